@@ -0,0 +1,244 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package completion generates shell completion scripts and a full help tree
+// from the go-flags definitions of the tools. Options list their enumerated
+// values with choice tags, or with a values tag when the tool checks the
+// value itself and go-flags should not.
+package completion
+
+import "os"
+import "fmt"
+import "sort"
+import "strings"
+import "reflect"
+
+import "github.com/jessevdk/go-flags"
+
+var Shells = []string{"bash", "zsh", "fish"}
+
+type Option struct {
+	Short       string
+	Long        string
+	Description string
+	Argument    bool     // Takes a value
+	Values      []string // Enumerated values, files are completed when empty
+}
+
+type Command struct {
+	Name        string
+	Description string
+	Inherited   []Option // Options of the parent commands, they apply here too
+	Options     []Option
+	Commands    []*Command
+}
+
+// All returns the options accepted by the command, own and inherited.
+func (self *Command) All() []Option {
+	return append(append([]Option{}, self.Inherited...), self.Options...)
+}
+
+// Find returns the subcommand at the path, nil if there is none.
+func (self *Command) Find(path ...string) *Command {
+	c := self
+	for _, name := range path {
+		var next *Command
+		for _, sub := range c.Commands {
+			if sub.Name == name {
+				next = sub
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		c = next
+	}
+	return c
+}
+
+// Renamed returns a copy of the command under another name.
+func (self *Command) Renamed(name string) *Command {
+	c := *self
+	c.Name = name
+	return &c
+}
+
+func option(o *flags.Option) Option {
+	opt := Option{Long: o.LongName, Description: o.Description}
+	if o.ShortName != 0 {
+		opt.Short = string(o.ShortName)
+	}
+
+	switch o.Field().Type.Kind() {
+	case reflect.Bool, reflect.Func:
+	case reflect.Slice:
+		opt.Argument = o.Field().Type.Elem().Kind() != reflect.Bool
+	default:
+		opt.Argument = true
+	}
+
+	opt.Values = append(opt.Values, o.Choices...)
+	if values := o.Field().Tag.Get("values"); len(values) > 0 {
+		opt.Values = append(opt.Values, strings.Split(values, ",")...)
+	}
+	return opt
+}
+
+func groupOptions(g *flags.Group) []Option {
+	opts := make([]Option, 0)
+	if g.Hidden {
+		return opts
+	}
+	for _, o := range g.Options() {
+		if !o.Hidden {
+			opts = append(opts, option(o))
+		}
+	}
+	for _, sub := range g.Groups() {
+		opts = append(opts, groupOptions(sub)...)
+	}
+	return opts
+}
+
+func command(c *flags.Command, name string, description string, inherited []Option) *Command {
+	cmd := &Command{Name: name, Description: description, Options: groupOptions(c.Group)}
+
+	// An option of the command hides a parent option of the same name
+	own := make(map[string]bool)
+	for _, o := range cmd.Options {
+		own["-"+o.Short] = len(o.Short) > 0
+		own["--"+o.Long] = len(o.Long) > 0
+	}
+	for _, o := range inherited {
+		if !own["-"+o.Short] && !own["--"+o.Long] {
+			cmd.Inherited = append(cmd.Inherited, o)
+		}
+	}
+
+	subs := c.Commands()
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Name < subs[j].Name })
+	for _, sub := range subs {
+		if !sub.Hidden {
+			cmd.Commands = append(cmd.Commands, command(sub, sub.Name, sub.ShortDescription, cmd.All()))
+		}
+	}
+	return cmd
+}
+
+// FromParser returns the command tree of a parser.
+func FromParser(parser *flags.Parser) *Command {
+	return command(parser.Command, parser.Name, parser.ShortDescription, nil)
+}
+
+// Script returns the completion script of the tree for the shell.
+func Script(shell string, root *Command) (string, error) {
+	switch shell {
+	case "bash":
+		return Bash(root), nil
+	case "zsh":
+		return Zsh(root), nil
+	case "fish":
+		return Fish(root), nil
+	}
+	return "", fmt.Errorf("no completion for shell %s, supported shells are %s", shell, strings.Join(Shells, ", "))
+}
+
+// HelpAll returns the help of every command and option of the tree.
+func HelpAll(root *Command) string {
+	var sb strings.Builder
+	helpAll(&sb, root, "")
+	return sb.String()
+}
+
+func helpAll(sb *strings.Builder, c *Command, path string) {
+	path = strings.TrimSpace(path + " " + c.Name)
+	if len(c.Description) > 0 {
+		fmt.Fprintf(sb, "%s - %s\n", path, c.Description)
+	} else {
+		fmt.Fprintf(sb, "%s\n", path)
+	}
+	for _, o := range c.Options {
+		synopsis := optionSynopsis(o)
+		if len(synopsis) > 32 {
+			fmt.Fprintf(sb, "    %s\n    %-32s %s\n", synopsis, "", o.Description)
+		} else {
+			fmt.Fprintf(sb, "    %-32s %s\n", synopsis, o.Description)
+		}
+	}
+	sb.WriteString("\n")
+	for _, sub := range c.Commands {
+		helpAll(sb, sub, path)
+	}
+}
+
+func optionSynopsis(o Option) string {
+	s := "--" + o.Long
+	if len(o.Long) == 0 {
+		s = "-" + o.Short
+	} else if len(o.Short) > 0 {
+		s = "-" + o.Short + ", " + s
+	}
+	if len(o.Values) > 0 {
+		s += "=" + strings.Join(o.Values, "|")
+	} else if o.Argument {
+		s += "=VALUE"
+	}
+	return s
+}
+
+// walk calls f for every command of the tree with the path of subcommand
+// names leading to it, "" for the root.
+func walk(c *Command, path string, f func(path string, c *Command)) {
+	f(path, c)
+	for _, sub := range c.Commands {
+		walk(sub, strings.TrimSpace(path+" "+sub.Name), f)
+	}
+}
+
+func (self Option) flags() []string {
+	names := make([]string, 0, 2)
+	if len(self.Short) > 0 {
+		names = append(names, "-"+self.Short)
+	}
+	if len(self.Long) > 0 {
+		names = append(names, "--"+self.Long)
+	}
+	return names
+}
+
+// quote single quotes a word for bash and zsh.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// identifier makes a shell function name out of the program name.
+func identifier(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Options is the completion subcommand of a tool.
+type Options struct {
+	Args struct {
+		Shell string `positional-arg-name:"shell" description:"bash, zsh or fish"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Main prints the completion script of the tree for the chosen shell.
+func Main(opts *Options, root *Command) {
+	if strings.Contains(root.Name, " ") {
+		// A subcommand of a combined binary, the script has to cover all of it
+		fmt.Printf("ERROR completion is generated for a whole binary, run %s completion\n", strings.Fields(root.Name)[0])
+		os.Exit(2)
+	}
+	script, err := Script(opts.Args.Shell, root)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	fmt.Print(script)
+}
@@ -0,0 +1,275 @@
+// Author  Raido Pahtma
+// License MIT
+
+package completion
+
+import "fmt"
+import "strings"
+
+// The scripts are static, generated from the tree. All of them work out the
+// path of subcommands from the words typed so far and then offer the
+// subcommands, options or option values of that path.
+
+func caseLines(sb *strings.Builder, root *Command, pattern func(string) string, body func(path string, c *Command) string) {
+	walk(root, "", func(path string, c *Command) {
+		if b := body(path, c); len(b) > 0 {
+			fmt.Fprintf(sb, "\t\t%s) %s ;;\n", pattern(path), b)
+		}
+	})
+}
+
+func commandNames(c *Command) []string {
+	names := make([]string, 0, len(c.Commands))
+	for _, sub := range c.Commands {
+		names = append(names, sub.Name)
+	}
+	return names
+}
+
+func Bash(root *Command) string {
+	var sb strings.Builder
+	fn := "_" + identifier(root.Name)
+	path := func(p string) string { return quote(p) }
+
+	fmt.Fprintf(&sb, "# bash completion for %s, generated by %s completion bash\n", root.Name, root.Name)
+
+	fmt.Fprintf(&sb, "%s_commands() {\n\tcase \"$1\" in\n", fn)
+	caseLines(&sb, root, path, func(p string, c *Command) string {
+		if len(c.Commands) == 0 {
+			return ""
+		}
+		return "echo " + quote(strings.Join(commandNames(c), " "))
+	})
+	sb.WriteString("\tesac\n}\n\n")
+
+	fmt.Fprintf(&sb, "%s_options() {\n\tcase \"$1\" in\n", fn)
+	caseLines(&sb, root, path, func(p string, c *Command) string {
+		names := make([]string, 0)
+		for _, o := range c.All() {
+			names = append(names, o.flags()...)
+		}
+		return "echo " + quote(strings.Join(names, " "))
+	})
+	sb.WriteString("\tesac\n}\n\n")
+
+	// Options taking a value, by path and option, the enumerated values or
+	// "-" for files
+	fmt.Fprintf(&sb, "%s_values() {\n\tcase \"$1\" in\n", fn)
+	walk(root, "", func(p string, c *Command) {
+		for _, o := range c.All() {
+			if !o.Argument && len(o.Values) == 0 {
+				continue
+			}
+			values := "-"
+			if len(o.Values) > 0 {
+				values = strings.Join(o.Values, " ")
+			}
+			for _, f := range o.flags() {
+				fmt.Fprintf(&sb, "\t\t%s) echo %s ;;\n", quote(p+"|"+f), quote(values))
+			}
+		}
+	})
+	sb.WriteString("\t\t*) return 1 ;;\n\tesac\n}\n\n")
+
+	fmt.Fprintf(&sb, `%s() {
+	local cur prev path w i values
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	path=""
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		w="${COMP_WORDS[i]}"
+		case " $(%s_commands "$path") " in
+			*" $w "*) path="${path:+$path }$w" ;;
+		esac
+	done
+
+	if values=$(%s_values "$path|$prev"); then
+		if [ "$values" = "-" ]; then
+			COMPREPLY=($(compgen -f -- "$cur"))
+		else
+			COMPREPLY=($(compgen -W "$values" -- "$cur"))
+		fi
+		return
+	fi
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "$(%s_options "$path")" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "$(%s_commands "$path")" -- "$cur"))
+	if [ ${#COMPREPLY[@]} -eq 0 ]; then
+		COMPREPLY=($(compgen -f -- "$cur"))
+	fi
+}
+complete -o filenames -F %s %s
+`, fn, fn, fn, fn, fn, fn, root.Name)
+	return sb.String()
+}
+
+// zshDescribed formats name:description pairs for _describe.
+func zshDescribed(name string, description string) string {
+	return quote(strings.Replace(name, ":", `\:`, -1) + ":" + description)
+}
+
+func Zsh(root *Command) string {
+	var sb strings.Builder
+	fn := "_" + identifier(root.Name)
+	path := func(p string) string { return quote(p) }
+
+	fmt.Fprintf(&sb, "#compdef %s\n# zsh completion for %s, generated by %s completion zsh\n\n", root.Name, root.Name, root.Name)
+
+	fmt.Fprintf(&sb, "%s_commands() {\n\treply=()\n\tcase \"$1\" in\n", fn)
+	caseLines(&sb, root, path, func(p string, c *Command) string {
+		if len(c.Commands) == 0 {
+			return ""
+		}
+		items := make([]string, 0, len(c.Commands))
+		for _, sub := range c.Commands {
+			items = append(items, zshDescribed(sub.Name, sub.Description))
+		}
+		return "reply=(" + strings.Join(items, " ") + ")"
+	})
+	sb.WriteString("\tesac\n}\n\n")
+
+	fmt.Fprintf(&sb, "%s_options() {\n\treply=()\n\tcase \"$1\" in\n", fn)
+	caseLines(&sb, root, path, func(p string, c *Command) string {
+		items := make([]string, 0)
+		for _, o := range c.All() {
+			for _, f := range o.flags() {
+				items = append(items, zshDescribed(f, o.Description))
+			}
+		}
+		return "reply=(" + strings.Join(items, " ") + ")"
+	})
+	sb.WriteString("\tesac\n}\n\n")
+
+	fmt.Fprintf(&sb, "%s_values() {\n\treply=()\n\tcase \"$1\" in\n", fn)
+	walk(root, "", func(p string, c *Command) {
+		for _, o := range c.All() {
+			if !o.Argument && len(o.Values) == 0 {
+				continue
+			}
+			values := []string{"-"}
+			if len(o.Values) > 0 {
+				values = make([]string, 0, len(o.Values))
+				for _, v := range o.Values {
+					values = append(values, quote(v))
+				}
+			}
+			for _, f := range o.flags() {
+				fmt.Fprintf(&sb, "\t\t%s) reply=(%s) ;;\n", quote(p+"|"+f), strings.Join(values, " "))
+			}
+		}
+	})
+	sb.WriteString("\t\t*) return 1 ;;\n\tesac\n}\n\n")
+
+	fmt.Fprintf(&sb, `%s() {
+	local cur=${words[CURRENT]} prev=${words[CURRENT-1]} p="" w i
+	local -a reply names
+	for ((i = 2; i < CURRENT; i++)); do
+		w=${words[i]}
+		%s_commands "$p"
+		names=(${reply%%%%:*})
+		if (( ${names[(Ie)$w]} )); then
+			p="${p:+$p }$w"
+		fi
+	done
+
+	if %s_values "$p|$prev"; then
+		if [[ $reply[1] == - ]]; then
+			_files
+		else
+			compadd -a reply
+		fi
+		return
+	fi
+	if [[ $cur == -* ]]; then
+		%s_options "$p"
+		_describe -t options option reply
+		return
+	fi
+	%s_commands "$p"
+	if (( ${#reply} )); then
+		_describe -t commands command reply
+	else
+		_files
+	fi
+}
+
+# Autoloaded from fpath the file is the completion function, sourced it
+# registers it
+if [[ $funcstack[1] == %s ]]; then
+	%s "$@"
+else
+	compdef %s %s
+fi
+`, fn, fn, fn, fn, fn, fn, fn, fn, root.Name)
+	return sb.String()
+}
+
+// fishQuote single quotes a word for fish.
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+func Fish(root *Command) string {
+	var sb strings.Builder
+	fn := "__" + identifier(root.Name)
+	name := root.Name
+
+	fmt.Fprintf(&sb, "# fish completion for %s, generated by %s completion fish\n\n", name, name)
+
+	fmt.Fprintf(&sb, "function %s_commands\n\tswitch \"$argv[1]\"\n", fn)
+	walk(root, "", func(p string, c *Command) {
+		if len(c.Commands) == 0 {
+			return
+		}
+		names := make([]string, 0, len(c.Commands))
+		for _, n := range commandNames(c) {
+			names = append(names, fishQuote(n))
+		}
+		fmt.Fprintf(&sb, "\t\tcase %s\n\t\t\tprintf '%%s\\n' %s\n", fishQuote(p), strings.Join(names, " "))
+	})
+	sb.WriteString("\tend\nend\n\n")
+
+	fmt.Fprintf(&sb, `function %s_path
+	set -l p ""
+	for w in (commandline -opc)[2..-1]
+		if contains -- $w (%s_commands "$p")
+			set p (string trim -- "$p $w")
+		end
+	end
+	echo $p
+end
+
+function %s_at
+	set -l p (%s_path)
+	test "$p" = "$argv[1]"
+end
+
+complete -c %s -f
+`, fn, fn, fn, fn, name)
+
+	walk(root, "", func(p string, c *Command) {
+		cond := "-n " + fishQuote(fn+"_at "+fishQuote(p))
+		for _, sub := range c.Commands {
+			fmt.Fprintf(&sb, "complete -c %s %s -a %s -d %s\n", name, cond, fishQuote(sub.Name), fishQuote(sub.Description))
+		}
+		for _, o := range c.All() {
+			line := fmt.Sprintf("complete -c %s %s", name, cond)
+			if len(o.Short) > 0 {
+				line += " -s " + o.Short
+			}
+			if len(o.Long) > 0 {
+				line += " -l " + o.Long
+			}
+			if len(o.Values) > 0 {
+				line += " -x -a " + fishQuote(strings.Join(o.Values, " "))
+			} else if o.Argument {
+				line += " -r -F"
+			}
+			fmt.Fprintf(&sb, "%s -d %s\n", line, fishQuote(o.Description))
+		}
+	})
+	return sb.String()
+}
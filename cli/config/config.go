@@ -0,0 +1,209 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package config fills the options of the tools from station config files
+// and the environment. A config file is TOML or YAML, by its extension, with
+// the long names of the options of the tool as keys:
+//
+//	euifile = "/mnt/pools/eui.txt"
+//	manufacturer = "0ad7b4a6-2a40-4a38-8f4c-2e8d1b8a9f11"
+//	region = ["eu", "uk"]
+//
+// The file is given with --config or <TOOL>_CONFIG, without either the first
+// <tool>.toml, <tool>.yaml or <tool>.yml in the search path is read. Every
+// option can also come from the environment as <TOOL>_<NAME>, e.g.
+// USERSIGGEN_SIGDIR_KEY. A flag wins over the environment, the environment
+// over the file and the file over the default.
+package config
+
+import "os"
+import "fmt"
+import "sort"
+import "strings"
+import "reflect"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/BurntSushi/toml"
+import "github.com/jessevdk/go-flags"
+import "gopkg.in/yaml.v2"
+
+const (
+	SOURCE_DEFAULT = "default"
+	SOURCE_FILE    = "file"
+	SOURCE_ENV     = "env"
+	SOURCE_FLAG    = "flag"
+)
+
+type Value struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+	Origin string      `json:"origin,omitempty"` // File or environment variable the value came from
+}
+
+// EnvPrefix returns the prefix of the environment variables of a tool.
+func EnvPrefix(tool string) string {
+	return strings.ToUpper(strings.Replace(tool, "-", "_", -1)) + "_"
+}
+
+func envName(tool string, option *flags.Option) string {
+	return EnvPrefix(tool) + strings.ToUpper(strings.Replace(option.LongName, "-", "_", -1))
+}
+
+// SearchPath returns the directories searched for the config of a tool when
+// none is given: the working directory, the user config directory and the
+// system one.
+func SearchPath() []string {
+	dirs := []string{"."}
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "thinnect"))
+	}
+	return append(dirs, "/etc/thinnect")
+}
+
+// Find returns the config file of a tool from the environment or the search
+// path, "" when there is none.
+func Find(tool string) string {
+	if file, ok := os.LookupEnv(EnvPrefix(tool) + "CONFIG"); ok {
+		return file
+	}
+	for _, dir := range SearchPath() {
+		for _, ext := range []string{".toml", ".yaml", ".yml"} {
+			file := filepath.Join(dir, tool+ext)
+			if info, err := os.Stat(file); err == nil && !info.IsDir() {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// Options returns the options that can be configured, the ones that are
+// actions, like --help, are left out.
+func Options(parser *flags.Parser) []*flags.Option {
+	options := make([]*flags.Option, 0)
+	var walk func(g *flags.Group)
+	walk = func(g *flags.Group) {
+		for _, o := range g.Options() {
+			if len(o.LongName) == 0 || o.Field().Type.Kind() == reflect.Func || o.LongName == "config" || o.LongName == "help" {
+				continue
+			}
+			options = append(options, o)
+		}
+		for _, sub := range g.Groups() {
+			walk(sub)
+		}
+	}
+	walk(parser.Command.Group)
+	return options
+}
+
+// Read returns the values of a config file by option name.
+func Read(file string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+	default:
+		if _, err := toml.DecodeFile(file, &values); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	return values, nil
+}
+
+// setValue sets an option from a config value, arrays set repeatable options
+// once for every element.
+func setValue(option *flags.Option, value interface{}) error {
+	values := []interface{}{value}
+	if list, ok := value.([]interface{}); ok {
+		if option.Field().Type.Kind() != reflect.Slice {
+			return fmt.Errorf("%s takes a single value", option.LongName)
+		}
+		values = list
+	}
+	for _, v := range values {
+		var s string
+		switch t := v.(type) {
+		case string:
+			s = t
+		case int, int64, float64, bool:
+			s = fmt.Sprint(t)
+		default:
+			return fmt.Errorf("%s can not be a %T", option.LongName, v)
+		}
+		if err := option.Set(&s); err != nil {
+			return fmt.Errorf("%s: %s", option.LongName, err)
+		}
+	}
+	return nil
+}
+
+// Apply fills the options that were not given as flags from the environment
+// and the config file, and reports where every value came from.
+func Apply(parser *flags.Parser, tool string, file string) ([]Value, error) {
+	values := make(map[string]interface{})
+	if len(file) > 0 {
+		var err error
+		if values, err = Read(file); err != nil {
+			return nil, err
+		}
+	}
+
+	known := make(map[string]bool)
+	provenance := make([]Value, 0)
+	for _, o := range Options(parser) {
+		known[o.LongName] = true
+		cv := Value{Name: o.LongName, Source: SOURCE_DEFAULT}
+		env := envName(tool, o)
+		if o.IsSet() && !o.IsSetDefault() {
+			cv.Source = SOURCE_FLAG
+		} else if v, ok := os.LookupEnv(env); ok {
+			cv.Source, cv.Origin = SOURCE_ENV, env
+			if err := o.Set(&v); err != nil {
+				return nil, fmt.Errorf("%s: %s", env, err)
+			}
+		} else if v, ok := values[o.LongName]; ok {
+			cv.Source, cv.Origin = SOURCE_FILE, file
+			if err := setValue(o, v); err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		}
+		cv.Value = optionValue(o)
+		if !o.Hidden || cv.Source != SOURCE_DEFAULT {
+			provenance = append(provenance, cv)
+		}
+	}
+
+	unknown := make([]string, 0)
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s: unknown options %s", file, strings.Join(unknown, ", "))
+	}
+	return provenance, nil
+}
+
+func optionValue(option *flags.Option) interface{} {
+	v := option.Value()
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	if m, ok := v.(flags.Marshaler); ok {
+		if s, err := m.MarshalFlag(); err == nil {
+			return s
+		}
+	}
+	return v
+}
@@ -0,0 +1,354 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package euigen generates the EUI-64 and 128-bit identifier pools. It is the
+// euigen command, the thinnect-id binary runs it as its eui subcommand.
+package euigen
+
+import "os"
+import "fmt"
+import "strings"
+import "bufio"
+import "time"
+import "net"
+import "encoding/json"
+
+import "github.com/jessevdk/go-flags"
+
+import "go.thinnect.net/euisig/eui"
+import "github.com/thinnect/euisiggen/cli/completion"
+import "github.com/thinnect/euisiggen/cli/config"
+
+type Eui64 = eui.Eui64
+
+func generate(first Eui64, last Eui64, euifile string, lstfile string) error {
+	euiout, err := os.OpenFile(euifile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer euiout.Close()
+
+	lstout, err := os.OpenFile(lstfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer lstout.Close()
+
+	euiwriter := bufio.NewWriter(euiout)
+	defer euiwriter.Flush()
+
+	lstwriter := bufio.NewWriter(lstout)
+	defer lstwriter.Flush()
+
+	ts := time.Now().UTC().Format(time.RFC3339)
+	_, err = euiwriter.WriteString(fmt.Sprintf("# EUI-64 range %s - %s, %s\n", first, last, ts))
+	if err != nil {
+		return err
+	}
+
+	_, err = lstwriter.WriteString(fmt.Sprintf("# EUI-64 range %s - %s, %s\n", first.Canonical(), last.Canonical(), ts))
+	if err != nil {
+		return err
+	}
+
+	for current := first; current <= last; current++ {
+		short := uint16(current)
+		if short == 0 || short == 0xFFFF {
+			_, err = euiwriter.WriteString(fmt.Sprintf("%s,RESERVED\n", current))
+		} else {
+			_, err = euiwriter.WriteString(fmt.Sprintf("%s,\n", current))
+		}
+		if err != nil {
+			return err
+		}
+		_, err = lstwriter.WriteString(fmt.Sprintf("%s\n", current.Canonical()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type IidOptions struct {
+	Prefix  string `long:"prefix"  required:"true" description:"IPv6 prefix the addresses are in, /64 or shorter."`
+	Euifile string `long:"euifile" description:"Take the EUI-64s from a pool file instead of the arguments."`
+	Used    bool   `long:"used"    description:"Only the EUI-64s in the pool file that have been assigned to a board."`
+	Format  string `long:"format"  default:"list" choice:"list" choice:"hosts" description:"EUI, IID and address list or a hosts file."`
+	Args    struct {
+		Euis []Eui64 `positional-arg-name:"EUI64"`
+	} `positional-args:"yes"`
+}
+
+// readEuiFile returns the EUI-64s listed in a pool file, optionally only the
+// ones that have been marked as used.
+func readEuiFile(infile string, used bool) ([]Eui64, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	euis := make([]Eui64, 0)
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) == 0 || strings.HasPrefix(t, "#") {
+			continue
+		}
+		splits := strings.Split(t, ",")
+		if used && (len(splits) < 2 || len(splits[1]) == 0 || splits[1] == "RESERVED") {
+			continue
+		}
+		v, err := eui.Parse(splits[0])
+		if err != nil {
+			return nil, err
+		}
+		euis = append(euis, v)
+	}
+	return euis, scanner.Err()
+}
+
+func iid(opts *IidOptions) error {
+	_, prefix, err := net.ParseCIDR(opts.Prefix)
+	if err != nil {
+		return err
+	}
+
+	euis := opts.Args.Euis
+	if len(opts.Euifile) > 0 {
+		euis, err = readEuiFile(opts.Euifile, opts.Used)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+
+	if opts.Format == "hosts" {
+		fmt.Fprintf(writer, "# %s, %s\n", prefix, time.Now().UTC().Format(time.RFC3339))
+	}
+	for _, e := range euis {
+		addr, err := e.Address(prefix)
+		if err != nil {
+			return err
+		}
+		if opts.Format == "hosts" {
+			fmt.Fprintf(writer, "%s\teui-%s\n", addr, strings.ToLower(e.String()))
+		} else {
+			fmt.Fprintf(writer, "%s,%s,%s\n", e, e.IidString(), addr)
+		}
+	}
+
+	return nil
+}
+
+type Id128Options struct {
+	First  eui.Id128 `long:"first" required:"true" description:"Start of the 128-bit identifier range, 32 hex digits."`
+	Count  uint32    `long:"count" required:"true" description:"Number of identifiers in the pool."`
+	Output string    `long:"out"   default:"id128.txt" description:"The 128-bit identifier pool file name."`
+}
+
+// generate128 writes a pool of 128-bit identifiers, in the same layout as the
+// EUI-64 pool so usersiggen allocates and marks them the same way.
+func generate128(first eui.Id128, count uint32, outfile string) error {
+	out, err := os.OpenFile(outfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer := bufio.NewWriter(out)
+	defer writer.Flush()
+
+	last := first
+	for i := uint32(1); i < count; i++ {
+		last = last.Next()
+	}
+
+	ts := time.Now().UTC().Format(time.RFC3339)
+	_, err = writer.WriteString(fmt.Sprintf("# ID-128 range %s - %s, %s\n", first, last, ts))
+	if err != nil {
+		return err
+	}
+
+	current := first
+	for i := uint32(0); i < count; i++ {
+		if _, err = writer.WriteString(fmt.Sprintf("%s,\n", current)); err != nil {
+			return err
+		}
+		current = current.Next()
+	}
+
+	return nil
+}
+
+// PoolResult is the --format json result of generating a pool, the text goes
+// to stderr then so stdout is only the result.
+type PoolResult struct {
+	Type      string       `json:"type"` // eui64 or id128
+	First     string       `json:"first"`
+	Last      string       `json:"last"`
+	Count     uint64       `json:"count"`
+	Generated string       `json:"generated"`
+	Files     []ResultFile `json:"files"`
+}
+
+type ResultFile struct {
+	Role string `json:"role"` // euifile, list or pool
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+func printResult(r PoolResult, out *os.File, files map[string]string) error {
+	for _, role := range []string{"euifile", "list", "pool"} {
+		path, ok := files[role]
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		r.Files = append(r.Files, ResultFile{Role: role, Path: path, Size: info.Size()})
+	}
+	j, _ := json.MarshalIndent(r, "", "\t")
+	fmt.Fprintln(out, string(j))
+	return nil
+}
+
+// Options are the options of the EUI-64 pool generator, the subcommands have
+// their own.
+type Options struct {
+	First      *Eui64 `long:"first" description:"Start of the EUI64 range."`
+	Last       *Eui64 `long:"last" description:"End of the EUI64 range."`
+	EuiOutput  string `long:"euiout" default:"eui.txt" description:"The EUI-64 output file name."`
+	ListOutput string `long:"listout" default:"list.txt" description:"The EUI-64 canonical form output file name."`
+	Format     string `long:"format" default:"text" choice:"text" choice:"json" description:"Result as text or as a JSON object on stdout with the range and the files written."`
+	Config     string `long:"config" description:"Config file, TOML or YAML with option names as keys. EUIGEN_CONFIG or euigen.toml/.yaml in ., the user config dir or /etc/thinnect if not given."`
+	HelpAll    func() `long:"help-all" description:"Show the help of every command and option."`
+}
+
+// commands holds the options of the subcommands.
+type commands struct {
+	iid        IidOptions
+	id128      Id128Options
+	completion completion.Options
+}
+
+// newParser builds the command line of the generator around the options.
+func newParser(name string, opts *Options, cmds *commands) *flags.Parser {
+	parser := flags.NewParser(opts, flags.Default)
+	parser.Name = name
+	parser.SubcommandsOptional = true
+	parser.AddCommand("iid", "IPv6 interface identifiers and addresses",
+		"Convert EUI-64s to modified EUI-64 interface identifiers and IPv6 addresses in the given prefix.",
+		&cmds.iid)
+	parser.AddCommand("id128", "Generate a 128-bit identifier pool",
+		"Write a pool of consecutive 128-bit identifiers for the extended identity record.",
+		&cmds.id128)
+	parser.AddCommand("completion", "Shell completion script",
+		"Print a bash, zsh or fish completion script for the command.",
+		&cmds.completion)
+
+	opts.HelpAll = func() {
+		fmt.Print(completion.HelpAll(completion.FromParser(parser)))
+		os.Exit(0)
+	}
+	return parser
+}
+
+// Parser returns the command line of the generator, for generating the
+// completion and help of commands that include it.
+func Parser(name string) *flags.Parser {
+	return newParser(name, new(Options), new(commands))
+}
+
+// Main runs the command with the arguments, name is what usage messages call
+// the command.
+func Main(name string, args []string) {
+	var opts Options
+	var cmds commands
+	parser := newParser(name, &opts, &cmds)
+
+	_, err := parser.ParseArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	if len(opts.Config) == 0 {
+		opts.Config = config.Find("euigen")
+	}
+	if _, err := config.Apply(parser, "euigen", opts.Config); err != nil {
+		fmt.Println("Error reading config:", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if opts.Format == "json" {
+		os.Stdout = os.Stderr
+	}
+	generated := time.Now().UTC().Format(time.RFC3339)
+
+	if parser.Active != nil {
+		switch parser.Active.Name {
+		case "iid":
+			if err := iid(&cmds.iid); err != nil {
+				fmt.Println("Error converting EUIs:", err)
+				os.Exit(1)
+			}
+		case "id128":
+			if cmds.id128.Count == 0 {
+				fmt.Println("Error: the pool needs at least one identifier")
+				os.Exit(1)
+			}
+			fmt.Printf("ID-128 output: %s\n", cmds.id128.Output)
+			if err := generate128(cmds.id128.First, cmds.id128.Count, cmds.id128.Output); err != nil {
+				fmt.Println("Error generating ID-128 pool:", err)
+				os.Exit(1)
+			}
+			if opts.Format == "json" {
+				last := cmds.id128.First
+				for i := uint32(1); i < cmds.id128.Count; i++ {
+					last = last.Next()
+				}
+				r := PoolResult{Type: "id128", First: cmds.id128.First.String(), Last: last.String(), Count: uint64(cmds.id128.Count), Generated: generated}
+				if err := printResult(r, out, map[string]string{"pool": cmds.id128.Output}); err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+			}
+		case "completion":
+			completion.Main(&cmds.completion, completion.FromParser(parser))
+		}
+		os.Exit(0)
+	}
+
+	if opts.First == nil || opts.Last == nil {
+		fmt.Println("Error: the --first and --last of the EUI64 range are required")
+		os.Exit(1)
+	}
+
+	fmt.Printf("EUI-64 output: %s\n", opts.EuiOutput)
+	fmt.Printf("EUI-64 canonical list output: %s\n", opts.ListOutput)
+	fmt.Printf("EUI range %s - %s\n", opts.First.Canonical(), opts.Last.Canonical())
+
+	err = generate(*opts.First, *opts.Last, opts.EuiOutput, opts.ListOutput)
+	if err != nil {
+		fmt.Println("Error generating EUI files:", err)
+		os.Exit(1)
+	}
+
+	if opts.Format == "json" {
+		r := PoolResult{Type: "eui64", First: opts.First.String(), Last: opts.Last.String(), Generated: generated}
+		if *opts.Last >= *opts.First {
+			r.Count = uint64(*opts.Last-*opts.First) + 1
+		}
+		if err := printResult(r, out, map[string]string{"euifile": opts.EuiOutput, "list": opts.ListOutput}); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+
+}
@@ -0,0 +1,693 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Provisioning service of usersiggen, for manufacturing systems that drive
+// the signature and license generation over gRPC instead of running the
+// binaries. The Go code in this directory is generated from this file:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	       --go-grpc_out=. --go-grpc_opt=paths=source_relative provisioning.proto
+//
+// A signature area is passed around as the raw bytes that are written to the
+// device, every RPC that changes it returns the whole area.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: provisioning.proto
+
+package provisioning
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AppendComponentRequest_Type int32
+
+const (
+	AppendComponentRequest_COMPONENT AppendComponentRequest_Type = 0
+	AppendComponentRequest_PLATFORM  AppendComponentRequest_Type = 1
+)
+
+// Enum value maps for AppendComponentRequest_Type.
+var (
+	AppendComponentRequest_Type_name = map[int32]string{
+		0: "COMPONENT",
+		1: "PLATFORM",
+	}
+	AppendComponentRequest_Type_value = map[string]int32{
+		"COMPONENT": 0,
+		"PLATFORM":  1,
+	}
+)
+
+func (x AppendComponentRequest_Type) Enum() *AppendComponentRequest_Type {
+	p := new(AppendComponentRequest_Type)
+	*p = x
+	return p
+}
+
+func (x AppendComponentRequest_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AppendComponentRequest_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_provisioning_proto_enumTypes[0].Descriptor()
+}
+
+func (AppendComponentRequest_Type) Type() protoreflect.EnumType {
+	return &file_provisioning_proto_enumTypes[0]
+}
+
+func (x AppendComponentRequest_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AppendComponentRequest_Type.Descriptor instead.
+func (AppendComponentRequest_Type) EnumDescriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{3, 0}
+}
+
+// Component is a board, platform or component record, the fields of a
+// provisioning profile component.
+type Component struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version       string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"` // major.minor.assembly
+	Uuid          string                 `protobuf:"bytes,3,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Manufacturer  string                 `protobuf:"bytes,4,opt,name=manufacturer,proto3" json:"manufacturer,omitempty"`               // Manufacturer UUID
+	Serial        string                 `protobuf:"bytes,5,opt,name=serial,proto3" json:"serial,omitempty"`                           // Serial number, up to 16 characters
+	SerialUuid    string                 `protobuf:"bytes,6,opt,name=serial_uuid,json=serialUuid,proto3" json:"serial_uuid,omitempty"` // Or the serial number as a UUID
+	Position      uint32                 `protobuf:"varint,7,opt,name=position,proto3" json:"position,omitempty"`
+	Slot          string                 `protobuf:"bytes,8,opt,name=slot,proto3" json:"slot,omitempty"`
+	Part          string                 `protobuf:"bytes,9,opt,name=part,proto3" json:"part,omitempty"` // Part number, the rest comes from the PLM
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Component) Reset() {
+	*x = Component{}
+	mi := &file_provisioning_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Component) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Component) ProtoMessage() {}
+
+func (x *Component) ProtoReflect() protoreflect.Message {
+	mi := &file_provisioning_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Component.ProtoReflect.Descriptor instead.
+func (*Component) Descriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Component) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Component) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Component) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *Component) GetManufacturer() string {
+	if x != nil {
+		return x.Manufacturer
+	}
+	return ""
+}
+
+func (x *Component) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *Component) GetSerialUuid() string {
+	if x != nil {
+		return x.SerialUuid
+	}
+	return ""
+}
+
+func (x *Component) GetPosition() uint32 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+func (x *Component) GetSlot() string {
+	if x != nil {
+		return x.Slot
+	}
+	return ""
+}
+
+func (x *Component) GetPart() string {
+	if x != nil {
+		return x.Part
+	}
+	return ""
+}
+
+type SignatureArea struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Eui64         string                 `protobuf:"bytes,1,opt,name=eui64,proto3" json:"eui64,omitempty"` // 16 hex digits
+	Sigdata       []byte                 `protobuf:"bytes,2,opt,name=sigdata,proto3" json:"sigdata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SignatureArea) Reset() {
+	*x = SignatureArea{}
+	mi := &file_provisioning_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SignatureArea) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignatureArea) ProtoMessage() {}
+
+func (x *SignatureArea) ProtoReflect() protoreflect.Message {
+	mi := &file_provisioning_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignatureArea.ProtoReflect.Descriptor instead.
+func (*SignatureArea) Descriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SignatureArea) GetEui64() string {
+	if x != nil {
+		return x.Eui64
+	}
+	return ""
+}
+
+func (x *SignatureArea) GetSigdata() []byte {
+	if x != nil {
+		return x.Sigdata
+	}
+	return nil
+}
+
+type GenerateBoardSignatureRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Eui64         string                 `protobuf:"bytes,1,opt,name=eui64,proto3" json:"eui64,omitempty"` // Empty to allocate the next free EUI of the pool
+	Tag           string                 `protobuf:"bytes,2,opt,name=tag,proto3" json:"tag,omitempty"`     // Pool partition the EUI is allocated from
+	WorkOrder     string                 `protobuf:"bytes,3,opt,name=work_order,json=workOrder,proto3" json:"work_order,omitempty"`
+	Board         *Component             `protobuf:"bytes,4,opt,name=board,proto3" json:"board,omitempty"`
+	Platform      *Component             `protobuf:"bytes,5,opt,name=platform,proto3" json:"platform,omitempty"`
+	Components    []*Component           `protobuf:"bytes,6,rep,name=components,proto3" json:"components,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerateBoardSignatureRequest) Reset() {
+	*x = GenerateBoardSignatureRequest{}
+	mi := &file_provisioning_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateBoardSignatureRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateBoardSignatureRequest) ProtoMessage() {}
+
+func (x *GenerateBoardSignatureRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_provisioning_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerateBoardSignatureRequest.ProtoReflect.Descriptor instead.
+func (*GenerateBoardSignatureRequest) Descriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GenerateBoardSignatureRequest) GetEui64() string {
+	if x != nil {
+		return x.Eui64
+	}
+	return ""
+}
+
+func (x *GenerateBoardSignatureRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *GenerateBoardSignatureRequest) GetWorkOrder() string {
+	if x != nil {
+		return x.WorkOrder
+	}
+	return ""
+}
+
+func (x *GenerateBoardSignatureRequest) GetBoard() *Component {
+	if x != nil {
+		return x.Board
+	}
+	return nil
+}
+
+func (x *GenerateBoardSignatureRequest) GetPlatform() *Component {
+	if x != nil {
+		return x.Platform
+	}
+	return nil
+}
+
+func (x *GenerateBoardSignatureRequest) GetComponents() []*Component {
+	if x != nil {
+		return x.Components
+	}
+	return nil
+}
+
+type AppendComponentRequest struct {
+	state         protoimpl.MessageState      `protogen:"open.v1"`
+	Sigdata       []byte                      `protobuf:"bytes,1,opt,name=sigdata,proto3" json:"sigdata,omitempty"`
+	Type          AppendComponentRequest_Type `protobuf:"varint,2,opt,name=type,proto3,enum=thinnect.euisiggen.provisioning.v1.AppendComponentRequest_Type" json:"type,omitempty"`
+	Component     *Component                  `protobuf:"bytes,3,opt,name=component,proto3" json:"component,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendComponentRequest) Reset() {
+	*x = AppendComponentRequest{}
+	mi := &file_provisioning_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendComponentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendComponentRequest) ProtoMessage() {}
+
+func (x *AppendComponentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_provisioning_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendComponentRequest.ProtoReflect.Descriptor instead.
+func (*AppendComponentRequest) Descriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AppendComponentRequest) GetSigdata() []byte {
+	if x != nil {
+		return x.Sigdata
+	}
+	return nil
+}
+
+func (x *AppendComponentRequest) GetType() AppendComponentRequest_Type {
+	if x != nil {
+		return x.Type
+	}
+	return AppendComponentRequest_COMPONENT
+}
+
+func (x *AppendComponentRequest) GetComponent() *Component {
+	if x != nil {
+		return x.Component
+	}
+	return nil
+}
+
+type IssueLicenseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sigdata       []byte                 `protobuf:"bytes,1,opt,name=sigdata,proto3" json:"sigdata,omitempty"`
+	License       []byte                 `protobuf:"bytes,2,opt,name=license,proto3" json:"license,omitempty"`                      // Contents of the license file
+	ValidFrom     string                 `protobuf:"bytes,3,opt,name=valid_from,json=validFrom,proto3" json:"valid_from,omitempty"` // YYYY-MM-DD or RFC 3339, both empty for a license without a window
+	ValidUntil    string                 `protobuf:"bytes,4,opt,name=valid_until,json=validUntil,proto3" json:"valid_until,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IssueLicenseRequest) Reset() {
+	*x = IssueLicenseRequest{}
+	mi := &file_provisioning_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IssueLicenseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IssueLicenseRequest) ProtoMessage() {}
+
+func (x *IssueLicenseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_provisioning_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IssueLicenseRequest.ProtoReflect.Descriptor instead.
+func (*IssueLicenseRequest) Descriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *IssueLicenseRequest) GetSigdata() []byte {
+	if x != nil {
+		return x.Sigdata
+	}
+	return nil
+}
+
+func (x *IssueLicenseRequest) GetLicense() []byte {
+	if x != nil {
+		return x.License
+	}
+	return nil
+}
+
+func (x *IssueLicenseRequest) GetValidFrom() string {
+	if x != nil {
+		return x.ValidFrom
+	}
+	return ""
+}
+
+func (x *IssueLicenseRequest) GetValidUntil() string {
+	if x != nil {
+		return x.ValidUntil
+	}
+	return ""
+}
+
+type ReadSignatureRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sigdata       []byte                 `protobuf:"bytes,1,opt,name=sigdata,proto3" json:"sigdata,omitempty"`
+	Eui64         string                 `protobuf:"bytes,2,opt,name=eui64,proto3" json:"eui64,omitempty"`   // Or the area of an EUI in the sigdir of the server
+	Schema        string                 `protobuf:"bytes,3,opt,name=schema,proto3" json:"schema,omitempty"` // v1 or v2, v2 when empty
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadSignatureRequest) Reset() {
+	*x = ReadSignatureRequest{}
+	mi := &file_provisioning_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadSignatureRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadSignatureRequest) ProtoMessage() {}
+
+func (x *ReadSignatureRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_provisioning_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadSignatureRequest.ProtoReflect.Descriptor instead.
+func (*ReadSignatureRequest) Descriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ReadSignatureRequest) GetSigdata() []byte {
+	if x != nil {
+		return x.Sigdata
+	}
+	return nil
+}
+
+func (x *ReadSignatureRequest) GetEui64() string {
+	if x != nil {
+		return x.Eui64
+	}
+	return ""
+}
+
+func (x *ReadSignatureRequest) GetSchema() string {
+	if x != nil {
+		return x.Schema
+	}
+	return ""
+}
+
+type ReadSignatureResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Eui64         string                 `protobuf:"bytes,1,opt,name=eui64,proto3" json:"eui64,omitempty"`
+	Json          string                 `protobuf:"bytes,2,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadSignatureResponse) Reset() {
+	*x = ReadSignatureResponse{}
+	mi := &file_provisioning_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadSignatureResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadSignatureResponse) ProtoMessage() {}
+
+func (x *ReadSignatureResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_provisioning_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadSignatureResponse.ProtoReflect.Descriptor instead.
+func (*ReadSignatureResponse) Descriptor() ([]byte, []int) {
+	return file_provisioning_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ReadSignatureResponse) GetEui64() string {
+	if x != nil {
+		return x.Eui64
+	}
+	return ""
+}
+
+func (x *ReadSignatureResponse) GetJson() string {
+	if x != nil {
+		return x.Json
+	}
+	return ""
+}
+
+var File_provisioning_proto protoreflect.FileDescriptor
+
+const file_provisioning_proto_rawDesc = "" +
+	"\n" +
+	"\x12provisioning.proto\x12\"thinnect.euisiggen.provisioning.v1\"\xee\x01\n" +
+	"\tComponent\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12\x12\n" +
+	"\x04uuid\x18\x03 \x01(\tR\x04uuid\x12\"\n" +
+	"\fmanufacturer\x18\x04 \x01(\tR\fmanufacturer\x12\x16\n" +
+	"\x06serial\x18\x05 \x01(\tR\x06serial\x12\x1f\n" +
+	"\vserial_uuid\x18\x06 \x01(\tR\n" +
+	"serialUuid\x12\x1a\n" +
+	"\bposition\x18\a \x01(\rR\bposition\x12\x12\n" +
+	"\x04slot\x18\b \x01(\tR\x04slot\x12\x12\n" +
+	"\x04part\x18\t \x01(\tR\x04part\"?\n" +
+	"\rSignatureArea\x12\x14\n" +
+	"\x05eui64\x18\x01 \x01(\tR\x05eui64\x12\x18\n" +
+	"\asigdata\x18\x02 \x01(\fR\asigdata\"\xc5\x02\n" +
+	"\x1dGenerateBoardSignatureRequest\x12\x14\n" +
+	"\x05eui64\x18\x01 \x01(\tR\x05eui64\x12\x10\n" +
+	"\x03tag\x18\x02 \x01(\tR\x03tag\x12\x1d\n" +
+	"\n" +
+	"work_order\x18\x03 \x01(\tR\tworkOrder\x12C\n" +
+	"\x05board\x18\x04 \x01(\v2-.thinnect.euisiggen.provisioning.v1.ComponentR\x05board\x12I\n" +
+	"\bplatform\x18\x05 \x01(\v2-.thinnect.euisiggen.provisioning.v1.ComponentR\bplatform\x12M\n" +
+	"\n" +
+	"components\x18\x06 \x03(\v2-.thinnect.euisiggen.provisioning.v1.ComponentR\n" +
+	"components\"\xf9\x01\n" +
+	"\x16AppendComponentRequest\x12\x18\n" +
+	"\asigdata\x18\x01 \x01(\fR\asigdata\x12S\n" +
+	"\x04type\x18\x02 \x01(\x0e2?.thinnect.euisiggen.provisioning.v1.AppendComponentRequest.TypeR\x04type\x12K\n" +
+	"\tcomponent\x18\x03 \x01(\v2-.thinnect.euisiggen.provisioning.v1.ComponentR\tcomponent\"#\n" +
+	"\x04Type\x12\r\n" +
+	"\tCOMPONENT\x10\x00\x12\f\n" +
+	"\bPLATFORM\x10\x01\"\x89\x01\n" +
+	"\x13IssueLicenseRequest\x12\x18\n" +
+	"\asigdata\x18\x01 \x01(\fR\asigdata\x12\x18\n" +
+	"\alicense\x18\x02 \x01(\fR\alicense\x12\x1d\n" +
+	"\n" +
+	"valid_from\x18\x03 \x01(\tR\tvalidFrom\x12\x1f\n" +
+	"\vvalid_until\x18\x04 \x01(\tR\n" +
+	"validUntil\"^\n" +
+	"\x14ReadSignatureRequest\x12\x18\n" +
+	"\asigdata\x18\x01 \x01(\fR\asigdata\x12\x14\n" +
+	"\x05eui64\x18\x02 \x01(\tR\x05eui64\x12\x16\n" +
+	"\x06schema\x18\x03 \x01(\tR\x06schema\"A\n" +
+	"\x15ReadSignatureResponse\x12\x14\n" +
+	"\x05eui64\x18\x01 \x01(\tR\x05eui64\x12\x12\n" +
+	"\x04json\x18\x02 \x01(\tR\x04json2\xa5\x04\n" +
+	"\fProvisioning\x12\x8e\x01\n" +
+	"\x16GenerateBoardSignature\x12A.thinnect.euisiggen.provisioning.v1.GenerateBoardSignatureRequest\x1a1.thinnect.euisiggen.provisioning.v1.SignatureArea\x12\x80\x01\n" +
+	"\x0fAppendComponent\x12:.thinnect.euisiggen.provisioning.v1.AppendComponentRequest\x1a1.thinnect.euisiggen.provisioning.v1.SignatureArea\x12z\n" +
+	"\fIssueLicense\x127.thinnect.euisiggen.provisioning.v1.IssueLicenseRequest\x1a1.thinnect.euisiggen.provisioning.v1.SignatureArea\x12\x84\x01\n" +
+	"\rReadSignature\x128.thinnect.euisiggen.provisioning.v1.ReadSignatureRequest\x1a9.thinnect.euisiggen.provisioning.v1.ReadSignatureResponseBUZ.github.com/thinnect/euisiggen/cli/provisioning\xaa\x02\"Thinnect.Euisiggen.Provisioning.V1b\x06proto3"
+
+var (
+	file_provisioning_proto_rawDescOnce sync.Once
+	file_provisioning_proto_rawDescData []byte
+)
+
+func file_provisioning_proto_rawDescGZIP() []byte {
+	file_provisioning_proto_rawDescOnce.Do(func() {
+		file_provisioning_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_provisioning_proto_rawDesc), len(file_provisioning_proto_rawDesc)))
+	})
+	return file_provisioning_proto_rawDescData
+}
+
+var file_provisioning_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_provisioning_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_provisioning_proto_goTypes = []any{
+	(AppendComponentRequest_Type)(0),      // 0: thinnect.euisiggen.provisioning.v1.AppendComponentRequest.Type
+	(*Component)(nil),                     // 1: thinnect.euisiggen.provisioning.v1.Component
+	(*SignatureArea)(nil),                 // 2: thinnect.euisiggen.provisioning.v1.SignatureArea
+	(*GenerateBoardSignatureRequest)(nil), // 3: thinnect.euisiggen.provisioning.v1.GenerateBoardSignatureRequest
+	(*AppendComponentRequest)(nil),        // 4: thinnect.euisiggen.provisioning.v1.AppendComponentRequest
+	(*IssueLicenseRequest)(nil),           // 5: thinnect.euisiggen.provisioning.v1.IssueLicenseRequest
+	(*ReadSignatureRequest)(nil),          // 6: thinnect.euisiggen.provisioning.v1.ReadSignatureRequest
+	(*ReadSignatureResponse)(nil),         // 7: thinnect.euisiggen.provisioning.v1.ReadSignatureResponse
+}
+var file_provisioning_proto_depIdxs = []int32{
+	1, // 0: thinnect.euisiggen.provisioning.v1.GenerateBoardSignatureRequest.board:type_name -> thinnect.euisiggen.provisioning.v1.Component
+	1, // 1: thinnect.euisiggen.provisioning.v1.GenerateBoardSignatureRequest.platform:type_name -> thinnect.euisiggen.provisioning.v1.Component
+	1, // 2: thinnect.euisiggen.provisioning.v1.GenerateBoardSignatureRequest.components:type_name -> thinnect.euisiggen.provisioning.v1.Component
+	0, // 3: thinnect.euisiggen.provisioning.v1.AppendComponentRequest.type:type_name -> thinnect.euisiggen.provisioning.v1.AppendComponentRequest.Type
+	1, // 4: thinnect.euisiggen.provisioning.v1.AppendComponentRequest.component:type_name -> thinnect.euisiggen.provisioning.v1.Component
+	3, // 5: thinnect.euisiggen.provisioning.v1.Provisioning.GenerateBoardSignature:input_type -> thinnect.euisiggen.provisioning.v1.GenerateBoardSignatureRequest
+	4, // 6: thinnect.euisiggen.provisioning.v1.Provisioning.AppendComponent:input_type -> thinnect.euisiggen.provisioning.v1.AppendComponentRequest
+	5, // 7: thinnect.euisiggen.provisioning.v1.Provisioning.IssueLicense:input_type -> thinnect.euisiggen.provisioning.v1.IssueLicenseRequest
+	6, // 8: thinnect.euisiggen.provisioning.v1.Provisioning.ReadSignature:input_type -> thinnect.euisiggen.provisioning.v1.ReadSignatureRequest
+	2, // 9: thinnect.euisiggen.provisioning.v1.Provisioning.GenerateBoardSignature:output_type -> thinnect.euisiggen.provisioning.v1.SignatureArea
+	2, // 10: thinnect.euisiggen.provisioning.v1.Provisioning.AppendComponent:output_type -> thinnect.euisiggen.provisioning.v1.SignatureArea
+	2, // 11: thinnect.euisiggen.provisioning.v1.Provisioning.IssueLicense:output_type -> thinnect.euisiggen.provisioning.v1.SignatureArea
+	7, // 12: thinnect.euisiggen.provisioning.v1.Provisioning.ReadSignature:output_type -> thinnect.euisiggen.provisioning.v1.ReadSignatureResponse
+	9, // [9:13] is the sub-list for method output_type
+	5, // [5:9] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_provisioning_proto_init() }
+func file_provisioning_proto_init() {
+	if File_provisioning_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_provisioning_proto_rawDesc), len(file_provisioning_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_provisioning_proto_goTypes,
+		DependencyIndexes: file_provisioning_proto_depIdxs,
+		EnumInfos:         file_provisioning_proto_enumTypes,
+		MessageInfos:      file_provisioning_proto_msgTypes,
+	}.Build()
+	File_provisioning_proto = out.File
+	file_provisioning_proto_goTypes = nil
+	file_provisioning_proto_depIdxs = nil
+}
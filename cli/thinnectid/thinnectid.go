@@ -0,0 +1,197 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package thinnectid is euigen and usersiggen in one binary, the tools are
+// its subcommands and the ones of usersiggen that serve or verify are
+// promoted to the top level. The thinnect-id binary runs it, the euigen and
+// usersiggen binaries are still built for existing scripts, they run the
+// same code. Every task has one name.
+package thinnectid
+
+import "os"
+import "fmt"
+import "strings"
+
+import "github.com/thinnect/euisiggen/cli/completion"
+import "github.com/thinnect/euisiggen/cli/euigen"
+import "github.com/thinnect/euisiggen/cli/usersiggen"
+
+type command struct {
+	name        string
+	description string
+	run         func(name string, args []string)
+	// Arguments given to the tool before the ones of the user, by the first
+	// user argument, "" when there is no matching one
+	prefix map[string][]string
+}
+
+var g_commands = []command{
+	{"eui", "Generate EUI-64 and 128-bit identifier pools (euigen)", euigen.Main, nil},
+	{"sig", "Generate, read and check signatures (usersiggen)", usersiggen.Main, nil},
+	{"license", "Append a license to a signature file, 'license preflight' checks eligibility", usersiggen.Main,
+		map[string][]string{
+			"":          {"--type", "license"},
+			"preflight": {"license-preflight"},
+		}},
+	{"serve", "Serve the GraphQL endpoint, 'serve fixture', 'serve fleet', 'serve eui' and 'serve grpc' the fixture, heartbeats, EUI allocation and provisioning", usersiggen.Main,
+		map[string][]string{
+			"":        {"graphql"},
+			"fixture": {"fixture"},
+			"fleet":   {"fleet", "serve"},
+			"eui":     {"euiserver"},
+			"grpc":    {"grpc"},
+		}},
+	{"verify", "Verify the signatures of a file, 'verify bom' against a bill of materials, 'verify delivery' and 'verify patch' manifests and patches", usersiggen.Main,
+		map[string][]string{
+			"":         {"--verify"},
+			"bom":      {"check"},
+			"delivery": {"delivery", "verify"},
+			"patch":    {"patch", "verify"},
+		}},
+	{"config", "Validate a station config and show the effective options", usersiggen.Main,
+		map[string][]string{
+			"": {"config"},
+		}},
+	{"loadtest", "Load test a fixture driver, GraphQL or fleet endpoint", usersiggen.Main,
+		map[string][]string{
+			"": {"loadtest"},
+		}},
+	{"read", "Dump the signatures of a file as JSON", usersiggen.Main,
+		map[string][]string{
+			"": {"--read-sig"},
+		}},
+}
+
+// toolArgs puts the prefix of the command in front of the user arguments,
+// a prefix picked by a keyword replaces the keyword.
+func toolArgs(c command, args []string) []string {
+	if c.prefix == nil {
+		return args
+	}
+	if len(args) > 0 {
+		if prefix, ok := c.prefix[args[0]]; ok && len(args[0]) > 0 {
+			return append(append([]string{}, prefix...), args[1:]...)
+		}
+	}
+	return append(append([]string{}, c.prefix[""]...), args...)
+}
+
+func usage(name string) {
+	fmt.Printf("Usage:\n  %s <command> [OPTIONS]\n\nCommands:\n", name)
+	for _, c := range g_commands {
+		fmt.Printf("  %-13s %s\n", c.name, c.description)
+	}
+	fmt.Printf("  %-13s %s\n", "completion", "Print a bash, zsh or fish completion script")
+	fmt.Printf("\nRun %s <command> --help for the options of a command, --help-all for all of them.\n", name)
+}
+
+// without returns a copy of the command without the named subcommands.
+func without(c *completion.Command, names ...string) *completion.Command {
+	cp := *c
+	cp.Commands = nil
+	for _, sub := range c.Commands {
+		keep := true
+		for _, name := range names {
+			keep = keep && sub.Name != name
+		}
+		if keep {
+			cp.Commands = append(cp.Commands, sub)
+		}
+	}
+	return &cp
+}
+
+// commandTree describes the commands as they are promoted from the tools,
+// for the completion and --help-all.
+func commandTree(name string) *completion.Command {
+	eui := without(completion.FromParser(euigen.Parser("eui")), "completion")
+	sig := without(completion.FromParser(usersiggen.Parser("sig")), "completion")
+
+	license := without(sig.Renamed("license"))
+	license.Commands = []*completion.Command{sig.Find("license-preflight").Renamed("preflight")}
+
+	serve := sig.Find("graphql").Renamed("serve")
+	serve.Commands = []*completion.Command{
+		sig.Find("fixture"),
+		sig.Find("fleet", "serve").Renamed("fleet"),
+		sig.Find("euiserver").Renamed("eui"),
+		sig.Find("grpc"),
+	}
+
+	verify := without(sig.Renamed("verify"))
+	verify.Commands = []*completion.Command{
+		sig.Find("check").Renamed("bom"),
+		sig.Find("delivery", "verify").Renamed("delivery"),
+		sig.Find("patch", "verify").Renamed("patch"),
+	}
+
+	root := &completion.Command{Name: name, Options: []completion.Option{
+		{Short: "h", Long: "help", Description: "Show this help message"},
+		{Short: "V", Long: "version", Description: "Show generator version."},
+		{Long: "help-all", Description: "Show the help of every command and option."},
+	}}
+	config := sig.Find("config")
+	loadtest := sig.Find("loadtest")
+	read := &completion.Command{Name: "read"}
+
+	for _, c := range []*completion.Command{eui, sig, license, serve, verify, config, loadtest, read} {
+		for _, cmd := range g_commands {
+			if cmd.name == c.Name {
+				c.Description = cmd.description
+			}
+		}
+		root.Commands = append(root.Commands, c)
+	}
+	root.Commands = append(root.Commands, &completion.Command{Name: "completion",
+		Description: "Print a bash, zsh or fish completion script"})
+	return root
+}
+
+// Main runs the binary called name with the arguments that follow the
+// program name.
+func Main(name string, args []string) {
+	if len(args) < 1 {
+		usage(name)
+		os.Exit(2)
+	}
+
+	cmd := args[0]
+	switch cmd {
+	case "-h", "--help", "help":
+		usage(name)
+		os.Exit(0)
+	case "-V", "--version", "version":
+		usersiggen.Main(name, []string{"-V"})
+		os.Exit(0)
+	case "--help-all":
+		fmt.Print(completion.HelpAll(commandTree(name)))
+		os.Exit(0)
+	case "completion":
+		var opts completion.Options
+		if len(args) != 2 {
+			fmt.Printf("ERROR usage: %s completion bash|zsh|fish\n", name)
+			os.Exit(2)
+		}
+		opts.Args.Shell = args[1]
+		completion.Main(&opts, commandTree(name))
+		os.Exit(0)
+	}
+
+	for _, c := range g_commands {
+		if c.name == cmd {
+			c.run(name+" "+cmd, toolArgs(c, args[1:]))
+			os.Exit(0)
+		}
+	}
+
+	fmt.Printf("ERROR unknown command %s, known commands are: %s\n", cmd, commandNames())
+	os.Exit(2)
+}
+
+func commandNames() string {
+	names := make([]string, 0, len(g_commands))
+	for _, c := range g_commands {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,62 @@
+// Author  Raido Pahtma
+// License MIT
+
+package thinnectid
+
+import "reflect"
+import "testing"
+
+// Every task has one name, no two commands run the same tool with the same
+// arguments.
+func TestOneNamePerTask(t *testing.T) {
+	for i, a := range g_commands {
+		for _, b := range g_commands[i+1:] {
+			if a.name == b.name {
+				t.Errorf("command %s is there twice", a.name)
+			}
+			same := reflect.ValueOf(a.run).Pointer() == reflect.ValueOf(b.run).Pointer()
+			if same && reflect.DeepEqual(toolArgs(a, nil), toolArgs(b, nil)) {
+				t.Errorf("%s and %s are the same command", a.name, b.name)
+			}
+		}
+	}
+	tree := commandTree("thinnect-id")
+	if len(tree.Commands) != len(g_commands)+1 {
+		t.Errorf("the completion has %d commands, want %d and completion", len(tree.Commands), len(g_commands))
+	}
+}
+
+// The arguments the tools get for the commands of thinnect-id.
+func TestToolArgs(t *testing.T) {
+	find := func(name string) command {
+		for _, c := range g_commands {
+			if c.name == name {
+				return c
+			}
+		}
+		t.Fatalf("no command %s", name)
+		return command{}
+	}
+	cases := []struct {
+		command string
+		args    []string
+		want    []string
+	}{
+		{"verify", []string{"sigdata.bin"}, []string{"--verify", "sigdata.bin"}},
+		{"verify", []string{"sigdata.bin", "--verify-key", "factory.pub"}, []string{"--verify", "sigdata.bin", "--verify-key", "factory.pub"}},
+		{"verify", []string{"bom", "--against", "platform.yaml", "sigdata.bin"}, []string{"check", "--against", "platform.yaml", "sigdata.bin"}},
+		{"verify", []string{"delivery", "batch"}, []string{"delivery", "verify", "batch"}},
+		{"verify", []string{"patch", "p.bin"}, []string{"patch", "verify", "p.bin"}},
+		{"license", []string{"--sigfile", "s.bin"}, []string{"--type", "license", "--sigfile", "s.bin"}},
+		{"license", []string{"preflight"}, []string{"license-preflight"}},
+		{"serve", nil, []string{"graphql"}},
+		{"serve", []string{"fleet", "--listen", ":80"}, []string{"fleet", "serve", "--listen", ":80"}},
+		{"read", []string{"sigdata.bin"}, []string{"--read-sig", "sigdata.bin"}},
+		{"sig", []string{"--type", "board"}, []string{"--type", "board"}},
+	}
+	for _, c := range cases {
+		if got := toolArgs(find(c.command), c.args); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %v: %v, want %v", c.command, c.args, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bufio"
+import "errors"
+import "strings"
+import "path/filepath"
+
+import "go.thinnect.net/euisig/signature"
+
+// Allocation of EUIs from the pool and marking them with the board they went
+// to. The pool is locked from the allocation until the marks are written, a
+// registry claims the EUIs instead, marks of an unreachable pool are queued.
+
+func getEui(infile string, tag string, work_order string) (eui64, error) {
+	return getEuiExcept(infile, tag, work_order, nil)
+}
+
+// getEuiExcept returns the first unmarked EUI that is not in the claimed set,
+// for handing out several EUIs before any of them get marked. Only EUIs of the
+// pool partition of the tag are considered and the claimed EUIs count against
+// the quota of the tag. EUIs of customer blocks only go to the work orders of
+// the customer.
+func getEuiExcept(infile string, tag string, work_order string, claimed map[eui64]bool) (eui64, error) {
+	euis, err := getEuisExcept(infile, tag, work_order, claimed, 1)
+	if err != nil {
+		return 0, err
+	}
+	return euis[0], nil
+}
+
+// getEuisExcept returns the first count unmarked EUIs that are not in the
+// claimed set, reading the pool once.
+func getEuisExcept(infile string, tag string, work_order string, claimed map[eui64]bool, count int) ([]eui64, error) {
+	// Held until the EUIs are marked, runs sharing the pool take turns
+	if g_eui_registry == nil {
+		if _, err := lockPool(infile); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := recoverClaims(infile, false); err != nil {
+		return nil, err
+	}
+
+	// Marks queued while the pool was unreachable go in first, what can not
+	// be written yet is held back from allocation
+	replayMarks(infile)
+	pending, err := pendingMarks(infile)
+	if err != nil {
+		return nil, err
+	}
+	queued := 0
+	for e, t := range pending {
+		if t == tag && !claimed[e] {
+			queued++
+		}
+	}
+
+	entries, err := readPool(infile)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := readPoolTags(infile)
+	if err != nil {
+		return nil, err
+	}
+	if err := tags.check(tag, infile); err != nil {
+		return nil, err
+	}
+	customers, err := readPoolCustomers(infile)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := tags[tag]; ok && t.Quota > 0 && tagUsed(entries, tag)+len(claimed)+queued+count > t.Quota {
+		if count > 1 {
+			return nil, errors.New(fmt.Sprintf("Quota of %d EUI64s for tag %s in %s has no room for %d more!", t.Quota, tag, infile, count))
+		}
+		return nil, errors.New(fmt.Sprintf("Quota of %d EUI64s for tag %s in %s is used up!", t.Quota, tag, infile))
+	}
+
+	// A registry has the EUIs claimed, the ones another run got first are
+	// passed over
+	var euis []eui64
+	taken := make(map[eui64]bool)
+	for {
+		euis = make([]eui64, 0, count)
+		for _, e := range entries {
+			_, held := pending[e.Eui]
+			if e.Status == POOL_FREE && !claimed[e.Eui] && !taken[e.Eui] && !held && tags.eligible(e.Eui, tag) && customers.eligible(e.Eui, work_order) {
+				if euis = append(euis, e.Eui); len(euis) == count {
+					break
+				}
+			}
+		}
+		if len(euis) < count {
+			break
+		}
+		if g_eui_registry == nil {
+			return euis, nil
+		}
+		lost, err := g_eui_registry.Claim(euis)
+		if err != nil {
+			return nil, err
+		}
+		if len(lost) == 0 {
+			return euis, nil
+		}
+		for _, e := range lost {
+			taken[e] = true
+		}
+	}
+
+	if count > 1 {
+		return nil, errors.New(fmt.Sprintf("Only %d of %d EUI64s free in %s!", len(euis), count, infile))
+	}
+	if customer := customers.ofWorkOrder(work_order); len(customer) > 0 {
+		return nil, errors.New(fmt.Sprintf("Could not find a suitable EUI64 of customer %s for work order %s in %s!", customer, work_order, infile))
+	}
+	if len(tag) > 0 {
+		return nil, errors.New(fmt.Sprintf("Could not find a suitable EUI64 for tag %s in %s!", tag, infile))
+	}
+	return nil, errors.New(fmt.Sprintf("Could not find a suitable EUI64 in %s!", infile))
+}
+
+// poolMark is what gets recorded in the pool about the board an EUI went to.
+func poolMark(csig ComponentSignature, tag string) string {
+	m := fmt.Sprintf("%s,%s,%d,%x,%x", csig.BoardName(), csig.BoardVersion(), csig.Unix_time, csig.Component_uuid, csig.Manufacturer_uuid)
+	if len(tag) > 0 {
+		m = fmt.Sprintf("%s,%s", m, tag)
+	}
+	return m
+}
+
+// markEui records the board in the pool. When the pool can not be reached the
+// mark is queued in the mark journal instead, the signature has already been
+// written at this point.
+func markEui(infile string, esig EUISignature, csig ComponentSignature, tag string) error {
+	return markEuis(infile, map[eui64]string{esig.Eui64: poolMark(csig, tag)}, esig.Unix_time)
+}
+
+// markIdentity records the board of an identity context in the pool.
+func markIdentity(infile string, tag string, id *signature.Identity) error {
+	esig, bsig := id.EUI(), id.Board()
+	if esig == nil || bsig == nil {
+		return fmt.Errorf("%016X has no EUI64 or board signature to mark", id.Eui64)
+	}
+	return markEui(infile, *esig, *bsig, tag)
+}
+
+// markEuis records the marks of several EUIs in one rewrite of the pool, or
+// queues all of them when the pool can not be reached.
+func markEuis(infile string, marks map[eui64]string, ts int64) error {
+	defer unlockPool(infile)
+	err := writeMarks(infile, marks, ts)
+	if err != nil && poolUnavailable(err) {
+		for eui, mark := range marks {
+			if err := queueMark(infile, eui, mark, err); err != nil {
+				return err
+			}
+		}
+		err = nil
+	}
+	if err == nil {
+		// The marks commit the claims of the run
+		if err := dropClaims(infile, marks); err != nil {
+			fmt.Printf("WARNING: failed to drop the claims from %s: %s\n", claimJournal(infile), err)
+		}
+	}
+	return err
+}
+
+func writeMark(infile string, eui eui64, mark string, ts int64) error {
+	return writeMarks(infile, map[eui64]string{eui: mark}, ts)
+}
+
+func writeMarks(infile string, marks map[eui64]string, ts int64) error {
+	infile, err := filepath.Abs(infile)
+	if err != nil {
+		return err
+	}
+
+	bounds, err := readPoolBounds(infile)
+	if err != nil {
+		return err
+	}
+	for eui := range marks {
+		if err := checkPoolBounds(infile, bounds, eui); err != nil {
+			return err
+		}
+	}
+	if g_eui_registry != nil {
+		return g_eui_registry.Mark(marks, ts)
+	}
+	if taken, err := lockPool(infile); err != nil {
+		return err
+	} else if taken {
+		defer unlockPool(infile)
+	}
+
+	in, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	outfile := filepath.Join(filepath.Dir(infile), fmt.Sprintf("eui_temp_%d.txt", ts))
+	out, err := os.OpenFile(outfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	writer := bufio.NewWriter(out)
+
+	//fmt.Printf("infile %s\n", infile)
+	//fmt.Printf("outfile %s\n", outfile)
+
+	marked := make(map[eui64]bool)
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(t, "#") == false {
+			splits := strings.Split(t, ",")
+
+			if len(marked) < len(marks) && (len(splits) == 1 || (len(splits) == 2 && len(splits[1]) == 0)) {
+				val, err := parseEui(splits[0])
+				if err != nil {
+					return err
+				}
+
+				if mark, ok := marks[val]; ok && !marked[val] {
+					writer.WriteString(fmt.Sprintf("%s,%s", splits[0], mark))
+					marked[val] = true
+				} else {
+					// Skipped over, claimed by someone else or in another partition
+					writer.WriteString(t)
+				}
+			} else {
+				writer.WriteString(t)
+			}
+		} else {
+			writer.WriteString(scanner.Text())
+		}
+		writer.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		// A partial read must not replace the pool
+		os.Remove(outfile)
+		return err
+	}
+	for eui := range marks {
+		if !marked[eui] {
+			os.Remove(outfile)
+			return fmt.Errorf("%016X is not free in %s", eui, infile)
+		}
+	}
+
+	in.Close()
+
+	if err := writer.Flush(); err != nil {
+		os.Remove(outfile)
+		return err
+	}
+	out.Close()
+
+	err = rotateBackupOnce(infile)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(outfile, infile)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "strings"
+import "encoding/binary"
+import "encoding/json"
+import "bytes"
+import "reflect"
+
+import "go.thinnect.net/euisig/signature"
+
+type AnnotateOptions struct {
+	Args struct {
+		File string `positional-arg-name:"file.bin"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// signatureStruct returns a pointer to the fixed structure of a signature
+// type, nil for types without one.
+func signatureStruct(t uint8) interface{} {
+	switch t {
+	case SIGNATURE_TYPE_EUI64:
+		return new(EUISignature)
+	case SIGNATURE_TYPE_BOARD, SIGNATURE_TYPE_PLATFORM, SIGNATURE_TYPE_COMPONENT:
+		return new(ComponentSignature)
+	case SIGNATURE_TYPE_COMPLIANCE:
+		return new(ComplianceSignature)
+	case SIGNATURE_TYPE_EXTENDED_ID:
+		return new(ExtendedIdSignature)
+	case SIGNATURE_TYPE_DECOMMISSION:
+		return new(DecommissionSignature)
+	case SIGNATURE_TYPE_AREA_SIGNATURE:
+		return new(AreaSignature)
+	case SIGNATURE_TYPE_PIN:
+		return new(PinSignature)
+	case SIGNATURE_TYPE_LICENSE_V2:
+		// The window, the license file follows it
+		return new(struct {
+			BaseSignature
+			LicenseWindow
+		})
+	}
+	return nil
+}
+
+func hexBytes(b []byte, max int) string {
+	s := make([]string, 0, len(b))
+	for i, c := range b {
+		if i == max {
+			s = append(s, "..")
+			break
+		}
+		s = append(s, fmt.Sprintf("%02X", c))
+	}
+	return strings.Join(s, " ")
+}
+
+func fieldName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); len(tag) > 0 {
+		return strings.Split(tag, ",")[0]
+	}
+	return strings.ToLower(f.Name)
+}
+
+func annotateLine(offset int, raw []byte, name string, value string) {
+	fmt.Printf("%6d %4d  %-24s %-40s %s\n", offset, len(raw), name, value, hexBytes(raw, 12))
+}
+
+// annotateStruct prints one line per field of a decoded structure, v must be
+// the struct value decoded from data, which starts at origin in the file.
+func annotateStruct(v reflect.Value, data []byte, offset int, origin int) int {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+
+		if f.Anonymous && fv.Kind() == reflect.Struct {
+			offset = annotateStruct(fv, data, offset, origin)
+			continue
+		}
+		if fv.Kind() == reflect.Slice {
+			continue // Variable length part, handled by the caller
+		}
+
+		sz := binary.Size(fv.Interface())
+		if offset+sz > len(data) {
+			annotateLine(origin+offset, data[offset:], fieldName(f), "TRUNCATED")
+			return len(data)
+		}
+
+		value, err := json.Marshal(fv.Interface())
+		if err != nil {
+			value = []byte(fmt.Sprintf("%v", fv.Interface()))
+		}
+		annotateLine(origin+offset, data[offset:offset+sz], fieldName(f), string(value))
+		offset += sz
+	}
+	return offset
+}
+
+func annotateCrc(data []byte, start int, end int) {
+	name := "crc"
+	if check := signature.CheckName(data[start:]); check != signature.CHECK_XMODEM {
+		name = check
+	}
+	if end+signature.CheckSize(data[start:]) > len(data) {
+		annotateLine(end, data[end:], name, "TRUNCATED")
+		return
+	}
+	stored, computed := signature.RecordCheck(data[start:], end-start)
+	status := "OK"
+	if !bytes.Equal(stored, computed) {
+		status = fmt.Sprintf("BAD, computed 0x%X", computed)
+	}
+	annotateLine(end, stored, name, fmt.Sprintf("0x%X %s", stored, status))
+}
+
+// annotate walks the records of a signature area and prints an offset
+// annotated dump of every field.
+func annotate(data []byte) {
+	var base BaseSignature
+	basesz := binary.Size(base)
+
+	fmt.Printf("%6s %4s  %-24s %-40s %s\n", "OFFSET", "LEN", "FIELD", "VALUE", "BYTES")
+
+	offset := 0
+	for n := 0; offset+basesz <= len(data); n++ {
+		if signature.IsCborRecord(data[offset:]) {
+			size, err := signature.RecordLength(data[offset:])
+			if err != nil {
+				break
+			}
+			end := offset + size
+			name := "unknown"
+			if base, err := cborBase(data[offset:]); err == nil {
+				name = signature.TypeName(base.Signature_type)
+			}
+			fmt.Printf("# record %d: %s signature, CBOR, %d bytes at %d\n", n, name, size, offset)
+			annotateLine(offset, data[offset:offset+3], "cbor_tag", "55799, self-described CBOR")
+			if end-2 <= len(data) {
+				annotateLine(offset+3, data[offset+3:end-2], "cbor_map", "fields by name, see --read-sig")
+				annotateCrc(data, offset, end-2)
+			} else {
+				fmt.Printf("# record %d is truncated, %d bytes missing\n", n, end-len(data))
+			}
+			offset = end
+			continue
+		}
+		order := signature.RecordOrder(data[offset:])
+		w := signature.CheckSize(data[offset:])
+		binary.Read(bytes.NewReader(data[offset:offset+basesz]), order, &base)
+		if base.Signature_size < uint16(basesz)+2 || base.Signature_size > MAX_SIGNATURE_LENGTH {
+			break
+		}
+
+		end := offset + int(base.Signature_size)
+		fmt.Printf("# record %d: %s signature, %d bytes at %d\n", n, signature.TypeName(base.Signature_type), base.Signature_size, offset)
+
+		record := data[offset:]
+		if len(record) > int(base.Signature_size) {
+			record = record[:base.Signature_size]
+		}
+
+		if base.Sig_version_major&^signature.VERSION_FLAGS == signature.TLV_VERSION_MAJOR {
+			pos := offset + annotateStruct(reflect.ValueOf(&base).Elem(), record, 0, offset)
+			for pos+3 <= minInt(end-w, len(data)) {
+				tag := data[pos]
+				length := int(binary.BigEndian.Uint16(data[pos+1 : pos+3]))
+				annotateLine(pos, data[pos:pos+3], "tlv", fmt.Sprintf("tag %d %s, %d bytes", tag, signature.TlvTagName(tag), length))
+				if pos+3+length > len(data) {
+					break
+				}
+				annotateLine(pos+3, data[pos+3:pos+3+length], signature.TlvTagName(tag), fmt.Sprintf("%d bytes", length))
+				pos += 3 + length
+			}
+			if end-w <= len(data) {
+				annotateCrc(data, offset, end-w)
+			} else {
+				fmt.Printf("# record %d is truncated, %d bytes missing\n", n, end-len(data))
+			}
+			offset = end
+			continue
+		}
+
+		sig := signatureStruct(base.Signature_type)
+		pos := offset
+		if sig != nil && binary.Size(sig) <= len(record) {
+			binary.Read(bytes.NewReader(record), order, sig)
+			pos = offset + annotateStruct(reflect.ValueOf(sig).Elem(), record, 0, offset)
+		} else {
+			pos = offset + annotateStruct(reflect.ValueOf(&base).Elem(), record, 0, offset)
+		}
+
+		if c, ok := sig.(*ComponentSignature); ok && pos < end-w && pos < len(data) {
+			// The data, a component record in a named slot ends with the slot
+			payload := data[pos:minInt(pos+int(c.Data_length), minInt(end-w, len(data)))]
+			if len(payload) > 0 {
+				annotateLine(pos, payload, "data", fmt.Sprintf("%d bytes", len(payload)))
+			}
+			pos += len(payload)
+			if slot := data[pos:minInt(end-w, len(data))]; len(slot) == binary.Size(tslot(0)) && c.Signature_type == SIGNATURE_TYPE_COMPONENT {
+				value, _ := json.Marshal(tslot(order.Uint32(slot)))
+				annotateLine(pos, slot, "slot", string(value))
+				pos += len(slot)
+			}
+		}
+		if pos < end-w && pos < len(data) {
+			payload := data[pos:minInt(end-w, len(data))]
+			name := "data"
+			if base.Signature_type == SIGNATURE_TYPE_LICENSE || base.Signature_type == SIGNATURE_TYPE_LICENSE_V2 {
+				name = "lic_file"
+			}
+			annotateLine(pos, payload, name, fmt.Sprintf("%d bytes", len(payload)))
+		}
+
+		if end-w <= len(data) {
+			annotateCrc(data, offset, end-w)
+		} else {
+			fmt.Printf("# record %d is truncated, %d bytes missing\n", n, end-len(data))
+		}
+		offset = end
+	}
+
+	if offset < len(data) {
+		rest := data[offset:]
+		if len(trimErased(rest)) == 0 {
+			fmt.Printf("# %d bytes of 0xFF padding at %d\n", len(rest), offset)
+		} else {
+			fmt.Printf("# %d trailing bytes at %d: %s\n", len(rest), offset, hexBytes(rest, 16))
+		}
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "annotate",
+		short:   "Offset annotated hexdump of a signature file",
+		long:    "Label every field of every record with its offset, length, decoded value and raw bytes.",
+		options: func() interface{} { return new(AnnotateOptions) },
+		run:     func(c *commandRun) { annotateMain(c.options.(*AnnotateOptions)) },
+	})
+}
+
+func annotateMain(opts *AnnotateOptions) {
+	data, err := readSigdirFile(opts.Args.File)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	annotate(data)
+}
@@ -0,0 +1,44 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "bytes"
+import "strings"
+import "testing"
+
+func TestAnnotate(t *testing.T) {
+	area := testArea(t, 0x70B3D5D72F000001)
+	area = append(area, bytes.Repeat([]byte{0xFF}, 32)...)
+	out := captureStdout(t, func() { annotate(area) })
+	for _, line := range []string{
+		"# record 0: eui64 signature, 24 bytes at 0",
+		`14    8  eui64                    "70B3D5D72F000001"`,
+		"# record 1: board signature, 86 bytes at 24",
+		`54   16  component_name           "tsb2"`,
+		"# record 3: component signature, 86 bytes at 196",
+		"# 32 bytes of 0xFF padding at 282",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("no %q in\n%s", line, out)
+		}
+	}
+	if strings.Count(out, " OK ") != 4 {
+		t.Errorf("%d checks OK, want 4\n%s", strings.Count(out, " OK "), out)
+	}
+
+	// A changed byte is caught by the CRC of its record only
+	area[60] ^= 0x20
+	out = captureStdout(t, func() { annotate(area) })
+	if strings.Count(out, "BAD, computed") != 1 || strings.Count(out, " OK ") != 3 {
+		t.Errorf("changed board record:\n%s", out)
+	}
+}
+
+func TestAnnotateTrailing(t *testing.T) {
+	area := append(testArea(t, 0x70B3D5D72F000001)[:24], 0x01, 0x02, 0x03)
+	out := captureStdout(t, func() { annotate(area) })
+	if !strings.Contains(out, "# 3 trailing bytes at 24: 01 02 03") {
+		t.Errorf("trailing bytes:\n%s", out)
+	}
+}
@@ -0,0 +1,355 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "io"
+import "fmt"
+import "bytes"
+import "errors"
+import "strings"
+import "time"
+import "io/ioutil"
+import "archive/tar"
+import "encoding/hex"
+import "encoding/json"
+import "crypto/sha256"
+import "crypto/ed25519"
+import "path/filepath"
+
+import "go.thinnect.net/euisig/signature"
+
+// Long-term archive of a signature area, a .tnsig file. It is a plain POSIX
+// tar, no compression, that carries everything needed to make sense of the
+// area without this tool: the raw area, its decoding as JSON, the Kaitai
+// Struct description of the format, a plain text README and a manifest with
+// the generator version and the SHA-256 of every file. The manifest may be
+// signed with an Ed25519 key, the public key is in the manifest.
+
+const SCHEMA_ARCHIVE = "euisiggen/archive/v1"
+const ARCHIVE_MANIFEST = "MANIFEST.json"
+
+type ArchiveFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type ArchiveManifest struct {
+	Schema    string        `json:"schema"`
+	Generator string        `json:"generator"`
+	Created   time.Time     `json:"created"`
+	Eui64     string        `json:"eui64"`
+	Source    string        `json:"source"` // Name of the signature file that was archived
+	Files     []ArchiveFile `json:"files"`
+	KeyId     string        `json:"key_id,omitempty"`
+	PublicKey string        `json:"public_key,omitempty"` // Ed25519, hex
+	Signature string        `json:"signature,omitempty"`  // Over the manifest without the signature
+}
+
+type ArchivePackOptions struct {
+	Key  string `long:"key" description:"Ed25519 private key the manifest is signed with, PKCS#8 PEM."`
+	Out  string `long:"out" description:"Archive file, the signature file name with .tnsig if not given."`
+	Args struct {
+		File string `positional-arg-name:"sigdata"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type ArchiveUnpackOptions struct {
+	Dir  string `long:"dir" default:"." description:"Directory the files are written to."`
+	Args struct {
+		File string `positional-arg-name:"archive"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type ArchiveVerifyOptions struct {
+	Pubkey string `long:"pubkey" description:"Ed25519 public key the archive must be signed with, PEM."`
+	Args   struct {
+		File string `positional-arg-name:"archive"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type ArchiveOptions struct {
+	Pack   ArchivePackOptions   `command:"pack"   description:"Archive a signature file with its decoding and format description."`
+	Unpack ArchiveUnpackOptions `command:"unpack" description:"Verify an archive and write out its files."`
+	Verify ArchiveVerifyOptions `command:"verify" description:"Verify the hashes, the signature and the records of an archive."`
+}
+
+const archiveReadme = `Thinnect device signature area archive
+======================================
+
+This is a tar archive of the signature area of one device, written by
+usersiggen %s on %s.
+
+  sigdata.bin    The signature area as it was written to the device.
+  sigdata.json   The records of the area decoded, field by field.
+  format.ksy     Description of the binary format in the Kaitai Struct
+                 language (https://kaitai.io), sigdata.bin can be parsed
+                 with it without usersiggen.
+  MANIFEST.json  Size and SHA-256 of every other file, the generator
+                 version, and when signed an Ed25519 public key and the
+                 signature.
+
+Every record of the area is a header, a body and a check over both, the
+CRC-16/XMODEM with big endian integers unless the flags in the first byte
+of the record, sig_version_major, select another check or little endian.
+The area may end in 0xFF padding.
+
+The hashes can be checked with sha256sum. The signature is an Ed25519
+signature of MANIFEST.json serialized as compact JSON without the
+"signature" field, in the field order of the file.
+`
+
+// signedBytes is what the signature covers, the manifest without it.
+func (self *ArchiveManifest) signedBytes() []byte {
+	m := *self
+	m.Signature = ""
+	b, _ := json.Marshal(m)
+	return b
+}
+
+func archiveName(sigfile string) string {
+	return strings.TrimSuffix(filepath.Base(sigfile), filepath.Ext(sigfile)) + ".tnsig"
+}
+
+func archivePack(opts *ArchivePackOptions) error {
+	data, err := readSigdirFile(opts.Args.File)
+	if err != nil {
+		return err
+	}
+	sigs, err := readSigs(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", opts.Args.File, err)
+	}
+	eui, err := areaEui(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", opts.Args.File, err)
+	}
+	var key ed25519.PrivateKey
+	if len(opts.Key) > 0 {
+		if key, err = loadSigningKey(opts.Key, "archive"); err != nil {
+			return err
+		}
+	}
+
+	m := &ArchiveManifest{Schema: SCHEMA_ARCHIVE, Generator: generatorVersion(), Created: time.Now().UTC(),
+		Eui64: fmt.Sprintf("%016X", eui), Source: filepath.Base(opts.Args.File)}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"README.txt", []byte(fmt.Sprintf(archiveReadme, m.Generator, m.Created.Format(time.RFC3339)))},
+		{"sigdata.bin", data},
+		{"sigdata.json", []byte(sigsToJson(sigs, SCHEMA_READ_SIG_V2) + "\n")},
+		{"format.ksy", []byte(generateKsy())},
+	}
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		m.Files = append(m.Files, ArchiveFile{f.name, int64(len(f.data)), hex.EncodeToString(sum[:])})
+	}
+	if key != nil {
+		pub := key.Public().(ed25519.PublicKey)
+		id := signature.KeyIdOf(pub)
+		m.KeyId = hex.EncodeToString(id[:])
+		m.PublicKey = hex.EncodeToString(pub)
+		m.Signature = hex.EncodeToString(ed25519.Sign(key, m.signedBytes()))
+	}
+	mj, err := json.MarshalIndent(m, "", "	")
+	if err != nil {
+		return err
+	}
+
+	out := opts.Out
+	if len(out) == 0 {
+		out = archiveName(opts.Args.File)
+	}
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	entries := append([]struct {
+		name string
+		data []byte
+	}{{ARCHIVE_MANIFEST, mj}}, files...)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0444, Size: int64(len(e.data)), ModTime: m.Created, Format: tar.FormatUSTAR}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0440)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	signed := "unsigned"
+	if key != nil {
+		signed = "signed with " + strings.ToUpper(m.KeyId)
+	}
+	fmt.Printf("%016X archived to %s, %s\n", eui, out, signed)
+	return nil
+}
+
+// readArchive reads an archive and checks every file against its hash, the
+// signature when there is one and that the area decodes.
+func readArchive(file string) (*ArchiveManifest, map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var m *ArchiveManifest
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Name == ARCHIVE_MANIFEST {
+			m = new(ArchiveManifest)
+			if err := json.Unmarshal(data, m); err != nil {
+				return nil, nil, fmt.Errorf("corrupt manifest: %s", err)
+			}
+		}
+		contents[hdr.Name] = data
+	}
+	if m == nil {
+		return nil, nil, errors.New("no manifest, not a signature archive")
+	}
+	if m.Schema != SCHEMA_ARCHIVE {
+		return nil, nil, fmt.Errorf("unknown archive schema %q", m.Schema)
+	}
+
+	for _, af := range m.Files {
+		if strings.ContainsAny(af.Name, `/\`) || af.Name == ".." {
+			return nil, nil, fmt.Errorf("%s is not a plain file name", af.Name)
+		}
+		data, ok := contents[af.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is missing from the archive", af.Name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != af.Sha256 || int64(len(data)) != af.Size {
+			return nil, nil, fmt.Errorf("%s does not match its hash in the manifest", af.Name)
+		}
+	}
+	if len(m.Signature) > 0 {
+		pub, err := hex.DecodeString(m.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("manifest public key is not an Ed25519 key")
+		}
+		sig, err := hex.DecodeString(m.Signature)
+		if err != nil || !ed25519.Verify(pub, m.signedBytes(), sig) {
+			return nil, nil, errors.New("manifest signature is not valid")
+		}
+	}
+
+	data, ok := contents["sigdata.bin"]
+	if !ok {
+		return nil, nil, errors.New("sigdata.bin is missing from the archive")
+	}
+	if _, err := readSigs(data); err != nil {
+		return nil, nil, fmt.Errorf("sigdata.bin: %s", err)
+	}
+	return m, contents, nil
+}
+
+func archiveVerify(opts *ArchiveVerifyOptions) error {
+	m, _, err := readArchive(opts.Args.File)
+	if err != nil {
+		return err
+	}
+	if len(opts.Pubkey) > 0 {
+		pub, err := loadEd25519Public(opts.Pubkey)
+		if err != nil {
+			return err
+		}
+		if len(m.Signature) == 0 {
+			return errors.New("the archive is not signed")
+		}
+		if m.PublicKey != hex.EncodeToString(pub) {
+			return fmt.Errorf("the archive is signed with key %s, not with %s", strings.ToUpper(m.KeyId), opts.Pubkey)
+		}
+	}
+	signed := "unsigned"
+	if len(m.Signature) > 0 {
+		signed = "signed with " + strings.ToUpper(m.KeyId)
+	}
+	fmt.Printf("Archive OK, %s by usersiggen %s at %s, %s\n", m.Eui64, m.Generator, m.Created.Format(time.RFC3339), signed)
+	return nil
+}
+
+func archiveUnpack(opts *ArchiveUnpackOptions) error {
+	m, contents, err := readArchive(opts.Args.File)
+	if err != nil {
+		return err
+	}
+	files := append([]ArchiveFile{{Name: ARCHIVE_MANIFEST}}, m.Files...)
+	for _, af := range files {
+		path := filepath.Join(opts.Dir, af.Name)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(contents[af.Name])
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", path)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "archive",
+		short:   "Long-term archive of a signature file",
+		long:    "Pack a signature file into a .tnsig archive with its decoding, format description and hashes, or verify and unpack one.",
+		options: func() interface{} { return new(ArchiveOptions) },
+		run:     func(c *commandRun) { archiveMain(c.command, c.options.(*ArchiveOptions)) },
+	})
+}
+
+func archiveMain(command string, opts *ArchiveOptions) {
+	switch command {
+	case "pack":
+		if err := archivePack(&opts.Pack); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+	case "unpack":
+		if err := archiveUnpack(&opts.Unpack); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+	case "verify":
+		if err := archiveVerify(&opts.Verify); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "bytes"
+import "strings"
+import "testing"
+import "path/filepath"
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	area := testArea(t, 0x70B3D5D72F000001)
+	sigfile := writeTestFile(t, dir, "EUI-64_70B3D5D72F000001.bin", area)
+	key, pub := writeTestKeys(t, dir, "archive")
+	_, other := writeTestKeys(t, dir, "other")
+	archive := filepath.Join(dir, archiveName(sigfile))
+	if filepath.Base(archive) != "EUI-64_70B3D5D72F000001.tnsig" {
+		t.Errorf("archive of %s is %s", sigfile, archive)
+	}
+
+	pack := &ArchivePackOptions{Key: key, Out: archive}
+	pack.Args.File = sigfile
+	out := captureStdout(t, func() {
+		if err := archivePack(pack); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.HasPrefix(out, "70B3D5D72F000001 archived to "+archive+", signed with ") {
+		t.Errorf("pack printed %q", out)
+	}
+
+	m, contents, err := readArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Eui64 != "70B3D5D72F000001" || m.Source != filepath.Base(sigfile) || len(m.Files) != 4 || len(m.Signature) == 0 {
+		t.Errorf("manifest is %+v", m)
+	}
+	if !bytes.Equal(contents["sigdata.bin"], area) || !strings.Contains(string(contents["format.ksy"]), "eui_signature") {
+		t.Errorf("archive does not carry the area and its format")
+	}
+
+	verify := func(pubkey string) error {
+		var err error
+		captureStdout(t, func() {
+			opts := &ArchiveVerifyOptions{Pubkey: pubkey}
+			opts.Args.File = archive
+			err = archiveVerify(opts)
+		})
+		return err
+	}
+	if err := verify(pub); err != nil {
+		t.Errorf("verify with the signing key: %s", err)
+	}
+	if err := verify(other); err == nil {
+		t.Errorf("verified with another key")
+	}
+
+	unpack := &ArchiveUnpackOptions{Dir: t.TempDir()}
+	unpack.Args.File = archive
+	captureStdout(t, func() {
+		if err := archiveUnpack(unpack); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, name := range []string{ARCHIVE_MANIFEST, "README.txt", "sigdata.bin", "sigdata.json", "format.ksy"} {
+		if _, err := os.Stat(filepath.Join(unpack.Dir, name)); err != nil {
+			t.Errorf("unpacked %s: %s", name, err)
+		}
+	}
+}
+
+// A changed byte in any file of the archive is found.
+func TestArchiveTampered(t *testing.T) {
+	dir := t.TempDir()
+	sigfile := writeTestFile(t, dir, "sigdata.bin", testArea(t, 0x70B3D5D72F000001))
+	opts := &ArchivePackOptions{Out: filepath.Join(dir, "sigdata.tnsig")}
+	opts.Args.File = sigfile
+	captureStdout(t, func() {
+		if err := archivePack(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if m, _, err := readArchive(opts.Out); err != nil || len(m.Signature) != 0 {
+		t.Fatalf("unsigned archive: %+v, %v", m, err)
+	}
+
+	data, err := os.ReadFile(opts.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte("Thinnect device signature area archive"))
+	data[i] = 't'
+	tampered := writeTestFile(t, dir, "tampered.tnsig", data)
+	if _, _, err := readArchive(tampered); err == nil || !strings.Contains(err.Error(), "README.txt does not match") {
+		t.Errorf("tampered archive: %v", err)
+	}
+}
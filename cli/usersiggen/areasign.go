@@ -0,0 +1,114 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "io/ioutil"
+import "time"
+
+import "go.thinnect.net/euisig/signature"
+
+// The factory signs the finished signature area with its Ed25519 key, or its
+// ECDSA P-256 key in the TPM, see tpm.go, so devices and backend services can
+// check that the user page has not been changed since. Signing is the last
+// step, after the board, platform, component and other records have been
+// written:
+//
+//	usersiggen --type sign --sign-key factory.pem --out sigdata.bin
+//	usersiggen --type sign --sign-key tpm:0x81000100 --out sigdata.bin
+//
+// The area signature record covers every byte before it. Records appended
+// after it are not covered and make the verification fail.
+
+// trimErased drops the erased flash, 0xFF bytes, from the end of the data.
+// Not bytes.TrimRight, it takes "\xff" for a rune and trims any byte that is
+// not UTF-8 with it.
+func trimErased(data []byte) []byte {
+	end := len(data)
+	for end > 0 && data[end-1] == 0xFF {
+		end--
+	}
+	return data[:end]
+}
+
+// areaSigned tells if the records of an area already end in an area
+// signature.
+func areaSigned(area []byte) bool {
+	var gen UserSignature
+	sigs, err := gen.DeserializeArea(area)
+	if err != nil {
+		return false
+	}
+	for _, s := range sigs {
+		if _, ok := s.(AreaSignature); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func signMain(gen *UserSignature, opts *Options, timestamp time.Time) {
+	if len(opts.SignKey) == 0 {
+		fmt.Printf("ERROR --type sign needs the factory key, --sign-key\n")
+		os.Exit(2)
+	}
+	key, err := loadAreaSigner(opts.SignKey)
+	if err != nil {
+		fmt.Printf("ERROR loading signing key: %s\n", err)
+		os.Exit(1)
+	}
+	if g_policy != nil {
+		id, _ := signature.PublicKeyId(key.Public())
+		if err := g_policy.checkSigningKey(id); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+	}
+
+	area, err := ioutil.ReadFile(opts.Output)
+	if os.IsNotExist(err) {
+		fmt.Printf("ERROR initial signature file %s not found!\n", opts.Output)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Printf("ERROR reading signature file: %s\n", err)
+		os.Exit(1)
+	}
+	// Only the records are signed, not the erased flash after them
+	area = trimErased(area)
+	if areaSigned(area) {
+		fmt.Printf("ERROR %s is already signed\n", opts.Output)
+		os.Exit(4)
+	}
+
+	asig, err := gen.ConstructAreaSignatureWith(timestamp, area, key)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+	asigdata, err := gen.Serialize(asig)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := ioutil.WriteFile(opts.Output, append(area, asigdata...), 0640); err != nil {
+		fmt.Printf("ERROR writing output file: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Signed %d bytes with key %X\n", len(area), asig.Key_id[:])
+	outFormatMain(opts)
+	os.Exit(0)
+}
+
+// verifyAreaSignature checks the area signature of a signature file with the
+// factory public key.
+func verifyAreaSignature(data []byte, keyfile string) error {
+	var gen UserSignature
+	key, err := loadAreaPublic(keyfile)
+	if err != nil {
+		return err
+	}
+	return gen.VerifyAreaWith(data, key)
+}
@@ -0,0 +1,364 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "io"
+import "fmt"
+import "bytes"
+import "strings"
+import "sort"
+import "time"
+import "errors"
+import "encoding/json"
+import "encoding/hex"
+import "crypto/sha256"
+import "archive/tar"
+import "path/filepath"
+
+import "github.com/klauspost/compress/zstd"
+
+// Station state backup, a zstd compressed tar of the pools, sigdir, registries,
+// sequence logs and configuration with a manifest of SHA-256 hashes as the
+// first entry. Private keys are left out unless asked for.
+
+const BACKUP_MANIFEST = "MANIFEST.json"
+
+type BackupOptions struct {
+	Out         string `long:"out"          required:"true" description:"Backup file, state.tar.zst."`
+	IncludeKeys bool   `long:"include-keys" description:"Also capture private keys."`
+	Args        struct {
+		Paths []string `positional-arg-name:"path" description:"Pool files, sigdirs, profile and definition directories to capture."`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type RestoreOptions struct {
+	In     string `long:"in"      required:"true" description:"Backup file to restore."`
+	Dir    string `long:"dir"     default:"." description:"Directory to restore into."`
+	DryRun bool   `long:"dry-run" description:"Only show what would be added or changed."`
+	Force  bool   `long:"force"   description:"Overwrite files that differ from the backup."`
+}
+
+type BackupFile struct {
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	Mode   uint32    `json:"mode"`
+	Time   time.Time `json:"time"`
+	Sha256 string    `json:"sha256"`
+}
+
+type BackupManifest struct {
+	Schema    string       `json:"schema"`
+	Generator string       `json:"generator"`
+	Time      time.Time    `json:"time"`
+	Host      string       `json:"host"`
+	Files     []BackupFile `json:"files"`
+	Skipped   []string     `json:"skipped_keys,omitempty"`
+}
+
+// isPrivateKey recognizes key files by name or PEM content.
+func isPrivateKey(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".key" || ext == ".p12" || ext == ".pfx" {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(f, head)
+	return bytes.Contains(head[:n], []byte("PRIVATE KEY-----"))
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// backupPath keeps the path as given, relative to where the backup is made,
+// so restoring into the station directory puts everything back in place.
+func backupPath(path string) string {
+	return filepath.ToSlash(strings.TrimLeft(filepath.Clean(path), "/"))
+}
+
+func collectBackupFiles(paths []string, includeKeys bool, exclude string) ([]BackupFile, []string, error) {
+	files := make([]BackupFile, 0)
+	skipped := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || seen[path] {
+				return nil
+			}
+			if abs, _ := filepath.Abs(path); abs == exclude {
+				return nil
+			}
+			seen[path] = true
+			if !includeKeys && isPrivateKey(path) {
+				skipped = append(skipped, backupPath(path))
+				return nil
+			}
+			sum, err := fileSha256(path)
+			if err != nil {
+				return err
+			}
+			files = append(files, BackupFile{backupPath(path), info.Size(), uint32(info.Mode().Perm()), info.ModTime().UTC(), sum})
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, skipped, nil
+}
+
+func writeBackup(opts *BackupOptions) (*BackupManifest, error) {
+	out, _ := filepath.Abs(opts.Out)
+	files, skipped, err := collectBackupFiles(opts.Args.Paths, opts.IncludeKeys, out)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	manifest := &BackupManifest{SCHEMA_BACKUP, generatorVersion(), time.Now().UTC(), host, files, skipped}
+	mj, err := json.MarshalIndent(manifest, "", "	")
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(opts.Out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+
+	hdr := &tar.Header{Name: BACKUP_MANIFEST, Mode: 0644, Size: int64(len(mj)), ModTime: manifest.Time}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(mj); err != nil {
+		return nil, err
+	}
+
+	for _, bf := range files {
+		hdr := &tar.Header{Name: bf.Path, Mode: int64(bf.Mode), Size: bf.Size, ModTime: bf.Time}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		in, err := os.Open(filepath.FromSlash(bf.Path))
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.CopyN(tw, in, bf.Size)
+		in.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s changed during the backup: %s", bf.Path, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, f.Close()
+}
+
+// readBackup reads the manifest and contents of a backup and verifies every
+// file against its hash.
+func readBackup(infile string) (*BackupManifest, map[string][]byte, error) {
+	f, err := os.Open(infile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+
+	var manifest *BackupManifest
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Name == BACKUP_MANIFEST {
+			manifest = new(BackupManifest)
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("Corrupt manifest: %s", err)
+			}
+		} else {
+			contents[hdr.Name] = data
+		}
+	}
+	if manifest == nil {
+		return nil, nil, errors.New("No manifest, not a station backup")
+	}
+
+	for _, bf := range manifest.Files {
+		data, ok := contents[bf.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is missing from the backup", bf.Path)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != bf.Sha256 {
+			return nil, nil, fmt.Errorf("%s does not match its hash in the manifest", bf.Path)
+		}
+		if strings.HasPrefix(bf.Path, "../") || strings.Contains(bf.Path, "/../") {
+			return nil, nil, fmt.Errorf("%s points outside the restore directory", bf.Path)
+		}
+	}
+	return manifest, contents, nil
+}
+
+// What restoring does to a file, as the dry run shows it.
+const RESTORE_ADD = "A"
+const RESTORE_MODIFY = "M"
+const RESTORE_SAME = "="
+
+// restorePlan compares the files of the backup to the ones in dir, it returns
+// the action of every file and the number of files that would be overwritten.
+func restorePlan(manifest *BackupManifest, dir string) (map[string]string, int, error) {
+	changed := 0
+	actions := make(map[string]string)
+	for _, bf := range manifest.Files {
+		sum, err := fileSha256(filepath.Join(dir, filepath.FromSlash(bf.Path)))
+		if os.IsNotExist(err) {
+			actions[bf.Path] = RESTORE_ADD
+		} else if err != nil {
+			return nil, 0, err
+		} else if sum != bf.Sha256 {
+			actions[bf.Path] = RESTORE_MODIFY
+			changed++
+		} else {
+			actions[bf.Path] = RESTORE_SAME
+		}
+	}
+	return actions, changed, nil
+}
+
+// restoreFiles writes the files that are not the same as in the backup, each
+// replaced at once with its mode and time.
+func restoreFiles(manifest *BackupManifest, contents map[string][]byte, dir string, actions map[string]string) error {
+	for _, bf := range manifest.Files {
+		if actions[bf.Path] == RESTORE_SAME {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(bf.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0770); err != nil {
+			return err
+		}
+		tmp := target + ".restore"
+		if err := os.WriteFile(tmp, contents[bf.Path], os.FileMode(bf.Mode)); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			return err
+		}
+		os.Chtimes(target, bf.Time, bf.Time)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "backup",
+		short:   "Back up the production state",
+		long:    "Capture pools, sigdirs, registries, sequence logs and configuration with integrity hashes, private keys excluded.",
+		options: func() interface{} { return new(BackupOptions) },
+		run:     func(c *commandRun) { backupMain(c.options.(*BackupOptions)) },
+	})
+	registerCommand(subcommand{
+		name:    "restore",
+		short:   "Restore the production state from a backup",
+		long:    "Verify the backup and restore it, --dry-run shows what would be added or changed.",
+		options: func() interface{} { return new(RestoreOptions) },
+		run:     func(c *commandRun) { restoreMain(c.options.(*RestoreOptions)) },
+	})
+}
+
+func backupMain(opts *BackupOptions) {
+	manifest, err := writeBackup(opts)
+	if err != nil {
+		os.Remove(opts.Out)
+		fmt.Printf("ERROR creating backup: %s\n", err)
+		os.Exit(1)
+	}
+
+	var total int64
+	for _, bf := range manifest.Files {
+		total += bf.Size
+	}
+	for _, s := range manifest.Skipped {
+		fmt.Printf("Skipped private key %s\n", s)
+	}
+	fmt.Printf("Backed up %d files, %d bytes to %s\n", len(manifest.Files), total, opts.Out)
+}
+
+func restoreMain(opts *RestoreOptions) {
+	manifest, contents, err := readBackup(opts.In)
+	if err != nil {
+		fmt.Printf("ERROR reading backup %s: %s\n", opts.In, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Backup of %s from %s, generator %s, %d files\n",
+		manifest.Host, TimestampString(manifest.Time), manifest.Generator, len(manifest.Files))
+
+	actions, changed, err := restorePlan(manifest, opts.Dir)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	for _, bf := range manifest.Files {
+		if opts.DryRun || actions[bf.Path] != RESTORE_SAME {
+			fmt.Printf("%s %s\n", actions[bf.Path], bf.Path)
+		}
+	}
+
+	if opts.DryRun {
+		os.Exit(0)
+	}
+	if changed > 0 && !opts.Force {
+		fmt.Printf("ERROR %d files differ from the backup, use --force to overwrite them\n", changed)
+		os.Exit(4)
+	}
+
+	if err := restoreFiles(manifest, contents, opts.Dir, actions); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Restored %d files into %s\n", len(manifest.Files), opts.Dir)
+}
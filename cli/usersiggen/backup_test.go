@@ -0,0 +1,123 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "strings"
+import "testing"
+import "path/filepath"
+
+// The backup keeps the paths as given, it is made and restored in the station
+// directory.
+func TestBackup(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTestFile(t, ".", "euis.txt", []byte("70B3D5D72F000001,tsb2\n70B3D5D72F000002\n"))
+	writeTestFile(t, "sigdata", "EUI-64_70B3D5D72F000001.bin", testArea(t, 0x70B3D5D72F000001))
+	key, _ := writeTestKeys(t, "keys", "station")
+	writeTestFile(t, "keys", "line1.key", []byte("secret"))
+
+	opts := &BackupOptions{Out: "state.tar.zst"}
+	opts.Args.Paths = []string{"euis.txt", "sigdata", "keys", "."}
+	manifest, err := writeBackup(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, 0)
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+	}
+	if strings.Join(paths, " ") != "euis.txt keys/station.pub sigdata/EUI-64_70B3D5D72F000001.bin" {
+		t.Errorf("backed up %v", paths)
+	}
+	if strings.Join(manifest.Skipped, " ") != "keys/line1.key "+filepath.ToSlash(key) {
+		t.Errorf("skipped %v", manifest.Skipped)
+	}
+
+	read, contents, err := readBackup(opts.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Schema != SCHEMA_BACKUP || len(read.Files) != 3 || string(contents["euis.txt"]) != "70B3D5D72F000001,tsb2\n70B3D5D72F000002\n" {
+		t.Errorf("backup read is %+v", read)
+	}
+
+	opts = &BackupOptions{Out: "keys.tar.zst", IncludeKeys: true}
+	opts.Args.Paths = []string{"keys"}
+	if manifest, err := writeBackup(opts); err != nil || len(manifest.Files) != 3 || len(manifest.Skipped) != 0 {
+		t.Errorf("backup with the keys: %+v, %v", manifest, err)
+	}
+	if _, err := writeBackup(opts); err == nil {
+		t.Errorf("backup written over an existing one")
+	}
+}
+
+func TestBackupCorrupt(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTestFile(t, ".", "euis.txt", []byte("70B3D5D72F000001\n"))
+	opts := &BackupOptions{Out: "state.tar.zst"}
+	opts.Args.Paths = []string{"euis.txt"}
+	if _, err := writeBackup(opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(opts.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xFF
+	writeTestFile(t, ".", "corrupt.tar.zst", data)
+	if _, _, err := readBackup("corrupt.tar.zst"); err == nil {
+		t.Errorf("a corrupt backup is read")
+	}
+	if _, _, err := readBackup("euis.txt"); err == nil {
+		t.Errorf("a pool is read as a backup")
+	}
+}
+
+// A restore adds the missing files and overwrites the changed ones, the
+// same ones are left alone.
+func TestRestore(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTestFile(t, ".", "euis.txt", []byte("70B3D5D72F000001,tsb2\n70B3D5D72F000002\n"))
+	writeTestFile(t, "sigdata", "EUI-64_70B3D5D72F000001.bin", testArea(t, 0x70B3D5D72F000001))
+	writeTestFile(t, ".", "usersiggen.toml", []byte("sigdir = 'sigdata'\n"))
+	opts := &BackupOptions{Out: "state.tar.zst"}
+	opts.Args.Paths = []string{"euis.txt", "sigdata", "usersiggen.toml"}
+	if _, err := writeBackup(opts); err != nil {
+		t.Fatal(err)
+	}
+	manifest, contents, err := readBackup(opts.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pool moved on, the signature got lost
+	writeTestFile(t, ".", "euis.txt", []byte("70B3D5D72F000001,tsb2\n70B3D5D72F000002,tsb2\n"))
+	os.RemoveAll("sigdata")
+	actions, changed, err := restorePlan(manifest, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"euis.txt": RESTORE_MODIFY, "sigdata/EUI-64_70B3D5D72F000001.bin": RESTORE_ADD, "usersiggen.toml": RESTORE_SAME}
+	if changed != 1 || len(actions) != 3 {
+		t.Errorf("restore actions are %v, %d changed", actions, changed)
+	}
+	for path, action := range want {
+		if actions[path] != action {
+			t.Errorf("%s is %s, want %s", path, actions[path], action)
+		}
+	}
+
+	if err := restoreFiles(manifest, contents, ".", actions); err != nil {
+		t.Fatal(err)
+	}
+	if actions, changed, _ := restorePlan(manifest, "."); changed != 0 || actions["sigdata/EUI-64_70B3D5D72F000001.bin"] != RESTORE_SAME {
+		t.Errorf("after the restore actions are %v", actions)
+	}
+	if data, _ := os.ReadFile("euis.txt"); string(data) != "70B3D5D72F000001,tsb2\n70B3D5D72F000002\n" {
+		t.Errorf("pool is\n%s", data)
+	}
+	if info, err := os.Stat("sigdata/EUI-64_70B3D5D72F000001.bin"); err != nil || !info.ModTime().Equal(manifest.Files[1].Time) {
+		t.Errorf("restored signature: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "errors"
+import "path/filepath"
+
+// Batch generation of board signatures, --count N. The EUIs are taken from the
+// pool in one read, all signatures are built before anything is written, the
+// EUIs are marked in a single rewrite of the pool and then the signature files
+// are written into the sigdir. Every board needs a serial number of its own,
+// so a batch takes the serials from a work order or goes without.
+
+type batchBoard struct {
+	eui      eui64
+	sigfile  string
+	sequence uint32
+	sigdata  []byte
+}
+
+// checkBatch checks the options of a batch, the errors are usage errors.
+func checkBatch(opts *Options) error {
+	if len(opts.Euifile) == 0 {
+		return errors.New("--count takes the EUIs from an --euifile, not from an --eui-source")
+	}
+	if len(opts.Eui) > 0 {
+		return errors.New("--count can not be combined with --eui")
+	}
+	if len(opts.Serial) > 0 || len(opts.SerialUUID) > 0 {
+		return errors.New("every board of a batch needs its own serial, use a --work-order instead of --serial")
+	}
+	return nil
+}
+
+func generateBatch(gen *UserSignature, opts *Options, timestamp time.Time, component_uuid [16]byte, manufacturer_uuid [16]byte) error {
+	count := int(opts.Count)
+
+	var first uint32
+	if len(opts.WorkOrder) > 0 {
+		var err error
+		if first, err = nextSequence(opts.Seqdir, opts.WorkOrder, opts.WorkOrderSize); err != nil {
+			return err
+		}
+		if opts.WorkOrderSize > 0 && first+uint32(count)-1 > opts.WorkOrderSize {
+			return fmt.Errorf("Work order %s has room for %d more boards, not %d", opts.WorkOrder, opts.WorkOrderSize-first+1, count)
+		}
+	}
+
+	euis, err := getEuisExcept(opts.Euifile, opts.Tag, opts.WorkOrder, nil, count)
+	if err != nil {
+		return err
+	}
+
+	boards := make([]batchBoard, 0, count)
+	marks := make(map[eui64]string)
+	for i, eui := range euis {
+		b := batchBoard{eui: eui, sigfile: filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui))}
+		if _, err := os.Stat(b.sigfile); err == nil {
+			return fmt.Errorf("signature file for %016X exists at %s", eui, b.sigfile)
+		}
+
+		var serial [16]byte
+		if len(opts.WorkOrder) > 0 {
+			b.sequence = first + uint32(i)
+			if serial, err = sequenceSerial(opts.SerialFormat, opts.WorkOrder, b.sequence); err != nil {
+				return err
+			}
+		}
+
+		esig, err := gen.ConstructEUISignature(timestamp, eui)
+		if err != nil {
+			return err
+		}
+		esigdata, err := gen.Serialize(esig)
+		if err != nil {
+			return err
+		}
+		csig, err := gen.ConstructComponentSignature(timestamp, opts.Name, opts.Version, component_uuid, manufacturer_uuid, serial, opts.Position, SIGNATURE_TYPE_BOARD)
+		if err != nil {
+			return err
+		}
+		if err := policyComponent(csig); err != nil {
+			return err
+		}
+		var record interface{} = csig
+		if len(opts.Datafile) > 0 {
+			if record, err = attachDatafile(gen, csig, opts.Datafile); err != nil {
+				return err
+			}
+		}
+		csigdata, err := gen.Serialize(record)
+		if err != nil {
+			return err
+		}
+
+		b.sigdata = append(esigdata, csigdata...)
+		marks[eui] = poolMark(*csig, opts.Tag)
+		boards = append(boards, b)
+	}
+
+	// Claim, write the signature files, commit with the marks
+	claims := make([]EuiClaim, 0, len(boards))
+	for _, b := range boards {
+		claims = append(claims, newClaim(b.eui, opts.Tag, b.sigfile, b.sigdata))
+	}
+	if err := claimEuis(opts.Euifile, claims); err != nil {
+		return fmt.Errorf("claiming %d EUIs in %s: %s", len(claims), opts.Euifile, err)
+	}
+	for _, b := range boards {
+		if err := writeSigdirFile(b.sigfile, b.sigdata, 0440); err != nil {
+			rollbackClaims(opts.Euifile, claims)
+			return fmt.Errorf("writing %s: %s", b.sigfile, err)
+		}
+		registerSigfile(b.eui, b.sigfile)
+	}
+	if err := markEuis(opts.Euifile, marks, timestamp.Unix()); err != nil {
+		rollbackClaims(opts.Euifile, claims)
+		return fmt.Errorf("marking %d EUIs in %s: %s", len(marks), opts.Euifile, err)
+	}
+
+	results := make([]GenerationResult, 0, len(boards))
+	for _, b := range boards {
+		if len(opts.WorkOrder) > 0 {
+			if err := commitSequence(opts.Seqdir, opts.WorkOrder, b.sequence, fmt.Sprintf("%016X", b.eui)); err != nil {
+				return fmt.Errorf("recording work order sequence: %s", err)
+			}
+		}
+		r := GenerationResult{Type: "board", Eui: fmt.Sprintf("%016X", b.eui), Generated: TimestampString(timestamp),
+			WorkOrder: opts.WorkOrder, Sequence: b.sequence}
+		f, err := resultFile("sigfile", b.sigfile, true)
+		if err != nil {
+			return err
+		}
+		r.Files = []ResultFile{f}
+
+		fmt.Printf("EUI-64: %016X\n", b.eui)
+		if len(opts.WorkOrder) > 0 {
+			fmt.Printf("Sequence: %s %d\n", opts.WorkOrder, b.sequence)
+		}
+		if opts.PanelSize > 0 {
+			panel, position := panelOf(b.sequence, opts.PanelSize)
+			index, err := writePanelIndex(opts.Seqdir, opts.WorkOrder, panel, opts.PanelSize)
+			if err != nil {
+				return fmt.Errorf("writing panel index: %s", err)
+			}
+			fmt.Printf("Panel: %s position %d (%s)\n", panelId(opts.WorkOrder, panel), position, index)
+			r.Panel, r.PanelPosition = panelId(opts.WorkOrder, panel), position
+		}
+		results = append(results, r)
+	}
+	if opts.Format == FORMAT_JSON {
+		printResult(results)
+	}
+	return nil
+}
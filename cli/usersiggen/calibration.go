@@ -0,0 +1,344 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "os/exec"
+import "fmt"
+import "errors"
+import "sort"
+import "math"
+import "bytes"
+import "strings"
+import "strconv"
+import "net/http"
+import "encoding/csv"
+import "encoding/hex"
+import "encoding/json"
+import "io/ioutil"
+import "time"
+
+// Calibration plugins turn the raw calibration measurements of a sensor into
+// the component data of its component record. There is one plugin per sensor
+// type, the built-in ones register themselves from init, external ones are
+// declared in a profile and run as commands.
+//
+// Measurements come from a CSV file, one row per device with an eui64 (or
+// serial) column and one column per measurement, or from a fixture API that
+// returns them as a JSON object for GET <url> with {eui} substituted. With the
+// source "fixture" the fixture driver gets them from the test fixture in the
+// middle of the test, see fixture.go.
+
+type Measurements map[string]float64
+
+type CalibrationPlugin interface {
+	Sensor() string
+	// Measurements lists the measurement names the plugin needs
+	Measurements() []string
+	// Encode validates the measurements and returns the component data
+	Encode(m Measurements) ([]byte, error)
+}
+
+var g_calibration_plugins = make(map[string]CalibrationPlugin)
+
+func registerCalibration(p CalibrationPlugin) {
+	name := strings.ToLower(p.Sensor())
+	if _, ok := g_calibration_plugins[name]; ok {
+		panic(fmt.Sprintf("calibration plugin %s registered twice", name))
+	}
+	g_calibration_plugins[name] = p
+}
+
+func findCalibration(sensor string) (CalibrationPlugin, error) {
+	if p, ok := g_calibration_plugins[strings.ToLower(sensor)]; ok {
+		return p, nil
+	}
+	names := make([]string, 0, len(g_calibration_plugins))
+	for name := range g_calibration_plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("no calibration plugin for sensor %s, known sensors are: %s", sensor, strings.Join(names, ", "))
+}
+
+// requireMeasurements checks that all the measurements a plugin needs are
+// present and finite.
+func requireMeasurements(p CalibrationPlugin, m Measurements) error {
+	missing := make([]string, 0)
+	for _, name := range p.Measurements() {
+		v, ok := m[name]
+		if !ok {
+			missing = append(missing, name)
+		} else if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%s: measurement %s is not a number", p.Sensor(), name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: missing measurements %s", p.Sensor(), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// CalibrationConfig attaches calibration data to a component of a profile.
+type CalibrationConfig struct {
+	Sensor string `json:"sensor"`
+	Source string `json:"source"` // CSV file, fixture API url or "fixture"
+}
+
+// Measurements reported by the test fixture to the fixture driver.
+const CALIBRATION_SOURCE_FIXTURE = "fixture"
+
+// ExternalCalibration is a calibration plugin implemented by a command. It
+// gets the measurements as a JSON object on stdin and writes the component
+// data as hex to stdout, a non-zero exit status rejects the measurements.
+type ExternalCalibration struct {
+	Name    string   `json:"sensor"`
+	Needs   []string `json:"measurements"`
+	Command []string `json:"command"`
+}
+
+func (self *ExternalCalibration) Sensor() string {
+	return self.Name
+}
+
+func (self *ExternalCalibration) Measurements() []string {
+	return self.Needs
+}
+
+func (self *ExternalCalibration) Encode(m Measurements) ([]byte, error) {
+	if err := requireMeasurements(self, m); err != nil {
+		return nil, err
+	}
+	if len(self.Command) == 0 {
+		return nil, fmt.Errorf("%s: no command", self.Name)
+	}
+	input, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(self.Command[0], self.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %s %s", self.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: output is not hex: %s", self.Name, err)
+	}
+	return data, nil
+}
+
+// registerExternalCalibrations registers the calibration commands of a
+// profile, they may not replace a built-in plugin.
+func registerExternalCalibrations(plugins []ExternalCalibration) error {
+	for i := range plugins {
+		p := &plugins[i]
+		if existing, ok := g_calibration_plugins[strings.ToLower(p.Name)]; ok {
+			if ext, same := existing.(*ExternalCalibration); same && ext.Name == p.Name {
+				continue // Same profile loaded again
+			}
+			return fmt.Errorf("calibration plugin for %s is already registered", p.Name)
+		}
+		if len(p.Name) == 0 || len(p.Command) == 0 {
+			return fmt.Errorf("calibration plugin needs a sensor and a command")
+		}
+		registerCalibration(p)
+	}
+	return nil
+}
+
+// readMeasurements finds the measurements of a device in the source.
+func readMeasurements(source string, eui eui64, serial string) (Measurements, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return fetchMeasurements(strings.Replace(source, "{eui}", fmt.Sprintf("%016X", eui), -1))
+	}
+	return csvMeasurements(source, eui, serial)
+}
+
+func fetchMeasurements(url string) (Measurements, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var m Measurements
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("%s: %s", url, err)
+	}
+	return m, nil
+}
+
+// csvMeasurements reads the row of the device, matched by the eui64 or serial
+// column. A file without either column must have exactly one row.
+func csvMeasurements(file string, eui eui64, serial string) (Measurements, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("%s has no measurements", file)
+	}
+
+	header := rows[0]
+	key := -1
+	want := ""
+	for i, h := range header {
+		header[i] = strings.TrimSpace(h)
+		switch header[i] {
+		case "eui64":
+			key, want = i, fmt.Sprintf("%016X", eui)
+		case "serial":
+			if key < 0 && len(serial) > 0 {
+				key, want = i, serial
+			}
+		}
+	}
+
+	var row []string
+	if key < 0 {
+		if len(rows) != 2 {
+			return nil, fmt.Errorf("%s has %d rows but no eui64 or serial column", file, len(rows)-1)
+		}
+		row = rows[1]
+	} else {
+		for _, r := range rows[1:] {
+			v := strings.TrimSpace(r[key])
+			if header[key] == "eui64" {
+				// Any notation of the EUI, the canonical one of the euigen lists too
+				if e, err := parseEui(v); err == nil {
+					v = fmt.Sprintf("%016X", e)
+				}
+			}
+			if strings.EqualFold(v, want) {
+				if row != nil {
+					return nil, fmt.Errorf("%s has several rows for %s", file, want)
+				}
+				row = r
+			}
+		}
+		if row == nil {
+			return nil, fmt.Errorf("%s has no measurements for %s", file, want)
+		}
+	}
+
+	m := make(Measurements)
+	for i, h := range header {
+		if i == key || h == "eui64" || h == "serial" || i >= len(row) || len(strings.TrimSpace(row[i])) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s is not a number: %s", file, h, row[i])
+		}
+		m[h] = v
+	}
+	return m, nil
+}
+
+// calibrationData reads the measurements of a device and encodes them with
+// the plugin of the sensor.
+func calibrationData(cfg CalibrationConfig, eui eui64, serial string) ([]byte, error) {
+	p, err := findCalibration(cfg.Sensor)
+	if err != nil {
+		return nil, err
+	}
+	m, err := readMeasurements(cfg.Source, eui, serial)
+	if err != nil {
+		return nil, err
+	}
+	return p.Encode(m)
+}
+
+// fixtureCalibrationData encodes the measurements the fixture reported.
+func fixtureCalibrationData(cfg CalibrationConfig, m Measurements) ([]byte, error) {
+	p, err := findCalibration(cfg.Sensor)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("the measurements come from the test fixture, only the fixture driver has them")
+	}
+	return p.Encode(m)
+}
+
+type CalibrationListOptions struct{}
+
+type CalibrationEncodeOptions struct {
+	Sensor string `long:"sensor" required:"true" description:"Sensor type of the calibration plugin."`
+	Source string `long:"source" required:"true" description:"Measurements CSV file or fixture API url."`
+	Eui    string `long:"eui"    description:"EUI-64 of the device row."`
+	Serial string `long:"serial" description:"Serial number of the device row."`
+}
+
+type CalibrationOptions struct {
+	List   CalibrationListOptions   `command:"list"   description:"List the calibration plugins and the measurements they need."`
+	Encode CalibrationEncodeOptions `command:"encode" description:"Validate measurements and print the component data."`
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "calibration",
+		short:   "Component data calibration plugins",
+		long:    "List the calibration plugins per sensor type, or validate measurements and show the component data they encode to.",
+		options: func() interface{} { return new(CalibrationOptions) },
+		run:     func(c *commandRun) { calibrationMain(c.command, c.options.(*CalibrationOptions)) },
+		offline: func(c *commandRun) error {
+			if c.command == "encode" {
+				return offlineUrl("calibration source", c.options.(*CalibrationOptions).Encode.Source)
+			}
+			return nil
+		},
+	})
+}
+
+func calibrationMain(command string, opts *CalibrationOptions) {
+	switch command {
+	case "list":
+		names := make([]string, 0, len(g_calibration_plugins))
+		for name := range g_calibration_plugins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p := g_calibration_plugins[name]
+			fmt.Printf("%-12s %s\n", p.Sensor(), strings.Join(p.Measurements(), ","))
+		}
+
+	case "encode":
+		var eui eui64
+		if len(opts.Encode.Eui) > 0 {
+			var err error
+			if eui, err = parseEui(opts.Encode.Eui); err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(2)
+			}
+		}
+		data, err := calibrationData(CalibrationConfig{opts.Encode.Sensor, opts.Encode.Source}, eui, opts.Encode.Serial)
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+		fmt.Printf("%X\n", data)
+	}
+}
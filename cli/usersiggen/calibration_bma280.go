@@ -0,0 +1,58 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "math"
+import "bytes"
+import "encoding/binary"
+
+// BMA280 accelerometer, six position tumble calibration. Every axis is read
+// pointing up and down, in mg, the mean of the two is the offset and half
+// the difference the response to 1 g. The component data is per axis x, y, z:
+//
+//   u8  format version (1)
+//   s16 offset, mg
+//   u16 scale, 1/10000 of the response to 1 g
+
+const BMA280_CALIBRATION_VERSION = 1
+
+type bma280Calibration struct{}
+
+func init() {
+	registerCalibration(bma280Calibration{})
+}
+
+func (bma280Calibration) Sensor() string {
+	return "bma280"
+}
+
+func (bma280Calibration) Measurements() []string {
+	return []string{"x_up", "x_down", "y_up", "y_down", "z_up", "z_down"}
+}
+
+func (self bma280Calibration) Encode(m Measurements) ([]byte, error) {
+	if err := requireMeasurements(self, m); err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint8(BMA280_CALIBRATION_VERSION))
+	for _, axis := range []string{"x", "y", "z"} {
+		up, down := m[axis+"_up"], m[axis+"_down"]
+		offset := (up + down) / 2
+		scale := (up - down) / 2 / 1000
+
+		// The datasheet zero-g offset is within ±150 mg, sensitivity ±5 %
+		if math.Abs(offset) > 150 {
+			return nil, fmt.Errorf("bma280 %s axis offset %.0f mg is out of range", axis, offset)
+		}
+		if scale < 0.9 || scale > 1.1 {
+			return nil, fmt.Errorf("bma280 %s axis response %.3f g is out of range, was the axis read up and down?", axis, scale)
+		}
+		binary.Write(buf, binary.BigEndian, int16(math.Round(offset)))
+		binary.Write(buf, binary.BigEndian, uint16(math.Round(scale*10000)))
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "math"
+import "bytes"
+import "encoding/binary"
+
+// SHT31 temperature and humidity sensor, two point calibration against a
+// reference in a climate chamber. The component data is the linear
+// correction of both channels:
+//
+//   u8  format version (1)
+//   s16 temperature offset, 0.01 °C
+//   u16 temperature gain, 1/10000
+//   s16 humidity offset, 0.01 %RH
+//   u16 humidity gain, 1/10000
+
+const SHT31_CALIBRATION_VERSION = 1
+
+type sht31Calibration struct{}
+
+func init() {
+	registerCalibration(sht31Calibration{})
+}
+
+func (sht31Calibration) Sensor() string {
+	return "sht31"
+}
+
+func (sht31Calibration) Measurements() []string {
+	return []string{
+		"t_ref_1", "t_raw_1", "t_ref_2", "t_raw_2",
+		"rh_ref_1", "rh_raw_1", "rh_ref_2", "rh_raw_2",
+	}
+}
+
+// twoPoint fits reference = gain*raw + offset through two measurements.
+func twoPoint(ref1, raw1, ref2, raw2 float64) (float64, float64, error) {
+	if math.Abs(raw2-raw1) < 1e-6 {
+		return 0, 0, fmt.Errorf("calibration points %.2f and %.2f are the same", raw1, raw2)
+	}
+	gain := (ref2 - ref1) / (raw2 - raw1)
+	return gain, ref1 - gain*raw1, nil
+}
+
+func (self sht31Calibration) Encode(m Measurements) ([]byte, error) {
+	if err := requireMeasurements(self, m); err != nil {
+		return nil, err
+	}
+
+	tgain, toffset, err := twoPoint(m["t_ref_1"], m["t_raw_1"], m["t_ref_2"], m["t_raw_2"])
+	if err != nil {
+		return nil, fmt.Errorf("sht31 temperature: %s", err)
+	}
+	hgain, hoffset, err := twoPoint(m["rh_ref_1"], m["rh_raw_1"], m["rh_ref_2"], m["rh_raw_2"])
+	if err != nil {
+		return nil, fmt.Errorf("sht31 humidity: %s", err)
+	}
+
+	// Beyond these the sensor is broken rather than in need of calibration
+	if tgain < 0.9 || tgain > 1.1 || math.Abs(toffset) > 5 {
+		return nil, fmt.Errorf("sht31 temperature correction gain %.4f offset %.2f °C is out of range", tgain, toffset)
+	}
+	if hgain < 0.8 || hgain > 1.2 || math.Abs(hoffset) > 15 {
+		return nil, fmt.Errorf("sht31 humidity correction gain %.4f offset %.2f %%RH is out of range", hgain, hoffset)
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, struct {
+		Version  uint8
+		Toffset  int16
+		Tgain    uint16
+		Rhoffset int16
+		Rhgain   uint16
+	}{
+		SHT31_CALIBRATION_VERSION,
+		int16(math.Round(toffset * 100)),
+		uint16(math.Round(tgain * 10000)),
+		int16(math.Round(hoffset * 100)),
+		uint16(math.Round(hgain * 10000)),
+	})
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "math"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "encoding/hex"
+
+// Measurements of a SHT31 reading 0.5 °C low and 2 %RH high.
+var g_test_sht31 = Measurements{
+	"t_ref_1": 20, "t_raw_1": 19.5, "t_ref_2": 40, "t_raw_2": 39.5,
+	"rh_ref_1": 30, "rh_raw_1": 32, "rh_ref_2": 70, "rh_raw_2": 72,
+}
+
+func TestCalibrationPlugins(t *testing.T) {
+	p, err := findCalibration("SHT31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := p.Encode(g_test_sht31); err != nil || hex.EncodeToString(data) != "0100322710ff382710" {
+		t.Errorf("sht31 data is %x: %v", data, err)
+	}
+	if _, err := findCalibration("bme280"); err == nil || !strings.Contains(err.Error(), "bma280, sht31") {
+		t.Errorf("unknown sensor: %v", err)
+	}
+
+	broken := Measurements{}
+	for k, v := range g_test_sht31 {
+		broken[k] = v
+	}
+	broken["t_raw_2"] = 19.5
+	delete(broken, "rh_raw_2")
+	if _, err := p.Encode(broken); err == nil || !strings.Contains(err.Error(), "missing measurements rh_raw_2") {
+		t.Errorf("missing measurement: %v", err)
+	}
+	broken["rh_raw_2"] = math.NaN()
+	if _, err := p.Encode(broken); err == nil || !strings.Contains(err.Error(), "not a number") {
+		t.Errorf("NaN measurement: %v", err)
+	}
+	broken["rh_raw_2"] = 72
+	if _, err := p.Encode(broken); err == nil || !strings.Contains(err.Error(), "are the same") {
+		t.Errorf("one calibration point: %v", err)
+	}
+
+	bma, _ := findCalibration("bma280")
+	axes := Measurements{"x_up": 1010, "x_down": -990, "y_up": 1000, "y_down": -1000, "z_up": 1000, "z_down": -1000}
+	if data, err := bma.Encode(axes); err != nil || hex.EncodeToString(data[:5]) != "01000a2710" {
+		t.Errorf("bma280 data is %x: %v", data, err)
+	}
+	axes["z_down"] = 1000
+	if _, err := bma.Encode(axes); err == nil || !strings.Contains(err.Error(), "z axis") {
+		t.Errorf("z axis not turned: %v", err)
+	}
+}
+
+func TestExternalCalibration(t *testing.T) {
+	plugins := []ExternalCalibration{
+		{Name: "lux42", Needs: []string{"dark", "bright"}, Command: []string{"sh", "-c", "cat >/dev/null; echo 0102"}},
+		{Name: "co2x", Needs: []string{"ppm"}, Command: []string{"sh", "-c", "echo out of range >&2; exit 3"}},
+	}
+	t.Cleanup(func() {
+		delete(g_calibration_plugins, "lux42")
+		delete(g_calibration_plugins, "co2x")
+	})
+	if err := registerExternalCalibrations(plugins); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerExternalCalibrations(plugins); err != nil {
+		t.Errorf("the same profile loaded again: %v", err)
+	}
+	if err := registerExternalCalibrations([]ExternalCalibration{{Name: "sht31", Command: []string{"true"}}}); err == nil {
+		t.Errorf("an external plugin replaced a built-in one")
+	}
+
+	lux, _ := findCalibration("lux42")
+	if data, err := lux.Encode(Measurements{"dark": 1, "bright": 900}); err != nil || hex.EncodeToString(data) != "0102" {
+		t.Errorf("lux42 data is %x: %v", data, err)
+	}
+	co2, _ := findCalibration("co2x")
+	if _, err := co2.Encode(Measurements{"ppm": 400}); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("rejected measurements: %v", err)
+	}
+}
+
+func TestMeasurements(t *testing.T) {
+	dir := t.TempDir()
+	csv := writeTestFile(t, dir, "chamber.csv", []byte("# chamber 2\neui64, serial, t_ref_1, t_raw_1\n70-B3-D5-D7-2F-00-00-01,SN-0001,20,19.5\n70B3D5D72F000002,SN-0002,20,\n"))
+	if m, err := readMeasurements(csv, 0x70B3D5D72F000001, ""); err != nil || len(m) != 2 || m["t_raw_1"] != 19.5 {
+		t.Errorf("measurements are %v: %v", m, err)
+	}
+	if m, err := readMeasurements(csv, 0x70B3D5D72F000002, ""); err != nil || len(m) != 1 {
+		t.Errorf("an empty cell is measured: %v, %v", m, err)
+	}
+	if _, err := readMeasurements(csv, 0x70B3D5D72F000003, ""); err == nil || !strings.Contains(err.Error(), "no measurements for 70B3D5D72F000003") {
+		t.Errorf("device not in the file: %v", err)
+	}
+	serials := writeTestFile(t, dir, "serials.csv", []byte("serial,dark\nSN-0001,1\nSN-0002,2\n"))
+	if m, err := readMeasurements(serials, 0x70B3D5D72F000001, "sn-0002"); err != nil || m["dark"] != 2 {
+		t.Errorf("measurements by serial are %v: %v", m, err)
+	}
+	if _, err := readMeasurements(serials, 0x70B3D5D72F000001, ""); err == nil {
+		t.Errorf("several rows without a key")
+	}
+	if _, err := readMeasurements(writeTestFile(t, dir, "bad.csv", []byte("dark\nnone\n")), 0, ""); err == nil || !strings.Contains(err.Error(), "not a number") {
+		t.Errorf("text measurement: %v", err)
+	}
+
+	fixture := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/measurements/70B3D5D72F000001" {
+			http.Error(w, "no such device", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"dark": 1.5}`))
+	}))
+	defer fixture.Close()
+	if m, err := readMeasurements(fixture.URL+"/measurements/{eui}", 0x70B3D5D72F000001, ""); err != nil || m["dark"] != 1.5 {
+		t.Errorf("fixture API measurements are %v: %v", m, err)
+	}
+	if _, err := readMeasurements(fixture.URL+"/measurements/{eui}", 0x70B3D5D72F000002, ""); err == nil || !strings.Contains(err.Error(), "no such device") {
+		t.Errorf("device unknown to the fixture API: %v", err)
+	}
+
+	if _, err := fixtureCalibrationData(CalibrationConfig{Sensor: "sht31", Source: CALIBRATION_SOURCE_FIXTURE}, nil); err == nil {
+		t.Errorf("fixture measurements without the fixture")
+	}
+}
@@ -0,0 +1,376 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "io/ioutil"
+import "strings"
+
+import "gopkg.in/yaml.v2"
+
+import "go.thinnect.net/euisig/signature"
+
+// PlatformDefinition is the expected composition of a device, the electronic
+// bill of materials its signatures are audited against.
+//
+//	name: tsb2
+//	board:
+//	  name: tsb2
+//	  uuid: 7e1a....
+//	  version: ">=2.0.0, <3.0.0"
+//	platform:
+//	  name: tsb2-env
+//	  uuid: 1f2b....
+//	slots:
+//	  - name: sensor-ext
+//	    positions: "0-3"
+//	components:
+//	  - name: sht31
+//	    uuid: 3c4d....
+//	    version: "1.x"
+//	    position: 0
+//	    slot: sensor-ext
+type PlatformDefinition struct {
+	Name       string                `yaml:"name"`
+	Board      *ComponentDefinition  `yaml:"board"`
+	Platform   *ComponentDefinition  `yaml:"platform"`
+	Slots      []SlotDefinition      `yaml:"slots"`
+	Components []ComponentDefinition `yaml:"components"`
+}
+
+type ComponentDefinition struct {
+	Name         string `yaml:"name"`
+	UUID         string `yaml:"uuid"`
+	Manufacturer string `yaml:"manufacturer"`
+	Version      string `yaml:"version"` // Exact, wildcard (1.2.x) or comma separated comparisons (>=1.0.0, <2.0.0)
+	Position     uint8  `yaml:"position"`
+	Slot         string `yaml:"slot"`
+}
+
+type CheckOptions struct {
+	Against   string `long:"against" required:"true" description:"Platform definition YAML file."`
+	Blacklist string `long:"blacklist" description:"Blacklist of signature area hashes, from rma transfer. A blacklisted signature fails the check."`
+	Args      struct {
+		Sigfile string `positional-arg-name:"sigdata.bin"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type BomIssue struct {
+	Kind   string // missing, extra, mismatch or revoked
+	What   string
+	Detail string
+}
+
+func loadPlatformDefinition(file string) (*PlatformDefinition, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	def := new(PlatformDefinition)
+	if err := yaml.UnmarshalStrict(b, def); err != nil {
+		return nil, fmt.Errorf("Failed to parse platform definition %s: %s", file, err)
+	}
+	for _, cdef := range def.Components {
+		if len(cdef.Slot) > 0 {
+			if err := def.checkSlot(cdef.Slot, cdef.Position); err != nil {
+				return nil, fmt.Errorf("Platform definition %s: %s", file, err)
+			}
+		}
+	}
+	return def, nil
+}
+
+func compareVersion(a BoardVersion, b BoardVersion) int {
+	av := []uint8{a.Major, a.Minor, a.Assembly}
+	bv := []uint8{b.Major, b.Minor, b.Assembly}
+	for i := range av {
+		if av[i] != bv[i] {
+			if av[i] < bv[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionMatches checks the MAJOR.MINOR.ASSEMBLY version of a signature
+// against a version expression from a platform definition.
+func versionMatches(expr string, v BoardVersion) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if len(expr) == 0 || expr == "*" {
+		return true, nil
+	}
+
+	if strings.ContainsAny(expr, "xX*") {
+		parts := strings.Split(expr, ".")
+		actual := strings.Split(v.String(), ".")
+		if len(parts) > 3 {
+			return false, fmt.Errorf("Invalid version pattern %s", expr)
+		}
+		for i, p := range parts {
+			if p == "x" || p == "X" || p == "*" {
+				return true, nil
+			}
+			if p != actual[i] {
+				return false, nil
+			}
+		}
+		return len(parts) == 3, nil
+	}
+
+	for _, c := range strings.Split(expr, ",") {
+		c = strings.TrimSpace(c)
+		op := "="
+		for _, o := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(c, o) {
+				op = o
+				c = strings.TrimSpace(c[len(o):])
+				break
+			}
+		}
+
+		var want BoardVersion
+		if err := want.UnmarshalFlag(c); err != nil {
+			return false, fmt.Errorf("Invalid version %s: %s", c, err)
+		}
+
+		cmp := compareVersion(v, want)
+		ok := false
+		switch op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func sigVersion(s ComponentSignature) BoardVersion {
+	return BoardVersion{Major: s.Version_major, Minor: s.Version_minor, Assembly: s.Version_assembly}
+}
+
+// compareComponent lists the differences between an expected and an actual
+// signature, the position is compared by the caller.
+func compareComponent(what string, def ComponentDefinition, s ComponentSignature) ([]BomIssue, error) {
+	issues := make([]BomIssue, 0)
+
+	if len(def.Name) > 0 && def.Name != s.BoardName() {
+		issues = append(issues, BomIssue{"mismatch", what, fmt.Sprintf("name %s, expected %s", s.BoardName(), def.Name)})
+	}
+
+	if len(def.UUID) > 0 {
+		u, err := parseUuid(def.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("%s UUID: %s", what, err)
+		}
+		if u != s.Component_uuid {
+			issues = append(issues, BomIssue{"mismatch", what, fmt.Sprintf("UUID %s, expected %s", uuidString(s.Component_uuid), uuidString(u))})
+		}
+	}
+
+	if len(def.Manufacturer) > 0 {
+		u, err := parseUuid(def.Manufacturer)
+		if err != nil {
+			return nil, fmt.Errorf("%s manufacturer UUID: %s", what, err)
+		}
+		if u != s.Manufacturer_uuid {
+			issues = append(issues, BomIssue{"mismatch", what, fmt.Sprintf("manufacturer %s, expected %s", uuidString(s.Manufacturer_uuid), uuidString(u))})
+		}
+	}
+
+	ok, err := versionMatches(def.Version, sigVersion(s))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", what, err)
+	}
+	if !ok {
+		issues = append(issues, BomIssue{"mismatch", what, fmt.Sprintf("version %s, expected %s", s.BoardVersion(), def.Version)})
+	}
+
+	return issues, nil
+}
+
+func sameUuid(def ComponentDefinition, s ComponentSignature) bool {
+	u, err := parseUuid(def.UUID)
+	return err == nil && u == s.Component_uuid
+}
+
+// checkAgainstDefinition audits the signatures of a device against the
+// platform definition and returns every deviation found.
+func checkAgainstDefinition(def *PlatformDefinition, sigs []interface{}) ([]BomIssue, error) {
+	issues := make([]BomIssue, 0)
+
+	var board, platform *ComponentSignature
+	components := make([]ComponentSignature, 0)
+	slots := make([]tslot, 0)
+	for _, sig := range sigs {
+		if s, ok := signature.ComponentOf(sig); ok {
+			switch s.Signature_type {
+			case SIGNATURE_TYPE_BOARD:
+				board = &s
+			case SIGNATURE_TYPE_PLATFORM:
+				platform = &s
+			case SIGNATURE_TYPE_COMPONENT:
+				components = append(components, s)
+				slots = append(slots, signature.SlotOf(sig))
+			}
+		}
+	}
+
+	singles := []struct {
+		what string
+		def  *ComponentDefinition
+		sig  *ComponentSignature
+	}{
+		{"board", def.Board, board},
+		{"platform", def.Platform, platform},
+	}
+	for _, single := range singles {
+		if single.def == nil && single.sig != nil {
+			issues = append(issues, BomIssue{"extra", single.what, single.sig.BoardName()})
+		} else if single.def != nil && single.sig == nil {
+			issues = append(issues, BomIssue{"missing", single.what, single.def.Name})
+		} else if single.def != nil {
+			found, err := compareComponent(single.what, *single.def, *single.sig)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, found...)
+		}
+	}
+
+	used := make([]bool, len(components))
+	for _, cdef := range def.Components {
+		what := fmt.Sprintf("component %s@%d", cdef.Name, cdef.Position)
+
+		match := -1
+		for i, c := range components {
+			if !used[i] && c.Position == cdef.Position && sameUuid(cdef, c) {
+				match = i
+				break
+			}
+		}
+		if match < 0 {
+			for i, c := range components {
+				if !used[i] && (c.Position == cdef.Position || sameUuid(cdef, c)) {
+					match = i
+					break
+				}
+			}
+		}
+		if match < 0 {
+			issues = append(issues, BomIssue{"missing", what, ""})
+			continue
+		}
+
+		used[match] = true
+		found, err := compareComponent(what, cdef, components[match])
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+		if components[match].Position != cdef.Position {
+			issues = append(issues, BomIssue{"mismatch", what, fmt.Sprintf("position %d, expected %d", components[match].Position, cdef.Position)})
+		}
+		if slot := signature.SlotHash(cdef.Slot); slots[match] != 0 && slots[match] != slot {
+			issues = append(issues, BomIssue{"mismatch", what, fmt.Sprintf("slot %s, expected %s", slots[match], cdef.Slot)})
+		}
+	}
+
+	for n, c := range components {
+		if slots[n] == 0 {
+			continue
+		}
+		var slot *SlotDefinition
+		for i := range def.Slots {
+			if signature.SlotHash(def.Slots[i].Name) == slots[n] {
+				slot = &def.Slots[i]
+			}
+		}
+		what := fmt.Sprintf("component %s@%d", c.BoardName(), c.Position)
+		if slot == nil {
+			issues = append(issues, BomIssue{"mismatch", what, fmt.Sprintf("slot %s is not defined", slots[n])})
+		} else if err := def.checkSlot(slot.Name, c.Position); err != nil {
+			issues = append(issues, BomIssue{"mismatch", what, err.Error()})
+		}
+	}
+
+	for i, c := range components {
+		if !used[i] {
+			issues = append(issues, BomIssue{"extra", fmt.Sprintf("component %s@%d", c.BoardName(), c.Position), c.BoardVersion()})
+		}
+	}
+
+	return issues, nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "check",
+		short:   "Check signatures against a platform definition",
+		long:    "Verify that the recorded board, platform and components match the expected platform definition.",
+		options: func() interface{} { return new(CheckOptions) },
+		run:     func(c *commandRun) { checkMain(c.options.(*CheckOptions)) },
+	})
+}
+
+func checkMain(opts *CheckOptions) {
+	def, err := loadPlatformDefinition(opts.Against)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	for _, s := range def.Slots {
+		signature.SlotNames[signature.SlotHash(s.Name)] = s.Name
+	}
+
+	sigs, err := readSigsFromFile(opts.Args.Sigfile)
+	if err != nil {
+		fmt.Printf("Failed to read signature from file [%s]: %s\n", opts.Args.Sigfile, err)
+		os.Exit(3)
+	}
+
+	issues, err := checkAgainstDefinition(def, sigs)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(opts.Blacklist) > 0 {
+		blacklist, err := readBlacklist(opts.Blacklist)
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+		data, err := readSigdirFile(opts.Args.Sigfile)
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+		if entry, ok := blacklist[sigHash(data)]; ok {
+			issues = append(issues, BomIssue{"revoked", "signature", "blacklisted " + entry + ", the identity was transferred to another board"})
+		}
+	}
+
+	for _, issue := range issues {
+		fmt.Printf("%-8s %s %s\n", strings.ToUpper(issue.Kind), issue.What, issue.Detail)
+	}
+
+	if len(issues) > 0 {
+		fmt.Printf("%s does not match %s, %d issues\n", opts.Args.Sigfile, def.Name, len(issues))
+		os.Exit(4)
+	}
+	fmt.Printf("%s matches %s\n", opts.Args.Sigfile, def.Name)
+}
@@ -0,0 +1,89 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "testing"
+
+func TestVersionMatches(t *testing.T) {
+	v := BoardVersion{Major: 1, Minor: 2, Assembly: 3}
+	for expr, want := range map[string]bool{
+		"":                true,
+		"*":               true,
+		"1.2.3":           true,
+		"1.2.4":           false,
+		"1.x":             true,
+		"1.2.x":           true,
+		"1.3.x":           false,
+		"2.x":             false,
+		">=1.0.0, <2.0.0": true,
+		">1.2.3":          false,
+		"<=1.2.3":         true,
+		">=2.0.0":         false,
+	} {
+		if ok, err := versionMatches(expr, v); err != nil || ok != want {
+			t.Errorf("1.2.3 matches %q: %v, %v", expr, ok, err)
+		}
+	}
+	if _, err := versionMatches(">=1.two", v); err == nil {
+		t.Errorf(">=1.two is a version")
+	}
+}
+
+const testPlatformDefinition = `name: tsb2-env
+board:
+  name: tsb2
+  uuid: 01010101-0101-0101-0101-010101010101
+  version: ">=1.0.0, <2.0.0"
+platform:
+  name: smenete
+  uuid: 02020202-0202-0202-0202-020202020202
+components:
+  - name: sht31
+    uuid: 03030303-0303-0303-0303-030303030303
+    version: "1.2.x"
+    position: 0
+`
+
+func TestCheckAgainstDefinition(t *testing.T) {
+	dir := t.TempDir()
+	sigs, err := readSigs(testArea(t, 0x70B3D5D72F000001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := loadPlatformDefinition(writeTestFile(t, dir, "tsb2-env.yaml", []byte(testPlatformDefinition)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues, err := checkAgainstDefinition(def, sigs); err != nil || len(issues) != 0 {
+		t.Errorf("issues with the matching definition: %v, %v", issues, err)
+	}
+
+	def.Board.Version = "2.x"
+	def.Platform = nil
+	def.Components[0].Position = 1
+	def.Components = append(def.Components, ComponentDefinition{Name: "bma280", Position: 2})
+	issues, err := checkAgainstDefinition(def, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"mismatch board version 1.2.3, expected 2.x",
+		"extra platform smenete",
+		"mismatch component sht31@1 position 0, expected 1",
+		"missing component bma280@2 ",
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("issues are %v", issues)
+	}
+	for i, issue := range issues {
+		if s := fmt.Sprintf("%s %s %s", issue.Kind, issue.What, issue.Detail); s != want[i] {
+			t.Errorf("issue %d is %q, want %q", i, s, want[i])
+		}
+	}
+
+	if _, err := loadPlatformDefinition(writeTestFile(t, dir, "typo.yaml", []byte("name: x\nbaord:\n  name: tsb2\n"))); err == nil {
+		t.Errorf("a definition with an unknown field is loaded")
+	}
+}
@@ -0,0 +1,282 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bufio"
+import "strings"
+import "time"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import "path/filepath"
+
+// A run that allocates from an euifile goes claim, generate, commit. The EUIs
+// are claimed in a journal next to the pool, eui.txt.journal, with the hash of
+// the signature area before any file is written, then the signature files are
+// written and marking the pool commits the run and drops the claims. A claim
+// left in the journal is a run that was interrupted. The next allocation from
+// the pool, holding the pool lock, resumes it when its signature file was
+// written in full and marks the EUI, otherwise it rolls it back: the partial
+// file is moved aside and the EUI stays free. Pools in an EUI registry keep
+// their claims in the registry.
+
+type EuiClaim struct {
+	Eui     string `json:"eui64"`
+	Tag     string `json:"tag,omitempty"`
+	Sigfile string `json:"sigfile"`
+	Sha256  string `json:"sha256"` // Of the signature area the run generated
+	Claimed string `json:"claimed"`
+}
+
+type RecoverOptions struct {
+	Rollback bool `long:"rollback" description:"Roll back the interrupted runs that wrote their signature files too, instead of marking the EUIs."`
+	Args     struct {
+		Euifile string `positional-arg-name:"euifile"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func claimJournal(infile string) string {
+	return infile + ".journal"
+}
+
+func newClaim(eui eui64, tag string, sigfile string, sigdata []byte) EuiClaim {
+	if abs, err := filepath.Abs(sigfile); err == nil {
+		sigfile = abs
+	}
+	sum := sha256.Sum256(sigdata)
+	return EuiClaim{Eui: fmt.Sprintf("%016X", eui), Tag: tag, Sigfile: sigfile,
+		Sha256: hex.EncodeToString(sum[:]), Claimed: TimestampString(time.Now().UTC())}
+}
+
+func readClaims(infile string) ([]EuiClaim, error) {
+	claims := make([]EuiClaim, 0)
+	in, err := os.Open(claimJournal(infile))
+	if os.IsNotExist(err) {
+		return claims, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) == 0 {
+			continue
+		}
+		var c EuiClaim
+		if err := json.Unmarshal([]byte(t), &c); err != nil {
+			return nil, fmt.Errorf("%s: %s", claimJournal(infile), err)
+		}
+		claims = append(claims, c)
+	}
+	return claims, scanner.Err()
+}
+
+func writeClaims(infile string, claims []EuiClaim) error {
+	journal := claimJournal(infile)
+	if len(claims) == 0 {
+		if err := os.Remove(journal); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	var sb strings.Builder
+	for _, c := range claims {
+		j, _ := json.Marshal(c)
+		sb.Write(j)
+		sb.WriteString("\n")
+	}
+	tmp := journal + ".tmp"
+	if err := writeSyncedFile(tmp, []byte(sb.String())); err != nil {
+		return err
+	}
+	return os.Rename(tmp, journal)
+}
+
+// claimEuis journals the signature files a run is about to write.
+func claimEuis(infile string, claims []EuiClaim) error {
+	if g_eui_registry != nil || len(claims) == 0 {
+		return nil
+	}
+	if taken, err := lockPool(infile); err != nil {
+		return err
+	} else if taken {
+		defer unlockPool(infile)
+	}
+
+	journal, err := readClaims(infile)
+	if err != nil {
+		return err
+	}
+	return writeClaims(infile, append(journal, claims...))
+}
+
+// dropClaims removes the claims of the EUIs from the journal, once they are
+// marked.
+func dropClaims(infile string, euis map[eui64]string) error {
+	if _, err := os.Stat(claimJournal(infile)); os.IsNotExist(err) {
+		return nil
+	}
+	if taken, err := lockPool(infile); err != nil {
+		return err
+	} else if taken {
+		defer unlockPool(infile)
+	}
+
+	journal, err := readClaims(infile)
+	if err != nil {
+		return err
+	}
+	kept := make([]EuiClaim, 0, len(journal))
+	for _, c := range journal {
+		if e, err := parseEui(c.Eui); err == nil {
+			if _, ok := euis[e]; ok {
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	if len(kept) == len(journal) {
+		return nil
+	}
+	return writeClaims(infile, kept)
+}
+
+// rollbackClaims undoes the claims of a run that failed before marking, the
+// signature files it wrote are moved aside.
+func rollbackClaims(infile string, claims []EuiClaim) {
+	euis := make(map[eui64]string)
+	for _, c := range claims {
+		if err := rotateBackup(c.Sigfile); err != nil {
+			fmt.Printf("WARNING: failed to move aside %s: %s\n", c.Sigfile, err)
+		}
+		if e, err := parseEui(c.Eui); err == nil {
+			euis[e] = ""
+		}
+	}
+	if err := dropClaims(infile, euis); err != nil {
+		fmt.Printf("WARNING: failed to drop the claims from %s: %s\n", claimJournal(infile), err)
+	}
+}
+
+// claimComplete tells whether the signature file of a claim was written in
+// full, it returns the area when it was.
+func claimComplete(c EuiClaim) ([]byte, error) {
+	if _, err := os.Stat(c.Sigfile); os.IsNotExist(err) {
+		return nil, nil
+	}
+	if fileIsSealed(c.Sigfile) && g_sigdir_key == nil {
+		return nil, fmt.Errorf("interrupted run of %s left %s, the station key is needed to recover it (--sigdir-key)", c.Eui, c.Sigfile)
+	}
+	data, err := readSigdirFile(c.Sigfile)
+	if err != nil {
+		// Sealed and cut short
+		return nil, nil
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != c.Sha256 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// recoverClaims resumes or rolls back the interrupted runs of a pool, it
+// returns how many there were.
+func recoverClaims(infile string, rollback bool) (int, error) {
+	if g_eui_registry != nil {
+		return 0, nil
+	}
+	if _, err := os.Stat(claimJournal(infile)); os.IsNotExist(err) {
+		return 0, nil
+	}
+	if taken, err := lockPool(infile); err != nil {
+		return 0, err
+	} else if taken {
+		defer unlockPool(infile)
+	}
+
+	journal, err := readClaims(infile)
+	if err != nil || len(journal) == 0 {
+		return 0, err
+	}
+	entries, err := readPool(infile)
+	if err != nil {
+		return 0, err
+	}
+	free := make(map[eui64]bool)
+	for _, e := range entries {
+		free[e.Eui] = e.Status == POOL_FREE
+	}
+	pending, err := pendingMarks(infile)
+	if err != nil {
+		return 0, err
+	}
+
+	var gen UserSignature
+	kept := make([]EuiClaim, 0)
+	for _, c := range journal {
+		eui, err := parseEui(c.Eui)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", claimJournal(infile), err)
+		}
+		if _, queued := pending[eui]; !free[eui] || queued {
+			// Marked, the run stopped before it dropped the claim
+			continue
+		}
+
+		data, err := claimComplete(c)
+		if err != nil {
+			return 0, err
+		}
+		if data == nil || rollback {
+			if err := rotateBackup(c.Sigfile); err != nil {
+				kept = append(kept, c)
+				fmt.Printf("WARNING: rolling back the interrupted run of %016X failed: %s\n", eui, err)
+				continue
+			}
+			fmt.Printf("Rolled back the interrupted run of %016X, the EUI is free again\n", eui)
+			continue
+		}
+
+		id, err := gen.IdentityOf(data)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", c.Sigfile, err)
+		}
+		esig, bsig := id.EUI(), id.Board()
+		if esig == nil || bsig == nil {
+			return 0, fmt.Errorf("%s has no EUI64 or board signature to mark", c.Sigfile)
+		}
+		if err := writeMark(infile, eui, poolMark(*bsig, c.Tag), esig.Unix_time); err != nil {
+			return 0, err
+		}
+		registerSigfile(eui, c.Sigfile)
+		fmt.Printf("Resumed the interrupted run of %016X, marked from %s\n", eui, c.Sigfile)
+	}
+	return len(journal) - len(kept), writeClaims(infile, kept)
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "recover",
+		short:   "Resume or roll back interrupted runs of a pool",
+		long:    "Mark the EUIs of interrupted runs that wrote their signature files in full, roll back the rest.",
+		options: func() interface{} { return new(RecoverOptions) },
+		run:     func(c *commandRun) { recoverMain(c.options.(*RecoverOptions)) },
+	})
+}
+
+func recoverMain(opts *RecoverOptions) {
+	count, err := recoverClaims(opts.Args.Euifile, opts.Rollback)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	if count == 0 {
+		fmt.Printf("No interrupted runs in %s\n", opts.Args.Euifile)
+	}
+}
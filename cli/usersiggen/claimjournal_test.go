@@ -0,0 +1,100 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "strings"
+import "testing"
+import "path/filepath"
+
+// testClaims journals runs of three EUIs that were interrupted after writing
+// the signature file in full, part of it and none of it.
+func testClaims(t *testing.T) (string, []EuiClaim) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	pool := writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001\n70B3D5D72F000002\n70B3D5D72F000003\n"))
+	claims := make([]EuiClaim, 0)
+	for i, e := range []eui64{0x70B3D5D72F000001, 0x70B3D5D72F000002, 0x70B3D5D72F000003} {
+		area := testArea(t, e)
+		sigfile := filepath.Join(dir, fmt.Sprintf("EUI-64_%016X.bin", e))
+		switch i {
+		case 0:
+			writeTestFile(t, dir, filepath.Base(sigfile), area)
+		case 1:
+			writeTestFile(t, dir, filepath.Base(sigfile), area[:100])
+		}
+		claims = append(claims, newClaim(e, "line1", sigfile, area))
+	}
+	if err := claimEuis(pool, claims); err != nil {
+		t.Fatal(err)
+	}
+	return pool, claims
+}
+
+func TestRecoverClaims(t *testing.T) {
+	pool, claims := testClaims(t)
+	if journal, err := readClaims(pool); err != nil || len(journal) != 3 {
+		t.Fatalf("%d claims journaled: %v", len(journal), err)
+	}
+
+	var count int
+	var err error
+	out := captureStdout(t, func() { count, err = recoverClaims(pool, false) })
+	if err != nil || count != 3 {
+		t.Fatalf("%d runs recovered: %v", count, err)
+	}
+	for _, line := range []string{
+		"Resumed the interrupted run of 70B3D5D72F000001",
+		"Rolled back the interrupted run of 70B3D5D72F000002",
+		"Rolled back the interrupted run of 70B3D5D72F000003",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("no %q in\n%s", line, out)
+		}
+	}
+	data, _ := os.ReadFile(pool)
+	if lines := strings.Split(string(data), "\n"); !strings.HasPrefix(lines[0], "70B3D5D72F000001,tsb2") || lines[1] != "70B3D5D72F000002" || lines[2] != "70B3D5D72F000003" {
+		t.Errorf("pool is\n%s", data)
+	}
+	if _, err := os.Stat(claims[0].Sigfile); err != nil {
+		t.Errorf("the resumed signature file is gone: %s", err)
+	}
+	if _, err := os.Stat(claims[1].Sigfile); !os.IsNotExist(err) {
+		t.Errorf("the partial signature file is not moved aside")
+	}
+	if _, err := os.Stat(claimJournal(pool)); !os.IsNotExist(err) {
+		t.Errorf("the journal is left behind")
+	}
+	if count, err := recoverClaims(pool, false); count != 0 || err != nil {
+		t.Errorf("%d runs recovered again: %v", count, err)
+	}
+}
+
+// With --rollback even the complete runs are rolled back.
+func TestRecoverClaimsRollback(t *testing.T) {
+	pool, claims := testClaims(t)
+	captureStdout(t, func() {
+		if count, err := recoverClaims(pool, true); err != nil || count != 3 {
+			t.Fatalf("%d runs rolled back: %v", count, err)
+		}
+	})
+	if data, _ := os.ReadFile(pool); string(data) != "70B3D5D72F000001\n70B3D5D72F000002\n70B3D5D72F000003\n" {
+		t.Errorf("pool is\n%s", data)
+	}
+	if _, err := os.Stat(claims[0].Sigfile); !os.IsNotExist(err) {
+		t.Errorf("the signature file of a rolled back run is left")
+	}
+}
+
+func TestDropClaims(t *testing.T) {
+	pool, _ := testClaims(t)
+	if err := dropClaims(pool, map[eui64]string{0x70B3D5D72F000002: "tsb2"}); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := readClaims(pool)
+	if err != nil || len(journal) != 2 || journal[0].Eui != "70B3D5D72F000001" || journal[1].Eui != "70B3D5D72F000003" {
+		t.Errorf("claims left are %+v: %v", journal, err)
+	}
+}
@@ -0,0 +1,102 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "sort"
+
+import "github.com/jessevdk/go-flags"
+
+import "github.com/thinnect/euisiggen/cli/completion"
+
+// Subcommands of usersiggen. Every command registers itself from init in the
+// file that implements it, with its descriptions, a constructor for its
+// options, the function running it and, when it reaches the network, the check
+// refusing it in --offline mode. The parser gets them in the order of their
+// names.
+
+// commandRun is the subcommand given on the command line.
+type commandRun struct {
+	name    string
+	command string      // Subcommand of the command, empty when it has none
+	options interface{} // Options of the command, made by its options function
+	opts    *Options
+	parser  *flags.Parser
+}
+
+type subcommand struct {
+	name    string
+	short   string
+	long    string
+	options func() interface{}
+	run     func(c *commandRun)
+	offline func(c *commandRun) error
+}
+
+var g_subcommands = make(map[string]subcommand)
+
+func registerCommand(cmd subcommand) {
+	if _, ok := g_subcommands[cmd.name]; ok {
+		panic(fmt.Sprintf("command %s registered twice", cmd.name))
+	}
+	g_subcommands[cmd.name] = cmd
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(g_subcommands))
+	for name := range g_subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addCommands adds the registered commands to the parser, it returns the
+// options of each by name.
+func addCommands(parser *flags.Parser) map[string]interface{} {
+	options := make(map[string]interface{})
+	for _, name := range commandNames() {
+		cmd := g_subcommands[name]
+		options[name] = cmd.options()
+		parser.AddCommand(name, cmd.short, cmd.long, options[name])
+	}
+	return options
+}
+
+// activeCommand returns the subcommand on the parsed command line, nil when
+// there is none.
+func activeCommand(parser *flags.Parser, opts *Options, options map[string]interface{}) *commandRun {
+	if parser.Active == nil {
+		return nil
+	}
+	c := &commandRun{name: parser.Active.Name, options: options[parser.Active.Name], opts: opts, parser: parser}
+	if parser.Active.Active != nil {
+		c.command = parser.Active.Active.Name
+	}
+	return c
+}
+
+func (self *commandRun) run() {
+	g_subcommands[self.name].run(self)
+}
+
+// offline refuses the command when it needs the network.
+func (self *commandRun) offline() error {
+	if check := g_subcommands[self.name].offline; check != nil {
+		return check(self)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "completion",
+		short:   "Shell completion script",
+		long:    "Print a bash, zsh or fish completion script for the command.",
+		options: func() interface{} { return new(completion.Options) },
+		run: func(c *commandRun) {
+			completion.Main(c.options.(*completion.Options), completion.FromParser(c.parser))
+		},
+	})
+}
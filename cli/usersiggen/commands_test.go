@@ -0,0 +1,241 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "io"
+import "os"
+import "strings"
+import "testing"
+import "time"
+import "crypto/rand"
+import "crypto/x509"
+import "crypto/ed25519"
+import "encoding/pem"
+import "path/filepath"
+
+import "github.com/thinnect/euisiggen/cli/completion"
+
+// The signature areas and keys of the command tests.
+
+var g_test_time = time.Unix(1700000000, 0).UTC()
+
+// testUuid returns a UUID made of b.
+func testUuid(b byte) [16]byte {
+	var u [16]byte
+	for i := range u {
+		u[i] = b
+	}
+	return u
+}
+
+// testArea returns the signature area of a board with a platform and a
+// component.
+func testArea(t *testing.T, e eui64) []byte {
+	t.Helper()
+	var gen UserSignature
+	var serial [16]byte
+	copy(serial[:], "SN-0001")
+	records := make([]interface{}, 0)
+	esig, err := gen.ConstructEUISignature(g_test_time, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records = append(records, esig)
+	for _, c := range []struct {
+		name string
+		tp   uint8
+	}{
+		{"tsb2", SIGNATURE_TYPE_BOARD},
+		{"smenete", SIGNATURE_TYPE_PLATFORM},
+		{"sht31", SIGNATURE_TYPE_COMPONENT},
+	} {
+		csig, err := gen.ConstructComponentSignature(g_test_time, c.name, BoardVersion{Major: 1, Minor: 2, Assembly: 3}, testUuid(c.tp), testUuid(0xAA), serial, 0, c.tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, csig)
+	}
+	area := make([]byte, 0)
+	for _, r := range records {
+		data, err := gen.Serialize(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		area = append(area, data...)
+	}
+	return area
+}
+
+// writeTestFile writes data to name in dir and returns the path.
+func writeTestFile(t *testing.T, dir string, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0660); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestKeys writes a new Ed25519 key pair in PEM to dir, it returns the
+// files of the private and the public key.
+func writeTestKeys(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubder, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, dir, name+".pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		writeTestFile(t, dir, name+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubder}))
+}
+
+// captureStdout returns what f prints.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	w.Close()
+	return string(<-done)
+}
+
+// The commands of usersiggen, scripts run them by these names.
+var g_test_commands = []string{
+	"annotate", "archive", "backup", "calibration", "check", "completion",
+	"config", "decommission", "delivery", "eui-registry", "euiserver",
+	"explain", "export", "export-stats", "fixture", "fleet", "forecast",
+	"graphql", "grpc", "keyring", "ksy", "license-preflight", "loadtest",
+	"mark-queue", "patch", "pins", "policy", "pool-import", "pool-stats",
+	"presets", "provision", "read-device", "recover", "redact", "restore",
+	"rma",
+}
+
+func TestCommands(t *testing.T) {
+	if names := commandNames(); strings.Join(names, " ") != strings.Join(g_test_commands, " ") {
+		t.Fatalf("commands are\n%v\nwant\n%v", names, g_test_commands)
+	}
+
+	parser, options := newParser("usersiggen", new(Options))
+	for _, name := range g_test_commands {
+		cmd := parser.Find(name)
+		if cmd == nil {
+			t.Errorf("%s is not in the parser", name)
+			continue
+		}
+		if options[name] == nil || g_subcommands[name].run == nil {
+			t.Errorf("%s has no options or nothing to run", name)
+		}
+		if len(cmd.ShortDescription) == 0 || len(cmd.LongDescription) == 0 {
+			t.Errorf("%s is not described", name)
+		}
+	}
+
+	// Every parser has options of its own
+	_, again := newParser("usersiggen", new(Options))
+	if again["provision"] == options["provision"] {
+		t.Errorf("parsers share the options of the commands")
+	}
+}
+
+func TestActiveCommand(t *testing.T) {
+	var opts Options
+	parser, options := newParser("usersiggen", &opts)
+	if _, err := parser.ParseArgs([]string{"--read-sig", "sigdata.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	if active := activeCommand(parser, &opts, options); active != nil {
+		t.Errorf("%s is active without a command", active.name)
+	}
+
+	parser, options = newParser("usersiggen", &opts)
+	if _, err := parser.ParseArgs([]string{"fleet", "serve", "--listen", "192.0.2.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	active := activeCommand(parser, &opts, options)
+	if active == nil || active.name != "fleet" || active.command != "serve" {
+		t.Fatalf("active command is %+v", active)
+	}
+	if opts := active.options.(*FleetOptions); opts.Serve.Listen != "192.0.2.1:8080" {
+		t.Errorf("fleet serve --listen is %s", opts.Serve.Listen)
+	}
+}
+
+// The commands reaching the network are refused in --offline mode, the
+// others are not.
+func TestOfflineCommands(t *testing.T) {
+	defer func(offline bool) { g_offline = offline }(g_offline)
+	g_offline = true
+	for _, c := range []struct {
+		args    []string
+		refused bool
+	}{
+		{[]string{"fleet", "serve", "--listen", "192.0.2.1:8080"}, true},
+		{[]string{"fleet", "serve", "--listen", "127.0.0.1:8080"}, false},
+		{[]string{"fleet", "status", "--url", "http://fleet.example.com"}, true},
+		{[]string{"graphql", "--listen", ":8080"}, true},
+		{[]string{"euiserver", "--euifile", "eui.txt", "--listen", "localhost:8080"}, false},
+		{[]string{"loadtest", "--target", "http://staging.example.com"}, true},
+		{[]string{"config", "validate", "--check-urls", "prod.toml"}, true},
+		{[]string{"config", "validate", "prod.toml"}, false},
+		{[]string{"explain", "sigdata.bin"}, false},
+	} {
+		var opts Options
+		parser, options := newParser("usersiggen", &opts)
+		if _, err := parser.ParseArgs(c.args); err != nil {
+			t.Fatalf("%v: %s", c.args, err)
+		}
+		err := offlineCommand(&opts, activeCommand(parser, &opts, options))
+		if (err != nil) != c.refused {
+			t.Errorf("%v: %v", c.args, err)
+		}
+	}
+}
+
+// The completion knows every command and the values of the options.
+func TestCompletion(t *testing.T) {
+	parser, _ := newParser("usersiggen", new(Options))
+	root := completion.FromParser(parser)
+	for _, name := range g_test_commands {
+		if root.Find(name) == nil {
+			t.Errorf("%s is not completed", name)
+		}
+	}
+	if mq := root.Find("mark-queue"); mq == nil || len(mq.Commands) != 3 {
+		t.Errorf("mark-queue commands are not completed")
+	}
+	for _, shell := range completion.Shells {
+		script, err := completion.Script(shell, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(script, "pool-import") || !strings.Contains(script, "compliance") {
+			t.Errorf("%s completion is missing commands or values", shell)
+		}
+	}
+	if _, err := completion.Script("tcsh", root); err == nil {
+		t.Errorf("tcsh is completed")
+	}
+}
@@ -0,0 +1,58 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "io/ioutil"
+import "encoding/json"
+
+// complianceMain appends the compliance record of --type compliance to the
+// signature file.
+func complianceMain(gen *UserSignature, opts *Options, timestamp time.Time) {
+	if _, err := os.Stat(opts.Output); os.IsNotExist(err) {
+		fmt.Printf("ERROR initial signature file %s not found!\n", opts.Output)
+		os.Exit(1)
+	}
+
+	info := ComplianceInfo{
+		CountryOfOrigin: opts.CountryOfOrigin,
+		Regions:         opts.Region,
+		RedId:           opts.RedId,
+		FccId:           opts.FccId,
+		IcId:            opts.IcId,
+	}
+	if len(opts.ComplianceJson) > 0 {
+		b, err := ioutil.ReadFile(opts.ComplianceJson)
+		if err != nil {
+			fmt.Printf("ERROR reading compliance file: %s\n", err)
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(b, &info); err != nil {
+			fmt.Printf("ERROR parsing compliance file %s: %s\n", opts.ComplianceJson, err)
+			os.Exit(1)
+		}
+	}
+
+	csig, err := gen.ConstructComplianceSignature(timestamp, info)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+
+	csigdata, err := gen.Serialize(csig)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+
+	err = appendFile(opts.Output, csigdata)
+	if err != nil {
+		fmt.Printf("ERROR appending compliance data to file: %s\n", err)
+		os.Exit(1)
+	}
+	outFormatMain(opts)
+	os.Exit(0)
+}
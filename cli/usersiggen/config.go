@@ -0,0 +1,229 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "errors"
+import "strings"
+import "net/http"
+import "path/filepath"
+import "encoding/json"
+
+import "github.com/jessevdk/go-flags"
+
+import "github.com/thinnect/euisiggen/cli/config"
+
+// A station config file holds the options of a station so they do not have to
+// be repeated on every command line, the loading is in cli/config and shared
+// with euigen. The file is usersiggen.toml or usersiggen.yaml in the config
+// search path unless --config or USERSIGGEN_CONFIG names one.
+
+const CONFIG_TOOL = "usersiggen" // Names the file and the environment variables
+
+type ConfigValue = config.Value
+
+type ConfigValidateOptions struct {
+	CheckUrls bool `long:"check-urls" description:"Also check that the URLs in the config can be reached."`
+	Json      bool `long:"json"       description:"Output the effective config and the findings as JSON."`
+	Args      struct {
+		File string `positional-arg-name:"config"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type ConfigOptions struct {
+	Validate ConfigValidateOptions `command:"validate" description:"Check a station config and show the effective value of every option."`
+}
+
+func configOptions(parser *flags.Parser) []*flags.Option {
+	return config.Options(parser)
+}
+
+func applyConfig(parser *flags.Parser, file string) ([]ConfigValue, error) {
+	return config.Apply(parser, CONFIG_TOOL, file)
+}
+
+type ConfigFinding struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Problem string `json:"problem,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+func checkExists(path string) error {
+	_, err := os.Stat(path)
+	return err
+}
+
+func checkDir(path string) error {
+	info, err := os.Stat(path)
+	if err == nil && !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return err
+}
+
+func checkUuid(s string) error {
+	_, err := parseUuid(s)
+	return err
+}
+
+// Checks of the option values that refer to something.
+var g_config_checks = map[string]func(v string) error{
+	"euifile":         checkExists,
+	"id128-pool":      checkExists,
+	"compliance-json": checkExists,
+	"license-file":    checkExists,
+	"sigfile":         checkExists,
+	"datafile":        checkExists,
+	"manifest":        func(v string) error { _, err := loadDeviceManifest(v); return err },
+	"sigdir":          checkDir,
+	"preset-dir":      checkDir,
+	"seqdir":          checkDir,
+	"mark-journal":    func(v string) error { return checkDir(filepath.Dir(v)) },
+	"slots":           func(v string) error { _, err := loadPlatformDefinition(v); return err },
+	"sigdir-key":      func(v string) error { _, err := loadSigdirKey(v); return err },
+	"policy":          func(v string) error { _, err := readPolicy(v); return err },
+	"policy-key":      func(v string) error { _, err := loadEd25519Public(v); return err },
+	"keyring":         func(v string) error { _, err := readKeyring(v); return err },
+	"key-pass-file":   checkExists,
+	"eui-registry":    checkExists,
+	"plm-max-age":     func(v string) error { _, err := time.ParseDuration(v); return err },
+	"random-source":   checkExists,
+	"out-address":     func(v string) error { _, err := parseOutAddress(v); return err },
+	"display-tz":      func(v string) error { _, err := time.LoadLocation(v); return err },
+	"eui":             func(v string) error { _, err := parseEui(v); return err },
+	"uuid":            checkUuid,
+	"manufacturer":    checkUuid,
+	"serial-uuid":     checkUuid,
+	"id128-namespace": checkUuid,
+	"calibration":     func(v string) error { _, err := findCalibration(v); return err },
+	"calibration-source": func(v string) error {
+		if isUrl(v) {
+			return nil
+		}
+		return checkExists(v)
+	},
+}
+
+func isUrl(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func checkUrl(url string) (string, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Head(strings.Replace(url, "{eui}", "0000000000000000", -1))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Status, nil
+}
+
+// validateConfig checks the effective values, returns the findings and the
+// number of problems.
+func validateConfig(values []ConfigValue, urls bool) ([]ConfigFinding, int) {
+	findings := make([]ConfigFinding, 0)
+	problems := 0
+	for _, cv := range values {
+		s, ok := cv.Value.(string)
+		if !ok || len(s) == 0 {
+			continue
+		}
+		f := ConfigFinding{Name: cv.Name, Value: s}
+		if check, ok := g_config_checks[cv.Name]; ok {
+			if err := check(s); err != nil {
+				f.Problem = err.Error()
+			}
+		}
+		if isUrl(s) && len(f.Problem) == 0 {
+			if !urls {
+				f.Note = "not checked, --check-urls"
+			} else if status, err := checkUrl(s); err != nil {
+				f.Problem = fmt.Sprintf("not reachable: %s", err)
+			} else {
+				f.Note = "reachable, " + status
+			}
+		}
+		if len(f.Problem) > 0 {
+			problems++
+		}
+		if _, checked := g_config_checks[cv.Name]; checked || isUrl(s) {
+			findings = append(findings, f)
+		}
+	}
+	return findings, problems
+}
+
+// printEffectiveConfig prints the values as TOML, with where they came from.
+func printEffectiveConfig(values []ConfigValue) {
+	for _, cv := range values {
+		j, _ := json.Marshal(cv.Value)
+		source := cv.Source
+		if len(cv.Origin) > 0 {
+			source += " " + cv.Origin
+		}
+		fmt.Printf("%-48s # %s\n", fmt.Sprintf("%s = %s", cv.Name, j), source)
+	}
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "config",
+		short:   "Station config files",
+		long:    "Validate a station config file and show the effective value of every option and where it came from.",
+		options: func() interface{} { return new(ConfigOptions) },
+		run:     func(c *commandRun) { configMain(c.command, c.options.(*ConfigOptions), c.parser) },
+		offline: func(c *commandRun) error {
+			if c.command == "validate" && c.options.(*ConfigOptions).Validate.CheckUrls {
+				return errors.New("--check-urls needs the network, refused in --offline mode")
+			}
+			return nil
+		},
+	})
+}
+
+func configMain(command string, opts *ConfigOptions, parser *flags.Parser) {
+	switch command {
+	case "validate":
+		values, err := applyConfig(parser, opts.Validate.Args.File)
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+		findings, problems := validateConfig(values, opts.Validate.CheckUrls)
+
+		if opts.Validate.Json {
+			j, _ := json.MarshalIndent(struct {
+				File     string          `json:"file"`
+				Values   []ConfigValue   `json:"values"`
+				Findings []ConfigFinding `json:"findings"`
+				Problems int             `json:"problems"`
+			}{opts.Validate.Args.File, values, findings, problems}, "", "	")
+			fmt.Println(string(j))
+		} else {
+			printEffectiveConfig(values)
+			fmt.Println()
+			for _, f := range findings {
+				if len(f.Problem) > 0 {
+					fmt.Printf("ERROR %s = %s: %s\n", f.Name, f.Value, f.Problem)
+				} else if len(f.Note) > 0 {
+					fmt.Printf("OK    %s = %s (%s)\n", f.Name, f.Value, f.Note)
+				} else {
+					fmt.Printf("OK    %s = %s\n", f.Name, f.Value)
+				}
+			}
+			if problems == 0 {
+				fmt.Printf("%s is valid\n", opts.Validate.Args.File)
+			}
+		}
+		if problems > 0 {
+			if !opts.Validate.Json {
+				fmt.Printf("ERROR %d problems in %s\n", problems, opts.Validate.Args.File)
+			}
+			os.Exit(4)
+		}
+	}
+}
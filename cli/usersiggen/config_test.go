@@ -0,0 +1,74 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "strings"
+import "testing"
+
+// configValues parses the command line and applies the config to it.
+func configValues(t *testing.T, args []string, file string) ([]ConfigValue, error) {
+	t.Helper()
+	parser, _ := newParser("usersiggen", new(Options))
+	if _, err := parser.ParseArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	return applyConfig(parser, file)
+}
+
+// Flags beat the environment, the environment beats the file, and the file
+// values that refer to something are checked.
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	euifile := writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001\n"))
+	t.Setenv("USERSIGGEN_PLM_MAX_AGE", "1h")
+	for _, file := range []string{
+		writeTestFile(t, dir, "usersiggen.toml", []byte("euifile = '"+euifile+"'\nsigdir = 'station'\nplm-max-age = '2h'\neui = '70B3D5D72F00000X'\ndisplay-tz = 'Europe/Tallinn'\nplm-url = 'http://plm.example.com/parts/{part}'\nkeep-backups = 3\npreset-dir = '"+dir+"'\n")),
+		writeTestFile(t, dir, "usersiggen.yaml", []byte("euifile: "+euifile+"\nsigdir: station\nplm-max-age: 2h\neui: 70B3D5D72F00000X\ndisplay-tz: Europe/Tallinn\nplm-url: http://plm.example.com/parts/{part}\nkeep-backups: 3\npreset-dir: "+dir+"\n")),
+	} {
+		values, err := configValues(t, []string{"--sigdir", dir, "config", "validate", file}, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName := make(map[string]ConfigValue)
+		for _, cv := range values {
+			byName[cv.Name] = cv
+		}
+		for name, want := range map[string]ConfigValue{
+			"sigdir":       {Value: dir, Source: "flag"},
+			"plm-max-age":  {Value: "1h", Source: "env", Origin: "USERSIGGEN_PLM_MAX_AGE"},
+			"euifile":      {Value: euifile, Source: "file", Origin: file},
+			"keep-backups": {Value: 3, Source: "file", Origin: file},
+			"plm-cache":    {Value: "plm-cache.json", Source: "default"},
+		} {
+			cv := byName[name]
+			if cv.Source != want.Source || cv.Origin != want.Origin || cv.Value != want.Value {
+				t.Errorf("%s is %+v, want %+v", name, cv, want)
+			}
+		}
+
+		findings, problems := validateConfig(values, false)
+		checked := make(map[string]ConfigFinding)
+		for _, f := range findings {
+			checked[f.Name] = f
+		}
+		if problems != 1 || !strings.Contains(checked["eui"].Problem, "70B3D5D72F00000X") {
+			t.Errorf("%d problems: %+v", problems, findings)
+		}
+		if f := checked["plm-url"]; len(f.Problem) > 0 || !strings.Contains(f.Note, "--check-urls") {
+			t.Errorf("plm-url is %+v", f)
+		}
+		if f, ok := checked["euifile"]; !ok || len(f.Problem) > 0 {
+			t.Errorf("euifile is %+v", f)
+		}
+	}
+
+	unknown := writeTestFile(t, dir, "typo.toml", []byte("eui-file = 'euis.txt'\n"))
+	if _, err := configValues(t, []string{"config", "validate", unknown}, unknown); err == nil || !strings.Contains(err.Error(), "unknown options eui-file") {
+		t.Errorf("unknown option: %v", err)
+	}
+	single := writeTestFile(t, dir, "single.toml", []byte("euifile = ['a.txt', 'b.txt']\n"))
+	if _, err := configValues(t, []string{"config", "validate", single}, single); err == nil || !strings.Contains(err.Error(), "single value") {
+		t.Errorf("list for a single value: %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "sort"
+import "bufio"
+import "regexp"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+import "time"
+
+// EUIs of customers who bring their own IEEE blocks. The customer and its
+// blocks are declared in the euifile header next to the tags:
+//
+//   # customer acme work-orders=ACME-*,WO-2231
+//   # customer-block acme 70B3D5A000000000 70B3D5A0000000FF
+//
+// EUIs in the blocks of a customer only go to the work orders of the
+// customer, and those work orders only take EUIs from its blocks. The
+// declarations and the pool entries are written by pool-import from the
+// list the customer supplies.
+
+type PoolCustomer struct {
+	Name       string
+	WorkOrders []string // Patterns of the work order names, path.Match syntax
+	Blocks     []PoolRange
+}
+
+type PoolCustomers map[string]*PoolCustomer
+
+type PoolImportOptions struct {
+	Customer   string `long:"customer"    required:"true" description:"Customer the EUIs belong to."`
+	WorkOrders string `long:"work-orders" description:"Work orders of the customer, comma separated patterns like ACME-*. Needed for a new customer."`
+	Column     int    `long:"column"      description:"Column of the EUIs, counted from 1, the first column with an EUI if not given."`
+	DryRun     bool   `long:"dry-run"     description:"Only check the list, do not touch the pool."`
+	Args       struct {
+		Euifile string `positional-arg-name:"eui.txt"`
+		List    string `positional-arg-name:"list.csv"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// parseCustomerBlock parses the fields of a customer-block declaration.
+func parseCustomerBlock(infile string, fields []string) (PoolRange, error) {
+	if len(fields) != 4 {
+		return PoolRange{}, fmt.Errorf("customer-block of %s in %s is not 'customer-block <customer> <first> <last>'", fields[1], infile)
+	}
+	first, err := parseEui(fields[2])
+	if err != nil {
+		return PoolRange{}, fmt.Errorf("customer-block of %s: %s", fields[1], err)
+	}
+	last, err := parseEui(fields[3])
+	if err != nil {
+		return PoolRange{}, fmt.Errorf("customer-block of %s: %s", fields[1], err)
+	}
+	if first > last {
+		return PoolRange{}, fmt.Errorf("customer-block of %s: %016X-%016X is inverted", fields[1], first, last)
+	}
+	return PoolRange{First: first, Last: last}, nil
+}
+
+// readPoolCustomers parses the customer declarations of an euifile.
+func readPoolCustomers(infile string) (PoolCustomers, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	customers := make(PoolCustomers)
+	blocks := make(map[string][]PoolRange)
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(t, "#") {
+			continue
+		}
+		fields := strings.Fields(t[1:])
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "customer":
+			c := &PoolCustomer{Name: fields[1]}
+			if _, ok := customers[c.Name]; ok {
+				return nil, fmt.Errorf("customer %s is declared twice in %s", c.Name, infile)
+			}
+			for _, f := range fields[2:] {
+				if !strings.HasPrefix(f, "work-orders=") {
+					return nil, fmt.Errorf("customer %s: unknown key %s", c.Name, f)
+				}
+				c.WorkOrders = strings.Split(strings.TrimPrefix(f, "work-orders="), ",")
+				for _, wo := range c.WorkOrders {
+					if _, err := filepath.Match(wo, ""); err != nil {
+						return nil, fmt.Errorf("customer %s: work order pattern %s: %s", c.Name, wo, err)
+					}
+				}
+			}
+			customers[c.Name] = c
+		case "customer-block":
+			b, err := parseCustomerBlock(infile, fields)
+			if err != nil {
+				return nil, err
+			}
+			blocks[fields[1]] = append(blocks[fields[1]], b)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, bs := range blocks {
+		c, ok := customers[name]
+		if !ok {
+			return nil, fmt.Errorf("customer-block of %s, the customer is not declared in %s", name, infile)
+		}
+		c.Blocks = bs
+	}
+	for _, c := range customers {
+		for _, b := range c.Blocks {
+			for _, o := range customers {
+				for _, ob := range o.Blocks {
+					if o != c && b.First <= ob.Last && ob.First <= b.Last {
+						return nil, fmt.Errorf("customer-block %016X-%016X of %s overlaps a block of %s", b.First, b.Last, c.Name, o.Name)
+					}
+				}
+			}
+		}
+	}
+	return customers, nil
+}
+
+// owner returns the customer whose block holds the EUI, empty for our own.
+func (customers PoolCustomers) owner(e eui64) string {
+	for _, c := range customers {
+		for _, b := range c.Blocks {
+			if b.First <= e && e <= b.Last {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+// ofWorkOrder returns the customer of a work order, empty for our own.
+func (customers PoolCustomers) ofWorkOrder(work_order string) string {
+	if len(work_order) == 0 {
+		return ""
+	}
+	for _, c := range customers {
+		for _, wo := range c.WorkOrders {
+			if ok, _ := filepath.Match(wo, work_order); ok {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+// eligible tells if a free EUI may go to a build of the work order.
+func (customers PoolCustomers) eligible(e eui64, work_order string) bool {
+	return customers.owner(e) == customers.ofWorkOrder(work_order)
+}
+
+// Spreadsheet exports write the EUIs in every way, with or without
+// separators, quoted, as 0x numbers. Excel turns a long EUI made of digits
+// into a number and shows it in scientific notation, that can not be undone.
+var g_excel_number = regexp.MustCompile(`^[0-9](\.[0-9]+)?E\+[0-9]+$`)
+
+// importEui parses an EUI of a customer list, ok is false when the field is
+// not meant to be one.
+func importEui(field string) (eui64, bool, error) {
+	f := strings.ToUpper(strings.Trim(strings.TrimSpace(field), `"'`))
+	if g_excel_number.MatchString(f) {
+		return 0, true, fmt.Errorf("%s is an EUI turned into a number by a spreadsheet, export the column as text", field)
+	}
+	f = strings.TrimPrefix(f, "0X")
+	f = strings.NewReplacer("-", "", ":", "", " ", "", ".", "").Replace(f)
+	if len(f) != 16 {
+		return 0, false, nil
+	}
+	e, err := parseEui(f)
+	if err != nil {
+		return 0, false, nil
+	}
+	return e, true, nil
+}
+
+// splitRow splits a row of a CSV, semicolon separated or tab separated
+// export.
+func splitRow(row string) []string {
+	for _, sep := range []string{"\t", ";", ","} {
+		if strings.Contains(row, sep) {
+			return strings.Split(row, sep)
+		}
+	}
+	return []string{row}
+}
+
+// readCustomerList reads the EUIs of a customer list. A row without an EUI
+// is a header when it comes before any EUI and an error after.
+func readCustomerList(file string, column int) ([]eui64, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	euis := make([]eui64, 0)
+	seen := make(map[eui64]int)
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for line := 1; scanner.Scan(); line++ {
+		row := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if len(row) == 0 || strings.HasPrefix(row, "#") {
+			continue
+		}
+		fields := splitRow(row)
+		if column > len(fields) {
+			return nil, fmt.Errorf("%s line %d has %d columns, not %d", file, line, len(fields), column)
+		}
+		if column > 0 {
+			fields = fields[column-1 : column]
+		}
+
+		found := false
+		for _, f := range fields {
+			e, ok, err := importEui(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %s", file, line, err)
+			}
+			if !ok {
+				continue
+			}
+			if first, dup := seen[e]; dup {
+				return nil, fmt.Errorf("%s line %d: %016X is already on line %d", file, line, e, first)
+			}
+			seen[e] = line
+			euis = append(euis, e)
+			found = true
+			break
+		}
+		if !found {
+			if len(euis) == 0 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("%s line %d: no EUI-64 in %q, EUIs are 16 hex digits, with or without - or : between the bytes", file, line, row)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(euis) == 0 {
+		return nil, fmt.Errorf("no EUI-64s in %s", file)
+	}
+	return euis, nil
+}
+
+// euiBlocks returns the runs of consecutive EUIs.
+func euiBlocks(euis []eui64) []PoolRange {
+	sorted := append([]eui64{}, euis...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	blocks := make([]PoolRange, 0)
+	for _, e := range sorted {
+		if n := len(blocks); n > 0 && blocks[n-1].Last+1 == e {
+			blocks[n-1].Last = e
+		} else {
+			blocks = append(blocks, PoolRange{First: e, Last: e})
+		}
+	}
+	return blocks
+}
+
+// checkImport refuses EUIs the pool already has or that fall in our own
+// ranges or the blocks of another customer.
+func checkImport(infile string, customer string, euis []eui64) error {
+	entries, err := readPool(infile)
+	if err != nil {
+		return err
+	}
+	ranges, err := readPoolRanges(infile)
+	if err != nil {
+		return err
+	}
+	customers, err := readPoolCustomers(infile)
+	if err != nil {
+		return err
+	}
+	tags, err := readPoolTags(infile)
+	if err != nil {
+		return err
+	}
+
+	pooled := make(map[eui64]bool)
+	for _, e := range entries {
+		pooled[e.Eui] = true
+	}
+	for _, e := range euis {
+		if pooled[e] {
+			return fmt.Errorf("%016X is already in %s", e, infile)
+		}
+		for _, r := range ranges {
+			if r.First <= e && e <= r.Last {
+				return fmt.Errorf("%016X is in our own range %016X - %016X of %s", e, r.First, r.Last, infile)
+			}
+		}
+		if owner := customers.owner(e); len(owner) > 0 && owner != customer {
+			return fmt.Errorf("%016X is in a block of customer %s", e, owner)
+		}
+		if owner := tags.owner(e); len(owner) > 0 {
+			return fmt.Errorf("%016X is in the range of tag %s", e, owner)
+		}
+	}
+	return nil
+}
+
+func poolImport(opts *PoolImportOptions) error {
+	if strings.ContainsAny(opts.Customer, " \t,=#") {
+		return fmt.Errorf("customer name %q can not contain spaces, commas, = or #", opts.Customer)
+	}
+	customers, err := readPoolCustomers(opts.Args.Euifile)
+	if err != nil {
+		return err
+	}
+	c, known := customers[opts.Customer]
+	if !known && len(opts.WorkOrders) == 0 {
+		return fmt.Errorf("customer %s is new to %s, its --work-orders are needed", opts.Customer, opts.Args.Euifile)
+	}
+	if known && len(opts.WorkOrders) > 0 && opts.WorkOrders != strings.Join(c.WorkOrders, ",") {
+		return fmt.Errorf("customer %s has work orders %s in %s, edit the declaration to change them", opts.Customer, strings.Join(c.WorkOrders, ","), opts.Args.Euifile)
+	}
+	for _, wo := range strings.Split(opts.WorkOrders, ",") {
+		if other := customers.ofWorkOrder(wo); len(wo) > 0 && len(other) > 0 && other != opts.Customer {
+			return fmt.Errorf("work order %s already belongs to customer %s", wo, other)
+		}
+	}
+
+	euis, err := readCustomerList(opts.Args.List, opts.Column)
+	if err != nil {
+		return err
+	}
+	if err := checkImport(opts.Args.Euifile, opts.Customer, euis); err != nil {
+		return err
+	}
+	blocks := euiBlocks(euis)
+	fmt.Printf("%d EUI-64s of %s in %d blocks\n", len(euis), opts.Customer, len(blocks))
+	if opts.DryRun {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(opts.Args.Euifile)
+	if err != nil {
+		return err
+	}
+	w := new(strings.Builder)
+	w.Write(b)
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		w.WriteString("\n")
+	}
+	fmt.Fprintf(w, "# Imported %d EUI-64s of customer %s from %s, %s\n", len(euis), opts.Customer, filepath.Base(opts.Args.List), time.Now().UTC().Format(time.RFC3339))
+	if !known {
+		fmt.Fprintf(w, "# customer %s work-orders=%s\n", opts.Customer, opts.WorkOrders)
+	}
+	for _, b := range blocks {
+		fmt.Fprintf(w, "# customer-block %s %016X %016X\n", opts.Customer, b.First, b.Last)
+	}
+	for _, e := range euis {
+		fmt.Fprintf(w, "%016X,\n", e)
+	}
+
+	tmp := opts.Args.Euifile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(w.String()), 0660); err != nil {
+		return err
+	}
+	if err := rotateBackup(opts.Args.Euifile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, opts.Args.Euifile); err != nil {
+		return err
+	}
+	fmt.Printf("Added to %s\n", opts.Args.Euifile)
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "pool-import",
+		short:   "Import the EUIs of a customer",
+		long:    "Add a customer supplied EUI list, CSV, semicolon or tab separated, to the pool. The EUIs only go to the work orders of the customer.",
+		options: func() interface{} { return new(PoolImportOptions) },
+		run:     func(c *commandRun) { poolImportMain(c.options.(*PoolImportOptions)) },
+	})
+}
+
+func poolImportMain(opts *PoolImportOptions) {
+	if err := poolImport(opts); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
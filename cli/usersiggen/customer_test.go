@@ -0,0 +1,144 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "strings"
+import "testing"
+
+func TestImportEui(t *testing.T) {
+	for field, want := range map[string]eui64{
+		"70B3D5A000000001":           0x70B3D5A000000001,
+		` "70-b3-d5-a0-00-00-00-02"`: 0x70B3D5A000000002,
+		"70:B3:D5:A0:00:00:00:03":    0x70B3D5A000000003,
+		"0x70B3D5A000000004":         0x70B3D5A000000004,
+		"'70B3.D5A0.0000.0005'":      0x70B3D5A000000005,
+	} {
+		if e, ok, err := importEui(field); err != nil || !ok || e != want {
+			t.Errorf("%q is %016X, %v, %v", field, e, ok, err)
+		}
+	}
+	for _, field := range []string{"serial", "70B3D5A0000001", "Device EUI"} {
+		if _, ok, err := importEui(field); ok || err != nil {
+			t.Errorf("%q is an EUI: %v", field, err)
+		}
+	}
+	if _, _, err := importEui("7.0E+15"); err == nil || !strings.Contains(err.Error(), "spreadsheet") {
+		t.Errorf("an EUI turned into a number: %v", err)
+	}
+}
+
+func TestReadCustomerList(t *testing.T) {
+	dir := t.TempDir()
+	list := writeTestFile(t, dir, "acme.csv", []byte("\ufeffserial;eui\nA1;70B3D5A000000001\nA2;70B3D5A000000002\n\nA3;70B3D5A000000010\n"))
+	euis, err := readCustomerList(list, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(euis) != 3 || euis[2] != 0x70B3D5A000000010 {
+		t.Errorf("EUIs are %v", euis)
+	}
+	blocks := euiBlocks(euis)
+	if len(blocks) != 2 || blocks[0] != (PoolRange{0x70B3D5A000000001, 0x70B3D5A000000002}) || blocks[1] != (PoolRange{0x70B3D5A000000010, 0x70B3D5A000000010}) {
+		t.Errorf("blocks are %+v", blocks)
+	}
+
+	for content, problem := range map[string]string{
+		"70B3D5A000000001\n70B3D5A000000001\n": "already on line 1",
+		"70B3D5A000000001\nA2\n":               "line 2: no EUI-64",
+		"eui\n":                                "no EUI-64s",
+	} {
+		if _, err := readCustomerList(writeTestFile(t, dir, "bad.csv", []byte(content)), 0); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%q: %v, want %s", content, err, problem)
+		}
+	}
+	if _, err := readCustomerList(list, 3); err == nil {
+		t.Errorf("read column 3 of two")
+	}
+}
+
+// The imported EUIs become a customer block that only the work orders of the
+// customer take EUIs from.
+func TestPoolImport(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	opts := &PoolImportOptions{Customer: "acme", WorkOrders: "ACME-*"}
+	opts.Args.Euifile = writeTestFile(t, dir, "euis.txt", []byte("# EUI-64 range 70B3D5D72F000000 - 70B3D5D72F0000FF, 2026-01-01\n70B3D5D72F000001\n"))
+	opts.Args.List = writeTestFile(t, dir, "acme.csv", []byte("70B3D5A000000001\n70B3D5A000000002\n"))
+	captureStdout(t, func() {
+		if err := poolImport(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	customers, err := readPoolCustomers(opts.Args.Euifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := customers["acme"]
+	if acme == nil || len(acme.Blocks) != 1 || acme.Blocks[0] != (PoolRange{0x70B3D5A000000001, 0x70B3D5A000000002}) {
+		t.Fatalf("customers are %+v", customers)
+	}
+	if !customers.eligible(0x70B3D5A000000001, "ACME-17") || customers.eligible(0x70B3D5A000000001, "WO-1") || customers.eligible(0x70B3D5D72F000001, "ACME-17") || !customers.eligible(0x70B3D5D72F000001, "") {
+		t.Errorf("the EUIs of acme are not kept to its work orders")
+	}
+	if bounds, _ := readPoolBounds(opts.Args.Euifile); checkPoolBounds(opts.Args.Euifile, bounds, 0x70B3D5A000000002) != nil {
+		t.Errorf("an imported EUI is out of the bounds of the pool")
+	}
+	entries, err := readPool(opts.Args.Euifile)
+	if err != nil || len(entries) != 3 || entries[2].Status != POOL_FREE {
+		t.Errorf("pool entries are %+v: %v", entries, err)
+	}
+
+	// Imported again, in our own range, for another customer's work orders
+	pool, _ := os.ReadFile(opts.Args.Euifile)
+	for _, c := range []struct {
+		customer string
+		orders   string
+		list     string
+		problem  string
+	}{
+		{"acme", "", "70B3D5A000000001\n", "is already in"},
+		{"acme", "", "70B3D5D72F000010\n", "in our own range"},
+		{"globex", "ACME-1", "70B3D5B000000001\n", "already belongs to customer acme"},
+		{"globex", "", "70B3D5B000000001\n", "its --work-orders are needed"},
+		{"acme", "WO-*", "70B3D5B000000001\n", "edit the declaration"},
+	} {
+		opts := &PoolImportOptions{Customer: c.customer, WorkOrders: c.orders}
+		opts.Args.Euifile = writeTestFile(t, dir, "euis.txt", pool)
+		opts.Args.List = writeTestFile(t, dir, "list.csv", []byte(c.list))
+		if err := poolImport(opts); err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("%s %s %q: %v, want %s", c.customer, c.orders, c.list, err, c.problem)
+		}
+	}
+
+	// A dry run leaves the pool as it was
+	opts = &PoolImportOptions{Customer: "acme", DryRun: true}
+	opts.Args.Euifile = writeTestFile(t, dir, "euis.txt", pool)
+	opts.Args.List = writeTestFile(t, dir, "list.csv", []byte("70B3D5A000000003\n"))
+	captureStdout(t, func() {
+		if err := poolImport(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if data, _ := os.ReadFile(opts.Args.Euifile); string(data) != string(pool) {
+		t.Errorf("a dry run changed the pool")
+	}
+}
+
+func TestPoolCustomerErrors(t *testing.T) {
+	dir := t.TempDir()
+	for header, problem := range map[string]string{
+		"# customer acme\n# customer acme\n":                                         "declared twice",
+		"# customer acme owner=me\n":                                                 "unknown key",
+		"# customer-block acme 70B3D5A000000000 70B3D5A0000000FF\n":                  "not declared",
+		"# customer acme\n# customer-block acme 70B3D5A0000000FF 70B3D5A000000000\n": "inverted",
+		"# customer acme\n# customer-block acme 70B3D5A000000000\n":                  "is not 'customer-block",
+		"# customer acme\n# customer globex\n# customer-block acme 70B3D5A000000000 70B3D5A0000000FF\n# customer-block globex 70B3D5A0000000F0 70B3D5A0000001FF\n": "overlaps",
+	} {
+		if _, err := readPoolCustomers(writeTestFile(t, dir, "euis.txt", []byte(header))); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%q: %v, want %s", header, err, problem)
+		}
+	}
+}
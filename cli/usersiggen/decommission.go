@@ -0,0 +1,391 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bufio"
+import "errors"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/hex"
+import "encoding/json"
+import "crypto/ed25519"
+import "time"
+
+import "go.thinnect.net/euisig/signature"
+
+// Decommissioning closes the life of an identity. The end-of-life record says
+// why, when and on whose authority a device was taken out of service and is
+// signed with the Ed25519 key of the authority. The EUI is retired in the
+// pool, so it is never handed out or marked again, and the licenses issued
+// for it are withdrawn in the registry.
+//
+// The same record can be written to the device before disposal, a decoder
+// reading the signature area of a returned device then knows it was retired:
+//
+//	header | eui u64 | reason u8 | authority [16] | ed25519 signature [64] | crc
+//
+// The signature covers everything before it.
+
+const SCHEMA_DECOMMISSION = "euisiggen/decommission/v1"
+
+// DecommissionRecord is the end-of-life record kept with the production
+// state. The signature is over the JSON of the record with an empty
+// signature field.
+type DecommissionRecord struct {
+	Schema    string          `json:"schema"`
+	Generator string          `json:"generator"`
+	Eui64     string          `json:"eui64"`
+	Reason    string          `json:"reason"`
+	Date      time.Time       `json:"date"`
+	Authority string          `json:"authority"`
+	Note      string          `json:"note,omitempty"`
+	Pool      string          `json:"pool,omitempty"` // Pool the EUI was retired in
+	Revoked   []IssuedLicense `json:"revoked"`        // Licenses withdrawn in the registry
+	Device    string          `json:"device"`         // Record for the device, hex
+	Signature string          `json:"signature"`
+}
+
+func (self *DecommissionRecord) signed() []byte {
+	rec := *self
+	rec.Signature = ""
+	b, _ := json.Marshal(rec)
+	return b
+}
+
+type DecommissionIssueOptions struct {
+	Eui       string `long:"eui"       required:"true" description:"EUI-64 of the device."`
+	Reason    string `long:"reason"    required:"true" values:"end-of-life,failed,returned,lost,compromised,other" description:"Why the device is taken out of service."`
+	Authority string `long:"authority" required:"true" description:"Who decided it, up to 16 characters, recorded on the device too."`
+	Date      string `long:"date"      description:"Date of decommissioning, YYYY-MM-DD or RFC 3339, now when not given."`
+	Note      string `long:"note"      description:"Free text kept in the record, not written to the device."`
+	Key       string `long:"key"       required:"true" description:"Ed25519 private key of the authority, PKCS#8 PEM."`
+	Euifile   string `long:"euifile"   description:"Pool to retire the EUI in."`
+	Registry  string `long:"registry"  description:"License registry to withdraw the licenses of the EUI in."`
+	Sigdir    string `long:"sigdir"    description:"Directory of the device signatures, the device must have one and the record is kept next to it."`
+	Record    string `long:"record"    description:"End-of-life record, EUI-64_<eui>_decommission.json in the sigdir by default."`
+	Out       string `long:"out"       description:"Also write the record for the device, to append to its signature area before disposal."`
+}
+
+type DecommissionVerifyOptions struct {
+	Record string `long:"record" required:"true" description:"End-of-life record."`
+	Pubkey string `long:"pubkey" required:"true" description:"Ed25519 public key of the authority, PEM."`
+	In     string `long:"in"     description:"Also check the decommission record in this signature area against it."`
+}
+
+type DecommissionOptions struct {
+	Issue  DecommissionIssueOptions  `command:"issue"  description:"Issue a signed end-of-life record, retire the EUI and withdraw its licenses."`
+	Verify DecommissionVerifyOptions `command:"verify" description:"Verify an end-of-life record and the record written to a device."`
+}
+
+func parseDecommissionDate(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Now().UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("date %s is neither YYYY-MM-DD nor RFC 3339", s)
+	}
+	return t.UTC(), nil
+}
+
+// retireEui marks a used EUI retired in the pool, the pool is replaced like
+// for a mark.
+func retireEui(infile string, eui eui64, ts int64) error {
+	if g_eui_registry != nil {
+		return g_eui_registry.Retire(eui, ts)
+	}
+	infile, err := filepath.Abs(infile)
+	if err != nil {
+		return err
+	}
+	if taken, err := lockPool(infile); err != nil {
+		return err
+	} else if taken {
+		defer unlockPool(infile)
+	}
+
+	in, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	outfile := filepath.Join(filepath.Dir(infile), fmt.Sprintf("eui_temp_%d.txt", ts))
+	out, err := os.OpenFile(outfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	writer := bufio.NewWriter(out)
+
+	var problem error = fmt.Errorf("%016X is not in %s", eui, infile)
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) > 0 && !strings.HasPrefix(t, "#") {
+			splits := strings.Split(t, ",")
+			if val, err := parseEui(splits[0]); err == nil && val == eui {
+				if len(splits) > 7 {
+					problem = fmt.Errorf("%016X is already retired in %s", eui, infile)
+				} else if len(splits) < 3 {
+					problem = fmt.Errorf("%016X was never marked in %s, only used EUIs can be retired", eui, infile)
+				} else {
+					for len(splits) < 7 {
+						splits = append(splits, "")
+					}
+					t = strings.Join(append(splits, fmt.Sprintf("%s%d", POOL_RETIRED_PREFIX, ts)), ",")
+					problem = nil
+				}
+			}
+			writer.WriteString(t)
+		} else {
+			writer.WriteString(scanner.Text())
+		}
+		writer.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		problem = err
+	}
+	if problem == nil {
+		problem = writer.Flush()
+	}
+	if problem != nil {
+		// A partial read must not replace the pool
+		os.Remove(outfile)
+		return problem
+	}
+
+	in.Close()
+	out.Close()
+
+	if err := rotateBackup(infile); err != nil {
+		return err
+	}
+	return os.Rename(outfile, infile)
+}
+
+// checkRetirable refuses EUIs the pool does not have as used, before anything
+// gets written.
+func checkRetirable(infile string, eui eui64) error {
+	entries, err := readPool(infile)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Eui != eui {
+			continue
+		}
+		switch e.Status {
+		case POOL_USED:
+			return nil
+		case POOL_RETIRED:
+			return fmt.Errorf("%016X was already retired in %s at %s", eui, infile, TimestampString(e.Retired))
+		}
+		return fmt.Errorf("%016X is %s in %s, only used EUIs can be retired", eui, e.Status, infile)
+	}
+	return fmt.Errorf("%016X is not in %s", eui, infile)
+}
+
+func decommissionIssue(opts *DecommissionIssueOptions) error {
+	var gen UserSignature
+
+	eui, err := parseEui(opts.Eui)
+	if err != nil {
+		return err
+	}
+	reason, err := signature.ParseReason(opts.Reason)
+	if err != nil {
+		return err
+	}
+	date, err := parseDecommissionDate(opts.Date)
+	if err != nil {
+		return err
+	}
+	key, err := loadSigningKey(opts.Key, "decommission")
+	if err != nil {
+		return err
+	}
+
+	record := opts.Record
+	if len(opts.Sigdir) > 0 {
+		sigfile := filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui))
+		if _, err := os.Stat(sigfile); err != nil {
+			return fmt.Errorf("no device signature for %016X in %s, never manufactured?", eui, opts.Sigdir)
+		}
+		if len(record) == 0 {
+			record = filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X_decommission.json", eui))
+		}
+	}
+	if len(record) == 0 {
+		return errors.New("no place for the record, give --record or --sigdir")
+	}
+	if _, err := os.Stat(record); err == nil {
+		return fmt.Errorf("%s already exists, %016X has been decommissioned", record, eui)
+	}
+	if len(opts.Euifile) > 0 {
+		if err := checkRetirable(opts.Euifile, eui); err != nil {
+			return err
+		}
+	}
+
+	revoked := make([]IssuedLicense, 0)
+	if len(opts.Registry) > 0 {
+		if revoked, err = findIssuedLicenses(opts.Registry, eui); err != nil {
+			return err
+		}
+	}
+
+	dsig, err := gen.ConstructDecommissionSignature(date, eui, reason, opts.Authority, key)
+	if err != nil {
+		return err
+	}
+	device, err := gen.Serialize(dsig)
+	if err != nil {
+		return err
+	}
+
+	rec := DecommissionRecord{
+		Schema:    SCHEMA_DECOMMISSION,
+		Generator: generatorVersion(),
+		Eui64:     fmt.Sprintf("%016X", eui),
+		Reason:    reason.String(),
+		Date:      date,
+		Authority: opts.Authority,
+		Note:      opts.Note,
+		Pool:      opts.Euifile,
+		Revoked:   revoked,
+		Device:    hex.EncodeToString(device),
+	}
+	rec.Signature = hex.EncodeToString(ed25519.Sign(key, rec.signed()))
+
+	b, err := json.MarshalIndent(rec, "", "	")
+	if err != nil {
+		return err
+	}
+	if err := writeSigdirFile(record, append(b, '\n'), 0440); err != nil {
+		return err
+	}
+	fmt.Printf("Decommission record %s\n", record)
+
+	if len(opts.Out) > 0 {
+		if err := ioutil.WriteFile(opts.Out, device, 0640); err != nil {
+			return err
+		}
+		fmt.Printf("Device record %s\n", opts.Out)
+	}
+
+	if len(opts.Euifile) > 0 {
+		if err := retireEui(opts.Euifile, eui, date.Unix()); err != nil {
+			return fmt.Errorf("record written, retiring in %s failed: %s", opts.Euifile, err)
+		}
+		fmt.Printf("Retired %016X in %s\n", eui, opts.Euifile)
+	}
+
+	for _, lic := range revoked {
+		w := IssuedLicense{Eui: lic.Eui, Type: lic.Type, Params: lic.Params, Purpose: lic.Purpose, Id: lic.Id, Time: date, Withdrawn: true}
+		if err := recordIssuedLicense(opts.Registry, w); err != nil {
+			return fmt.Errorf("record written, withdrawing licenses in %s failed: %s", opts.Registry, err)
+		}
+	}
+	if len(opts.Registry) > 0 {
+		fmt.Printf("Withdrew %d licenses in %s\n", len(revoked), opts.Registry)
+	}
+	return nil
+}
+
+func decommissionVerify(opts *DecommissionVerifyOptions) error {
+	var gen UserSignature
+
+	key, err := loadEd25519Public(opts.Pubkey)
+	if err != nil {
+		return err
+	}
+	b, err := readSigdirFile(opts.Record)
+	if err != nil {
+		return err
+	}
+	var rec DecommissionRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return fmt.Errorf("%s: %s", opts.Record, err)
+	}
+	if rec.Schema != SCHEMA_DECOMMISSION {
+		return fmt.Errorf("%s is not a decommission record", opts.Record)
+	}
+	sig, err := hex.DecodeString(rec.Signature)
+	if err != nil || !ed25519.Verify(key, rec.signed(), sig) {
+		return fmt.Errorf("signature of %s does not verify", opts.Record)
+	}
+
+	device, err := hex.DecodeString(rec.Device)
+	if err != nil {
+		return fmt.Errorf("device record of %s: %s", opts.Record, err)
+	}
+	sigs, err := gen.DeserializeArea(device)
+	if err != nil {
+		return fmt.Errorf("device record of %s: %s", opts.Record, err)
+	}
+	dsig, ok := sigs[0].(DecommissionSignature)
+	if !ok {
+		return fmt.Errorf("device record of %s is not a decommission record", opts.Record)
+	}
+	if err := dsig.Verify(key); err != nil {
+		return err
+	}
+	if fmt.Sprintf("%016X", dsig.Eui64) != rec.Eui64 || dsig.Reason.String() != rec.Reason || dsig.Unix_time != rec.Date.Unix() {
+		return fmt.Errorf("device record of %s does not match the record", opts.Record)
+	}
+
+	if len(opts.In) > 0 {
+		sigs, err := readSigsFromFile(opts.In)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, s := range sigs {
+			if d, ok := s.(DecommissionSignature); ok {
+				found = true
+				if d != dsig {
+					return fmt.Errorf("decommission record in %s differs from %s", opts.In, opts.Record)
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s has no decommission record", opts.In)
+		}
+	}
+
+	fmt.Printf("OK %s %s %s by %s\n", rec.Eui64, rec.Reason, TimestampString(rec.Date), rec.Authority)
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "decommission",
+		short:   "End-of-life records for devices",
+		long:    "Issue a signed end-of-life record for an EUI, retire it in the pool, withdraw its licenses and emit the record for the device, or verify a record.",
+		options: func() interface{} { return new(DecommissionOptions) },
+		run:     func(c *commandRun) { decommissionMain(c.command, c.options.(*DecommissionOptions)) },
+	})
+}
+
+func decommissionMain(command string, opts *DecommissionOptions) {
+	var err error
+	switch command {
+	case "issue":
+		err = decommissionIssue(&opts.Issue)
+	case "verify":
+		err = decommissionVerify(&opts.Verify)
+	}
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
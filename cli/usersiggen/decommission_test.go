@@ -0,0 +1,95 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "strings"
+import "testing"
+import "path/filepath"
+
+// A returned device is decommissioned, its EUI retired and its license
+// withdrawn, the record verifies on its own and on the device.
+func TestDecommission(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	key, pub := writeTestKeys(t, dir, "authority")
+	sigdir := filepath.Join(dir, "sigdata")
+	area := testArea(t, 0x70B3D5D72F000001)
+	writeTestFile(t, sigdir, "EUI-64_70B3D5D72F000001.bin", area)
+	registry := filepath.Join(dir, "issued_licenses.jsonl")
+	if err := recordIssuedLicense(registry, IssuedLicense{Eui: "70B3D5D72F000001", Type: "beatstack-pro", Time: g_test_time}); err != nil {
+		t.Fatal(err)
+	}
+	opts := &DecommissionIssueOptions{
+		Eui:       "70B3D5D72F000001",
+		Reason:    "returned",
+		Authority: "QA Tallinn",
+		Date:      "2024-03-01",
+		Key:       key,
+		Euifile:   writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001,tsb2,1.2.3,1700000000,uuid,AA\n70B3D5D72F000002\n")),
+		Registry:  registry,
+		Sigdir:    sigdir,
+		Out:       filepath.Join(dir, "decommission.bin"),
+	}
+	captureStdout(t, func() {
+		if err := decommissionIssue(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	entries, err := readPool(opts.Euifile)
+	if err != nil || entries[0].Status != POOL_RETIRED || entries[0].Retired.Format("2006-01-02") != "2024-03-01" || entries[1].Status != POOL_FREE {
+		t.Errorf("pool entries are %+v: %v", entries, err)
+	}
+	if issued, err := findIssuedLicenses(registry, 0x70B3D5D72F000001); err != nil || len(issued) != 0 {
+		t.Errorf("licenses still issued: %+v, %v", issued, err)
+	}
+	if err := decommissionIssue(opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("decommissioned twice: %v", err)
+	}
+
+	device, err := os.ReadFile(opts.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := &DecommissionVerifyOptions{
+		Record: filepath.Join(sigdir, "EUI-64_70B3D5D72F000001_decommission.json"),
+		Pubkey: pub,
+		In:     writeTestFile(t, dir, "returned.bin", append(append([]byte{}, area...), device...)),
+	}
+	if out := captureStdout(t, func() {
+		if err := decommissionVerify(verify); err != nil {
+			t.Error(err)
+		}
+	}); !strings.Contains(out, "OK 70B3D5D72F000001 returned 2024-03-01") {
+		t.Errorf("verify printed %s", out)
+	}
+
+	verify.In = writeTestFile(t, dir, "other.bin", area)
+	if err := decommissionVerify(verify); err == nil || !strings.Contains(err.Error(), "no decommission record") {
+		t.Errorf("device without the record: %v", err)
+	}
+	_, other := writeTestKeys(t, dir, "other")
+	verify.Pubkey, verify.In = other, ""
+	if err := decommissionVerify(verify); err == nil {
+		t.Errorf("another key verifies the record")
+	}
+}
+
+func TestRetirable(t *testing.T) {
+	pool := writeTestFile(t, t.TempDir(), "euis.txt", []byte("70B3D5D72F000001,tsb2,1.2.3,1700000000,uuid,AA,,retired=1700000001\n70B3D5D72F000002,RESERVED\n70B3D5D72F000003\n"))
+	for e, problem := range map[eui64]string{
+		0x70B3D5D72F000001: "already retired",
+		0x70B3D5D72F000002: "is reserved",
+		0x70B3D5D72F000003: "is free",
+		0x70B3D5D72F000004: "is not in",
+	} {
+		if err := checkRetirable(pool, e); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%016X: %v, want %s", e, err, problem)
+		}
+	}
+	if _, err := parseDecommissionDate("01.03.2024"); err == nil {
+		t.Errorf("a date in another format is accepted")
+	}
+}
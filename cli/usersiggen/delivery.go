@@ -0,0 +1,291 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "sort"
+import "time"
+import "errors"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/hex"
+import "encoding/json"
+import "encoding/binary"
+import "crypto/ed25519"
+import "crypto/sha256"
+
+// Delivery manifest for shipping a batch of signature and license files to a
+// customer. Every entry hashes the file together with the previous entry, the
+// last hash is signed, so a dropped, reordered, added or modified file breaks
+// either the chain or the signature. The customer verifies with the public
+// key, nothing else of the generator setup is needed.
+
+const SCHEMA_DELIVERY = "euisiggen/delivery/v1"
+
+type DeliveryEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	Chain  string `json:"chain"` // Hash of the previous chain value and this entry
+}
+
+type DeliveryManifest struct {
+	Schema    string          `json:"schema"`
+	Generator string          `json:"generator"`
+	Batch     string          `json:"batch"`
+	Created   string          `json:"created"`
+	Count     int             `json:"count"`
+	Entries   []DeliveryEntry `json:"entries"`
+	Head      string          `json:"head"` // Chain value of the last entry
+	Signature string          `json:"signature"`
+}
+
+type DeliveryCreateOptions struct {
+	Batch string `long:"batch" required:"true" description:"Batch identifier, e.g. the order or work order."`
+	Key   string `long:"key"   required:"true" description:"Ed25519 private key, PKCS#8 PEM."`
+	Dir   string `long:"dir"   default:"." description:"Directory the files are delivered from, names are relative to it."`
+	Out   string `long:"out"   default:"MANIFEST.json" description:"Manifest file."`
+	Args  struct {
+		Files []string `positional-arg-name:"file"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type DeliveryVerifyOptions struct {
+	Manifest string `long:"manifest" default:"MANIFEST.json" description:"Manifest file."`
+	Pubkey   string `long:"pubkey"   required:"true" description:"Ed25519 public key of the sender, PEM."`
+	Dir      string `long:"dir"      default:"." description:"Directory the delivery was unpacked to."`
+	Strict   bool   `long:"strict"   description:"Also fail on files in the directory that are not in the manifest."`
+}
+
+type DeliveryOptions struct {
+	Create DeliveryCreateOptions `command:"create" description:"Write a signed, hash-chained manifest of a batch."`
+	Verify DeliveryVerifyOptions `command:"verify" description:"Verify a received batch against its manifest."`
+}
+
+func deliveryGenesis(batch string) []byte {
+	h := sha256.Sum256([]byte(SCHEMA_DELIVERY + "\n" + batch))
+	return h[:]
+}
+
+func deliveryChain(prev []byte, e DeliveryEntry) ([]byte, error) {
+	digest, err := hex.DecodeString(e.Sha256)
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("%s: bad sha256 %q", e.Name, e.Sha256)
+	}
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(e.Size))
+
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(digest)
+	h.Write(size[:])
+	h.Write([]byte(e.Name))
+	return h.Sum(nil), nil
+}
+
+// signedBytes is what the signature covers, the head covers the entries.
+func (self *DeliveryManifest) signedBytes() []byte {
+	return []byte(strings.Join([]string{self.Schema, self.Batch, self.Created,
+		fmt.Sprintf("%d", self.Count), self.Head}, "\n"))
+}
+
+// deliveryFiles expands the arguments to the files under them, as names
+// relative to the delivery directory.
+func deliveryFiles(dir string, args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, arg := range args {
+		root := filepath.Join(dir, arg)
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("%s is outside %s", path, dir)
+			}
+			name := filepath.ToSlash(rel)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), int64(len(data)), nil
+}
+
+func deliveryCreate(opts *DeliveryCreateOptions) error {
+	key, err := loadSigningKey(opts.Key, "delivery")
+	if err != nil {
+		return err
+	}
+
+	names, err := deliveryFiles(opts.Dir, opts.Args.Files)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("no files to deliver")
+	}
+
+	m := DeliveryManifest{Schema: SCHEMA_DELIVERY, Generator: generatorVersion(), Batch: opts.Batch,
+		Created: TimestampString(time.Now().UTC()), Entries: make([]DeliveryEntry, 0, len(names))}
+	manifest, _ := filepath.Abs(opts.Out)
+	chain := deliveryGenesis(opts.Batch)
+	for _, name := range names {
+		if abs, _ := filepath.Abs(filepath.Join(opts.Dir, filepath.FromSlash(name))); abs == manifest {
+			continue
+		}
+		e := DeliveryEntry{Name: name}
+		if e.Sha256, e.Size, err = hashFile(filepath.Join(opts.Dir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+		if chain, err = deliveryChain(chain, e); err != nil {
+			return err
+		}
+		e.Chain = hex.EncodeToString(chain)
+		m.Entries = append(m.Entries, e)
+	}
+	m.Count = len(m.Entries)
+	m.Head = hex.EncodeToString(chain)
+	m.Signature = hex.EncodeToString(ed25519.Sign(key, m.signedBytes()))
+
+	if err := writeJsonFile(opts.Out, m); err != nil {
+		return err
+	}
+	fmt.Printf("Manifest of %d files for batch %s written to %s, head %s\n", m.Count, m.Batch, opts.Out, m.Head)
+	return nil
+}
+
+// verifyDeliveryManifest checks the signature and the chain of the manifest
+// itself, the files are checked separately.
+func verifyDeliveryManifest(m *DeliveryManifest, pub ed25519.PublicKey) error {
+	if m.Schema != SCHEMA_DELIVERY {
+		return fmt.Errorf("unknown manifest schema %q", m.Schema)
+	}
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(pub, m.signedBytes(), sig) {
+		return errors.New("manifest signature is not valid")
+	}
+	if m.Count != len(m.Entries) {
+		return fmt.Errorf("manifest lists %d entries, signed for %d", len(m.Entries), m.Count)
+	}
+
+	chain := deliveryGenesis(m.Batch)
+	for i, e := range m.Entries {
+		if chain, err = deliveryChain(chain, e); err != nil {
+			return err
+		}
+		if hex.EncodeToString(chain) != e.Chain {
+			return fmt.Errorf("chain broken at entry %d, %s", i+1, e.Name)
+		}
+	}
+	if hex.EncodeToString(chain) != m.Head {
+		return errors.New("chain does not end in the signed head")
+	}
+	return nil
+}
+
+// deliveryVerify returns the number of problems with the delivered files.
+func deliveryVerify(opts *DeliveryVerifyOptions) (int, error) {
+	pub, err := loadEd25519Public(opts.Pubkey)
+	if err != nil {
+		return 0, err
+	}
+	b, err := ioutil.ReadFile(opts.Manifest)
+	if err != nil {
+		return 0, err
+	}
+	var m DeliveryManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return 0, fmt.Errorf("%s: %s", opts.Manifest, err)
+	}
+	if err := verifyDeliveryManifest(&m, pub); err != nil {
+		return 0, fmt.Errorf("%s: %s", opts.Manifest, err)
+	}
+	fmt.Printf("Manifest OK, batch %s of %d files created %s\n", m.Batch, m.Count, m.Created)
+
+	problems := 0
+	listed := make(map[string]bool)
+	for _, e := range m.Entries {
+		listed[e.Name] = true
+		digest, size, err := hashFile(filepath.Join(opts.Dir, filepath.FromSlash(e.Name)))
+		if os.IsNotExist(err) {
+			fmt.Printf("MISSING  %s\n", e.Name)
+			problems++
+		} else if err != nil {
+			return problems, err
+		} else if digest != e.Sha256 || size != e.Size {
+			fmt.Printf("MODIFIED %s\n", e.Name)
+			problems++
+		}
+	}
+
+	present, err := deliveryFiles(opts.Dir, []string{"."})
+	if err != nil {
+		return problems, err
+	}
+	manifest, _ := filepath.Abs(opts.Manifest)
+	for _, name := range present {
+		abs, _ := filepath.Abs(filepath.Join(opts.Dir, filepath.FromSlash(name)))
+		if listed[name] || abs == manifest {
+			continue
+		}
+		fmt.Printf("EXTRA    %s\n", name)
+		if opts.Strict {
+			problems++
+		}
+	}
+	return problems, nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "delivery",
+		short:   "Signed manifests for customer deliveries",
+		long:    "Create a hash-chained, signed manifest of a batch of signature and license files, or verify a received batch against it.",
+		options: func() interface{} { return new(DeliveryOptions) },
+		run:     func(c *commandRun) { deliveryMain(c.command, c.options.(*DeliveryOptions)) },
+	})
+}
+
+func deliveryMain(command string, opts *DeliveryOptions) {
+	switch command {
+	case "create":
+		if err := deliveryCreate(&opts.Create); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+	case "verify":
+		problems, err := deliveryVerify(&opts.Verify)
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+		if problems > 0 {
+			fmt.Printf("ERROR %d files do not match the manifest, the delivery is incomplete or was tampered with\n", problems)
+			os.Exit(4)
+		}
+		fmt.Printf("All files match %s\n", opts.Verify.Manifest)
+	}
+}
@@ -0,0 +1,94 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "strings"
+import "testing"
+import "encoding/json"
+import "path/filepath"
+
+// A batch is shipped with its manifest, the customer finds a missing,
+// modified or extra file, a reordered manifest breaks the chain.
+func TestDelivery(t *testing.T) {
+	dir := t.TempDir()
+	key, pub := writeTestKeys(t, t.TempDir(), "delivery")
+	writeTestFile(t, dir, "sigdata/EUI-64_70B3D5D72F000001.bin", testArea(t, 0x70B3D5D72F000001))
+	writeTestFile(t, dir, "sigdata/EUI-64_70B3D5D72F000002.bin", testArea(t, 0x70B3D5D72F000002))
+	writeTestFile(t, dir, "licenses.json", []byte("[]\n"))
+
+	create := &DeliveryCreateOptions{Batch: "WO-2231", Key: key, Dir: dir, Out: filepath.Join(dir, "MANIFEST.json")}
+	create.Args.Files = []string{"sigdata", "licenses.json", "sigdata/EUI-64_70B3D5D72F000001.bin"}
+	captureStdout(t, func() {
+		if err := deliveryCreate(create); err != nil {
+			t.Fatal(err)
+		}
+	})
+	verify := &DeliveryVerifyOptions{Manifest: create.Out, Pubkey: pub, Dir: dir, Strict: true}
+	verified := func() (int, string, error) {
+		var problems int
+		var err error
+		out := captureStdout(t, func() {
+			problems, err = deliveryVerify(verify)
+		})
+		return problems, out, err
+	}
+	if problems, out, err := verified(); problems != 0 || err != nil {
+		t.Fatalf("%d problems: %v\n%s", problems, err, out)
+	}
+
+	writeTestFile(t, dir, "sigdata/EUI-64_70B3D5D72F000002.bin", testArea(t, 0x70B3D5D72F000003))
+	os.Remove(filepath.Join(dir, "licenses.json"))
+	writeTestFile(t, dir, "notes.txt", []byte("extra"))
+	problems, out, err := verified()
+	if problems != 3 || err != nil || !strings.Contains(out, "MISSING  licenses.json") || !strings.Contains(out, "MODIFIED sigdata/EUI-64_70B3D5D72F000002.bin") || !strings.Contains(out, "EXTRA    notes.txt") {
+		t.Errorf("%d problems: %v\n%s", problems, err, out)
+	}
+	verify.Strict = false
+	if problems, _, _ := verified(); problems != 2 {
+		t.Errorf("%d problems without --strict, want 2", problems)
+	}
+
+	// The manifest itself
+	var m DeliveryManifest
+	data, _ := os.ReadFile(create.Out)
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Count != 3 || m.Entries[0].Name != "licenses.json" {
+		t.Fatalf("manifest entries are %+v", m.Entries)
+	}
+	_, other := writeTestKeys(t, t.TempDir(), "other")
+	verify.Pubkey = other
+	if _, _, err := verified(); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("another key verifies the manifest: %v", err)
+	}
+	pubkey, err := loadEd25519Public(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered := m
+	reordered.Entries = []DeliveryEntry{m.Entries[1], m.Entries[0], m.Entries[2]}
+	if err := verifyDeliveryManifest(&reordered, pubkey); err == nil || !strings.Contains(err.Error(), "chain broken at entry 1") {
+		t.Errorf("reordered manifest: %v", err)
+	}
+	dropped := m
+	dropped.Entries = m.Entries[:2]
+	if err := verifyDeliveryManifest(&dropped, pubkey); err == nil {
+		t.Errorf("a dropped entry is not noticed")
+	}
+}
+
+func TestDeliveryFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "b/2.bin", nil)
+	writeTestFile(t, dir, "a.bin", nil)
+	names, err := deliveryFiles(dir, []string{".", "b"})
+	if err != nil || strings.Join(names, " ") != "a.bin b/2.bin" {
+		t.Errorf("files are %v: %v", names, err)
+	}
+	if _, err := deliveryFiles(filepath.Join(dir, "b"), []string{"../a.bin"}); err == nil {
+		t.Errorf("a file outside the directory is delivered")
+	}
+}
@@ -0,0 +1,96 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "strconv"
+import "strings"
+import "encoding/json"
+
+import "github.com/jessevdk/go-flags"
+
+import "go.thinnect.net/euisig/signature"
+
+// Renamed flags keep working for two minor versions after the rename. The old
+// flag is a hidden option next to the new one, its value is moved over and a
+// structured warning is printed on stderr so it does not end up in JSON
+// output. With --strict-cli the warning is an error, for catching scripts
+// that still use the old names. Once the generator reaches the removal
+// version the old flag is refused regardless.
+
+const SCHEMA_DEPRECATION = "euisiggen/deprecation/v1"
+
+type Deprecation struct {
+	Command     string `json:"command,omitempty"` // Subcommand the flag belongs to, empty for the main options
+	Flag        string `json:"flag"`
+	Replacement string `json:"replacement"`
+	Since       string `json:"deprecated_since"`
+	Removed     string `json:"removed_in"`
+}
+
+var g_deprecations = []Deprecation{
+	{Flag: "serialuuid", Replacement: "serial-uuid", Since: "3.4", Removed: "3.6"},
+	{Flag: "licfile", Replacement: "license-file", Since: "3.4", Removed: "3.6"},
+}
+
+// removed tells if the generator version has reached the removal version.
+func (d Deprecation) removed() bool {
+	v := strings.SplitN(d.Removed, ".", 2)
+	major, _ := strconv.Atoi(v[0])
+	minor := 0
+	if len(v) > 1 {
+		minor, _ = strconv.Atoi(v[1])
+	}
+	if int(signature.VersionMajor) != major {
+		return int(signature.VersionMajor) > major
+	}
+	return int(signature.VersionMinor) >= minor
+}
+
+func deprecationWarning(d Deprecation, level string) {
+	j, _ := json.Marshal(struct {
+		Schema string `json:"schema"`
+		Level  string `json:"level"`
+		Deprecation
+	}{SCHEMA_DEPRECATION, level, d})
+	fmt.Fprintf(os.Stderr, "%s\n", j)
+}
+
+// applyDeprecations moves the values of deprecated flags to their
+// replacements, warning about every one used.
+func applyDeprecations(parser *flags.Parser, strict bool) error {
+	for _, d := range g_deprecations {
+		cmd := parser.Command
+		if len(d.Command) > 0 {
+			if cmd = parser.Find(d.Command); cmd == nil {
+				continue
+			}
+		}
+		old := cmd.FindOptionByLongName(d.Flag)
+		if old == nil || !old.IsSet() {
+			continue
+		}
+
+		if d.removed() {
+			deprecationWarning(d, "error")
+			return fmt.Errorf("--%s was removed in %s, use --%s", d.Flag, d.Removed, d.Replacement)
+		}
+		if strict {
+			deprecationWarning(d, "error")
+			return fmt.Errorf("--%s is deprecated, use --%s (--strict-cli)", d.Flag, d.Replacement)
+		}
+		deprecationWarning(d, "warning")
+
+		replacement := cmd.FindOptionByLongName(d.Replacement)
+		if replacement.IsSet() {
+			return fmt.Errorf("--%s and --%s are the same flag, use only --%s", d.Flag, d.Replacement, d.Replacement)
+		}
+		value := fmt.Sprint(old.Value())
+		if err := replacement.Set(&value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
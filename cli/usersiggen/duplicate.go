@@ -0,0 +1,75 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "crypto/sha256"
+import "encoding/binary"
+
+import "go.thinnect.net/euisig/signature"
+
+// Guard against running the same generation twice. By default a signature
+// file that already exists for the EUI fails the run. With --if-exists
+// skip-identical the content that would be generated is compared with the
+// existing file instead: when it is the same the existing file is used as it
+// is, "already generated, skipping", conflicting content still fails. Every
+// run stamps the records with its own time, so the generation times and the
+// CRCs that cover them are left out of the comparison. An --eui override
+// replaces the file of the EUI, with skip-identical only when the content
+// differs, so a repeated override is not rewritten and backed up again.
+
+const IF_EXISTS_FAIL = "fail"
+const IF_EXISTS_SKIP_IDENTICAL = "skip-identical"
+
+// contentHash is the SHA-256 of the records of a signature area without
+// their generation times and CRCs.
+func contentHash(sigdata []byte) [32]byte {
+	var base BaseSignature
+	basesz := binary.Size(base)
+	// unix_time is the last field of the header
+	timesz := binary.Size(base.Unix_time)
+
+	h := sha256.New()
+	for rd := 0; rd+basesz <= len(sigdata); {
+		size, err := signature.RecordLength(sigdata[rd:])
+		if err != nil || rd+size > len(sigdata) {
+			h.Write(sigdata[rd:])
+			break
+		}
+		h.Write(sigdata[rd : rd+basesz-timesz])
+		h.Write(sigdata[rd+basesz : rd+size-signature.CheckSize(sigdata[rd:])])
+		rd += size
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// existingSigfile applies the duplicate-run guard to the signature file of
+// an EUI. It returns the contents of the existing file when the run is to
+// use them instead of sigdata, nil when there is no file or an override is to
+// replace it.
+func existingSigfile(sigfile string, sigdata []byte, policy string, override bool) ([]byte, error) {
+	if _, err := os.Stat(sigfile); err != nil {
+		return nil, nil
+	}
+	if policy != IF_EXISTS_SKIP_IDENTICAL {
+		if override {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("signature file exists at %s", sigfile)
+	}
+	existing, err := readSigdirFile(sigfile)
+	if err != nil {
+		return nil, err
+	}
+	if contentHash(existing) != contentHash(sigdata) {
+		if override {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("signature file at %s has different content, refusing to replace it", sigfile)
+	}
+	return existing, nil
+}
@@ -0,0 +1,69 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "bytes"
+import "testing"
+import "time"
+import "path/filepath"
+
+func euiSigdata(t *testing.T, ts time.Time, e eui64) []byte {
+	var gen UserSignature
+	esig, err := gen.ConstructEUISignature(ts, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := gen.Serialize(esig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The guard of the generation and of the --eui override, the override
+// replaces the file unless skip-identical finds the same content in it.
+func TestExistingSigfile(t *testing.T) {
+	sigfile := filepath.Join(t.TempDir(), "EUI-64_70B3D5D72F000001.bin")
+	previous := euiSigdata(t, time.Unix(1600000000, 0), 0x70B3D5D72F000001)
+	identical := euiSigdata(t, time.Unix(1700000000, 0), 0x70B3D5D72F000001)
+	different := euiSigdata(t, time.Unix(1700000000, 0), 0x70B3D5D72F000002)
+
+	for _, override := range []bool{false, true} {
+		for _, policy := range []string{IF_EXISTS_FAIL, IF_EXISTS_SKIP_IDENTICAL} {
+			if existing, err := existingSigfile(sigfile, identical, policy, override); existing != nil || err != nil {
+				t.Errorf("no file, %s, override %v: %v, %v", policy, override, existing, err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(sigfile, previous, 0440); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		sigdata  []byte
+		policy   string
+		override bool
+		reused   bool
+		fails    bool
+	}{
+		{identical, IF_EXISTS_FAIL, false, false, true},
+		{identical, IF_EXISTS_FAIL, true, false, false},
+		{identical, IF_EXISTS_SKIP_IDENTICAL, false, true, false},
+		{identical, IF_EXISTS_SKIP_IDENTICAL, true, true, false},
+		{different, IF_EXISTS_SKIP_IDENTICAL, false, false, true},
+		{different, IF_EXISTS_SKIP_IDENTICAL, true, false, false},
+	} {
+		existing, err := existingSigfile(sigfile, c.sigdata, c.policy, c.override)
+		if (err != nil) != c.fails {
+			t.Errorf("%s, override %v, identical %v: error %v", c.policy, c.override, c.reused, err)
+		}
+		if c.reused && !bytes.Equal(existing, previous) {
+			t.Errorf("%s, override %v: the existing file is not used", c.policy, c.override)
+		}
+		if !c.reused && existing != nil {
+			t.Errorf("%s, override %v: the existing file is used for other content", c.policy, c.override)
+		}
+	}
+}
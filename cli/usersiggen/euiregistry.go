@@ -0,0 +1,418 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "strings"
+import "time"
+import "net/url"
+import "database/sql"
+
+import _ "github.com/lib/pq"
+import _ "github.com/mattn/go-sqlite3"
+
+// EUI registry, the allocation state of the pool kept in an SQLite or a
+// PostgreSQL database instead of rewriting the euifile on every mark. The euifile still declares
+// the tags, customers and ranges of the pool in its header, the registry
+// holds the state of every EUI, the board it went to, when, and the signature
+// file written for it:
+//
+//	usersiggen eui-registry import eui.txt euis.db
+//	usersiggen --euifile eui.txt --eui-registry euis.db --type board ...
+//	usersiggen eui-registry list --board tsb2 euis.db
+//
+// A PostgreSQL registry, postgres://user@host/db, is shared by the lines of
+// several sites. An EUI is claimed in the registry when it is handed out, two
+// lines never get the same one. A claim lapses after EUI_CLAIM_LEASE when the
+// EUI does not get marked, after a crash or an aborted run.
+//
+// Import again after adding EUIs to the euifile, EUIs already in the registry
+// keep their state. The license registry, --registry, is a different thing.
+
+// EuiRegistry keeps the allocation state of a pool.
+type EuiRegistry interface {
+	// Entries returns the entries of the pool in allocation order.
+	Entries() ([]PoolEntry, error)
+	// Claim claims free EUIs for this run, all of them or none. It returns
+	// the EUIs that were claimed by someone else or are not free anymore.
+	Claim(euis []eui64) ([]eui64, error)
+	// Mark marks free EUIs used, all of them or none.
+	Mark(marks map[eui64]string, ts int64) error
+	// Retire marks a used EUI retired.
+	Retire(eui eui64, ts int64) error
+	// Release frees a used EUI of a scrapped board.
+	Release(eui eui64) error
+	// Sigfile records the signature file written for an EUI.
+	Sigfile(eui eui64, path string) error
+	// Add adds the entries not in the registry yet after the ones that are,
+	// it returns how many were added.
+	Add(entries []PoolEntry) (int, error)
+	Close() error
+}
+
+type EuiRegistryImportOptions struct {
+	Args struct {
+		Euifile  string `positional-arg-name:"euifile"`
+		Registry string `positional-arg-name:"registry"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type EuiRegistryListOptions struct {
+	Board  string `long:"board"  description:"Only EUIs that went to boards with this name."`
+	Tag    string `long:"tag"    description:"Only EUIs marked with this tag."`
+	Status string `long:"status" description:"Only EUIs in this state: free, reserved, used or retired."`
+	Args   struct {
+		Registry string `positional-arg-name:"registry"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type EuiRegistryOptions struct {
+	Import EuiRegistryImportOptions `command:"import" description:"Add the EUIs of an euifile to a registry, creating it if needed."`
+	List   EuiRegistryListOptions   `command:"list"   description:"List the EUIs of a registry."`
+}
+
+const EUI_CLAIM_LEASE = time.Hour
+
+// g_eui_registry is the registry of the run, nil when the euifile is the pool.
+var g_eui_registry EuiRegistry
+
+// The same statements serve SQLite and PostgreSQL.
+const euiRegistrySchema = `CREATE TABLE IF NOT EXISTS euis (
+	eui     TEXT PRIMARY KEY,
+	seq     BIGINT NOT NULL,
+	status  TEXT NOT NULL,
+	mark    TEXT NOT NULL DEFAULT '',
+	board   TEXT NOT NULL DEFAULT '',
+	tag     TEXT NOT NULL DEFAULT '',
+	marked  BIGINT NOT NULL DEFAULT 0,
+	retired BIGINT NOT NULL DEFAULT 0,
+	sigfile TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS euis_seq ON euis (seq);
+CREATE TABLE IF NOT EXISTS claims (
+	eui      TEXT PRIMARY KEY,
+	claimant TEXT NOT NULL,
+	until    BIGINT NOT NULL
+);`
+
+type sqlEuiRegistry struct {
+	name     string
+	db       *sql.DB
+	claimant string
+}
+
+func isPostgresUrl(name string) bool {
+	return strings.HasPrefix(name, "postgres://") || strings.HasPrefix(name, "postgresql://")
+}
+
+// openEuiRegistry opens an existing registry, or creates it. A postgres://
+// url is a PostgreSQL database, anything else an SQLite file.
+func openEuiRegistry(name string, create bool) (EuiRegistry, error) {
+	driver, source, display := "postgres", name, name
+	if isPostgresUrl(name) {
+		u, err := url.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		// No host is the local socket
+		if len(u.Host) > 0 {
+			if err := offlineAddr("EUI registry", u.Host); err != nil {
+				return nil, err
+			}
+		}
+		display = u.Redacted()
+	} else {
+		if _, err := os.Stat(name); os.IsNotExist(err) && !create {
+			return nil, fmt.Errorf("no EUI registry at %s, create it with eui-registry import", name)
+		}
+		// Writers queue for the lock instead of failing, a transaction takes
+		// it before reading what it updates
+		driver, source = "sqlite3", fmt.Sprintf("file:%s?_busy_timeout=10000&_txlock=immediate", name)
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(euiRegistrySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %s", display, err)
+	}
+	host, _ := os.Hostname()
+	return &sqlEuiRegistry{display, db, fmt.Sprintf("%s:%d", host, os.Getpid())}, nil
+}
+
+func setEuiRegistry(name string) error {
+	if len(name) == 0 {
+		return nil
+	}
+	r, err := openEuiRegistry(name, false)
+	if err != nil {
+		return err
+	}
+	g_eui_registry = r
+	return nil
+}
+
+// unixOrZero is the Unix time of t, 0 for the zero time.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (self *sqlEuiRegistry) Close() error {
+	return self.db.Close()
+}
+
+func (self *sqlEuiRegistry) Entries() ([]PoolEntry, error) {
+	rows, err := self.db.Query("SELECT eui, mark, sigfile FROM euis ORDER BY seq")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]PoolEntry, 0)
+	for rows.Next() {
+		var eui, mark, sigfile string
+		if err := rows.Scan(&eui, &mark, &sigfile); err != nil {
+			return nil, err
+		}
+		entry, err := parsePoolLine(eui + "," + mark)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", self.name, err)
+		}
+		entry.Sigfile = sigfile
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// update sets the mark of an EUI and the columns derived from it, when the
+// EUI is in the state given.
+func (self *sqlEuiRegistry) update(tx *sql.Tx, eui eui64, status string, mark string) error {
+	e, err := parsePoolLine(fmt.Sprintf("%016X,%s", eui, mark))
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE euis SET status = $1, mark = $2, board = $3, tag = $4, marked = $5, retired = $6 WHERE eui = $7 AND status = $8",
+		e.Status, mark, e.Board, e.Tag, unixOrZero(e.Marked), unixOrZero(e.Retired), fmt.Sprintf("%016X", eui), status)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%016X is not %s in %s", eui, status, self.name)
+	}
+	return nil
+}
+
+func (self *sqlEuiRegistry) Mark(marks map[eui64]string, ts int64) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	for eui, mark := range marks {
+		if err := self.update(tx, eui, POOL_FREE, mark); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM claims WHERE eui = $1", fmt.Sprintf("%016X", eui)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (self *sqlEuiRegistry) Claim(euis []eui64) ([]eui64, error) {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec("DELETE FROM claims WHERE until < $1", now.Unix()); err != nil {
+		return nil, err
+	}
+	taken := make([]eui64, 0)
+	for _, eui := range euis {
+		// A claim held by another run keeps the row, the insert does nothing
+		res, err := tx.Exec("INSERT INTO claims (eui, claimant, until) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			fmt.Sprintf("%016X", eui), self.claimant, now.Add(EUI_CLAIM_LEASE).Unix())
+		if err != nil {
+			return nil, err
+		}
+		var status string
+		if n, _ := res.RowsAffected(); n == 0 {
+			taken = append(taken, eui)
+		} else if err := tx.QueryRow("SELECT status FROM euis WHERE eui = $1", fmt.Sprintf("%016X", eui)).Scan(&status); err != nil || status != POOL_FREE {
+			taken = append(taken, eui)
+		}
+	}
+	if len(taken) > 0 {
+		return taken, nil
+	}
+	return nil, tx.Commit()
+}
+
+func (self *sqlEuiRegistry) Retire(eui eui64, ts int64) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var mark string
+	if err := tx.QueryRow("SELECT mark FROM euis WHERE eui = $1", fmt.Sprintf("%016X", eui)).Scan(&mark); err == sql.ErrNoRows {
+		return fmt.Errorf("%016X is not in %s", eui, self.name)
+	} else if err != nil {
+		return err
+	}
+	splits := strings.Split(mark, ",")
+	if len(splits) > 6 {
+		return fmt.Errorf("%016X is already retired in %s", eui, self.name)
+	} else if len(splits) < 2 {
+		return fmt.Errorf("%016X was never marked in %s, only used EUIs can be retired", eui, self.name)
+	}
+	for len(splits) < 6 {
+		splits = append(splits, "")
+	}
+	mark = strings.Join(append(splits, fmt.Sprintf("%s%d", POOL_RETIRED_PREFIX, ts)), ",")
+	if err := self.update(tx, eui, POOL_USED, mark); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (self *sqlEuiRegistry) Release(eui eui64) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := self.update(tx, eui, POOL_USED, ""); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE euis SET sigfile = '' WHERE eui = $1", fmt.Sprintf("%016X", eui)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (self *sqlEuiRegistry) Sigfile(eui eui64, path string) error {
+	_, err := self.db.Exec("UPDATE euis SET sigfile = $1 WHERE eui = $2", path, fmt.Sprintf("%016X", eui))
+	return err
+}
+
+func (self *sqlEuiRegistry) Add(entries []PoolEntry) (int, error) {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM euis").Scan(&seq); err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, e := range entries {
+		mark := e.Mark
+		if e.Status == POOL_RESERVED {
+			mark = "RESERVED"
+		}
+		res, err := tx.Exec("INSERT INTO euis (eui, seq, status, mark, board, tag, marked, retired) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT DO NOTHING",
+			fmt.Sprintf("%016X", e.Eui), seq+1, e.Status, mark, e.Board, e.Tag, unixOrZero(e.Marked), unixOrZero(e.Retired))
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			seq++
+			added++
+		}
+	}
+	return added, tx.Commit()
+}
+
+// registerSigfile records the signature file of an EUI in the registry of
+// the run. The file has been written, a failure is only warned about.
+func registerSigfile(eui eui64, path string) {
+	if g_eui_registry == nil {
+		return
+	}
+	if err := g_eui_registry.Sigfile(eui, path); err != nil {
+		fmt.Printf("WARNING: failed to record %s for %016X in the EUI registry: %s\n", path, eui, err)
+	}
+}
+
+func euiRegistryImport(opts *EuiRegistryImportOptions) error {
+	entries, err := readPoolFile(opts.Args.Euifile)
+	if err != nil {
+		return err
+	}
+	r, err := openEuiRegistry(opts.Args.Registry, true)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	added, err := r.Add(entries)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d EUI-64s added to %s, %d already there\n", added, opts.Args.Registry, len(entries)-added)
+	return nil
+}
+
+func euiRegistryList(opts *EuiRegistryListOptions) error {
+	r, err := openEuiRegistry(opts.Args.Registry, false)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	entries, err := r.Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if (len(opts.Board) > 0 && e.Board != opts.Board) || (len(opts.Tag) > 0 && e.Tag != opts.Tag) || (len(opts.Status) > 0 && e.Status != opts.Status) {
+			continue
+		}
+		marked := ""
+		if !e.Marked.IsZero() {
+			marked = TimestampString(e.Marked)
+		}
+		line := fmt.Sprintf("%016X %-8s %-16s %-8s %-25s %-12s %s", e.Eui, e.Status, e.Board, e.Version, marked, e.Tag, e.Sigfile)
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "eui-registry",
+		short:   "EUI registry database",
+		long:    "Import the EUIs of an euifile into an SQLite or PostgreSQL registry, or list the EUIs of one by board, tag or state.",
+		options: func() interface{} { return new(EuiRegistryOptions) },
+		run:     func(c *commandRun) { euiRegistryMain(c.command, c.options.(*EuiRegistryOptions)) },
+	})
+}
+
+func euiRegistryMain(command string, opts *EuiRegistryOptions) {
+	var err error
+	switch command {
+	case "import":
+		err = euiRegistryImport(&opts.Import)
+	case "list":
+		err = euiRegistryList(&opts.List)
+	}
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "strings"
+import "testing"
+import "path/filepath"
+
+// The pool state kept in an SQLite registry, two runs never claim the same
+// EUI and only free EUIs get marked.
+func TestEuiRegistry(t *testing.T) {
+	dir := t.TempDir()
+	registry := filepath.Join(dir, "euis.db")
+	opts := &EuiRegistryImportOptions{}
+	opts.Args.Euifile = writeTestFile(t, dir, "euis.txt", []byte("# tag pilot quota=2\n70B3D5D72F000001,tsb2,1.2.3,1700000000,uuid,AA,pilot\n70B3D5D72F000002,RESERVED\n70B3D5D72F000003\n70B3D5D72F000004\n"))
+	opts.Args.Registry = registry
+	if _, err := openEuiRegistry(registry, false); err == nil || !strings.Contains(err.Error(), "eui-registry import") {
+		t.Errorf("a missing registry opens: %v", err)
+	}
+	captureStdout(t, func() {
+		if err := euiRegistryImport(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	first, err := openEuiRegistry(registry, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := openEuiRegistry(registry, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	entries, err := first.Entries()
+	if err != nil || len(entries) != 4 || entries[0].Status != POOL_USED || entries[0].Tag != "pilot" || entries[1].Status != POOL_RESERVED || entries[2].Status != POOL_FREE {
+		t.Fatalf("entries are %+v: %v", entries, err)
+	}
+
+	if taken, err := first.Claim([]eui64{0x70B3D5D72F000003}); taken != nil || err != nil {
+		t.Errorf("claim taken %v: %v", taken, err)
+	}
+	if taken, err := second.Claim([]eui64{0x70B3D5D72F000003, 0x70B3D5D72F000004}); len(taken) != 1 || taken[0] != 0x70B3D5D72F000003 || err != nil {
+		t.Errorf("claim of a claimed EUI taken %v: %v", taken, err)
+	}
+	if taken, _ := second.Claim([]eui64{0x70B3D5D72F000001}); len(taken) != 1 {
+		t.Errorf("a used EUI is claimed")
+	}
+
+	if err := first.Mark(map[eui64]string{0x70B3D5D72F000003: "tsb2,1.2.3,1700000001,uuid,AA,pilot"}, 1700000001); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Mark(map[eui64]string{0x70B3D5D72F000004: "tsb2", 0x70B3D5D72F000003: "tsb3"}, 1700000002); err == nil || !strings.Contains(err.Error(), "70B3D5D72F000003 is not free") {
+		t.Errorf("a used EUI is marked again: %v", err)
+	}
+	if err := first.Sigfile(0x70B3D5D72F000003, "sigdata/EUI-64_70B3D5D72F000003.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Retire(0x70B3D5D72F000001, 1700000003); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Retire(0x70B3D5D72F000001, 1700000004); err == nil || !strings.Contains(err.Error(), "already retired") {
+		t.Errorf("retired twice: %v", err)
+	}
+	if err := first.Retire(0x70B3D5D72F000004, 1700000004); err == nil || !strings.Contains(err.Error(), "never marked") {
+		t.Errorf("a free EUI is retired: %v", err)
+	}
+
+	entries, _ = second.Entries()
+	if entries[0].Status != POOL_RETIRED || entries[2].Status != POOL_USED || entries[2].Sigfile != "sigdata/EUI-64_70B3D5D72F000003.bin" || entries[3].Status != POOL_FREE {
+		t.Errorf("entries are %+v", entries)
+	}
+	if err := second.Release(0x70B3D5D72F000003); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = first.Entries()
+	if entries[2].Status != POOL_FREE || len(entries[2].Sigfile) != 0 {
+		t.Errorf("released entry is %+v", entries[2])
+	}
+
+	// Imported again with a new EUI, the state of the others is kept
+	opts.Args.Euifile = writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001\n70B3D5D72F000002\n70B3D5D72F000003\n70B3D5D72F000004\n70B3D5D72F000005\n"))
+	captureStdout(t, func() {
+		if err := euiRegistryImport(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	list := &EuiRegistryListOptions{Status: POOL_FREE}
+	list.Args.Registry = registry
+	out := captureStdout(t, func() {
+		if err := euiRegistryList(list); err != nil {
+			t.Error(err)
+		}
+	})
+	if out != "70B3D5D72F000003 free\n70B3D5D72F000004 free\n70B3D5D72F000005 free\n" {
+		t.Errorf("free EUIs are\n%s", out)
+	}
+}
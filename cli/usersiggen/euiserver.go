@@ -0,0 +1,258 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "sync"
+import "errors"
+import "strings"
+import "net/http"
+import "encoding/hex"
+import "encoding/json"
+import "path/filepath"
+
+import "go.thinnect.net/euisig/signature"
+
+// EUI allocation server, the one process that writes the pool so the flashing
+// stations do not share the euifile over a network drive:
+//
+//	POST /allocate         {"tag": ..., "work_order": ...} -> {"eui64": ..., "expires": ...}
+//	POST /mark             {"eui64": ..., "board": {...}}  -> {"eui64": ..., "sigdata": <hex>}
+//	                       {"eui64": ..., "sigdata": <hex>}
+//	GET  /sigdata/<EUI64>  -> the signature file
+//
+// An allocated EUI is held for the station for --lease. The mark reports the
+// board of the EUI, with the board fields of a profile the server generates
+// the signature area, with sigdata the station generated it and the server
+// checks that it is for the EUI. The area is kept in the sigdir and the EUI
+// marked in the pool with the tag it was allocated for.
+
+type EuiServerOptions struct {
+	Listen  string        `long:"listen"  default:":8080" description:"Address the stations connect to."`
+	Euifile string        `long:"euifile" required:"true" description:"The pool the EUIs are allocated from."`
+	Sigdir  string        `long:"sigdir"  default:"sigdata" description:"Where the signature files are kept."`
+	Lease   time.Duration `long:"lease"   default:"10m" description:"How long an allocated EUI is held for the station before it is marked."`
+}
+
+type AllocateRequest struct {
+	Tag       string `json:"tag,omitempty"`
+	WorkOrder string `json:"work_order,omitempty"`
+}
+
+type AllocateResponse struct {
+	Eui64   string    `json:"eui64"`
+	Expires time.Time `json:"expires"`
+}
+
+type MarkRequest struct {
+	Eui64   string            `json:"eui64"`
+	Board   *ProfileComponent `json:"board,omitempty"`   // The server generates the area
+	Sigdata string            `json:"sigdata,omitempty"` // The area the station generated, hex
+}
+
+type MarkResponse struct {
+	Eui64   string `json:"eui64"`
+	Sigdata string `json:"sigdata"` // hex
+}
+
+type euiLease struct {
+	tag     string
+	expires time.Time
+}
+
+type euiServer struct {
+	mutex  sync.Mutex
+	opts   *EuiServerOptions
+	leases map[eui64]euiLease
+}
+
+// claimed returns the EUIs held for stations, the expired leases are dropped.
+func (self *euiServer) claimed() map[eui64]bool {
+	claimed := make(map[eui64]bool)
+	now := time.Now()
+	for eui, l := range self.leases {
+		if now.After(l.expires) {
+			delete(self.leases, eui)
+		} else {
+			claimed[eui] = true
+		}
+	}
+	return claimed
+}
+
+func (self *euiServer) allocate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST an allocation request", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AllocateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "malformed allocation request", http.StatusBadRequest)
+		return
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	eui, err := getEuiExcept(self.opts.Euifile, req.Tag, req.WorkOrder, self.claimed())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	l := euiLease{req.Tag, time.Now().Add(self.opts.Lease).UTC()}
+	self.leases[eui] = l
+	fmt.Printf("%016X allocated to %s\n", eui, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AllocateResponse{fmt.Sprintf("%016X", eui), l.expires})
+}
+
+// identity returns the identity the mark reports, generated from the board
+// or the one the station generated.
+func (self *euiServer) identity(eui eui64, req *MarkRequest) (*signature.Identity, error) {
+	var gen UserSignature
+	if (req.Board == nil) == (len(req.Sigdata) == 0) {
+		return nil, errors.New("give either the board or the sigdata")
+	}
+	if req.Board != nil {
+		dev := &ProvisionDevice{Eui: eui, Timestamp: time.Now().UTC()}
+		if _, err := buildSigdata(&gen, &ProvisionProfile{Board: *req.Board}, dev); err != nil {
+			return nil, err
+		}
+		return dev.Identity, nil
+	}
+
+	data, err := hex.DecodeString(req.Sigdata)
+	if err != nil {
+		return nil, fmt.Errorf("sigdata is not hex: %s", err)
+	}
+	id, err := gen.IdentityOf(data)
+	if err != nil {
+		return nil, err
+	}
+	if id.Eui64 != eui {
+		return nil, fmt.Errorf("sigdata is for %016X, not %016X", id.Eui64, eui)
+	}
+	return id, nil
+}
+
+var errSigfileExists = errors.New("signature file exists")
+
+// commitIdentity keeps the signature area of an identity in the sigdir and
+// marks its EUI in the pool, the file is removed when the marking fails.
+func commitIdentity(euifile string, sigdir string, tag string, id *signature.Identity) error {
+	sigfile := filepath.Join(sigdir, fmt.Sprintf("EUI-64_%016X.bin", id.Eui64))
+	if _, err := os.Stat(sigfile); err == nil {
+		return errSigfileExists
+	}
+	if err := writeSigdirFile(sigfile, id.Sigdata, 0440); err != nil {
+		return err
+	}
+	if err := markIdentity(euifile, tag, id); err != nil {
+		os.Remove(sigfile)
+		return err
+	}
+	registerSigfile(id.Eui64, sigfile)
+	return nil
+}
+
+func (self *euiServer) mark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST a mark", http.StatusMethodNotAllowed)
+		return
+	}
+	var req MarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "malformed mark", http.StatusBadRequest)
+		return
+	}
+	eui, err := parseEui(req.Eui64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	l, ok := self.leases[eui]
+	if !ok || time.Now().After(l.expires) {
+		http.Error(w, fmt.Sprintf("%016X is not allocated or its lease has expired", eui), http.StatusConflict)
+		return
+	}
+	id, err := self.identity(eui, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := commitIdentity(self.opts.Euifile, self.opts.Sigdir, l.tag, id); err == errSigfileExists {
+		http.Error(w, fmt.Sprintf("signature file for %016X exists", eui), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delete(self.leases, eui)
+	fmt.Printf("%016X marked by %s\n", eui, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MarkResponse{fmt.Sprintf("%016X", eui), hex.EncodeToString(id.Sigdata)})
+}
+
+func (self *euiServer) sigdata(w http.ResponseWriter, r *http.Request) {
+	eui, err := parseEui(strings.TrimPrefix(r.URL.Path, "/sigdata/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := readSigdirFile(filepath.Join(self.opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui)))
+	if os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("no signature file for %016X", eui), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+func euiServe(opts *EuiServerOptions) error {
+	if _, err := readPool(opts.Euifile); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.Sigdir, 0770); err != nil {
+		return err
+	}
+	srv := &euiServer{opts: opts, leases: make(map[eui64]euiLease)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/allocate", srv.allocate)
+	mux.HandleFunc("/mark", srv.mark)
+	mux.HandleFunc("/sigdata/", srv.sigdata)
+
+	fmt.Printf("Allocating EUIs of %s on %s\n", opts.Euifile, opts.Listen)
+	return http.ListenAndServe(opts.Listen, mux)
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "euiserver",
+		short:   "Serve EUI allocation to the flashing stations",
+		long:    "Allocate EUIs from the pool over HTTP, mark them with the board the station reports and keep the signature files.",
+		options: func() interface{} { return new(EuiServerOptions) },
+		run:     func(c *commandRun) { euiServerMain(c.options.(*EuiServerOptions)) },
+		offline: func(c *commandRun) error { return offlineAddr("euiserver", c.options.(*EuiServerOptions).Listen) },
+	})
+}
+
+func euiServerMain(opts *EuiServerOptions) {
+	if err := euiServe(opts); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,111 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "io"
+import "os"
+import "bytes"
+import "strings"
+import "testing"
+import "time"
+import "net/http"
+import "net/http/httptest"
+import "encoding/hex"
+import "encoding/json"
+
+func testEuiServer(t *testing.T) (*httptest.Server, *EuiServerOptions) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	opts := &EuiServerOptions{
+		Euifile: writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001\n70B3D5D72F000002\n")),
+		Sigdir:  dir,
+		Lease:   time.Minute,
+	}
+	srv := &euiServer{opts: opts, leases: make(map[eui64]euiLease)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/allocate", srv.allocate)
+	mux.HandleFunc("/mark", srv.mark)
+	mux.HandleFunc("/sigdata/", srv.sigdata)
+	hs := httptest.NewServer(mux)
+	t.Cleanup(hs.Close)
+	return hs, opts
+}
+
+// postJson posts v and decodes the response into reply, it returns the
+// status and the body of an error.
+func postJson(t *testing.T, url string, v interface{}, reply interface{}) (int, string) {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK && reply != nil {
+		if err := json.Unmarshal(body, reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, strings.TrimSpace(string(body))
+}
+
+func TestEuiServer(t *testing.T) {
+	hs, opts := testEuiServer(t)
+	var first, second AllocateResponse
+	captureStdout(t, func() {
+		postJson(t, hs.URL+"/allocate", AllocateRequest{}, &first)
+		postJson(t, hs.URL+"/allocate", AllocateRequest{}, &second)
+		if status, body := postJson(t, hs.URL+"/allocate", AllocateRequest{}, nil); status != http.StatusConflict {
+			t.Errorf("allocation from an empty pool is %d %s", status, body)
+		}
+	})
+	if first.Eui64 != "70B3D5D72F000001" || second.Eui64 != "70B3D5D72F000002" || !first.Expires.After(time.Now()) {
+		t.Fatalf("allocated %+v and %+v", first, second)
+	}
+
+	// The server generates the area of the first from the board, the station
+	// the area of the second
+	board := &ProfileComponent{Name: "tsb2", Version: "1.2.3", UUID: "01010101-0101-0101-0101-010101010101", Manufacturer: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"}
+	var marked MarkResponse
+	captureStdout(t, func() {
+		if status, body := postJson(t, hs.URL+"/mark", MarkRequest{Eui64: first.Eui64, Board: board}, &marked); status != http.StatusOK {
+			t.Fatalf("mark with the board is %d %s", status, body)
+		}
+		sigdata := hex.EncodeToString(testArea(t, 0x70B3D5D72F000001))
+		if status, body := postJson(t, hs.URL+"/mark", MarkRequest{Eui64: second.Eui64, Sigdata: sigdata}, nil); status != http.StatusBadRequest || !strings.Contains(body, "sigdata is for 70B3D5D72F000001") {
+			t.Errorf("mark with the area of another EUI is %d %s", status, body)
+		}
+		sigdata = hex.EncodeToString(testArea(t, 0x70B3D5D72F000002))
+		if status, body := postJson(t, hs.URL+"/mark", MarkRequest{Eui64: second.Eui64, Sigdata: sigdata}, nil); status != http.StatusOK {
+			t.Errorf("mark with the sigdata is %d %s", status, body)
+		}
+		if status, body := postJson(t, hs.URL+"/mark", MarkRequest{Eui64: first.Eui64, Board: board}, nil); status != http.StatusConflict {
+			t.Errorf("second mark is %d %s", status, body)
+		}
+	})
+
+	pool, _ := os.ReadFile(opts.Euifile)
+	if lines := strings.Split(string(pool), "\n"); !strings.HasPrefix(lines[0], "70B3D5D72F000001,tsb2") || !strings.HasPrefix(lines[1], "70B3D5D72F000002,tsb2") {
+		t.Errorf("pool is\n%s", pool)
+	}
+
+	resp, err := http.Get(hs.URL + "/sigdata/" + first.Eui64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if hex.EncodeToString(data) != marked.Sigdata {
+		t.Errorf("GET /sigdata is not the marked area")
+	}
+	resp, err = http.Get(hs.URL + "/sigdata/70B3D5D72F000003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /sigdata of an unknown EUI is %s", resp.Status)
+	}
+}
@@ -0,0 +1,59 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "time"
+import "bytes"
+import "strings"
+import "net/http"
+import "io/ioutil"
+import "encoding/hex"
+import "encoding/json"
+
+// Client of the EUI allocation server, --eui-source takes the EUI from the
+// server instead of an euifile and the generated signature area goes back to
+// it for marking:
+//
+//	usersiggen --eui-source http://euiserver:8080 --type board ...
+
+// postEuiServer posts a request to the allocation server and decodes the
+// answer, the server explains a refusal in the body.
+func postEuiServer(source string, path string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	r, err := client.Post(strings.TrimRight(source, "/")+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("allocation server responded %s: %s", r.Status, strings.TrimSpace(string(b)))
+	}
+	return json.Unmarshal(b, resp)
+}
+
+// allocateRemoteEui gets the next free EUI from the allocation server.
+func allocateRemoteEui(source string, tag string, work_order string) (eui64, error) {
+	var resp AllocateResponse
+	if err := postEuiServer(source, "/allocate", AllocateRequest{tag, work_order}, &resp); err != nil {
+		return 0, err
+	}
+	return parseEui(resp.Eui64)
+}
+
+// markRemoteEui reports the signature area of an EUI to the allocation
+// server, which marks the EUI and keeps the area.
+func markRemoteEui(source string, eui eui64, sigdata []byte) error {
+	var resp MarkResponse
+	req := MarkRequest{Eui64: fmt.Sprintf("%016X", eui), Sigdata: hex.EncodeToString(sigdata)}
+	return postEuiServer(source, "/mark", req, &resp)
+}
@@ -0,0 +1,384 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bytes"
+import "time"
+import "encoding/binary"
+
+import "go.thinnect.net/euisig/signature"
+
+// explain narrates the layout of a signature area for firmware engineers: how
+// the records are walked, where every field and CRC is, and what a strict
+// firmware parser would choke on. The layouts come from the format history,
+// so the explanation follows the format of every record.
+
+type ExplainOptions struct {
+	Args struct {
+		File string `positional-arg-name:"file.bin"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type explanation struct {
+	problems []string // A strict parser fails on these
+	notes    []string // Parsers must be written to cope with these
+}
+
+func (self *explanation) problem(format string, args ...interface{}) {
+	self.problems = append(self.problems, fmt.Sprintf(format, args...))
+}
+
+func (self *explanation) note(format string, args ...interface{}) {
+	self.notes = append(self.notes, fmt.Sprintf(format, args...))
+}
+
+func explainHeader(basesz int) {
+	fmt.Printf("The area is a chain of records. Every record starts with the same %d byte\n", basesz)
+	fmt.Printf("header, all numbers are big endian:\n\n")
+	fmt.Printf("  +0  sig_version_major, minor, patch  3 x 1 byte, format of the record\n")
+	fmt.Printf("  +3  signature_size                   2 bytes, the whole record with the CRC\n")
+	fmt.Printf("  +5  signature_type                   1 byte\n")
+	fmt.Printf("  +6  unix_time                        8 bytes, seconds, when it was generated\n\n")
+	fmt.Printf("Every record ends with a check of all of its bytes before it, the 2 byte\n")
+	fmt.Printf("CRC-16/XMODEM unless bits 5 and 6 of sig_version_major select another\n")
+	fmt.Printf("check, the high bit marks a little-endian record. A bootloader reads the\n")
+	fmt.Printf("first 5 bytes of the area, takes the EUI-64 from the first record and\n")
+	fmt.Printf("finds the next record signature_size bytes further. The walk ends at a\n")
+	fmt.Printf("size below %d or above %d, erased flash reads 0xFFFF there, or at the\n", basesz+2, MAX_SIGNATURE_LENGTH)
+	fmt.Printf("end of the area.\n\n")
+}
+
+// explainRecord narrates one record at offset, rec is the record bytes
+// available in the area.
+func explainRecord(x *explanation, n int, offset int, base BaseSignature, rec []byte, seen map[uint8]int) {
+	size := int(base.Signature_size)
+	name := signature.TypeName(base.Signature_type)
+	fmt.Printf("Record %d, %s, bytes %d..%d (%d bytes)\n", n, name, offset, offset+size-1, size)
+	fmt.Printf("  format %d.%d.%d, generated %s\n", base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch,
+		TimestampString(time.Unix(base.Unix_time, 0)))
+
+	if n == 0 && base.Signature_type != SIGNATURE_TYPE_EUI64 {
+		x.problem("record 0 at 0 is %s, bootloaders read the EUI-64 from the first record", name)
+	}
+	if prev, ok := seen[base.Signature_type]; ok {
+		switch base.Signature_type {
+		case SIGNATURE_TYPE_EUI64, SIGNATURE_TYPE_BOARD, SIGNATURE_TYPE_EXTENDED_ID, SIGNATURE_TYPE_DECOMMISSION, SIGNATURE_TYPE_AREA_SIGNATURE, SIGNATURE_TYPE_PIN:
+			x.problem("record %d at %d is a second %s record, the first is record %d", n, offset, name, prev)
+		}
+	} else {
+		seen[base.Signature_type] = n
+	}
+
+	format, err := signature.FormatOf(base)
+	var layout *signature.FormatRecord
+	if err != nil {
+		x.problem("record %d at %d: %s", n, offset, err)
+	} else {
+		for i := range format.Records {
+			if format.Records[i].Type == base.Signature_type {
+				layout = &format.Records[i]
+			}
+		}
+		if latest := latestFormat(format.Encoding); format.Version == latest.Version && formatNewer(base, latest.Version) {
+			x.note("record %d at %d is format %d.%d.%d, newer than %s this explanation knows, fields may have been added",
+				n, offset, base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch, latest.Version)
+		}
+	}
+
+	// The layouts give the sizes with the 2 byte CRC
+	lsize := size - signature.CheckSize(rec) + 2
+
+	if layout == nil {
+		if err == nil {
+			x.problem("record %d at %d has type %d, unknown in format %s, a strict parser stops here, a lenient one skips signature_size bytes",
+				n, offset, base.Signature_type, format.Version)
+		}
+		fmt.Printf("  type %d has no known layout, %d bytes of body\n", base.Signature_type, lsize-binary.Size(base)-2)
+	} else {
+		fixed := layout.Size
+		fmt.Printf("  fields after the header:\n")
+		for _, f := range layout.Fields {
+			if f.Size < 0 {
+				fmt.Printf("    %5d  %-24s the rest up to the CRC\n", offset+f.Offset, f.Name)
+			} else if f.Offset+f.Size <= lsize-2 {
+				fmt.Printf("    %5d  %-24s %s\n", offset+f.Offset, f.Name, plural(f.Size, "byte"))
+			}
+		}
+		variable := len(layout.Fields) > 0 && layout.Fields[len(layout.Fields)-1].Size < 0
+		// A component record in a named slot ends with the slot, after the data
+		slot := 0
+		if base.Signature_type == SIGNATURE_TYPE_COMPONENT && lsize > fixed && len(rec) >= fixed-2 {
+			data_length := int(signature.RecordOrder(rec).Uint16(rec[fixed-4 : fixed-2]))
+			if lsize-fixed == data_length+binary.Size(tslot(0)) {
+				slot = binary.Size(tslot(0))
+			}
+		}
+		if lsize-slot > fixed && !variable {
+			fmt.Printf("    %5d  %-24s %s\n", offset+fixed-2, "data", plural(lsize-fixed-slot, "byte"))
+		}
+		if slot > 0 {
+			fmt.Printf("    %5d  %-24s %s, FNV-1a of the slot name\n", offset+lsize-2-slot, "slot", plural(slot, "byte"))
+			x.note("record %d at %d ends with a slot after the data, parsers expecting %d bytes must use signature_size", n, offset, fixed)
+			lsize -= slot
+		}
+		if lsize < fixed {
+			x.problem("record %d at %d is %d bytes, a %s record is at least %d", n, offset, lsize, name, fixed)
+		} else if lsize > fixed && variable {
+			x.note("record %d at %d carries %d bytes of variable data, parsers must use signature_size and not a fixed lsize",
+				n, offset, lsize-fixed)
+		} else if lsize > fixed && base.Signature_type >= SIGNATURE_TYPE_BOARD && base.Signature_type <= SIGNATURE_TYPE_COMPONENT {
+			x.note("record %d at %d carries %d bytes of component data before the CRC, parsers expecting %d bytes must use signature_size",
+				n, offset, lsize-fixed, fixed)
+		} else if lsize > fixed {
+			x.problem("record %d at %d is %d bytes, %d more than a %s record, a strict parser rejects it", n, offset, lsize, lsize-fixed, name)
+		}
+	}
+
+	if len(rec) < size {
+		x.problem("record %d at %d is truncated, %d of %d bytes in the area", n, offset, len(rec), size)
+		fmt.Printf("  truncated, the CRC is missing\n\n")
+		return
+	}
+	explainCheck(x, n, offset, rec[:size])
+}
+
+// explainCheck explains the check at the end of a record.
+func explainCheck(x *explanation, n int, offset int, rec []byte) {
+	size := len(rec)
+	sz := size - signature.CheckSize(rec)
+	check := signature.CheckName(rec)
+	stored, computed := signature.RecordCheck(rec, sz)
+	status := "OK"
+	if !bytes.Equal(stored, computed) {
+		status = fmt.Sprintf("BAD, computed 0x%X", computed)
+		x.problem("record %d at %d has a bad %s, stored 0x%X computed 0x%X", n, offset, check, stored, computed)
+	}
+	label := "CRC"
+	if check != signature.CHECK_XMODEM {
+		label = check + " check"
+		x.note("record %d at %d ends with a %s check, not the CRC-16/XMODEM", n, offset, check)
+	}
+	fmt.Printf("  %s at %d..%d: 0x%X %s\n\n", label, offset+sz, offset+size-1, stored, status)
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// formatNewer tells if the header is newer than the version.
+func formatNewer(base BaseSignature, version string) bool {
+	var v [3]uint8
+	fmt.Sscanf(version, "%d.%d.%d", &v[0], &v[1], &v[2])
+	h := [3]uint8{base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch}
+	for i := range h {
+		if h[i] != v[i] {
+			return h[i] > v[i]
+		}
+	}
+	return false
+}
+
+// explainCbor explains a record of the CBOR wire format, the fields are in
+// the map by name, so there is no layout to explain.
+func explainCbor(x *explanation, n int, offset int, rec []byte, seen map[uint8]int) (int, error) {
+	size, err := signature.RecordLength(rec)
+	if err != nil {
+		return 0, err
+	}
+	if size > len(rec) {
+		return 0, fmt.Errorf("CBOR record of %d bytes is truncated", size)
+	}
+	base, err := cborBase(rec)
+	if err != nil {
+		return 0, err
+	}
+	name := signature.TypeName(base.Signature_type)
+	fmt.Printf("Record %d, %s, bytes %d..%d (%d bytes)\n", n, name, offset, offset+size-1, size)
+	fmt.Printf("  CBOR wire format, the self-described CBOR tag D9 D9 F7, a map of the fields\n")
+	fmt.Printf("  by name and the CRC of the tag and the map, format %d.%d.%d, generated %s\n\n",
+		base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch, TimestampString(time.Unix(base.Unix_time, 0)))
+
+	if n == 0 && base.Signature_type != SIGNATURE_TYPE_EUI64 {
+		x.problem("record 0 at 0 is %s, bootloaders read the EUI-64 from the first record", name)
+	}
+	if n == 0 {
+		x.note("record 0 is CBOR, bootloaders that read the EUI-64 at a fixed offset need the binary wire format")
+	}
+	if format, err := signature.FormatOfRecord(rec); err != nil {
+		x.problem("record %d at %d: %s", n, offset, err)
+	} else if latest := latestFormat(format.Encoding); format.Version == latest.Version && formatNewer(base, latest.Version) {
+		x.note("record %d at %d is format %d.%d.%d, newer than %s this explanation knows, keys may have been added",
+			n, offset, base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch, latest.Version)
+	}
+	if _, ok := seen[base.Signature_type]; !ok {
+		seen[base.Signature_type] = n
+	}
+	return size, nil
+}
+
+// explainTlv explains a record of the version 4 TLV layout, the header is
+// the one of version 3, the fields follow as tag, length and value.
+func explainTlv(x *explanation, n int, offset int, base BaseSignature, rec []byte, seen map[uint8]int) {
+	size := int(base.Signature_size)
+	name := signature.TypeName(base.Signature_type)
+	fmt.Printf("Record %d, %s, bytes %d..%d (%d bytes)\n", n, name, offset, offset+size-1, size)
+	fmt.Printf("  format %d.%d.%d, TLV layout, generated %s\n", base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch,
+		TimestampString(time.Unix(base.Unix_time, 0)))
+
+	// The tags the layout of the type knows, others are skipped by readers
+	known := make(map[uint8]bool)
+	format, err := signature.FormatOf(base)
+	if err != nil {
+		x.problem("record %d at %d: %s", n, offset, err)
+	} else {
+		if latest := latestFormat(format.Encoding); format.Version == latest.Version && formatNewer(base, latest.Version) {
+			x.note("record %d at %d is format %d.%d.%d, newer than %s this explanation knows, tags may have been added",
+				n, offset, base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch, latest.Version)
+		}
+		for _, r := range format.Records {
+			if r.Type == base.Signature_type {
+				for _, f := range r.Fields {
+					known[f.Tag] = true
+				}
+			}
+		}
+	}
+
+	if n == 0 && base.Signature_type != SIGNATURE_TYPE_EUI64 {
+		x.problem("record 0 at 0 is %s, bootloaders read the EUI-64 from the first record", name)
+	}
+	if _, ok := seen[base.Signature_type]; !ok {
+		seen[base.Signature_type] = n
+	}
+	if len(rec) < size {
+		x.problem("record %d at %d is truncated, %d of %d bytes", n, offset, len(rec), size)
+		fmt.Printf("\n")
+		return
+	}
+	hdr := binary.Size(base)
+	for rd := hdr; rd+3 <= size-signature.CheckSize(rec); {
+		tag := rec[rd]
+		length := int(binary.BigEndian.Uint16(rec[rd+1 : rd+3]))
+		fmt.Printf("  +%-3d tag %-3d %-22s %d bytes\n", rd, tag, signature.TlvTagName(tag), length)
+		if err == nil && !known[tag] {
+			x.note("record %d at %d has tag %d, not in the %s layout of format %s, readers skip it", n, offset, tag, name, format.Version)
+		}
+		rd += 3 + length
+	}
+	var gen UserSignature
+	if _, err := gen.DeserializeTlv(rec); err != nil {
+		x.problem("record %d at %d: %s", n, offset, err)
+	}
+	fmt.Printf("\n")
+}
+
+// latestFormat returns the latest format version of an encoding.
+func latestFormat(encoding string) signature.FormatVersion {
+	var latest signature.FormatVersion
+	for _, v := range signature.FormatHistory() {
+		if v.Encoding == encoding {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// explain prints the narrative of the area and returns the findings.
+func explain(file string, data []byte) *explanation {
+	x := new(explanation)
+	var gen UserSignature
+	var base BaseSignature
+	basesz := binary.Size(base)
+
+	records := sigRecords(data)
+	fmt.Printf("%s is a signature area of %d bytes with %d records.\n\n", file, len(data), len(records))
+	explainHeader(basesz)
+
+	seen := make(map[uint8]int)
+	offset := 0
+	for n := 0; offset+basesz <= len(data); n++ {
+		if signature.IsCborRecord(data[offset:]) {
+			size, err := explainCbor(x, n, offset, data[offset:], seen)
+			if err != nil {
+				x.problem("record %d at %d: %s, the walk ends there", n, offset, err)
+				break
+			}
+			offset += size
+			continue
+		}
+		base, _ = gen.DeserializeBaseSignature(data[offset:])
+		if base.Signature_size == 0xFFFF {
+			break
+		}
+		if base.Signature_size < uint16(basesz)+2 || base.Signature_size > MAX_SIGNATURE_LENGTH {
+			x.problem("signature_size %d at %d is not a record size, the walk ends there", base.Signature_size, offset+3)
+			break
+		}
+		if signature.RecordOrder(data[offset:]) == binary.LittleEndian {
+			x.note("record %d at %d is little-endian, the high bit of sig_version_major is set, the numbers and the CRC are little-endian", n, offset)
+		}
+		if base.Sig_version_major == signature.TLV_VERSION_MAJOR {
+			explainTlv(x, n, offset, base, data[offset:], seen)
+		} else {
+			explainRecord(x, n, offset, base, data[offset:], seen)
+		}
+		offset += int(base.Signature_size)
+	}
+
+	if offset < len(data) {
+		rest := data[offset:]
+		if len(trimErased(rest)) == 0 {
+			fmt.Printf("Bytes %d..%d are 0xFF, erased flash after the last record.\n\n", offset, len(data)-1)
+			x.note("%d bytes of 0xFF padding at %d, parsers must stop at signature_size 0xFFFF", len(rest), offset)
+		} else {
+			fmt.Printf("Bytes %d..%d follow the last record: %s\n\n", offset, len(data)-1, hexBytes(rest, 16))
+			x.problem("%d bytes at %d are neither a record nor 0xFF padding", len(rest), offset)
+		}
+	}
+	if _, ok := seen[SIGNATURE_TYPE_EUI64]; !ok {
+		x.problem("the area has no EUI-64 record")
+	}
+	return x
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "explain",
+		short:   "Explain the layout of a signature file",
+		long:    "Narrate how the records of a signature area are walked, where the fields and CRCs are, and flag anything a strict firmware parser would choke on.",
+		options: func() interface{} { return new(ExplainOptions) },
+		run:     func(c *commandRun) { explainMain(c.options.(*ExplainOptions)) },
+	})
+}
+
+func explainMain(opts *ExplainOptions) {
+	data, err := readSigdirFile(opts.Args.File)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	x := explain(opts.Args.File, data)
+	if len(x.notes) > 0 {
+		fmt.Printf("Parsers must cope with:\n")
+		for _, n := range x.notes {
+			fmt.Printf("  - %s\n", n)
+		}
+		fmt.Printf("\n")
+	}
+	if len(x.problems) == 0 {
+		fmt.Printf("Nothing a strict firmware parser would choke on.\n")
+		return
+	}
+	fmt.Printf("A strict firmware parser would choke on:\n")
+	for _, p := range x.problems {
+		fmt.Printf("  - %s\n", p)
+	}
+	os.Exit(4)
+}
@@ -0,0 +1,53 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "bytes"
+import "strings"
+import "testing"
+
+func TestExplain(t *testing.T) {
+	area := append(testArea(t, 0x70B3D5D72F000001), bytes.Repeat([]byte{0xFF}, 32)...)
+	var x *explanation
+	out := captureStdout(t, func() { x = explain("sigdata.bin", area) })
+	if len(x.problems) != 0 {
+		t.Errorf("problems in a good area: %q", x.problems)
+	}
+	if len(x.notes) != 1 || !strings.Contains(x.notes[0], "32 bytes of 0xFF padding at 282") {
+		t.Errorf("notes are %q", x.notes)
+	}
+	for _, line := range []string{
+		"sigdata.bin is a signature area of 314 bytes with 4 records.",
+		"Record 1, board, bytes 24..109 (86 bytes)",
+		"CRC at 280..281: 0x4E4E OK",
+		"Bytes 282..313 are 0xFF, erased flash after the last record.",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("no %q in\n%s", line, out)
+		}
+	}
+}
+
+// What a strict firmware parser would choke on is flagged.
+func TestExplainProblems(t *testing.T) {
+	area := testArea(t, 0x70B3D5D72F000001)
+	area[60] ^= 0x20
+	var x *explanation
+	captureStdout(t, func() { x = explain("changed.bin", area) })
+	if len(x.problems) != 1 || !strings.Contains(x.problems[0], "record 1 at 24 has a bad xmodem") {
+		t.Errorf("changed board record: %q", x.problems)
+	}
+
+	// Without the EUI-64 record, junk after the records
+	area = append(testArea(t, 0x70B3D5D72F000001)[24:], 0x01, 0x02, 0x03)
+	captureStdout(t, func() { x = explain("noeui.bin", area) })
+	want := []string{
+		"record 0 at 0 is board, bootloaders read the EUI-64 from the first record",
+		"3 bytes at 258 are neither a record nor 0xFF padding",
+		"the area has no EUI-64 record",
+	}
+	if strings.Join(x.problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems are %q, want %q", x.problems, want)
+	}
+}
@@ -0,0 +1,262 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "encoding/json"
+import "encoding/hex"
+import "crypto/sha256"
+import "time"
+
+import "go.thinnect.net/euisig/eui"
+import "go.thinnect.net/euisig/signature"
+
+// Device twin export. The structure a platform ingests is derived from the
+// signatures: the device (EUI) has a board and a platform, the platform (or
+// the device when there is none) has the components.
+
+const DTMI_DEVICE = "dtmi:com:thinnect:Device;1"
+const DTMI_BOARD = "dtmi:com:thinnect:Board;1"
+const DTMI_PLATFORM = "dtmi:com:thinnect:Platform;1"
+const DTMI_COMPONENT = "dtmi:com:thinnect:Component;1"
+
+type ExportOptions struct {
+	Format   string `long:"format"   default:"adt" choice:"adt" choice:"jsonld" description:"Azure Digital Twins import document or generic JSON-LD."`
+	Registry string `long:"registry" description:"License registry to include issued licenses from."`
+	Plain    bool   `long:"allow-plaintext" description:"Allow exporting an encrypted signature file in plaintext."`
+	Args     struct {
+		Sigfile string `positional-arg-name:"sigdata.bin"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type twinNode struct {
+	Id         string
+	Model      string
+	Properties map[string]interface{}
+}
+
+type twinRelationship struct {
+	Source string
+	Target string
+	Name   string
+}
+
+type deviceTwin struct {
+	Nodes         []twinNode
+	Relationships []twinRelationship
+}
+
+func uuidString(u tuuid) string {
+	return eui.UuidString(u)
+}
+
+func componentProperties(s ComponentSignature) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         s.BoardName(),
+		"uuid":         uuidString(s.Component_uuid),
+		"version":      s.BoardVersion(),
+		"serialNumber": uuidString(s.Serial_number),
+		"manufacturer": uuidString(s.Manufacturer_uuid),
+		"position":     s.Position,
+		"created":      TimestampString(time.Unix(s.Unix_time, 0).UTC()),
+	}
+}
+
+// buildDeviceTwin turns the signatures of a device into twin nodes and the
+// relationships between them.
+func buildDeviceTwin(sigs []interface{}, licenses []IssuedLicense) (*deviceTwin, error) {
+	twin := new(deviceTwin)
+
+	var device *twinNode
+	for _, sig := range sigs {
+		if s, ok := sig.(EUISignature); ok {
+			id := fmt.Sprintf("%016X", s.Eui64)
+			device = &twinNode{id, DTMI_DEVICE, map[string]interface{}{
+				"eui64":   id,
+				"created": TimestampString(time.Unix(s.Unix_time, 0).UTC()),
+			}}
+		}
+	}
+	if device == nil {
+		return nil, fmt.Errorf("No EUI64 signature, the device has no identity")
+	}
+
+	parent := device.Id
+	components := make([]twinNode, 0)
+	for _, sig := range sigs {
+		if c, ok := signature.ComponentOf(sig); ok {
+			sig = c
+		}
+		switch s := sig.(type) {
+		case ComponentSignature:
+			switch s.Signature_type {
+			case SIGNATURE_TYPE_BOARD:
+				twin.Nodes = append(twin.Nodes, twinNode{device.Id + "-board", DTMI_BOARD, componentProperties(s)})
+				twin.Relationships = append(twin.Relationships, twinRelationship{device.Id, device.Id + "-board", "hasBoard"})
+			case SIGNATURE_TYPE_PLATFORM:
+				parent = device.Id + "-platform"
+				twin.Nodes = append(twin.Nodes, twinNode{parent, DTMI_PLATFORM, componentProperties(s)})
+				twin.Relationships = append(twin.Relationships, twinRelationship{device.Id, parent, "hasPlatform"})
+			case SIGNATURE_TYPE_COMPONENT:
+				id := fmt.Sprintf("%s-component-%d-%s", device.Id, s.Position, s.BoardName())
+				components = append(components, twinNode{id, DTMI_COMPONENT, componentProperties(s)})
+			}
+		case ComplianceSignature:
+			b, _ := json.Marshal(s)
+			var compliance map[string]interface{}
+			json.Unmarshal(b, &compliance)
+			device.Properties["compliance"] = compliance
+		case LicenseSignature:
+			sum := sha256.Sum256(s.Lic_file)
+			device.Properties["license"] = map[string]interface{}{
+				"size":   len(s.Lic_file),
+				"sha256": hex.EncodeToString(sum[:]),
+			}
+		case LicenseV2Signature:
+			sum := sha256.Sum256(s.Lic_file)
+			device.Properties["license"] = map[string]interface{}{
+				"size":      len(s.Lic_file),
+				"sha256":    hex.EncodeToString(sum[:]),
+				"notBefore": s.Not_before,
+				"notAfter":  s.Not_after,
+			}
+		}
+	}
+
+	for _, c := range components {
+		twin.Nodes = append(twin.Nodes, c)
+		twin.Relationships = append(twin.Relationships, twinRelationship{parent, c.Id, "hasComponent"})
+	}
+
+	if len(licenses) > 0 {
+		issued := make([]map[string]interface{}, 0, len(licenses))
+		for _, l := range licenses {
+			issued = append(issued, map[string]interface{}{"type": l.Type, "issued": TimestampString(l.Time)})
+		}
+		device.Properties["issuedLicenses"] = issued
+	}
+
+	twin.Nodes = append([]twinNode{*device}, twin.Nodes...)
+	return twin, nil
+}
+
+// Azure Digital Twins import layout, twins and relationships as the ADT REST
+// API accepts them.
+func (twin *deviceTwin) adt() interface{} {
+	twins := make([]map[string]interface{}, 0, len(twin.Nodes))
+	for _, n := range twin.Nodes {
+		t := map[string]interface{}{
+			"$dtId":     n.Id,
+			"$metadata": map[string]string{"$model": n.Model},
+		}
+		for k, v := range n.Properties {
+			t[k] = v
+		}
+		twins = append(twins, t)
+	}
+
+	rels := make([]map[string]string, 0, len(twin.Relationships))
+	for _, r := range twin.Relationships {
+		rels = append(rels, map[string]string{
+			"$relationshipId":   r.Source + "-" + r.Name + "-" + r.Target,
+			"$sourceId":         r.Source,
+			"$targetId":         r.Target,
+			"$relationshipName": r.Name,
+		})
+	}
+
+	return map[string]interface{}{
+		"schema":        SCHEMA_TWIN,
+		"generator":     generatorVersion(),
+		"twins":         twins,
+		"relationships": rels,
+	}
+}
+
+// JSON-LD layout, a graph of nodes where relationships are @id references.
+func (twin *deviceTwin) jsonld() interface{} {
+	graph := make([]map[string]interface{}, 0, len(twin.Nodes))
+	index := make(map[string]map[string]interface{})
+	for _, n := range twin.Nodes {
+		node := map[string]interface{}{"@id": "urn:thinnect:" + n.Id, "@type": n.Model}
+		for k, v := range n.Properties {
+			node[k] = v
+		}
+		index[n.Id] = node
+		graph = append(graph, node)
+	}
+
+	for _, r := range twin.Relationships {
+		src := index[r.Source]
+		ref := map[string]string{"@id": "urn:thinnect:" + r.Target}
+		if lst, ok := src[r.Name].([]interface{}); ok {
+			src[r.Name] = append(lst, ref)
+		} else {
+			src[r.Name] = []interface{}{ref}
+		}
+	}
+
+	return map[string]interface{}{
+		"@context": map[string]string{
+			"@vocab":  "https://schema.thinnect.com/device#",
+			"created": "http://purl.org/dc/terms/created",
+		},
+		"schema":    SCHEMA_TWIN,
+		"generator": generatorVersion(),
+		"@graph":    graph,
+	}
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "export",
+		short:   "Export signatures as a device twin document",
+		long:    "Convert decoded signatures and license information into an Azure Digital Twins or JSON-LD document.",
+		options: func() interface{} { return new(ExportOptions) },
+		run:     func(c *commandRun) { exportMain(c.options.(*ExportOptions)) },
+	})
+}
+
+func exportMain(opts *ExportOptions) {
+	if fileIsSealed(opts.Args.Sigfile) && !opts.Plain {
+		fmt.Printf("ERROR %s is encrypted, exporting it in plaintext needs --allow-plaintext\n", opts.Args.Sigfile)
+		os.Exit(4)
+	}
+
+	sigs, err := readSigsFromFile(opts.Args.Sigfile)
+	if err != nil {
+		fmt.Printf("Failed to read signature from file [%s]: %s\n", opts.Args.Sigfile, err)
+		os.Exit(3)
+	}
+
+	var licenses []IssuedLicense
+	if len(opts.Registry) > 0 {
+		for _, sig := range sigs {
+			if s, ok := sig.(EUISignature); ok {
+				licenses, err = findIssuedLicenses(opts.Registry, s.Eui64)
+				if err != nil {
+					fmt.Printf("ERROR reading license registry: %s\n", err)
+					os.Exit(1)
+				}
+			}
+		}
+	}
+
+	twin, err := buildDeviceTwin(sigs, licenses)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	var doc interface{}
+	if opts.Format == "jsonld" {
+		doc = twin.jsonld()
+	} else {
+		doc = twin.adt()
+	}
+
+	j, _ := json.MarshalIndent(doc, "", "	")
+	fmt.Println(string(j))
+}
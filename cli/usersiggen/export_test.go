@@ -0,0 +1,100 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "testing"
+import "encoding/json"
+
+func TestDeviceTwin(t *testing.T) {
+	sigs, err := readSigs(testArea(t, 0x70B3D5D72F000001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	twin, err := buildDeviceTwin(sigs, []IssuedLicense{{Type: "connectivity", Time: g_test_time}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{"70B3D5D72F000001", "70B3D5D72F000001-board", "70B3D5D72F000001-platform", "70B3D5D72F000001-component-0-sht31"}
+	if len(twin.Nodes) != len(ids) {
+		t.Fatalf("%d nodes, want %d: %+v", len(twin.Nodes), len(ids), twin.Nodes)
+	}
+	for i, id := range ids {
+		if twin.Nodes[i].Id != id {
+			t.Errorf("node %d is %s, want %s", i, twin.Nodes[i].Id, id)
+		}
+	}
+	board := twin.Nodes[1].Properties
+	if board["name"] != "tsb2" || board["uuid"] != "01010101-0101-0101-0101-010101010101" || board["manufacturer"] != "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" {
+		t.Errorf("board is %v", board)
+	}
+	if issued, ok := twin.Nodes[0].Properties["issuedLicenses"].([]map[string]interface{}); !ok || issued[0]["type"] != "connectivity" {
+		t.Errorf("issued licenses are %v", twin.Nodes[0].Properties["issuedLicenses"])
+	}
+
+	// The component hangs off the platform when there is one
+	want := []twinRelationship{
+		{"70B3D5D72F000001", "70B3D5D72F000001-board", "hasBoard"},
+		{"70B3D5D72F000001", "70B3D5D72F000001-platform", "hasPlatform"},
+		{"70B3D5D72F000001-platform", "70B3D5D72F000001-component-0-sht31", "hasComponent"},
+	}
+	if len(twin.Relationships) != len(want) {
+		t.Fatalf("relationships are %v", twin.Relationships)
+	}
+	for i, r := range want {
+		if twin.Relationships[i] != r {
+			t.Errorf("relationship %d is %v, want %v", i, twin.Relationships[i], r)
+		}
+	}
+}
+
+func TestDeviceTwinLayouts(t *testing.T) {
+	sigs, err := readSigs(testArea(t, 0x70B3D5D72F000001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	twin, err := buildDeviceTwin(sigs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var adt struct {
+		Twins         []map[string]interface{} `json:"twins"`
+		Relationships []map[string]string      `json:"relationships"`
+	}
+	b, _ := json.Marshal(twin.adt())
+	if err := json.Unmarshal(b, &adt); err != nil {
+		t.Fatal(err)
+	}
+	if len(adt.Twins) != 4 || adt.Twins[0]["$dtId"] != "70B3D5D72F000001" || len(adt.Relationships) != 3 {
+		t.Errorf("ADT document is %s", b)
+	}
+	if r := adt.Relationships[2]; r["$sourceId"] != "70B3D5D72F000001-platform" || r["$relationshipName"] != "hasComponent" {
+		t.Errorf("relationship is %v", r)
+	}
+
+	var ld struct {
+		Graph []map[string]interface{} `json:"@graph"`
+	}
+	b, _ = json.Marshal(twin.jsonld())
+	if err := json.Unmarshal(b, &ld); err != nil {
+		t.Fatal(err)
+	}
+	if len(ld.Graph) != 4 || ld.Graph[0]["@id"] != "urn:thinnect:70B3D5D72F000001" {
+		t.Fatalf("JSON-LD document is %s", b)
+	}
+	if refs, ok := ld.Graph[2]["hasComponent"].([]interface{}); !ok || len(refs) != 1 {
+		t.Errorf("platform is %v", ld.Graph[2])
+	}
+}
+
+func TestDeviceTwinNoEui(t *testing.T) {
+	sigs, err := readSigs(testArea(t, 0x70B3D5D72F000001)[24:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildDeviceTwin(sigs, nil); err == nil {
+		t.Errorf("a twin without an identity")
+	}
+}
@@ -0,0 +1,203 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bufio"
+import "errors"
+import "strings"
+import "path/filepath"
+import "time"
+
+import "go.thinnect.net/euisig/eui"
+
+// Extended identity, a 128-bit identifier for protocols that cannot work with
+// an EUI-64, recorded in the extended identity record. The identifier is either allocated from an ID-128 pool
+// (euigen id128) or derived from the EUI-64 within a namespace, in which case
+// the namespace is recorded so the derivation can be repeated.
+
+func parseId128(s string) (tid128, error) {
+	id, err := eui.Parse128(s)
+	return tid128(id), err
+}
+
+// ExtendedIdConfig describes the extended identity of a provisioning profile,
+// allocated from a pool or derived in a namespace.
+type ExtendedIdConfig struct {
+	Pool      string `json:"pool"`
+	Namespace string `json:"namespace"`
+}
+
+// deriveId128 derives the identifier of an EUI-64 in a namespace.
+func deriveId128(namespace string, e eui64) (tid128, tuuid, error) {
+	ns, err := parseUuid(namespace)
+	if err != nil {
+		return tid128{}, tuuid{}, fmt.Errorf("Namespace %s: %s", namespace, err)
+	}
+	return tid128(eui.DeriveId128(ns, eui.Eui64(e))), ns, nil
+}
+
+// getId128 returns the first unmarked identifier of an ID-128 pool.
+func getId128(infile string) (tid128, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return tid128{}, err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) == 0 || strings.HasPrefix(t, "#") {
+			continue
+		}
+		splits := strings.Split(t, ",")
+		if len(splits) == 1 || (len(splits) == 2 && len(splits[1]) == 0) {
+			return parseId128(splits[0])
+		}
+	}
+
+	return tid128{}, errors.New(fmt.Sprintf("Could not find a suitable ID-128 in %s!", infile))
+}
+
+// markId128 records in the pool the EUI-64 an identifier was given to.
+func markId128(infile string, id tid128, owner eui64, t time.Time) error {
+	infile, err := filepath.Abs(infile)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	outfile := filepath.Join(filepath.Dir(infile), fmt.Sprintf("id128_temp_%d.txt", t.Unix()))
+	out, err := os.OpenFile(outfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	key := id.String()
+	marked := false
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	writer := bufio.NewWriter(out)
+	for scanner.Scan() {
+		t2 := strings.TrimSpace(scanner.Text())
+		splits := strings.Split(t2, ",")
+		if !marked && !strings.HasPrefix(t2, "#") && strings.EqualFold(splits[0], key) &&
+			(len(splits) == 1 || (len(splits) == 2 && len(splits[1]) == 0)) {
+			writer.WriteString(fmt.Sprintf("%s,%016X,%d\n", splits[0], owner, t.Unix()))
+			marked = true
+		} else {
+			writer.WriteString(scanner.Text() + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		os.Remove(outfile)
+		return err
+	}
+	if !marked {
+		os.Remove(outfile)
+		return fmt.Errorf("%s is not free in %s", key, infile)
+	}
+
+	in.Close()
+	writer.Flush()
+	out.Close()
+
+	if err := rotateBackup(infile); err != nil {
+		return err
+	}
+	return os.Rename(outfile, infile)
+}
+
+// buildExtendedId constructs the extended identity record of an EUI-64 as the
+// configuration describes it.
+func buildExtendedId(gen *UserSignature, t time.Time, cfg ExtendedIdConfig, owner eui64) (*ExtendedIdSignature, error) {
+	var id tid128
+	var ns tuuid
+	var err error
+	if len(cfg.Pool) > 0 && len(cfg.Namespace) > 0 {
+		return nil, errors.New("The extended identity is either allocated from a pool or derived in a namespace, not both")
+	} else if len(cfg.Pool) > 0 {
+		id, err = getId128(cfg.Pool)
+	} else if len(cfg.Namespace) > 0 {
+		id, ns, err = deriveId128(cfg.Namespace, owner)
+	} else {
+		err = errors.New("The extended identity needs a pool or a namespace")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gen.ConstructExtendedIdSignature(t, id, ns)
+}
+
+// extidMain appends the extended identity of --type extid to the signature
+// file, allocated from an ID-128 pool, derived from the EUI or given.
+func extidMain(gen *UserSignature, opts *Options, timestamp time.Time) {
+	if _, err := os.Stat(opts.Output); os.IsNotExist(err) {
+		fmt.Printf("ERROR initial signature file %s not found!\n", opts.Output)
+		os.Exit(1)
+	}
+	sigs, err := readSigsFromFile(opts.Output)
+	if err != nil {
+		fmt.Printf("ERROR reading signature file: %s\n", err)
+		os.Exit(1)
+	}
+	var owner eui64
+	for _, s := range sigs {
+		if es, ok := s.(EUISignature); ok {
+			owner = es.Eui64
+		}
+		if _, ok := s.(ExtendedIdSignature); ok {
+			fmt.Printf("ERROR %s already has an extended identity\n", opts.Output)
+			os.Exit(4)
+		}
+	}
+
+	var esig *ExtendedIdSignature
+	if len(opts.Id128) > 0 {
+		if len(opts.Id128Pool) > 0 || len(opts.Id128Namespace) > 0 {
+			fmt.Printf("ERROR --id128 can not be combined with --id128-pool or --id128-namespace\n")
+			os.Exit(2)
+		}
+		id, err := parseId128(opts.Id128)
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(2)
+		}
+		esig, err = gen.ConstructExtendedIdSignature(timestamp, id, tuuid{})
+	} else {
+		esig, err = buildExtendedId(gen, timestamp, ExtendedIdConfig{Pool: opts.Id128Pool, Namespace: opts.Id128Namespace}, owner)
+	}
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+
+	esigdata, err := gen.Serialize(esig)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+
+	err = appendFile(opts.Output, esigdata)
+	if err != nil {
+		fmt.Printf("ERROR appending extended identity to file: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(opts.Id128Pool) > 0 {
+		if err := markId128(opts.Id128Pool, esig.Id128, owner, timestamp); err != nil {
+			fmt.Printf("ERROR marking %s used: %s\n", esig.Id128, err)
+			os.Exit(1)
+		}
+	}
+	outFormatMain(opts)
+	os.Exit(0)
+}
@@ -0,0 +1,287 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "strings"
+import "strconv"
+import "bufio"
+import "time"
+import "sync"
+import "net"
+import "path/filepath"
+import "encoding/json"
+
+// The fixture driver hands out identities to the slots of a multi-DUT test
+// fixture over TCP. The protocol is line based, every request names a slot:
+//
+//	REQUEST <slot>          -> IDENTITY <slot> <EUI> <sigdata hex>
+//	PASS <slot>             -> OK <slot> <EUI>
+//	FAIL <slot> [reason]    -> RETRY <slot> <attempt> | RELEASED <slot> <EUI>
+//
+// Errors are answered with ERROR <slot> <message>. An identity stays with its
+// slot until it passes or runs out of retries, only passed identities are
+// written to the sigdir and marked in the pool.
+//
+// When the calibration source of a component of the profile is "fixture" the
+// fixture measures the device in the middle of the test and reports the
+// measurements, the calibration record is built from them:
+//
+//	REQUEST <slot>          -> MEASURE <slot> <EUI> <measurement,...>
+//	MEASURED <slot> <json>  -> IDENTITY <slot> <EUI> <sigdata hex>
+//
+// The measurements are a JSON object of the names and values. A slot may
+// report them again after a failure, the signature block is built anew.
+
+type FixtureOptions struct {
+	Listen     string `long:"listen"      default:":7000" description:"Address the fixture connects to."`
+	Profile    string `long:"profile"     required:"true" description:"Provisioning profile name or path to a profile JSON file."`
+	ProfileDir string `long:"profile-dir" default:"profiles" description:"Directory containing <profile>.json files."`
+	Slots      uint   `long:"slots"       default:"12" description:"Number of DUT slots in the fixture."`
+	Retries    uint   `long:"retries"     default:"2" description:"Times a slot may retry the same identity after a failure."`
+}
+
+type fixtureSlot struct {
+	dev      *ProvisionDevice
+	attempts uint
+}
+
+type fixtureServer struct {
+	mutex   sync.Mutex
+	profile *ProvisionProfile
+	opts    *FixtureOptions
+	slots   map[uint]*fixtureSlot
+	claimed map[eui64]bool
+
+	measurements []string // Reported by the fixture, nil when the profile needs none
+}
+
+// fixtureMeasurements lists the measurements the fixture reports for the
+// components of the profile, nil when it reports none.
+func fixtureMeasurements(p *ProvisionProfile) ([]string, error) {
+	components := append([]ProfileComponent{p.Board}, p.Components...)
+	if p.Platform != nil {
+		components = append(components, *p.Platform)
+	}
+	var names []string
+	for _, c := range components {
+		if c.Calibration == nil || c.Calibration.Source != CALIBRATION_SOURCE_FIXTURE {
+			continue
+		}
+		plugin, err := findCalibration(c.Calibration.Sensor)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", c.Name, err)
+		}
+		names = append(names, plugin.Measurements()...)
+	}
+	return names, nil
+}
+
+func (self *fixtureServer) identity(slot uint, dev *ProvisionDevice) string {
+	if dev.Identity == nil {
+		return fmt.Sprintf("MEASURE %d %016X %s", slot, dev.Eui, strings.Join(self.measurements, ","))
+	}
+	return fmt.Sprintf("IDENTITY %d %016X %X", slot, dev.Eui, dev.Identity.Sigdata)
+}
+
+func (self *fixtureServer) request(slot uint) string {
+	if s, ok := self.slots[slot]; ok {
+		return self.identity(slot, s.dev)
+	}
+
+	eui, err := getEuiExcept(self.profile.Euifile, self.profile.Tag, "", self.claimed)
+	if err != nil {
+		return fmt.Sprintf("ERROR %d %s", slot, err)
+	}
+
+	dev := &ProvisionDevice{Eui: eui, Timestamp: time.Now().UTC()}
+	if self.measurements == nil {
+		if _, err := buildSigdata(new(UserSignature), self.profile, dev); err != nil {
+			return fmt.Sprintf("ERROR %d %s", slot, err)
+		}
+	}
+
+	self.claimed[eui] = true
+	self.slots[slot] = &fixtureSlot{dev: dev}
+	return self.identity(slot, dev)
+}
+
+// measured builds the signature block of a slot from the measurements of the
+// fixture.
+func (self *fixtureServer) measured(slot uint, report string) string {
+	s, ok := self.slots[slot]
+	if !ok {
+		return fmt.Sprintf("ERROR %d no identity assigned", slot)
+	}
+	if self.measurements == nil {
+		return fmt.Sprintf("ERROR %d the profile takes no measurements from the fixture", slot)
+	}
+	var m Measurements
+	if err := json.Unmarshal([]byte(report), &m); err != nil {
+		return fmt.Sprintf("ERROR %d measurements: %s", slot, err)
+	}
+
+	dev := &ProvisionDevice{Eui: s.dev.Eui, Timestamp: s.dev.Timestamp, Measured: m}
+	if _, err := buildSigdata(new(UserSignature), self.profile, dev); err != nil {
+		return fmt.Sprintf("ERROR %d %s", slot, err)
+	}
+	s.dev = dev
+	fmt.Printf("slot %2d measured %016X\n", slot, dev.Eui)
+	return self.identity(slot, dev)
+}
+
+func (self *fixtureServer) pass(slot uint) string {
+	s, ok := self.slots[slot]
+	if !ok {
+		return fmt.Sprintf("ERROR %d no identity assigned", slot)
+	}
+
+	dev := s.dev
+	if dev.Identity == nil {
+		return fmt.Sprintf("ERROR %d no signature block, the measurements were not reported", slot)
+	}
+	dev.Sigfile = filepath.Join(self.profile.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", dev.Eui))
+	if _, err := os.Stat(self.profile.Sigdir); os.IsNotExist(err) {
+		if err = os.Mkdir(self.profile.Sigdir, 0770); err != nil {
+			return fmt.Sprintf("ERROR %d %s", slot, err)
+		}
+	}
+	if err := rotateBackup(dev.Sigfile); err != nil {
+		return fmt.Sprintf("ERROR %d %s", slot, err)
+	}
+	if err := writeSigdirFile(dev.Sigfile, dev.Identity.Sigdata, 0440); err != nil {
+		return fmt.Sprintf("ERROR %d %s", slot, err)
+	}
+	if err := markIdentity(self.profile.Euifile, self.profile.Tag, dev.Identity); err != nil {
+		os.Remove(dev.Sigfile)
+		return fmt.Sprintf("ERROR %d %s", slot, err)
+	}
+	registerSigfile(dev.Eui, dev.Sigfile)
+
+	delete(self.slots, slot)
+	delete(self.claimed, dev.Eui)
+	fmt.Printf("slot %2d passed %016X\n", slot, dev.Eui)
+	return fmt.Sprintf("OK %d %016X", slot, dev.Eui)
+}
+
+func (self *fixtureServer) fail(slot uint, reason string) string {
+	s, ok := self.slots[slot]
+	if !ok {
+		return fmt.Sprintf("ERROR %d no identity assigned", slot)
+	}
+
+	s.attempts++
+	fmt.Printf("slot %2d failed %016X attempt %d: %s\n", slot, s.dev.Eui, s.attempts, reason)
+	if s.attempts <= self.opts.Retries {
+		return fmt.Sprintf("RETRY %d %d", slot, s.attempts)
+	}
+
+	delete(self.slots, slot)
+	delete(self.claimed, s.dev.Eui)
+	return fmt.Sprintf("RELEASED %d %016X", slot, s.dev.Eui)
+}
+
+func (self *fixtureServer) handle(line string) string {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(fields) < 2 {
+		return "ERROR - expected <command> <slot>"
+	}
+
+	slot, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil || slot < 1 || uint(slot) > self.opts.Slots {
+		return fmt.Sprintf("ERROR %s slot must be 1..%d", fields[1], self.opts.Slots)
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	switch strings.ToUpper(fields[0]) {
+	case "REQUEST":
+		return self.request(uint(slot))
+	case "PASS":
+		return self.pass(uint(slot))
+	case "FAIL":
+		reason := ""
+		if len(fields) > 2 {
+			reason = fields[2]
+		}
+		return self.fail(uint(slot), reason)
+	case "MEASURED":
+		if len(fields) < 3 {
+			return fmt.Sprintf("ERROR %d expected MEASURED <slot> <json>", slot)
+		}
+		return self.measured(uint(slot), fields[2])
+	}
+	return fmt.Sprintf("ERROR %d unknown command %s", slot, fields[0])
+}
+
+func (self *fixtureServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(conn, "%s\n", self.handle(scanner.Text())); err != nil {
+			return
+		}
+	}
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "fixture",
+		short:   "Serve identities to a multi-DUT test fixture",
+		long:    "Hand out per-slot identities over TCP, mark them in the pool only when the slot passes.",
+		options: func() interface{} { return new(FixtureOptions) },
+		run:     func(c *commandRun) { fixtureMain(c.options.(*FixtureOptions)) },
+		offline: func(c *commandRun) error { return offlineAddr("fixture driver", c.options.(*FixtureOptions).Listen) },
+	})
+}
+
+func fixtureMain(opts *FixtureOptions) {
+	profile, err := loadProvisionProfile(opts.Profile, opts.ProfileDir)
+	if err != nil {
+		fmt.Printf("ERROR loading profile: %s\n", err)
+		os.Exit(1)
+	}
+	if err := offlineProfile(profile); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	if len(profile.Euifile) == 0 {
+		fmt.Printf("ERROR profile has no euifile\n")
+		os.Exit(1)
+	}
+
+	srv := &fixtureServer{
+		profile: profile,
+		opts:    opts,
+		slots:   make(map[uint]*fixtureSlot),
+		claimed: make(map[eui64]bool),
+	}
+	if srv.measurements, err = fixtureMeasurements(profile); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Fixture driver for %d slots on %s\n", opts.Slots, opts.Listen)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+		go srv.serve(conn)
+	}
+}
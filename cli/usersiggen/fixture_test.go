@@ -0,0 +1,85 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "net"
+import "bufio"
+import "strings"
+import "testing"
+import "path/filepath"
+
+func testFixture(t *testing.T) *fixtureServer {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	testProfile(t, dir, "")
+	profile, err := loadProvisionProfile("line1", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fixtureServer{profile: profile, opts: &FixtureOptions{Slots: 4, Retries: 1},
+		slots: make(map[uint]*fixtureSlot), claimed: make(map[eui64]bool)}
+}
+
+func TestFixture(t *testing.T) {
+	srv := testFixture(t)
+	captureStdout(t, func() {
+		for _, c := range []struct {
+			request string
+			reply   string
+		}{
+			{"REQUEST 1", "IDENTITY 1 70B3D5D72F000001 "},
+			{"REQUEST 2", "IDENTITY 2 70B3D5D72F000002 "},
+			{"request 1", "IDENTITY 1 70B3D5D72F000001 "},
+			{"REQUEST 3", "ERROR 3 "},
+			{"FAIL 2 no radio", "RETRY 2 1"},
+			{"FAIL 2 no radio", "RELEASED 2 70B3D5D72F000002"},
+			{"PASS 2", "ERROR 2 no identity assigned"},
+			{"PASS 1", "OK 1 70B3D5D72F000001"},
+			{"REQUEST 5", "ERROR 5 slot must be 1..4"},
+			{"BURN 1", "ERROR 1 unknown command BURN"},
+			{"REQUEST", "ERROR - expected <command> <slot>"},
+		} {
+			if reply := srv.handle(c.request); !strings.HasPrefix(reply, c.reply) {
+				t.Errorf("%s is answered %s, want %s", c.request, reply, c.reply)
+			}
+		}
+	})
+
+	// Only the passed identity is written and marked
+	pool, _ := os.ReadFile(srv.profile.Euifile)
+	if lines := strings.Split(string(pool), "\n"); !strings.HasPrefix(lines[0], "70B3D5D72F000001,") || lines[1] != "70B3D5D72F000002" {
+		t.Errorf("pool is\n%s", pool)
+	}
+	sigs, err := readSigsFromFile(filepath.Join(srv.profile.Sigdir, "EUI-64_70B3D5D72F000001.bin"))
+	if err != nil || len(sigs) != 3 {
+		t.Errorf("%d records written for the passed slot: %v", len(sigs), err)
+	}
+}
+
+// The fixture talks to the driver over a connection, a line per request.
+func TestFixtureServe(t *testing.T) {
+	srv := testFixture(t)
+	fixture, driver := net.Pipe()
+	go srv.serve(driver)
+	defer fixture.Close()
+
+	replies := bufio.NewScanner(fixture)
+	captureStdout(t, func() {
+		for _, c := range []struct {
+			request string
+			reply   string
+		}{
+			{"REQUEST 4\n\n", "IDENTITY 4 70B3D5D72F000001 "},
+			{"FAIL 4 timeout\n", "RETRY 4 1"},
+		} {
+			if _, err := fixture.Write([]byte(c.request)); err != nil {
+				t.Fatal(err)
+			}
+			if !replies.Scan() || !strings.HasPrefix(replies.Text(), c.reply) {
+				t.Errorf("%q is answered %q, want %s", c.request, replies.Text(), c.reply)
+			}
+		}
+	})
+}
@@ -0,0 +1,327 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "io/ioutil"
+import "strings"
+import "encoding/json"
+import "bufio"
+import "bytes"
+import "time"
+import "sort"
+import "sync"
+import "net/http"
+import "path/filepath"
+
+import "go.thinnect.net/euisig/signature"
+
+// Heartbeat is reported by a station to the central fleet endpoint after every
+// provisioned device.
+type Heartbeat struct {
+	Schema  string    `json:"schema"`
+	Station string    `json:"station"`
+	Site    string    `json:"site"`
+	Version string    `json:"version"` // Generator version of the station
+	Time    time.Time `json:"time"`
+
+	PoolRemaining int     `json:"pool_remaining"` // -1 when the pool could not be read
+	PoolDaysLeft  float64 `json:"pool_days_left"` // Forecast, -1 when it cannot be estimated
+
+	LastDevice  string            `json:"last_device"`
+	LastStatus  string            `json:"last_status"`
+	Provisioned uint64            `json:"provisioned"`
+	Failed      uint64            `json:"failed"`
+	StageErrors map[string]uint64 `json:"stage_errors"`
+}
+
+type HeartbeatConfig struct {
+	Url     string `json:"url"`
+	Station string `json:"station"`
+	Site    string `json:"site"`
+	State   string `json:"state"` // Counters are kept here between runs, defaults to <sigdir>/station.json
+}
+
+type FleetServeOptions struct {
+	Listen string `long:"listen" default:":8090" description:"Address to accept heartbeats on."`
+	State  string `long:"state"  description:"Persist the latest heartbeat of every station to this file."`
+}
+
+type FleetStatusOptions struct {
+	Url      string        `long:"url"   required:"true" description:"Fleet endpoint, e.g. http://fleet:8090."`
+	Stale    time.Duration `long:"stale"     default:"15m" description:"Stations silent for longer are flagged as stale."`
+	WarnDays int           `long:"warn-days" default:"60" description:"Warn about stations whose pool is forecast to run out sooner."`
+}
+
+type FleetOptions struct {
+	Serve  FleetServeOptions  `command:"serve"  description:"Collect station heartbeats."`
+	Status FleetStatusOptions `command:"status" description:"Show the latest heartbeat of every station."`
+}
+
+func generatorVersion() string {
+	return fmt.Sprintf("%d.%d.%d", signature.VersionMajor, signature.VersionMinor, signature.VersionPatch)
+}
+
+// countFreeEuis counts the EUIs getEui would still hand out.
+func countFreeEuis(infile string) (int, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) > 0 && strings.HasPrefix(t, "#") == false {
+			splits := strings.Split(t, ",")
+			if len(splits) == 1 || (len(splits) == 2 && len(splits[1]) == 0) {
+				count++
+			}
+		}
+	}
+	return count, scanner.Err()
+}
+
+func loadHeartbeatState(file string) Heartbeat {
+	var hb Heartbeat
+	if b, err := ioutil.ReadFile(file); err == nil {
+		json.Unmarshal(b, &hb)
+	}
+	if hb.StageErrors == nil {
+		hb.StageErrors = make(map[string]uint64)
+	}
+	return hb
+}
+
+func writeJsonFile(file string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "	")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// reportHeartbeat updates the station counters with the outcome of a run and
+// sends them to the fleet endpoint. Failures are only warnings, a missing
+// fleet endpoint must not stop production.
+func reportHeartbeat(profile *ProvisionProfile, dev *ProvisionDevice, results []StageResult, failure error) {
+	cfg := profile.Heartbeat
+	if cfg == nil {
+		return
+	}
+
+	state := cfg.State
+	if len(state) == 0 {
+		state = filepath.Join(profile.Sigdir, "station.json")
+	}
+
+	hb := loadHeartbeatState(state)
+	hb.Station = cfg.Station
+	hb.Site = cfg.Site
+	hb.Schema = SCHEMA_HEARTBEAT
+	hb.Version = generatorVersion()
+	hb.Time = time.Now().UTC()
+	if dev.Eui != 0 {
+		hb.LastDevice = fmt.Sprintf("%016X", dev.Eui)
+	}
+
+	if failure != nil {
+		hb.Failed++
+		hb.LastStatus = STAGE_FAILED
+		for _, r := range results {
+			if r.Status == STAGE_FAILED {
+				hb.StageErrors[r.Stage]++
+			}
+		}
+	} else {
+		hb.Provisioned++
+		hb.LastStatus = STAGE_OK
+	}
+
+	hb.PoolRemaining = -1
+	hb.PoolDaysLeft = -1
+	if len(profile.Euifile) > 0 {
+		if n, err := countFreeEuis(profile.Euifile); err == nil {
+			hb.PoolRemaining = n
+		}
+		hb.PoolDaysLeft = poolDaysLeft(profile.Euifile)
+	}
+
+	if err := writeJsonFile(state, hb); err != nil {
+		fmt.Printf("WARNING: failed to store station state in %s: %s\n", state, err)
+	}
+
+	if len(cfg.Url) == 0 {
+		return
+	}
+
+	body, _ := json.Marshal(hb)
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(strings.TrimRight(cfg.Url, "/")+"/heartbeat", "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("WARNING: heartbeat not delivered: %s\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		fmt.Printf("WARNING: heartbeat rejected: %s\n", resp.Status)
+	}
+}
+
+type fleetServer struct {
+	mutex    sync.Mutex
+	stations map[string]Heartbeat
+	state    string
+}
+
+func (self *fleetServer) heartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST a heartbeat", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var hb Heartbeat
+	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil || len(hb.Station) == 0 {
+		http.Error(w, "malformed heartbeat", http.StatusBadRequest)
+		return
+	}
+
+	hb.Time = hb.Time.UTC()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.stations[hb.Site+"/"+hb.Station] = hb
+	if len(self.state) > 0 {
+		if err := writeJsonFile(self.state, self.stations); err != nil {
+			fmt.Printf("WARNING: failed to store fleet state: %s\n", err)
+		}
+	}
+}
+
+func (self *fleetServer) fleet(w http.ResponseWriter, r *http.Request) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	list := make([]Heartbeat, 0, len(self.stations))
+	for _, hb := range self.stations {
+		list = append(list, hb)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func fleetServe(opts *FleetServeOptions) error {
+	srv := &fleetServer{stations: make(map[string]Heartbeat), state: opts.State}
+	if len(opts.State) > 0 {
+		if b, err := ioutil.ReadFile(opts.State); err == nil {
+			if err := json.Unmarshal(b, &srv.stations); err != nil {
+				return fmt.Errorf("Failed to parse fleet state %s: %s", opts.State, err)
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heartbeat", srv.heartbeat)
+	mux.HandleFunc("/fleet", srv.fleet)
+
+	fmt.Printf("Collecting heartbeats on %s\n", opts.Listen)
+	return http.ListenAndServe(opts.Listen, mux)
+}
+
+func fleetStatus(opts *FleetStatusOptions) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimRight(opts.Url, "/") + "/fleet")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fleet endpoint responded %s", resp.Status)
+	}
+
+	var list []Heartbeat
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return err
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Site != list[j].Site {
+			return list[i].Site < list[j].Site
+		}
+		return list[i].Station < list[j].Station
+	})
+
+	fmt.Printf("%-12s %-12s %-8s %8s %-16s %-7s %8s %8s  %s\n",
+		"SITE", "STATION", "VERSION", "POOL", "LAST DEVICE", "STATUS", "OK", "FAILED", "LAST SEEN")
+
+	var provisioned, failed uint64
+	warnings := make([]string, 0)
+	for _, hb := range list {
+		age := time.Since(hb.Time).Truncate(time.Second)
+		seen := fmt.Sprintf("%s (%s ago)", TimestampString(hb.Time), age)
+		if age > opts.Stale {
+			seen += " STALE"
+		}
+		pool := "?"
+		if hb.PoolRemaining >= 0 {
+			pool = fmt.Sprintf("%d", hb.PoolRemaining)
+		}
+		fmt.Printf("%-12s %-12s %-8s %8s %-16s %-7s %8d %8d  %s\n",
+			hb.Site, hb.Station, hb.Version, pool, hb.LastDevice, hb.LastStatus, hb.Provisioned, hb.Failed, seen)
+
+		provisioned += hb.Provisioned
+		failed += hb.Failed
+
+		if hb.PoolRemaining == 0 || (hb.PoolDaysLeft >= 0 && hb.PoolDaysLeft < float64(opts.WarnDays)) {
+			warnings = append(warnings, fmt.Sprintf("WARNING: pool of %s/%s runs out in %.0f days", hb.Site, hb.Station, hb.PoolDaysLeft))
+		}
+	}
+	fmt.Printf("%d stations, %d provisioned, %d failed\n", len(list), provisioned, failed)
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "fleet",
+		short:   "Station fleet overview",
+		long:    "Collect heartbeats from provisioning stations or show the fleet status.",
+		options: func() interface{} { return new(FleetOptions) },
+		run:     func(c *commandRun) { fleetMain(c.command, c.options.(*FleetOptions)) },
+		offline: func(c *commandRun) error {
+			opts := c.options.(*FleetOptions)
+			switch c.command {
+			case "serve":
+				return offlineAddr("fleet serve", opts.Serve.Listen)
+			case "status":
+				return offlineUrl("fleet status", opts.Status.Url)
+			}
+			return nil
+		},
+	})
+}
+
+func fleetMain(command string, opts *FleetOptions) {
+	var err error
+	switch command {
+	case "serve":
+		err = fleetServe(&opts.Serve)
+	case "status":
+		err = fleetStatus(&opts.Status)
+	}
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,72 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "errors"
+import "time"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "path/filepath"
+
+func TestCountFreeEuis(t *testing.T) {
+	pool := writeTestFile(t, t.TempDir(), "euis.txt", []byte("# pool\n70B3D5D72F000001,tsb2\n70B3D5D72F000002\n\n70B3D5D72F000003,\n"))
+	if n, err := countFreeEuis(pool); err != nil || n != 2 {
+		t.Errorf("%d free EUIs: %v", n, err)
+	}
+}
+
+// Stations report to the fleet endpoint after every device, the status lists
+// them with their counters.
+func TestFleet(t *testing.T) {
+	fleet := &fleetServer{stations: make(map[string]Heartbeat), state: filepath.Join(t.TempDir(), "fleet.json")}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heartbeat", fleet.heartbeat)
+	mux.HandleFunc("/fleet", fleet.fleet)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	profile := &ProvisionProfile{
+		Euifile:   writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001,tsb2\n70B3D5D72F000002\n")),
+		Sigdir:    dir,
+		Heartbeat: &HeartbeatConfig{Url: srv.URL + "/", Station: "line1", Site: "tallinn"},
+	}
+	failed := []StageResult{{Stage: "flash", Status: STAGE_FAILED}}
+	for _, failure := range []error{nil, errors.New("stage flash failed"), nil} {
+		out := captureStdout(t, func() {
+			reportHeartbeat(profile, &ProvisionDevice{Eui: 0x70B3D5D72F000001}, failed, failure)
+		})
+		if len(out) > 0 {
+			t.Errorf("heartbeat printed %q", out)
+		}
+	}
+
+	hb := fleet.stations["tallinn/line1"]
+	if hb.Schema != SCHEMA_HEARTBEAT || hb.Provisioned != 2 || hb.Failed != 1 || hb.StageErrors["flash"] != 1 || hb.PoolRemaining != 1 || hb.LastDevice != "70B3D5D72F000001" {
+		t.Errorf("heartbeat is %+v", hb)
+	}
+	if state := loadHeartbeatState(filepath.Join(dir, "station.json")); state.Provisioned != 2 {
+		t.Errorf("station state is %+v", state)
+	}
+
+	out := captureStdout(t, func() {
+		if err := fleetStatus(&FleetStatusOptions{Url: srv.URL, Stale: time.Minute, WarnDays: 60}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "tallinn      line1") || !strings.Contains(out, "1 stations, 2 provisioned, 1 failed") {
+		t.Errorf("fleet status is\n%s", out)
+	}
+
+	resp, err := http.Get(srv.URL + "/heartbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /heartbeat is %s", resp.Status)
+	}
+}
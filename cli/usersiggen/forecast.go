@@ -0,0 +1,226 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "sort"
+import "time"
+import "encoding/json"
+
+// The pool file is the allocation history, every marked EUI carries the board
+// name and the time it was assigned. The consumption rate over a recent
+// window gives the date the free EUIs run out.
+
+const FORECAST_WINDOW_DAYS = 30
+
+type ForecastOptions struct {
+	WindowDays int  `long:"window-days" default:"30" description:"Allocation history to base the consumption rate on."`
+	WarnDays   int  `long:"warn-days"   default:"60" description:"Warn when a pool runs out sooner, exit code 4."`
+	Json       bool `long:"json"        description:"Output the forecast as JSON."`
+	Args       struct {
+		Euifiles []string `positional-arg-name:"eui.txt"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type ProductForecast struct {
+	Product   string  `json:"product"`
+	Used      int     `json:"used"`
+	Recent    int     `json:"recent"` // Allocated within the window
+	PerDay    float64 `json:"per_day"`
+	Share     float64 `json:"share"` // Of the recent pool consumption
+	LastAlloc string  `json:"last_allocation,omitempty"`
+}
+
+type PoolForecast struct {
+	Pool      string            `json:"pool"`
+	Free      int               `json:"free"`
+	Used      int               `json:"used"`
+	PerDay    float64           `json:"per_day"`
+	DaysLeft  float64           `json:"days_left"` // -1 when nothing was allocated in the window
+	Depletion string            `json:"depletion,omitempty"`
+	Warning   bool              `json:"warning"`
+	Products  []ProductForecast `json:"products"`
+	Tags      []TagForecast     `json:"tags,omitempty"` // Only for partitioned pools
+}
+
+// TagForecast is the forecast of one pool partition, what runs out first of
+// the free EUIs of the partition and the quota of the tag.
+type TagForecast struct {
+	TagStats
+	Recent    int     `json:"recent"`
+	PerDay    float64 `json:"per_day"`
+	DaysLeft  float64 `json:"days_left"`
+	Depletion string  `json:"depletion,omitempty"`
+	Warning   bool    `json:"warning"`
+}
+
+// forecastPool estimates when the free EUIs of a pool run out at the rate
+// they were allocated during the window before now.
+func forecastPool(name string, entries []PoolEntry, tags PoolTags, now time.Time, window time.Duration) PoolForecast {
+	f := PoolForecast{Pool: name, DaysLeft: -1, Products: make([]ProductForecast, 0)}
+	days := window.Hours() / 24
+
+	products := make(map[string]*ProductForecast)
+	last := make(map[string]time.Time)
+	recent := 0
+	for _, e := range entries {
+		switch e.Status {
+		case POOL_FREE:
+			f.Free++
+		case POOL_USED, POOL_RETIRED:
+			f.Used++
+			p, ok := products[e.Board]
+			if !ok {
+				p = &ProductForecast{Product: e.Board}
+				products[e.Board] = p
+			}
+			p.Used++
+			if !e.Marked.IsZero() && e.Marked.After(now.Add(-window)) {
+				p.Recent++
+				recent++
+			}
+			if e.Marked.After(last[e.Board]) {
+				last[e.Board] = e.Marked
+			}
+		}
+	}
+
+	for _, p := range products {
+		p.PerDay = float64(p.Recent) / days
+		if t, ok := last[p.Product]; ok {
+			p.LastAlloc = TimestampString(t)
+		}
+		if recent > 0 {
+			p.Share = float64(p.Recent) / float64(recent)
+		}
+		f.Products = append(f.Products, *p)
+	}
+	sort.Slice(f.Products, func(i, j int) bool { return f.Products[i].Recent > f.Products[j].Recent })
+
+	f.PerDay = float64(recent) / days
+	if f.PerDay > 0 {
+		f.DaysLeft = float64(f.Free) / f.PerDay
+		f.Depletion = TimestampString(now.Add(time.Duration(f.DaysLeft * 24 * float64(time.Hour))))
+	}
+
+	if len(tags) > 0 {
+		for _, st := range poolTagStats(entries, tags) {
+			t := TagForecast{TagStats: st, DaysLeft: -1}
+			for _, e := range entries {
+				if (e.Status == POOL_USED || e.Status == POOL_RETIRED) && e.Tag == st.Name && !e.Marked.IsZero() && e.Marked.After(now.Add(-window)) {
+					t.Recent++
+				}
+			}
+			t.PerDay = float64(t.Recent) / days
+			if t.PerDay > 0 {
+				t.DaysLeft = float64(t.Available) / t.PerDay
+				t.Depletion = TimestampString(now.Add(time.Duration(t.DaysLeft * 24 * float64(time.Hour))))
+			}
+			f.Tags = append(f.Tags, t)
+		}
+	}
+	return f
+}
+
+// poolDaysLeft is the forecast for a single pool file over the default
+// window, -1 when it cannot be estimated.
+func poolDaysLeft(infile string) float64 {
+	entries, err := readPool(infile)
+	if err != nil {
+		return -1
+	}
+	return forecastPool(infile, entries, nil, time.Now().UTC(), FORECAST_WINDOW_DAYS*24*time.Hour).DaysLeft
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "forecast",
+		short:   "Forecast when the EUI pools run out",
+		long:    "Estimate the depletion date of every pool from the allocation rate per product, warn when a new EUI block is needed.",
+		options: func() interface{} { return new(ForecastOptions) },
+		run:     func(c *commandRun) { forecastMain(c.options.(*ForecastOptions)) },
+	})
+}
+
+func forecastMain(opts *ForecastOptions) {
+	if opts.WindowDays <= 0 {
+		fmt.Printf("ERROR window must be at least a day\n")
+		os.Exit(2)
+	}
+
+	now := time.Now().UTC()
+	window := time.Duration(opts.WindowDays) * 24 * time.Hour
+
+	forecasts := make([]PoolForecast, 0)
+	warnings := 0
+	for _, infile := range opts.Args.Euifiles {
+		entries, err := readPool(infile)
+		if err != nil {
+			fmt.Printf("ERROR reading %s: %s\n", infile, err)
+			os.Exit(1)
+		}
+		tags, err := readPoolTags(infile)
+		if err != nil {
+			fmt.Printf("ERROR reading %s: %s\n", infile, err)
+			os.Exit(1)
+		}
+		f := forecastPool(infile, entries, tags, now, window)
+		if f.Free == 0 || (f.DaysLeft >= 0 && f.DaysLeft < float64(opts.WarnDays)) {
+			f.Warning = true
+			warnings++
+		}
+		for i := range f.Tags {
+			t := &f.Tags[i]
+			if (t.Available == 0 && (len(t.Name) > 0 || t.Used > 0)) || (t.DaysLeft >= 0 && t.DaysLeft < float64(opts.WarnDays)) {
+				t.Warning = true
+				warnings++
+			}
+		}
+		forecasts = append(forecasts, f)
+	}
+
+	if opts.Json {
+		j, _ := json.MarshalIndent(map[string]interface{}{
+			"schema":      SCHEMA_FORECAST,
+			"generator":   generatorVersion(),
+			"time":        TimestampString(now),
+			"window_days": opts.WindowDays,
+			"pools":       forecasts,
+		}, "", "	")
+		fmt.Println(string(j))
+	} else {
+		for _, f := range forecasts {
+			depletion := "never at the current rate"
+			if f.DaysLeft >= 0 {
+				depletion = fmt.Sprintf("%s (%.0f days)", f.Depletion, f.DaysLeft)
+			}
+			fmt.Printf("%s: %d free, %d used, %.1f/day, runs out %s\n", f.Pool, f.Free, f.Used, f.PerDay, depletion)
+			for _, p := range f.Products {
+				fmt.Printf("  %-16s %8d used %6d recent %8.1f/day %5.1f%%  last %s\n",
+					p.Product, p.Used, p.Recent, p.PerDay, p.Share*100, p.LastAlloc)
+			}
+			for _, t := range f.Tags {
+				depletion := "never at the current rate"
+				if t.DaysLeft >= 0 {
+					depletion = fmt.Sprintf("%s (%.0f days)", t.Depletion, t.DaysLeft)
+				}
+				fmt.Printf("  tag %-12s %8d used %6d available %8.1f/day  runs out %s\n",
+					tagLabel(t.Name), t.Used, t.Available, t.PerDay, depletion)
+			}
+			if f.Warning {
+				fmt.Printf("WARNING: %s runs out within %d days, order a new EUI block\n", f.Pool, opts.WarnDays)
+			}
+			for _, t := range f.Tags {
+				if t.Warning {
+					fmt.Printf("WARNING: tag %s of %s runs out within %d days\n", tagLabel(t.Name), f.Pool, opts.WarnDays)
+				}
+			}
+		}
+	}
+
+	if warnings > 0 {
+		os.Exit(4)
+	}
+}
@@ -0,0 +1,42 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "testing"
+import "time"
+
+func TestForecastPool(t *testing.T) {
+	day := 24 * time.Hour
+	entries := make([]PoolEntry, 0)
+	for i, board := range []string{"tsb2", "tsb2", "tsb2", "tsb2", "tsb3", "tsb2"} {
+		marked := g_test_time.Add(-time.Duration(i+1) * day)
+		if i == 5 {
+			marked = g_test_time.Add(-40 * day)
+		}
+		entries = append(entries, PoolEntry{Eui: eui64(i + 1), Status: POOL_USED, Board: board, Marked: marked})
+	}
+	for i := 0; i < 10; i++ {
+		entries = append(entries, PoolEntry{Eui: eui64(100 + i), Status: POOL_FREE})
+	}
+
+	f := forecastPool("euis.txt", entries, nil, g_test_time, 10*day)
+	if f.Free != 10 || f.Used != 6 || f.PerDay != 0.5 || f.DaysLeft != 20 || f.Depletion != TimestampString(g_test_time.Add(20*day)) {
+		t.Errorf("forecast is %+v", f)
+	}
+	if len(f.Products) != 2 {
+		t.Fatalf("products are %+v", f.Products)
+	}
+	if p := f.Products[0]; p.Product != "tsb2" || p.Used != 5 || p.Recent != 4 || p.Share != 0.8 || p.LastAlloc != TimestampString(g_test_time.Add(-day)) {
+		t.Errorf("tsb2 is %+v", p)
+	}
+	if p := f.Products[1]; p.Product != "tsb3" || p.Recent != 1 || p.PerDay != 0.1 {
+		t.Errorf("tsb3 is %+v", p)
+	}
+
+	// Nothing allocated in the window, no estimate
+	f = forecastPool("euis.txt", entries, nil, g_test_time.Add(100*day), 10*day)
+	if f.DaysLeft != -1 || len(f.Depletion) != 0 {
+		t.Errorf("forecast without allocations is %+v", f)
+	}
+}
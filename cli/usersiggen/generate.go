@@ -0,0 +1,457 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/jessevdk/go-flags"
+
+import "go.thinnect.net/euisig/signature"
+
+// attachDatafile attaches the contents of a file to the record as component
+// specific data.
+func attachDatafile(gen *UserSignature, sig *ComponentSignature, infile string) (*ComponentDataSignature, error) {
+	b, err := ioutil.ReadFile(infile)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("Data file %s is empty", infile)
+	}
+	return gen.AttachData(sig, b)
+}
+
+func appendFile(outfile string, data []byte) error {
+	f, err := os.OpenFile(outfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		fmt.Printf("ERROR opening output file: %s\n", err)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		fmt.Printf("ERROR writing output file: %s\n", err)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		fmt.Printf("ERROR closing output file: %s\n", err)
+		return err
+	}
+	return nil
+}
+
+// generateMain generates a board, platform or component signature, the
+// records of the --type and the EUI of a board.
+func generateMain(gen *UserSignature, opts *Options, parser *flags.Parser, timestamp time.Time) {
+	var eui eui64
+	var err error
+	var sigdata []byte
+
+	// We are generating a signature. Verify mandatory options for this operation
+	required_opts := []string{"type", "name", "version", "uuid", "manufacturer"}
+	for _, long_opt_name := range required_opts {
+		opt := parser.FindOptionByLongName(long_opt_name)
+		if !opt.IsSet() {
+			fmt.Printf("Required flag `--%s' was not specified\n", long_opt_name)
+			os.Exit(2)
+		}
+	}
+
+	if _, err = os.Stat(opts.Sigdir); os.IsNotExist(err) {
+		err = os.Mkdir(opts.Sigdir, 0770)
+		if err != nil {
+			fmt.Printf("ERROR creating output directory: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var component_uuid [16]byte
+	component_uuid, err = parseUuid(opts.UUID)
+	if err != nil {
+		fmt.Printf("UUID error(%d)", err)
+		os.Exit(1)
+	}
+
+	var manufacturer_uuid [16]byte
+	manufacturer_uuid, err = parseUuid(opts.Manufacturer)
+	if err != nil {
+		fmt.Printf("Manufacturer UUID error(%d)", err)
+		os.Exit(1)
+	}
+
+	var serial [16]byte
+	if len(opts.SerialUUID) > 0 {
+		serial, err = parseUuid(opts.SerialUUID)
+		if err != nil {
+			fmt.Printf("Serial UUID error(%d)", err)
+			os.Exit(1)
+		}
+		if enc := gen.Emulating(); enc != nil {
+			serial = enc.SerialFromUuid(serial, component_uuid)
+		}
+	} else if len(opts.Serial) > 0 {
+		if len(opts.Serial) > 16 {
+			fmt.Printf("Serial number string too long, max 16 characters.")
+			os.Exit(1)
+		}
+		copy(serial[:], opts.Serial)
+	} else {
+		if opts.Debug {
+			fmt.Printf("WARNING: No serial number.")
+		}
+	}
+
+	if len(opts.Seqdir) == 0 {
+		opts.Seqdir = filepath.Join(opts.Sigdir, "workorders")
+	}
+
+	if opts.PanelSize > 0 && len(opts.WorkOrder) == 0 {
+		fmt.Printf("ERROR --panel-size requires a --work-order\n")
+		os.Exit(2)
+	}
+
+	if opts.Count == 0 {
+		fmt.Printf("ERROR --count must be at least 1\n")
+		os.Exit(2)
+	}
+	if len(opts.EuiSource) > 0 && len(opts.Euifile) > 0 {
+		fmt.Printf("ERROR --eui-source and --euifile are both a source of EUIs, give one\n")
+		os.Exit(2)
+	}
+	if opts.Count > 1 && opts.Type != "board" {
+		fmt.Printf("ERROR --count is for board signatures\n")
+		os.Exit(2)
+	}
+
+	if opts.Type == "board" && opts.Count > 1 {
+		if err := checkBatch(opts); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(2)
+		}
+		if err := generateBatch(gen, opts, timestamp, component_uuid, manufacturer_uuid); err != nil {
+			fmt.Printf("ERROR generating batch: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if opts.Type == "board" {
+		overrideEui := false
+		includeEui := true
+		if len(opts.Eui) > 0 {
+			overrideEui = true
+			eui, err = parseEui(opts.Eui)
+			if err != nil {
+				fmt.Printf("ERROR parsing EUI64: %s\n", err)
+				os.Exit(1)
+			}
+		} else if len(opts.EuiSource) > 0 {
+			eui, err = allocateRemoteEui(opts.EuiSource, opts.Tag, opts.WorkOrder)
+			if err != nil {
+				fmt.Printf("ERROR getting EUI64 from %s: %s\n", opts.EuiSource, err)
+				os.Exit(1)
+			}
+		} else if len(opts.Euifile) > 0 {
+			eui, err = getEui(opts.Euifile, opts.Tag, opts.WorkOrder)
+			if err != nil {
+				fmt.Printf("ERROR getting EUI64: %s\n", err)
+				os.Exit(1)
+			}
+		} else {
+			includeEui = false
+			fmt.Printf("Generating signature without EUI64.\n")
+		}
+
+		var sigfile string
+		var esig *EUISignature
+		var esigdata []byte
+		if includeEui == true {
+			sigfile = filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui))
+			if overrideEui == false && opts.IfExists == IF_EXISTS_FAIL {
+				if _, err := os.Stat(sigfile); err == nil {
+					fmt.Printf("ERROR generating sigdata: signature file for %016X exists at %s\n", eui, sigfile)
+					os.Exit(1)
+				}
+			}
+
+			esig, err = gen.ConstructEUISignature(timestamp, eui)
+			if err != nil {
+				fmt.Printf("ERROR generating sigdata: %s\n", err)
+				os.Exit(1)
+			}
+
+			esigdata, err = gen.Serialize(esig)
+			if err != nil {
+				fmt.Printf("ERROR generating sigdata: %s\n", err)
+				os.Exit(1)
+			}
+		} else {
+			sigfile = filepath.Join(opts.Sigdir, fmt.Sprintf("Tstmp_%d.bin", timestamp.Unix()))
+		}
+
+		var sequence uint32
+		if len(opts.WorkOrder) > 0 {
+			if len(opts.Serial) > 0 || len(opts.SerialUUID) > 0 {
+				fmt.Printf("ERROR a serial number can not be specified together with a work order\n")
+				os.Exit(2)
+			}
+			sequence, err = nextSequence(opts.Seqdir, opts.WorkOrder, opts.WorkOrderSize)
+			if err != nil {
+				fmt.Printf("ERROR getting work order sequence: %s\n", err)
+				os.Exit(1)
+			}
+			serial, err = sequenceSerial(opts.SerialFormat, opts.WorkOrder, sequence)
+			if err != nil {
+				fmt.Printf("ERROR generating sigdata: %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		csig, err := gen.ConstructComponentSignature(timestamp, opts.Name, opts.Version, component_uuid, manufacturer_uuid, serial, opts.Position, SIGNATURE_TYPE_BOARD)
+		if err != nil {
+			fmt.Printf("ERROR generating sigdata: %s\n", err)
+			os.Exit(1)
+		}
+		if err := policyComponent(csig); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+
+		var record interface{} = csig
+		if len(opts.Datafile) > 0 {
+			if record, err = attachDatafile(gen, csig, opts.Datafile); err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		csigdata, err := gen.Serialize(record)
+		if err != nil {
+			fmt.Printf("ERROR generating sigdata: %s\n", err)
+			os.Exit(1)
+		}
+
+		sigdata = append(esigdata, csigdata...)
+
+		var existing []byte
+		if includeEui == true {
+			if existing, err = existingSigfile(sigfile, sigdata, opts.IfExists, overrideEui); err != nil {
+				fmt.Printf("ERROR generating sigdata: %016X: %s\n", eui, err)
+				os.Exit(1)
+			}
+			if existing != nil {
+				// The pool is marked with the board of the existing file
+				fmt.Printf("%016X already generated, skipping\n", eui)
+				sigdata = existing
+			}
+		}
+
+		// The EUI is claimed in the journal of the pool before the signature
+		// file is written, marking the pool commits the run
+		var claims []EuiClaim
+		if existing == nil && overrideEui == false && includeEui == true && len(opts.EuiSource) == 0 {
+			claims = []EuiClaim{newClaim(eui, opts.Tag, sigfile, sigdata)}
+			if err := claimEuis(opts.Euifile, claims); err != nil {
+				fmt.Printf("ERROR claiming %016X in %s: %s\n", eui, opts.Euifile, err)
+				os.Exit(1)
+			}
+		}
+
+		if existing == nil {
+			if err := rotateBackup(sigfile); err != nil {
+				fmt.Printf("ERROR generating sigdata: creating backup file for %016X of %s failed: %s\n", eui, sigfile, err)
+				rollbackClaims(opts.Euifile, claims)
+				os.Exit(1)
+			}
+
+			if err := writeSigdirFile(sigfile, sigdata, 0440); err != nil {
+				fmt.Printf("ERROR writing output file: %s\n", err)
+				rollbackClaims(opts.Euifile, claims)
+				os.Exit(1)
+			}
+			if includeEui {
+				registerSigfile(eui, sigfile)
+			}
+		}
+
+		if overrideEui == false && includeEui == true {
+			if len(opts.EuiSource) > 0 {
+				if err := markRemoteEui(opts.EuiSource, eui, sigdata); err != nil {
+					fmt.Printf("ERROR marking %016X at %s: %s\n", eui, opts.EuiSource, err)
+					if existing == nil {
+						os.Remove(sigfile)
+					}
+					os.Exit(1)
+				}
+			} else if existing != nil {
+				id, err := gen.IdentityOf(existing)
+				if err == nil {
+					err = markIdentity(opts.Euifile, opts.Tag, id)
+				}
+				if err != nil {
+					fmt.Printf("ERROR marking %016X in %s: %s\n", eui, opts.Euifile, err)
+					os.Exit(1)
+				}
+			} else if err := markEui(opts.Euifile, *esig, *csig, opts.Tag); err != nil {
+				fmt.Printf("ERROR marking %016X in %s: %s\n", eui, opts.Euifile, err)
+				rollbackClaims(opts.Euifile, claims)
+				os.Exit(1)
+			}
+		}
+
+		if len(opts.WorkOrder) > 0 {
+			identity := filepath.Base(sigfile)
+			if includeEui {
+				identity = fmt.Sprintf("%016X", eui)
+			}
+			if err := commitSequence(opts.Seqdir, opts.WorkOrder, sequence, identity); err != nil {
+				fmt.Printf("ERROR recording work order sequence: %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := ioutil.WriteFile(opts.Output, sigdata, 0640); err != nil {
+			fmt.Printf("ERROR writing output file: %s\n", err)
+			os.Exit(1)
+		}
+
+		if includeEui == true {
+			g_result.Eui = fmt.Sprintf("%016X", eui)
+		}
+		g_result.sigfile = sigfile
+		g_result.WorkOrder, g_result.Sequence = opts.WorkOrder, sequence
+
+		if includeEui == true {
+			fmt.Printf("EUI-64: %016X\n", eui)
+		} else {
+			fmt.Printf("Timestamp: %d\n", timestamp.Unix())
+		}
+		if len(opts.WorkOrder) > 0 {
+			fmt.Printf("Sequence: %s %d\n", opts.WorkOrder, sequence)
+		}
+		if opts.PanelSize > 0 {
+			panel, position := panelOf(sequence, opts.PanelSize)
+			index, err := writePanelIndex(opts.Seqdir, opts.WorkOrder, panel, opts.PanelSize)
+			if err != nil {
+				fmt.Printf("ERROR writing panel index: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Panel: %s position %d (%s)\n", panelId(opts.WorkOrder, panel), position, index)
+			g_result.Panel, g_result.PanelPosition = panelId(opts.WorkOrder, panel), position
+		}
+
+	} else if opts.Type == "platform" || opts.Type == "component" {
+		var tp uint8
+		if opts.Type == "platform" {
+			tp = SIGNATURE_TYPE_PLATFORM
+		} else if opts.Type == "component" {
+			tp = SIGNATURE_TYPE_COMPONENT
+		}
+
+		if _, err := os.Stat(opts.Output); os.IsNotExist(err) {
+			fmt.Printf("ERROR initial signature file %s not found!", opts.Output)
+			os.Exit(1)
+		}
+
+		csig, err := gen.ConstructComponentSignature(timestamp, opts.Name, opts.Version, component_uuid, manufacturer_uuid, serial, opts.Position, tp)
+		if err != nil {
+			fmt.Printf("ERROR generating sigdata: %s\n", err)
+			os.Exit(1)
+		}
+		if err := policyComponent(csig); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+
+		if len(opts.Slot) > 0 && len(opts.Slots) > 0 {
+			def, err := loadPlatformDefinition(opts.Slots)
+			if err == nil {
+				err = def.checkSlot(opts.Slot, opts.Position)
+			}
+			if err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		var record interface{} = csig
+		if len(opts.Calibration) > 0 {
+			if len(opts.CalibrationSource) == 0 {
+				fmt.Printf("ERROR --calibration needs --calibration-source\n")
+				os.Exit(2)
+			}
+			// The measurements are looked up by the EUI of the signature file
+			area, err := ioutil.ReadFile(opts.Output)
+			if err == nil {
+				eui, err = areaEui(area)
+			}
+			if err != nil {
+				fmt.Printf("ERROR calibration: %s: %s\n", opts.Output, err)
+				os.Exit(1)
+			}
+			data, err := calibrationData(CalibrationConfig{opts.Calibration, opts.CalibrationSource}, eui, opts.Serial)
+			if err != nil {
+				fmt.Printf("ERROR calibration: %s\n", err)
+				os.Exit(1)
+			}
+			if record, err = gen.AttachData(csig, data); err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(1)
+			}
+		} else if len(opts.Datafile) > 0 {
+			if record, err = attachDatafile(gen, csig, opts.Datafile); err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(opts.Slot) > 0 {
+			if record, err = gen.AttachSlot(record, signature.SlotHash(opts.Slot)); err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		csigdata, err := gen.Serialize(record)
+		if err != nil {
+			fmt.Printf("ERROR generating sigdata: %s\n", err)
+			os.Exit(1)
+		}
+
+		err = appendFile(opts.Output, csigdata)
+		if err != nil {
+			fmt.Printf("ERROR appending platform/component data to file: %s\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Printf("%s is not a known signature type, supported types are: board, platform, component and compliance.\n", opts.Type)
+		os.Exit(1)
+	}
+
+	outFormatMain(opts)
+
+	if opts.Debug {
+		printGeneratorVersion()
+		fmt.Printf("Timestamp:    %d (%s)\n", timestamp.Unix(), TimestampString(timestamp))
+		fmt.Printf("Name:         %s\n", opts.Name)
+		fmt.Printf("Version:      %s\n", opts.Version)
+		if len(opts.SerialUUID) > 0 {
+			fmt.Printf("Serial:       %s\n", uuidString(serial))
+		} else {
+			fmt.Printf("Serial:       %s\n", serial)
+		}
+		fmt.Printf("UUID:         %s\n", uuidString(component_uuid))
+		fmt.Printf("Manufacturer: %s\n", uuidString(manufacturer_uuid))
+
+		fmt.Printf("Output:       %s\n", opts.Output)
+		fmt.Printf("Sigdir:       %s\n", opts.Sigdir)
+		fmt.Printf("Euifile:      %s\n", opts.Euifile)
+
+		//fmt.Printf("SIG(%d):\n", len(sigdata))
+		//fmt.Printf("%X\n", sigdata[0:256])
+		//fmt.Printf("%X\n", sigdata[256:512])
+		//fmt.Printf("%X\n", sigdata[512:768])
+	}
+}
@@ -0,0 +1,433 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "sort"
+import "strings"
+import "time"
+import "encoding/json"
+import "path/filepath"
+import "net/http"
+
+import "github.com/graphql-go/graphql"
+
+import "go.thinnect.net/euisig/signature"
+
+// Read-only GraphQL view of the production data. Until there is a database
+// backend it is served from the files: the device signatures in the sigdir,
+// the EUI pool and the license registry, read again for every query.
+
+type GraphqlOptions struct {
+	Listen   string `long:"listen"   default:":8081" description:"Address to serve the GraphQL endpoint on."`
+	Sigdir   string `long:"sigdir"   default:"sigdata" description:"Where the EUI_XXXXXXXXXXXXXXXX.bin files are stored."`
+	Euifile  string `long:"euifile"  default:"eui.txt" description:"The EUI-64 pool file."`
+	Registry string `long:"registry" description:"License registry, defaults to <sigdir>/issued_licenses.jsonl."`
+
+	AggregateOnly bool    `long:"aggregate-only" description:"Serve only the aggregate statistics, for partners, no query reaches the devices."`
+	Epsilon       float64 `long:"epsilon"        default:"1.0" description:"Privacy budget of the noise added to the aggregate counts, 0 for exact counts."`
+	MinCount      uint    `long:"min-count"      default:"5" description:"Suppress the aggregate counts below this."`
+}
+
+type graphqlData struct {
+	devices  []map[string]interface{}
+	pool     []PoolEntry
+	licenses map[string][]IssuedLicense
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func licenseFields(l IssuedLicense) map[string]interface{} {
+	return map[string]interface{}{
+		"eui64":   l.Eui,
+		"type":    l.Type,
+		"params":  l.Params,
+		"issued":  TimestampString(l.Time),
+		"reissue": l.Reissue,
+	}
+}
+
+// deviceFields flattens the signatures of a device for the resolvers.
+func deviceFields(sigs []interface{}) map[string]interface{} {
+	d := map[string]interface{}{"components": []map[string]interface{}{}}
+	for _, sig := range sigs {
+		if c, ok := signature.ComponentOf(sig); ok {
+			sig = c
+		}
+		switch s := sig.(type) {
+		case EUISignature:
+			d["eui64"] = fmt.Sprintf("%016X", s.Eui64)
+			d["unix_time"] = s.Unix_time
+			d["created"] = TimestampString(time.Unix(s.Unix_time, 0).UTC())
+		case ComponentSignature:
+			c := componentProperties(s)
+			switch s.Signature_type {
+			case SIGNATURE_TYPE_BOARD:
+				d["board"] = c
+			case SIGNATURE_TYPE_PLATFORM:
+				d["platform"] = c
+			case SIGNATURE_TYPE_COMPONENT:
+				d["components"] = append(d["components"].([]map[string]interface{}), c)
+			}
+		case ComplianceSignature:
+			b, _ := json.Marshal(s)
+			var compliance map[string]interface{}
+			json.Unmarshal(b, &compliance)
+			d["compliance"] = compliance
+		}
+	}
+	return d
+}
+
+// loadDevices reads the devices of the signature files in the sigdir.
+func loadDevices(sigdir string) ([]map[string]interface{}, error) {
+	files, err := filepath.Glob(filepath.Join(sigdir, "EUI-64_????????????????.bin"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	devices := make([]map[string]interface{}, 0, len(files))
+	for _, f := range files {
+		sigs, err := readSigsFromFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f, err)
+		}
+		if d := deviceFields(sigs); d["eui64"] != nil {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+func loadGraphqlData(opts *GraphqlOptions) (*graphqlData, error) {
+	var err error
+	data := new(graphqlData)
+
+	data.devices, err = loadDevices(opts.Sigdir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AggregateOnly {
+		return data, nil
+	}
+
+	data.pool, err = readPool(opts.Euifile)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := opts.Registry
+	if len(registry) == 0 {
+		registry = filepath.Join(opts.Sigdir, "issued_licenses.jsonl")
+	}
+	data.licenses, err = readIssuedLicenses(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// page applies limit and offset to a filtered result list.
+func page(items []interface{}, args map[string]interface{}) map[string]interface{} {
+	total := len(items)
+	offset, _ := args["offset"].(int)
+	if offset > total {
+		offset = total
+	}
+	items = items[offset:]
+	if limit, ok := args["limit"].(int); ok && limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return map[string]interface{}{"total": total, "items": items}
+}
+
+func pageType(name string, item graphql.Output) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name + "Page",
+		Fields: graphql.Fields{
+			"total": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"items": &graphql.Field{Type: graphql.NewList(item)},
+		},
+	})
+}
+
+func pageArgs(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	args["limit"] = &graphql.ArgumentConfig{Type: graphql.Int}
+	args["offset"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0}
+	return args
+}
+
+func matches(filter interface{}, value interface{}) bool {
+	f, ok := filter.(string)
+	return !ok || strings.EqualFold(f, fmt.Sprintf("%v", value))
+}
+
+func graphqlSchema() (graphql.Schema, error) {
+	componentType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Component",
+		Fields: graphql.Fields{
+			"name":         &graphql.Field{Type: graphql.String},
+			"uuid":         &graphql.Field{Type: graphql.String},
+			"version":      &graphql.Field{Type: graphql.String},
+			"serialNumber": &graphql.Field{Type: graphql.String},
+			"manufacturer": &graphql.Field{Type: graphql.String},
+			"position":     &graphql.Field{Type: graphql.Int},
+			"created":      &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	complianceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Compliance",
+		Fields: graphql.Fields{
+			"countryOfOrigin": &graphql.Field{Type: graphql.String, Resolve: mapKey("country_of_origin")},
+			"regions":         &graphql.Field{Type: graphql.NewList(graphql.String), Resolve: mapKey("regions")},
+			"redId":           &graphql.Field{Type: graphql.String, Resolve: mapKey("red_id")},
+			"fccId":           &graphql.Field{Type: graphql.String, Resolve: mapKey("fcc_id")},
+			"icId":            &graphql.Field{Type: graphql.String, Resolve: mapKey("ic_id")},
+		},
+	})
+
+	licenseType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "License",
+		Fields: graphql.Fields{
+			"eui64":   &graphql.Field{Type: graphql.String},
+			"type":    &graphql.Field{Type: graphql.String},
+			"params":  &graphql.Field{Type: graphql.String},
+			"issued":  &graphql.Field{Type: graphql.String},
+			"reissue": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	poolEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PoolEntry",
+		Fields: graphql.Fields{
+			"eui64":        &graphql.Field{Type: graphql.String},
+			"status":       &graphql.Field{Type: graphql.String},
+			"board":        &graphql.Field{Type: graphql.String},
+			"version":      &graphql.Field{Type: graphql.String},
+			"marked":       &graphql.Field{Type: graphql.String},
+			"boardUuid":    &graphql.Field{Type: graphql.String},
+			"manufacturer": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	deviceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Device",
+		Fields: graphql.Fields{
+			"eui64":      &graphql.Field{Type: graphql.String},
+			"created":    &graphql.Field{Type: graphql.String},
+			"board":      &graphql.Field{Type: componentType},
+			"platform":   &graphql.Field{Type: componentType},
+			"components": &graphql.Field{Type: graphql.NewList(componentType)},
+			"compliance": &graphql.Field{Type: complianceType},
+			"licenses": &graphql.Field{
+				Type: graphql.NewList(licenseType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := queryData(p)
+					eui := p.Source.(map[string]interface{})["eui64"].(string)
+					lics := make([]interface{}, 0)
+					for _, l := range data.licenses[eui] {
+						lics = append(lics, licenseFields(l))
+					}
+					return lics, nil
+				},
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"device": &graphql.Field{
+				Type: deviceType,
+				Args: graphql.FieldConfigArgument{
+					"eui64": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := queryData(p)
+					for _, d := range data.devices {
+						if matches(p.Args["eui64"], d["eui64"]) {
+							return d, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+			"devices": &graphql.Field{
+				Type: pageType("Device", deviceType),
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"board":     &graphql.ArgumentConfig{Type: graphql.String},
+					"boardUuid": &graphql.ArgumentConfig{Type: graphql.String},
+					"platform":  &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := queryData(p)
+					items := make([]interface{}, 0)
+					for _, d := range data.devices {
+						board, _ := d["board"].(map[string]interface{})
+						platform, _ := d["platform"].(map[string]interface{})
+						if matches(p.Args["board"], board["name"]) &&
+							matches(p.Args["boardUuid"], board["uuid"]) &&
+							matches(p.Args["platform"], platform["name"]) {
+							items = append(items, d)
+						}
+					}
+					return page(items, p.Args), nil
+				},
+			},
+			"components": &graphql.Field{
+				Type: pageType("Component", componentType),
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{Type: graphql.String},
+					"uuid": &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := queryData(p)
+					items := make([]interface{}, 0)
+					for _, d := range data.devices {
+						for _, c := range d["components"].([]map[string]interface{}) {
+							if matches(p.Args["name"], c["name"]) && matches(p.Args["uuid"], c["uuid"]) {
+								items = append(items, c)
+							}
+						}
+					}
+					return page(items, p.Args), nil
+				},
+			},
+			"licenses": &graphql.Field{
+				Type: pageType("License", licenseType),
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"eui64": &graphql.ArgumentConfig{Type: graphql.String},
+					"type":  &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := queryData(p)
+					euis := make([]string, 0, len(data.licenses))
+					for eui := range data.licenses {
+						euis = append(euis, eui)
+					}
+					sort.Strings(euis)
+					items := make([]interface{}, 0)
+					for _, eui := range euis {
+						for _, l := range data.licenses[eui] {
+							if matches(p.Args["eui64"], l.Eui) && matches(p.Args["type"], l.Type) {
+								items = append(items, licenseFields(l))
+							}
+						}
+					}
+					return page(items, p.Args), nil
+				},
+			},
+			"pool": &graphql.Field{
+				Type: pageType("PoolEntry", poolEntryType),
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"status": &graphql.ArgumentConfig{Type: graphql.String},
+					"board":  &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := queryData(p)
+					items := make([]interface{}, 0)
+					for _, e := range data.pool {
+						if matches(p.Args["status"], e.Status) && matches(p.Args["board"], e.Board) {
+							entry := map[string]interface{}{
+								"eui64":        fmt.Sprintf("%016X", e.Eui),
+								"status":       e.Status,
+								"board":        e.Board,
+								"version":      e.Version,
+								"boardUuid":    e.BoardUuid,
+								"manufacturer": e.Manufacturer,
+							}
+							if !e.Marked.IsZero() {
+								entry["marked"] = TimestampString(e.Marked)
+							}
+							items = append(items, entry)
+						}
+					}
+					return page(items, p.Args), nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// queryData is the snapshot of the files loaded for the query, every
+// resolver of a query sees the same data.
+func queryData(p graphql.ResolveParams) *graphqlData {
+	return p.Info.RootValue.(map[string]interface{})["data"].(*graphqlData)
+}
+
+func mapKey(key string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return p.Source.(map[string]interface{})[key], nil
+	}
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "graphql",
+		short:   "Serve a read-only GraphQL query endpoint",
+		long:    "Query devices, components, licenses and the EUI pool with filters and pagination.",
+		options: func() interface{} { return new(GraphqlOptions) },
+		run:     func(c *commandRun) { graphqlMain(c.options.(*GraphqlOptions)) },
+		offline: func(c *commandRun) error { return offlineAddr("graphql", c.options.(*GraphqlOptions).Listen) },
+	})
+}
+
+func graphqlMain(opts *GraphqlOptions) {
+	schema, err := graphqlSchema()
+	if opts.AggregateOnly {
+		schema, err = statsSchema(aggregateQuery{opts.Epsilon, int(opts.MinCount)})
+	}
+	if err != nil {
+		fmt.Printf("ERROR building GraphQL schema: %s\n", err)
+		os.Exit(1)
+	}
+
+	http.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		if r.Method == http.MethodGet {
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+		} else if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			http.Error(w, "GET or POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := loadGraphqlData(opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RootObject:     map[string]interface{}{"data": data},
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        r.Context(),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+
+	fmt.Printf("GraphQL endpoint on %s/graphql\n", opts.Listen)
+	if err := http.ListenAndServe(opts.Listen, nil); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
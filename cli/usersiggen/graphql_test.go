@@ -0,0 +1,49 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "testing"
+import "encoding/json"
+import "path/filepath"
+
+import "github.com/graphql-go/graphql"
+
+func TestGraphql(t *testing.T) {
+	sigdir := t.TempDir()
+	writeTestFile(t, sigdir, "EUI-64_70B3D5D72F000001.bin", testArea(t, 0x70B3D5D72F000001))
+	writeTestFile(t, sigdir, "EUI-64_70B3D5D72F000002.bin", testArea(t, 0x70B3D5D72F000002))
+	opts := &GraphqlOptions{
+		Sigdir:  sigdir,
+		Euifile: writeTestFile(t, sigdir, "euis.txt", []byte("70B3D5D72F000001,tsb2\n70B3D5D72F000002,tsb2\n70B3D5D72F000003\n")),
+	}
+	if err := recordIssuedLicense(filepath.Join(sigdir, "issued_licenses.jsonl"), IssuedLicense{Eui: "70B3D5D72F000002", Type: "beatstack-pro", Time: g_test_time}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := loadGraphqlData(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := graphqlSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for query, want := range map[string]string{
+		`{ devices(board: "TSB2", offset: 1) { total items { eui64 board { name uuid } licenses { type } } } }`: `{"devices":{"items":[{"board":{"name":"tsb2","uuid":"01010101-0101-0101-0101-010101010101"},"eui64":"70B3D5D72F000002","licenses":[{"type":"beatstack-pro"}]}],"total":2}}`,
+		`{ device(eui64: "70b3d5d72f000001") { platform { name } components { name position } } }`:              `{"device":{"components":[{"name":"sht31","position":0}],"platform":{"name":"smenete"}}}`,
+		`{ devices(platform: "tsb2") { total } }`:                                                               `{"devices":{"total":0}}`,
+		`{ components(name: "sht31", limit: 1) { total items { serialNumber } } }`:                              `{"components":{"items":[{"serialNumber":"534e2d30-3030-3100-0000-000000000000"}],"total":2}}`,
+		`{ pool(status: "free") { total items { eui64 } } }`:                                                    `{"pool":{"items":[{"eui64":"70B3D5D72F000003"}],"total":1}}`,
+		`{ licenses(type: "beatstack-pro") { items { eui64 issued } } }`:                                        `{"licenses":{"items":[{"eui64":"70B3D5D72F000002","issued":"` + TimestampString(g_test_time) + `"}]}}`,
+	} {
+		result := graphql.Do(graphql.Params{Schema: schema, RootObject: map[string]interface{}{"data": data}, RequestString: query})
+		if len(result.Errors) > 0 {
+			t.Errorf("%s: %v", query, result.Errors)
+			continue
+		}
+		if b, _ := json.Marshal(result.Data); string(b) != want {
+			t.Errorf("%s\n%s\nwant\n%s", query, b, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "context"
+import "path/filepath"
+
+import "google.golang.org/grpc"
+import "google.golang.org/grpc/codes"
+import "google.golang.org/grpc/status"
+
+import "github.com/thinnect/euisiggen/cli/provisioning"
+
+// gRPC provisioning service, for manufacturing systems that drive the
+// generation themselves instead of running usersiggen and parsing its output.
+// The service is defined in cli/provisioning/provisioning.proto, clients are
+// generated from it. Board signatures are generated and committed like the EUI
+// allocation server does it, the EUI marked in the pool and the area kept in
+// the sigdir. Components and licenses are appended to the area the client
+// sends, nothing is stored for them.
+
+type GrpcOptions struct {
+	Listen  string `long:"listen"  default:":9090" description:"Address to serve the provisioning service on."`
+	Euifile string `long:"euifile" default:"eui.txt" description:"The pool the EUIs of the boards are allocated from."`
+	Sigdir  string `long:"sigdir"  default:"sigdata" description:"Where the signature files are kept."`
+}
+
+type grpcServer struct {
+	provisioning.UnimplementedProvisioningServer
+
+	mutex sync.Mutex // Allocation and marking of the pool
+	opts  *GrpcOptions
+}
+
+func protoComponent(c *provisioning.Component) ProfileComponent {
+	return ProfileComponent{
+		Name:         c.GetName(),
+		Version:      c.GetVersion(),
+		UUID:         c.GetUuid(),
+		Manufacturer: c.GetManufacturer(),
+		Serial:       c.GetSerial(),
+		SerialUUID:   c.GetSerialUuid(),
+		Position:     uint8(c.GetPosition()),
+		Slot:         c.GetSlot(),
+		Part:         c.GetPart(),
+	}
+}
+
+// requestArea returns the EUI of the area a client sent, after checking that
+// the area decodes.
+func requestArea(data []byte) (eui64, error) {
+	if len(data) == 0 {
+		return 0, status.Error(codes.InvalidArgument, "no sigdata")
+	}
+	if _, err := readSigs(data); err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "sigdata: %s", err)
+	}
+	eui, err := areaEui(data)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "sigdata: %s", err)
+	}
+	return eui, nil
+}
+
+func (self *grpcServer) GenerateBoardSignature(ctx context.Context, req *provisioning.GenerateBoardSignatureRequest) (*provisioning.SignatureArea, error) {
+	if req.Board == nil {
+		return nil, status.Error(codes.InvalidArgument, "no board")
+	}
+	p := &ProvisionProfile{Board: protoComponent(req.Board)}
+	if req.Platform != nil {
+		platform := protoComponent(req.Platform)
+		p.Platform = &platform
+	}
+	for _, c := range req.Components {
+		p.Components = append(p.Components, protoComponent(c))
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var eui eui64
+	var err error
+	if len(req.Eui64) > 0 {
+		if eui, err = parseEui(req.Eui64); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	} else if eui, err = getEui(self.opts.Euifile, req.Tag, req.WorkOrder); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	var gen UserSignature
+	dev := &ProvisionDevice{Eui: eui, Timestamp: time.Now().UTC(), WorkOrder: req.WorkOrder}
+	if _, err := buildSigdata(&gen, p, dev); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := commitIdentity(self.opts.Euifile, self.opts.Sigdir, req.Tag, dev.Identity); err == errSigfileExists {
+		return nil, status.Errorf(codes.AlreadyExists, "signature file for %016X exists", eui)
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	fmt.Printf("%016X generated for %s\n", eui, p.Board.Name)
+	return &provisioning.SignatureArea{Eui64: fmt.Sprintf("%016X", eui), Sigdata: dev.Identity.Sigdata}, nil
+}
+
+func (self *grpcServer) AppendComponent(ctx context.Context, req *provisioning.AppendComponentRequest) (*provisioning.SignatureArea, error) {
+	eui, err := requestArea(req.Sigdata)
+	if err != nil {
+		return nil, err
+	}
+	if req.Component == nil {
+		return nil, status.Error(codes.InvalidArgument, "no component")
+	}
+	tp := uint8(SIGNATURE_TYPE_COMPONENT)
+	if req.Type == provisioning.AppendComponentRequest_PLATFORM {
+		tp = SIGNATURE_TYPE_PLATFORM
+	}
+
+	var gen UserSignature
+	csig, err := parseComponentSignature(&gen, time.Now().UTC(), protoComponent(req.Component), tp)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	csigdata, err := gen.Serialize(csig)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	sigdata := append(append([]byte{}, req.Sigdata...), csigdata...)
+	return &provisioning.SignatureArea{Eui64: fmt.Sprintf("%016X", eui), Sigdata: sigdata}, nil
+}
+
+func (self *grpcServer) IssueLicense(ctx context.Context, req *provisioning.IssueLicenseRequest) (*provisioning.SignatureArea, error) {
+	eui, err := requestArea(req.Sigdata)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.License) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no license")
+	}
+	window, err := parseLicenseWindow(req.ValidFrom, req.ValidUntil)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var gen UserSignature
+	var licdata []byte
+	if window != nil {
+		licdata, err = gen.SerializeLicenseV2(time.Now().UTC(), req.License, *window)
+	} else {
+		licdata, err = gen.SerializeLicense(time.Now().UTC(), req.License)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	sigdata := append(append([]byte{}, req.Sigdata...), licdata...)
+	return &provisioning.SignatureArea{Eui64: fmt.Sprintf("%016X", eui), Sigdata: sigdata}, nil
+}
+
+func (self *grpcServer) ReadSignature(ctx context.Context, req *provisioning.ReadSignatureRequest) (*provisioning.ReadSignatureResponse, error) {
+	schema := SCHEMA_READ_SIG_V2
+	if len(req.Schema) > 0 {
+		var err error
+		if schema, err = parseSchemaVersion(req.Schema); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	data := req.Sigdata
+	if len(data) == 0 && len(req.Eui64) > 0 {
+		eui, err := parseEui(req.Eui64)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		data, err = readSigdirFile(filepath.Join(self.opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui)))
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "no signature file for %016X", eui)
+		} else if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	eui, err := requestArea(data)
+	if err != nil {
+		return nil, err
+	}
+	sigs, _ := readSigs(data)
+	return &provisioning.ReadSignatureResponse{Eui64: fmt.Sprintf("%016X", eui), Json: sigsToJson(sigs, schema)}, nil
+}
+
+func grpcServe(opts *GrpcOptions) error {
+	if _, err := readPool(opts.Euifile); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.Sigdir, 0770); err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer()
+	provisioning.RegisterProvisioningServer(srv, &grpcServer{opts: opts})
+
+	fmt.Printf("Serving the provisioning service on %s\n", opts.Listen)
+	return srv.Serve(lis)
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "grpc",
+		short:   "Serve the gRPC provisioning service",
+		long:    "Generate board signatures, append components and licenses and read signatures for a manufacturing system over gRPC.",
+		options: func() interface{} { return new(GrpcOptions) },
+		run:     func(c *commandRun) { grpcMain(c.options.(*GrpcOptions)) },
+		offline: func(c *commandRun) error { return offlineAddr("grpc", c.options.(*GrpcOptions).Listen) },
+	})
+}
+
+func grpcMain(opts *GrpcOptions) {
+	if err := grpcServe(opts); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "bytes"
+import "context"
+import "strings"
+import "testing"
+
+import "google.golang.org/grpc/codes"
+import "google.golang.org/grpc/status"
+
+import "github.com/thinnect/euisiggen/cli/provisioning"
+
+func TestGrpcServer(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	srv := &grpcServer{opts: &GrpcOptions{Euifile: writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001\n")), Sigdir: dir}}
+	ctx := context.Background()
+	board := &provisioning.Component{Name: "tsb2", Version: "1.2.3", Uuid: "01010101-0101-0101-0101-010101010101", Manufacturer: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"}
+
+	var area *provisioning.SignatureArea
+	var err error
+	captureStdout(t, func() {
+		area, err = srv.GenerateBoardSignature(ctx, &provisioning.GenerateBoardSignatureRequest{Board: board})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if area.Eui64 != "70B3D5D72F000001" {
+		t.Errorf("generated for %s", area.Eui64)
+	}
+	if pool, _ := os.ReadFile(srv.opts.Euifile); !strings.HasPrefix(string(pool), "70B3D5D72F000001,tsb2") {
+		t.Errorf("pool is\n%s", pool)
+	}
+	_, err = srv.GenerateBoardSignature(ctx, &provisioning.GenerateBoardSignatureRequest{Board: board})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("generated from an empty pool: %v", err)
+	}
+	_, err = srv.GenerateBoardSignature(ctx, &provisioning.GenerateBoardSignatureRequest{Board: board, Eui64: "70B3D5D72F000001"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("generated for a committed EUI: %v", err)
+	}
+
+	component := &provisioning.Component{Name: "sht31", Version: "1.0.0", Uuid: "03030303-0303-0303-0303-030303030303", Manufacturer: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"}
+	appended, err := srv.AppendComponent(ctx, &provisioning.AppendComponentRequest{Sigdata: area.Sigdata, Component: component})
+	if err != nil {
+		t.Fatal(err)
+	}
+	licensed, err := srv.IssueLicense(ctx, &provisioning.IssueLicenseRequest{Sigdata: appended.Sigdata, License: []byte("seats=10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(licensed.Sigdata, appended.Sigdata) || !bytes.HasPrefix(appended.Sigdata, area.Sigdata) {
+		t.Errorf("records are not appended to the area")
+	}
+	if sigs, err := readSigs(licensed.Sigdata); err != nil || len(sigs) != 4 {
+		t.Errorf("%d records after appending: %v", len(sigs), err)
+	}
+
+	// The area of a committed EUI is read from the sigdir
+	read, err := srv.ReadSignature(ctx, &provisioning.ReadSignatureRequest{Eui64: "70B3D5D72F000001", Schema: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(read.Json, `"board_signature"`) || strings.Contains(read.Json, SCHEMA_READ_SIG_V2) {
+		t.Errorf("v1 read is %s", read.Json)
+	}
+	_, err = srv.ReadSignature(ctx, &provisioning.ReadSignatureRequest{Eui64: "70B3D5D72F000002"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("read of an unknown EUI: %v", err)
+	}
+	_, err = srv.AppendComponent(ctx, &provisioning.AppendComponentRequest{Sigdata: []byte{0x01, 0x02}, Component: component})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("appended to a broken area: %v", err)
+	}
+}
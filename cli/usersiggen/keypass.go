@@ -0,0 +1,175 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "os/exec"
+import "fmt"
+import "bufio"
+import "bytes"
+import "errors"
+import "hash"
+import "strings"
+import "io/ioutil"
+import "crypto/aes"
+import "crypto/sha1"
+import "crypto/pbkdf2"
+import "crypto/sha256"
+import "crypto/sha512"
+import "crypto/cipher"
+import "crypto/x509/pkix"
+import "encoding/asn1"
+
+// Passphrase protected private keys, encrypted PKCS#8 as written by
+//
+//	openssl genpkey -algorithm ed25519 -aes256 -out factory.pem
+//
+// PBES2 with PBKDF2 and AES-CBC, what OpenSSL and most tools use. The
+// passphrase comes from --key-pass-file, USERSIGGEN_KEY_PASS or, when
+// there is a terminal, a prompt.
+
+const KEY_PASS_ENV = "USERSIGGEN_KEY_PASS"
+
+// The iteration count comes from the key file, more than this is refused
+// instead of keeping the tool busy for minutes. OpenSSL writes 2048.
+const MAX_PBKDF2_ITERATIONS = 1000000
+
+var g_key_pass_file string
+
+var (
+	oidPBES2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHmacSHA1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHmacSHA256    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHmacSHA512    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	g_pbkdf2_hashes  = map[string]func() hash.Hash{oidHmacSHA1.String(): sha1.New, oidHmacSHA256.String(): sha256.New, oidHmacSHA512.String(): sha512.New}
+	g_aes_key_length = map[string]int{oidAES128CBC.String(): 16, oidAES192CBC.String(): 24, oidAES256CBC.String(): 32}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+type pbes2Params struct {
+	Kdf    pkix.AlgorithmIdentifier
+	Cipher pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	Prf        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+func setKeyPassFile(file string) {
+	g_key_pass_file = file
+}
+
+// keyPassphrase returns the passphrase of an encrypted key file.
+func keyPassphrase(file string) ([]byte, error) {
+	if len(g_key_pass_file) > 0 {
+		b, err := ioutil.ReadFile(g_key_pass_file)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(b, "\r\n"), nil
+	}
+	if pass, ok := os.LookupEnv(KEY_PASS_ENV); ok {
+		return []byte(pass), nil
+	}
+
+	// stty fails when there is no terminal to prompt on
+	echo := exec.Command("stty", "-echo")
+	echo.Stdin = os.Stdin
+	if echo.Run() != nil {
+		return nil, fmt.Errorf("%s is encrypted, give the passphrase with --key-pass-file or %s", file, KEY_PASS_ENV)
+	}
+	defer func() {
+		restore := exec.Command("stty", "echo")
+		restore.Stdin = os.Stdin
+		restore.Run()
+		fmt.Fprintln(os.Stderr)
+	}()
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", file)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// decryptPrivateKey returns the PKCS#8 key of an ENCRYPTED PRIVATE KEY
+// block.
+func decryptPrivateKey(der []byte, file string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("%s is encrypted with %s, only PBES2 is supported", file, info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("%s: PBES2 parameters: %s", file, err)
+	}
+	if !params.Kdf.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("%s uses key derivation %s, only PBKDF2 is supported", file, params.Kdf.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.Kdf.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("%s: PBKDF2 parameters: %s", file, err)
+	}
+	if kdf.Iterations < 1 || kdf.Iterations > MAX_PBKDF2_ITERATIONS {
+		return nil, fmt.Errorf("%s: PBKDF2 with %d iterations, at most %d are accepted", file, kdf.Iterations, MAX_PBKDF2_ITERATIONS)
+	}
+	prf := oidHmacSHA1.String()
+	if len(kdf.Prf.Algorithm) > 0 {
+		prf = kdf.Prf.Algorithm.String()
+	}
+	h, ok := g_pbkdf2_hashes[prf]
+	if !ok {
+		return nil, fmt.Errorf("%s uses PBKDF2 with %s, not supported", file, prf)
+	}
+	keylen, ok := g_aes_key_length[params.Cipher.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("%s is encrypted with %s, only AES-CBC is supported", file, params.Cipher.Algorithm)
+	}
+	if kdf.KeyLength != 0 && kdf.KeyLength != keylen {
+		return nil, fmt.Errorf("%s: PBKDF2 key length %d does not fit %s", file, kdf.KeyLength, params.Cipher.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Cipher.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("%s: bad AES-CBC IV", file)
+	}
+	if len(info.Data) == 0 || len(info.Data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%s: encrypted key is not a whole number of blocks", file)
+	}
+
+	pass, err := keyPassphrase(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(h, string(pass), kdf.Salt, kdf.Iterations, keylen)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.Data)
+
+	// A wrong passphrase shows as bad padding, most of the time
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("wrong passphrase for " + file)
+	}
+	return plain[:len(plain)-pad], nil
+}
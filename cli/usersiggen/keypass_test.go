@@ -0,0 +1,51 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "bytes"
+import "strings"
+import "testing"
+import "crypto/ed25519"
+
+// The keys in testdata are one Ed25519 key encrypted by OpenSSL 3.0 with the
+// passphrase "factory":
+//
+//	openssl genpkey -algorithm ed25519 -aes-256-cbc -pass pass:factory -out factory-enc.pem
+//	openssl pkey -in factory-enc.pem -passin pass:factory -pubout -out factory.pub
+//	openssl pkcs8 -topk8 -v2 aes-128-cbc -v2prf hmacWithSHA1 -passout pass:factory -out factory-sha1.pem
+//	openssl pkcs8 -topk8 -v2 aes-256-cbc -iter 1000001 -passout pass:factory -out factory-iter.pem
+
+func TestEncryptedKey(t *testing.T) {
+	pub, err := loadEd25519Public("testdata/factory.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(KEY_PASS_ENV, "factory")
+	for _, file := range []string{"testdata/factory-enc.pem", "testdata/factory-sha1.pem"} {
+		key, err := loadEd25519Private(file)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+		if !bytes.Equal(key.Public().(ed25519.PublicKey), pub) {
+			t.Errorf("%s: the key does not match factory.pub", file)
+		}
+	}
+}
+
+func TestEncryptedKeyWrongPassphrase(t *testing.T) {
+	t.Setenv(KEY_PASS_ENV, "not the factory")
+	_, err := loadEd25519Private("testdata/factory-enc.pem")
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("got %v, want a wrong passphrase error", err)
+	}
+}
+
+func TestEncryptedKeyIterations(t *testing.T) {
+	t.Setenv(KEY_PASS_ENV, "factory")
+	_, err := loadEd25519Private("testdata/factory-iter.pem")
+	if err == nil || !strings.Contains(err.Error(), "1000001 iterations") {
+		t.Errorf("got %v, want the iteration count refused", err)
+	}
+}
@@ -0,0 +1,335 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "strings"
+import "io/ioutil"
+import "encoding/hex"
+import "encoding/json"
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/x509"
+import "crypto/ed25519"
+
+import "go.thinnect.net/euisig/signature"
+
+// Keyring of the signing keys, who owns a key, what it is for and until
+// when. With --keyring a key is only used for an operation its purpose
+// allows and only until it expires, the development key does not sign
+// production areas because it is the one at hand:
+//
+//	usersiggen keyring add --owner "Line 2" --purpose sign --not-after 2027-06-30 keyring.json factory.pem
+//	usersiggen --keyring keyring.json --type sign --sign-key factory.pem ...
+//
+// A key that is not in the keyring is refused.
+
+const SCHEMA_KEYRING = "euisiggen/keyring/v1"
+
+// Purposes a key can have, the operations that sign with it.
+var g_key_purposes = []string{"sign", "decommission", "delivery", "patch", "policy", "rma", "archive"}
+
+type KeyMetadata struct {
+	Id       string   `json:"id"` // Key id, 16 hex digits
+	Owner    string   `json:"owner"`
+	Purposes []string `json:"purposes"`
+	NotAfter string   `json:"not_after,omitempty"` // YYYY-MM-DD or RFC 3339, the key does not expire when empty
+}
+
+type Keyring struct {
+	Schema string         `json:"schema"`
+	Keys   []*KeyMetadata `json:"keys"`
+}
+
+type KeyringAddOptions struct {
+	Owner    string   `long:"owner" required:"true" description:"Person or station responsible for the key."`
+	Purpose  []string `long:"purpose" required:"true" description:"Operations the key signs for: sign, decommission, delivery, patch, policy, rma, archive."`
+	NotAfter string   `long:"not-after" description:"Date the key expires at the end of, or an RFC 3339 time."`
+	Args     struct {
+		Keyring string `positional-arg-name:"keyring"`
+		Key     string `positional-arg-name:"key"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type KeyringListOptions struct {
+	Args struct {
+		Keyring string `positional-arg-name:"keyring"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type KeyringOptions struct {
+	Add  KeyringAddOptions  `command:"add" description:"Add a key or replace its metadata, the public or the private key file."`
+	List KeyringListOptions `command:"list" description:"List the keys of a keyring."`
+}
+
+// g_keyring is the keyring of the run, nil when there is none.
+var g_keyring *Keyring
+
+// expiry returns when the key expires, the zero time if it does not. A date
+// is the end of that day.
+func (self *KeyMetadata) expiry() (time.Time, error) {
+	if len(self.NotAfter) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := parseDecommissionDate(self.NotAfter)
+	if err != nil {
+		return t, err
+	}
+	if _, err := time.Parse("2006-01-02", self.NotAfter); err == nil {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+func (self *KeyMetadata) hasPurpose(purpose string) bool {
+	for _, p := range self.Purposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPurposes(purposes []string) error {
+	for _, p := range purposes {
+		known := false
+		for _, k := range g_key_purposes {
+			known = known || p == k
+		}
+		if !known {
+			return fmt.Errorf("unknown key purpose %s, one of %s", p, strings.Join(g_key_purposes, ", "))
+		}
+	}
+	return nil
+}
+
+func readKeyring(file string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	k := new(Keyring)
+	if err := json.Unmarshal(b, k); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if k.Schema != SCHEMA_KEYRING {
+		return nil, fmt.Errorf("%s is not a keyring", file)
+	}
+	for _, m := range k.Keys {
+		if b, err := hex.DecodeString(m.Id); err != nil || len(b) != len(signature.KeyId{}) {
+			return nil, fmt.Errorf("%s: %s is not a key id", file, m.Id)
+		}
+		if err := checkPurposes(m.Purposes); err != nil {
+			return nil, fmt.Errorf("%s: key %s: %s", file, m.Id, err)
+		}
+		if _, err := m.expiry(); err != nil {
+			return nil, fmt.Errorf("%s: key %s: not_after %s", file, m.Id, err)
+		}
+	}
+	return k, nil
+}
+
+func setKeyring(file string) error {
+	if len(file) == 0 {
+		return nil
+	}
+	k, err := readKeyring(file)
+	if err != nil {
+		return err
+	}
+	g_keyring = k
+	return nil
+}
+
+func (self *Keyring) find(id signature.KeyId) *KeyMetadata {
+	for _, m := range self.Keys {
+		if strings.EqualFold(m.Id, hex.EncodeToString(id[:])) {
+			return m
+		}
+	}
+	return nil
+}
+
+// check refuses a key that is not in the keyring, is not for the purpose or
+// has expired at t.
+func (self *Keyring) check(id signature.KeyId, purpose string, t time.Time) error {
+	m := self.find(id)
+	if m == nil {
+		return fmt.Errorf("key %X is not in the keyring", id[:])
+	}
+	if !m.hasPurpose(purpose) {
+		return fmt.Errorf("key %X of %s is for %s, not %s", id[:], m.Owner, strings.Join(m.Purposes, ", "), purpose)
+	}
+	expiry, _ := m.expiry()
+	if !expiry.IsZero() && t.After(expiry) {
+		return fmt.Errorf("key %X of %s expired at %s", id[:], m.Owner, expiry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadSigningKey loads a private key for signing, checked against the
+// keyring of the run.
+func loadSigningKey(file string, purpose string) (ed25519.PrivateKey, error) {
+	key, err := loadEd25519Private(file)
+	if err != nil {
+		return nil, err
+	}
+	if g_keyring != nil {
+		if err := g_keyring.check(signature.KeyIdOf(key.Public().(ed25519.PublicKey)), purpose, time.Now()); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	return key, nil
+}
+
+// loadAreaSigner loads the factory key of the area signature, a key file or
+// a key in the TPM, tpm:HANDLE, checked against the keyring of the run.
+func loadAreaSigner(key string) (crypto.Signer, error) {
+	if !isTpmKey(key) {
+		return loadSigningKey(key, "sign")
+	}
+	signer, err := openTpmSigner(key)
+	if err != nil {
+		return nil, err
+	}
+	if g_keyring != nil {
+		id, _ := signature.PublicKeyId(signer.Public())
+		if err := g_keyring.check(id, "sign", time.Now()); err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	return signer, nil
+}
+
+// loadAreaPublic loads the public key the area signature is checked with, an
+// Ed25519 or ECDSA P-256 public key file, as tpm2_readpublic writes it, or
+// the key in the TPM, tpm:HANDLE.
+func loadAreaPublic(key string) (crypto.PublicKey, error) {
+	if isTpmKey(key) {
+		signer, err := openTpmSigner(key)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+	der, err := loadPemBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch pub.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		if _, err := signature.PublicKeyId(pub); err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("%s is neither an Ed25519 nor an ECDSA public key", key)
+}
+
+// loadEd25519Any loads the public key of either key file.
+func loadEd25519Any(file string) (ed25519.PublicKey, error) {
+	if pub, err := loadEd25519Public(file); err == nil {
+		return pub, nil
+	}
+	key, err := loadEd25519Private(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither an Ed25519 public nor private key", file)
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// loadKeyringKey loads the public key of a key to add to the keyring, either
+// Ed25519 key file, the ECDSA public key of a TPM key or tpm:HANDLE.
+func loadKeyringKey(key string) (signature.KeyId, error) {
+	var pub crypto.PublicKey
+	var err error
+	if pub, err = loadEd25519Any(key); err != nil {
+		if pub, err = loadAreaPublic(key); err != nil {
+			return signature.KeyId{}, fmt.Errorf("%s is neither an Ed25519 key, an ECDSA P-256 public key nor a TPM key", key)
+		}
+	}
+	return signature.PublicKeyId(pub)
+}
+
+func keyringAdd(opts *KeyringAddOptions) error {
+	k, err := readKeyring(opts.Args.Keyring)
+	if os.IsNotExist(err) {
+		k = &Keyring{Schema: SCHEMA_KEYRING}
+	} else if err != nil {
+		return err
+	}
+	id, err := loadKeyringKey(opts.Args.Key)
+	if err != nil {
+		return err
+	}
+	purposes := make([]string, 0)
+	for _, p := range opts.Purpose {
+		purposes = append(purposes, strings.Split(p, ",")...)
+	}
+	if err := checkPurposes(purposes); err != nil {
+		return err
+	}
+	m := &KeyMetadata{Id: hex.EncodeToString(id[:]), Owner: opts.Owner, Purposes: purposes, NotAfter: opts.NotAfter}
+	if _, err := m.expiry(); err != nil {
+		return fmt.Errorf("--not-after %s", err)
+	}
+
+	if old := k.find(id); old != nil {
+		*old = *m
+		fmt.Printf("Key %X metadata replaced\n", id[:])
+	} else {
+		k.Keys = append(k.Keys, m)
+		fmt.Printf("Key %X added\n", id[:])
+	}
+	return writeJsonFile(opts.Args.Keyring, k)
+}
+
+func keyringList(opts *KeyringListOptions) error {
+	k, err := readKeyring(opts.Args.Keyring)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, m := range k.Keys {
+		expires := "never"
+		if t, _ := m.expiry(); !t.IsZero() {
+			expires = t.Format(time.RFC3339)
+			if now.After(t) {
+				expires += " EXPIRED"
+			}
+		}
+		fmt.Printf("%s %-20s %-20s %s\n", strings.ToUpper(m.Id), m.Owner, strings.Join(m.Purposes, ","), expires)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "keyring",
+		short:   "Metadata of the signing keys",
+		long:    "Add the owner, purpose and expiry of a signing key to a keyring, or list the keys of one.",
+		options: func() interface{} { return new(KeyringOptions) },
+		run:     func(c *commandRun) { keyringMain(c.command, c.options.(*KeyringOptions)) },
+	})
+}
+
+func keyringMain(command string, opts *KeyringOptions) {
+	var err error
+	switch command {
+	case "add":
+		err = keyringAdd(&opts.Add)
+	case "list":
+		err = keyringList(&opts.List)
+	}
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
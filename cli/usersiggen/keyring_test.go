@@ -0,0 +1,86 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "strings"
+import "testing"
+import "time"
+import "path/filepath"
+
+func withKeyring(t *testing.T, file string) {
+	keyring := g_keyring
+	t.Cleanup(func() {
+		g_keyring = keyring
+	})
+	if err := setKeyring(file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A key signs for its purposes until it expires, keys not in the keyring not
+// at all.
+func TestKeyring(t *testing.T) {
+	dir := t.TempDir()
+	keyring := filepath.Join(dir, "keyring.json")
+	factory, factorypub := writeTestKeys(t, dir, "factory")
+	old, _ := writeTestKeys(t, dir, "old")
+	stranger, _ := writeTestKeys(t, dir, "stranger")
+	add := func(owner string, notAfter string, key string, purposes ...string) error {
+		opts := &KeyringAddOptions{Owner: owner, Purpose: purposes, NotAfter: notAfter}
+		opts.Args.Keyring, opts.Args.Key = keyring, key
+		var err error
+		captureStdout(t, func() { err = keyringAdd(opts) })
+		return err
+	}
+	if err := add("Line 1", "", factory, "delivery"); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("Line 2", "2030-01-31", factorypub, "sign,patch", "rma"); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("Line 2", "2020-12-31", old, "sign"); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("Line 3", "", stranger, "sign", "launch"); err == nil || !strings.Contains(err.Error(), "unknown key purpose launch") {
+		t.Errorf("unknown purpose: %v", err)
+	}
+	if err := add("Line 3", "tomorrow", stranger, "sign"); err == nil {
+		t.Errorf("expiry tomorrow is accepted")
+	}
+
+	k, err := readKeyring(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.Keys) != 2 || k.Keys[0].Owner != "Line 2" || strings.Join(k.Keys[0].Purposes, " ") != "sign patch rma" {
+		t.Fatalf("keys are %+v", k.Keys)
+	}
+	list := &KeyringListOptions{}
+	list.Args.Keyring = keyring
+	if out := captureStdout(t, func() {
+		if err := keyringList(list); err != nil {
+			t.Error(err)
+		}
+	}); !strings.Contains(out, "2030-01-31T23:59:59Z\n") || !strings.Contains(out, "2020-12-31T23:59:59Z EXPIRED\n") {
+		t.Errorf("keyring list is\n%s", out)
+	}
+
+	withKeyring(t, keyring)
+	if _, err := loadSigningKey(factory, "rma"); err != nil {
+		t.Error(err)
+	}
+	if _, err := loadSigningKey(factory, "delivery"); err == nil || !strings.Contains(err.Error(), "is for sign, patch, rma, not delivery") {
+		t.Errorf("key used for another purpose: %v", err)
+	}
+	if _, err := loadSigningKey(old, "sign"); err == nil || !strings.Contains(err.Error(), "expired at 2020-12-31T23:59:59Z") {
+		t.Errorf("expired key: %v", err)
+	}
+	if _, err := loadSigningKey(stranger, "sign"); err == nil || !strings.Contains(err.Error(), "not in the keyring") {
+		t.Errorf("key outside the keyring: %v", err)
+	}
+	id, _ := loadKeyringKey(factorypub)
+	if g_keyring.check(id, "sign", time.Date(2030, 1, 31, 23, 0, 0, 0, time.UTC)) != nil || g_keyring.check(id, "sign", time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC)) == nil {
+		t.Errorf("the key does not expire at the end of 2030-01-31")
+	}
+}
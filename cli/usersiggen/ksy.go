@@ -0,0 +1,206 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "io/ioutil"
+import "strings"
+import "encoding/binary"
+import "reflect"
+import "sort"
+
+import "go.thinnect.net/euisig/signature"
+
+// The Kaitai Struct description is generated from the Go structures with
+// reflection, so it describes exactly what Serialize writes.
+
+type KsyOptions struct {
+	Output string `long:"out" description:"Write the .ksy to a file instead of stdout."`
+}
+
+// Field types that need more than their binary size to be described.
+var g_ksy_string_types = map[reflect.Type]string{
+	reflect.TypeOf(tname{}):    "strz",
+	reflect.TypeOf(tcert{}):    "strz",
+	reflect.TypeOf(tcountry{}): "str",
+}
+
+func ksyField(f reflect.StructField) []string {
+	id := fieldName(f)
+	t := f.Type
+
+	if str, ok := g_ksy_string_types[t]; ok {
+		return []string{
+			"- id: " + id,
+			"  type: " + str,
+			fmt.Sprintf("  size: %d", t.Len()),
+			"  encoding: ASCII",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []string{"- id: " + id, fmt.Sprintf("  type: u%d", t.Size())}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return []string{"- id: " + id, fmt.Sprintf("  type: s%d", t.Size())}
+	case reflect.Array:
+		return []string{"- id: " + id, fmt.Sprintf("  size: %d", t.Len())}
+	case reflect.Slice:
+		if t == reflect.TypeOf(tdata{}) {
+			return []string{"- id: " + id, "  size: data_length"}
+		}
+		return []string{"- id: " + id, "  size-eos: true"}
+	}
+	panic(fmt.Sprintf("no Kaitai mapping for %s %s", f.Name, t))
+}
+
+func ksySeq(t reflect.Type, skipBase bool) []string {
+	lines := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			if !skipBase || f.Type != reflect.TypeOf(BaseSignature{}) {
+				lines = append(lines, ksySeq(f.Type, skipBase)...)
+			}
+			continue
+		}
+		lines = append(lines, ksyField(f)...)
+		if i > 0 && t.Field(i-1).Type.Kind() == reflect.Slice {
+			lines = append(lines, "  if: not _io.eof") // Follows the data, when present
+		}
+	}
+	return lines
+}
+
+// Records described by a structure other than the one they are named after.
+var g_ksy_type_names = map[reflect.Type]string{
+	reflect.TypeOf(ComponentDataSignature{}): "component_signature",
+}
+
+func ksyTypeName(t reflect.Type) string {
+	if name, ok := g_ksy_type_names[t]; ok {
+		return name
+	}
+	name := strings.TrimSuffix(t.Name(), "Signature")
+	return strings.ToLower(name) + "_signature"
+}
+
+func writeIndented(sb *strings.Builder, indent int, lines []string) {
+	pad := strings.Repeat(" ", indent)
+	for _, l := range lines {
+		sb.WriteString(pad + l + "\n")
+	}
+}
+
+// generateKsy returns the Kaitai Struct description of a signature area.
+func generateKsy() string {
+	var sb strings.Builder
+	basesz := binary.Size(BaseSignature{})
+
+	bodies := map[uint8]reflect.Type{
+		SIGNATURE_TYPE_EUI64:          reflect.TypeOf(EUISignature{}),
+		SIGNATURE_TYPE_BOARD:          reflect.TypeOf(ComponentDataSignature{}),
+		SIGNATURE_TYPE_PLATFORM:       reflect.TypeOf(ComponentDataSignature{}),
+		SIGNATURE_TYPE_COMPONENT:      reflect.TypeOf(ComponentDataSignature{}),
+		SIGNATURE_TYPE_LICENSE:        reflect.TypeOf(LicenseSignature{}),
+		SIGNATURE_TYPE_COMPLIANCE:     reflect.TypeOf(ComplianceSignature{}),
+		SIGNATURE_TYPE_EXTENDED_ID:    reflect.TypeOf(ExtendedIdSignature{}),
+		SIGNATURE_TYPE_DECOMMISSION:   reflect.TypeOf(DecommissionSignature{}),
+		SIGNATURE_TYPE_AREA_SIGNATURE: reflect.TypeOf(AreaSignature{}),
+		SIGNATURE_TYPE_PIN:            reflect.TypeOf(PinSignature{}),
+		SIGNATURE_TYPE_LICENSE_V2:     reflect.TypeOf(LicenseV2Signature{}),
+	}
+	codes := make([]int, 0, len(bodies))
+	for code := range bodies {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+
+	sb.WriteString(fmt.Sprintf("# Generated by usersiggen %s, do not edit.\n", generatorVersion()))
+	sb.WriteString("meta:\n")
+	sb.WriteString("  id: thinnect_sigdata\n")
+	sb.WriteString("  title: Thinnect device signature area\n")
+	sb.WriteString("  file-extension: bin\n")
+	sb.WriteString("  endian: be\n")
+	sb.WriteString("doc: |\n")
+	sb.WriteString("  Sequence of signature records, each a common header, a type specific\n")
+	sb.WriteString("  body and a CRC-16/XMODEM over header and body. The area may be followed\n")
+	sb.WriteString("  by 0xFF padding, which has to be cut off before parsing. Records with the\n")
+	sb.WriteString("  check code, bits 5 and 6 of sig_version_major, or the little-endian flag,\n")
+	sb.WriteString("  0x80, set are not described here.\n")
+	sb.WriteString("seq:\n")
+	sb.WriteString("  - id: signatures\n")
+	sb.WriteString("    type: signature\n")
+	sb.WriteString("    repeat: eos\n")
+
+	sb.WriteString("types:\n")
+	sb.WriteString("  signature:\n")
+	sb.WriteString("    seq:\n")
+	sb.WriteString("      - id: header\n")
+	sb.WriteString("        type: base_signature\n")
+	sb.WriteString("      - id: body\n")
+	sb.WriteString(fmt.Sprintf("        size: header.signature_size - %d\n", basesz+2))
+	sb.WriteString("        type:\n")
+	sb.WriteString("          switch-on: header.signature_type\n")
+	sb.WriteString("          cases:\n")
+	for _, code := range codes {
+		sb.WriteString(fmt.Sprintf("            'signature_type::%s': %s\n", signature.TypeName(uint8(code)), ksyTypeName(bodies[uint8(code)])))
+	}
+	sb.WriteString("      - id: crc\n")
+	sb.WriteString("        type: u2\n")
+
+	sb.WriteString("  base_signature:\n")
+	sb.WriteString("    seq:\n")
+	header := ksySeq(reflect.TypeOf(BaseSignature{}), false)
+	for i, l := range header {
+		if l == "- id: signature_type" {
+			header = append(header[:i+2], append([]string{"  enum: signature_type"}, header[i+2:]...)...)
+			break
+		}
+	}
+	writeIndented(&sb, 6, header)
+
+	done := make(map[reflect.Type]bool)
+	for _, code := range codes {
+		t := bodies[uint8(code)]
+		if done[t] {
+			continue
+		}
+		done[t] = true
+		sb.WriteString(fmt.Sprintf("  %s:\n", ksyTypeName(t)))
+		sb.WriteString("    seq:\n")
+		writeIndented(&sb, 6, ksySeq(t, true))
+	}
+
+	sb.WriteString("enums:\n")
+	sb.WriteString("  signature_type:\n")
+	for _, code := range codes {
+		sb.WriteString(fmt.Sprintf("    %d: %s\n", code, signature.TypeName(uint8(code))))
+	}
+
+	return sb.String()
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "ksy",
+		short:   "Emit a Kaitai Struct description of the signature format",
+		long:    "Generate a .ksy format specification from the signature structures of this generator.",
+		options: func() interface{} { return new(KsyOptions) },
+		run:     func(c *commandRun) { ksyMain(c.options.(*KsyOptions)) },
+	})
+}
+
+func ksyMain(opts *KsyOptions) {
+	ksy := generateKsy()
+	if len(opts.Output) == 0 {
+		fmt.Print(ksy)
+		return
+	}
+	if err := ioutil.WriteFile(opts.Output, []byte(ksy), 0644); err != nil {
+		fmt.Printf("ERROR writing %s: %s\n", opts.Output, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,76 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "strconv"
+import "strings"
+import "testing"
+import "encoding/binary"
+
+import "gopkg.in/yaml.v2"
+
+type ksyDoc struct {
+	Types map[string]struct {
+		Seq []map[string]interface{} `yaml:"seq"`
+	} `yaml:"types"`
+	Enums map[string]map[int]string `yaml:"enums"`
+}
+
+// ksySize returns the bytes of a sequence of fixed size fields.
+func ksySize(t *testing.T, seq []map[string]interface{}) int {
+	size := 0
+	for _, f := range seq {
+		if n, ok := f["size"].(int); ok {
+			size += n
+		} else if tp, ok := f["type"].(string); ok && (tp[0] == 'u' || tp[0] == 's') {
+			n, err := strconv.Atoi(tp[1:])
+			if err != nil {
+				t.Fatalf("field %v", f)
+			}
+			size += n
+		} else {
+			t.Fatalf("field %v is not of a fixed size", f)
+		}
+	}
+	return size
+}
+
+// The description parses as YAML, has a body for every record type and the
+// fields of the records where Serialize writes them.
+func TestKsy(t *testing.T) {
+	ksy := generateKsy()
+	var doc ksyDoc
+	if err := yaml.Unmarshal([]byte(ksy), &doc); err != nil {
+		t.Fatalf("%s\n%s", err, ksy)
+	}
+
+	types := doc.Enums["signature_type"]
+	if len(types) != 11 {
+		t.Errorf("%d signature types, want 11: %v", len(types), types)
+	}
+	for code, name := range types {
+		if !strings.Contains(ksy, "'signature_type::"+name+"': ") {
+			t.Errorf("no body for type %d %s", code, name)
+		}
+	}
+
+	if size := ksySize(t, doc.Types["base_signature"].Seq); size != binary.Size(BaseSignature{}) {
+		t.Errorf("header is %d bytes, want %d", size, binary.Size(BaseSignature{}))
+	}
+	for name, record := range map[string]interface{}{
+		"eui_signature":        EUISignature{},
+		"compliance_signature": ComplianceSignature{},
+		"extendedid_signature": ExtendedIdSignature{},
+		"pin_signature":        PinSignature{},
+	} {
+		body, ok := doc.Types[name]
+		if !ok {
+			t.Errorf("no type %s", name)
+			continue
+		}
+		if size, want := ksySize(t, body.Seq), binary.Size(record)-binary.Size(BaseSignature{}); size != want {
+			t.Errorf("%s is %d bytes, want %d", name, size, want)
+		}
+	}
+}
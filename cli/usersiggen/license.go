@@ -0,0 +1,473 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "errors"
+import "io/ioutil"
+import "strings"
+import "encoding/json"
+import "encoding/hex"
+import "crypto/sha256"
+import "bufio"
+import "time"
+import "path/filepath"
+
+import "go.thinnect.net/euisig/signature"
+
+// LicenseEligibility maps license profiles to the boards they may be issued
+// for, a board qualifies when either its name or its UUID is listed.
+//
+//	{"beatstack-pro": {"boards": ["tsb2"], "board_uuids": ["7e1a..."]}}
+type LicenseEligibility map[string]struct {
+	Boards     []string `json:"boards"`
+	BoardUuids []string `json:"board_uuids"`
+}
+
+type PreflightOptions struct {
+	Eui            string `long:"eui"             required:"true" description:"EUI-64 the license is to be issued for."`
+	Sigdir         string `long:"sigdir"          default:"sigdata" description:"Where the EUI_XXXXXXXXXXXXXXXX.bin files are stored."`
+	LicenseProfile string `long:"license-profile" description:"License profile to check the board eligibility for."`
+	Eligibility    string `long:"eligibility"     description:"License eligibility JSON file."`
+
+	Registry    string `long:"registry"     description:"License registry, defaults to <sigdir>/issued_licenses.jsonl."`
+	LicenseType string `long:"license-type" description:"Type of the license to be issued, defaults to the license profile."`
+	Params      string `long:"params"       description:"License parameters, identical type and parameters are only issued once."`
+	Reissue     string `long:"reissue"      description:"Reason for issuing an identical license again."`
+	Record      bool   `long:"record"       description:"Record the license as issued after the checks pass."`
+	Purpose     string `long:"purpose"      values:"production,factory-test" default:"production" description:"Purpose of the license, factory-test licenses expire after --validity."`
+	Validity    uint   `long:"validity"     default:"3600" description:"Seconds a factory-test license is valid, at most a day."`
+}
+
+// Factory test licenses let the functional test on the line exercise the
+// features of production firmware without a permanent entitlement. They
+// carry an id of their own and expire after a short validity, they may be
+// issued again for every test run and drop out of the registry once expired.
+const LICENSE_PURPOSE_PRODUCTION = "production"
+const LICENSE_PURPOSE_FACTORY_TEST = "factory-test"
+const FACTORY_TEST_VALIDITY = 3600      // Seconds, when not configured
+const FACTORY_TEST_MAX_VALIDITY = 86400 // Seconds
+
+// licenseTerms are the purpose, id and expiry of a license to be issued, a
+// production license has no id or expiry here.
+type licenseTerms struct {
+	Purpose string
+	Id      string
+	Expires time.Time
+}
+
+func newLicenseTerms(purpose string, validity uint, eui eui64, t time.Time) (licenseTerms, error) {
+	switch purpose {
+	case "", LICENSE_PURPOSE_PRODUCTION:
+		return licenseTerms{Purpose: LICENSE_PURPOSE_PRODUCTION}, nil
+	case LICENSE_PURPOSE_FACTORY_TEST:
+		if validity == 0 {
+			validity = FACTORY_TEST_VALIDITY
+		}
+		if validity > FACTORY_TEST_MAX_VALIDITY {
+			return licenseTerms{}, fmt.Errorf("a factory-test license is valid for at most %d seconds, not %d", FACTORY_TEST_MAX_VALIDITY, validity)
+		}
+		nonce, err := randomBytes(4) // Test runs of the same second get ids of their own
+		if err != nil {
+			return licenseTerms{}, err
+		}
+		return licenseTerms{
+			Purpose: purpose,
+			Id:      fmt.Sprintf("FT-%016X-%d-%x", eui, t.Unix(), nonce),
+			Expires: t.Add(time.Duration(validity) * time.Second),
+		}, nil
+	}
+	return licenseTerms{}, fmt.Errorf("unknown license purpose %s, known are %s and %s", purpose, LICENSE_PURPOSE_PRODUCTION, LICENSE_PURPOSE_FACTORY_TEST)
+}
+
+func (self licenseTerms) temporary() bool {
+	return self.Purpose == LICENSE_PURPOSE_FACTORY_TEST
+}
+
+// record returns the registry record of the license.
+func (self licenseTerms) record(eui eui64, ltype string, params string, t time.Time, reissue string) IssuedLicense {
+	rec := IssuedLicense{Eui: fmt.Sprintf("%016X", eui), Type: ltype, Params: params, Time: t, Reissue: reissue}
+	if self.temporary() {
+		expires := self.Expires
+		rec.Purpose, rec.Id, rec.Expires = self.Purpose, self.Id, &expires
+	}
+	return rec
+}
+
+func loadLicenseEligibility(file string) (LicenseEligibility, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var el LicenseEligibility
+	if err := json.Unmarshal(b, &el); err != nil {
+		return nil, fmt.Errorf("Failed to parse license eligibility %s: %s", file, err)
+	}
+	return el, nil
+}
+
+// licensePreflight verifies that the EUI has been manufactured, meaning its
+// signature is in the sigdir, and when a license profile is given, that the
+// board of the device is eligible for it. It returns the board signature.
+func licensePreflight(eui eui64, sigdir string, profile string, el LicenseEligibility) (*ComponentSignature, error) {
+	sigfile := filepath.Join(sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui))
+	if _, err := os.Stat(sigfile); err != nil {
+		return nil, fmt.Errorf("no device signature for %016X in %s, never manufactured?", eui, sigdir)
+	}
+
+	sigs, err := readSigsFromFile(sigfile)
+	if err != nil {
+		return nil, fmt.Errorf("device signature %s unreadable: %s", sigfile, err)
+	}
+
+	var board *ComponentSignature
+	euifound := false
+	for _, sig := range sigs {
+		if c, ok := signature.ComponentOf(sig); ok {
+			sig = c
+		}
+		switch s := sig.(type) {
+		case EUISignature:
+			euifound = s.Eui64 == eui
+		case ComponentSignature:
+			if s.Signature_type == SIGNATURE_TYPE_BOARD {
+				board = &s
+			}
+		}
+	}
+	if !euifound {
+		return nil, fmt.Errorf("device signature %s does not carry EUI %016X", sigfile, eui)
+	}
+	if board == nil {
+		return nil, fmt.Errorf("device signature %s has no board signature", sigfile)
+	}
+
+	if err := licenseEligible(board, profile, el); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
+
+// licenseEligible checks that the board is eligible for the license profile,
+// any board is when no profile is given.
+func licenseEligible(board *ComponentSignature, profile string, el LicenseEligibility) error {
+	if board == nil {
+		return errors.New("no board signature")
+	}
+	if len(profile) == 0 {
+		return nil
+	}
+
+	rules, ok := el[profile]
+	if !ok {
+		return fmt.Errorf("unknown license profile %s", profile)
+	}
+	for _, name := range rules.Boards {
+		if name == board.BoardName() {
+			return nil
+		}
+	}
+	for _, u := range rules.BoardUuids {
+		bu, err := parseUuid(u)
+		if err == nil && bu == board.Component_uuid {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("board %s %s is not eligible for license profile %s (allowed: %s)",
+		board.BoardName(), board.BoardVersion(), profile, strings.Join(rules.Boards, ", "))
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "license-preflight",
+		short:   "Check that a license may be issued for an EUI",
+		long:    "Require a device signature for the EUI in the sigdir and a board eligible for the license profile.",
+		options: func() interface{} { return new(PreflightOptions) },
+		run:     func(c *commandRun) { preflightMain(c.options.(*PreflightOptions)) },
+	})
+}
+
+func preflightMain(opts *PreflightOptions) {
+	eui, err := parseEui(opts.Eui)
+	if err != nil {
+		fmt.Printf("ERROR parsing EUI64: %s\n", err)
+		os.Exit(2)
+	}
+
+	var el LicenseEligibility
+	if len(opts.LicenseProfile) > 0 {
+		if len(opts.Eligibility) == 0 {
+			fmt.Printf("ERROR --license-profile requires --eligibility\n")
+			os.Exit(2)
+		}
+		el, err = loadLicenseEligibility(opts.Eligibility)
+		if err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	board, err := licensePreflight(eui, opts.Sigdir, opts.LicenseProfile, el)
+	if err != nil {
+		fmt.Printf("REFUSED %016X: %s\n", eui, err)
+		os.Exit(4)
+	}
+
+	registry := opts.Registry
+	if len(registry) == 0 {
+		registry = filepath.Join(opts.Sigdir, "issued_licenses.jsonl")
+	}
+	ltype := opts.LicenseType
+	if len(ltype) == 0 {
+		ltype = opts.LicenseProfile
+	}
+
+	existing, err := findIssuedLicenses(registry, eui)
+	if err != nil {
+		fmt.Printf("ERROR reading license registry: %s\n", err)
+		os.Exit(1)
+	}
+	if len(existing) > 0 {
+		fmt.Printf("Licenses issued for %016X:\n", eui)
+		printIssuedLicenses(existing)
+	}
+
+	now := time.Now().UTC()
+	terms, err := newLicenseTerms(opts.Purpose, opts.Validity, eui, now)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	params := licenseParamsHash(opts.Params)
+	if !terms.temporary() {
+		if err := checkReissue(existing, ltype, params, opts.Reissue); err != nil {
+			fmt.Printf("REFUSED %016X: %s\n", eui, err)
+			os.Exit(4)
+		}
+	}
+
+	if opts.Record {
+		if err := recordIssuedLicense(registry, terms.record(eui, ltype, params, now, opts.Reissue)); err != nil {
+			fmt.Printf("ERROR recording license: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if terms.temporary() {
+		fmt.Printf("OK %016X %s %s %s %s until %s\n", eui, board.BoardName(), board.BoardVersion(), terms.Purpose, terms.Id, TimestampString(terms.Expires))
+	} else {
+		fmt.Printf("OK %016X %s %s\n", eui, board.BoardName(), board.BoardVersion())
+	}
+}
+
+// IssuedLicense is a record in the license registry, an append-only JSON
+// lines file of every license issued, used to catch accidental re-issuance.
+type IssuedLicense struct {
+	Eui     string    `json:"eui64"`
+	Type    string    `json:"type"`
+	Params  string    `json:"params"` // SHA-256 of the license parameters
+	Time    time.Time `json:"time"`
+	Reissue string    `json:"reissue,omitempty"` // Reason given when issued again with identical parameters
+
+	Purpose string     `json:"purpose,omitempty"`    // factory-test for temporary licenses, empty for production
+	Id      string     `json:"license_id,omitempty"` // Id of a temporary license
+	Expires *time.Time `json:"expires,omitempty"`    // End of the validity of a temporary license
+
+	Withdrawn bool `json:"withdrawn,omitempty"` // Cancels earlier records of the same type, parameters and purpose
+}
+
+func licenseParamsHash(params ...string) string {
+	h := sha256.New()
+	for _, p := range params {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func findIssuedLicenses(registry string, eui eui64) ([]IssuedLicense, error) {
+	all, err := readIssuedLicenses(registry)
+	if err != nil {
+		return nil, err
+	}
+	found := all[fmt.Sprintf("%016X", eui)]
+	if found == nil {
+		found = make([]IssuedLicense, 0)
+	}
+	return found, nil
+}
+
+// readIssuedLicenses returns the licenses in effect for every EUI in the
+// registry, withdrawn records cancel earlier ones and expired temporary
+// licenses are left out.
+func readIssuedLicenses(registry string) (map[string][]IssuedLicense, error) {
+	found := make(map[string][]IssuedLicense)
+	now := time.Now()
+
+	in, err := os.Open(registry)
+	if os.IsNotExist(err) {
+		return found, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		var rec IssuedLicense
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // Interrupted write
+		}
+		if rec.Withdrawn {
+			kept := found[rec.Eui][:0]
+			for _, f := range found[rec.Eui] {
+				if f.Type != rec.Type || f.Params != rec.Params || f.Purpose != rec.Purpose {
+					kept = append(kept, f)
+				}
+			}
+			found[rec.Eui] = kept
+		} else if rec.Expires == nil || rec.Expires.After(now) {
+			found[rec.Eui] = append(found[rec.Eui], rec)
+		}
+	}
+	return found, scanner.Err()
+}
+
+// checkReissue refuses a license identical in type and parameters to one
+// already issued, unless a reason for issuing it again is given. Temporary
+// licenses do not count.
+func checkReissue(existing []IssuedLicense, ltype string, params string, reason string) error {
+	for _, rec := range existing {
+		if len(rec.Purpose) > 0 && rec.Purpose != LICENSE_PURPOSE_PRODUCTION {
+			continue
+		}
+		if rec.Type == ltype && rec.Params == params && len(reason) == 0 {
+			return fmt.Errorf("identical %s license already issued for %s at %s, use --reissue with a reason", ltype, rec.Eui, TimestampString(rec.Time))
+		}
+	}
+	return nil
+}
+
+func recordIssuedLicense(registry string, rec IssuedLicense) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(registry, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func printIssuedLicenses(existing []IssuedLicense) {
+	for _, rec := range existing {
+		fmt.Printf("  %s %-16s %s params %.16s", rec.Eui, rec.Type, TimestampString(rec.Time), rec.Params)
+		if len(rec.Reissue) > 0 {
+			fmt.Printf(" reissued: %s", rec.Reissue)
+		}
+		if rec.Expires != nil {
+			fmt.Printf(" %s %s until %s", rec.Purpose, rec.Id, TimestampString(*rec.Expires))
+		}
+		fmt.Printf("\n")
+	}
+}
+
+// parseLicenseWindow returns the validity window of --valid-from and
+// --valid-until, nil for a license without one. Both take YYYY-MM-DD or
+// RFC 3339, a date in --valid-until is valid to the end of the day.
+func parseLicenseWindow(from string, until string) (*LicenseWindow, error) {
+	if len(from) == 0 && len(until) == 0 {
+		return nil, nil
+	}
+	var window LicenseWindow
+	if len(from) > 0 {
+		t, err := parseDecommissionDate(from)
+		if err != nil {
+			return nil, fmt.Errorf("--valid-from %s", err)
+		}
+		window.Not_before = t.Unix()
+	}
+	if len(until) > 0 {
+		t, err := parseDecommissionDate(until)
+		if err != nil {
+			return nil, fmt.Errorf("--valid-until %s", err)
+		}
+		if _, err := time.Parse("2006-01-02", until); err == nil {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		window.Not_after = t.Unix()
+	}
+	if window.Not_before != 0 && window.Not_after != 0 && window.Not_after <= window.Not_before {
+		return nil, fmt.Errorf("--valid-until %s is not after --valid-from %s", until, from)
+	}
+	return &window, nil
+}
+
+func parseLicenseFile(gen *UserSignature, infile string, t time.Time, window *LicenseWindow) ([]byte, error) {
+	b, err := ioutil.ReadFile(infile)
+	if err != nil {
+		return nil, err
+	}
+	if window != nil {
+		// Time-limited licenses are a record type of their own
+		return gen.SerializeLicenseV2(t, b, *window)
+	}
+	return gen.SerializeLicense(t, b)
+}
+
+// licenseMain appends a license of --type license to a signature file into
+// the output file.
+func licenseMain(gen *UserSignature, opts *Options, timestamp time.Time) {
+	if _, err := os.Stat(opts.Sigfile); os.IsNotExist(err) {
+		fmt.Printf("ERROR initial signature file %s not found!\n", opts.Sigfile)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(opts.Licfile); os.IsNotExist(err) {
+		fmt.Printf("ERROR initial license file %s not found!\n", opts.Licfile)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(opts.Output); os.IsExist(err) {
+		fmt.Printf("ERROR output file %s already exists.\n", opts.Output)
+		os.Exit(1)
+	}
+
+	window, err := parseLicenseWindow(opts.ValidFrom, opts.ValidUntil)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	licdata, err := parseLicenseFile(gen, opts.Licfile, timestamp, window)
+	if err != nil {
+		fmt.Printf("ERROR parsing license file: %s\n", err)
+		os.Exit(1)
+	}
+
+	sigfiledata, err := ioutil.ReadFile(opts.Sigfile)
+	if err != nil {
+		fmt.Printf("ERROR reading signature file: %s\n", err)
+		os.Exit(1)
+	}
+
+	licdata = append(sigfiledata, licdata...)
+	err = appendFile(opts.Output, licdata)
+	if err != nil {
+		fmt.Printf("ERROR appending license data to file: %s\n", err)
+		os.Exit(1)
+	}
+	outFormatMain(opts)
+	os.Exit(0)
+}
@@ -0,0 +1,112 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "strings"
+import "testing"
+import "time"
+import "path/filepath"
+
+func TestLicensePreflight(t *testing.T) {
+	sigdir := t.TempDir()
+	writeTestFile(t, sigdir, "EUI-64_70B3D5D72F000001.bin", testArea(t, 0x70B3D5D72F000001))
+	writeTestFile(t, sigdir, "EUI-64_70B3D5D72F000002.bin", testArea(t, 0x70B3D5D72F000003))
+	el, err := loadLicenseEligibility(writeTestFile(t, t.TempDir(), "eligibility.json", []byte(`{
+	"beatstack-pro": {"boards": ["tsb1"], "board_uuids": ["01010101-0101-0101-0101-010101010101"]},
+	"beatstack-lite": {"boards": ["tsb1"]}
+}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		eui     eui64
+		profile string
+		problem string
+	}{
+		{0x70B3D5D72F000001, "", ""},
+		{0x70B3D5D72F000001, "beatstack-pro", ""},
+		{0x70B3D5D72F000001, "beatstack-lite", "board tsb2 1.2.3 is not eligible for license profile beatstack-lite"},
+		{0x70B3D5D72F000001, "beatstack", "unknown license profile beatstack"},
+		{0x70B3D5D72F000002, "", "does not carry EUI 70B3D5D72F000002"},
+		{0x70B3D5D72F000004, "", "never manufactured"},
+	} {
+		board, err := licensePreflight(c.eui, sigdir, c.profile, el)
+		if len(c.problem) == 0 && (err != nil || board.BoardName() != "tsb2") {
+			t.Errorf("%016X %s: %v", c.eui, c.profile, err)
+		}
+		if len(c.problem) > 0 && (err == nil || !strings.Contains(err.Error(), c.problem)) {
+			t.Errorf("%016X %s: %v, want %s", c.eui, c.profile, err, c.problem)
+		}
+	}
+}
+
+// The registry keeps the licenses in effect, withdrawn and expired ones drop
+// out of it.
+func TestIssuedLicenses(t *testing.T) {
+	registry := filepath.Join(t.TempDir(), "issued_licenses.jsonl")
+	now := time.Now().UTC()
+	pro := licenseParamsHash("seats=10")
+	production, _ := newLicenseTerms(LICENSE_PURPOSE_PRODUCTION, 0, 0x70B3D5D72F000001, now)
+	test, err := newLicenseTerms(LICENSE_PURPOSE_FACTORY_TEST, 0, 0x70B3D5D72F000001, now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !test.temporary() || !strings.HasPrefix(test.Id, "FT-70B3D5D72F000001-") {
+		t.Errorf("factory-test terms are %+v", test)
+	}
+	for _, rec := range []IssuedLicense{
+		production.record(0x70B3D5D72F000001, "beatstack-pro", pro, now, ""),
+		production.record(0x70B3D5D72F000001, "beatstack-lite", pro, now, ""),
+		{Eui: "70B3D5D72F000001", Type: "beatstack-lite", Params: pro, Time: now, Withdrawn: true},
+		test.record(0x70B3D5D72F000001, "beatstack-pro", pro, now.Add(-2*time.Hour), ""),
+		production.record(0x70B3D5D72F000002, "beatstack-pro", pro, now, ""),
+	} {
+		if err := recordIssuedLicense(registry, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	existing, err := findIssuedLicenses(registry, 0x70B3D5D72F000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) != 1 || existing[0].Type != "beatstack-pro" || existing[0].Expires != nil {
+		t.Fatalf("licenses in effect are %+v", existing)
+	}
+	if err := checkReissue(existing, "beatstack-pro", pro, ""); err == nil {
+		t.Errorf("identical license issued again without a reason")
+	}
+	if err := checkReissue(existing, "beatstack-pro", pro, "RMA 1234"); err != nil {
+		t.Errorf("reissue with a reason: %s", err)
+	}
+	if err := checkReissue(existing, "beatstack-pro", licenseParamsHash("seats=20"), ""); err != nil {
+		t.Errorf("license with other parameters: %s", err)
+	}
+
+	if _, err := newLicenseTerms(LICENSE_PURPOSE_FACTORY_TEST, FACTORY_TEST_MAX_VALIDITY+1, 0x70B3D5D72F000001, now); err == nil {
+		t.Errorf("factory-test license valid for more than a day")
+	}
+	if _, err := newLicenseTerms("demo", 0, 0x70B3D5D72F000001, now); err == nil {
+		t.Errorf("license for a demo")
+	}
+}
+
+func TestLicenseWindow(t *testing.T) {
+	window, err := parseLicenseWindow("2026-01-01", "2026-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	until := time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC).Unix()
+	if window.Not_before != from || window.Not_after != until {
+		t.Errorf("window is %d..%d, want %d..%d", window.Not_before, window.Not_after, from, until)
+	}
+	if window, err := parseLicenseWindow("", ""); window != nil || err != nil {
+		t.Errorf("a window without bounds: %+v, %v", window, err)
+	}
+	if _, err := parseLicenseWindow("2026-12-31", "2026-01-01"); err == nil {
+		t.Errorf("a window ending before it starts")
+	}
+}
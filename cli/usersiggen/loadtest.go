@@ -0,0 +1,446 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "net"
+import "sort"
+import "sync"
+import "time"
+import "bufio"
+import "bytes"
+import "errors"
+import "strings"
+import "strconv"
+import "net/url"
+import "net/http"
+import "encoding/json"
+
+// The load test runs synthetic operations against a provisioning server at a
+// fixed rate and reports the latency percentiles and error rate of each kind
+// of operation. The target picks the operations:
+//
+//	tcp://host:7000           fixture driver, REQUEST an identity and FAIL it
+//	                          until it is RELEASED, or PASS it with --pass
+//	http://host/graphql       GraphQL query endpoint, POST --query
+//	http://host/heartbeat     fleet server, POST heartbeats of loadtest-N stations
+//
+// Passing fixture identities marks them in the pool, run it only against a
+// staging pool.
+
+const SCHEMA_LOADTEST = "euisiggen/loadtest/v1"
+
+type LoadtestOptions struct {
+	Target       string        `long:"target"         required:"true" description:"tcp://host:port of a fixture driver, http(s)://host/graphql or http(s)://host/heartbeat."`
+	Rate         string        `long:"rate"           default:"10/s" description:"Operations started per second or minute, N/s or N/m."`
+	Duration     time.Duration `long:"duration"       default:"1m" description:"How long to keep starting operations."`
+	Workers      uint          `long:"workers"        default:"8" description:"Concurrent clients, a tcp target gets fixture slots 1..N."`
+	Timeout      time.Duration `long:"timeout"        default:"10s" description:"Timeout of a single request."`
+	Query        string        `long:"query"          default:"{ pool(limit: 10) { total } }" description:"Query sent to a graphql target."`
+	Pass         bool          `long:"pass"           description:"PASS fixture identities instead of failing them, marks them in the pool."`
+	MaxErrorRate float64       `long:"max-error-rate" default:"0.01" description:"Exit with 4 when a larger share of the operations fail."`
+	Json         bool          `long:"json"           description:"Print the report as JSON."`
+}
+
+type LoadtestOperation struct {
+	Name      string  `json:"name"`
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+	Max       float64 `json:"max_ms"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+type LoadtestReport struct {
+	Schema     string              `json:"schema"`
+	Generator  string              `json:"generator"`
+	Target     string              `json:"target"`
+	Started    string              `json:"started"`
+	Duration   float64             `json:"duration_s"`
+	Rate       float64             `json:"rate"`     // Asked for, per second
+	Achieved   float64             `json:"achieved"` // Operations started per second
+	Skipped    int                 `json:"skipped"`  // Ticks when every worker was busy
+	Operations []LoadtestOperation `json:"operations"`
+}
+
+type loadtestResults struct {
+	mutex     sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	lastError map[string]string
+}
+
+func (self *loadtestResults) add(name string, d time.Duration, err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.latencies[name] = append(self.latencies[name], d)
+	if err != nil {
+		self.errors[name]++
+		self.lastError[name] = err.Error()
+	}
+}
+
+// timed runs a request and records its latency under the name.
+func (self *loadtestResults) timed(name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	self.add(name, time.Since(start), err)
+	return err
+}
+
+// loadtestClient runs one operation against the target for every tick.
+type loadtestClient interface {
+	run(results *loadtestResults)
+	close()
+}
+
+// parseRate parses N/s or N/m into operations per second.
+func parseRate(s string) (float64, error) {
+	parts := strings.SplitN(s, "/", 2)
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("rate %s, expected N/s or N/m", s)
+	}
+	if len(parts) == 1 || parts[1] == "s" {
+		return n, nil
+	} else if parts[1] == "m" {
+		return n / 60, nil
+	}
+	return 0, fmt.Errorf("rate %s, expected N/s or N/m", s)
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+type fixtureClient struct {
+	addr    string
+	slot    uint
+	pass    bool
+	timeout time.Duration
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+// exchange sends a line to the fixture driver and returns the reply, the
+// connection is dropped on errors and made again for the next one.
+func (self *fixtureClient) exchange(line string) ([]string, error) {
+	if self.conn == nil {
+		conn, err := net.DialTimeout("tcp", self.addr, self.timeout)
+		if err != nil {
+			return nil, err
+		}
+		self.conn, self.reader = conn, bufio.NewReader(conn)
+	}
+	self.conn.SetDeadline(time.Now().Add(self.timeout))
+	reply, err := func() (string, error) {
+		if _, err := fmt.Fprintf(self.conn, "%s\n", line); err != nil {
+			return "", err
+		}
+		return self.reader.ReadString('\n')
+	}()
+	if err != nil {
+		self.close()
+		return nil, err
+	}
+	fields := strings.Fields(reply)
+	if len(fields) == 0 {
+		return nil, errors.New("empty reply")
+	} else if fields[0] == "ERROR" {
+		return fields, errors.New(strings.TrimSpace(reply))
+	}
+	return fields, nil
+}
+
+func (self *fixtureClient) expect(line string, replies ...string) ([]string, error) {
+	fields, err := self.exchange(line)
+	if err != nil {
+		return fields, err
+	}
+	for _, r := range replies {
+		if fields[0] == r {
+			return fields, nil
+		}
+	}
+	return fields, fmt.Errorf("%s answered with %s", line, strings.Join(fields, " "))
+}
+
+func (self *fixtureClient) run(results *loadtestResults) {
+	err := results.timed("request", func() error {
+		_, err := self.expect(fmt.Sprintf("REQUEST %d", self.slot), "IDENTITY")
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	if self.pass {
+		results.timed("pass", func() error {
+			_, err := self.expect(fmt.Sprintf("PASS %d", self.slot), "OK")
+			return err
+		})
+		return
+	}
+	// Failed until the driver runs out of retries and releases the identity
+	for i := 0; i < 100; i++ {
+		var fields []string
+		err := results.timed("fail", func() error {
+			var err error
+			fields, err = self.expect(fmt.Sprintf("FAIL %d loadtest", self.slot), "RETRY", "RELEASED")
+			return err
+		})
+		if err != nil || fields[0] == "RELEASED" {
+			return
+		}
+	}
+}
+
+func (self *fixtureClient) close() {
+	if self.conn != nil {
+		self.conn.Close()
+		self.conn = nil
+	}
+}
+
+type httpClient struct {
+	name   string
+	url    string
+	client *http.Client
+	body   func() ([]byte, error)
+	check  func(body []byte) error
+}
+
+func (self *httpClient) run(results *loadtestResults) {
+	results.timed(self.name, func() error {
+		b, err := self.body()
+		if err != nil {
+			return err
+		}
+		resp, err := self.client.Post(self.url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var reply bytes.Buffer
+		if _, err := reply.ReadFrom(resp.Body); err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(reply.String()))
+		}
+		if self.check != nil {
+			return self.check(reply.Bytes())
+		}
+		return nil
+	})
+}
+
+func (self *httpClient) close() {}
+
+func graphqlCheck(body []byte) error {
+	var reply struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return err
+	}
+	if len(reply.Errors) > 0 {
+		return errors.New(reply.Errors[0].Message)
+	}
+	return nil
+}
+
+func loadtestClients(opts *LoadtestOptions) ([]loadtestClient, error) {
+	u, err := url.Parse(opts.Target)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %s", opts.Target, err)
+	}
+
+	clients := make([]loadtestClient, 0, opts.Workers)
+	for i := uint(0); i < opts.Workers; i++ {
+		worker := i + 1
+		switch {
+		case u.Scheme == "tcp":
+			clients = append(clients, &fixtureClient{addr: u.Host, slot: worker, pass: opts.Pass, timeout: opts.Timeout})
+		case (u.Scheme == "http" || u.Scheme == "https") && strings.HasSuffix(u.Path, "/graphql"):
+			clients = append(clients, &httpClient{name: "query", url: opts.Target,
+				client: &http.Client{Timeout: opts.Timeout},
+				body: func() ([]byte, error) {
+					return json.Marshal(graphqlRequest{Query: opts.Query})
+				},
+				check: graphqlCheck})
+		case (u.Scheme == "http" || u.Scheme == "https") && strings.HasSuffix(u.Path, "/heartbeat"):
+			clients = append(clients, &httpClient{name: "heartbeat", url: opts.Target,
+				client: &http.Client{Timeout: opts.Timeout},
+				body: func() ([]byte, error) {
+					return json.Marshal(Heartbeat{
+						Schema:        SCHEMA_HEARTBEAT,
+						Station:       fmt.Sprintf("loadtest-%d", worker),
+						Site:          "loadtest",
+						Version:       generatorVersion(),
+						Time:          time.Now().UTC(),
+						PoolRemaining: -1,
+						PoolDaysLeft:  -1,
+						LastStatus:    "loadtest",
+					})
+				}})
+		default:
+			return nil, fmt.Errorf("target %s, expected tcp://host:port, http(s)://host/graphql or http(s)://host/heartbeat", opts.Target)
+		}
+	}
+	return clients, nil
+}
+
+func loadtest(opts *LoadtestOptions) (*LoadtestReport, error) {
+	rate, err := parseRate(opts.Rate)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Workers == 0 {
+		return nil, errors.New("at least one worker is needed")
+	}
+	clients, err := loadtestClients(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &loadtestResults{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+		lastError: make(map[string]string),
+	}
+
+	// Workers take ticks from the queue, a tick is skipped when all are busy
+	ticks := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c loadtestClient) {
+			defer wg.Done()
+			defer c.close()
+			for range ticks {
+				c.run(results)
+			}
+		}(c)
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	end := time.After(opts.Duration)
+	operations, skipped := 0, 0
+loop:
+	for {
+		select {
+		case <-end:
+			break loop
+		case <-ticker.C:
+			select {
+			case ticks <- struct{}{}:
+				operations++
+			default:
+				skipped++
+			}
+		}
+	}
+	ticker.Stop()
+	close(ticks)
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	report := &LoadtestReport{
+		Schema:     SCHEMA_LOADTEST,
+		Generator:  generatorVersion(),
+		Target:     opts.Target,
+		Started:    TimestampString(started),
+		Duration:   elapsed.Seconds(),
+		Rate:       rate,
+		Achieved:   float64(operations) / opts.Duration.Seconds(),
+		Skipped:    skipped,
+		Operations: make([]LoadtestOperation, 0),
+	}
+	names := make([]string, 0, len(results.latencies))
+	for name := range results.latencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l := results.latencies[name]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		op := LoadtestOperation{
+			Name:      name,
+			Count:     len(l),
+			Errors:    results.errors[name],
+			P50:       percentile(l, 0.50),
+			P90:       percentile(l, 0.90),
+			P99:       percentile(l, 0.99),
+			Max:       percentile(l, 1),
+			LastError: results.lastError[name],
+		}
+		op.ErrorRate = float64(op.Errors) / float64(op.Count)
+		report.Operations = append(report.Operations, op)
+	}
+	return report, nil
+}
+
+func printLoadtest(r *LoadtestReport) {
+	fmt.Printf("%s for %.0fs, %.1f/s asked, %.1f/s started, %d skipped with all workers busy\n",
+		r.Target, r.Duration, r.Rate, r.Achieved, r.Skipped)
+	fmt.Printf("%-10s %8s %8s %7s %9s %9s %9s %9s\n", "operation", "count", "errors", "rate", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	for _, op := range r.Operations {
+		fmt.Printf("%-10s %8d %8d %6.2f%% %9.1f %9.1f %9.1f %9.1f\n",
+			op.Name, op.Count, op.Errors, 100*op.ErrorRate, op.P50, op.P90, op.P99, op.Max)
+	}
+	for _, op := range r.Operations {
+		if len(op.LastError) > 0 {
+			fmt.Printf("%s last error: %s\n", op.Name, op.LastError)
+		}
+	}
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "loadtest",
+		short:   "Load test a provisioning server",
+		long:    "Run synthetic operations against a fixture driver, GraphQL or fleet endpoint at a fixed rate and report latency percentiles and error rates.",
+		options: func() interface{} { return new(LoadtestOptions) },
+		run:     func(c *commandRun) { loadtestMain(c.options.(*LoadtestOptions)) },
+		offline: func(c *commandRun) error { return offlineUrl("loadtest", c.options.(*LoadtestOptions).Target) },
+	})
+}
+
+func loadtestMain(opts *LoadtestOptions) {
+	report, err := loadtest(opts)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	if opts.Json {
+		j, _ := json.MarshalIndent(report, "", "\t")
+		fmt.Printf("%s\n", j)
+	} else {
+		printLoadtest(report)
+	}
+
+	for _, op := range report.Operations {
+		if op.ErrorRate > opts.MaxErrorRate {
+			os.Exit(4)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "net"
+import "strings"
+import "testing"
+import "time"
+import "sync/atomic"
+import "net/http"
+import "net/http/httptest"
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]float64{"10/s": 10, "5": 5, "120/m": 2, "0.5/s": 0.5} {
+		if rate, err := parseRate(s); err != nil || rate != want {
+			t.Errorf("rate %s is %f: %v", s, rate, err)
+		}
+	}
+	for _, s := range []string{"10/h", "0/s", "-1/s", "fast"} {
+		if _, err := parseRate(s); err == nil {
+			t.Errorf("rate %s is accepted", s)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for p, want := range map[float64]float64{0.5: 50, 0.9: 90, 0.99: 99, 1: 100, 0: 1} {
+		if ms := percentile(latencies, p); ms != want {
+			t.Errorf("p%.0f is %f ms, want %f", p*100, ms, want)
+		}
+	}
+	if percentile(nil, 0.5) != 0 {
+		t.Errorf("percentile of nothing")
+	}
+}
+
+func loadtestFor(t *testing.T, opts *LoadtestOptions) map[string]LoadtestOperation {
+	t.Helper()
+	report, err := loadtest(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	operations := make(map[string]LoadtestOperation)
+	for _, op := range report.Operations {
+		operations[op.Name] = op
+	}
+	return operations
+}
+
+// Every other query of the GraphQL target fails.
+func TestLoadtestGraphql(t *testing.T) {
+	var queries atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if queries.Add(1)%2 == 0 {
+			w.Write([]byte(`{"errors": [{"message": "pool unavailable"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"pool": {"total": 3}}}`))
+	}))
+	defer srv.Close()
+
+	ops := loadtestFor(t, &LoadtestOptions{Target: srv.URL + "/graphql", Rate: "50/s", Duration: 300 * time.Millisecond, Workers: 1, Timeout: time.Second, Query: "{ pool { total } }"})
+	query, ok := ops["query"]
+	if !ok || len(ops) != 1 || query.Count < 5 || query.Errors != query.Count/2 || query.LastError != "pool unavailable" {
+		t.Errorf("operations are %+v", ops)
+	}
+}
+
+// Fixture identities are requested and failed until released.
+func TestLoadtestFixture(t *testing.T) {
+	srv := testFixture(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+
+	var ops map[string]LoadtestOperation
+	captureStdout(t, func() {
+		ops = loadtestFor(t, &LoadtestOptions{Target: "tcp://" + l.Addr().String(), Rate: "20/s", Duration: 200 * time.Millisecond, Workers: 2, Timeout: time.Second})
+	})
+	if ops["request"].Count == 0 || ops["request"].Errors != 0 || ops["fail"].Count != 2*ops["request"].Count || ops["fail"].Errors != 0 {
+		t.Errorf("operations are %+v", ops)
+	}
+
+	if _, err := loadtestClients(&LoadtestOptions{Target: "http://fleet.example.com/status", Workers: 1}); err == nil || !strings.Contains(err.Error(), "expected tcp://") {
+		t.Errorf("unknown target: %v", err)
+	}
+}
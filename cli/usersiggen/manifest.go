@@ -0,0 +1,175 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "io/ioutil"
+import "encoding/json"
+import "path/filepath"
+
+// A device manifest describes every signature of a device in one JSON file,
+// the board, the platform and all of its components, so that one run with
+// --manifest writes the whole signature area instead of a board run followed
+// by a run per platform and component. The device part of a provisioning
+// profile is a manifest, the records are built the same way.
+
+type DeviceManifest struct {
+	Endianness string             `json:"endianness"` // Byte order of the target, overrides --endianness
+	Crc        string             `json:"crc"`        // Integrity check of the target, overrides --crc
+	Board      ProfileComponent   `json:"board"`
+	Platform   *ProfileComponent  `json:"platform"`
+	Components []ProfileComponent `json:"components"`
+	Compliance *ComplianceInfo    `json:"compliance"`
+	ExtendedId *ExtendedIdConfig  `json:"extended_id"`
+}
+
+func loadDeviceManifest(file string) (*DeviceManifest, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := new(DeviceManifest)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest %s: %s", file, err)
+	}
+	if len(m.Board.Name) == 0 {
+		return nil, fmt.Errorf("Manifest %s has no board", file)
+	}
+	return m, nil
+}
+
+// checkManifestSlots checks the slots of the components against the platform
+// definition.
+func checkManifestSlots(m *DeviceManifest, slots string) error {
+	def, err := loadPlatformDefinition(slots)
+	if err != nil {
+		return err
+	}
+	for _, c := range m.Components {
+		if len(c.Slot) > 0 {
+			if err := def.checkSlot(c.Slot, c.Position); err != nil {
+				return fmt.Errorf("%s: %s", c.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func manifestMain(gen *UserSignature, opts *Options, timestamp time.Time) {
+	m, err := loadDeviceManifest(opts.Manifest)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	// The serial is per device, it is not in a preset
+	if len(opts.Serial) > 0 {
+		m.Board.Serial = opts.Serial
+	}
+	if len(opts.SerialUUID) > 0 {
+		m.Board.SerialUUID = opts.SerialUUID
+	}
+	if len(opts.Slots) > 0 {
+		if err := checkManifestSlots(m, opts.Slots); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	p := &ProvisionProfile{Euifile: opts.Euifile, Tag: opts.Tag, Sigdir: opts.Sigdir, Output: opts.Output,
+		Endianness: m.Endianness, Crc: m.Crc, Board: m.Board, Platform: m.Platform, Components: m.Components, Compliance: m.Compliance, ExtendedId: m.ExtendedId}
+	dev := &ProvisionDevice{Timestamp: timestamp}
+
+	override := len(opts.Eui) > 0
+	if override {
+		if dev.Eui, err = parseEui(opts.Eui); err != nil {
+			fmt.Printf("ERROR parsing EUI64: %s\n", err)
+			os.Exit(1)
+		}
+	} else if dev.Eui, err = getEui(opts.Euifile, opts.Tag, opts.WorkOrder); err != nil {
+		fmt.Printf("ERROR getting EUI64: %s\n", err)
+		os.Exit(1)
+	}
+
+	sigfile := filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", dev.Eui))
+	if !override && opts.IfExists == IF_EXISTS_FAIL {
+		if _, err := os.Stat(sigfile); err == nil {
+			fmt.Printf("ERROR generating sigdata: signature file for %016X exists at %s\n", dev.Eui, sigfile)
+			os.Exit(1)
+		}
+	}
+
+	count, err := buildSigdata(gen, p, dev)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+
+	existing, err := existingSigfile(sigfile, dev.Identity.Sigdata, opts.IfExists, override)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %016X: %s\n", dev.Eui, err)
+		os.Exit(1)
+	}
+	if existing != nil {
+		fmt.Printf("%016X already generated, skipping\n", dev.Eui)
+		if dev.Identity, err = gen.IdentityOf(existing); err != nil {
+			fmt.Printf("ERROR %s: %s\n", sigfile, err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err = os.Stat(opts.Sigdir); os.IsNotExist(err) {
+		if err = os.Mkdir(opts.Sigdir, 0770); err != nil {
+			fmt.Printf("ERROR creating output directory: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Claim, write the signature file, commit with the mark
+	var claims []EuiClaim
+	if existing == nil && !override {
+		claims = []EuiClaim{newClaim(dev.Eui, opts.Tag, sigfile, dev.Identity.Sigdata)}
+		if err := claimEuis(opts.Euifile, claims); err != nil {
+			fmt.Printf("ERROR claiming %016X in %s: %s\n", dev.Eui, opts.Euifile, err)
+			os.Exit(1)
+		}
+	}
+	if existing == nil {
+		if err := rotateBackup(sigfile); err != nil {
+			fmt.Printf("ERROR generating sigdata: creating backup file for %016X of %s failed: %s\n", dev.Eui, sigfile, err)
+			rollbackClaims(opts.Euifile, claims)
+			os.Exit(1)
+		}
+		if err := writeSigdirFile(sigfile, dev.Identity.Sigdata, 0440); err != nil {
+			fmt.Printf("ERROR writing output file: %s\n", err)
+			rollbackClaims(opts.Euifile, claims)
+			os.Exit(1)
+		}
+		registerSigfile(dev.Eui, sigfile)
+	}
+	if !override {
+		if err := markIdentity(opts.Euifile, opts.Tag, dev.Identity); err != nil {
+			fmt.Printf("ERROR marking %016X in %s: %s\n", dev.Eui, opts.Euifile, err)
+			rollbackClaims(opts.Euifile, claims)
+			os.Exit(1)
+		}
+	}
+	if xsig := dev.Identity.ExtendedId(); xsig != nil && len(m.ExtendedId.Pool) > 0 {
+		if err := markId128(m.ExtendedId.Pool, xsig.Id128, dev.Eui, timestamp); err != nil {
+			fmt.Printf("ERROR marking %s used: %s\n", xsig.Id128, err)
+			os.Exit(1)
+		}
+	}
+	if err := ioutil.WriteFile(opts.Output, dev.Identity.Sigdata, 0640); err != nil {
+		fmt.Printf("ERROR writing output file: %s\n", err)
+		os.Exit(1)
+	}
+
+	g_result.Type, g_result.sigfile = "manifest", sigfile
+	fmt.Printf("EUI-64: %016X\n", dev.Eui)
+	fmt.Printf("Signatures: %d, %d bytes\n", count, len(dev.Identity.Sigdata))
+	outFormatMain(opts)
+	os.Exit(0)
+}
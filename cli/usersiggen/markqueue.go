@@ -0,0 +1,367 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bufio"
+import "strings"
+import "syscall"
+import "time"
+import "encoding/json"
+import "path/filepath"
+
+// The pool file often lives on a network share. When it can not be reached at
+// the time an EUI is marked the signature file has already been written, so
+// instead of aborting the mark is queued in a local journal and replayed the
+// next time the pool is used. A replayed mark is only written when the EUI is
+// still free in the pool, anything else is a conflict that stays in the
+// journal until it is resolved by hand.
+
+const DEFAULT_MARK_JOURNAL = "mark-journal.jsonl"
+
+var g_mark_journal = DEFAULT_MARK_JOURNAL
+
+type QueuedMark struct {
+	Pool     string `json:"pool"`
+	Eui      string `json:"eui64"`
+	Mark     string `json:"mark"`
+	Tag      string `json:"tag,omitempty"`
+	Queued   string `json:"queued"`
+	Reason   string `json:"reason"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+type MarkQueueOptions struct {
+	List   MarkQueueListOptions   `command:"list"   description:"Show the queued marks."`
+	Replay MarkQueueReplayOptions `command:"replay" description:"Write the queued marks to the pools that can be reached."`
+	Drop   MarkQueueDropOptions   `command:"drop"   description:"Remove a queued mark, after resolving a conflict by hand."`
+}
+
+type MarkQueueListOptions struct {
+	Json bool `long:"json" description:"Output the queue as JSON."`
+}
+
+type MarkQueueReplayOptions struct {
+	Pool string `long:"pool" description:"Only replay the marks of this pool file."`
+}
+
+type MarkQueueDropOptions struct {
+	Pool string `long:"pool" description:"Only drop the mark from the queue of this pool file."`
+	Args struct {
+		Euis []string `positional-arg-name:"EUI64"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// poolUnavailable tells apart failing to reach the pool from problems with
+// its content.
+func poolUnavailable(err error) bool {
+	switch err.(type) {
+	case *os.PathError, *os.LinkError, *os.SyscallError, syscall.Errno:
+		return true
+	}
+	return false
+}
+
+func setMarkJournal(journal string) {
+	if len(journal) > 0 {
+		g_mark_journal = journal
+	}
+}
+
+func readMarkJournal() ([]QueuedMark, error) {
+	marks := make([]QueuedMark, 0)
+	in, err := os.Open(g_mark_journal)
+	if os.IsNotExist(err) {
+		return marks, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) == 0 {
+			continue
+		}
+		var m QueuedMark
+		if err := json.Unmarshal([]byte(t), &m); err != nil {
+			return nil, fmt.Errorf("%s: %s", g_mark_journal, err)
+		}
+		marks = append(marks, m)
+	}
+	return marks, scanner.Err()
+}
+
+func writeMarkJournal(marks []QueuedMark) error {
+	if len(marks) == 0 {
+		if err := os.Remove(g_mark_journal); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	var sb strings.Builder
+	for _, m := range marks {
+		j, _ := json.Marshal(m)
+		sb.Write(j)
+		sb.WriteString("\n")
+	}
+	tmp := g_mark_journal + ".tmp"
+	if err := writeSyncedFile(tmp, []byte(sb.String())); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g_mark_journal)
+}
+
+func writeSyncedFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// queueMark journals a mark that could not be written to the pool.
+func queueMark(infile string, eui eui64, mark string, cause error) error {
+	if len(g_mark_journal) == 0 {
+		return cause
+	}
+	pool, err := filepath.Abs(infile)
+	if err != nil {
+		return cause
+	}
+
+	tag := ""
+	if splits := strings.Split(mark, ","); len(splits) > 5 {
+		tag = splits[5]
+	}
+	m := QueuedMark{Pool: pool, Eui: fmt.Sprintf("%016X", eui), Mark: mark, Tag: tag,
+		Queued: TimestampString(time.Now().UTC()), Reason: cause.Error()}
+	j, _ := json.Marshal(m)
+
+	f, err := os.OpenFile(g_mark_journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("%s, queueing the mark failed: %s", cause, err)
+	}
+	if _, err := f.Write(append(j, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("%s, queueing the mark failed: %s", cause, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("%s, queueing the mark failed: %s", cause, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%s, queueing the mark failed: %s", cause, err)
+	}
+
+	fmt.Printf("WARNING pool %s unavailable (%s), mark of %016X queued in %s\n", infile, cause, eui, g_mark_journal)
+	return nil
+}
+
+// pendingMarks returns the EUIs of a pool that have queued marks, conflicts
+// included, so they are not handed out again.
+func pendingMarks(infile string) (map[eui64]string, error) {
+	pending := make(map[eui64]string)
+	pool, err := filepath.Abs(infile)
+	if err != nil {
+		return pending, err
+	}
+	marks, err := readMarkJournal()
+	if err != nil {
+		return pending, err
+	}
+	for _, m := range marks {
+		if m.Pool != pool {
+			continue
+		}
+		if e, err := parseEui(m.Eui); err == nil {
+			pending[e] = m.Tag
+		}
+	}
+	return pending, nil
+}
+
+// replayMarks writes the queued marks of the pool, of all pools when infile is
+// empty. Marks of pools that can still not be reached stay queued.
+func replayMarks(infile string) (int, int, error) {
+	marks, err := readMarkJournal()
+	if err != nil || len(marks) == 0 {
+		return 0, 0, err
+	}
+
+	only := ""
+	if len(infile) > 0 {
+		if only, err = filepath.Abs(infile); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	pools := make(map[string][]PoolEntry)
+	replayed, conflicts := 0, 0
+	remaining := make([]QueuedMark, 0, len(marks))
+	for _, m := range marks {
+		if (len(only) > 0 && m.Pool != only) || len(m.Conflict) > 0 {
+			if len(m.Conflict) > 0 {
+				conflicts++
+			}
+			remaining = append(remaining, m)
+			continue
+		}
+
+		entries, ok := pools[m.Pool]
+		if !ok {
+			if entries, err = readPool(m.Pool); err != nil {
+				entries = nil
+			}
+			pools[m.Pool] = entries
+		}
+		if entries == nil {
+			remaining = append(remaining, m)
+			continue
+		}
+
+		if conflict := markConflict(entries, m); len(conflict) > 0 {
+			if conflict != "replayed" {
+				m.Conflict = conflict
+				conflicts++
+				fmt.Printf("ERROR queued mark of %s in %s conflicts: %s\n", m.Eui, m.Pool, conflict)
+				remaining = append(remaining, m)
+			}
+			continue
+		}
+
+		e, _ := parseEui(m.Eui)
+		if err := writeMark(m.Pool, e, m.Mark, time.Now().UnixNano()); err != nil {
+			if poolUnavailable(err) {
+				pools[m.Pool] = nil
+			} else {
+				m.Conflict = err.Error()
+				conflicts++
+				delete(pools, m.Pool)
+			}
+			remaining = append(remaining, m)
+			continue
+		}
+		delete(pools, m.Pool) // Read again for the next mark
+		replayed++
+		fmt.Printf("Replayed queued mark of %s in %s\n", m.Eui, m.Pool)
+	}
+
+	return replayed, conflicts, writeMarkJournal(remaining)
+}
+
+// markConflict checks a queued mark against the pool, "replayed" when the
+// pool already has exactly this mark.
+func markConflict(entries []PoolEntry, m QueuedMark) string {
+	e, err := parseEui(m.Eui)
+	if err != nil {
+		return err.Error()
+	}
+	for _, entry := range entries {
+		if entry.Eui != e {
+			continue
+		}
+		switch entry.Status {
+		case POOL_FREE:
+			return ""
+		case POOL_RESERVED:
+			return "the EUI is reserved in the pool"
+		case POOL_RETIRED:
+			return "the EUI is retired in the pool"
+		}
+		if entry.Mark == m.Mark {
+			return "replayed"
+		}
+		return fmt.Sprintf("the EUI is already marked for %s %s", entry.Board, entry.Version)
+	}
+	return "the EUI is not in the pool"
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "mark-queue",
+		short:   "Marks queued while the pool was unreachable",
+		long:    "List, replay or drop the pool marks queued in the mark journal.",
+		options: func() interface{} { return new(MarkQueueOptions) },
+		run:     func(c *commandRun) { markQueueMain(c.command, c.options.(*MarkQueueOptions)) },
+	})
+}
+
+func markQueueMain(command string, opts *MarkQueueOptions) {
+	switch command {
+	case "list":
+		marks, err := readMarkJournal()
+		if err != nil {
+			fmt.Printf("ERROR reading %s: %s\n", g_mark_journal, err)
+			os.Exit(1)
+		}
+		if opts.List.Json {
+			j, _ := json.MarshalIndent(marks, "", "	")
+			fmt.Println(string(j))
+			return
+		}
+		for _, m := range marks {
+			state := "queued"
+			if len(m.Conflict) > 0 {
+				state = "CONFLICT " + m.Conflict
+			}
+			fmt.Printf("%s %s %s %s (%s)\n", m.Eui, m.Pool, m.Queued, state, m.Reason)
+		}
+
+	case "replay":
+		replayed, conflicts, err := replayMarks(opts.Replay.Pool)
+		if err != nil {
+			fmt.Printf("ERROR replaying %s: %s\n", g_mark_journal, err)
+			os.Exit(1)
+		}
+		marks, _ := readMarkJournal()
+		fmt.Printf("%d replayed, %d conflicts, %d still queued\n", replayed, conflicts, len(marks)-conflicts)
+		if conflicts > 0 {
+			os.Exit(4)
+		}
+
+	case "drop":
+		marks, err := readMarkJournal()
+		if err != nil {
+			fmt.Printf("ERROR reading %s: %s\n", g_mark_journal, err)
+			os.Exit(1)
+		}
+		pool := ""
+		if len(opts.Drop.Pool) > 0 {
+			pool, _ = filepath.Abs(opts.Drop.Pool)
+		}
+		drop := make(map[string]bool)
+		for _, s := range opts.Drop.Args.Euis {
+			e, err := parseEui(s)
+			if err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(2)
+			}
+			drop[fmt.Sprintf("%016X", e)] = true
+		}
+		remaining := make([]QueuedMark, 0, len(marks))
+		for _, m := range marks {
+			if drop[m.Eui] && (len(pool) == 0 || m.Pool == pool) {
+				fmt.Printf("Dropped queued mark of %s in %s\n", m.Eui, m.Pool)
+				continue
+			}
+			remaining = append(remaining, m)
+		}
+		if err := writeMarkJournal(remaining); err != nil {
+			fmt.Printf("ERROR writing %s: %s\n", g_mark_journal, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "errors"
+import "strings"
+import "testing"
+import "path/filepath"
+
+func withMarkJournal(t *testing.T) string {
+	journal := g_mark_journal
+	g_mark_journal = filepath.Join(t.TempDir(), DEFAULT_MARK_JOURNAL)
+	t.Cleanup(func() {
+		g_mark_journal = journal
+	})
+	return g_mark_journal
+}
+
+func TestPoolUnavailable(t *testing.T) {
+	_, err := os.Open(filepath.Join(t.TempDir(), "share", "euis.txt"))
+	if !poolUnavailable(err) {
+		t.Errorf("%s is not unavailable", err)
+	}
+	if poolUnavailable(errors.New("malformed pool line")) {
+		t.Errorf("a malformed pool is unavailable")
+	}
+}
+
+// Marks queued while the share is away are held back from allocation and
+// written once it is back, unless the pool has changed under them.
+func TestMarkQueue(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	journal := withMarkJournal(t)
+	pool := filepath.Join(t.TempDir(), "share", "euis.txt")
+	marks := map[eui64]string{
+		0x70B3D5D72F000001: "tsb2,1.2.3,1700000000,uuid,AA,pilot",
+		0x70B3D5D72F000002: "tsb2,1.2.3,1700000001,uuid,AA,pilot",
+		0x70B3D5D72F000003: "tsb2,1.2.3,1700000002,uuid,AA,pilot",
+		0x70B3D5D72F000004: "tsb2,1.2.3,1700000003,uuid,AA,pilot",
+	}
+	captureStdout(t, func() {
+		for e, mark := range marks {
+			if err := queueMark(pool, e, mark, errors.New("share unavailable")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	pending, err := pendingMarks(pool)
+	if err != nil || len(pending) != 4 || pending[0x70B3D5D72F000001] != "pilot" {
+		t.Errorf("pending marks are %v: %v", pending, err)
+	}
+	if pending, _ := pendingMarks(filepath.Join(filepath.Dir(pool), "other.txt")); len(pending) != 0 {
+		t.Errorf("marks of another pool are pending: %v", pending)
+	}
+
+	// Still away, nothing changes
+	captureStdout(t, func() {
+		if replayed, conflicts, err := replayMarks(""); replayed != 0 || conflicts != 0 || err != nil {
+			t.Errorf("replayed %d, %d conflicts: %v", replayed, conflicts, err)
+		}
+	})
+	if queued, _ := readMarkJournal(); len(queued) != 4 {
+		t.Errorf("%d marks queued, want 4", len(queued))
+	}
+
+	// Back, 2 was marked meanwhile from another station, 3 already has the
+	// queued mark and 4 is reserved
+	writeTestFile(t, filepath.Dir(pool), "euis.txt", []byte("70B3D5D72F000001\n70B3D5D72F000002,tsb2,1.2.3,1700000099\n70B3D5D72F000003,"+marks[0x70B3D5D72F000003]+"\n70B3D5D72F000004,RESERVED\n"))
+	var replayed, conflicts int
+	out := captureStdout(t, func() {
+		replayed, conflicts, err = replayMarks(pool)
+	})
+	if replayed != 1 || conflicts != 2 || err != nil {
+		t.Fatalf("replayed %d, %d conflicts: %v\n%s", replayed, conflicts, err, out)
+	}
+	entries, err := readPool(pool)
+	if err != nil || entries[0].Mark != marks[0x70B3D5D72F000001] {
+		t.Errorf("pool entries are %+v: %v", entries, err)
+	}
+	queued, err := readMarkJournal()
+	if err != nil || len(queued) != 2 {
+		t.Fatalf("queued marks are %+v: %v", queued, err)
+	}
+	conflict := map[string]string{"70B3D5D72F000002": "already marked", "70B3D5D72F000004": "reserved"}
+	for _, m := range queued {
+		if len(conflict[m.Eui]) == 0 || !strings.Contains(m.Conflict, conflict[m.Eui]) {
+			t.Errorf("conflict of %s is %q", m.Eui, m.Conflict)
+		}
+	}
+
+	// Conflicts stay until dropped
+	captureStdout(t, func() {
+		if replayed, conflicts, err := replayMarks(""); replayed != 0 || conflicts != 2 || err != nil {
+			t.Errorf("replayed %d, %d conflicts: %v", replayed, conflicts, err)
+		}
+	})
+	if err := writeMarkJournal(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("an empty journal is kept: %v", err)
+	}
+}
+
+func TestMarkConflict(t *testing.T) {
+	entries := []PoolEntry{{Eui: 0x70B3D5D72F000001, Status: POOL_FREE}, {Eui: 0x70B3D5D72F000002, Status: POOL_RETIRED}}
+	for eui, want := range map[string]string{
+		"70B3D5D72F000001": "",
+		"70B3D5D72F000002": "the EUI is retired in the pool",
+		"70B3D5D72F000003": "the EUI is not in the pool",
+	} {
+		if conflict := markConflict(entries, QueuedMark{Eui: eui, Mark: "tsb2"}); conflict != want {
+			t.Errorf("conflict of %s is %q, want %q", eui, conflict, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "net"
+import "context"
+import "net/url"
+import "net/http"
+import "strings"
+
+// Offline mode for air-gapped lines. With --offline nothing the generator
+// does opens a network connection: the HTTP transport every client uses
+// refuses to dial anything but the loopback interface, and a command or a
+// profile that needs the network, a heartbeat url, an MES stage, a
+// calibration source url, a fleet endpoint, a server listening on the
+// network, is refused before it starts instead of failing halfway.
+// External commands of a profile are programs of their own, they get
+// THINNECT_OFFLINE=1 in their environment.
+
+var g_offline bool
+
+// loopback tells if a host:port, or a bare host, is on the loopback
+// interface. An empty host listens on every interface.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func errOffline(what string, addr string) error {
+	return fmt.Errorf("%s needs the network, %s, refused in --offline mode", what, addr)
+}
+
+// offlineAddr refuses a network address in offline mode.
+func offlineAddr(what string, addr string) error {
+	if !g_offline || loopback(addr) {
+		return nil
+	}
+	return errOffline(what, addr)
+}
+
+// offlineUrl refuses an http(s) or tcp url in offline mode, anything else is
+// a file.
+func offlineUrl(what string, s string) error {
+	if !g_offline || !(isUrl(s) || strings.HasPrefix(s, "tcp://")) {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(s, "{eui}", "0000000000000000", -1))
+	if err != nil {
+		return err
+	}
+	if loopback(u.Hostname()) {
+		return nil
+	}
+	return errOffline(what, u.Host)
+}
+
+// offlineProfile refuses a profile that needs the network.
+func offlineProfile(p *ProvisionProfile) error {
+	if p.Heartbeat != nil && len(p.Heartbeat.Url) > 0 {
+		if err := offlineUrl("heartbeat", p.Heartbeat.Url); err != nil {
+			return err
+		}
+	}
+	if mes := p.Stages["mes"]; mes.Enabled {
+		if err := offlineUrl("mes stage", mes.Url); err != nil {
+			return err
+		}
+	}
+	components := append([]ProfileComponent{p.Board}, p.Components...)
+	if p.Platform != nil {
+		components = append(components, *p.Platform)
+	}
+	for _, c := range components {
+		if c.Calibration != nil {
+			if err := offlineUrl(c.Name+" calibration source", c.Calibration.Source); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// offlineDial is the dialer of the HTTP transport in offline mode.
+func offlineDial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if !loopback(addr) {
+		return nil, errOffline("connection", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+func setOffline(offline bool) {
+	g_offline = offline
+	if offline {
+		// No proxy either, a local proxy would carry the requests out
+		http.DefaultTransport = &http.Transport{DialContext: offlineDial}
+		// Inherited by the external commands
+		os.Setenv("THINNECT_OFFLINE", "1")
+	}
+}
+
+// offlineCommand refuses a command line that needs the network, the
+// subcommand when it is given.
+func offlineCommand(opts *Options, active *commandRun) error {
+	if !g_offline {
+		return nil
+	}
+	if err := offlineUrl("--calibration-source", opts.CalibrationSource); err != nil {
+		return err
+	}
+	if err := offlineUrl("--eui-source", opts.EuiSource); err != nil {
+		return err
+	}
+	if active != nil {
+		return active.offline()
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bytes"
+import "errors"
+import "regexp"
+import "strconv"
+import "io/ioutil"
+import "path/filepath"
+import "strings"
+
+// Formatted copies of the signature file for flashing toolchains that do not
+// take raw binaries and for firmware builds that compile a default signature
+// in. The binary --out stays as it is, the later generator runs
+// append to it, and the formatted copy is written next to it again after
+// every run.
+
+var g_c_identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseOutAddress parses the base address of the formatted output, decimal
+// or 0x prefixed hex.
+func parseOutAddress(s string) (uint32, error) {
+	a, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("address %s, expected a 32-bit number like 0x0007F000", s)
+	}
+	return uint32(a), nil
+}
+
+// formattedName is the name of the formatted copy, the output with the
+// extension of the format.
+func formattedName(output string, ext string) string {
+	name := strings.TrimSuffix(output, filepath.Ext(output)) + ext
+	if name == output {
+		return output + ext // Never the binary itself
+	}
+	return name
+}
+
+func srecLine(t byte, address uint32, asize int, data []byte) string {
+	rec := make([]byte, 0, 1+asize+len(data)+1)
+	rec = append(rec, byte(asize+len(data)+1))
+	for i := asize - 1; i >= 0; i-- {
+		rec = append(rec, byte(address>>(8*uint(i))))
+	}
+	rec = append(rec, data...)
+	var sum byte
+	for _, b := range rec {
+		sum += b
+	}
+	rec = append(rec, ^sum)
+	return fmt.Sprintf("S%c%X\n", t, rec)
+}
+
+// srecord encodes data at the base address as Motorola S-records, S1, S2 or
+// S3 data records by the highest address, with length data bytes per record,
+// a header record and a count and termination record.
+func srecord(data []byte, base uint32, length int, header string) ([]byte, error) {
+	if length < 1 || length > 250 {
+		return nil, errors.New("record length must be 1..250 bytes")
+	}
+	end := uint64(base) + uint64(len(data))
+	if end > 1<<32 {
+		return nil, fmt.Errorf("%d bytes do not fit at 0x%08X", len(data), base)
+	}
+
+	// Data and termination record types and the address size for them
+	dtype, ttype, asize := byte('1'), byte('9'), 2
+	if end > 1<<24 {
+		dtype, ttype, asize = '3', '7', 4
+	} else if end > 1<<16 {
+		dtype, ttype, asize = '2', '8', 3
+	}
+
+	if len(header) > 64 {
+		header = header[:64]
+	}
+	var out bytes.Buffer
+	out.WriteString(srecLine('0', 0, 2, []byte(header)))
+	count := 0
+	for offset := 0; offset < len(data); offset += length {
+		chunk := data[offset:]
+		if len(chunk) > length {
+			chunk = chunk[:length]
+		}
+		out.WriteString(srecLine(dtype, base+uint32(offset), asize, chunk))
+		count++
+	}
+	if count <= 0xFFFF {
+		out.WriteString(srecLine('5', uint32(count), 2, nil))
+	} else {
+		out.WriteString(srecLine('6', uint32(count), 3, nil))
+	}
+	out.WriteString(srecLine(ttype, base, asize, nil))
+	return out.Bytes(), nil
+}
+
+// cheader formats data as a C array of the symbol, with the length as a
+// macro, for compiling a default signature area into development firmware.
+func cheader(data []byte, symbol string, source string) ([]byte, error) {
+	if !g_c_identifier.MatchString(symbol) {
+		return nil, fmt.Errorf("symbol %s is not a C identifier", symbol)
+	}
+	guard := strings.ToUpper(symbol)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "/* Signature area %s, generated by usersiggen %s. Do not edit. */\n", source, generatorVersion())
+	fmt.Fprintf(&out, "#ifndef %s_H_\n#define %s_H_\n\n#include <stdint.h>\n\n", guard, guard)
+	fmt.Fprintf(&out, "#define %s_LENGTH %d\n\n", guard, len(data))
+	fmt.Fprintf(&out, "static const uint8_t %s[%s_LENGTH] = {\n", symbol, guard)
+	for offset := 0; offset < len(data); offset += 12 {
+		line := data[offset:]
+		if len(line) > 12 {
+			line = line[:12]
+		}
+		out.WriteString("\t")
+		for i, b := range line {
+			if i > 0 {
+				out.WriteString(" ")
+			}
+			fmt.Fprintf(&out, "0x%02X,", b)
+		}
+		out.WriteString("\n")
+	}
+	fmt.Fprintf(&out, "};\n\n#endif // %s_H_\n", guard)
+	return out.Bytes(), nil
+}
+
+// writeOutFormat writes the formatted copy of the output for --out-format,
+// nothing for the plain binary.
+func writeOutFormat(opts *Options) error {
+	if opts.OutFormat == "bin" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(opts.Output)
+	if err != nil {
+		return err
+	}
+	base, err := parseOutAddress(opts.OutAddress)
+	if err != nil {
+		return err
+	}
+
+	var formatted []byte
+	var name string
+	switch opts.OutFormat {
+	case "srec":
+		name = formattedName(opts.Output, ".srec")
+		formatted, err = srecord(data, base, int(opts.OutRecordLength), filepath.Base(opts.Output))
+	case "cheader":
+		name = formattedName(opts.Output, ".h")
+		formatted, err = cheader(data, opts.Symbol, filepath.Base(opts.Output))
+	default:
+		return fmt.Errorf("unknown output format %s", opts.OutFormat)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, formatted, 0640)
+}
+
+func outFormatMain(opts *Options) {
+	if err := writeOutFormat(opts); err != nil {
+		fmt.Printf("ERROR writing %s output: %s\n", opts.OutFormat, err)
+		os.Exit(1)
+	}
+	resultMain(opts)
+}
@@ -0,0 +1,409 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bytes"
+import "errors"
+import "io/ioutil"
+import "encoding/binary"
+import "encoding/pem"
+import "crypto/ed25519"
+import "crypto/sha256"
+import "crypto/x509"
+
+import "go.thinnect.net/euisig/signature"
+
+// Signed delta patches for the signature area, so a record changed after
+// production (a field calibration update) can be sent on its own. A patch is
+// bound to the EUI of the device and every entry to the hash of the bytes it
+// replaces, so it only applies to the area it was made for.
+//
+//	magic "TSP1" | version u8 | eui u64 | entries u8
+//	entry: offset u16 | old_len u16 | old_sha256 [32] | new_len u16 | new bytes
+//	ed25519 signature [64] over everything before it
+//
+// Entries are applied from the highest offset down, so the offsets of the
+// earlier entries stay valid when a record changes in size.
+
+var g_patch_magic = []byte("TSP1")
+
+const PATCH_VERSION = 1
+
+type PatchEntry struct {
+	Offset  uint16
+	OldLen  uint16
+	OldHash [32]byte
+	New     []byte
+}
+
+type SigPatch struct {
+	Eui     eui64
+	Entries []PatchEntry
+}
+
+type PatchCreateOptions struct {
+	Old string `long:"old" required:"true" description:"Signature area as it is on the device."`
+	New string `long:"new" required:"true" description:"Signature area as it should become."`
+	Key string `long:"key" required:"true" description:"Ed25519 private key, PKCS#8 PEM (openssl genpkey -algorithm ed25519)."`
+	Out string `long:"out" required:"true" description:"Patch file."`
+}
+
+type PatchApplyOptions struct {
+	In     string `long:"in"     required:"true" description:"Signature area to patch."`
+	Patch  string `long:"patch"  required:"true" description:"Patch file."`
+	Pubkey string `long:"pubkey" required:"true" description:"Ed25519 public key, PEM."`
+	Out    string `long:"out"    required:"true" description:"Patched signature area."`
+}
+
+type PatchVerifyOptions struct {
+	Patch  string `long:"patch"  required:"true" description:"Patch file."`
+	Pubkey string `long:"pubkey" required:"true" description:"Ed25519 public key, PEM."`
+	In     string `long:"in"     description:"Also check that the patch applies to this signature area."`
+}
+
+type PatchOptions struct {
+	Create PatchCreateOptions `command:"create" description:"Create a signed patch between two signature areas."`
+	Apply  PatchApplyOptions  `command:"apply"  description:"Verify and apply a patch to a signature area."`
+	Verify PatchVerifyOptions `command:"verify" description:"Verify the signature of a patch."`
+}
+
+// sigRecords splits a signature area into its records, padding is dropped.
+func sigRecords(data []byte) [][]byte {
+	records := make([][]byte, 0)
+	for offset := 0; offset < len(data); {
+		size, err := signature.RecordLength(data[offset:])
+		if err != nil || offset+size > len(data) {
+			break
+		}
+		records = append(records, data[offset:offset+size])
+		offset += size
+	}
+	return records
+}
+
+func areaEui(data []byte) (eui64, error) {
+	sigs, err := readSigs(data)
+	if err != nil {
+		return 0, err
+	}
+	for _, sig := range sigs {
+		if s, ok := sig.(EUISignature); ok {
+			return s.Eui64, nil
+		}
+	}
+	return 0, errors.New("signature area has no EUI64 record")
+}
+
+// diffAreas compares the areas record by record. Records that change in
+// place become entries of their own, from the first change in size onwards
+// the rest of the area is replaced as one entry.
+func diffAreas(old []byte, new []byte) []PatchEntry {
+	oldr := sigRecords(old)
+	newr := sigRecords(new)
+	entries := make([]PatchEntry, 0)
+
+	offset := 0
+	i := 0
+	for ; i < len(oldr) && i < len(newr) && len(oldr[i]) == len(newr[i]); i++ {
+		if !bytes.Equal(oldr[i], newr[i]) {
+			entries = append(entries, PatchEntry{uint16(offset), uint16(len(oldr[i])), sha256.Sum256(oldr[i]), newr[i]})
+		}
+		offset += len(oldr[i])
+	}
+
+	if i < len(oldr) || i < len(newr) {
+		var rold, rnew []byte
+		for _, r := range oldr[i:] {
+			rold = append(rold, r...)
+		}
+		for _, r := range newr[i:] {
+			rnew = append(rnew, r...)
+		}
+		entries = append(entries, PatchEntry{uint16(offset), uint16(len(rold)), sha256.Sum256(rold), rnew})
+	}
+	return entries
+}
+
+func (self *SigPatch) body() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(g_patch_magic)
+	buf.WriteByte(PATCH_VERSION)
+	binary.Write(buf, binary.BigEndian, uint64(self.Eui))
+	buf.WriteByte(uint8(len(self.Entries)))
+	for _, e := range self.Entries {
+		binary.Write(buf, binary.BigEndian, e.Offset)
+		binary.Write(buf, binary.BigEndian, e.OldLen)
+		buf.Write(e.OldHash[:])
+		binary.Write(buf, binary.BigEndian, uint16(len(e.New)))
+		buf.Write(e.New)
+	}
+	return buf.Bytes()
+}
+
+// parsePatch checks the signature of a patch and decodes it.
+func parsePatch(data []byte, pub ed25519.PublicKey) (*SigPatch, error) {
+	if len(data) < len(g_patch_magic)+10+ed25519.SignatureSize || !bytes.HasPrefix(data, g_patch_magic) {
+		return nil, errors.New("not a signature patch")
+	}
+	body := data[:len(data)-ed25519.SignatureSize]
+	if !ed25519.Verify(pub, body, data[len(body):]) {
+		return nil, errors.New("patch signature is not valid")
+	}
+
+	r := bytes.NewReader(body[len(g_patch_magic):])
+	var version, count uint8
+	var eui uint64
+	binary.Read(r, binary.BigEndian, &version)
+	if version != PATCH_VERSION {
+		return nil, fmt.Errorf("unsupported patch version %d", version)
+	}
+	binary.Read(r, binary.BigEndian, &eui)
+	binary.Read(r, binary.BigEndian, &count)
+
+	patch := &SigPatch{Eui: eui64(eui)}
+	for i := 0; i < int(count); i++ {
+		var e PatchEntry
+		var newlen uint16
+		binary.Read(r, binary.BigEndian, &e.Offset)
+		binary.Read(r, binary.BigEndian, &e.OldLen)
+		r.Read(e.OldHash[:])
+		if err := binary.Read(r, binary.BigEndian, &newlen); err != nil {
+			return nil, errors.New("patch is truncated")
+		}
+		e.New = make([]byte, newlen)
+		if n, _ := r.Read(e.New); n != int(newlen) {
+			return nil, errors.New("patch is truncated")
+		}
+		patch.Entries = append(patch.Entries, e)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing data in patch")
+	}
+	return patch, nil
+}
+
+// apply checks that every entry matches the bytes it replaces and returns
+// the patched area.
+func (self *SigPatch) apply(area []byte) ([]byte, error) {
+	eui, err := areaEui(area)
+	if err != nil {
+		return nil, err
+	}
+	if eui != self.Eui {
+		return nil, fmt.Errorf("patch is for %016X, the area belongs to %016X", self.Eui, eui)
+	}
+
+	records := 0
+	for _, r := range sigRecords(area) {
+		records += len(r)
+	}
+	result := append([]byte{}, area[:records]...)
+	padding := area[records:]
+
+	for i := len(self.Entries) - 1; i >= 0; i-- {
+		e := self.Entries[i]
+		end := int(e.Offset) + int(e.OldLen)
+		if end > len(result) {
+			return nil, fmt.Errorf("entry at %d reaches past the records", e.Offset)
+		}
+		if sha256.Sum256(result[e.Offset:end]) != e.OldHash {
+			return nil, fmt.Errorf("entry at %d does not match the area, already patched or a different area", e.Offset)
+		}
+		result = append(append(append([]byte{}, result[:e.Offset]...), e.New...), result[end:]...)
+	}
+
+	// A device area keeps its size, a plain signature file has no padding
+	if len(padding) > 0 {
+		if len(result) > len(area) {
+			return nil, fmt.Errorf("patched records (%d bytes) do not fit the area (%d bytes)", len(result), len(area))
+		}
+		result = append(result, bytes.Repeat([]byte{0xFF}, len(area)-len(result))...)
+	}
+
+	if _, err := readSigs(result); err != nil {
+		return nil, fmt.Errorf("patched area does not decode: %s", err)
+	}
+	return result, nil
+}
+
+func loadPemBlock(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", file)
+	}
+	return block.Bytes, nil
+}
+
+func loadEd25519Private(file string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", file)
+	}
+	der := block.Bytes
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if der, err = decryptPrivateKey(der, file); err != nil {
+			return nil, err
+		}
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			return nil, errors.New("wrong passphrase for " + file)
+		}
+		return nil, err
+	}
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s is not an Ed25519 key", file)
+}
+
+func loadEd25519Public(file string) (ed25519.PublicKey, error) {
+	der, err := loadPemBlock(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(ed25519.PublicKey); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s is not an Ed25519 key", file)
+}
+
+func patchCreate(opts *PatchCreateOptions) error {
+	old, err := readSigdirFile(opts.Old)
+	if err != nil {
+		return err
+	}
+	new, err := readSigdirFile(opts.New)
+	if err != nil {
+		return err
+	}
+	if _, err := readSigs(new); err != nil {
+		return fmt.Errorf("%s: %s", opts.New, err)
+	}
+	eui, err := areaEui(old)
+	if err != nil {
+		return fmt.Errorf("%s: %s", opts.Old, err)
+	}
+	if neweui, err := areaEui(new); err != nil || neweui != eui {
+		return errors.New("the areas do not belong to the same EUI")
+	}
+	key, err := loadSigningKey(opts.Key, "patch")
+	if err != nil {
+		return err
+	}
+
+	patch := &SigPatch{Eui: eui, Entries: diffAreas(old, new)}
+	if len(patch.Entries) == 0 {
+		return errors.New("the areas are identical, nothing to patch")
+	}
+	if len(patch.Entries) > 255 {
+		return errors.New("too many changed records for one patch")
+	}
+	body := patch.body()
+	data := append(body, ed25519.Sign(key, body)...)
+	if err := ioutil.WriteFile(opts.Out, data, 0640); err != nil {
+		return err
+	}
+
+	for _, e := range patch.Entries {
+		fmt.Printf("offset %d: %d bytes replaced by %d\n", e.Offset, e.OldLen, len(e.New))
+	}
+	fmt.Printf("Patch for %016X, %d bytes in %s\n", eui, len(data), opts.Out)
+	return nil
+}
+
+func loadPatch(file string, pubkey string) (*SigPatch, error) {
+	pub, err := loadEd25519Public(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parsePatch(data, pub)
+}
+
+func patchApply(opts *PatchApplyOptions) error {
+	patch, err := loadPatch(opts.Patch, opts.Pubkey)
+	if err != nil {
+		return err
+	}
+	area, err := readSigdirFile(opts.In)
+	if err != nil {
+		return err
+	}
+	result, err := patch.apply(area)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(opts.Out, result, 0640); err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d entries for %016X to %s\n", len(patch.Entries), patch.Eui, opts.Out)
+	return nil
+}
+
+func patchVerify(opts *PatchVerifyOptions) error {
+	patch, err := loadPatch(opts.Patch, opts.Pubkey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signature OK, patch for %016X\n", patch.Eui)
+	for _, e := range patch.Entries {
+		fmt.Printf("offset %d: %d bytes replaced by %d\n", e.Offset, e.OldLen, len(e.New))
+	}
+	if len(opts.In) > 0 {
+		area, err := readSigdirFile(opts.In)
+		if err != nil {
+			return err
+		}
+		if _, err := patch.apply(area); err != nil {
+			return err
+		}
+		fmt.Printf("Applies to %s\n", opts.In)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "patch",
+		short:   "Signed delta patches of the signature area",
+		long:    "Create, verify and apply signed patches replacing changed records, for updating devices in the field.",
+		options: func() interface{} { return new(PatchOptions) },
+		run:     func(c *commandRun) { patchMain(c.command, c.options.(*PatchOptions)) },
+	})
+}
+
+func patchMain(command string, opts *PatchOptions) {
+	var err error
+	switch command {
+	case "create":
+		err = patchCreate(&opts.Create)
+	case "apply":
+		err = patchApply(&opts.Apply)
+	case "verify":
+		err = patchVerify(&opts.Verify)
+	}
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,120 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "bytes"
+import "testing"
+import "crypto/ed25519"
+import "path/filepath"
+
+import "go.thinnect.net/euisig/signature"
+
+// changedArea returns the test area with a new serial number on the platform,
+// record 2 at 110..195.
+func changedArea(t *testing.T) []byte {
+	area := testArea(t, 0x70B3D5D72F000001)
+	record := area[110:196]
+	copy(record[bytes.Index(record, []byte("SN-0001")):], "SN-0002")
+	signature.Recheck(record)
+	if _, err := readSigs(area); err != nil {
+		t.Fatal(err)
+	}
+	return area
+}
+
+func TestDiffAreas(t *testing.T) {
+	old := testArea(t, 0x70B3D5D72F000001)
+	if entries := diffAreas(old, old); len(entries) != 0 {
+		t.Errorf("identical areas differ in %v", entries)
+	}
+
+	entries := diffAreas(old, changedArea(t))
+	if len(entries) != 1 || entries[0].Offset != 110 || entries[0].OldLen != 86 || len(entries[0].New) != 86 {
+		t.Errorf("changed platform is %+v", entries)
+	}
+
+	// Records dropped from the end are replaced by nothing
+	entries = diffAreas(old, old[:196])
+	if len(entries) != 1 || entries[0].Offset != 196 || entries[0].OldLen != 86 || len(entries[0].New) != 0 {
+		t.Errorf("dropped component is %+v", entries)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	dir := t.TempDir()
+	old := testArea(t, 0x70B3D5D72F000001)
+	new := changedArea(t)
+	key, pub := writeTestKeys(t, dir, "patch")
+	_, other := writeTestKeys(t, dir, "other")
+	create := &PatchCreateOptions{
+		Old: writeTestFile(t, dir, "old.bin", old),
+		New: writeTestFile(t, dir, "new.bin", new),
+		Key: key,
+		Out: filepath.Join(dir, "update.tsp"),
+	}
+	captureStdout(t, func() {
+		if err := patchCreate(create); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := loadPatch(create.Out, other); err == nil {
+		t.Errorf("patch verified with another key")
+	}
+	patch, err := loadPatch(create.Out, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch.Eui != 0x70B3D5D72F000001 || len(patch.Entries) != 1 {
+		t.Fatalf("patch is %+v", patch)
+	}
+
+	// The device area keeps its size and padding
+	device := append(append([]byte{}, old...), bytes.Repeat([]byte{0xFF}, 64)...)
+	patched, err := patch.apply(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched, append(append([]byte{}, new...), bytes.Repeat([]byte{0xFF}, 64)...)) {
+		t.Errorf("patched area is not the new area")
+	}
+	if _, err := patch.apply(patched); err == nil {
+		t.Errorf("patch applied twice")
+	}
+	if _, err := patch.apply(testArea(t, 0x70B3D5D72F000002)); err == nil {
+		t.Errorf("patch applied to another device")
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	dir := t.TempDir()
+	key, pub := writeTestKeys(t, dir, "patch")
+	priv, err := loadEd25519Private(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey, err := loadEd25519Public(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := &SigPatch{Eui: 0x70B3D5D72F000001, Entries: diffAreas(testArea(t, 0x70B3D5D72F000001), changedArea(t))}
+	body := patch.body()
+	data := append(append([]byte{}, body...), ed25519.Sign(priv, body)...)
+
+	parsed, err := parsePatch(data, pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Eui != patch.Eui || len(parsed.Entries) != 1 || !bytes.Equal(parsed.Entries[0].New, patch.Entries[0].New) || parsed.Entries[0].OldHash != patch.Entries[0].OldHash {
+		t.Errorf("parsed patch is %+v", parsed)
+	}
+
+	data[len(g_patch_magic)+12] ^= 0x01
+	if _, err := parsePatch(data, pubkey); err == nil {
+		t.Errorf("a changed patch is valid")
+	}
+	if _, err := parsePatch([]byte("TSP1"), pubkey); err == nil {
+		t.Errorf("a truncated patch is valid")
+	}
+}
@@ -0,0 +1,229 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "io/ioutil"
+import "encoding/json"
+import "time"
+
+// Per-device setup PINs. The PIN is drawn from the random source and written
+// only to the delivery file for the fulfillment team, sealed with the
+// delivery key like an encrypted sigdir file. The signature area gets a
+// salted hash of it, never the PIN:
+//
+//	usersiggen --type pin --pin-delivery pins.sealed --pin-key fulfillment.key --out sigdata.bin
+//	usersiggen pins --file pins.sealed --key fulfillment.key
+
+const SCHEMA_PINS = "euisiggen/pins/v1"
+
+type DevicePin struct {
+	Eui64   string    `json:"eui64"`
+	Pin     string    `json:"pin"`
+	Created time.Time `json:"created"`
+}
+
+type PinDelivery struct {
+	Schema    string      `json:"schema"`
+	Generator string      `json:"generator"`
+	Pins      []DevicePin `json:"pins"`
+}
+
+type PinsOptions struct {
+	File string `long:"file" required:"true" description:"PIN delivery file."`
+	Key  string `long:"key"  required:"true" description:"Delivery key, 32 bytes or 64 hex digits."`
+}
+
+// randomPin returns a PIN of n uniformly random digits.
+func randomPin(n int) (string, error) {
+	pin := make([]byte, 0, n)
+	for len(pin) < n {
+		b, err := randomBytes(n)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			// 250 is the largest multiple of 10 a byte holds, larger would bias
+			if c < 250 && len(pin) < n {
+				pin = append(pin, '0'+c%10)
+			}
+		}
+	}
+	return string(pin), nil
+}
+
+func loadPinDelivery(file string, key []byte) (*PinDelivery, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &PinDelivery{Schema: SCHEMA_PINS, Pins: make([]DevicePin, 0)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !isSealed(data) {
+		return nil, fmt.Errorf("%s is not a sealed PIN delivery file", file)
+	}
+	plain, err := openSealed(key, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	d := new(PinDelivery)
+	if err := json.Unmarshal(plain, d); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if d.Schema != SCHEMA_PINS {
+		return nil, fmt.Errorf("%s is not a PIN delivery file", file)
+	}
+	return d, nil
+}
+
+func writePinDelivery(file string, key []byte, d *PinDelivery) error {
+	d.Generator = generatorVersion()
+	b, err := json.MarshalIndent(d, "", "	")
+	if err != nil {
+		return err
+	}
+	sealed, err := sealData(key, b)
+	if err != nil {
+		return err
+	}
+	if err := rotateBackup(file); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, sealed, 0600)
+}
+
+func pinMain(gen *UserSignature, opts *Options, timestamp time.Time) {
+	if len(opts.PinDelivery) == 0 || len(opts.PinKey) == 0 {
+		fmt.Printf("ERROR --type pin needs --pin-delivery and --pin-key, the PIN is only kept there\n")
+		os.Exit(2)
+	}
+	if opts.PinLength < 4 || opts.PinLength > MAX_PIN_LENGTH {
+		fmt.Printf("ERROR --pin-length must be 4 to %d\n", MAX_PIN_LENGTH)
+		os.Exit(2)
+	}
+	key, err := loadSigdirKey(opts.PinKey)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(opts.Output); os.IsNotExist(err) {
+		fmt.Printf("ERROR initial signature file %s not found!\n", opts.Output)
+		os.Exit(1)
+	}
+	sigs, err := readSigsFromFile(opts.Output)
+	if err != nil {
+		fmt.Printf("ERROR reading signature file: %s\n", err)
+		os.Exit(1)
+	}
+	var owner eui64
+	found := false
+	for _, s := range sigs {
+		switch r := s.(type) {
+		case EUISignature:
+			owner = r.Eui64
+			found = true
+		case PinSignature:
+			fmt.Printf("ERROR %s already has a setup PIN\n", opts.Output)
+			os.Exit(4)
+		case AreaSignature:
+			fmt.Printf("ERROR %s is signed, the PIN must be added before --type sign\n", opts.Output)
+			os.Exit(4)
+		}
+	}
+	if !found {
+		fmt.Printf("ERROR %s has no EUI-64, PINs are delivered per EUI\n", opts.Output)
+		os.Exit(1)
+	}
+
+	delivery, err := loadPinDelivery(opts.PinDelivery, key)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	for _, p := range delivery.Pins {
+		if p.Eui64 == fmt.Sprintf("%016X", owner) {
+			fmt.Printf("ERROR %016X already has a PIN in %s\n", owner, opts.PinDelivery)
+			os.Exit(4)
+		}
+	}
+
+	psig, err := buildPin(gen, timestamp, int(opts.PinLength), delivery, owner)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+	psigdata, err := gen.Serialize(psig)
+	if err != nil {
+		fmt.Printf("ERROR generating sigdata: %s\n", err)
+		os.Exit(1)
+	}
+
+	// The PIN is delivered before the device gets its hash, a device must
+	// never have a PIN nobody knows
+	if err := writePinDelivery(opts.PinDelivery, key, delivery); err != nil {
+		fmt.Printf("ERROR writing %s: %s\n", opts.PinDelivery, err)
+		os.Exit(1)
+	}
+	if err := appendFile(opts.Output, psigdata); err != nil {
+		fmt.Printf("ERROR appending setup PIN to file: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("PIN of %016X in %s\n", owner, opts.PinDelivery)
+	outFormatMain(opts)
+	os.Exit(0)
+}
+
+// buildPin draws the PIN of a device, adds it to the delivery and returns the
+// record for the device.
+func buildPin(gen *UserSignature, t time.Time, length int, delivery *PinDelivery, owner eui64) (*PinSignature, error) {
+	pin, err := randomPin(length)
+	if err != nil {
+		return nil, err
+	}
+	b, err := randomBytes(len(tsalt{}))
+	if err != nil {
+		return nil, err
+	}
+	var salt tsalt
+	copy(salt[:], b)
+	psig, err := gen.ConstructPinSignature(t, pin, salt)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Pins = append(delivery.Pins, DevicePin{Eui64: fmt.Sprintf("%016X", owner), Pin: pin, Created: t})
+	return psig, nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "pins",
+		short:   "Show the setup PINs of a delivery file",
+		long:    "Decrypt a PIN delivery file and list the setup PIN of every EUI as CSV, for the fulfillment team.",
+		options: func() interface{} { return new(PinsOptions) },
+		run:     func(c *commandRun) { pinsMain(c.options.(*PinsOptions)) },
+	})
+}
+
+func pinsMain(opts *PinsOptions) {
+	key, err := loadSigdirKey(opts.Key)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(opts.File); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(3)
+	}
+	d, err := loadPinDelivery(opts.File, key)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("eui64,pin,created\n")
+	for _, p := range d.Pins {
+		fmt.Printf("%s,%s,%s\n", p.Eui64, p.Pin, p.Created.UTC().Format(time.RFC3339))
+	}
+}
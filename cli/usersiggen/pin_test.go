@@ -0,0 +1,79 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "bytes"
+import "strings"
+import "testing"
+import "path/filepath"
+
+func TestRandomPin(t *testing.T) {
+	digits := make(map[rune]int)
+	for i := 0; i < 200; i++ {
+		pin, err := randomPin(8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pin) != 8 {
+			t.Fatalf("PIN %s is not 8 digits", pin)
+		}
+		for _, c := range pin {
+			digits[c]++
+		}
+	}
+	if len(digits) != 10 {
+		t.Errorf("PIN digits are %v", digits)
+	}
+}
+
+// The delivery file has the PIN sealed, the device only its salted hash.
+func TestPinDelivery(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pins.sealed")
+	key := bytes.Repeat([]byte{0x42}, 32)
+	delivery, err := loadPinDelivery(file, key)
+	if err != nil || len(delivery.Pins) != 0 {
+		t.Fatalf("new delivery is %+v: %v", delivery, err)
+	}
+
+	var gen UserSignature
+	psig, err := buildPin(&gen, g_test_time, 6, delivery, 0x70B3D5D72F000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePinDelivery(file, key, delivery); err != nil {
+		t.Fatal(err)
+	}
+	pin := delivery.Pins[0].Pin
+	if !psig.Check(pin) || psig.Check("000000"+pin) {
+		t.Errorf("the record does not check the PIN %s", pin)
+	}
+	data, err := gen.Serialize(psig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(pin)) {
+		t.Errorf("the PIN is in the record")
+	}
+
+	sealed, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte(pin)) || !isSealed(sealed) {
+		t.Errorf("the PIN is in the delivery file")
+	}
+	delivery, err = loadPinDelivery(file, key)
+	if err != nil || len(delivery.Pins) != 1 || delivery.Pins[0].Pin != pin || delivery.Pins[0].Eui64 != "70B3D5D72F000001" {
+		t.Errorf("delivery is %+v: %v", delivery, err)
+	}
+	if _, err := loadPinDelivery(file, bytes.Repeat([]byte{0x43}, 32)); err == nil {
+		t.Errorf("another key opens the delivery")
+	}
+	if _, err := loadPinDelivery(writeTestFile(t, dir, "pins.json", []byte("{}")), key); err == nil || !strings.Contains(err.Error(), "not a sealed") {
+		t.Errorf("plain delivery file: %v", err)
+	}
+}
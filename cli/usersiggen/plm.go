@@ -0,0 +1,283 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "strings"
+import "net/url"
+import "net/http"
+import "io/ioutil"
+import "encoding/json"
+
+import "github.com/jessevdk/go-flags"
+
+// Component names, versions and UUIDs from the PLM system by part number, so
+// what goes into the devices is what engineering has released:
+//
+//	usersiggen --part TN-00123 --plm-url 'http://plm/parts/{part}' --type board ...
+//
+// The url answers with the part as JSON, {"name": "tsb2", "version": "2.1.0",
+// "uuid": "...", "manufacturer": "..."}, a small service in front of the PLM
+// or ERP gives it this form. The parts are cached locally, lookups within
+// --plm-max-age do not ask again and an offline station only uses the cache.
+// A value also given on the command line, in the station config or the
+// profile must match the PLM.
+
+const SCHEMA_PLM_CACHE = "euisiggen/plm-cache/v1"
+
+type Part struct {
+	Number       string    `json:"part"`
+	Name         string    `json:"name"`
+	Version      string    `json:"version"`
+	Uuid         string    `json:"uuid"`
+	Manufacturer string    `json:"manufacturer,omitempty"`
+	Resolved     time.Time `json:"resolved"`
+}
+
+// PartResolver looks up parts by part number.
+type PartResolver interface {
+	Resolve(number string) (*Part, error)
+}
+
+type PlmConfig struct {
+	Url    string `json:"url"`     // {part} is substituted
+	Cache  string `json:"cache"`   // Defaults to plm-cache.json
+	MaxAge string `json:"max_age"` // Duration a cached part is used for, e.g. 24h
+}
+
+type PartCache struct {
+	Schema string           `json:"schema"`
+	Parts  map[string]*Part `json:"parts"`
+}
+
+type httpResolver struct {
+	url string
+}
+
+type cachedResolver struct {
+	file   string
+	maxAge time.Duration
+	next   PartResolver // nil when there is only the cache
+}
+
+// g_parts resolves --part and the parts of profile components, nil without
+// a PLM.
+var g_parts PartResolver
+
+func (self *httpResolver) Resolve(number string) (*Part, error) {
+	u := strings.Replace(self.url, "{part}", url.PathEscape(number), -1)
+	if err := offlineUrl("PLM lookup", u); err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("part %s is not in the PLM", number)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("PLM responded %s for part %s", resp.Status, number)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Part)
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, fmt.Errorf("part %s: %s", number, err)
+	}
+	p.Number = number
+	p.Resolved = time.Now().UTC()
+	return p, checkPart(p)
+}
+
+// checkPart refuses a part the records can not be made of.
+func checkPart(p *Part) error {
+	if len(p.Name) == 0 || len(p.Name) > 16 {
+		return fmt.Errorf("part %s: name %q must be 1 to 16 characters", p.Number, p.Name)
+	}
+	var v BoardVersion
+	if err := v.UnmarshalFlag(p.Version); err != nil {
+		return fmt.Errorf("part %s: version %s: %s", p.Number, p.Version, err)
+	}
+	if _, err := parseUuid(p.Uuid); err != nil {
+		return fmt.Errorf("part %s: uuid %s: %s", p.Number, p.Uuid, err)
+	}
+	if len(p.Manufacturer) > 0 {
+		if _, err := parseUuid(p.Manufacturer); err != nil {
+			return fmt.Errorf("part %s: manufacturer %s: %s", p.Number, p.Manufacturer, err)
+		}
+	}
+	return nil
+}
+
+func readPartCache(file string) (*PartCache, error) {
+	cache := &PartCache{Schema: SCHEMA_PLM_CACHE, Parts: make(map[string]*Part)}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cache); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if cache.Schema != SCHEMA_PLM_CACHE {
+		return nil, fmt.Errorf("%s is not a PLM cache", file)
+	}
+	if cache.Parts == nil {
+		cache.Parts = make(map[string]*Part)
+	}
+	return cache, nil
+}
+
+func (self *cachedResolver) Resolve(number string) (*Part, error) {
+	cache, err := readPartCache(self.file)
+	if err != nil {
+		return nil, err
+	}
+	cached, ok := cache.Parts[number]
+	if ok && (g_offline || self.next == nil || time.Since(cached.Resolved) <= self.maxAge) {
+		return cached, checkPart(cached)
+	}
+	if self.next == nil {
+		return nil, fmt.Errorf("part %s is not in the PLM cache %s and there is no PLM url", number, self.file)
+	}
+	if g_offline {
+		return nil, fmt.Errorf("part %s is not in the PLM cache %s, the PLM can not be asked in --offline mode", number, self.file)
+	}
+
+	p, err := self.next.Resolve(number)
+	if err != nil {
+		return nil, err
+	}
+	cache.Parts[number] = p
+	if err := writeJsonFile(self.file, cache); err != nil {
+		fmt.Printf("WARNING: failed to cache part %s in %s: %s\n", number, self.file, err)
+	}
+	return p, nil
+}
+
+func setPartResolver(cfg PlmConfig) error {
+	if len(cfg.Url) == 0 && len(cfg.Cache) == 0 {
+		return nil
+	}
+	r := &cachedResolver{file: cfg.Cache, maxAge: 24 * time.Hour}
+	if len(r.file) == 0 {
+		r.file = "plm-cache.json"
+	}
+	if len(cfg.MaxAge) > 0 {
+		d, err := time.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			return fmt.Errorf("PLM max age %s: %s", cfg.MaxAge, err)
+		}
+		r.maxAge = d
+	}
+	if len(cfg.Url) > 0 {
+		r.next = &httpResolver{cfg.Url}
+	}
+	g_parts = r
+	return nil
+}
+
+func resolvePart(number string) (*Part, error) {
+	if g_parts == nil {
+		return nil, fmt.Errorf("part %s can not be looked up without a PLM url or cache", number)
+	}
+	return g_parts.Resolve(number)
+}
+
+// equalUuid tells if two UUIDs in any notation are the same.
+func equalUuid(a string, b string) bool {
+	ua, erra := parseUuid(a)
+	ub, errb := parseUuid(b)
+	return erra == nil && errb == nil && ua == ub
+}
+
+// partValue compares a value given elsewhere with the one of the part, an
+// empty value takes the one of the part.
+func partValue(p *Part, what string, given string, value string, same func(string, string) bool) (string, error) {
+	if len(given) == 0 {
+		return value, nil
+	}
+	if len(value) > 0 && !same(given, value) {
+		return "", fmt.Errorf("%s %s, part %s in the PLM has %s", what, given, p.Number, value)
+	}
+	return given, nil
+}
+
+func equalVersion(a string, b string) bool {
+	var va, vb BoardVersion
+	return va.UnmarshalFlag(a) == nil && vb.UnmarshalFlag(b) == nil && va == vb
+}
+
+func equalString(a string, b string) bool {
+	return a == b
+}
+
+// applyPartOptions sets --name, --version, --uuid and --manufacturer from
+// the part, the ones already set must match it.
+func applyPartOptions(parser *flags.Parser, number string) error {
+	p, err := resolvePart(number)
+	if err != nil {
+		return err
+	}
+	values := []struct {
+		option string
+		value  string
+		same   func(string, string) bool
+	}{
+		{"name", p.Name, equalString},
+		{"version", p.Version, equalVersion},
+		{"uuid", p.Uuid, equalUuid},
+		{"manufacturer", p.Manufacturer, equalUuid},
+	}
+	for _, v := range values {
+		o := parser.FindOptionByLongName(v.option)
+		given := ""
+		if o.IsSet() && !o.IsSetDefault() {
+			given = fmt.Sprint(o.Value())
+		}
+		value, err := partValue(p, "--"+v.option, given, v.value, v.same)
+		if err != nil {
+			return err
+		}
+		if len(value) > 0 {
+			if err := o.Set(&value); err != nil {
+				return fmt.Errorf("--%s %s: %s", v.option, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// partComponent fills the component of a profile from its part, the values
+// the profile gives must match it.
+func partComponent(c ProfileComponent) (ProfileComponent, error) {
+	if len(c.Part) == 0 {
+		return c, nil
+	}
+	p, err := resolvePart(c.Part)
+	if err != nil {
+		return c, err
+	}
+	if c.Name, err = partValue(p, "name", c.Name, p.Name, equalString); err != nil {
+		return c, err
+	}
+	if c.Version, err = partValue(p, "version", c.Version, p.Version, equalVersion); err != nil {
+		return c, err
+	}
+	if c.UUID, err = partValue(p, "uuid", c.UUID, p.Uuid, equalUuid); err != nil {
+		return c, err
+	}
+	if c.Manufacturer, err = partValue(p, "manufacturer", c.Manufacturer, p.Manufacturer, equalUuid); err != nil {
+		return c, err
+	}
+	return c, nil
+}
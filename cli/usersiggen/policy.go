@@ -0,0 +1,322 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bytes"
+import "errors"
+import "sort"
+import "regexp"
+import "strings"
+import "io/ioutil"
+import "encoding/hex"
+import "encoding/json"
+import "crypto/ed25519"
+
+import "go.thinnect.net/euisig/eui"
+import "go.thinnect.net/euisig/signature"
+
+// Organization-wide generation policy. Quality signs a policy document with
+// its Ed25519 key, the stations load it with the public key and refuse to
+// generate anything outside of it, whatever the command line, the station
+// config or the profile asks for:
+//
+//	usersiggen policy sign --key quality.pem policy.json
+//	usersiggen --policy policy.json --policy-key quality.pub --type board ...
+//
+// An empty rule allows everything, a policy that only lists manufacturers
+// does not restrict the boards.
+
+const SCHEMA_POLICY = "euisiggen/policy/v1"
+
+type Policy struct {
+	Schema         string            `json:"schema"`
+	Organization   string            `json:"organization"`
+	Manufacturers  []string          `json:"manufacturers"`            // Manufacturer UUIDs allowed in any record
+	Boards         map[string]string `json:"boards"`                   // Board UUIDs allowed and the name each must be given
+	SerialPattern  string            `json:"serial_pattern,omitempty"` // Regular expression every board serial must match
+	AllowEmulation bool              `json:"allow_emulation"`          // Whether --emulate-version may write old formats
+	SigningKey     string            `json:"signing_key,omitempty"`    // Key id --type sign must use, 16 hex digits
+	Signature      string            `json:"signature"`
+
+	manufacturers map[string]bool
+	boards        map[string]string
+	serial        *regexp.Regexp
+}
+
+type PolicySignOptions struct {
+	Key  string `long:"key" required:"true" description:"Ed25519 private key of the policy owner, PKCS#8 PEM."`
+	Out  string `long:"out" description:"Signed policy file, the policy file itself if not given."`
+	Args struct {
+		File string `positional-arg-name:"policy"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type PolicyShowOptions struct {
+	Pubkey string `long:"pubkey" required:"true" description:"Ed25519 public key of the policy owner, PEM."`
+	Args   struct {
+		File string `positional-arg-name:"policy"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type PolicyOptions struct {
+	Sign PolicySignOptions `command:"sign" description:"Check and sign a policy document."`
+	Show PolicyShowOptions `command:"show" description:"Verify a signed policy and show its rules."`
+}
+
+// g_policy is the policy of the run, nil when there is none.
+var g_policy *Policy
+
+// signedBytes is what the signature covers, the policy without it.
+func (self *Policy) signedBytes() []byte {
+	p := *self
+	p.Signature = ""
+	b, _ := json.Marshal(p)
+	return b
+}
+
+// compile checks the rules and puts them in the form they are applied in,
+// the document itself is left as it was signed.
+func (self *Policy) compile() error {
+	if self.Schema != SCHEMA_POLICY {
+		return fmt.Errorf("unknown policy schema %q", self.Schema)
+	}
+	self.manufacturers = make(map[string]bool)
+	for _, m := range self.Manufacturers {
+		u, err := eui.ParseUuid(m)
+		if err != nil {
+			return fmt.Errorf("manufacturer %s: %s", m, err)
+		}
+		self.manufacturers[eui.UuidString(u)] = true
+	}
+	self.boards = make(map[string]string)
+	for b, name := range self.Boards {
+		u, err := eui.ParseUuid(b)
+		if err != nil {
+			return fmt.Errorf("board %s: %s", b, err)
+		}
+		if len(name) > 16 {
+			return fmt.Errorf("board %s: name %s is longer than 16 characters", b, name)
+		}
+		self.boards[eui.UuidString(u)] = name
+	}
+	if len(self.SerialPattern) > 0 {
+		re, err := regexp.Compile("^(?:" + self.SerialPattern + ")$")
+		if err != nil {
+			return fmt.Errorf("serial_pattern: %s", err)
+		}
+		self.serial = re
+	}
+	if len(self.SigningKey) > 0 {
+		if b, err := hex.DecodeString(self.SigningKey); err != nil || len(b) != len(signature.KeyId{}) {
+			return fmt.Errorf("signing_key %s is not a key id", self.SigningKey)
+		}
+	}
+	return nil
+}
+
+func readPolicy(file string) (*Policy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Policy)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return p, nil
+}
+
+// loadPolicy reads a policy and checks that the owner of the key signed it.
+func loadPolicy(file string, keyfile string) (*Policy, error) {
+	pub, err := loadEd25519Public(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	p, err := readPolicy(file)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(p.Signature)
+	if err != nil || !ed25519.Verify(pub, p.signedBytes(), sig) {
+		return nil, fmt.Errorf("%s: policy signature is not valid", file)
+	}
+	return p, nil
+}
+
+func setPolicy(file string, keyfile string) error {
+	if len(file) == 0 {
+		return nil
+	}
+	if len(keyfile) == 0 {
+		return errors.New("--policy needs the public key it is signed with, --policy-key")
+	}
+	p, err := loadPolicy(file, keyfile)
+	if err != nil {
+		return err
+	}
+	g_policy = p
+	return nil
+}
+
+// serialText is the serial number as the policy pattern sees it, the string
+// when it is one, the UUID otherwise.
+func serialText(serial tuuid) string {
+	s := serial[:]
+	if n := bytes.IndexByte(s, 0); n >= 0 {
+		if len(bytes.Trim(s[n:], "\x00")) == 0 {
+			s = s[:n]
+		}
+	}
+	for _, c := range s {
+		if c < 0x20 || c > 0x7E {
+			return eui.UuidString([16]byte(serial))
+		}
+	}
+	return string(s)
+}
+
+func (self *Policy) refuse(format string, args ...interface{}) error {
+	return fmt.Errorf("policy of %s: %s", self.Organization, fmt.Sprintf(format, args...))
+}
+
+// checkComponent refuses a board, platform or component record outside the
+// policy.
+func (self *Policy) checkComponent(sig *ComponentSignature) error {
+	if len(self.manufacturers) > 0 {
+		m := eui.UuidString([16]byte(sig.Manufacturer_uuid))
+		if !self.manufacturers[m] {
+			return self.refuse("manufacturer %s of %s is not allowed", m, sig.BoardName())
+		}
+	}
+	if sig.Signature_type != SIGNATURE_TYPE_BOARD {
+		return nil
+	}
+	if len(self.boards) > 0 {
+		b := eui.UuidString([16]byte(sig.Component_uuid))
+		name, ok := self.boards[b]
+		if !ok {
+			return self.refuse("board %s is not allowed", b)
+		}
+		if sig.BoardName() != name {
+			return self.refuse("board %s is %s, not %s", b, name, sig.BoardName())
+		}
+	}
+	if self.serial != nil {
+		if s := serialText(sig.Serial_number); !self.serial.MatchString(s) {
+			return self.refuse("serial %q does not match %s", s, self.SerialPattern)
+		}
+	}
+	return nil
+}
+
+func (self *Policy) checkEmulation(version string) error {
+	if !self.AllowEmulation {
+		return self.refuse("emulating %s is not allowed", version)
+	}
+	return nil
+}
+
+func (self *Policy) checkSigningKey(id signature.KeyId) error {
+	if len(self.SigningKey) > 0 && !strings.EqualFold(self.SigningKey, hex.EncodeToString(id[:])) {
+		return self.refuse("areas are signed with key %s, not %X", strings.ToUpper(self.SigningKey), id[:])
+	}
+	return nil
+}
+
+// policyComponent checks a record against the policy of the run.
+func policyComponent(sig *ComponentSignature) error {
+	if g_policy == nil {
+		return nil
+	}
+	return g_policy.checkComponent(sig)
+}
+
+func policySign(opts *PolicySignOptions) error {
+	key, err := loadSigningKey(opts.Key, "policy")
+	if err != nil {
+		return err
+	}
+	p, err := readPolicy(opts.Args.File)
+	if err != nil {
+		return err
+	}
+	p.Signature = hex.EncodeToString(ed25519.Sign(key, p.signedBytes()))
+
+	out := opts.Out
+	if len(out) == 0 {
+		out = opts.Args.File
+	}
+	if err := writeJsonFile(out, p); err != nil {
+		return err
+	}
+	fmt.Printf("Policy of %s signed, written to %s\n", p.Organization, out)
+	return nil
+}
+
+func policyShow(opts *PolicyShowOptions) error {
+	p, err := loadPolicy(opts.Args.File, opts.Pubkey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Policy of %s, signature OK\n", p.Organization)
+	if len(p.Manufacturers) == 0 {
+		fmt.Printf("Manufacturers:  any\n")
+	}
+	for _, m := range p.Manufacturers {
+		fmt.Printf("Manufacturer:   %s\n", m)
+	}
+	if len(p.Boards) == 0 {
+		fmt.Printf("Boards:         any\n")
+	}
+	boards := make([]string, 0, len(p.boards))
+	for b := range p.boards {
+		boards = append(boards, b)
+	}
+	sort.Strings(boards)
+	for _, b := range boards {
+		fmt.Printf("Board:          %s %s\n", b, p.boards[b])
+	}
+	if len(p.SerialPattern) > 0 {
+		fmt.Printf("Serials:        %s\n", p.SerialPattern)
+	} else {
+		fmt.Printf("Serials:        any\n")
+	}
+	fmt.Printf("Emulation:      %t\n", p.AllowEmulation)
+	if len(p.SigningKey) > 0 {
+		fmt.Printf("Signing key:    %s\n", strings.ToUpper(p.SigningKey))
+	} else {
+		fmt.Printf("Signing key:    any\n")
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "policy",
+		short:   "Generation policy of the organization",
+		long:    "Sign a policy of allowed manufacturers, boards, serials and signing settings, or verify one and show its rules.",
+		options: func() interface{} { return new(PolicyOptions) },
+		run:     func(c *commandRun) { policyMain(c.command, c.options.(*PolicyOptions)) },
+	})
+}
+
+func policyMain(command string, opts *PolicyOptions) {
+	switch command {
+	case "sign":
+		if err := policySign(&opts.Sign); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+	case "show":
+		if err := policyShow(&opts.Show); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+	}
+}
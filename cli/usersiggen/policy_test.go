@@ -0,0 +1,101 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "strings"
+import "testing"
+import "encoding/json"
+
+import "go.thinnect.net/euisig/signature"
+
+const g_test_policy = `{
+	"schema": "euisiggen/policy/v1",
+	"organization": "Thinnect",
+	"manufacturers": ["AAAAAAAA-AAAA-AAAA-AAAA-AAAAAAAAAAAA"],
+	"boards": {"01010101010101010101010101010101": "tsb2"},
+	"serial_pattern": "SN-[0-9]{4}",
+	"signing_key": "0011223344556677"
+}`
+
+func testBoard(t *testing.T, name string, board byte, manufacturer byte, serial string) *ComponentSignature {
+	t.Helper()
+	var gen UserSignature
+	var s [16]byte
+	copy(s[:], serial)
+	sig, err := gen.ConstructComponentSignature(g_test_time, name, BoardVersion{Major: 1}, testUuid(board), testUuid(manufacturer), s, 0, SIGNATURE_TYPE_BOARD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// A signed policy loads with the key of its owner only, and refuses the
+// records outside it.
+func TestPolicy(t *testing.T) {
+	dir := t.TempDir()
+	key, pub := writeTestKeys(t, dir, "quality")
+	_, other := writeTestKeys(t, dir, "other")
+	file := writeTestFile(t, dir, "policy.json", []byte(g_test_policy))
+	sign := &PolicySignOptions{Key: key}
+	sign.Args.File = file
+	captureStdout(t, func() {
+		if err := policySign(sign); err != nil {
+			t.Fatal(err)
+		}
+	})
+	p, err := loadPolicy(file, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPolicy(file, other); err == nil {
+		t.Errorf("the policy loads with another key")
+	}
+	var doc map[string]interface{}
+	data, _ := os.ReadFile(file)
+	json.Unmarshal(data, &doc)
+	doc["allow_emulation"] = true
+	data, _ = json.Marshal(doc)
+	if _, err := loadPolicy(writeTestFile(t, dir, "changed.json", data), pub); err == nil {
+		t.Errorf("a changed policy loads")
+	}
+
+	for _, c := range []struct {
+		board   *ComponentSignature
+		problem string
+	}{
+		{testBoard(t, "tsb2", 0x01, 0xAA, "SN-0001"), ""},
+		{testBoard(t, "tsb3", 0x01, 0xAA, "SN-0001"), "is tsb2, not tsb3"},
+		{testBoard(t, "tsb2", 0x02, 0xAA, "SN-0001"), "board 02020202-0202-0202-0202-020202020202 is not allowed"},
+		{testBoard(t, "tsb2", 0x01, 0xBB, "SN-0001"), "manufacturer bbbbbbbb"},
+		{testBoard(t, "tsb2", 0x01, 0xAA, "SN-01"), `serial "SN-01" does not match`},
+		{testBoard(t, "tsb2", 0x01, 0xAA, "\x01\x02"), `serial "01020000-0000-0000-0000-000000000000"`},
+	} {
+		err := p.checkComponent(c.board)
+		if (err == nil) != (len(c.problem) == 0) || (err != nil && !strings.Contains(err.Error(), c.problem)) {
+			t.Errorf("%s: %v, want %s", c.board.BoardName(), err, c.problem)
+		}
+	}
+	if err := p.checkEmulation("1.0"); err == nil {
+		t.Errorf("emulation is allowed")
+	}
+	if p.checkSigningKey(signature.KeyId{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77}) != nil || p.checkSigningKey(signature.KeyId{}) == nil {
+		t.Errorf("the signing key is not checked")
+	}
+
+	if err := setPolicy(file, ""); err == nil {
+		t.Errorf("a policy is set without its key")
+	}
+	for bad, problem := range map[string]string{
+		`{"schema": "euisiggen/policy/v2"}`:                                  "unknown policy schema",
+		`{"schema": "euisiggen/policy/v1", "boards": {"01010101": "tsb2"}}`:  "board 01010101",
+		`{"schema": "euisiggen/policy/v1", "serial_pattern": "SN-("}`:        "serial_pattern",
+		`{"schema": "euisiggen/policy/v1", "signing_key": "00112233445566"}`: "not a key id",
+		`{"schema": "euisiggen/policy/v1", "manufacturers": ["Thinnect"]}`:   "manufacturer Thinnect",
+	} {
+		if _, err := readPolicy(writeTestFile(t, dir, "bad.json", []byte(bad))); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%s: %v, want %s", bad, err, problem)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "errors"
+import "strings"
+import "strconv"
+import "bufio"
+import "sort"
+import "time"
+import "encoding/json"
+
+const POOL_FREE = "free"
+const POOL_RESERVED = "reserved"
+const POOL_USED = "used"
+const POOL_RETIRED = "retired"
+
+// A decommissioned device keeps its mark, the time it was retired follows
+// the tag column: eui,board,version,unixts,uuid,manufacturer,tag,retired=<unixts>
+const POOL_RETIRED_PREFIX = "retired="
+
+// PoolEntry is one line of an euifile. Used entries carry what markEui
+// recorded about the board the EUI went to.
+type PoolEntry struct {
+	Eui          eui64
+	Status       string
+	Board        string
+	Version      string
+	Marked       time.Time
+	BoardUuid    string
+	Manufacturer string
+	Tag          string
+	Retired      time.Time
+	Mark         string // Everything recorded after the EUI
+	Sigfile      string // Signature file, only a registry records it
+}
+
+// readPool returns the entries of the pool, from the registry when there is
+// one.
+func readPool(infile string) ([]PoolEntry, error) {
+	if g_eui_registry != nil {
+		return g_eui_registry.Entries()
+	}
+	return readPoolFile(infile)
+}
+
+// readPoolFile parses an euifile into its entries, comments are skipped.
+func readPoolFile(infile string) ([]PoolEntry, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	entries := make([]PoolEntry, 0)
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) == 0 || strings.HasPrefix(t, "#") {
+			continue
+		}
+		entry, err := parsePoolLine(t)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// parsePoolLine parses an entry line of an euifile, the EUI and its mark.
+func parsePoolLine(t string) (PoolEntry, error) {
+	splits := strings.Split(t, ",")
+	eui, err := parseEui(splits[0])
+	if err != nil {
+		return PoolEntry{}, err
+	}
+
+	entry := PoolEntry{Eui: eui, Status: POOL_FREE}
+	if len(splits) == 2 && splits[1] == "RESERVED" {
+		entry.Status = POOL_RESERVED
+	} else if len(splits) > 2 || (len(splits) == 2 && len(splits[1]) > 0) {
+		entry.Status = POOL_USED
+		entry.Mark = strings.Join(splits[1:], ",")
+		entry.Board = splits[1]
+		if len(splits) > 2 {
+			entry.Version = splits[2]
+		}
+		if len(splits) > 3 {
+			if ts, err := strconv.ParseInt(splits[3], 10, 64); err == nil {
+				entry.Marked = time.Unix(ts, 0).UTC()
+			}
+		}
+		if len(splits) > 4 {
+			entry.BoardUuid = splits[4]
+		}
+		if len(splits) > 5 {
+			entry.Manufacturer = splits[5]
+		}
+		if len(splits) > 6 {
+			entry.Tag = splits[6]
+		}
+		if len(splits) > 7 && strings.HasPrefix(splits[7], POOL_RETIRED_PREFIX) {
+			entry.Status = POOL_RETIRED
+			if ts, err := strconv.ParseInt(strings.TrimPrefix(splits[7], POOL_RETIRED_PREFIX), 10, 64); err == nil {
+				entry.Retired = time.Unix(ts, 0).UTC()
+			}
+		}
+	}
+	return entry, nil
+}
+
+// A pool can be partitioned by build configuration with tag declarations in
+// the euifile header:
+//
+//   # tag mass-prod first=0011223344560000 last=001122334456FFFF
+//   # tag pilot quota=200
+//
+// EUIs in the range of a tag only go to builds with that tag, a tag with a
+// range only takes EUIs from its range. Everything else, untagged builds and
+// tags without a range, shares the EUIs outside all ranges. The quota caps
+// the EUIs marked with the tag, used EUIs record the tag in the last column.
+
+type PoolTag struct {
+	Name   string `json:"tag"`
+	First  eui64  `json:"first,omitempty"`
+	Last   eui64  `json:"last,omitempty"`
+	Ranged bool   `json:"ranged"`
+	Quota  int    `json:"quota"` // 0 for no quota
+}
+
+type PoolTags map[string]*PoolTag
+
+// readPoolTags parses the tag declarations of an euifile.
+func readPoolTags(infile string) (PoolTags, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	tags := make(PoolTags)
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(t, "#") {
+			continue
+		}
+		fields := strings.Fields(t[1:])
+		if len(fields) < 2 || fields[0] != "tag" {
+			continue
+		}
+
+		tag := &PoolTag{Name: fields[1]}
+		if _, ok := tags[tag.Name]; ok {
+			return nil, fmt.Errorf("tag %s is declared twice in %s", tag.Name, infile)
+		}
+		var first, last bool
+		for _, f := range fields[2:] {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("tag %s: %s is not key=value", tag.Name, f)
+			}
+			switch kv[0] {
+			case "first":
+				tag.First, err = parseEui(kv[1])
+				first = true
+			case "last":
+				tag.Last, err = parseEui(kv[1])
+				last = true
+			case "quota":
+				tag.Quota, err = strconv.Atoi(kv[1])
+				if err == nil && tag.Quota < 0 {
+					err = errors.New("quota is negative")
+				}
+			default:
+				err = fmt.Errorf("unknown key %s", kv[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tag %s: %s", tag.Name, err)
+			}
+		}
+		if first != last {
+			return nil, fmt.Errorf("tag %s: a range needs both first and last", tag.Name)
+		}
+		if first && tag.First > tag.Last {
+			return nil, fmt.Errorf("tag %s: range %016X-%016X is inverted", tag.Name, tag.First, tag.Last)
+		}
+		tag.Ranged = first
+		for _, other := range tags {
+			if tag.Ranged && other.Ranged && tag.First <= other.Last && other.First <= tag.Last {
+				return nil, fmt.Errorf("tag %s overlaps tag %s", tag.Name, other.Name)
+			}
+		}
+		tags[tag.Name] = tag
+	}
+	return tags, scanner.Err()
+}
+
+// owner returns the tag whose range holds the EUI, empty for the shared part.
+func (tags PoolTags) owner(e eui64) string {
+	for _, t := range tags {
+		if t.Ranged && t.First <= e && e <= t.Last {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// eligible tells if a free EUI may go to a build with the tag.
+func (tags PoolTags) eligible(e eui64, tag string) bool {
+	if owner := tags.owner(e); len(owner) > 0 {
+		return owner == tag
+	}
+	t, ok := tags[tag]
+	return !ok || !t.Ranged
+}
+
+// check refuses tags the pool does not declare, so a typo can not bypass the
+// partitioning.
+func (tags PoolTags) check(tag string, infile string) error {
+	if _, ok := tags[tag]; len(tag) > 0 && !ok {
+		return fmt.Errorf("tag %s is not declared in %s", tag, infile)
+	}
+	return nil
+}
+
+// tagUsed counts the EUIs marked with the tag, retired ones stay counted
+// against the quota.
+func tagUsed(entries []PoolEntry, tag string) int {
+	used := 0
+	for _, e := range entries {
+		if (e.Status == POOL_USED || e.Status == POOL_RETIRED) && e.Tag == tag {
+			used++
+		}
+	}
+	return used
+}
+
+// TagStats is the state of one partition of a pool, the untagged builds are
+// reported with an empty tag.
+type TagStats struct {
+	PoolTag
+	Used      int `json:"used"`
+	Available int `json:"available"` // Free EUIs the tag may still take, within the quota
+}
+
+func poolTagStats(entries []PoolEntry, tags PoolTags) []TagStats {
+	names := []string{""}
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := make([]TagStats, 0, len(names))
+	for _, name := range names {
+		st := TagStats{PoolTag: PoolTag{Name: name}}
+		if t, ok := tags[name]; ok {
+			st.PoolTag = *t
+		}
+		st.Used = tagUsed(entries, name)
+		for _, e := range entries {
+			if e.Status == POOL_FREE && tags.eligible(e.Eui, name) {
+				st.Available++
+			}
+		}
+		if st.Quota > 0 && st.Quota-st.Used < st.Available {
+			st.Available = st.Quota - st.Used
+			if st.Available < 0 {
+				st.Available = 0
+			}
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+func tagLabel(tag string) string {
+	if len(tag) == 0 {
+		return "(untagged)"
+	}
+	return tag
+}
+
+type PoolStatsOptions struct {
+	Json bool `long:"json" description:"Output the statistics as JSON."`
+	Args struct {
+		Euifiles []string `positional-arg-name:"eui.txt"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type PoolStats struct {
+	Pool     string     `json:"pool"`
+	Free     int        `json:"free"`
+	Reserved int        `json:"reserved"`
+	Used     int        `json:"used"`
+	Retired  int        `json:"retired"`
+	Tags     []TagStats `json:"tags"`
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "pool-stats",
+		short:   "EUI pool usage per tag",
+		long:    "Show the free, reserved and used EUIs of the pools and the usage and quota of every pool partition.",
+		options: func() interface{} { return new(PoolStatsOptions) },
+		run:     func(c *commandRun) { poolStatsMain(c.options.(*PoolStatsOptions)) },
+	})
+}
+
+func poolStatsMain(opts *PoolStatsOptions) {
+	pools := make([]PoolStats, 0)
+	for _, infile := range opts.Args.Euifiles {
+		entries, err := readPool(infile)
+		if err != nil {
+			fmt.Printf("ERROR reading %s: %s\n", infile, err)
+			os.Exit(1)
+		}
+		tags, err := readPoolTags(infile)
+		if err != nil {
+			fmt.Printf("ERROR reading %s: %s\n", infile, err)
+			os.Exit(1)
+		}
+
+		st := PoolStats{Pool: infile, Tags: poolTagStats(entries, tags)}
+		for _, e := range entries {
+			switch e.Status {
+			case POOL_FREE:
+				st.Free++
+			case POOL_RESERVED:
+				st.Reserved++
+			case POOL_USED:
+				st.Used++
+			case POOL_RETIRED:
+				st.Retired++
+			}
+		}
+		pools = append(pools, st)
+	}
+
+	if opts.Json {
+		j, _ := json.MarshalIndent(map[string]interface{}{
+			"schema":    SCHEMA_POOL_STATS,
+			"generator": generatorVersion(),
+			"pools":     pools,
+		}, "", "	")
+		fmt.Println(string(j))
+		return
+	}
+
+	for _, st := range pools {
+		fmt.Printf("%s: %d free, %d reserved, %d used, %d retired\n", st.Pool, st.Free, st.Reserved, st.Used, st.Retired)
+		for _, t := range st.Tags {
+			partition := "shared"
+			if t.Ranged {
+				partition = fmt.Sprintf("%016X-%016X", t.First, t.Last)
+			}
+			quota := "-"
+			if t.Quota > 0 {
+				quota = strconv.Itoa(t.Quota)
+			}
+			fmt.Printf("  tag %-12s %-33s quota %6s %8d used %8d available\n",
+				tagLabel(t.Name), partition, quota, t.Used, t.Available)
+		}
+	}
+}
+
+// PoolRange is a range declared in the euifile header by euigen,
+// "# EUI-64 range 0011223344550000 - 00112233445500FF, <time>". Pools put
+// together from several blocks have several.
+type PoolRange struct {
+	First eui64
+	Last  eui64
+}
+
+func readPoolRanges(infile string) ([]PoolRange, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	ranges := make([]PoolRange, 0)
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(t, "# EUI-64 range ") {
+			continue
+		}
+		r, err := parseRangeHeader(infile, t)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, scanner.Err()
+}
+
+// parseRangeHeader parses a "# EUI-64 range <first> - <last>, <time>" line.
+func parseRangeHeader(infile string, t string) (PoolRange, error) {
+	bounds := strings.Split(strings.Split(strings.TrimPrefix(t, "# EUI-64 range "), ",")[0], " - ")
+	if len(bounds) != 2 {
+		return PoolRange{}, fmt.Errorf("malformed range header %q in %s", t, infile)
+	}
+	first, err := parseEui(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return PoolRange{}, fmt.Errorf("range header in %s: %s", infile, err)
+	}
+	last, err := parseEui(strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return PoolRange{}, fmt.Errorf("range header in %s: %s", infile, err)
+	}
+	return PoolRange{First: first, Last: last}, nil
+}
+
+// readPoolBounds returns the ranges the EUIs of a pool must be in, the ones
+// the header declares and the imported customer blocks, in one pass over the
+// file. A pool without a range header is not checked, it has no bounds.
+func readPoolBounds(infile string) ([]PoolRange, error) {
+	in, err := os.Open(infile)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	ranges := make([]PoolRange, 0)
+	blocks := make([]PoolRange, 0)
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(t, "# EUI-64 range ") {
+			r, err := parseRangeHeader(infile, t)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		} else if fields := strings.Fields(strings.TrimPrefix(t, "#")); strings.HasPrefix(t, "#") && len(fields) >= 2 && fields[0] == "customer-block" {
+			b, err := parseCustomerBlock(infile, fields)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, b)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	return append(ranges, blocks...), nil
+}
+
+// checkPoolBounds refuses an EUI outside the bounds read from a pool, the
+// ranges the pool header declares and the imported customer blocks. A pool
+// without a range header has no bounds and is not checked.
+func checkPoolBounds(infile string, bounds []PoolRange, e eui64) error {
+	if len(bounds) == 0 {
+		return nil
+	}
+	for _, r := range bounds {
+		if r.First <= e && e <= r.Last {
+			return nil
+		}
+	}
+	declared := make([]string, 0, len(bounds))
+	for _, r := range bounds {
+		declared = append(declared, fmt.Sprintf("%016X - %016X", r.First, r.Last))
+	}
+	return fmt.Errorf("%016X is outside the range of %s (%s), refusing to mark it", e, infile, strings.Join(declared, ", "))
+}
@@ -0,0 +1,120 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "strings"
+import "testing"
+
+func TestParsePoolLine(t *testing.T) {
+	for line, want := range map[string]PoolEntry{
+		"70B3D5D72F000001":          {Eui: 0x70B3D5D72F000001, Status: POOL_FREE},
+		"70B3D5D72F000001,":         {Eui: 0x70B3D5D72F000001, Status: POOL_FREE},
+		"70B3D5D72F000001,RESERVED": {Eui: 0x70B3D5D72F000001, Status: POOL_RESERVED},
+		"70B3D5D72F000001,tsb2":     {Eui: 0x70B3D5D72F000001, Status: POOL_USED, Board: "tsb2", Mark: "tsb2"},
+		"70B3D5D72F000001,tsb2,1.2.3,1700000000,0101,aaaa,pilot": {Eui: 0x70B3D5D72F000001, Status: POOL_USED, Board: "tsb2", Version: "1.2.3",
+			Marked: g_test_time, BoardUuid: "0101", Manufacturer: "aaaa", Tag: "pilot", Mark: "tsb2,1.2.3,1700000000,0101,aaaa,pilot"},
+		"70B3D5D72F000001,tsb2,1.2.3,1700000000,0101,aaaa,,retired=1700000000": {Eui: 0x70B3D5D72F000001, Status: POOL_RETIRED, Board: "tsb2", Version: "1.2.3",
+			Marked: g_test_time, BoardUuid: "0101", Manufacturer: "aaaa", Retired: g_test_time, Mark: "tsb2,1.2.3,1700000000,0101,aaaa,,retired=1700000000"},
+	} {
+		if e, err := parsePoolLine(line); err != nil || e != want {
+			t.Errorf("%s is\n%+v\nwant\n%+v\n%v", line, e, want, err)
+		}
+	}
+	if _, err := parsePoolLine("70B3D5D72F00001G,tsb2"); err == nil {
+		t.Errorf("a line with a broken EUI is parsed")
+	}
+}
+
+const testTaggedPool = `# EUI-64 range 70B3D5D72F000000 - 70B3D5D72F0000FF, 2026-01-01
+# tag mass-prod first=70B3D5D72F000000 last=70B3D5D72F00000F
+# tag pilot quota=2
+70B3D5D72F000001,tsb2,1.2.3,1700000000,0101,aaaa,mass-prod
+70B3D5D72F000002
+70B3D5D72F000003
+70B3D5D72F000010,tsb2,1.2.3,1700000000,0101,aaaa,pilot
+70B3D5D72F000011
+70B3D5D72F000012
+70B3D5D72F000013
+`
+
+func TestPoolTags(t *testing.T) {
+	pool := writeTestFile(t, t.TempDir(), "euis.txt", []byte(testTaggedPool))
+	tags, err := readPoolTags(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.owner(0x70B3D5D72F000002) != "mass-prod" || tags.owner(0x70B3D5D72F000011) != "" {
+		t.Errorf("owners are wrong: %+v", tags)
+	}
+	if tags.eligible(0x70B3D5D72F000002, "pilot") || !tags.eligible(0x70B3D5D72F000011, "pilot") || tags.eligible(0x70B3D5D72F000011, "mass-prod") {
+		t.Errorf("a ranged tag shares its EUIs or takes shared ones")
+	}
+	if tags.check("pilto", pool) == nil || tags.check("", pool) != nil {
+		t.Errorf("undeclared tags are not refused")
+	}
+
+	entries, err := readPool(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Untagged, mass-prod and pilot, the quota of pilot leaves it one EUI
+	want := []TagStats{
+		{PoolTag: PoolTag{}, Used: 0, Available: 3},
+		{PoolTag: *tags["mass-prod"], Used: 1, Available: 2},
+		{PoolTag: *tags["pilot"], Used: 1, Available: 1},
+	}
+	stats := poolTagStats(entries, tags)
+	if len(stats) != len(want) {
+		t.Fatalf("tag statistics are %+v", stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("tag statistics %d are %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+}
+
+func TestPoolTagErrors(t *testing.T) {
+	dir := t.TempDir()
+	for header, problem := range map[string]string{
+		"# tag pilot\n# tag pilot\n":                                 "declared twice",
+		"# tag pilot first=70B3D5D72F000000\n":                       "needs both first and last",
+		"# tag pilot first=70B3D5D72F00000F last=70B3D5D72F000000\n": "inverted",
+		"# tag pilot quota=-1\n":                                     "quota is negative",
+		"# tag pilot size=10\n":                                      "unknown key size",
+		"# tag a first=70B3D5D72F000000 last=70B3D5D72F00000F\n# tag b first=70B3D5D72F00000F last=70B3D5D72F00001F\n": "tag b overlaps tag a",
+	} {
+		pool := writeTestFile(t, dir, "euis.txt", []byte(header+"70B3D5D72F000001\n"))
+		if _, err := readPoolTags(pool); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%q: %v, want %s", header, err, problem)
+		}
+	}
+}
+
+func TestPoolBounds(t *testing.T) {
+	dir := t.TempDir()
+	pool := writeTestFile(t, dir, "euis.txt", []byte(testTaggedPool))
+	bounds, err := readPoolBounds(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bounds) != 1 || bounds[0] != (PoolRange{0x70B3D5D72F000000, 0x70B3D5D72F0000FF}) {
+		t.Errorf("bounds are %+v", bounds)
+	}
+	if err := checkPoolBounds(pool, bounds, 0x70B3D5D72F0000FF); err != nil {
+		t.Error(err)
+	}
+	if err := checkPoolBounds(pool, bounds, 0x70B3D5D72F000100); err == nil || !strings.Contains(err.Error(), "outside the range") {
+		t.Errorf("EUI outside the range: %v", err)
+	}
+
+	unbounded := writeTestFile(t, dir, "plain.txt", []byte("70B3D5D72F000001\n"))
+	if bounds, err := readPoolBounds(unbounded); err != nil || bounds != nil {
+		t.Errorf("bounds of a pool without a range header are %+v, %v", bounds, err)
+	}
+	broken := writeTestFile(t, dir, "broken.txt", []byte("# EUI-64 range 70B3D5D72F000000, 2026-01-01\n"))
+	if _, err := readPoolRanges(broken); err == nil {
+		t.Errorf("a malformed range header is read")
+	}
+}
@@ -0,0 +1,70 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "sync"
+import "errors"
+import "path/filepath"
+
+// Advisory locking of the euifile, for operators that run usersiggen against
+// a shared pool at the same time. The pool is replaced by a rename on every
+// write, so the lock is on a file of its own next to it, eui.txt.lock. An
+// allocation takes the lock and holds it until the marks are written or the
+// process exits, a second run waits for it before reading the pool. A pool in
+// an EUI registry is not locked, the registry claims the EUIs.
+
+var errPoolLocked = errors.New("pool is locked")
+
+var g_pool_locks = make(map[string]*os.File)
+var g_pool_locks_mutex sync.Mutex
+
+// lockPool takes the lock of the pool unless this process holds it already,
+// it returns whether it was taken now.
+func lockPool(infile string) (bool, error) {
+	path, err := filepath.Abs(infile)
+	if err != nil {
+		return false, err
+	}
+
+	g_pool_locks_mutex.Lock()
+	defer g_pool_locks_mutex.Unlock()
+
+	if _, ok := g_pool_locks[path]; ok {
+		return false, nil
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return false, err
+	}
+	err = lockFile(f, false)
+	if err == errPoolLocked {
+		fmt.Fprintf(os.Stderr, "Waiting for %s, another run is allocating from it\n", infile)
+		err = lockFile(f, true)
+	}
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	g_pool_locks[path] = f
+	return true, nil
+}
+
+// unlockPool releases the lock of the pool, if this process holds it.
+func unlockPool(infile string) {
+	path, err := filepath.Abs(infile)
+	if err != nil {
+		return
+	}
+
+	g_pool_locks_mutex.Lock()
+	defer g_pool_locks_mutex.Unlock()
+
+	if f, ok := g_pool_locks[path]; ok {
+		unlockFile(f)
+		f.Close()
+		delete(g_pool_locks, path)
+	}
+}
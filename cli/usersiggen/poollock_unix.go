@@ -0,0 +1,31 @@
+// Author  Raido Pahtma
+// License MIT
+
+//go:build unix
+
+package usersiggen
+
+import "os"
+import "syscall"
+
+func lockFile(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.EWOULDBLOCK {
+			return errPoolLocked
+		} else if err != nil {
+			return os.NewSyscallError("flock", err)
+		}
+		return nil
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
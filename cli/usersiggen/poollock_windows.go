@@ -0,0 +1,28 @@
+// Author  Raido Pahtma
+// License MIT
+
+//go:build windows
+
+package usersiggen
+
+import "os"
+
+import "golang.org/x/sys/windows"
+
+func lockFile(f *os.File, wait bool) error {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, new(windows.Overlapped))
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errPoolLocked
+	} else if err != nil {
+		return os.NewSyscallError("LockFileEx", err)
+	}
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, new(windows.Overlapped))
+}
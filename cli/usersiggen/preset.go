@@ -0,0 +1,98 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "sort"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+
+// A preset is a device manifest kept by name in the preset directory, so a
+// production recipe is
+//
+//	usersiggen --preset sm3-rev2 --euifile eui.txt --serial SN0001
+//
+// instead of a script full of names, versions and UUIDs. The preset describes
+// the board, the platform and the components and the run writes them like
+// --manifest does, the command line only gives what changes per device.
+
+type PresetsOptions struct {
+	PresetDir string `long:"preset-dir" default:"presets" description:"Directory containing <preset>.json device manifests."`
+}
+
+// presetFile returns the manifest of a preset, a name with the .json
+// extension is taken as a path.
+func presetFile(name string, dir string) (string, error) {
+	if strings.HasSuffix(name, ".json") {
+		return name, nil
+	}
+	if len(name) == 0 || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid preset name \"%s\"", name)
+	}
+	path := filepath.Join(dir, name+".json")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", fmt.Errorf("no preset %s in %s, see 'presets --preset-dir %s'", name, dir, dir)
+	}
+	return path, nil
+}
+
+// presetSummary is the one line description of a preset.
+func presetSummary(m *DeviceManifest) string {
+	s := fmt.Sprintf("%s %s", m.Board.Name, m.Board.Version)
+	if m.Platform != nil {
+		s += fmt.Sprintf(", platform %s %s", m.Platform.Name, m.Platform.Version)
+	}
+	if len(m.Components) > 0 {
+		names := make([]string, 0, len(m.Components))
+		for _, c := range m.Components {
+			names = append(names, c.Name)
+		}
+		s += fmt.Sprintf(", components %s", strings.Join(names, " "))
+	}
+	return s
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "presets",
+		short:   "List the device presets",
+		long:    "List the presets of the preset directory with the board, platform and components each describes.",
+		options: func() interface{} { return new(PresetsOptions) },
+		run:     func(c *commandRun) { presetsMain(c.options.(*PresetsOptions)) },
+	})
+}
+
+func presetsMain(opts *PresetsOptions) {
+	files, err := ioutil.ReadDir(opts.PresetDir)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(3)
+	}
+	names := make([]string, 0)
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		fmt.Printf("No presets in %s\n", opts.PresetDir)
+	}
+
+	broken := 0
+	for _, name := range names {
+		m, err := loadDeviceManifest(filepath.Join(opts.PresetDir, name+".json"))
+		if err != nil {
+			fmt.Printf("%-24s ERROR %s\n", name, err)
+			broken++
+			continue
+		}
+		fmt.Printf("%-24s %s\n", name, presetSummary(m))
+	}
+	if broken > 0 {
+		os.Exit(4)
+	}
+}
@@ -0,0 +1,44 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "strings"
+import "testing"
+import "path/filepath"
+
+func TestPresets(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "sm3-rev2.json", []byte(`{
+		"board": {"name": "tsb2", "version": "1.2.3"},
+		"platform": {"name": "smenete", "version": "3.0.0"},
+		"components": [{"name": "sht31"}, {"name": "bma280", "position": 1}]
+	}`))
+
+	path, err := presetFile("sm3-rev2", dir)
+	if err != nil || path != filepath.Join(dir, "sm3-rev2.json") {
+		t.Fatalf("preset file is %s: %v", path, err)
+	}
+	m, err := loadDeviceManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := presetSummary(m); s != "tsb2 1.2.3, platform smenete 3.0.0, components sht31 bma280" {
+		t.Errorf("summary is %s", s)
+	}
+	if path, err := presetFile("recipes/sm3.json", dir); err != nil || path != "recipes/sm3.json" {
+		t.Errorf("a .json preset is %s: %v", path, err)
+	}
+	for name, problem := range map[string]string{
+		"sm3-rev3":    "no preset sm3-rev3",
+		"../sm3-rev2": "invalid preset name",
+		"":            "invalid preset name",
+	} {
+		if _, err := presetFile(name, dir); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("preset %q: %v, want %s", name, err, problem)
+		}
+	}
+	if _, err := loadDeviceManifest(writeTestFile(t, dir, "empty.json", []byte(`{"components": []}`))); err == nil || !strings.Contains(err.Error(), "has no board") {
+		t.Errorf("preset without a board: %v", err)
+	}
+}
@@ -0,0 +1,891 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "os/exec"
+import "fmt"
+import "io/ioutil"
+import "strings"
+import "strconv"
+import "errors"
+import "encoding/json"
+import "bytes"
+import "time"
+import "context"
+import "net/http"
+import "path/filepath"
+
+import "go.thinnect.net/euisig/signature"
+
+// A provisioning profile describes everything needed to take a blank board
+// through the production line. Profiles are JSON files, looked up by name
+// from the profile directory.
+type ProvisionProfile struct {
+	Euifile string `json:"euifile"`
+	Tag     string `json:"tag"` // Pool partition of the build configuration
+	Sigdir  string `json:"sigdir"`
+	Output  string `json:"out"`
+
+	Endianness string `json:"endianness"` // Byte order of the target, big or little, overrides --endianness
+	Crc        string `json:"crc"`        // Integrity check the bootloader of the target verifies, overrides --crc
+
+	Board      ProfileComponent   `json:"board"`
+	Platform   *ProfileComponent  `json:"platform"`
+	Components []ProfileComponent `json:"components"`
+	Compliance *ComplianceInfo    `json:"compliance"`
+	ExtendedId *ExtendedIdConfig  `json:"extended_id"`
+
+	Firmware struct {
+		Image  string `json:"image"`  // Firmware image the signature area is merged into
+		Offset string `json:"offset"` // Offset of the signature area in the image, decimal or 0x hex
+		Output string `json:"out"`    // Merged image, {eui} is substituted
+	} `json:"firmware"`
+
+	Stages  map[string]StageConfig `json:"stages"`
+	Plugins []PluginConfig         `json:"plugins"`
+
+	CalibrationPlugins []ExternalCalibration `json:"calibration_plugins"`
+
+	Heartbeat *HeartbeatConfig `json:"heartbeat"`
+	Plm       *PlmConfig       `json:"plm"` // Where the parts of the components are looked up, overrides --plm-url
+
+	SigdirKey   string `json:"sigdir_key"`   // Station key file, encrypts the signature files in the sigdir
+	KeepBackups *int   `json:"keep_backups"` // Timestamped backups kept of replaced files, overrides --keep-backups
+	MarkJournal string `json:"mark_journal"` // Local journal for marks of an unreachable pool, overrides --mark-journal
+}
+
+type ProfileComponent struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	UUID         string `json:"uuid"`
+	Manufacturer string `json:"manufacturer"`
+	Serial       string `json:"serial"`
+	SerialUUID   string `json:"serial_uuid"`
+	Position     uint8  `json:"position"`
+	Slot         string `json:"slot"`
+	Part         string `json:"part"` // Part number, the name, version, UUID and manufacturer come from the PLM
+
+	Calibration *CalibrationConfig `json:"calibration"` // Component data from a calibration plugin
+	Datafile    string             `json:"datafile"`    // Component data from a file
+}
+
+type StageConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Command  []string `json:"command"`  // External command, placeholders like {eui} are substituted
+	Rollback []string `json:"rollback"` // External command undoing the stage when a later stage fails
+	Url      string   `json:"url"`
+	Timeout  int      `json:"timeout"` // Seconds, 0 for no timeout
+
+	LicenseProfile string `json:"license_profile"` // License stage, board must be eligible for it
+	Eligibility    string `json:"eligibility"`     // License stage, license eligibility JSON file
+	Registry       string `json:"registry"`        // License stage, registry of issued licenses
+	Purpose        string `json:"purpose"`         // License stage, factory-test for a temporary license
+	Validity       uint   `json:"validity"`        // License stage, seconds a factory-test license is valid
+}
+
+// ProvisionDevice holds the state of the device moving through the pipeline.
+type ProvisionDevice struct {
+	Eui       eui64     `json:"eui64"`
+	Timestamp time.Time `json:"timestamp"`
+
+	WorkOrder     string `json:"work_order,omitempty"`
+	WorkOrderSize uint32 `json:"-"`
+	SerialFormat  string `json:"-"`
+	Seqdir        string `json:"-"`
+	Sequence      uint32 `json:"sequence,omitempty"`
+	Reissue       string `json:"reissue,omitempty"`
+	PanelSize     uint32 `json:"-"`
+	IfExists      string `json:"-"`
+	Panel         string `json:"panel,omitempty"`
+	PanelPosition uint32 `json:"panel_position,omitempty"`
+
+	Sigfile  string `json:"sigfile"`
+	Output   string `json:"out"`
+	Merged   string `json:"merged,omitempty"`
+	Readback string `json:"readback,omitempty"`
+	License  string `json:"license,omitempty"`
+
+	LicenseId      string `json:"license_id,omitempty"`      // Temporary license
+	LicenseExpires int64  `json:"license_expires,omitempty"` // Unix time, temporary license
+
+	Measured Measurements `json:"measured,omitempty"` // Calibration measurements the fixture reported
+
+	// Identity context of the device, built once by the build stage and
+	// handed to the later stages and plugins instead of the files
+	Identity *signature.Identity `json:"identity,omitempty"`
+
+	reused bool // The signature file existed with the same content
+	detail string
+}
+
+// Note records a short human readable result of the currently running stage.
+func (dev *ProvisionDevice) Note(format string, args ...interface{}) {
+	dev.detail = fmt.Sprintf(format, args...)
+}
+
+type StageResult struct {
+	Stage    string        `json:"stage"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Detail   string        `json:"detail,omitempty"`
+}
+
+const STAGE_OK = "ok"
+const STAGE_SKIPPED = "skipped"
+const STAGE_FAILED = "FAILED"
+const STAGE_NOT_RUN = "not run"
+const STAGE_ROLLED_BACK = "rolled back"
+const STAGE_ROLLBACK_FAILED = "ROLLBACK FAILED"
+
+type stageFunc func(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error)
+
+type builtinStageDef struct {
+	name     string
+	run      stageFunc
+	rollback stageFunc
+}
+
+// The order of the built-in stages is fixed, a stage only runs when enabled
+// in the profile or on the command line. Plugin stages are inserted after the
+// built-in stage they name.
+var g_provision_stages = []builtinStageDef{
+	{"allocate", stageAllocate, nil},
+	{"build", stageBuild, rollbackBuild},
+	{"merge", stageMerge, rollbackMerge},
+	{"flash", stageCommand, rollbackCommand},
+	{"verify", stageVerify, nil},
+	{"license", stageLicense, rollbackLicense},
+	{"mark", stageMark, rollbackMark},
+	{"label", stageCommand, rollbackCommand},
+	{"mes", stageMes, nil},
+}
+
+type ProvisionOptions struct {
+	Profile    string   `long:"profile"     required:"true" description:"Provisioning profile name or path to a profile JSON file."`
+	ProfileDir string   `long:"profile-dir" default:"profiles" description:"Directory containing <profile>.json files."`
+	Enable     []string `long:"enable"      description:"Enable a stage regardless of the profile, can be repeated."`
+	Disable    []string `long:"disable"     description:"Disable a stage regardless of the profile, can be repeated."`
+	Timestamp  int64    `long:"timestamp"   description:"Use the specified timestamp."`
+	Reissue    string   `long:"reissue"     description:"Reason for issuing a license identical to an already issued one."`
+
+	WorkOrder     string `long:"work-order"      description:"Embed a per work order sequence number in the board serial."`
+	WorkOrderSize uint32 `long:"work-order-size" description:"Number of boards in the work order."`
+	SerialFormat  string `long:"serial-format"   default:"%s-%04d" description:"Format of the work order serial, gets the work order and the sequence number."`
+	PanelSize     uint32 `long:"panel-size"      description:"Boards per panel, work order sequence numbers are grouped into panels."`
+	Seqdir        string `long:"seqdir"          description:"Where work order sequence logs are kept, defaults to <sigdir>/workorders."`
+	IfExists      string `long:"if-exists"       default:"fail" values:"fail,skip-identical" description:"When the signature file of the EUI exists, fail or use it when it has the content that would be generated."`
+}
+
+func loadProvisionProfile(name string, dir string) (*ProvisionProfile, error) {
+	path := name
+	if !strings.HasSuffix(name, ".json") {
+		path = filepath.Join(dir, name+".json")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := new(ProvisionProfile)
+	if err := json.Unmarshal(b, profile); err != nil {
+		return nil, fmt.Errorf("Failed to parse profile %s: %s", path, err)
+	}
+
+	if len(profile.Sigdir) == 0 {
+		profile.Sigdir = "sigdata"
+	}
+	if err := setSigdirKey(profile.SigdirKey); err != nil {
+		return nil, fmt.Errorf("Station key of profile %s: %s", path, err)
+	}
+	setKeepBackups(profile.KeepBackups)
+	setMarkJournal(profile.MarkJournal)
+	if profile.Plm != nil {
+		if err := setPartResolver(*profile.Plm); err != nil {
+			return nil, fmt.Errorf("Profile %s: %s", path, err)
+		}
+	}
+	if err := registerExternalCalibrations(profile.CalibrationPlugins); err != nil {
+		return nil, fmt.Errorf("Profile %s: %s", path, err)
+	}
+	if len(profile.Output) == 0 {
+		profile.Output = "sigdata.bin"
+	}
+	if profile.Stages == nil {
+		profile.Stages = make(map[string]StageConfig)
+	}
+	for name := range profile.Stages {
+		if findProvisionStage(name) == nil {
+			return nil, fmt.Errorf("Unknown stage %s in profile %s", name, path)
+		}
+	}
+	for _, plugin := range profile.Plugins {
+		if len(plugin.Name) == 0 || findProvisionStage(plugin.Name) != nil {
+			return nil, fmt.Errorf("Plugin stage name '%s' in profile %s is empty or taken by a built-in stage", plugin.Name, path)
+		}
+		if len(plugin.After) > 0 && findProvisionStage(plugin.After) == nil {
+			return nil, fmt.Errorf("Plugin %s is placed after unknown stage %s in profile %s", plugin.Name, plugin.After, path)
+		}
+		if len(plugin.Command) == 0 {
+			return nil, fmt.Errorf("Plugin %s has no command in profile %s", plugin.Name, path)
+		}
+	}
+
+	return profile, nil
+}
+
+func findProvisionStage(name string) *builtinStageDef {
+	for i := range g_provision_stages {
+		if g_provision_stages[i].name == name {
+			return &g_provision_stages[i]
+		}
+	}
+	return nil
+}
+
+func parseComponentSignature(gen *UserSignature, t time.Time, c ProfileComponent, signature_type uint8) (*ComponentSignature, error) {
+	c, err := partComponent(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var version BoardVersion
+	if err := version.UnmarshalFlag(c.Version); err != nil {
+		return nil, fmt.Errorf("%s version: %s", c.Name, err)
+	}
+
+	component_uuid, err := parseUuid(c.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("%s UUID: %s", c.Name, err)
+	}
+
+	manufacturer_uuid, err := parseUuid(c.Manufacturer)
+	if err != nil {
+		return nil, fmt.Errorf("%s manufacturer UUID: %s", c.Name, err)
+	}
+
+	var serial tuuid
+	if len(c.SerialUUID) > 0 {
+		serial, err = parseUuid(c.SerialUUID)
+		if err != nil {
+			return nil, fmt.Errorf("%s serial UUID: %s", c.Name, err)
+		}
+		if enc := gen.Emulating(); enc != nil {
+			serial = enc.SerialFromUuid(serial, component_uuid)
+		}
+	} else if len(c.Serial) > 16 {
+		return nil, fmt.Errorf("%s serial number string too long, max 16 characters", c.Name)
+	} else {
+		copy(serial[:], c.Serial)
+	}
+
+	sig, err := gen.ConstructComponentSignature(t, c.Name, version, component_uuid, manufacturer_uuid, serial, c.Position, signature_type)
+	if err != nil {
+		return nil, err
+	}
+	if err := policyComponent(sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// calibratedComponent attaches the calibration data of the device or the
+// contents of the datafile and the slot to the component record when the
+// profile asks for them.
+func calibratedComponent(gen *UserSignature, c ProfileComponent, sig *ComponentSignature, dev *ProvisionDevice) (interface{}, error) {
+	rec, err := componentData(gen, c, sig, dev)
+	if err != nil || len(c.Slot) == 0 {
+		return rec, err
+	}
+	return gen.AttachSlot(rec, signature.SlotHash(c.Slot))
+}
+
+// componentData attaches the calibration data of the device or the contents
+// of the datafile to the component record.
+func componentData(gen *UserSignature, c ProfileComponent, sig *ComponentSignature, dev *ProvisionDevice) (interface{}, error) {
+	if c.Calibration != nil && len(c.Datafile) > 0 {
+		return nil, fmt.Errorf("%s has both calibration and a datafile", c.Name)
+	}
+	if len(c.Datafile) > 0 {
+		return attachDatafile(gen, sig, c.Datafile)
+	}
+	if c.Calibration == nil {
+		return sig, nil
+	}
+	var data []byte
+	var err error
+	if c.Calibration.Source == CALIBRATION_SOURCE_FIXTURE {
+		data, err = fixtureCalibrationData(*c.Calibration, dev.Measured)
+	} else {
+		data, err = calibrationData(*c.Calibration, dev.Eui, c.Serial)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s calibration: %s", c.Name, err)
+	}
+	return gen.AttachData(sig, data)
+}
+
+func parseOffset(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, errors.New("Offset not specified")
+	}
+	return strconv.ParseInt(s, 0, 64)
+}
+
+func (dev *ProvisionDevice) expand(s string) string {
+	r := strings.NewReplacer(
+		"{eui}", fmt.Sprintf("%016X", dev.Eui),
+		"{sigfile}", dev.Sigfile,
+		"{sigdata}", dev.Output,
+		"{merged}", dev.Merged,
+		"{readback}", dev.Readback,
+		"{license}", dev.License,
+		"{license_id}", dev.LicenseId,
+		"{expires}", strconv.FormatInt(dev.LicenseExpires, 10),
+		"{panel}", dev.Panel,
+		"{position}", strconv.FormatUint(uint64(dev.PanelPosition), 10),
+		"{sequence}", strconv.FormatUint(uint64(dev.Sequence), 10),
+		"{timestamp}", strconv.FormatInt(dev.Timestamp.Unix(), 10))
+	return r.Replace(s)
+}
+
+func runStageCommand(ctx context.Context, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if len(cfg.Command) == 0 {
+		return "", errors.New("No command configured")
+	}
+
+	args := make([]string, len(cfg.Command))
+	for i, a := range cfg.Command {
+		args[i] = dev.expand(a)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return args[0], nil
+}
+
+func stageAllocate(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if len(p.Euifile) == 0 {
+		return "", errors.New("No euifile in profile")
+	}
+
+	eui, err := getEui(p.Euifile, p.Tag, dev.WorkOrder)
+	if err != nil {
+		return "", err
+	}
+	dev.Eui = eui
+	return fmt.Sprintf("%016X", eui), nil
+}
+
+// buildSigdata constructs and serializes all the signatures the profile
+// describes for the device into its identity context, nothing is written to
+// disk.
+func buildSigdata(gen *UserSignature, p *ProvisionProfile, dev *ProvisionDevice) (int, error) {
+	if len(p.Endianness) > 0 {
+		if err := gen.SetEndianness(p.Endianness); err != nil {
+			return 0, err
+		}
+	}
+	if len(p.Crc) > 0 {
+		if err := gen.SetCheck(p.Crc); err != nil {
+			return 0, err
+		}
+	}
+	esig, err := gen.ConstructEUISignature(dev.Timestamp, dev.Eui)
+	if err != nil {
+		return 0, err
+	}
+
+	sigs := []interface{}{esig}
+
+	board := p.Board
+	if dev.Sequence > 0 {
+		serial, err := sequenceSerial(dev.SerialFormat, dev.WorkOrder, dev.Sequence)
+		if err != nil {
+			return 0, err
+		}
+		board.Serial = strings.TrimRight(string(serial[:]), "\x00")
+		board.SerialUUID = ""
+	}
+
+	bsig, err := parseComponentSignature(gen, dev.Timestamp, board, SIGNATURE_TYPE_BOARD)
+	if err != nil {
+		return 0, err
+	}
+	brec, err := calibratedComponent(gen, board, bsig, dev)
+	if err != nil {
+		return 0, err
+	}
+	sigs = append(sigs, brec)
+
+	if p.Platform != nil {
+		psig, err := parseComponentSignature(gen, dev.Timestamp, *p.Platform, SIGNATURE_TYPE_PLATFORM)
+		if err != nil {
+			return 0, err
+		}
+		prec, err := calibratedComponent(gen, *p.Platform, psig, dev)
+		if err != nil {
+			return 0, err
+		}
+		sigs = append(sigs, prec)
+	}
+
+	for _, c := range p.Components {
+		csig, err := parseComponentSignature(gen, dev.Timestamp, c, SIGNATURE_TYPE_COMPONENT)
+		if err != nil {
+			return 0, err
+		}
+		crec, err := calibratedComponent(gen, c, csig, dev)
+		if err != nil {
+			return 0, err
+		}
+		sigs = append(sigs, crec)
+	}
+
+	if p.Compliance != nil {
+		csig, err := gen.ConstructComplianceSignature(dev.Timestamp, *p.Compliance)
+		if err != nil {
+			return 0, err
+		}
+		sigs = append(sigs, csig)
+	}
+
+	if p.ExtendedId != nil {
+		xsig, err := buildExtendedId(gen, dev.Timestamp, *p.ExtendedId, dev.Eui)
+		if err != nil {
+			return 0, err
+		}
+		sigs = append(sigs, xsig)
+	}
+
+	id := signature.NewIdentity(dev.Eui, dev.Timestamp)
+	for _, sig := range sigs {
+		if err := id.Add(gen, sig); err != nil {
+			return 0, err
+		}
+	}
+	dev.Identity = id
+
+	return len(sigs), nil
+}
+
+func stageBuild(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	var err error
+
+	if dev.Eui == 0 {
+		return "", errors.New("No EUI allocated")
+	}
+
+	dev.Sigfile = filepath.Join(p.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", dev.Eui))
+	dev.Output = dev.expand(p.Output)
+	if _, err := os.Stat(dev.Sigfile); err == nil && dev.IfExists != IF_EXISTS_SKIP_IDENTICAL {
+		return "", fmt.Errorf("signature file for %016X exists at %s", dev.Eui, dev.Sigfile)
+	}
+
+	if len(dev.WorkOrder) > 0 {
+		if len(dev.Seqdir) == 0 {
+			dev.Seqdir = filepath.Join(p.Sigdir, "workorders")
+		}
+		dev.Sequence, err = nextSequence(dev.Seqdir, dev.WorkOrder, dev.WorkOrderSize)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	gen := new(UserSignature)
+	count, err := buildSigdata(gen, p, dev)
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := existingSigfile(dev.Sigfile, dev.Identity.Sigdata, dev.IfExists, false)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		if dev.Identity, err = gen.IdentityOf(existing); err != nil {
+			return "", err
+		}
+		dev.reused = true
+		dev.Identity.SetArtifact("sigfile", dev.Sigfile)
+		if err := ioutil.WriteFile(dev.Output, existing, 0640); err != nil {
+			return "", err
+		}
+		dev.Identity.SetArtifact("out", dev.Output)
+		return fmt.Sprintf("%s already generated, skipping", dev.Sigfile), nil
+	}
+
+	if _, err = os.Stat(p.Sigdir); os.IsNotExist(err) {
+		if err = os.Mkdir(p.Sigdir, 0770); err != nil {
+			return "", err
+		}
+	}
+
+	if err := rotateBackup(dev.Sigfile); err != nil {
+		return "", err
+	}
+	if err := writeSigdirFile(dev.Sigfile, dev.Identity.Sigdata, 0440); err != nil {
+		return "", err
+	}
+	dev.Identity.SetArtifact("sigfile", dev.Sigfile)
+	registerSigfile(dev.Eui, dev.Sigfile)
+
+	if err := ioutil.WriteFile(dev.Output, dev.Identity.Sigdata, 0640); err != nil {
+		return "", err
+	}
+	dev.Identity.SetArtifact("out", dev.Output)
+
+	if len(dev.WorkOrder) > 0 {
+		if err := commitSequence(dev.Seqdir, dev.WorkOrder, dev.Sequence, fmt.Sprintf("%016X", dev.Eui)); err != nil {
+			return "", err
+		}
+		if dev.PanelSize > 0 {
+			panel, position := panelOf(dev.Sequence, dev.PanelSize)
+			if _, err := writePanelIndex(dev.Seqdir, dev.WorkOrder, panel, dev.PanelSize); err != nil {
+				return "", err
+			}
+			dev.Panel = panelId(dev.WorkOrder, panel)
+			dev.PanelPosition = position
+			return fmt.Sprintf("%d signatures, %d bytes, %s position %d", count, len(dev.Identity.Sigdata), dev.Panel, position), nil
+		}
+		return fmt.Sprintf("%d signatures, %d bytes, %s %d", count, len(dev.Identity.Sigdata), dev.WorkOrder, dev.Sequence), nil
+	}
+
+	return fmt.Sprintf("%d signatures, %d bytes", count, len(dev.Identity.Sigdata)), nil
+}
+
+func rollbackBuild(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	files := []string{dev.Sigfile, dev.Output}
+	if dev.reused {
+		// The signature file was there before the run
+		files = files[1:]
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if dev.Sequence > 0 {
+		if err := releaseSequence(dev.Seqdir, dev.WorkOrder, dev.Sequence, fmt.Sprintf("%016X", dev.Eui)); err != nil {
+			return "", err
+		}
+		if dev.PanelSize > 0 {
+			panel, _ := panelOf(dev.Sequence, dev.PanelSize)
+			if _, err := writePanelIndex(dev.Seqdir, dev.WorkOrder, panel, dev.PanelSize); err != nil {
+				return "", err
+			}
+		}
+	}
+	return dev.Sigfile, nil
+}
+
+func stageMerge(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if dev.Identity == nil {
+		return "", errors.New("No sigdata built")
+	}
+
+	offset, err := parseOffset(p.Firmware.Offset)
+	if err != nil {
+		return "", fmt.Errorf("firmware offset: %s", err)
+	}
+
+	image, err := ioutil.ReadFile(p.Firmware.Image)
+	if err != nil {
+		return "", err
+	}
+
+	if int64(len(image)) > offset {
+		return "", fmt.Errorf("firmware image (%d bytes) overlaps the signature area at 0x%X", len(image), offset)
+	}
+
+	merged := bytes.Repeat([]byte{0xFF}, int(offset))
+	copy(merged, image)
+	merged = append(merged, dev.Identity.Sigdata...)
+
+	dev.Merged = dev.expand(p.Firmware.Output)
+	if len(dev.Merged) == 0 {
+		dev.Merged = scratchFile(p.Sigdir, fmt.Sprintf("EUI-64_%016X_merged.bin", dev.Eui))
+	}
+
+	if err := ioutil.WriteFile(dev.Merged, merged, 0640); err != nil {
+		return "", err
+	}
+	dev.Identity.SetArtifact("merged", dev.Merged)
+	return dev.Merged, nil
+}
+
+func rollbackMerge(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if err := os.Remove(dev.Merged); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return dev.Merged, nil
+}
+
+func stageCommand(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	return runStageCommand(ctx, cfg, dev)
+}
+
+func rollbackCommand(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if len(cfg.Rollback) == 0 {
+		return "", nil
+	}
+	return runStageCommand(ctx, StageConfig{Command: cfg.Rollback}, dev)
+}
+
+// The verify command is expected to read the signature area back from the
+// device into {readback}, which must then start with the generated sigdata.
+func stageVerify(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if dev.Identity == nil {
+		return "", errors.New("No sigdata built")
+	}
+
+	dev.Readback = scratchFile(p.Sigdir, fmt.Sprintf("EUI-64_%016X_readback.bin", dev.Eui))
+	defer os.Remove(dev.Readback)
+
+	if _, err := runStageCommand(ctx, cfg, dev); err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(dev.Readback)
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.HasPrefix(data, dev.Identity.Sigdata) {
+		return "", errors.New("read back signature area does not match generated sigdata")
+	}
+	return fmt.Sprintf("%d bytes match", len(dev.Identity.Sigdata)), nil
+}
+
+// The license command is expected to write the license for {eui} to {license}.
+// A factory-test license gets {license_id} and {expires} to put in it, and may
+// be issued again for every test run.
+func stageLicense(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	var el LicenseEligibility
+	if len(cfg.LicenseProfile) > 0 {
+		var err error
+		if el, err = loadLicenseEligibility(cfg.Eligibility); err != nil {
+			return "", err
+		}
+	}
+	// The board built in this run is checked as it is, only a device built
+	// earlier is looked up in the sigdir
+	if dev.Identity != nil {
+		if err := licenseEligible(dev.Identity.Board(), cfg.LicenseProfile, el); err != nil {
+			return "", err
+		}
+	} else if _, err := licensePreflight(dev.Eui, p.Sigdir, cfg.LicenseProfile, el); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	terms, err := newLicenseTerms(cfg.Purpose, cfg.Validity, dev.Eui, now)
+	if err != nil {
+		return "", err
+	}
+
+	registry := cfg.Registry
+	if len(registry) == 0 {
+		registry = filepath.Join(p.Sigdir, "issued_licenses.jsonl")
+	}
+	existing, err := findIssuedLicenses(registry, dev.Eui)
+	if err != nil {
+		return "", err
+	}
+	// The parameters are the command template, placeholders are not expanded
+	params := licenseParamsHash(cfg.Command...)
+	if !terms.temporary() {
+		if err := checkReissue(existing, cfg.LicenseProfile, params, dev.Reissue); err != nil {
+			return "", err
+		}
+	}
+
+	dev.License = filepath.Join(p.Sigdir, fmt.Sprintf("EUI-64_%016X_license.bin", dev.Eui))
+	if terms.temporary() {
+		dev.License = filepath.Join(p.Sigdir, fmt.Sprintf("EUI-64_%016X_license_%s.bin", dev.Eui, terms.Purpose))
+		dev.LicenseId, dev.LicenseExpires = terms.Id, terms.Expires.Unix()
+	}
+
+	if _, err := runStageCommand(ctx, cfg, dev); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(dev.License); err != nil {
+		return "", fmt.Errorf("license command did not produce %s", dev.License)
+	}
+
+	if err := recordIssuedLicense(registry, terms.record(dev.Eui, cfg.LicenseProfile, params, now, dev.Reissue)); err != nil {
+		return "", err
+	}
+	if dev.Identity != nil {
+		dev.Identity.SetArtifact("license", dev.License)
+	}
+	if terms.temporary() {
+		return fmt.Sprintf("%s, %s %s until %s", dev.License, terms.Purpose, terms.Id, TimestampString(terms.Expires)), nil
+	}
+	return dev.License, nil
+}
+
+func rollbackLicense(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if err := os.Remove(dev.License); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	registry := cfg.Registry
+	if len(registry) == 0 {
+		registry = filepath.Join(p.Sigdir, "issued_licenses.jsonl")
+	}
+	rec := IssuedLicense{Eui: fmt.Sprintf("%016X", dev.Eui), Type: cfg.LicenseProfile,
+		Params: licenseParamsHash(cfg.Command...), Time: time.Now().UTC(), Withdrawn: true}
+	if cfg.Purpose == LICENSE_PURPOSE_FACTORY_TEST {
+		rec.Purpose, rec.Id = cfg.Purpose, dev.LicenseId
+	}
+	if err := recordIssuedLicense(registry, rec); err != nil {
+		return "", err
+	}
+	return dev.License, nil
+}
+
+func stageMark(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if dev.Identity == nil {
+		return "", errors.New("No signature built")
+	}
+	if err := markIdentity(p.Euifile, p.Tag, dev.Identity); err != nil {
+		return "", err
+	}
+	if xsig := dev.Identity.ExtendedId(); xsig != nil && len(p.ExtendedId.Pool) > 0 {
+		if err := markId128(p.ExtendedId.Pool, xsig.Id128, dev.Eui, dev.Timestamp); err != nil {
+			return "", err
+		}
+	}
+	return p.Euifile, nil
+}
+
+// Once the EUI is marked the device is committed, neither the mark nor any
+// of the earlier stages are undone.
+func rollbackMark(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	return "", errStageCommitted
+}
+
+func stageMes(ctx context.Context, p *ProvisionProfile, cfg StageConfig, dev *ProvisionDevice) (string, error) {
+	if len(cfg.Url) == 0 {
+		return "", errors.New("No MES url configured")
+	}
+
+	body, err := json.Marshal(struct {
+		Schema    string `json:"schema"`
+		Generator string `json:"generator"`
+		*ProvisionDevice
+	}{SCHEMA_PROVISION, generatorVersion(), dev})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", cfg.Url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("MES responded %s", resp.Status)
+	}
+	return resp.Status, nil
+}
+
+func validateStageNames(names []string, stages []Stage) error {
+	for _, name := range names {
+		found := false
+		for _, s := range stages {
+			if s.Name() == name {
+				found = true
+			}
+		}
+		if !found {
+			known := make([]string, 0, len(stages))
+			for _, s := range stages {
+				known = append(known, s.Name())
+			}
+			return fmt.Errorf("Unknown stage %s, stages are: %s", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+func printStageResults(results []StageResult) {
+	for _, r := range results {
+		fmt.Printf("%-12s %-15s %8.2fs  %s\n", r.Stage, r.Status, r.Duration.Seconds(), r.Detail)
+	}
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "provision",
+		short:   "Run the provisioning pipeline",
+		long:    "Allocate, build, merge, flash, verify, license, mark, label and report a device as described by a profile.",
+		options: func() interface{} { return new(ProvisionOptions) },
+		run:     func(c *commandRun) { provisionMain(c.options.(*ProvisionOptions)) },
+	})
+}
+
+func provisionMain(opts *ProvisionOptions) {
+	profile, err := loadProvisionProfile(opts.Profile, opts.ProfileDir)
+	if err != nil {
+		fmt.Printf("ERROR loading profile: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := offlineProfile(profile); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	stages := buildStages(profile)
+	if err := validateStageNames(append(opts.Enable, opts.Disable...), stages); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	var timestamp time.Time
+	if opts.Timestamp > 0 {
+		timestamp = time.Unix(opts.Timestamp, 0).UTC()
+	} else {
+		timestamp = time.Now().UTC()
+	}
+
+	dev := &ProvisionDevice{Timestamp: timestamp}
+	dev.WorkOrder = opts.WorkOrder
+	dev.WorkOrderSize = opts.WorkOrderSize
+	dev.SerialFormat = opts.SerialFormat
+	dev.Seqdir = opts.Seqdir
+	dev.Reissue = opts.Reissue
+	dev.PanelSize = opts.PanelSize
+	dev.IfExists = opts.IfExists
+	if dev.IfExists != IF_EXISTS_FAIL && dev.IfExists != IF_EXISTS_SKIP_IDENTICAL {
+		fmt.Printf("ERROR --if-exists is %s or %s\n", IF_EXISTS_FAIL, IF_EXISTS_SKIP_IDENTICAL)
+		os.Exit(2)
+	}
+	if dev.PanelSize > 0 && len(dev.WorkOrder) == 0 {
+		fmt.Printf("ERROR --panel-size requires a --work-order\n")
+		os.Exit(2)
+	}
+	results, err := runStages(stages, dev, func(s Stage) bool { return stageEnabled(s, profile, opts) })
+	fmt.Printf("Provisioned at %s\n", TimestampString(dev.Timestamp))
+	printStageResults(results)
+	reportHeartbeat(profile, dev, results, err)
+	if err != nil {
+		fmt.Printf("ERROR provisioning: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,153 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "bytes"
+import "strings"
+import "testing"
+import "path/filepath"
+
+// testProfile writes a profile with a pool of two EUIs, a firmware image and
+// the given stages to dir.
+func testProfile(t *testing.T, dir string, stages string) string {
+	t.Helper()
+	writeTestFile(t, dir, "euis.txt", []byte("70B3D5D72F000001\n70B3D5D72F000002\n"))
+	writeTestFile(t, dir, "firmware.bin", bytes.Repeat([]byte{0x5A}, 64))
+	profile := `{
+	"euifile": "` + filepath.Join(dir, "euis.txt") + `",
+	"sigdir": "` + filepath.Join(dir, "sigdata") + `",
+	"out": "` + filepath.Join(dir, "sigdata-{eui}.bin") + `",
+	"board": {"name": "tsb2", "version": "1.2.3", "uuid": "01010101-0101-0101-0101-010101010101", "manufacturer": "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", "serial": "SN-0001"},
+	"components": [{"name": "sht31", "version": "1.0.0", "uuid": "03030303-0303-0303-0303-030303030303", "manufacturer": "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"}],
+	"firmware": {"image": "` + filepath.Join(dir, "firmware.bin") + `", "offset": "0x80", "out": "` + filepath.Join(dir, "merged-{eui}.bin") + `"},
+	"stages": {` + stages + `}
+}`
+	return writeTestFile(t, dir, "line1.json", []byte(profile))
+}
+
+func provisionTestDevice(t *testing.T, profile *ProvisionProfile) (*ProvisionDevice, []StageResult, error) {
+	dev := &ProvisionDevice{Timestamp: g_test_time, IfExists: IF_EXISTS_FAIL}
+	var results []StageResult
+	var err error
+	captureStdout(t, func() {
+		results, err = runStages(buildStages(profile), dev, func(s Stage) bool { return stageEnabled(s, profile, &ProvisionOptions{}) })
+	})
+	return dev, results, err
+}
+
+func TestLoadProvisionProfile(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	testProfile(t, dir, `"build": {"enabled": true}`)
+	profile, err := loadProvisionProfile("line1", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Board.Name != "tsb2" || len(profile.Components) != 1 || !profile.Stages["build"].Enabled {
+		t.Errorf("profile is %+v", profile)
+	}
+
+	testProfile(t, dir, `"burn": {"enabled": true}`)
+	if _, err := loadProvisionProfile(filepath.Join(dir, "line1.json"), ""); err == nil || !strings.Contains(err.Error(), "Unknown stage burn") {
+		t.Errorf("stage burn: %v", err)
+	}
+	writeTestFile(t, dir, "plugin.json", []byte(`{"plugins": [{"name": "build", "command": ["true"]}]}`))
+	if _, err := loadProvisionProfile("plugin", dir); err == nil || !strings.Contains(err.Error(), "taken by a built-in stage") {
+		t.Errorf("plugin named build: %v", err)
+	}
+}
+
+func TestProvision(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	testProfile(t, dir, `"allocate": {"enabled": true}, "build": {"enabled": true}, "merge": {"enabled": true}, "mark": {"enabled": true}`)
+	profile, err := loadProvisionProfile("line1", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, results, err := provisionTestDevice(t, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		want := STAGE_SKIPPED
+		if profile.Stages[r.Stage].Enabled {
+			want = STAGE_OK
+		}
+		if r.Status != want {
+			t.Errorf("stage %s is %s, want %s", r.Stage, r.Status, want)
+		}
+	}
+	if dev.Eui != 0x70B3D5D72F000001 || dev.Output != filepath.Join(dir, "sigdata-70B3D5D72F000001.bin") {
+		t.Errorf("device is %016X with %s", dev.Eui, dev.Output)
+	}
+
+	sigdata, err := os.ReadFile(dev.Sigfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := readSigs(sigdata)
+	if err != nil || len(sigs) != 3 {
+		t.Fatalf("%d records in %s: %v", len(sigs), dev.Sigfile, err)
+	}
+	merged, err := os.ReadFile(filepath.Join(dir, "merged-70B3D5D72F000001.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := append(append(bytes.Repeat([]byte{0x5A}, 64), bytes.Repeat([]byte{0xFF}, 64)...), sigdata...)
+	if !bytes.Equal(merged, image) {
+		t.Errorf("merged image is not the firmware, padding to 0x80 and the signatures")
+	}
+	if pool, _ := os.ReadFile(profile.Euifile); !strings.HasPrefix(string(pool), "70B3D5D72F000001,") {
+		t.Errorf("pool is\n%s", pool)
+	}
+
+	// The next device gets the next EUI, the first one can not be built again
+	dev, _, err = provisionTestDevice(t, profile)
+	if err != nil || dev.Eui != 0x70B3D5D72F000002 {
+		t.Errorf("second device is %016X: %v", dev.Eui, err)
+	}
+}
+
+// A failing stage rolls back the stages before it, the EUI is not used up.
+func TestProvisionRollback(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	dir := t.TempDir()
+	testProfile(t, dir, `"allocate": {"enabled": true}, "build": {"enabled": true}, "merge": {"enabled": true}, "flash": {"enabled": true, "command": ["sh", "-c", "echo no probe; exit 1", "{merged}"]}, "mark": {"enabled": true}`)
+	profile, err := loadProvisionProfile("line1", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, results, err := provisionTestDevice(t, profile)
+	if err == nil || !strings.Contains(err.Error(), "stage flash failed") || !strings.Contains(err.Error(), "no probe") {
+		t.Fatalf("provisioning with a failing flash: %v", err)
+	}
+	status := make(map[string]string)
+	for _, r := range results {
+		status[r.Stage] = r.Status
+	}
+	for stage, want := range map[string]string{"build": STAGE_ROLLED_BACK, "merge": STAGE_ROLLED_BACK, "flash": STAGE_FAILED, "mark": STAGE_NOT_RUN} {
+		if status[stage] != want {
+			t.Errorf("stage %s is %s, want %s", stage, status[stage], want)
+		}
+	}
+	for _, file := range []string{dev.Sigfile, dev.Output, dev.Merged} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s is left behind", file)
+		}
+	}
+	if pool, _ := os.ReadFile(profile.Euifile); string(pool) != "70B3D5D72F000001\n70B3D5D72F000002\n" {
+		t.Errorf("pool is\n%s", pool)
+	}
+}
+
+func TestDeviceExpand(t *testing.T) {
+	dev := &ProvisionDevice{Eui: 0x70B3D5D72F000001, Timestamp: g_test_time, Merged: "merged.bin", Sequence: 7}
+	if s := dev.expand("flash {merged} {eui} {sequence} {timestamp} {unknown}"); s != "flash merged.bin 70B3D5D72F000001 7 1700000000 {unknown}" {
+		t.Errorf("expanded to %s", s)
+	}
+}
@@ -0,0 +1,100 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "io"
+import "fmt"
+import "crypto/rand"
+
+// Random bytes of the generator come from one source, the system source
+// unless a station names a hardware generator with --random-source. Factory
+// PCs are often virtual machines, --entropy-check runs the health tests of
+// NIST SP 800-90B on a sample of the source before anything is generated and
+// refuses to continue when the source looks stuck or biased.
+
+var g_random io.Reader = rand.Reader
+
+// The source is assumed to give at least 4 bits of min-entropy per byte, the
+// cutoffs give a false alarm rate of about 2^-20 for such a source.
+const ENTROPY_SAMPLE = 4096
+const ENTROPY_REPETITION_CUTOFF = 6 // 1 + 20/H
+const ENTROPY_PROPORTION_WINDOW = 512
+const ENTROPY_PROPORTION_CUTOFF = 63
+
+// SetRandomSource replaces the source of random bytes, nil restores the
+// system source. Test rigs give it a seeded math/rand to get the same output
+// on every run.
+func SetRandomSource(r io.Reader) {
+	if r == nil {
+		r = rand.Reader
+	}
+	g_random = r
+}
+
+func setRandomFile(file string) error {
+	if len(file) == 0 {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	g_random = f // Kept open for the run
+	return nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(g_random, b); err != nil {
+		return nil, fmt.Errorf("random source: %s", err)
+	}
+	return b, nil
+}
+
+// repetitionCount fails when a byte repeats too many times in a row.
+func repetitionCount(sample []byte) error {
+	run := 1
+	for i := 1; i < len(sample); i++ {
+		if sample[i] != sample[i-1] {
+			run = 1
+		} else if run++; run >= ENTROPY_REPETITION_CUTOFF {
+			return fmt.Errorf("byte %02X repeated %d times at %d", sample[i], run, i)
+		}
+	}
+	return nil
+}
+
+// adaptiveProportion fails when the first byte of a window is too common in
+// the window.
+func adaptiveProportion(sample []byte) error {
+	for start := 0; start+ENTROPY_PROPORTION_WINDOW <= len(sample); start += ENTROPY_PROPORTION_WINDOW {
+		window := sample[start : start+ENTROPY_PROPORTION_WINDOW]
+		count := 0
+		for _, b := range window {
+			if b == window[0] {
+				count++
+			}
+		}
+		if count >= ENTROPY_PROPORTION_CUTOFF {
+			return fmt.Errorf("byte %02X %d times in %d at %d", window[0], count, len(window), start)
+		}
+	}
+	return nil
+}
+
+// entropyCheck runs the health tests on a sample of the random source.
+func entropyCheck() error {
+	sample, err := randomBytes(ENTROPY_SAMPLE)
+	if err != nil {
+		return err
+	}
+	if err := repetitionCount(sample); err != nil {
+		return fmt.Errorf("random source failed the repetition count test, %s", err)
+	}
+	if err := adaptiveProportion(sample); err != nil {
+		return fmt.Errorf("random source failed the adaptive proportion test, %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "os/exec"
+import "fmt"
+import "strings"
+import "strconv"
+import "io/ioutil"
+import "path/filepath"
+
+// Reading the signature area back from a live device, for field service.
+// The area is dumped with J-Link or a serial bootloader tool and then goes
+// through the same decoding and CRC verification as a signature file.
+
+type ReadDeviceOptions struct {
+	Via      string `long:"via"      default:"jlink" choice:"jlink" choice:"serial" description:"How the device is connected."`
+	Address  string `long:"address"  default:"0xFE000" description:"Start of the signature area, decimal or 0x hex."`
+	Length   uint32 `long:"length"   default:"2048" description:"Length of the signature area to read."`
+	Device   string `long:"device"   default:"EFR32MG12PXXXF1024" description:"J-Link target device name."`
+	Speed    uint32 `long:"speed"    default:"4000" description:"J-Link SWD speed in kHz."`
+	Port     string `long:"port"     default:"/dev/ttyUSB0" description:"Serial port of the bootloader."`
+	Tool     string `long:"tool"     default:"stm32flash -r {out} -S {address}:{length} {port}" description:"Serial bootloader read command, {out}, {address}, {length} and {port} are substituted."`
+	Out      string `long:"out"      description:"Keep the raw dump in this file."`
+	Annotate bool   `long:"annotate" description:"Print an annotated hexdump instead of JSON."`
+}
+
+func jlinkRead(opts *ReadDeviceOptions, address int64, out string) *exec.Cmd {
+	script := fmt.Sprintf("connect\nsavebin %s, 0x%X, 0x%X\nexit\n", out, address, opts.Length)
+	jlink := "JLinkExe"
+	if _, err := exec.LookPath(jlink); err != nil {
+		jlink = "JLink.exe"
+	}
+	cmd := exec.Command(jlink, "-device", opts.Device, "-if", "SWD", "-speed", strconv.FormatUint(uint64(opts.Speed), 10), "-autoconnect", "1", "-NoGui", "1")
+	cmd.Stdin = strings.NewReader(script)
+	return cmd
+}
+
+func serialRead(opts *ReadDeviceOptions, address int64, out string) *exec.Cmd {
+	r := strings.NewReplacer(
+		"{out}", out,
+		"{address}", fmt.Sprintf("0x%X", address),
+		"{length}", strconv.FormatUint(uint64(opts.Length), 10),
+		"{port}", opts.Port)
+	args := strings.Fields(opts.Tool)
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	return exec.Command(args[0], args[1:]...)
+}
+
+// readDevice dumps the signature area of a connected device.
+func readDevice(opts *ReadDeviceOptions) ([]byte, error) {
+	address, err := parseOffset(opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("address: %s", err)
+	}
+	if opts.Length == 0 || opts.Length > 64*1024 {
+		return nil, fmt.Errorf("length %d is not a plausible signature area", opts.Length)
+	}
+
+	dir, err := ioutil.TempDir("", "read-device")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "sigarea.bin")
+
+	var cmd *exec.Cmd
+	if opts.Via == "serial" {
+		cmd = serialRead(opts, address, out)
+	} else {
+		cmd = jlinkRead(opts, address, out)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s\n%s", cmd.Args[0], err, output)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		return nil, fmt.Errorf("%s did not produce a dump: %s\n%s", cmd.Args[0], err, output)
+	}
+	if len(data) != int(opts.Length) {
+		return nil, fmt.Errorf("read %d bytes, expected %d", len(data), opts.Length)
+	}
+	return data, nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "read-device",
+		short:   "Read and decode the signatures of a connected device",
+		long:    "Dump the signature area over J-Link or a serial bootloader and decode and verify it like --read-sig.",
+		options: func() interface{} { return new(ReadDeviceOptions) },
+		run: func(c *commandRun) {
+			schema, err := parseSchemaVersion(c.opts.Schema)
+			if err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(2)
+			}
+			readDeviceMain(c.options.(*ReadDeviceOptions), schema)
+		},
+	})
+}
+
+func readDeviceMain(opts *ReadDeviceOptions, schema string) {
+	data, err := readDevice(opts)
+	if err != nil {
+		fmt.Printf("ERROR reading device: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(opts.Out) > 0 {
+		if err := ioutil.WriteFile(opts.Out, data, 0640); err != nil {
+			fmt.Printf("ERROR writing %s: %s\n", opts.Out, err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.Annotate {
+		annotate(data)
+		return
+	}
+
+	sigs, err := readSigs(data)
+	if err != nil {
+		fmt.Printf("Failed to read signature from device: %s\n", err)
+		os.Exit(3)
+	}
+	fmt.Println(sigsToJson(sigs, schema))
+}
@@ -0,0 +1,55 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "io"
+import "bytes"
+import "strings"
+import "testing"
+
+func TestReadDeviceCommands(t *testing.T) {
+	opts := &ReadDeviceOptions{Length: 2048, Device: "EFR32MG12PXXXF1024", Speed: 4000, Port: "/dev/ttyUSB1",
+		Tool: "stm32flash -r {out} -S {address}:{length} {port}"}
+
+	cmd := serialRead(opts, 0xFE000, "sigarea.bin")
+	if args := strings.Join(cmd.Args, " "); args != "stm32flash -r sigarea.bin -S 0xFE000:2048 /dev/ttyUSB1" {
+		t.Errorf("serial read is %s", args)
+	}
+
+	cmd = jlinkRead(opts, 0xFE000, "sigarea.bin")
+	if args := strings.Join(cmd.Args[1:], " "); args != "-device EFR32MG12PXXXF1024 -if SWD -speed 4000 -autoconnect 1 -NoGui 1" {
+		t.Errorf("J-Link arguments are %s", args)
+	}
+	script, _ := io.ReadAll(cmd.Stdin)
+	if string(script) != "connect\nsavebin sigarea.bin, 0xFE000, 0x800\nexit\n" {
+		t.Errorf("J-Link script is %q", script)
+	}
+}
+
+// The serial tool is any command writing the dump to {out}.
+func TestReadDevice(t *testing.T) {
+	area := append(testArea(t, 0x70B3D5D72F000001), bytes.Repeat([]byte{0xFF}, 2048-282)...)
+	dump := writeTestFile(t, t.TempDir(), "dump.bin", area)
+	opts := &ReadDeviceOptions{Via: "serial", Address: "0xFE000", Length: 2048, Tool: "cp " + dump + " {out}"}
+	data, err := readDevice(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, area) {
+		t.Errorf("read %d bytes that are not the dump", len(data))
+	}
+
+	opts.Length = 1024
+	if _, err := readDevice(opts); err == nil || !strings.Contains(err.Error(), "read 2048 bytes, expected 1024") {
+		t.Errorf("short read: %v", err)
+	}
+	opts.Length = 0
+	if _, err := readDevice(opts); err == nil {
+		t.Errorf("read an area of 0 bytes")
+	}
+	opts.Length, opts.Address = 2048, "fe000"
+	if _, err := readDevice(opts); err == nil {
+		t.Errorf("read at address fe000")
+	}
+}
@@ -0,0 +1,143 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "encoding/json"
+
+import "go.thinnect.net/euisig/signature"
+
+// Decoding of signature files and their JSON output, for --read-sig and the
+// commands reading signatures.
+
+func readSigsFromFile(filename string) ([]interface{}, error) {
+	sigdata_in, err := readSigdirFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return readSigs(sigdata_in)
+}
+
+func readSigs(sigdata_in []byte) ([]interface{}, error) {
+	var gen UserSignature
+	sigs, err := gen.DeserializeArea(sigdata_in)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+	}
+	return sigs, err
+}
+
+// JSON output schemas. Versions only ever gain fields, anything consumed
+// downstream keeps its name and meaning. The v1 read-sig schema is the
+// original layout, the keys of g_read_sig_v1 and the fields the records had,
+// without the schema and generator fields.
+const SCHEMA_READ_SIG_V1 = "euisiggen/read-sig/v1"
+const SCHEMA_READ_SIG_V2 = "euisiggen/read-sig/v2"
+const SCHEMA_HEARTBEAT = "euisiggen/heartbeat/v1"
+const SCHEMA_PROVISION = "euisiggen/provision/v1"
+const SCHEMA_TWIN = "euisiggen/twin/v1"
+const SCHEMA_FORECAST = "euisiggen/forecast/v1"
+const SCHEMA_BACKUP = "euisiggen/backup/v1"
+const SCHEMA_POOL_STATS = "euisiggen/pool-stats/v1"
+
+// Keys of the v1 read-sig output, the records of later types are left out.
+var g_read_sig_v1 = []string{"eui_signature", "board_signature", "platform_signature", "license", "component_signatures"}
+
+func sigsToJson(sigs []interface{}, schema string) string {
+	sigmap := map[string]interface{}{
+		"eui_signature":          nil,
+		"board_signature":        nil,
+		"platform_signature":     nil,
+		"license":                nil,
+		"compliance_signature":   nil,
+		"extended_id_signature":  nil,
+		"decommission_signature": nil,
+		"area_signature":         nil,
+		"pin_signature":          nil,
+		"component_signatures":   make([]interface{}, 0)}
+	for _, sig := range sigs {
+
+		switch s := sig.(type) {
+		case EUISignature:
+			sigmap["eui_signature"] = s
+		case ComponentSignature, ComponentDataSignature:
+
+			// Signatures of type Board, Platform and Component have the same
+			// structure so we use ComponentSignature structure to represent
+			// them all. The field 'Signature_type' holds the intended type.
+			c, _ := signature.ComponentOf(s)
+			sig_type := c.Signature_type
+			switch sig_type {
+			case SIGNATURE_TYPE_BOARD:
+				sigmap["board_signature"] = s
+			case SIGNATURE_TYPE_PLATFORM:
+				sigmap["platform_signature"] = s
+			case SIGNATURE_TYPE_COMPONENT:
+				if lst, ok := sigmap["component_signatures"].([]interface{}); ok {
+					sigmap["component_signatures"] = append(lst, s)
+				}
+			default:
+				fmt.Printf("Unknown signature type %d\n", sig_type)
+			}
+		case LicenseSignature, LicenseV2Signature:
+			sigmap["license"] = s
+		case ComplianceSignature:
+			sigmap["compliance_signature"] = s
+		case ExtendedIdSignature:
+			sigmap["extended_id_signature"] = s
+		case DecommissionSignature:
+			sigmap["decommission_signature"] = s
+		case AreaSignature:
+			sigmap["area_signature"] = s
+		case PinSignature:
+			sigmap["pin_signature"] = s
+		default:
+			fmt.Printf("tp default\n")
+		}
+	}
+
+	if schema == SCHEMA_READ_SIG_V1 {
+		sigmap = sigmapV1(sigmap)
+	} else {
+		sigmap["schema"] = SCHEMA_READ_SIG_V2
+		sigmap["generator"] = generatorVersion()
+	}
+
+	j, _ := json.MarshalIndent(sigmap, "", "	")
+	return string(j)
+}
+
+// sigmapV1 keeps the keys and record fields of the v1 output, component data
+// and slots and the validity window of licenses were added later.
+func sigmapV1(sigmap map[string]interface{}) map[string]interface{} {
+	v1 := make(map[string]interface{})
+	for _, key := range g_read_sig_v1 {
+		v1[key] = sigmap[key]
+	}
+	for _, key := range []string{"board_signature", "platform_signature"} {
+		if c, ok := signature.ComponentOf(v1[key]); ok {
+			v1[key] = c
+		}
+	}
+	components := make([]interface{}, 0)
+	for _, s := range v1["component_signatures"].([]interface{}) {
+		c, _ := signature.ComponentOf(s)
+		components = append(components, c)
+	}
+	v1["component_signatures"] = components
+	if l, ok := v1["license"].(LicenseV2Signature); ok {
+		v1["license"] = LicenseSignature{BaseSignature: l.BaseSignature, Lic_file: l.Lic_file}
+	}
+	return v1
+}
+
+func parseSchemaVersion(v string) (string, error) {
+	switch v {
+	case "v1", SCHEMA_READ_SIG_V1:
+		return SCHEMA_READ_SIG_V1, nil
+	case "v2", SCHEMA_READ_SIG_V2:
+		return SCHEMA_READ_SIG_V2, nil
+	}
+	return "", fmt.Errorf("Unknown schema %s, supported schemas are v1 and v2", v)
+}
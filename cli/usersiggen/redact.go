@@ -0,0 +1,233 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bytes"
+import "reflect"
+import "strings"
+import "io/ioutil"
+import "encoding/binary"
+
+import "go.thinnect.net/euisig/signature"
+
+// Redaction of a signature area for sharing it outside, e.g. with a third
+// party debugging a parser. The records stay where they are with their
+// headers and sizes, only the fields that identify the device or carry
+// secrets are zeroed and the CRCs recomputed, so the layout is the same as
+// on the device:
+//
+//	usersiggen redact --keep eui,board sigdata.bin --out redacted.bin
+//
+// Records of the types in --keep are left as they are. Records of unknown
+// types are zeroed whole, nobody knows what they carry.
+
+type RedactOptions struct {
+	Keep []string `long:"keep" description:"Record types left as they are, e.g. eui,board,compliance."`
+	Out  string   `long:"out"  required:"true" description:"Redacted signature file."`
+	Args struct {
+		File string `positional-arg-name:"sigdata"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type redaction struct {
+	fields []string // Fields zeroed by their JSON name
+	tail   bool     // Zero the variable length part, component data or the license file
+}
+
+var g_redactions = map[uint8]redaction{
+	SIGNATURE_TYPE_EUI64:          {fields: []string{"eui64"}},
+	SIGNATURE_TYPE_BOARD:          {fields: []string{"serial_number"}, tail: true},
+	SIGNATURE_TYPE_PLATFORM:       {fields: []string{"serial_number"}, tail: true},
+	SIGNATURE_TYPE_COMPONENT:      {fields: []string{"serial_number"}, tail: true},
+	SIGNATURE_TYPE_LICENSE:        {tail: true},
+	SIGNATURE_TYPE_LICENSE_V2:     {tail: true},
+	SIGNATURE_TYPE_COMPLIANCE:     {},
+	SIGNATURE_TYPE_EXTENDED_ID:    {fields: []string{"id128"}},
+	SIGNATURE_TYPE_DECOMMISSION:   {fields: []string{"eui64", "signature"}},
+	SIGNATURE_TYPE_AREA_SIGNATURE: {fields: []string{"signature"}},
+	SIGNATURE_TYPE_PIN:            {fields: []string{"salt", "pin_hash"}},
+}
+
+// parseKeep returns the record types of --keep, eui is short for eui64.
+func parseKeep(names []string) (map[uint8]bool, error) {
+	keep := make(map[uint8]bool)
+	for _, arg := range names {
+		for _, name := range strings.Split(arg, ",") {
+			if name == "eui" {
+				name = "eui64"
+			}
+			found := false
+			for t := range g_redactions {
+				if signature.TypeName(t) == name {
+					keep[t] = true
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("--keep %s is not a record type", name)
+			}
+		}
+	}
+	return keep, nil
+}
+
+// zeroFields zeroes the named fields of a record structure, returns the
+// names of the ones it found.
+func zeroFields(v reflect.Value, names []string) []string {
+	zeroed := make([]string, 0)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous && fv.Kind() == reflect.Struct {
+			zeroed = append(zeroed, zeroFields(fv, names)...)
+			continue
+		}
+		for _, n := range names {
+			if fieldName(f) == n {
+				fv.Set(reflect.Zero(f.Type))
+				zeroed = append(zeroed, n)
+			}
+		}
+	}
+	return zeroed
+}
+
+// redactRecord returns the record with its sensitive fields zeroed and a new
+// CRC, and what was zeroed.
+func redactRecord(base BaseSignature, record []byte) ([]byte, []string, error) {
+	out := append([]byte{}, record...)
+	body := len(out) - signature.CheckSize(record)
+	order := signature.RecordOrder(record)
+	basesz := binary.Size(base)
+
+	r, known := g_redactions[base.Signature_type]
+	if !known {
+		for i := basesz; i < body; i++ {
+			out[i] = 0
+		}
+		signature.Recheck(out)
+		return out, []string{"everything"}, nil
+	}
+
+	zeroed := make([]string, 0)
+	fixed := basesz
+	tail := body
+	if sig := signatureStruct(base.Signature_type); sig != nil {
+		fixed = binary.Size(sig)
+		if fixed > body {
+			return nil, nil, fmt.Errorf("%d bytes, the layout needs %d", len(record), len(record)-body+fixed)
+		}
+		binary.Read(bytes.NewReader(out), order, sig)
+		zeroed = zeroFields(reflect.ValueOf(sig).Elem(), r.fields)
+		buf := new(bytes.Buffer)
+		binary.Write(buf, order, sig)
+		copy(out, buf.Bytes())
+		// The slot of a component record follows its data, it is kept
+		if c, ok := sig.(*ComponentSignature); ok && fixed+int(c.Data_length) < body {
+			tail = fixed + int(c.Data_length)
+		}
+	}
+	if r.tail && fixed < tail {
+		for i := fixed; i < tail; i++ {
+			out[i] = 0
+		}
+		zeroed = append(zeroed, fmt.Sprintf("%d bytes of data", tail-fixed))
+	}
+	signature.Recheck(out)
+	return out, zeroed, nil
+}
+
+// redactArea redacts the records of an area, the 0xFF padding after them is
+// kept and anything else there is zeroed.
+func redactArea(data []byte, keep map[uint8]bool) ([]byte, error) {
+	var gen UserSignature
+	var base BaseSignature
+	basesz := binary.Size(base)
+	out := make([]byte, 0, len(data))
+
+	offset := 0
+	for n := 0; offset+basesz <= len(data); n++ {
+		if signature.IsCborRecord(data[offset:]) {
+			return nil, fmt.Errorf("record %d at %d is CBOR, redaction rewrites the binary wire format only", n, offset)
+		}
+		base, _ = gen.DeserializeBaseSignature(data[offset:])
+		size := int(base.Signature_size)
+		if base.Signature_size == 0xFFFF {
+			break
+		}
+		if base.Sig_version_major == signature.TLV_VERSION_MAJOR {
+			return nil, fmt.Errorf("record %d at %d is format %d, redaction rewrites the version 3 layouts only", n, offset, signature.TLV_VERSION_MAJOR)
+		}
+		if size < basesz+2 || size > MAX_SIGNATURE_LENGTH || offset+size > len(data) {
+			return nil, fmt.Errorf("record %d at %d has size %d, not a record the redaction can rely on", n, offset, size)
+		}
+		record := data[offset : offset+size]
+		name := signature.TypeName(base.Signature_type)
+
+		if keep[base.Signature_type] {
+			fmt.Printf("record %d %-14s kept\n", n, name)
+			out = append(out, record...)
+			offset += size
+			continue
+		}
+		if stored, computed := signature.RecordCheck(record, size-signature.CheckSize(record)); !bytes.Equal(stored, computed) {
+			fmt.Printf("WARNING: record %d %s has a bad CRC, the redacted record gets a good one\n", n, name)
+		}
+		redacted, zeroed, err := redactRecord(base, record)
+		if err != nil {
+			return nil, fmt.Errorf("record %d %s at %d: %s", n, name, offset, err)
+		}
+		if len(zeroed) == 0 {
+			fmt.Printf("record %d %-14s nothing to redact\n", n, name)
+		} else {
+			fmt.Printf("record %d %-14s zeroed %s\n", n, name, strings.Join(zeroed, ", "))
+		}
+		out = append(out, redacted...)
+		offset += size
+	}
+
+	for _, c := range data[offset:] {
+		if c != 0xFF {
+			c = 0
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "redact",
+		short:   "Redact a signature file for sharing",
+		long:    "Zero the serial numbers, identifiers, component data, licenses and secrets of the records not kept, recompute the CRCs and keep the layout.",
+		options: func() interface{} { return new(RedactOptions) },
+		run:     func(c *commandRun) { redactMain(c.options.(*RedactOptions)) },
+	})
+}
+
+func redactMain(opts *RedactOptions) {
+	keep, err := parseKeep(opts.Keep)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	data, err := readSigdirFile(opts.Args.File)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(3)
+	}
+	redacted, err := redactArea(data, keep)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(opts.Out, redacted, 0640); err != nil {
+		fmt.Printf("ERROR writing %s: %s\n", opts.Out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Redacted %s written to %s\n", opts.Args.File, opts.Out)
+}
@@ -0,0 +1,77 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "bytes"
+import "strings"
+import "testing"
+
+func TestParseKeep(t *testing.T) {
+	keep, err := parseKeep([]string{"eui,board", "compliance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keep) != 3 || !keep[SIGNATURE_TYPE_EUI64] || !keep[SIGNATURE_TYPE_BOARD] || !keep[SIGNATURE_TYPE_COMPLIANCE] {
+		t.Errorf("--keep eui,board --keep compliance is %v", keep)
+	}
+	if _, err := parseKeep([]string{"eui,serial"}); err == nil {
+		t.Errorf("--keep serial is not a record type")
+	}
+}
+
+// The redacted area has the layout and valid CRCs of the original, the
+// records not kept lose their serial numbers.
+func TestRedactArea(t *testing.T) {
+	area := append(testArea(t, 0x70B3D5D72F000001), bytes.Repeat([]byte{0xFF}, 8)...)
+	keep, _ := parseKeep([]string{"eui,platform"})
+	var redacted []byte
+	var err error
+	out := captureStdout(t, func() { redacted, err = redactArea(area, keep) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redacted) != len(area) || !bytes.Equal(redacted[len(redacted)-8:], bytes.Repeat([]byte{0xFF}, 8)) {
+		t.Fatalf("redacted area is %d bytes, want %d ending in the padding", len(redacted), len(area))
+	}
+	for _, line := range []string{"record 0 eui64          kept", "record 1 board          zeroed serial_number", "record 2 platform       kept"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("no %q in\n%s", line, out)
+		}
+	}
+
+	sigs, err := readSigs(redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 4 {
+		t.Fatalf("%d records in the redacted area", len(sigs))
+	}
+	if e := sigs[0].(EUISignature); e.Eui64 != 0x70B3D5D72F000001 {
+		t.Errorf("kept EUI-64 is %016X", e.Eui64)
+	}
+	for i, zeroed := range []bool{true, false, true} {
+		c := sigs[i+1].(ComponentSignature)
+		if zeroed != (c.Serial_number == [16]byte{}) {
+			t.Errorf("record %d %s has serial number %x", i+1, c.BoardName(), c.Serial_number)
+		}
+	}
+}
+
+func TestRedactJunk(t *testing.T) {
+	area := append(testArea(t, 0x70B3D5D72F000001), 0xFF, 0x01, 0xFF)
+	var redacted []byte
+	captureStdout(t, func() { redacted, _ = redactArea(area, map[uint8]bool{}) })
+	if !bytes.Equal(redacted[len(redacted)-3:], []byte{0xFF, 0x00, 0xFF}) {
+		t.Errorf("bytes after the records are %x", redacted[len(redacted)-3:])
+	}
+
+	area = testArea(t, 0x70B3D5D72F000001)
+	area[24+4] = 0xF0 // The size of the board record
+	captureStdout(t, func() {
+		_, err := redactArea(area, map[uint8]bool{})
+		if err == nil {
+			t.Errorf("a broken record is redacted")
+		}
+	})
+}
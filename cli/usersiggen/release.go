@@ -0,0 +1,167 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bufio"
+import "strings"
+import "time"
+import "path/filepath"
+
+// Releasing the EUI of a board that was scrapped after provisioning, before
+// it left the factory:
+//
+//	usersiggen --release-eui 0011223344556677 --euifile eui.txt [--release-archive]
+//
+// The mark is removed from the pool, so the EUI is handed out again, and the
+// signature file is moved aside like a replaced file. With --release-archive
+// it is kept as a .tnsig archive in the sigdir instead. A retired EUI went to
+// a device that shipped and is never released.
+
+// releasableEntry returns the pool entry of an EUI that can be released.
+func releasableEntry(infile string, eui eui64) (*PoolEntry, error) {
+	entries, err := readPool(infile)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Eui != eui {
+			continue
+		}
+		switch e.Status {
+		case POOL_USED:
+			return &e, nil
+		case POOL_RETIRED:
+			return nil, fmt.Errorf("%016X is retired in %s, a decommissioned EUI is not released", eui, infile)
+		default:
+			return nil, fmt.Errorf("%016X is %s in %s, only used EUIs can be released", eui, e.Status, infile)
+		}
+	}
+	return nil, fmt.Errorf("%016X is not in %s", eui, infile)
+}
+
+// releaseEui removes the mark of a used EUI from the pool.
+func releaseEui(infile string, eui eui64) error {
+	if g_eui_registry != nil {
+		return g_eui_registry.Release(eui)
+	}
+	infile, err := filepath.Abs(infile)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	outfile := filepath.Join(filepath.Dir(infile), fmt.Sprintf("eui_temp_%d.txt", time.Now().UnixNano()))
+	out, err := os.OpenFile(outfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	writer := bufio.NewWriter(out)
+
+	released := false
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) > 0 && !strings.HasPrefix(t, "#") {
+			splits := strings.Split(t, ",")
+			if val, err := parseEui(splits[0]); err == nil && val == eui && !released {
+				t = splits[0] + ","
+				released = true
+			}
+			writer.WriteString(t)
+		} else {
+			writer.WriteString(scanner.Text())
+		}
+		writer.WriteString("\n")
+	}
+	problem := scanner.Err()
+	if problem == nil && !released {
+		problem = fmt.Errorf("%016X is not in %s", eui, infile)
+	}
+	if problem == nil {
+		problem = writer.Flush()
+	}
+	if problem != nil {
+		// A partial read must not replace the pool
+		os.Remove(outfile)
+		return problem
+	}
+
+	in.Close()
+	out.Close()
+
+	if err := rotateBackup(infile); err != nil {
+		return err
+	}
+	return os.Rename(outfile, infile)
+}
+
+func releaseMain(opts *Options) error {
+	eui, err := parseEui(opts.ReleaseEui)
+	if err != nil {
+		return err
+	}
+	if taken, err := lockPool(opts.Euifile); err != nil {
+		return err
+	} else if taken {
+		defer unlockPool(opts.Euifile)
+	}
+	if _, err := recoverClaims(opts.Euifile, false); err != nil {
+		return err
+	}
+
+	entry, err := releasableEntry(opts.Euifile, eui)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMarks(opts.Euifile)
+	if err != nil {
+		return err
+	}
+	if _, queued := pending[eui]; queued {
+		return fmt.Errorf("a mark of %016X is queued in %s, replay or drop it first", eui, g_mark_journal)
+	}
+
+	sigfile := entry.Sigfile
+	if len(sigfile) == 0 {
+		sigfile = filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui))
+	}
+	_, err = os.Stat(sigfile)
+	found := err == nil
+	if found && opts.ReleaseArchive {
+		out := filepath.Join(filepath.Dir(sigfile), fmt.Sprintf("EUI-64_%016X_released_%d.tnsig", eui, time.Now().UTC().Unix()))
+		pack := ArchivePackOptions{Out: out}
+		pack.Args.File = sigfile
+		if err := archivePack(&pack); err != nil {
+			return err
+		}
+	}
+
+	if err := releaseEui(opts.Euifile, eui); err != nil {
+		return err
+	}
+	fmt.Printf("%016X released, it was marked %s\n", eui, entry.Mark)
+
+	if !found {
+		fmt.Printf("No signature file for %016X at %s\n", eui, sigfile)
+		return nil
+	}
+	if opts.ReleaseArchive {
+		err = os.Remove(sigfile)
+	} else {
+		err = rotateBackup(sigfile)
+	}
+	if err != nil {
+		return fmt.Errorf("%016X is free but %s is still there, move it away before the EUI is allocated again: %s", eui, sigfile, err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+import "bytes"
+import "encoding/json"
+import "encoding/binary"
+
+import "go.thinnect.net/euisig/signature"
+
+// --format json is for the systems that call the generator: instead of the
+// text, like "EUI-64: %016X", a generation prints one JSON object with the EUI
+// and every file it wrote, with the size and the records of each, and a batch
+// prints an array of them. The text goes to stderr then, so stdout is only the
+// result. A run that fails prints no result and exits with its usual code.
+
+const FORMAT_TEXT = "text"
+const FORMAT_JSON = "json"
+
+type ResultRecord struct {
+	Type      string `json:"type"`
+	Offset    int    `json:"offset"`
+	Size      int    `json:"size"`
+	Timestamp string `json:"timestamp"`
+	Crc       string `json:"crc"`
+}
+
+type ResultFile struct {
+	Role    string         `json:"role"` // out, sigfile, srec or cheader
+	Path    string         `json:"path"`
+	Size    int64          `json:"size"`
+	Records []ResultRecord `json:"records,omitempty"`
+}
+
+type GenerationResult struct {
+	Type          string       `json:"type"`
+	Eui           string       `json:"eui64,omitempty"`
+	Generated     string       `json:"generated"`
+	WorkOrder     string       `json:"work_order,omitempty"`
+	Sequence      uint32       `json:"sequence,omitempty"`
+	Panel         string       `json:"panel,omitempty"`
+	PanelPosition uint32       `json:"panel_position,omitempty"`
+	Files         []ResultFile `json:"files"`
+
+	sigfile string
+}
+
+// The result of the running generation, filled in as it goes
+var g_result GenerationResult
+
+// Where the result is printed, stdout before the text was moved to stderr
+var g_result_out = os.Stdout
+
+func setResultFormat(format string) {
+	if format == FORMAT_JSON {
+		g_result_out = os.Stdout
+		os.Stdout = os.Stderr
+	}
+}
+
+// resultRecords lists the records of a signature area.
+func resultRecords(data []byte) []ResultRecord {
+	records, _ := verifyArea(data)
+	result := make([]ResultRecord, 0, len(records))
+	for _, r := range records {
+		var base BaseSignature
+		if signature.IsCborRecord(data[r.offset:]) {
+			base, _ = cborBase(data[r.offset:])
+		} else {
+			binary.Read(bytes.NewReader(data[r.offset:]), signature.RecordOrder(data[r.offset:]), &base)
+		}
+		rr := ResultRecord{Type: r.name, Offset: r.offset, Size: r.size,
+			Timestamp: TimestampString(time.Unix(base.Unix_time, 0).UTC())}
+		if end := r.offset + r.size; end <= len(data) && r.size >= signature.CheckSize(data[r.offset:]) {
+			rr.Crc = fmt.Sprintf("%X", data[end-signature.CheckSize(data[r.offset:]):end])
+		}
+		result = append(result, rr)
+	}
+	return result
+}
+
+// resultFile describes a file the run wrote, the records only for signature
+// areas.
+func resultFile(role string, path string, area bool) (ResultFile, error) {
+	f := ResultFile{Role: role, Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return f, err
+	}
+	f.Size = info.Size()
+	if area {
+		data, err := readSigdirFile(path)
+		if err != nil {
+			return f, err
+		}
+		f.Records = resultRecords(data)
+	}
+	return f, nil
+}
+
+// completeResult adds the files of the finished generation to the result.
+func completeResult(opts *Options) (GenerationResult, error) {
+	r := g_result
+	if len(r.Type) == 0 {
+		r.Type = opts.Type
+	}
+	if len(r.Eui) == 0 {
+		if data, err := readSigdirFile(opts.Output); err == nil {
+			if eui, err := areaEui(data); err == nil {
+				r.Eui = fmt.Sprintf("%016X", eui)
+			}
+		}
+	}
+
+	r.Files = make([]ResultFile, 0)
+	f, err := resultFile("out", opts.Output, true)
+	if err != nil {
+		return r, err
+	}
+	r.Files = append(r.Files, f)
+	if len(r.sigfile) > 0 {
+		if f, err = resultFile("sigfile", r.sigfile, true); err != nil {
+			return r, err
+		}
+		r.Files = append(r.Files, f)
+	}
+	switch opts.OutFormat {
+	case "srec":
+		f, err = resultFile("srec", formattedName(opts.Output, ".srec"), false)
+	case "cheader":
+		f, err = resultFile("cheader", formattedName(opts.Output, ".h"), false)
+	default:
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	r.Files = append(r.Files, f)
+	return r, nil
+}
+
+func printResult(result interface{}) {
+	j, _ := json.MarshalIndent(result, "", "\t")
+	fmt.Fprintln(g_result_out, string(j))
+}
+
+// resultMain prints the result of a finished generation for --format json.
+func resultMain(opts *Options) {
+	if opts.Format != FORMAT_JSON {
+		return
+	}
+	r, err := completeResult(opts)
+	if err != nil {
+		fmt.Printf("ERROR result: %s\n", err)
+		os.Exit(1)
+	}
+	printResult(r)
+}
@@ -0,0 +1,101 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "sort"
+import "time"
+import "strings"
+import "path/filepath"
+
+// Files that get replaced, signature files, pool files and panel manifests,
+// are moved aside to timestamped backups first, <file>.<time>.<n>.bak, n
+// counts the backups of the same millisecond so the names sort by age. Only
+// the newest --keep-backups are kept, the old single <file>.bak counts as the
+// oldest. A pool marked many times in one run is backed up once, before the
+// first mark.
+
+const DEFAULT_KEEP_BACKUPS = 5
+
+var g_keep_backups = DEFAULT_KEEP_BACKUPS
+
+// Files already backed up by this invocation.
+var g_rotated = make(map[string]bool)
+
+// backupName returns the name of a new backup of a file made at t, it comes
+// after the backups of the same millisecond that are still kept.
+func backupName(path string, t time.Time) string {
+	stamp := fmt.Sprintf("%s.%s", path, t.UTC().Format("20060102T150405.000Z"))
+	n := 0
+	if same, _ := filepath.Glob(stamp + ".*.bak"); len(same) > 0 {
+		sort.Strings(same)
+		fmt.Sscanf(strings.TrimPrefix(same[len(same)-1], stamp+"."), "%d", &n)
+		n++
+	}
+	return fmt.Sprintf("%s.%03d.bak", stamp, n)
+}
+
+// listBackups returns the backups of a file, oldest first.
+func listBackups(path string) ([]string, error) {
+	found, err := filepath.Glob(path + ".*.bak")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(found)
+	if _, err := os.Stat(path + ".bak"); err == nil {
+		found = append([]string{path + ".bak"}, found...)
+	}
+	return found, nil
+}
+
+// pruneBackups removes all but the keep newest backups of a file.
+func pruneBackups(path string, keep int) error {
+	found, err := listBackups(path)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(found)-keep; i++ {
+		if err := os.Remove(found[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateBackup moves an existing file aside before it is replaced, or removes
+// it when no backups are kept. A missing file is not an error.
+func rotateBackup(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	if g_keep_backups <= 0 {
+		return os.Remove(path)
+	}
+
+	if err := os.Rename(path, backupName(path, time.Now())); err != nil {
+		return err
+	}
+	return pruneBackups(path, g_keep_backups)
+}
+
+// rotateBackupOnce backs up a file the first time it is replaced by this
+// invocation, later replacements overwrite it without a new backup.
+func rotateBackupOnce(path string) error {
+	if g_rotated[path] {
+		return nil
+	}
+	if err := rotateBackup(path); err != nil {
+		return err
+	}
+	g_rotated[path] = true
+	return nil
+}
+
+func setKeepBackups(keep *int) {
+	if keep != nil {
+		g_keep_backups = *keep
+	}
+}
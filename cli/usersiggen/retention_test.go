@@ -0,0 +1,77 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "testing"
+import "path/filepath"
+
+func withKeepBackups(t *testing.T, keep int) {
+	keep_backups, rotated := g_keep_backups, g_rotated
+	g_keep_backups, g_rotated = keep, make(map[string]bool)
+	t.Cleanup(func() {
+		g_keep_backups, g_rotated = keep_backups, rotated
+	})
+}
+
+// Marking a pool several times in one run leaves one backup, the pool as it
+// was before the run.
+func TestMarksRotateOnce(t *testing.T) {
+	withKeepBackups(t, DEFAULT_KEEP_BACKUPS)
+	pool := filepath.Join(t.TempDir(), "euis.txt")
+	original := "# pool\n70B3D5D72F000001\n70B3D5D72F000002\n70B3D5D72F000003\n"
+	if err := os.WriteFile(pool, []byte(original), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, e := range []eui64{0x70B3D5D72F000001, 0x70B3D5D72F000002, 0x70B3D5D72F000003} {
+		if err := writeMark(pool, e, "BOARD", int64(1700000000+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := listBackups(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("%d backups of the pool, want 1: %v", len(backups), backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != original {
+		t.Errorf("backup is\n%s\nwant\n%s", data, original)
+	}
+	want := "# pool\n70B3D5D72F000001,BOARD\n70B3D5D72F000002,BOARD\n70B3D5D72F000003,BOARD\n"
+	if data, _ := os.ReadFile(pool); string(data) != want {
+		t.Errorf("pool is\n%s\nwant\n%s", data, want)
+	}
+}
+
+// Only --keep-backups of the backups of a file are kept, none with 0.
+func TestKeepBackups(t *testing.T) {
+	for _, keep := range []int{0, 2, DEFAULT_KEEP_BACKUPS} {
+		withKeepBackups(t, keep)
+		file := filepath.Join(t.TempDir(), "sigdata.bin")
+		for i := 0; i < 7; i++ {
+			if err := os.WriteFile(file, []byte{byte(i)}, 0660); err != nil {
+				t.Fatal(err)
+			}
+			if err := rotateBackup(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backups, err := listBackups(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) != keep {
+			t.Errorf("%d backups kept with --keep-backups %d", len(backups), keep)
+			continue
+		}
+		if keep > 0 {
+			if data, _ := os.ReadFile(backups[keep-1]); len(data) != 1 || data[0] != 6 {
+				t.Errorf("newest backup is %v, want [6]", data)
+			}
+		}
+	}
+}
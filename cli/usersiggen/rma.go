@@ -0,0 +1,330 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bufio"
+import "bytes"
+import "errors"
+import "strings"
+import "time"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/hex"
+import "encoding/json"
+import "encoding/pem"
+import "crypto/ed25519"
+import "crypto/sha256"
+import "crypto/x509"
+
+import "go.thinnect.net/euisig/signature"
+
+// A warranty swap moves the identity of a device to a replacement board. The
+// signature area is generated again with the EUI, serial number, licenses and
+// the other records of the original, only the board record describes the new
+// board. A supervisor signs off the transfer with their key, the audit log
+// records who and why, and the hash of the old signature area goes to the
+// blacklist so the old board can not pass as the device anymore.
+
+const SCHEMA_RMA = "euisiggen/rma/v1"
+
+type RmaTransferOptions struct {
+	Eui          string `long:"eui"            required:"true" description:"EUI-64 of the device."`
+	Sigdir       string `long:"sigdir"         required:"true" description:"Directory of the device signatures, EUI-64_<eui>.bin is replaced."`
+	Name         string `long:"name"           description:"Board name of the replacement, the original when not given."`
+	Version      string `long:"version"        description:"Board version of the replacement, MAJOR.MINOR.ASSEMBLY, the original when not given."`
+	Uuid         string `long:"uuid"           description:"Board UUID of the replacement, the original when not given."`
+	Manufacturer string `long:"manufacturer"   description:"Manufacturer UUID of the replacement, the original when not given."`
+	Reason       string `long:"reason"         required:"true" description:"Why the identity is moved, recorded in the audit log."`
+	Key          string `long:"supervisor-key" required:"true" description:"Ed25519 private key of the supervisor, PKCS#8 PEM."`
+	Supervisors  string `long:"supervisors"    required:"true" description:"PEM file with the public keys of the supervisors allowed to transfer."`
+	Audit        string `long:"audit"          default:"rma-audit.jsonl" description:"Audit log of the transfers."`
+	Blacklist    string `long:"blacklist"      default:"sig-blacklist.txt" description:"Blacklist of signature area hashes."`
+	Out          string `long:"out"            description:"Also write the new signature area to this file, for flashing."`
+}
+
+type RmaOptions struct {
+	Transfer RmaTransferOptions `command:"transfer" description:"Move the identity of a device to a replacement board."`
+}
+
+// RmaAudit is a record of the audit log, signed by the supervisor over the
+// JSON of the record with an empty signature field.
+type RmaAudit struct {
+	Schema     string    `json:"schema"`
+	Generator  string    `json:"generator"`
+	Time       time.Time `json:"time"`
+	Eui64      string    `json:"eui64"`
+	Reason     string    `json:"reason"`
+	Supervisor string    `json:"supervisor"` // Public key, hex
+	OldBoard   string    `json:"old_board"`
+	NewBoard   string    `json:"new_board"`
+	OldHash    string    `json:"old_sha256"`
+	NewHash    string    `json:"new_sha256"`
+	Signature  string    `json:"signature"`
+}
+
+func (self *RmaAudit) signed() []byte {
+	rec := *self
+	rec.Signature = ""
+	b, _ := json.Marshal(rec)
+	return b
+}
+
+// loadSupervisors reads the public keys of a PEM file with one or more keys.
+func loadSupervisors(file string) ([]ed25519.PublicKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]ed25519.PublicKey, 0)
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		if k, ok := key.(ed25519.PublicKey); ok {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no Ed25519 public keys", file)
+	}
+	return keys, nil
+}
+
+func checkSupervisor(key ed25519.PrivateKey, supervisors []ed25519.PublicKey) error {
+	pub := key.Public().(ed25519.PublicKey)
+	for _, s := range supervisors {
+		if bytes.Equal(pub, s) {
+			return nil
+		}
+	}
+	return errors.New("the key is not one of a supervisor, a transfer needs a supervisor")
+}
+
+func sigHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// readBlacklist returns the blacklisted signature area hashes, a missing
+// blacklist is empty. Every line is a hash followed by the EUI and the time
+// it was blacklisted.
+func readBlacklist(file string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	in, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return hashes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		t := strings.TrimSpace(scanner.Text())
+		if len(t) == 0 || strings.HasPrefix(t, "#") {
+			continue
+		}
+		fields := strings.Fields(t)
+		hashes[strings.ToLower(fields[0])] = strings.Join(fields[1:], " ")
+	}
+	return hashes, scanner.Err()
+}
+
+func appendLine(file string, line string) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// replacementBoard returns the board record of the replacement, the original
+// with the details given for the new board.
+func replacementBoard(gen *UserSignature, old ComponentSignature, opts *RmaTransferOptions, t time.Time) (*ComponentSignature, error) {
+	name := old.BoardName()
+	if len(opts.Name) > 0 {
+		name = opts.Name
+	}
+	version := BoardVersion{Major: old.Version_major, Minor: old.Version_minor, Assembly: old.Version_assembly}
+	if len(opts.Version) > 0 {
+		if err := version.UnmarshalFlag(opts.Version); err != nil {
+			return nil, fmt.Errorf("version %s: %s", opts.Version, err)
+		}
+	}
+	board, manufacturer := old.Component_uuid, old.Manufacturer_uuid
+	if len(opts.Uuid) > 0 {
+		u, err := parseUuid(opts.Uuid)
+		if err != nil {
+			return nil, fmt.Errorf("uuid %s: %s", opts.Uuid, err)
+		}
+		board = u
+	}
+	if len(opts.Manufacturer) > 0 {
+		u, err := parseUuid(opts.Manufacturer)
+		if err != nil {
+			return nil, fmt.Errorf("manufacturer %s: %s", opts.Manufacturer, err)
+		}
+		manufacturer = u
+	}
+
+	sig, err := gen.ConstructComponentSignature(t, name, version, board, manufacturer, old.Serial_number, old.Position, SIGNATURE_TYPE_BOARD)
+	if err != nil {
+		return nil, err
+	}
+	if err := policyComponent(sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func rmaTransfer(opts *RmaTransferOptions) error {
+	var gen UserSignature
+	now := time.Now().UTC()
+
+	eui, err := parseEui(opts.Eui)
+	if err != nil {
+		return err
+	}
+	key, err := loadSigningKey(opts.Key, "rma")
+	if err != nil {
+		return err
+	}
+	supervisors, err := loadSupervisors(opts.Supervisors)
+	if err != nil {
+		return err
+	}
+	if err := checkSupervisor(key, supervisors); err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(opts.Reason)) == 0 {
+		return errors.New("a transfer needs a reason for the audit log")
+	}
+
+	sigfile := filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X.bin", eui))
+	old, err := readSigdirFile(sigfile)
+	if err != nil {
+		return fmt.Errorf("no device signature for %016X in %s: %s", eui, opts.Sigdir, err)
+	}
+	blacklist, err := readBlacklist(opts.Blacklist)
+	if err != nil {
+		return err
+	}
+	if _, ok := blacklist[sigHash(old)]; ok {
+		return fmt.Errorf("%s is blacklisted, it was already transferred", sigfile)
+	}
+	if found, err := areaEui(old); err != nil || found != eui {
+		return fmt.Errorf("%s does not carry EUI %016X", sigfile, eui)
+	}
+
+	// The records are copied as they are, only the board record is replaced
+	area := make([]byte, 0, len(old))
+	var oldBoard, newBoard string
+	for _, rec := range sigRecords(old) {
+		if recordType(rec) == SIGNATURE_TYPE_BOARD && len(newBoard) == 0 {
+			sigs, err := gen.DeserializeArea(rec)
+			if err != nil {
+				return fmt.Errorf("board record of %s: %s", sigfile, err)
+			}
+			s := sigs[0]
+			c, _ := signature.ComponentOf(s)
+			board, err := replacementBoard(&gen, c, opts, now)
+			if err != nil {
+				return err
+			}
+			var bsig interface{} = board
+			if d, ok := s.(ComponentDataSignature); ok {
+				if bsig, err = gen.AttachData(board, d.Data); err != nil {
+					return err
+				}
+			}
+			if rec, err = gen.Serialize(bsig); err != nil {
+				return err
+			}
+			oldBoard = fmt.Sprintf("%s %s %s", c.BoardName(), c.BoardVersion(), uuidString(c.Component_uuid))
+			newBoard = fmt.Sprintf("%s %s %s", board.BoardName(), board.BoardVersion(), uuidString(board.Component_uuid))
+		}
+		area = append(area, rec...)
+	}
+	if len(newBoard) == 0 {
+		return fmt.Errorf("%s has no board record", sigfile)
+	}
+
+	audit := RmaAudit{
+		Schema:     SCHEMA_RMA,
+		Generator:  generatorVersion(),
+		Time:       now,
+		Eui64:      fmt.Sprintf("%016X", eui),
+		Reason:     opts.Reason,
+		Supervisor: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		OldBoard:   oldBoard,
+		NewBoard:   newBoard,
+		OldHash:    sigHash(old),
+		NewHash:    sigHash(area),
+	}
+	audit.Signature = hex.EncodeToString(ed25519.Sign(key, audit.signed()))
+	j, err := json.Marshal(audit)
+	if err != nil {
+		return err
+	}
+
+	// The old area is kept next to the new one for the record
+	kept := filepath.Join(opts.Sigdir, fmt.Sprintf("EUI-64_%016X_rma_%d.bin", eui, now.Unix()))
+	if err := os.Rename(sigfile, kept); err != nil {
+		return err
+	}
+	if err := writeSigdirFile(sigfile, area, 0440); err != nil {
+		os.Rename(kept, sigfile)
+		return err
+	}
+	if err := appendLine(opts.Blacklist, fmt.Sprintf("%s %016X %d", audit.OldHash, eui, now.Unix())); err != nil {
+		return fmt.Errorf("%s written, blacklisting failed: %s", sigfile, err)
+	}
+	if err := appendLine(opts.Audit, string(j)); err != nil {
+		return fmt.Errorf("%s written, audit log failed: %s", sigfile, err)
+	}
+
+	if len(opts.Out) > 0 {
+		if err := ioutil.WriteFile(opts.Out, area, 0640); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Transferred %016X from %s to %s\n", eui, oldBoard, newBoard)
+	fmt.Printf("Old signature %s blacklisted, kept as %s\n", audit.OldHash, kept)
+	return nil
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "rma",
+		short:   "Warranty swaps",
+		long:    "Move the identity of a device to a replacement board, signed off by a supervisor, audited, the old signature blacklisted.",
+		options: func() interface{} { return new(RmaOptions) },
+		run:     func(c *commandRun) { rmaMain(c.command, c.options.(*RmaOptions)) },
+	})
+}
+
+func rmaMain(command string, opts *RmaOptions) {
+	var err error
+	switch command {
+	case "transfer":
+		err = rmaTransfer(&opts.Transfer)
+	}
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,99 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "bytes"
+import "strings"
+import "testing"
+import "encoding/hex"
+import "encoding/json"
+import "crypto/ed25519"
+import "path/filepath"
+
+// The replacement board carries the identity, the old area is blacklisted and
+// the transfer audited.
+func TestRmaTransfer(t *testing.T) {
+	dir := t.TempDir()
+	key, pub := writeTestKeys(t, dir, "supervisor")
+	other, otherpub := writeTestKeys(t, dir, "technician")
+	supervisors, err := os.ReadFile(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigdir := filepath.Join(dir, "sigdata")
+	old := testArea(t, 0x70B3D5D72F000001)
+	sigfile := writeTestFile(t, sigdir, "EUI-64_70B3D5D72F000001.bin", old)
+	opts := &RmaTransferOptions{
+		Eui:         "70B3D5D72F000001",
+		Sigdir:      sigdir,
+		Version:     "2.0.0",
+		Reason:      "RMA-118 radio failure",
+		Key:         key,
+		Supervisors: writeTestFile(t, dir, "supervisors.pem", supervisors),
+		Audit:       filepath.Join(dir, "rma-audit.jsonl"),
+		Blacklist:   filepath.Join(dir, "sig-blacklist.txt"),
+	}
+
+	opts.Key = other
+	if err := rmaTransfer(opts); err == nil || !strings.Contains(err.Error(), "not one of a supervisor") {
+		t.Errorf("a technician transferred: %v", err)
+	}
+	opts.Key = key
+	captureStdout(t, func() {
+		if err := rmaTransfer(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	area, err := os.ReadFile(sigfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := readSigs(area)
+	if err != nil || len(sigs) != 4 {
+		t.Fatalf("new area is %v: %v", sigs, err)
+	}
+	if e, ok := sigs[0].(EUISignature); !ok || e.Eui64 != 0x70B3D5D72F000001 {
+		t.Errorf("new area has %v for the EUI", sigs[0])
+	}
+	if b, ok := sigs[1].(ComponentSignature); !ok || b.BoardVersion() != "2.0.0" || b.Serial_number != sigs[2].(ComponentSignature).Serial_number {
+		t.Errorf("new board is %+v", sigs[1])
+	}
+	if !bytes.Equal(area[110:], old[110:]) {
+		t.Errorf("records after the board changed")
+	}
+
+	blacklist, err := readBlacklist(opts.Blacklist)
+	if _, ok := blacklist[sigHash(old)]; !ok || err != nil {
+		t.Errorf("blacklist is %v: %v", blacklist, err)
+	}
+	var audit RmaAudit
+	line, _ := os.ReadFile(opts.Audit)
+	if err := json.Unmarshal(line, &audit); err != nil {
+		t.Fatal(err)
+	}
+	supervisor, _ := loadEd25519Public(pub)
+	signature, _ := hex.DecodeString(audit.Signature)
+	if !ed25519.Verify(supervisor, audit.signed(), signature) || audit.NewHash != sigHash(area) || !strings.HasPrefix(audit.OldBoard, "tsb2 1.2.3 ") || !strings.HasPrefix(audit.NewBoard, "tsb2 2.0.0 ") {
+		t.Errorf("audit record is %+v", audit)
+	}
+
+	// The old board can not be transferred again
+	kept, _ := filepath.Glob(filepath.Join(sigdir, "EUI-64_70B3D5D72F000001_rma_*.bin"))
+	if len(kept) != 1 {
+		t.Fatalf("old areas kept are %v", kept)
+	}
+	os.Rename(kept[0], sigfile)
+	if err := rmaTransfer(opts); err == nil || !strings.Contains(err.Error(), "blacklisted") {
+		t.Errorf("a blacklisted area transferred: %v", err)
+	}
+
+	if _, err := loadSupervisors(otherpub); err != nil {
+		t.Error(err)
+	}
+	if _, err := loadSupervisors(other); err == nil {
+		t.Errorf("a private key is a supervisor")
+	}
+}
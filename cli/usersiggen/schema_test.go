@@ -0,0 +1,86 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "bytes"
+import "strings"
+import "testing"
+import "time"
+import "io/ioutil"
+import "encoding/json"
+
+import "go.thinnect.net/euisig/signature"
+
+// The v1 read-sig output is the output of the original generator, captured
+// from it in testdata/read-sig-v1, byte for byte.
+func TestReadSigV1Golden(t *testing.T) {
+	sigs, err := readSigsFromFile("testdata/read-sig-v1/sigdata.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("testdata/read-sig-v1/sigdata.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sigsToJson(sigs, SCHEMA_READ_SIG_V1) + "\n"; got != string(want) {
+		t.Errorf("v1 output differs from the original:\n%s", got)
+	}
+}
+
+// Records and fields added after v1 are left out of it, v2 has them.
+func TestReadSigV1Later(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/read-sig-v1/sigdata.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gen UserSignature
+	ts := time.Unix(1700000004, 0)
+	comp, err := gen.ConstructComponentSignature(ts, "sensor", BoardVersion{Major: 1}, tuuid{1}, tuuid{2}, tuuid{3}, 3, SIGNATURE_TYPE_COMPONENT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withdata, _ := gen.AttachData(comp, []byte{0xCA, 0x11})
+	pin, _ := gen.ConstructPinSignature(ts, "123456", signature.Salt{1})
+	area := bytes.NewBuffer(data)
+	for _, rec := range []interface{}{withdata, pin} {
+		b, err := gen.Serialize(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		area.Write(b)
+	}
+	sigs, err := readSigs(area.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v1 map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(sigsToJson(sigs, SCHEMA_READ_SIG_V1)), &v1); err != nil {
+		t.Fatal(err)
+	}
+	if len(v1) != len(g_read_sig_v1) {
+		t.Errorf("v1 has %d keys, want %d", len(v1), len(g_read_sig_v1))
+	}
+	for _, key := range g_read_sig_v1 {
+		if _, ok := v1[key]; !ok {
+			t.Errorf("v1 has no %s", key)
+		}
+	}
+	if c := string(v1["component_signatures"]); strings.Count(c, "component_uuid") != 2 || strings.Contains(c, `"data"`) {
+		t.Errorf("v1 components are not in the v1 layout: %s", c)
+	}
+
+	var v2 map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(sigsToJson(sigs, SCHEMA_READ_SIG_V2)), &v2); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"schema", "generator", "pin_signature"} {
+		if _, ok := v2[key]; !ok {
+			t.Errorf("v2 has no %s", key)
+		}
+	}
+	if !strings.Contains(string(v2["component_signatures"]), `"data"`) {
+		t.Errorf("v2 components have no data")
+	}
+}
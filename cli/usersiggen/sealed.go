@@ -0,0 +1,143 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bytes"
+import "errors"
+import "strings"
+import "io/ioutil"
+import "encoding/hex"
+import "crypto/aes"
+import "crypto/cipher"
+import "path/filepath"
+
+// Encryption at rest for the signature files kept in the sigdir. With a
+// station key configured the files are sealed with AES-256-GCM, readers open
+// them transparently when given the same key. Plaintext files keep working,
+// so a sigdir can be migrated gradually.
+//
+// Sealed file: magic | 12 byte nonce | ciphertext and tag
+
+var g_sealed_magic = []byte("TSIGSEAL")
+
+const SEALED_AAD = "euisiggen sigdir v1"
+
+var g_sigdir_key []byte
+
+// loadSigdirKey reads a 256 bit station key, either 32 raw bytes or 64 hex
+// digits.
+func loadSigdirKey(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 32 {
+		return b, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s is not a 256 bit key, 32 bytes or 64 hex digits expected", file)
+	}
+	return key, nil
+}
+
+func setSigdirKey(file string) error {
+	if len(file) == 0 {
+		return nil
+	}
+	key, err := loadSigdirKey(file)
+	if err != nil {
+		return err
+	}
+	g_sigdir_key = key
+	return nil
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, g_sealed_magic)
+}
+
+func sealData(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, g_sealed_magic...), nonce...)
+	return gcm.Seal(sealed, nonce, data, []byte(SEALED_AAD)), nil
+}
+
+func openSealed(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(g_sealed_magic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed file is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(SEALED_AAD))
+	if err != nil {
+		return nil, errors.New("sealed file cannot be opened, wrong key or corrupt")
+	}
+	return plain, nil
+}
+
+// writeSigdirFile writes a signature file into the sigdir, sealed when a
+// station key is configured.
+func writeSigdirFile(path string, data []byte, perm os.FileMode) error {
+	if g_sigdir_key != nil {
+		sealed, err := sealData(g_sigdir_key, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+	return ioutil.WriteFile(path, data, perm)
+}
+
+// readSigdirFile returns the plaintext of a signature file, sealed or not.
+func readSigdirFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !isSealed(data) {
+		return data, err
+	}
+	if g_sigdir_key == nil {
+		return nil, fmt.Errorf("%s is encrypted, the station key is needed (--sigdir-key)", path)
+	}
+	return openSealed(g_sigdir_key, data)
+}
+
+func fileIsSealed(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(g_sealed_magic))
+	n, _ := f.Read(head)
+	return isSealed(head[:n])
+}
+
+// scratchFile places intermediate plaintext images, merged firmware and read
+// back signature areas, outside the sigdir when the sigdir is encrypted.
+func scratchFile(sigdir string, name string) string {
+	if g_sigdir_key != nil {
+		return filepath.Join(os.TempDir(), name)
+	}
+	return filepath.Join(sigdir, name)
+}
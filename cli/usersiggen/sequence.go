@@ -0,0 +1,181 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "strings"
+import "strconv"
+import "errors"
+import "bufio"
+import "time"
+import "path/filepath"
+
+// Work order sequence numbers are kept in an append-only log per work order,
+// <seqdir>/<work order>.seq, one "sequence,EUI,unix_time[,released]" line per
+// board. A number is only logged once the sigdata for it has been written, so
+// a run that is interrupted before that hands the same number to the retried
+// board. A partially written last line is ignored.
+
+func sequenceFile(dir string, workorder string) (string, error) {
+	if len(workorder) == 0 || strings.ContainsAny(workorder, "/\\:,") {
+		return "", fmt.Errorf("Work order '%s' is not usable as a file name", workorder)
+	}
+	return filepath.Join(dir, workorder+".seq"), nil
+}
+
+func readSequences(dir string, workorder string) (map[uint32]string, error) {
+	active := make(map[uint32]string)
+
+	fname, err := sequenceFile(dir, workorder)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return active, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(bufio.NewReader(in))
+	for scanner.Scan() {
+		splits := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+		if len(splits) < 3 {
+			continue
+		}
+		seq, err := strconv.ParseUint(splits[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		if len(splits) > 3 && splits[3] == "released" {
+			delete(active, uint32(seq))
+		} else {
+			active[uint32(seq)] = splits[1]
+		}
+	}
+	return active, scanner.Err()
+}
+
+// nextSequence returns the sequence number the next board of the work order
+// gets, numbering starts from 1. A size of 0 means the work order has no
+// upper limit.
+func nextSequence(dir string, workorder string, size uint32) (uint32, error) {
+	active, err := readSequences(dir, workorder)
+	if err != nil {
+		return 0, err
+	}
+
+	var last uint32
+	for seq := range active {
+		if seq > last {
+			last = seq
+		}
+	}
+
+	if size > 0 && last >= size {
+		return 0, fmt.Errorf("Work order %s is complete, all %d sequence numbers used", workorder, size)
+	}
+	return last + 1, nil
+}
+
+func appendSequence(dir string, workorder string, line string) error {
+	fname, err := sequenceFile(dir, workorder)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// commitSequence records that seq of the work order was used for the board
+// with the given identity (EUI or timestamp based file name).
+func commitSequence(dir string, workorder string, seq uint32, identity string) error {
+	active, err := readSequences(dir, workorder)
+	if err != nil {
+		return err
+	}
+	if prev, ok := active[seq]; ok {
+		return fmt.Errorf("Sequence %d of work order %s is already used by %s", seq, workorder, prev)
+	}
+	return appendSequence(dir, workorder, fmt.Sprintf("%d,%s,%d", seq, identity, time.Now().UTC().Unix()))
+}
+
+// releaseSequence returns seq to the work order, for when the generated board
+// signature is discarded.
+func releaseSequence(dir string, workorder string, seq uint32, identity string) error {
+	return appendSequence(dir, workorder, fmt.Sprintf("%d,%s,%d,released", seq, identity, time.Now().UTC().Unix()))
+}
+
+// sequenceSerial formats the serial number string embedded in the board
+// signature, format gets the work order and the sequence number.
+func sequenceSerial(format string, workorder string, seq uint32) ([16]byte, error) {
+	var serial [16]byte
+	s := fmt.Sprintf(format, workorder, seq)
+	if len(s) > len(serial) {
+		return serial, errors.New(fmt.Sprintf("Sequence serial '%s' is too long(%d), max %d characters", s, len(s), len(serial)))
+	}
+	copy(serial[:], s)
+	return serial, nil
+}
+
+// Boards are manufactured in panels, consecutive sequence numbers of a work
+// order fill the positions of a panel in order.
+func panelOf(seq uint32, size uint32) (uint32, uint32) {
+	return (seq-1)/size + 1, (seq-1)%size + 1
+}
+
+func panelId(workorder string, panel uint32) string {
+	return fmt.Sprintf("%s-P%04d", workorder, panel)
+}
+
+// writePanelIndex rewrites <seqdir>/<work order>-P<panel>.txt listing the
+// EUIs on the panel in position order, empty positions have no EUI.
+func writePanelIndex(dir string, workorder string, panel uint32, size uint32) (string, error) {
+	active, err := readSequences(dir, workorder)
+	if err != nil {
+		return "", err
+	}
+
+	fname := filepath.Join(dir, panelId(workorder, panel)+".txt")
+	tmp := fname + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	writer := bufio.NewWriter(out)
+	writer.WriteString(fmt.Sprintf("# Panel %s, %d positions\n", panelId(workorder, panel), size))
+	for pos := uint32(1); pos <= size; pos++ {
+		writer.WriteString(fmt.Sprintf("%d,%s\n", pos, active[(panel-1)*size+pos]))
+	}
+	if err := writer.Flush(); err != nil {
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		return "", err
+	}
+	out.Close()
+
+	if err := rotateBackup(fname); err != nil {
+		return "", err
+	}
+	return fname, os.Rename(tmp, fname)
+}
@@ -0,0 +1,53 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "go.thinnect.net/euisig/signature"
+
+// The records and their serialization live in the signature package, the
+// generator refers to them by the names it has always used.
+
+const SIGNATURE_TYPE_EUI64 = signature.SIGNATURE_TYPE_EUI64
+const SIGNATURE_TYPE_BOARD = signature.SIGNATURE_TYPE_BOARD
+const SIGNATURE_TYPE_PLATFORM = signature.SIGNATURE_TYPE_PLATFORM
+const SIGNATURE_TYPE_COMPONENT = signature.SIGNATURE_TYPE_COMPONENT
+const SIGNATURE_TYPE_LICENSE = signature.SIGNATURE_TYPE_LICENSE
+const SIGNATURE_TYPE_COMPLIANCE = signature.SIGNATURE_TYPE_COMPLIANCE
+const SIGNATURE_TYPE_EXTENDED_ID = signature.SIGNATURE_TYPE_EXTENDED_ID
+const SIGNATURE_TYPE_DECOMMISSION = signature.SIGNATURE_TYPE_DECOMMISSION
+const SIGNATURE_TYPE_AREA_SIGNATURE = signature.SIGNATURE_TYPE_AREA_SIGNATURE
+const SIGNATURE_TYPE_PIN = signature.SIGNATURE_TYPE_PIN
+const SIGNATURE_TYPE_LICENSE_V2 = signature.SIGNATURE_TYPE_LICENSE_V2
+
+const MAX_SIGNATURE_LENGTH = signature.MAX_SIGNATURE_LENGTH
+const MAX_PIN_LENGTH = signature.MAX_PIN_LENGTH
+
+type UserSignature = signature.Generator
+
+type eui64 = signature.Eui64
+type tuuid = signature.Uuid
+type tname = signature.Name
+type tcountry = signature.Country
+type tcert = signature.Cert
+type tregions = signature.Regions
+type tdata = signature.Data
+type tslot = signature.Slot
+type tid128 = signature.Id128
+type treason = signature.Reason
+type tsalt = signature.Salt
+
+type BaseSignature = signature.BaseSignature
+type EUISignature = signature.EUISignature
+type ComponentSignature = signature.ComponentSignature
+type ComponentDataSignature = signature.ComponentDataSignature
+type ComplianceSignature = signature.ComplianceSignature
+type ComplianceInfo = signature.ComplianceInfo
+type LicenseSignature = signature.LicenseSignature
+type LicenseV2Signature = signature.LicenseV2Signature
+type LicenseWindow = signature.LicenseWindow
+type ExtendedIdSignature = signature.ExtendedIdSignature
+type DecommissionSignature = signature.DecommissionSignature
+type AreaSignature = signature.AreaSignature
+type PinSignature = signature.PinSignature
+type BoardVersion = signature.BoardVersion
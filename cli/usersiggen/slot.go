@@ -0,0 +1,87 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "strconv"
+import "strings"
+
+import "go.thinnect.net/euisig/signature"
+
+// Slots name the places components are fitted to on a platform, the component
+// record carries the hash of the slot name and the names come from the
+// platform definition.
+
+// SlotDefinition names a slot of a platform and the component positions that
+// are valid in it, "0-3" or "0,2,4", any position when empty.
+type SlotDefinition struct {
+	Name      string `yaml:"name"`
+	Positions string `yaml:"positions"`
+}
+
+func (self SlotDefinition) allows(position uint8) (bool, error) {
+	if len(strings.TrimSpace(self.Positions)) == 0 {
+		return true, nil
+	}
+	for _, r := range strings.Split(self.Positions, ",") {
+		r = strings.TrimSpace(r)
+		lo, hi := r, r
+		if i := strings.Index(r, "-"); i > 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		l, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 8)
+		if err != nil {
+			return false, fmt.Errorf("Invalid positions %s of slot %s", self.Positions, self.Name)
+		}
+		h, err := strconv.ParseUint(strings.TrimSpace(hi), 10, 8)
+		if err != nil {
+			return false, fmt.Errorf("Invalid positions %s of slot %s", self.Positions, self.Name)
+		}
+		if uint64(position) >= l && uint64(position) <= h {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (def *PlatformDefinition) findSlot(name string) *SlotDefinition {
+	for i := range def.Slots {
+		if def.Slots[i].Name == name {
+			return &def.Slots[i]
+		}
+	}
+	return nil
+}
+
+// checkSlot verifies that the slot is defined and the position is valid in it.
+func (def *PlatformDefinition) checkSlot(name string, position uint8) error {
+	slot := def.findSlot(name)
+	if slot == nil {
+		return fmt.Errorf("Slot %s is not defined for platform %s", name, def.Name)
+	}
+	ok, err := slot.allows(position)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Position %d is not valid in slot %s, valid positions are %s", position, name, slot.Positions)
+	}
+	return nil
+}
+
+// useSlotNames makes the slot names of a platform definition known for
+// displaying records.
+func useSlotNames(file string) error {
+	if len(file) == 0 {
+		return nil
+	}
+	def, err := loadPlatformDefinition(file)
+	if err != nil {
+		return err
+	}
+	for _, s := range def.Slots {
+		signature.SlotNames[signature.SlotHash(s.Name)] = s.Name
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "os/exec"
+import "fmt"
+import "strings"
+import "errors"
+import "encoding/json"
+import "bytes"
+import "time"
+import "context"
+
+// Stage is a single step of the provisioning pipeline. Run must leave the
+// device untouched when it fails, Rollback undoes a successful Run when a
+// later stage fails.
+type Stage interface {
+	Name() string
+	Run(ctx context.Context, dev *ProvisionDevice) error
+	Rollback(ctx context.Context, dev *ProvisionDevice) error
+}
+
+// Returned by Rollback when the stage has made the device permanent, stages
+// before it are then not rolled back either.
+var errStageCommitted = errors.New("stage is committed and can not be rolled back")
+
+// PluginConfig declares an external stage in a profile. The plugin command
+// gets the device as JSON on stdin, with the decoded records and artifacts of
+// its identity context once it is built, and THINNECT_STAGE / THINNECT_EUI in
+// the environment, a non-zero exit status fails the stage. The last line written
+// to stdout is used as the stage result detail.
+type PluginConfig struct {
+	Name  string `json:"name"`
+	After string `json:"after"` // Built-in stage to run after, plugins without it run last
+	StageConfig
+}
+
+type builtinStage struct {
+	def     builtinStageDef
+	profile *ProvisionProfile
+	cfg     StageConfig
+}
+
+func (s *builtinStage) Name() string {
+	return s.def.name
+}
+
+func (s *builtinStage) Run(ctx context.Context, dev *ProvisionDevice) error {
+	detail, err := s.def.run(ctx, s.profile, s.cfg, dev)
+	if err == nil {
+		dev.Note("%s", detail)
+	}
+	return err
+}
+
+func (s *builtinStage) Rollback(ctx context.Context, dev *ProvisionDevice) error {
+	if s.def.rollback == nil {
+		return nil
+	}
+	_, err := s.def.rollback(ctx, s.profile, s.cfg, dev)
+	return err
+}
+
+type pluginStage struct {
+	cfg PluginConfig
+}
+
+func (s *pluginStage) Name() string {
+	return s.cfg.Name
+}
+
+func (s *pluginStage) exec(ctx context.Context, command []string, dev *ProvisionDevice) (string, error) {
+	input, err := json.Marshal(dev)
+	if err != nil {
+		return "", err
+	}
+
+	args := make([]string, len(command))
+	for i, a := range command {
+		args[i] = dev.expand(a)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"THINNECT_STAGE="+s.cfg.Name,
+		fmt.Sprintf("THINNECT_EUI=%016X", dev.Eui))
+
+	err = cmd.Run()
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) == 0 {
+			msg = lines[len(lines)-1]
+		}
+		return "", fmt.Errorf("%s: %s %s", args[0], err, msg)
+	}
+	return lines[len(lines)-1], nil
+}
+
+func (s *pluginStage) Run(ctx context.Context, dev *ProvisionDevice) error {
+	detail, err := s.exec(ctx, s.cfg.Command, dev)
+	if err == nil {
+		dev.Note("%s", detail)
+	}
+	return err
+}
+
+func (s *pluginStage) Rollback(ctx context.Context, dev *ProvisionDevice) error {
+	if len(s.cfg.Rollback) == 0 {
+		return nil
+	}
+	_, err := s.exec(ctx, s.cfg.Rollback, dev)
+	return err
+}
+
+func (s *pluginStage) timeout() int {
+	return s.cfg.Timeout
+}
+
+func (s *builtinStage) timeout() int {
+	return s.cfg.Timeout
+}
+
+// buildStages puts together the built-in stages and the plugins declared in
+// the profile, in execution order.
+func buildStages(profile *ProvisionProfile) []Stage {
+	stages := make([]Stage, 0, len(g_provision_stages)+len(profile.Plugins))
+	for _, def := range g_provision_stages {
+		stages = append(stages, &builtinStage{def, profile, profile.Stages[def.name]})
+		for _, plugin := range profile.Plugins {
+			if plugin.After == def.name {
+				stages = append(stages, &pluginStage{plugin})
+			}
+		}
+	}
+	for _, plugin := range profile.Plugins {
+		if len(plugin.After) == 0 {
+			stages = append(stages, &pluginStage{plugin})
+		}
+	}
+	return stages
+}
+
+func stageEnabled(s Stage, profile *ProvisionProfile, opts *ProvisionOptions) bool {
+	for _, n := range opts.Disable {
+		if n == s.Name() {
+			return false
+		}
+	}
+	for _, n := range opts.Enable {
+		if n == s.Name() {
+			return true
+		}
+	}
+	if plugin, ok := s.(*pluginStage); ok {
+		return plugin.cfg.Enabled
+	}
+	return profile.Stages[s.Name()].Enabled
+}
+
+func stageContext(s Stage) (context.Context, context.CancelFunc) {
+	if t, ok := s.(interface{ timeout() int }); ok && t.timeout() > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(t.timeout())*time.Second)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// runStages runs the enabled stages in order. When a stage fails, the stages
+// that already completed are rolled back in reverse order until one of them
+// reports that it is committed.
+func runStages(stages []Stage, dev *ProvisionDevice, enabled func(Stage) bool) ([]StageResult, error) {
+	results := make([]StageResult, len(stages))
+	completed := make([]int, 0, len(stages))
+
+	var failure error
+	for i, stage := range stages {
+		results[i].Stage = stage.Name()
+
+		if failure != nil {
+			results[i].Status = STAGE_NOT_RUN
+			continue
+		}
+		if !enabled(stage) {
+			results[i].Status = STAGE_SKIPPED
+			continue
+		}
+
+		ctx, cancel := stageContext(stage)
+		dev.detail = ""
+		start := time.Now()
+		err := stage.Run(ctx, dev)
+		cancel()
+		results[i].Duration = time.Since(start)
+
+		if err != nil {
+			results[i].Status = STAGE_FAILED
+			results[i].Detail = err.Error()
+			failure = fmt.Errorf("stage %s failed: %s", stage.Name(), err)
+		} else {
+			results[i].Status = STAGE_OK
+			results[i].Detail = dev.detail
+			completed = append(completed, i)
+		}
+	}
+
+	if failure != nil {
+		for j := len(completed) - 1; j >= 0; j-- {
+			i := completed[j]
+			ctx, cancel := stageContext(stages[i])
+			err := stages[i].Rollback(ctx, dev)
+			cancel()
+			if err == errStageCommitted {
+				break
+			} else if err != nil {
+				results[i].Status = STAGE_ROLLBACK_FAILED
+				results[i].Detail = err.Error()
+			} else {
+				results[i].Status = STAGE_ROLLED_BACK
+			}
+		}
+	}
+
+	return results, failure
+}
@@ -0,0 +1,183 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "math"
+import "sort"
+import "time"
+import "encoding/json"
+import "encoding/binary"
+
+import "github.com/graphql-go/graphql"
+
+// Aggregate statistics for partners: devices made per product and week,
+// nothing that identifies a device. productWeeks is the only query that
+// reads the devices for them, it returns counts and nothing else, and the
+// aggregate-only GraphQL schema has no other query. Every device adds one to
+// one count, Laplace noise of scale 1/epsilon on the counts makes the
+// statistics epsilon-differentially private, counts below the minimum are
+// suppressed on top of that.
+
+const SCHEMA_STATS = "euisiggen/stats/v1"
+
+type StatsOptions struct {
+	Sigdir   string  `long:"sigdir"    default:"sigdata" description:"Where the EUI_XXXXXXXXXXXXXXXX.bin files are stored."`
+	Epsilon  float64 `long:"epsilon"   default:"1.0" description:"Privacy budget of the noise added to the counts, 0 for exact counts."`
+	MinCount uint    `long:"min-count" default:"5" description:"Suppress the counts below this."`
+	Json     bool    `long:"json" description:"Output the statistics as JSON."`
+}
+
+// ProductWeek is the number of devices of a product made in an ISO week.
+// The product is the platform of the device, the board when it has none.
+type ProductWeek struct {
+	Product string `json:"product"`
+	Week    string `json:"week"`
+	Devices int    `json:"devices"`
+}
+
+// aggregateQuery is how the counts are protected.
+type aggregateQuery struct {
+	epsilon   float64
+	min_count int
+}
+
+// laplace returns a sample of the Laplace distribution.
+func laplace(scale float64) (float64, error) {
+	b, err := randomBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	u := float64(binary.BigEndian.Uint64(b)>>11)/(1<<53) - 0.5 // [-0.5, 0.5)
+	if u < 0 {
+		return scale * math.Log(1+2*u), nil
+	}
+	return -scale * math.Log(1-2*u), nil
+}
+
+func deviceProduct(d map[string]interface{}) string {
+	for _, key := range []string{"platform", "board"} {
+		if c, ok := d[key].(map[string]interface{}); ok {
+			return fmt.Sprintf("%s %s", c["name"], c["version"])
+		}
+	}
+	return "unknown"
+}
+
+// productWeeks counts the devices per product and week, returns the counts
+// that are not suppressed and the number of suppressed ones.
+func (q aggregateQuery) productWeeks(devices []map[string]interface{}) ([]ProductWeek, int, error) {
+	counts := make(map[ProductWeek]int)
+	for _, d := range devices {
+		year, week := time.Unix(d["unix_time"].(int64), 0).UTC().ISOWeek()
+		counts[ProductWeek{Product: deviceProduct(d), Week: fmt.Sprintf("%04d-W%02d", year, week)}]++
+	}
+
+	rows := make([]ProductWeek, 0, len(counts))
+	suppressed := 0
+	for row, count := range counts {
+		if q.epsilon > 0 {
+			noise, err := laplace(1 / q.epsilon)
+			if err != nil {
+				return nil, 0, err
+			}
+			count = int(math.Max(0, math.Round(float64(count)+noise)))
+		}
+		if count < q.min_count {
+			suppressed++
+			continue
+		}
+		row.Devices = count
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Week != rows[j].Week {
+			return rows[i].Week < rows[j].Week
+		}
+		return rows[i].Product < rows[j].Product
+	})
+	return rows, suppressed, nil
+}
+
+// statsSchema is the GraphQL schema of the aggregate-only endpoint.
+func statsSchema(q aggregateQuery) (graphql.Schema, error) {
+	productWeekType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ProductWeek",
+		Fields: graphql.Fields{
+			"product": &graphql.Field{Type: graphql.String},
+			"week":    &graphql.Field{Type: graphql.String},
+			"devices": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"productWeeks": &graphql.Field{
+				Type: graphql.NewList(productWeekType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rows, _, err := q.productWeeks(queryData(p).devices)
+					if err != nil {
+						return nil, err
+					}
+					items := make([]interface{}, 0, len(rows))
+					for _, r := range rows {
+						items = append(items, map[string]interface{}{"product": r.Product, "week": r.Week, "devices": r.Devices})
+					}
+					return items, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+func init() {
+	registerCommand(subcommand{
+		name:    "export-stats",
+		short:   "Aggregate device statistics for partners",
+		long:    "Devices made per product and week, without EUIs or serials, with noise on the counts and small counts suppressed.",
+		options: func() interface{} { return new(StatsOptions) },
+		run:     func(c *commandRun) { statsMain(c.options.(*StatsOptions)) },
+	})
+}
+
+func statsMain(opts *StatsOptions) {
+	if opts.Epsilon < 0 {
+		fmt.Printf("ERROR --epsilon can not be negative\n")
+		os.Exit(2)
+	}
+	devices, err := loadDevices(opts.Sigdir)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	q := aggregateQuery{opts.Epsilon, int(opts.MinCount)}
+	rows, suppressed, err := q.productWeeks(devices)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+
+	if opts.Json {
+		j, _ := json.MarshalIndent(map[string]interface{}{
+			"schema":     SCHEMA_STATS,
+			"generator":  generatorVersion(),
+			"epsilon":    opts.Epsilon,
+			"min_count":  opts.MinCount,
+			"suppressed": suppressed,
+			"weeks":      rows,
+		}, "", "	")
+		fmt.Println(string(j))
+		return
+	}
+
+	for _, r := range rows {
+		fmt.Printf("%s  %-32s %6d\n", r.Week, r.Product, r.Devices)
+	}
+	fmt.Printf("%d counts below %d suppressed\n", suppressed, opts.MinCount)
+}
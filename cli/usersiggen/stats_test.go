@@ -0,0 +1,68 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "fmt"
+import "math"
+import "testing"
+import "encoding/json"
+
+import "github.com/graphql-go/graphql"
+
+func TestLaplace(t *testing.T) {
+	n, sum, abs := 20000, 0.0, 0.0
+	for i := 0; i < n; i++ {
+		x, err := laplace(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += x
+		abs += math.Abs(x)
+	}
+	// The mean is 0 and the mean absolute deviation is the scale
+	if mean, mad := sum/float64(n), abs/float64(n); math.Abs(mean) > 0.1 || math.Abs(mad-2) > 0.1 {
+		t.Errorf("mean %f and deviation %f of Laplace(2)", mean, mad)
+	}
+}
+
+// Seven devices of one product, five made in a week and two in the next.
+func TestProductWeeks(t *testing.T) {
+	sigdir := t.TempDir()
+	for e := eui64(0x70B3D5D72F000001); e <= 0x70B3D5D72F000007; e++ {
+		writeTestFile(t, sigdir, fmt.Sprintf("EUI-64_%016X.bin", e), testArea(t, e))
+	}
+	devices, err := loadDevices(sigdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range devices[5:] {
+		d["unix_time"] = g_test_time.AddDate(0, 0, 7).Unix()
+	}
+
+	rows, suppressed, err := aggregateQuery{0, 5}.productWeeks(devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0] != (ProductWeek{"smenete 1.2.3", "2023-W46", 5}) || suppressed != 1 {
+		t.Errorf("product weeks are %+v, %d suppressed", rows, suppressed)
+	}
+	rows, suppressed, err = aggregateQuery{0, 1}.productWeeks(devices)
+	if err != nil || len(rows) != 2 || rows[1].Week != "2023-W47" || rows[1].Devices != 2 || suppressed != 0 {
+		t.Errorf("product weeks are %+v, %d suppressed: %v", rows, suppressed, err)
+	}
+
+	// The schema answers with the counts and nothing else
+	schema, err := statsSchema(aggregateQuery{0, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := map[string]interface{}{"data": &graphqlData{devices: devices}}
+	result := graphql.Do(graphql.Params{Schema: schema, RootObject: root, RequestString: `{ productWeeks { product week devices } }`})
+	if b, _ := json.Marshal(result.Data); len(result.Errors) > 0 || string(b) != `{"productWeeks":[{"devices":5,"product":"smenete 1.2.3","week":"2023-W46"}]}` {
+		t.Errorf("productWeeks is %s: %v", b, result.Errors)
+	}
+	if result := graphql.Do(graphql.Params{Schema: schema, RootObject: root, RequestString: `{ devices { total } }`}); len(result.Errors) == 0 {
+		t.Errorf("the aggregate schema answers about devices")
+	}
+}
@@ -0,0 +1,294 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "io"
+import "fmt"
+import "bytes"
+import "errors"
+import "strconv"
+import "strings"
+import "math/big"
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "encoding/asn1"
+import "encoding/binary"
+
+// Factory keys kept in the TPM 2.0 of the factory PC, addressed by their
+// persistent handle instead of a key file:
+//
+//	tpm2_createprimary -C o -c primary.ctx
+//	tpm2_create -C primary.ctx -G ecc256:ecdsa-sha256 -u key.pub -r key.priv
+//	tpm2_load -C primary.ctx -u key.pub -r key.priv -c key.ctx
+//	tpm2_evictcontrol -C o -c key.ctx 0x81000100
+//	tpm2_readpublic -c 0x81000100 -f pem -o factory.pub
+//	usersiggen --type sign --sign-key tpm:0x81000100 --out sigdata.bin
+//
+// The key never leaves the TPM, usersiggen sends it the SHA-256 of what is
+// signed. TPMs do not have Ed25519, the key must be an unrestricted ECDSA
+// P-256 signing key. The auth value of the key comes from USERSIGGEN_TPM_AUTH,
+// empty when it is not set. The commands go to /dev/tpmrm0, the resource
+// manager of the kernel, or the device of --tpm-device.
+
+const TPM_KEY_PREFIX = "tpm:"
+const TPM_AUTH_ENV = "USERSIGGEN_TPM_AUTH"
+
+var g_tpm_device = "/dev/tpmrm0"
+
+// TPM 2.0 constants, from part 2 of the specification
+const TPM_ST_NO_SESSIONS = 0x8001
+const TPM_ST_SESSIONS = 0x8002
+const TPM_ST_HASHCHECK = 0x8024
+const TPM_CC_SIGN = 0x0000015D
+const TPM_CC_READ_PUBLIC = 0x00000173
+const TPM_RS_PW = 0x40000009
+const TPM_RH_NULL = 0x40000007
+const TPM_ALG_ECC = 0x0023
+const TPM_ALG_ECDSA = 0x0018
+const TPM_ALG_SHA256 = 0x000B
+const TPM_ALG_NULL = 0x0010
+const TPM_ECC_NIST_P256 = 0x0003
+const TPMA_OBJECT_SIGN_ENCRYPT = 0x00040000
+const TPMA_OBJECT_RESTRICTED = 0x00010000
+const TPM_PERSISTENT_FIRST = 0x81000000
+const TPM_PERSISTENT_LAST = 0x81FFFFFF
+const TPM_MAX_RESPONSE = 4096
+
+// tpmSigner signs with an ECDSA P-256 key in a TPM, a crypto.Signer for the
+// signature package.
+type tpmSigner struct {
+	tpm    io.ReadWriter
+	handle uint32
+	auth   []byte
+	public *ecdsa.PublicKey
+}
+
+func setTpmDevice(device string) {
+	if len(device) > 0 {
+		g_tpm_device = device
+	}
+}
+
+// isTpmKey tells if a key argument names a TPM key, tpm:HANDLE.
+func isTpmKey(key string) bool {
+	return strings.HasPrefix(key, TPM_KEY_PREFIX)
+}
+
+// parseTpmHandle parses the persistent handle of tpm:HANDLE.
+func parseTpmHandle(key string) (uint32, error) {
+	s := strings.TrimPrefix(key, TPM_KEY_PREFIX)
+	h, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a TPM handle, e.g. tpm:0x81000100", key)
+	}
+	if h < TPM_PERSISTENT_FIRST || h > TPM_PERSISTENT_LAST {
+		return 0, fmt.Errorf("TPM handle 0x%08X is not persistent, 0x81000000 to 0x81FFFFFF", h)
+	}
+	return uint32(h), nil
+}
+
+// openTpmSigner opens the TPM and the key of tpm:HANDLE in it.
+func openTpmSigner(key string) (*tpmSigner, error) {
+	handle, err := parseTpmHandle(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(g_tpm_device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening the TPM: %s", err)
+	}
+	return newTpmSigner(f, handle, []byte(os.Getenv(TPM_AUTH_ENV)))
+}
+
+// newTpmSigner reads the public key of handle and checks the TPM can sign
+// areas with it.
+func newTpmSigner(tpm io.ReadWriter, handle uint32, auth []byte) (*tpmSigner, error) {
+	s := &tpmSigner{tpm: tpm, handle: handle, auth: auth}
+	cmd := new(bytes.Buffer)
+	binary.Write(cmd, binary.BigEndian, handle)
+	resp, err := s.command(TPM_ST_NO_SESSIONS, TPM_CC_READ_PUBLIC, cmd.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("TPM2_ReadPublic of 0x%08X: %s", handle, err)
+	}
+	pub, err := tpmParsePublic(resp)
+	if err != nil {
+		return nil, fmt.Errorf("TPM key 0x%08X: %s", handle, err)
+	}
+	s.public = pub
+	return s, nil
+}
+
+func (self *tpmSigner) Public() crypto.PublicKey {
+	return self.public
+}
+
+// Sign signs the SHA-256 digest with TPM2_Sign and returns the signature in
+// ASN.1 DER, as crypto.Signer does for ECDSA.
+func (self *tpmSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != 32 {
+		return nil, errors.New("the TPM key signs SHA-256 digests")
+	}
+	cmd := new(bytes.Buffer)
+	binary.Write(cmd, binary.BigEndian, self.handle)
+	// Password session with the auth value of the key
+	auth := new(bytes.Buffer)
+	binary.Write(auth, binary.BigEndian, uint32(TPM_RS_PW))
+	binary.Write(auth, binary.BigEndian, uint16(0)) // No nonce
+	auth.WriteByte(0)                               // Session attributes
+	tpm2b(auth, self.auth)
+	binary.Write(cmd, binary.BigEndian, uint32(auth.Len()))
+	cmd.Write(auth.Bytes())
+	tpm2b(cmd, digest)
+	binary.Write(cmd, binary.BigEndian, []uint16{TPM_ALG_ECDSA, TPM_ALG_SHA256})
+	// A NULL ticket, the digest was not made by the TPM
+	binary.Write(cmd, binary.BigEndian, uint16(TPM_ST_HASHCHECK))
+	binary.Write(cmd, binary.BigEndian, uint32(TPM_RH_NULL))
+	binary.Write(cmd, binary.BigEndian, uint16(0))
+
+	resp, err := self.command(TPM_ST_SESSIONS, TPM_CC_SIGN, cmd.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("TPM2_Sign with 0x%08X: %s", self.handle, err)
+	}
+	r := &tpmReader{data: resp}
+	r.u32() // Size of the parameters, the auth area follows them
+	if alg, hash := r.u16(), r.u16(); alg != TPM_ALG_ECDSA || hash != TPM_ALG_SHA256 {
+		return nil, fmt.Errorf("TPM2_Sign returned a signature of algorithm 0x%04X, hash 0x%04X", alg, hash)
+	}
+	sig := struct{ R, S *big.Int }{new(big.Int).SetBytes(r.tpm2b()), new(big.Int).SetBytes(r.tpm2b())}
+	if r.err != nil {
+		return nil, fmt.Errorf("TPM2_Sign: %s", r.err)
+	}
+	return asn1.Marshal(sig)
+}
+
+// command sends a command to the TPM and returns the response after the
+// header, the response code must be success.
+func (self *tpmSigner) command(tag uint16, code uint32, body []byte) ([]byte, error) {
+	cmd := new(bytes.Buffer)
+	binary.Write(cmd, binary.BigEndian, tag)
+	binary.Write(cmd, binary.BigEndian, uint32(10+len(body)))
+	binary.Write(cmd, binary.BigEndian, code)
+	cmd.Write(body)
+	if _, err := self.tpm.Write(cmd.Bytes()); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, TPM_MAX_RESPONSE)
+	n, err := self.tpm.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	if n < 10 {
+		return nil, fmt.Errorf("response of %d bytes", n)
+	}
+	if size := binary.BigEndian.Uint32(resp[2:6]); int(size) != n {
+		return nil, fmt.Errorf("response of %d bytes, its header says %d", n, size)
+	}
+	if rc := binary.BigEndian.Uint32(resp[6:10]); rc != 0 {
+		return nil, fmt.Errorf("response code 0x%03X%s", rc, tpmRcHint(rc))
+	}
+	return resp[10:n], nil
+}
+
+// tpmRcHint explains the response codes of a wrong handle, auth value or
+// key. The codes of format 1 carry the number of the handle, session or
+// parameter in bits 6 and 8 to 11.
+func tpmRcHint(rc uint32) string {
+	if rc == 0x921 {
+		return ", the TPM is locked out after wrong auth values"
+	}
+	if rc&0x80 == 0 {
+		return ""
+	}
+	switch rc & 0xBF {
+	case 0x08B:
+		return ", there is no key at the handle"
+	case 0x08E, 0x0A2:
+		return ", wrong auth value, see " + TPM_AUTH_ENV
+	case 0x082:
+		return ", the key may not sign"
+	}
+	return ""
+}
+
+// tpmParsePublic returns the ECDSA public key of a TPM2_ReadPublic response,
+// refusing keys that cannot sign areas.
+func tpmParsePublic(resp []byte) (*ecdsa.PublicKey, error) {
+	outer := &tpmReader{data: resp}
+	r := &tpmReader{data: outer.tpm2b()}
+	alg := r.u16()
+	r.u16() // Name algorithm
+	attributes := r.u32()
+	r.tpm2b() // Auth policy
+	if r.err == nil && alg != TPM_ALG_ECC {
+		return nil, fmt.Errorf("the key is of algorithm 0x%04X, not an ECC key", alg)
+	}
+	if sym := r.u16(); sym != TPM_ALG_NULL {
+		r.u16() // Key bits and mode of a storage key
+		r.u16()
+	}
+	scheme := r.u16()
+	if scheme != TPM_ALG_NULL {
+		r.u16()
+	}
+	curve := r.u16()
+	if kdf := r.u16(); kdf != TPM_ALG_NULL {
+		r.u16()
+	}
+	x, y := r.tpm2b(), r.tpm2b()
+	if r.err != nil || outer.err != nil {
+		return nil, errors.New("malformed TPM2_ReadPublic response")
+	}
+	switch {
+	case curve != TPM_ECC_NIST_P256:
+		return nil, fmt.Errorf("the key is on curve 0x%04X, not P-256", curve)
+	case attributes&TPMA_OBJECT_SIGN_ENCRYPT == 0:
+		return nil, errors.New("the key is not a signing key")
+	case attributes&TPMA_OBJECT_RESTRICTED != 0:
+		return nil, errors.New("the key is restricted, it only signs what the TPM made")
+	case scheme != TPM_ALG_NULL && scheme != TPM_ALG_ECDSA:
+		return nil, fmt.Errorf("the key signs with scheme 0x%04X, not ECDSA", scheme)
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if _, err := pub.ECDH(); err != nil || len(x) > 32 || len(y) > 32 {
+		return nil, errors.New("the public key is not on P-256")
+	}
+	return pub, nil
+}
+
+// tpm2b writes a TPM2B, a 2 byte size and the bytes.
+func tpm2b(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+}
+
+// tpmReader reads the big endian fields of a TPM response, the first error
+// sticks and the later reads return zero.
+type tpmReader struct {
+	data []byte
+	err  error
+}
+
+func (self *tpmReader) next(n int) []byte {
+	if self.err != nil || len(self.data) < n {
+		self.err = errors.New("truncated")
+		return make([]byte, n)
+	}
+	b := self.data[:n]
+	self.data = self.data[n:]
+	return b
+}
+
+func (self *tpmReader) u16() uint16 {
+	return binary.BigEndian.Uint16(self.next(2))
+}
+
+func (self *tpmReader) u32() uint32 {
+	return binary.BigEndian.Uint32(self.next(4))
+}
+
+func (self *tpmReader) tpm2b() []byte {
+	return self.next(int(self.u16()))
+}
@@ -0,0 +1,169 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "bytes"
+import "strings"
+import "testing"
+import "time"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "crypto/rand"
+import "encoding/binary"
+
+import "go.thinnect.net/euisig/signature"
+
+// fakeTpm answers TPM2_ReadPublic and TPM2_Sign for one ECDSA P-256 key at a
+// persistent handle, as a TPM behind /dev/tpmrm0 does.
+type fakeTpm struct {
+	key        *ecdsa.PrivateKey
+	handle     uint32
+	auth       []byte
+	attributes uint32
+	resp       []byte
+}
+
+func (self *fakeTpm) Write(cmd []byte) (int, error) {
+	r := &tpmReader{data: cmd}
+	r.u16()
+	r.u32()
+	code := r.u32()
+	handle := r.u32()
+	body := new(bytes.Buffer)
+	rc := uint32(0)
+	switch {
+	case handle != self.handle:
+		rc = 0x18B // TPM_RC_HANDLE of handle 1
+	case code == TPM_CC_READ_PUBLIC:
+		pub := new(bytes.Buffer)
+		binary.Write(pub, binary.BigEndian, []uint16{TPM_ALG_ECC, TPM_ALG_SHA256})
+		binary.Write(pub, binary.BigEndian, self.attributes)
+		tpm2b(pub, nil)
+		binary.Write(pub, binary.BigEndian, []uint16{TPM_ALG_NULL, TPM_ALG_ECDSA, TPM_ALG_SHA256, TPM_ECC_NIST_P256, TPM_ALG_NULL})
+		tpm2b(pub, self.key.X.FillBytes(make([]byte, 32)))
+		tpm2b(pub, self.key.Y.FillBytes(make([]byte, 32)))
+		tpm2b(body, pub.Bytes())
+		tpm2b(body, nil) // Name
+		tpm2b(body, nil) // Qualified name
+	case code == TPM_CC_SIGN:
+		r.u32()
+		r.u32()
+		r.tpm2b()
+		r.next(1)
+		if !bytes.Equal(r.tpm2b(), self.auth) {
+			rc = 0x98E // TPM_RC_AUTH_FAIL of session 1
+			break
+		}
+		digest := r.tpm2b()
+		sr, ss, err := ecdsa.Sign(rand.Reader, self.key, digest)
+		if err != nil {
+			return 0, err
+		}
+		params := new(bytes.Buffer)
+		binary.Write(params, binary.BigEndian, []uint16{TPM_ALG_ECDSA, TPM_ALG_SHA256})
+		tpm2b(params, sr.Bytes())
+		tpm2b(params, ss.Bytes())
+		binary.Write(body, binary.BigEndian, uint32(params.Len()))
+		body.Write(params.Bytes())
+		body.Write([]byte{0, 0, 1, 0, 0}) // Auth area of the password session
+	default:
+		rc = 0x143 // TPM_RC_COMMAND_CODE
+	}
+	if rc != 0 {
+		body.Reset()
+	}
+	resp := new(bytes.Buffer)
+	binary.Write(resp, binary.BigEndian, uint16(TPM_ST_NO_SESSIONS))
+	binary.Write(resp, binary.BigEndian, uint32(10+body.Len()))
+	binary.Write(resp, binary.BigEndian, rc)
+	resp.Write(body.Bytes())
+	self.resp = resp.Bytes()
+	return len(cmd), nil
+}
+
+func (self *fakeTpm) Read(b []byte) (int, error) {
+	return copy(b, self.resp), nil
+}
+
+func newFakeTpm(t *testing.T) *fakeTpm {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeTpm{key: key, handle: 0x81000100, auth: []byte("line2"), attributes: TPMA_OBJECT_SIGN_ENCRYPT}
+}
+
+// An area signed with the key in the TPM verifies with its public key.
+func TestTpmSign(t *testing.T) {
+	tpm := newFakeTpm(t)
+	signer, err := newTpmSigner(tpm, 0x81000100, []byte("line2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signer.public.Equal(&tpm.key.PublicKey) {
+		t.Fatalf("TPM2_ReadPublic gave another key")
+	}
+
+	var gen UserSignature
+	ts := time.Unix(1700000000, 0)
+	eui, _ := gen.ConstructEUISignature(ts, 0x70B3D5D72F000001)
+	area, err := gen.Serialize(eui)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asig, err := gen.ConstructAreaSignatureWith(ts, area, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := signature.PublicKeyId(&tpm.key.PublicKey); asig.Key_id != id {
+		t.Errorf("signed with key %X, want %X", asig.Key_id[:], id[:])
+	}
+	record, err := gen.Serialize(asig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(area, record...)
+	if err := gen.VerifyAreaWith(data, &tpm.key.PublicKey); err != nil {
+		t.Error(err)
+	}
+	data[8] ^= 0x01
+	signature.Recheck(data)
+	if err := gen.VerifyAreaWith(data, &tpm.key.PublicKey); err == nil {
+		t.Errorf("a changed area verifies")
+	}
+}
+
+func TestTpmErrors(t *testing.T) {
+	tpm := newFakeTpm(t)
+	if _, err := newTpmSigner(tpm, 0x81000101, nil); err == nil || !strings.Contains(err.Error(), "no key at the handle") {
+		t.Errorf("wrong handle: %v", err)
+	}
+
+	signer, err := newTpmSigner(tpm, 0x81000100, []byte("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gen UserSignature
+	if _, err := gen.ConstructAreaSignatureWith(time.Unix(1700000000, 0), []byte{0x03}, signer); err == nil || !strings.Contains(err.Error(), "wrong auth value") {
+		t.Errorf("wrong auth value: %v", err)
+	}
+
+	tpm.attributes |= TPMA_OBJECT_RESTRICTED
+	if _, err := newTpmSigner(tpm, 0x81000100, nil); err == nil || !strings.Contains(err.Error(), "restricted") {
+		t.Errorf("restricted key: %v", err)
+	}
+	tpm.attributes = 0
+	if _, err := newTpmSigner(tpm, 0x81000100, nil); err == nil || !strings.Contains(err.Error(), "not a signing key") {
+		t.Errorf("decryption key: %v", err)
+	}
+
+	for _, key := range []string{"tpm:0x80000001", "tpm:0x01000000", "tpm:line2", "tpm:"} {
+		if _, err := parseTpmHandle(key); err == nil {
+			t.Errorf("%s is taken for a persistent handle", key)
+		}
+	}
+	if h, err := parseTpmHandle("tpm:0x81000100"); err != nil || h != 0x81000100 {
+		t.Errorf("tpm:0x81000100 is %08X, %v", h, err)
+	}
+}
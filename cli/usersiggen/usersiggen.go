@@ -0,0 +1,446 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package usersiggen generates, reads and checks the signature area of a
+// device. It is the usersiggen command, the thinnect-id binary runs it as
+// its sig, license, serve and verify subcommands.
+package usersiggen
+
+import "os"
+import "fmt"
+import "time"
+
+import "github.com/jessevdk/go-flags"
+
+import "go.thinnect.net/euisig/eui"
+import "github.com/thinnect/euisiggen/cli/completion"
+import "github.com/thinnect/euisiggen/cli/config"
+import "go.thinnect.net/euisig/signature"
+
+// Timestamps are always kept in UTC, only reports shown to the operator are
+// converted to the --display-tz zone.
+var g_display_location = time.UTC
+
+// TimestampString formats t as RFC 3339, always including the zone offset.
+func TimestampString(t time.Time) string {
+	return t.In(g_display_location).Format(time.RFC3339)
+}
+
+func setDisplayTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("Unknown time zone %s: %s", name, err)
+	}
+	g_display_location = loc
+	return nil
+}
+
+func parseEui(s string) (eui64, error) {
+	v, err := eui.Parse(s)
+	return eui64(v), err
+}
+
+func printGeneratorVersion() {
+	fmt.Printf("Device signature generator %d.%d.%d\n", signature.VersionMajor, signature.VersionMinor, signature.VersionPatch)
+}
+
+// Options are the options of the signature generator itself, the
+// subcommands have their own.
+type Options struct {
+	Type      string `long:"type" values:"board,platform,component,compliance,extid,pin,license,sign" description:"Signature type - board, platform, component, compliance, extid, pin. License. Sign appends the area signature."`
+	Manifest  string `long:"manifest" description:"JSON file describing the board, platform and all components, writes the whole signature area in one run."`
+	Preset    string `long:"preset"     description:"Named device manifest from the --preset-dir, e.g. sm3-rev2, written like --manifest."`
+	PresetDir string `long:"preset-dir" default:"presets" description:"Directory containing <preset>.json device manifests."`
+
+	Name         string       `long:"name"         description:"The name of the component that the user signature will be used for."`
+	Version      BoardVersion `long:"version"      description:"The version of the board X.Y.Z."`
+	UUID         string       `long:"uuid"         description:"Board/Platform/Component UUID. 16 bytes."`
+	Manufacturer string       `long:"manufacturer" description:"Manufacturer UUID. 16 bytes."`
+	Part         string       `long:"part"         description:"Part number, the name, version, UUID and manufacturer are looked up in the PLM."`
+	PlmUrl       string       `long:"plm-url"      description:"PLM lookup url, {part} is substituted, e.g. http://plm/parts/{part}."`
+	PlmCache     string       `long:"plm-cache"    default:"plm-cache.json" description:"Local cache of the parts looked up in the PLM."`
+	PlmMaxAge    string       `long:"plm-max-age"  default:"24h" description:"How long a cached part is used before the PLM is asked again."`
+	Position     uint8        `long:"position"     description:"Component position/index (when multiple)."`
+	Slot         string       `long:"slot"         description:"Named slot the component is fitted to, checked against the --slots platform definition."`
+
+	Calibration       string `long:"calibration"        description:"Attach component data from the calibration plugin of this sensor type."`
+	CalibrationSource string `long:"calibration-source" description:"Calibration measurements, CSV file or fixture API url with {eui}."`
+	Datafile          string `long:"datafile"           description:"Attach the contents of this file to the record as component specific data."`
+
+	Serial        string `long:"serial"     description:"Serial number, string format. Up to 16 characters."`
+	SerialUUID    string `long:"serial-uuid" description:"Serial number, UUID format. 16 bytes."`
+	OldSerialUUID string `long:"serialuuid" hidden:"true" description:"Deprecated, use --serial-uuid."`
+
+	WorkOrder     string `long:"work-order"      description:"Embed a per work order sequence number in the board serial."`
+	WorkOrderSize uint32 `long:"work-order-size" description:"Number of boards in the work order, refuse sequence numbers beyond it."`
+	SerialFormat  string `long:"serial-format"   default:"%s-%04d" description:"Format of the work order serial, gets the work order and the sequence number."`
+	PanelSize     uint32 `long:"panel-size"      description:"Boards per panel, work order sequence numbers are grouped into panels."`
+	Seqdir        string `long:"seqdir"          description:"Where work order sequence logs are kept, defaults to <sigdir>/workorders."`
+
+	Eui         string `long:"eui"     default:""        description:"Do not retrieve EUI from euifile, override with the specified EUI."`
+	Euifile     string `long:"euifile"                   description:"The file containing available EUIs."`
+	EuiSource   string `long:"eui-source"             description:"EUI allocation server to take the EUI from instead of an euifile, e.g. http://euiserver:8080."`
+	EuiRegistry string `long:"eui-registry"         description:"Registry holding the state of the EUIs of the euifile, an SQLite file or a postgres:// url, see 'eui-registry import'."`
+	Sigdir      string `long:"sigdir"  default:"sigdata" description:"Where to store EUI_XXXXXXXXXXXXXXXX.bin files."`
+	Tag         string `long:"tag"                       description:"Build configuration tag, the EUI comes from the pool partition of the tag."`
+	Count       uint   `long:"count"   default:"1"       description:"Generate this many board signatures from the euifile in one run, into the sigdir only."`
+	IfExists    string `long:"if-exists" default:"fail" values:"fail,skip-identical" description:"When the signature file of the EUI exists, fail or use it when it has the content that would be generated."`
+
+	CountryOfOrigin string   `long:"coo"             description:"Country of origin, ISO 3166 2 letter code."`
+	Region          []string `long:"region"          description:"Certified regulatory region, can be repeated (eu, us, ca, uk, ...)."`
+	RedId           string   `long:"red-id"          description:"RED certificate identifier. Up to 24 characters."`
+	FccId           string   `long:"fcc-id"          description:"FCC ID. Up to 24 characters."`
+	IcId            string   `long:"ic-id"           description:"ISED Canada certification number. Up to 24 characters."`
+	ComplianceJson  string   `long:"compliance-json" description:"Read compliance information from a JSON file instead of flags."`
+
+	Id128          string `long:"id128"           description:"Extended 128-bit identifier, 32 hex digits or UUID notation."`
+	Id128Pool      string `long:"id128-pool"      description:"Allocate the extended identifier from this ID-128 pool file."`
+	Id128Namespace string `long:"id128-namespace" description:"Derive the extended identifier from the EUI-64 in this namespace UUID."`
+
+	Licfile    string `long:"license-file" description:"Generated license file."`
+	OldLicfile string `long:"licfile" hidden:"true" description:"Deprecated, use --license-file."`
+	Sigfile    string `long:"sigfile"  description:"Signature file to append license to."`
+	ValidFrom  string `long:"valid-from"  description:"License is not valid before this date, YYYY-MM-DD or RFC 3339."`
+	ValidUntil string `long:"valid-until" description:"License is not valid after this date, YYYY-MM-DD for the end of the day or RFC 3339."`
+
+	PinLength   uint   `long:"pin-length"   default:"6" description:"Digits of the setup PIN of --type pin."`
+	PinDelivery string `long:"pin-delivery" description:"Encrypted file the setup PINs are delivered to the fulfillment team in."`
+	PinKey      string `long:"pin-key"      description:"Key of the PIN delivery file, 32 bytes or 64 hex digits."`
+
+	SignKey   string `long:"sign-key" description:"Factory Ed25519 private key, PKCS#8 PEM, or tpm:HANDLE for an ECDSA P-256 key in the TPM, --type sign signs the whole area with it."`
+	TpmDevice string `long:"tpm-device" default:"/dev/tpmrm0" description:"TPM 2.0 device of tpm:HANDLE keys."`
+
+	Timestamp      int64  `long:"timestamp" description:"Use the specified timestamp."`
+	EmulateVersion string `long:"emulate-version" description:"Write the signature byte for byte as this earlier generator version did, for audits."`
+	SigVersion     int    `long:"sig-version" default:"3" choice:"3" choice:"4" description:"Signature format of the records, 3 for the fixed structures or 4 for tagged TLV fields."`
+	Endianness     string `long:"endianness" default:"big" choice:"big" choice:"little" description:"Byte order of the binary records, little for firmware reading them as native Cortex-M structs."`
+	Crc            string `long:"crc" default:"xmodem" choice:"xmodem" choice:"ccitt" choice:"crc32" choice:"sha256" description:"Integrity check at the end of the records, CRC-16/XMODEM, CRC-16/CCITT-FALSE, CRC-32 or the first 4 bytes of the SHA-256."`
+	WireFormat     string `long:"wire-format" default:"binary" choice:"binary" choice:"cbor" description:"Encoding of the records, the fixed binary structures or deterministic CBOR maps."`
+
+	Output          string `long:"out" default:"sigdata.bin" description:"The output file name."`
+	Format          string `long:"format" default:"text" choice:"text" choice:"json" description:"Result of the generation as text or as a JSON object on stdout, with the EUI, files, timestamps, CRCs and sizes."`
+	OutFormat       string `long:"out-format"        default:"bin" values:"bin,srec,cheader" description:"Also write the output in this format next to it, srec for Motorola S-records, cheader for a C array."`
+	OutAddress      string `long:"out-address"       default:"0" description:"Base address of the formatted output, e.g. 0x0007F000."`
+	OutRecordLength uint   `long:"out-record-length" default:"16" description:"Data bytes per S-record."`
+	Symbol          string `long:"symbol"            default:"user_signature_area" description:"Name of the C array of the cheader output."`
+
+	ReadSig        string `short:"r" long:"read-sig" description:"Dump signature in file as JSON"`
+	Verify         string `long:"verify" description:"Verify the CRCs, sizes and EUI-64 of the signatures in file, exits 4 when they fail."`
+	ReleaseEui     string `long:"release-eui" description:"Free the EUI of a board scrapped after provisioning, the mark is removed from the euifile and the signature file moved aside."`
+	ReleaseArchive bool   `long:"release-archive" description:"With --release-eui, keep the signature file as a .tnsig archive in the sigdir."`
+	VerifyKey      string `long:"verify-key" description:"Factory Ed25519 or ECDSA P-256 public key, PEM, or tpm:HANDLE, --verify also checks the area signature with it."`
+	Schema         string `long:"schema" default:"v2" values:"v1,v2" description:"JSON output schema version, v1 for the original layout."`
+
+	DisplayTz    string `long:"display-tz" default:"UTC" description:"Time zone for timestamps in reports, e.g. Europe/Tallinn or Local."`
+	SigdirKey    string `long:"sigdir-key" description:"Station key file, signature files in the sigdir are encrypted with it."`
+	RandomSource string `long:"random-source" description:"Read random bytes from this file or device, e.g. /dev/hwrng, instead of the system source."`
+	EntropyCheck bool   `long:"entropy-check" description:"Run health tests on the random source at startup, refuse to continue when it fails them."`
+	Slots        string `long:"slots" description:"Platform definition to check slots against and take slot names from."`
+	KeepBackups  int    `long:"keep-backups" default:"5" description:"Timestamped backups kept of replaced signature, pool and manifest files, 0 for none."`
+	MarkJournal  string `long:"mark-journal" default:"mark-journal.jsonl" description:"Local journal for marks of an unreachable pool, replayed when it returns."`
+
+	Policy    string `long:"policy"     description:"Signed generation policy of the organization, anything outside it is refused."`
+	PolicyKey string `long:"policy-key" description:"Ed25519 public key the policy is signed with, PEM."`
+	Keyring   string `long:"keyring"    description:"Keyring of the signing keys, keys not in it, for another purpose or expired are refused."`
+
+	KeyPassFile string `long:"key-pass-file" description:"File with the passphrase of encrypted private keys. USERSIGGEN_KEY_PASS or a prompt if not given."`
+
+	Offline bool `long:"offline" description:"Never access the network, refuse whatever would need it. For air-gapped lines."`
+
+	Config string `long:"config" description:"Station config file, TOML or YAML with option names as keys. USERSIGGEN_CONFIG or usersiggen.toml/.yaml in ., the user config dir or /etc/thinnect if not given."`
+
+	ShowVersion func() `short:"V" description:"Show generator version."`
+	HelpAll     func() `long:"help-all" description:"Show the help of every command and option."`
+	Debug       bool   `long:"debug" description:"Enable debug messages"`
+	StrictCli   bool   `long:"strict-cli" description:"Refuse deprecated flags instead of warning about them."`
+}
+
+// newParser builds the command line of the generator around the options, it
+// returns the options of the subcommands by name.
+func newParser(name string, opts *Options) (*flags.Parser, map[string]interface{}) {
+	opts.ShowVersion = func() {
+		printGeneratorVersion()
+		os.Exit(0)
+	}
+
+	parser := flags.NewParser(opts, flags.Default)
+	parser.Name = name
+	parser.SubcommandsOptional = true
+	options := addCommands(parser)
+
+	opts.HelpAll = func() {
+		fmt.Print(completion.HelpAll(completion.FromParser(parser)))
+		os.Exit(0)
+	}
+	return parser, options
+}
+
+// Parser returns the command line of the generator, for generating the
+// completion and help of commands that include it.
+func Parser(name string) *flags.Parser {
+	parser, _ := newParser(name, new(Options))
+	return parser
+}
+
+// Main runs the command with the arguments, name is what usage messages call
+// the command.
+func Main(name string, args []string) {
+	var opts Options
+	parser, options := newParser(name, &opts)
+
+	var gen UserSignature
+	var err error
+
+	_, err = parser.ParseArgs(args)
+	if err != nil {
+		fmt.Printf("ERROR parsing arguments\n")
+		os.Exit(1)
+	}
+
+	if parser.Active == nil || parser.Active.Name != "config" {
+		if len(opts.Config) == 0 {
+			opts.Config = config.Find(CONFIG_TOOL)
+		}
+		if _, err := applyConfig(parser, opts.Config); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if err := applyDeprecations(parser, opts.StrictCli); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := normalizeUuidOptions(parser); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := setDisplayTimezone(opts.DisplayTz); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := setSigdirKey(opts.SigdirKey); err != nil {
+		fmt.Printf("ERROR loading station key: %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := setRandomFile(opts.RandomSource); err != nil {
+		fmt.Printf("ERROR opening random source: %s\n", err)
+		os.Exit(2)
+	}
+	if opts.EntropyCheck {
+		if err := entropyCheck(); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(4)
+		}
+	}
+
+	if err := setPolicy(opts.Policy, opts.PolicyKey); err != nil {
+		fmt.Printf("ERROR loading policy: %s\n", err)
+		os.Exit(2)
+	}
+	setKeyPassFile(opts.KeyPassFile)
+	setTpmDevice(opts.TpmDevice)
+	if err := setKeyring(opts.Keyring); err != nil {
+		fmt.Printf("ERROR loading keyring: %s\n", err)
+		os.Exit(2)
+	}
+
+	setOffline(opts.Offline)
+	active := activeCommand(parser, &opts, options)
+	if err := offlineCommand(&opts, active); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	if err := setEuiRegistry(opts.EuiRegistry); err != nil {
+		fmt.Printf("ERROR opening EUI registry: %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := setPartResolver(PlmConfig{opts.PlmUrl, opts.PlmCache, opts.PlmMaxAge}); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	if len(opts.Part) > 0 {
+		if err := applyPartOptions(parser, opts.Part); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	g_keep_backups = opts.KeepBackups
+	g_mark_journal = opts.MarkJournal
+
+	if err := useSlotNames(opts.Slots); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	if active != nil {
+		active.run()
+		os.Exit(0)
+	}
+
+	schema, err := parseSchemaVersion(opts.Schema)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+
+	opt := parser.FindOptionByLongName("read-sig")
+	if opt.IsSet() {
+		// We are reading a signature.
+		sigs, err := readSigsFromFile(opts.ReadSig)
+		if err != nil {
+			fmt.Printf("Failed to read signature from file [%s]: %s\n", opts.ReadSig, err)
+			os.Exit(3)
+		} else {
+			fmt.Println(sigsToJson(sigs, schema))
+			os.Exit(0)
+		}
+	}
+
+	if len(opts.Verify) > 0 {
+		at := time.Now().UTC()
+		if opts.Timestamp > 0 {
+			at = time.Unix(opts.Timestamp, 0).UTC()
+		}
+		verifyMain(opts.Verify, opts.VerifyKey, at)
+		os.Exit(0)
+	}
+
+	if len(opts.ReleaseEui) > 0 {
+		if len(opts.Euifile) == 0 {
+			fmt.Printf("ERROR --release-eui needs the --euifile the EUI is marked in\n")
+			os.Exit(2)
+		}
+		if err := releaseMain(&opts); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	var timestamp time.Time
+	if opts.Timestamp > 0 {
+		timestamp = time.Unix(opts.Timestamp, 0).UTC()
+	} else {
+		timestamp = time.Now().UTC()
+	}
+	g_result.Generated = TimestampString(timestamp)
+	setResultFormat(opts.Format)
+
+	if _, err := parseOutAddress(opts.OutAddress); err != nil {
+		fmt.Printf("ERROR --out-address: %s\n", err)
+		os.Exit(2)
+	}
+	if !g_c_identifier.MatchString(opts.Symbol) {
+		fmt.Printf("ERROR --symbol %s is not a C identifier\n", opts.Symbol)
+		os.Exit(2)
+	}
+
+	if len(opts.Datafile) > 0 && len(opts.Calibration) > 0 {
+		fmt.Printf("ERROR --datafile and --calibration both give the component data, use one\n")
+		os.Exit(2)
+	}
+	if opts.IfExists != IF_EXISTS_FAIL && opts.IfExists != IF_EXISTS_SKIP_IDENTICAL {
+		fmt.Printf("ERROR --if-exists is %s or %s\n", IF_EXISTS_FAIL, IF_EXISTS_SKIP_IDENTICAL)
+		os.Exit(2)
+	}
+
+	if len(opts.EmulateVersion) > 0 {
+		if g_policy != nil {
+			if err := g_policy.checkEmulation(opts.EmulateVersion); err != nil {
+				fmt.Printf("ERROR %s\n", err)
+				os.Exit(4)
+			}
+		}
+		if err := gen.Emulate(opts.EmulateVersion); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if err := gen.SetWireFormat(opts.WireFormat); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	if opts.WireFormat == signature.WIRE_FORMAT_CBOR && len(opts.EmulateVersion) > 0 {
+		fmt.Printf("ERROR --emulate-version writes the binary records of that version, not --wire-format %s\n", opts.WireFormat)
+		os.Exit(2)
+	}
+	if err := gen.SetSigVersion(opts.SigVersion); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	if opts.SigVersion == signature.TLV_VERSION_MAJOR && (opts.WireFormat == signature.WIRE_FORMAT_CBOR || len(opts.EmulateVersion) > 0) {
+		fmt.Printf("ERROR --sig-version %d is a binary format of its own, not for --wire-format cbor or --emulate-version\n", opts.SigVersion)
+		os.Exit(2)
+	}
+
+	if err := gen.SetEndianness(opts.Endianness); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	if opts.Endianness == signature.ENDIANNESS_LITTLE && (opts.WireFormat == signature.WIRE_FORMAT_CBOR || len(opts.EmulateVersion) > 0) {
+		fmt.Printf("ERROR --endianness %s is for the binary records, not for --wire-format cbor or --emulate-version\n", opts.Endianness)
+		os.Exit(2)
+	}
+
+	if err := gen.SetCheck(opts.Crc); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(2)
+	}
+	if opts.Crc != signature.CHECK_XMODEM && (opts.WireFormat == signature.WIRE_FORMAT_CBOR || len(opts.EmulateVersion) > 0) {
+		fmt.Printf("ERROR --crc %s is for the binary records, not for --wire-format cbor or --emulate-version\n", opts.Crc)
+		os.Exit(2)
+	}
+
+	if len(opts.Preset) > 0 {
+		if len(opts.Manifest) > 0 {
+			fmt.Printf("ERROR --preset names a manifest, it can not be combined with --manifest\n")
+			os.Exit(2)
+		}
+		if opts.Manifest, err = presetFile(opts.Preset, opts.PresetDir); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if len(opts.Manifest) > 0 {
+		if len(opts.Type) > 0 {
+			fmt.Printf("ERROR the manifest describes all the signatures, --manifest can not be combined with --type\n")
+			os.Exit(2)
+		}
+		if len(opts.Eui) == 0 && len(opts.Euifile) == 0 {
+			fmt.Printf("ERROR --manifest needs an --euifile or --eui\n")
+			os.Exit(2)
+		}
+		manifestMain(&gen, &opts, timestamp)
+	}
+
+	if opts.Type == "license" {
+		licenseMain(&gen, &opts, timestamp)
+	}
+
+	if opts.Type == "compliance" {
+		complianceMain(&gen, &opts, timestamp)
+	}
+
+	if opts.Type == "pin" {
+		pinMain(&gen, &opts, timestamp)
+	}
+
+	if opts.Type == "sign" {
+		signMain(&gen, &opts, timestamp)
+	}
+
+	if opts.Type == "extid" {
+		extidMain(&gen, &opts, timestamp)
+	}
+
+	generateMain(&gen, &opts, parser, timestamp)
+}
@@ -0,0 +1,60 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+
+import "github.com/jessevdk/go-flags"
+
+import "go.thinnect.net/euisig/eui"
+
+// UUIDs get copied in from many systems, with or without braces and dashes,
+// in upper or lower case. They are all read the same way, and the UUID
+// options are rewritten in the canonical form before anything uses them. A
+// form other than the canonical one is echoed on stderr with the canonical
+// form, so the operator sees what was read.
+
+// Options that take a UUID, in every command that has them.
+var g_uuid_options = []string{"uuid", "manufacturer", "serial-uuid", "id128-namespace"}
+
+func parseUuid(s string) (tuuid, error) {
+	u, err := eui.ParseUuid(s)
+	return tuuid(u), err
+}
+
+// normalizeUuidOptions rewrites the UUID options that are set in the
+// canonical form.
+func normalizeUuidOptions(parser *flags.Parser) error {
+	for _, o := range configOptions(parser) {
+		if !o.IsSet() || !isUuidOption(o.LongName) {
+			continue
+		}
+		value := fmt.Sprint(o.Value())
+		if len(value) == 0 {
+			continue
+		}
+		u, err := parseUuid(value)
+		if err != nil {
+			return fmt.Errorf("--%s: %s", o.LongName, err)
+		}
+		canonical := eui.UuidString(u)
+		if canonical != value {
+			fmt.Fprintf(os.Stderr, "--%s %s read as %s\n", o.LongName, value, canonical)
+			if err := o.Set(&canonical); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isUuidOption(name string) bool {
+	for _, n := range g_uuid_options {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+// Author  Raido Pahtma
+// License MIT
+
+package usersiggen
+
+import "os"
+import "fmt"
+import "bytes"
+import "errors"
+import "reflect"
+import "time"
+import "encoding/binary"
+
+import "go.thinnect.net/euisig/signature"
+
+// --verify gives production scripts a pass or fail for a signature file:
+// every record must have a good CRC and a signature_size that agrees with its
+// layout, there must be exactly one EUI-64 record and nothing but erased
+// flash may follow the last record. --read-sig tolerates all of these.
+// Time-limited licenses must be valid now, or at --timestamp.
+
+type verifiedRecord struct {
+	offset   int
+	name     string
+	size     int
+	problems []string
+}
+
+// expectedSize returns the size the record must have by its layout, 0 when
+// the layout only gives a minimum, and the minimum size.
+func expectedSize(base BaseSignature, rec []byte) (int, int, error) {
+	format, err := signature.FormatOf(base)
+	if err != nil {
+		return 0, 0, err
+	}
+	size := int(base.Signature_size)
+	for _, r := range format.Records {
+		if r.Type != base.Signature_type {
+			continue
+		}
+		switch base.Signature_type {
+		case SIGNATURE_TYPE_BOARD, SIGNATURE_TYPE_PLATFORM, SIGNATURE_TYPE_COMPONENT:
+			fixed := binary.Size(ComponentSignature{})
+			if len(rec) < fixed {
+				return 0, fixed + 2, nil
+			}
+			data_length := int(signature.RecordOrder(rec).Uint16(rec[fixed-2 : fixed]))
+			exact := fixed + data_length + 2
+			// A component record in a named slot ends with the slot
+			if slotted := exact + binary.Size(tslot(0)); base.Signature_type == SIGNATURE_TYPE_COMPONENT && size == slotted {
+				return slotted, fixed + 2, nil
+			}
+			return exact, fixed + 2, nil
+		}
+		if n := len(r.Fields); n > 0 && r.Fields[n-1].Size < 0 {
+			return 0, r.Size, nil
+		}
+		return r.Size, r.Size, nil
+	}
+	return 0, 0, fmt.Errorf("type %d is unknown in format %s", base.Signature_type, format.Version)
+}
+
+func verifyRecord(offset int, base BaseSignature, rec []byte) verifiedRecord {
+	v := verifiedRecord{offset: offset, name: signature.TypeName(base.Signature_type), size: int(base.Signature_size)}
+
+	// The layouts give the sizes with the 2 byte CRC
+	w := signature.CheckSize(rec)
+	layout := base
+	layout.Signature_size -= uint16(w - 2)
+	exact, minimum, err := expectedSize(layout, rec)
+	if exact > 0 {
+		exact += w - 2
+	}
+	minimum += w - 2
+	if err != nil {
+		v.problems = append(v.problems, err.Error())
+	} else if exact > 0 && v.size != exact {
+		v.problems = append(v.problems, fmt.Sprintf("signature_size %d, the layout gives %d", v.size, exact))
+	} else if v.size < minimum {
+		v.problems = append(v.problems, fmt.Sprintf("signature_size %d, the layout needs at least %d", v.size, minimum))
+	}
+
+	if len(rec) < v.size {
+		v.problems = append(v.problems, fmt.Sprintf("truncated, %d of %d bytes in the file", len(rec), v.size))
+		return v
+	}
+	label := "CRC"
+	if check := signature.CheckName(rec); check != signature.CHECK_XMODEM {
+		label = check
+		v.name += " (" + check + ")"
+	}
+	if stored, computed := signature.RecordCheck(rec, v.size-w); !bytes.Equal(stored, computed) {
+		v.problems = append(v.problems, fmt.Sprintf("%s %X, computed %X", label, stored, computed))
+	}
+	return v
+}
+
+// verifyCbor checks a CBOR record, it tells if the record is the EUI64 one.
+// The layout of a CBOR record is checked by decoding it.
+func verifyCbor(offset int, rec []byte) (verifiedRecord, bool, error) {
+	size, err := signature.RecordLength(rec)
+	if err != nil {
+		return verifiedRecord{}, false, err
+	}
+	v := verifiedRecord{offset: offset, name: "cbor", size: size}
+	if len(rec) < size {
+		v.problems = append(v.problems, fmt.Sprintf("truncated, %d of %d bytes in the file", len(rec), size))
+		return v, false, nil
+	}
+	base, err := cborBase(rec)
+	if err != nil {
+		v.problems = append(v.problems, err.Error())
+		return v, false, nil
+	}
+	v.name = signature.TypeName(base.Signature_type) + " (cbor)"
+	return v, base.Signature_type == SIGNATURE_TYPE_EUI64, nil
+}
+
+// verifyTlv checks a version 4 record, the TLVs take the place of the
+// layout.
+func verifyTlv(offset int, base BaseSignature, rec []byte) verifiedRecord {
+	v := verifiedRecord{offset: offset, name: signature.TypeName(base.Signature_type) + " (v4)", size: int(base.Signature_size)}
+	if len(rec) < v.size {
+		v.problems = append(v.problems, fmt.Sprintf("truncated, %d of %d bytes in the file", len(rec), v.size))
+		return v
+	}
+	var gen UserSignature
+	if _, err := gen.DeserializeTlv(rec); err != nil {
+		v.problems = append(v.problems, err.Error())
+	}
+	return v
+}
+
+// cborBase decodes the common fields of a CBOR record.
+func cborBase(rec []byte) (BaseSignature, error) {
+	var gen UserSignature
+	sig, _, err := gen.DeserializeCbor(rec)
+	if err != nil {
+		return BaseSignature{}, err
+	}
+	if sig == nil {
+		return BaseSignature{}, errors.New("CBOR record of an unknown type")
+	}
+	return reflect.ValueOf(sig).FieldByName("BaseSignature").Interface().(BaseSignature), nil
+}
+
+// recordType returns the type of the record at the start of data, binary,
+// TLV or CBOR.
+func recordType(rec []byte) uint8 {
+	if signature.IsCborRecord(rec) {
+		base, err := cborBase(rec)
+		if err != nil {
+			return 0xFF
+		}
+		return base.Signature_type
+	}
+	if len(rec) < 6 {
+		return 0xFF
+	}
+	return rec[5]
+}
+
+// verifyArea checks the records of a signature area, returns the records and
+// the problems of the area as a whole.
+func verifyArea(data []byte) ([]verifiedRecord, []string) {
+	var gen UserSignature
+	var base BaseSignature
+	basesz := binary.Size(base)
+	records := make([]verifiedRecord, 0)
+	problems := make([]string, 0)
+
+	euis := 0
+	offset := 0
+	for offset+basesz <= len(data) {
+		if signature.IsCborRecord(data[offset:]) {
+			v, eui, err := verifyCbor(offset, data[offset:])
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("CBOR record at %d: %s", offset, err))
+				break
+			}
+			if eui {
+				euis++
+			}
+			records = append(records, v)
+			offset += v.size
+			continue
+		}
+		base, _ = gen.DeserializeBaseSignature(data[offset:])
+		if base.Signature_size == 0xFFFF {
+			break
+		}
+		if int(base.Signature_size) < basesz+2 || base.Signature_size > MAX_SIGNATURE_LENGTH {
+			problems = append(problems, fmt.Sprintf("signature_size %d at %d is not a record size", base.Signature_size, offset))
+			break
+		}
+		if base.Signature_type == SIGNATURE_TYPE_EUI64 {
+			euis++
+		}
+		if base.Sig_version_major == signature.TLV_VERSION_MAJOR {
+			records = append(records, verifyTlv(offset, base, data[offset:]))
+		} else {
+			records = append(records, verifyRecord(offset, base, data[offset:]))
+		}
+		offset += int(base.Signature_size)
+	}
+
+	if offset < len(data) {
+		if rest := data[offset:]; len(trimErased(rest)) > 0 {
+			problems = append(problems, fmt.Sprintf("%d bytes at %d are not a record: %s", len(rest), offset, hexBytes(rest, 16)))
+		}
+	}
+	if euis != 1 {
+		problems = append(problems, fmt.Sprintf("%d EUI-64 records, exactly one is needed", euis))
+	}
+	return records, problems
+}
+
+// verifyLicenses checks the validity windows of the licenses in the area at
+// a time, returns the number of licenses that are not valid.
+func verifyLicenses(data []byte, at time.Time) int {
+	var gen UserSignature
+	sigs, err := gen.DeserializeArea(data)
+	if err != nil {
+		// verifyArea has told what is wrong
+		return 0
+	}
+	failed := 0
+	for _, s := range sigs {
+		if l, ok := s.(LicenseV2Signature); ok {
+			if err := l.Check(at); err != nil {
+				fmt.Printf("FAIL %s\n", err)
+				failed++
+			} else {
+				fmt.Printf("OK   license valid at %s\n", TimestampString(at))
+			}
+		}
+	}
+	return failed
+}
+
+func verifyMain(file string, keyfile string, at time.Time) {
+	data, err := readSigdirFile(file)
+	if err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(3)
+	}
+
+	records, problems := verifyArea(data)
+	failed := len(problems)
+	for n, r := range records {
+		status := "OK"
+		if len(r.problems) > 0 {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%-4s %2d %-14s at %4d, %4d bytes\n", status, n, r.name, r.offset, r.size)
+		for _, p := range r.problems {
+			fmt.Printf("       %s\n", p)
+		}
+	}
+	for _, p := range problems {
+		fmt.Printf("FAIL %s\n", p)
+	}
+	failed += verifyLicenses(data, at)
+	if len(keyfile) > 0 {
+		if err := verifyAreaSignature(data, keyfile); err != nil {
+			fmt.Printf("FAIL %s\n", err)
+			failed++
+		} else {
+			fmt.Printf("OK   area signature\n")
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%s failed verification, %s\n", file, plural(failed, "failure"))
+		os.Exit(4)
+	}
+	fmt.Printf("%s verified, %s\n", file, plural(len(records), "record"))
+}
@@ -0,0 +1,31 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package eui forwards to go.thinnect.net/euisig/eui, where the
+// package moved with the separately versioned go.thinnect.net/euisig module.
+//
+// Deprecated: import go.thinnect.net/euisig/eui instead. The
+// shims stay until the next major version of the format.
+package eui
+
+import "go.thinnect.net/euisig/eui"
+
+// Deprecated: use the constants of go.thinnect.net/euisig/eui.
+const (
+	EUI_FORMATS = eui.EUI_FORMATS
+)
+
+// Deprecated: use the types of go.thinnect.net/euisig/eui.
+type (
+	Eui64 = eui.Eui64
+	Id128 = eui.Id128
+)
+
+// Deprecated: use the functions of go.thinnect.net/euisig/eui.
+var (
+	DeriveId128 = eui.DeriveId128
+	Parse       = eui.Parse
+	Parse128    = eui.Parse128
+	ParseUuid   = eui.ParseUuid
+	UuidString  = eui.UuidString
+)
@@ -0,0 +1,12 @@
+// Author  Raido Pahtma
+// License MIT
+
+package main
+
+import "os"
+
+import "github.com/thinnect/euisiggen/cli/euigen"
+
+func main() {
+	euigen.Main("euigen", os.Args[1:])
+}
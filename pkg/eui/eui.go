@@ -0,0 +1,199 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package eui has the identifier types shared by the tools, EUI-64 and the
+// extended 128-bit identifier, and the conversion of EUI-64s to IPv6
+// interface identifiers, as 6LoWPAN derives them. The API is stable like the
+// one of the signature package.
+package eui
+
+import "fmt"
+import "net"
+import "errors"
+import "strconv"
+import "strings"
+import "encoding/hex"
+import "encoding/binary"
+import "crypto/sha1"
+
+type Eui64 uint64
+
+func (self Eui64) String() string {
+	return fmt.Sprintf("%016X", uint64(self))
+}
+
+func (self Eui64) Canonical() string {
+	var i uint8
+	s := ""
+	for i = 7; i > 0; i-- {
+		s = fmt.Sprintf("%s%02X-", s, uint8(self>>(8*i)))
+	}
+	return fmt.Sprintf("%s%02X", s, uint8(self))
+}
+
+func (self *Eui64) UnmarshalFlag(s string) error {
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*self = v
+
+	return nil
+}
+
+func (v Eui64) MarshalFlag() (string, error) {
+	return fmt.Sprintf("%016X", v), nil
+}
+
+// EUI_FORMATS lists the forms Parse accepts, for the error messages.
+const EUI_FORMATS = "0011223344556677, 00-11-22-33-44-55-66-77 or 00:11:22:33:44:55:66:77"
+
+// Parse parses an EUI-64 given as 16 hex digits or in the canonical form,
+// the bytes separated by dashes as in the euigen lists, or by colons.
+func Parse(s string) (Eui64, error) {
+	h := s
+	if len(s) == 23 {
+		sep := s[2:3]
+		if sep != "-" && sep != ":" {
+			return 0, fmt.Errorf("%s is not a valid EUI-64, use %s", s, EUI_FORMATS)
+		}
+		groups := strings.Split(s, sep)
+		if len(groups) != 8 {
+			return 0, fmt.Errorf("%s is not a valid EUI-64, mixed separators, use %s", s, EUI_FORMATS)
+		}
+		for _, g := range groups {
+			if len(g) != 2 {
+				return 0, fmt.Errorf("%s is not a valid EUI-64, use %s", s, EUI_FORMATS)
+			}
+		}
+		h = strings.Join(groups, "")
+	} else if len(s) != 16 {
+		return 0, errors.New(fmt.Sprintf("%s is not a valid EUI-64, length %d, use %s", s, len(s), EUI_FORMATS))
+	}
+
+	v, err := strconv.ParseUint(h, 16, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("%s is not a valid EUI-64, use %s", s, EUI_FORMATS))
+	}
+
+	return Eui64(v), nil
+}
+
+// InterfaceIdentifier returns the modified EUI-64 interface identifier,
+// the EUI-64 with the universal/local bit inverted (RFC 4291 appendix A).
+func (self Eui64) InterfaceIdentifier() uint64 {
+	return uint64(self) ^ 0x0200000000000000
+}
+
+// IidString formats the interface identifier like the lower half of an IPv6
+// address, 0211:2233:4455:0001.
+func (self Eui64) IidString() string {
+	iid := self.InterfaceIdentifier()
+	return fmt.Sprintf("%04x:%04x:%04x:%04x", uint16(iid>>48), uint16(iid>>32), uint16(iid>>16), uint16(iid))
+}
+
+// Address combines a /64 (or shorter) prefix with the interface identifier.
+func (self Eui64) Address(prefix *net.IPNet) (net.IP, error) {
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 || prefix.IP.To4() != nil {
+		return nil, errors.New(fmt.Sprintf("%s is not an IPv6 prefix", prefix))
+	}
+	if ones > 64 {
+		return nil, errors.New(fmt.Sprintf("prefix %s is longer than 64 bits, no room for the interface identifier", prefix))
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16()[:8])
+	iid := self.InterfaceIdentifier()
+	for i := 0; i < 8; i++ {
+		ip[8+i] = byte(iid >> (8 * uint(7-i)))
+	}
+	return ip, nil
+}
+
+// Id128 is a 128-bit identifier for protocols that need more than an EUI-64.
+type Id128 [16]byte
+
+func (self Id128) String() string {
+	return fmt.Sprintf("%X", self[:])
+}
+
+// hex128 reads 32 hex digits in any of the forms UUIDs are copied around in:
+// with or without dashes, in braces or quotes, as urn:uuid:, in any case.
+func hex128(s string) ([16]byte, bool) {
+	var v [16]byte
+	h := strings.Trim(strings.TrimSpace(s), "\"'")
+	if len(h) > 9 && strings.EqualFold(h[:9], "urn:uuid:") {
+		h = h[9:]
+	}
+	if strings.HasPrefix(h, "{") && strings.HasSuffix(h, "}") {
+		h = h[1 : len(h)-1]
+	}
+	b, err := hex.DecodeString(strings.Replace(h, "-", "", -1))
+	if err != nil || len(b) != len(v) {
+		return v, false
+	}
+	copy(v[:], b)
+	return v, true
+}
+
+// Parse128 parses the 32 hex digit form of a 128-bit identifier, UUID
+// notation works too.
+func Parse128(s string) (Id128, error) {
+	id, ok := hex128(s)
+	if !ok {
+		return id, errors.New(fmt.Sprintf("%s is not a valid 128-bit identifier", s))
+	}
+	return Id128(id), nil
+}
+
+// ParseUuid parses a UUID with or without dashes, braces or urn:uuid:, in
+// upper or lower case.
+func ParseUuid(s string) ([16]byte, error) {
+	u, ok := hex128(s)
+	if !ok {
+		return u, errors.New(fmt.Sprintf("%s is not a valid UUID", s))
+	}
+	return u, nil
+}
+
+// UuidString formats a UUID in the canonical form, lower case with dashes.
+func UuidString(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func (self *Id128) UnmarshalFlag(s string) error {
+	v, err := Parse128(s)
+	if err != nil {
+		return err
+	}
+	*self = v
+	return nil
+}
+
+// Next returns the identifier incremented by one.
+func (self Id128) Next() Id128 {
+	for i := len(self) - 1; i >= 0; i-- {
+		self[i]++
+		if self[i] != 0 {
+			break
+		}
+	}
+	return self
+}
+
+// DeriveId128 derives a 128-bit identifier from an EUI-64 within a namespace,
+// a name based (version 5) UUID of the EUI-64 bytes.
+func DeriveId128(namespace [16]byte, e Eui64) Id128 {
+	var name [8]byte
+	binary.BigEndian.PutUint64(name[:], uint64(e))
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write(name[:])
+	var id Id128
+	copy(id[:], h.Sum(nil))
+	id[6] = (id[6] & 0x0F) | 0x50
+	id[8] = (id[8] & 0x3F) | 0x80
+	return id
+}
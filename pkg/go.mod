@@ -0,0 +1,3 @@
+module go.thinnect.net/euisig
+
+go 1.24
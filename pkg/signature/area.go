@@ -0,0 +1,267 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "bytes"
+import "errors"
+import "time"
+import "math/big"
+import "crypto"
+import "crypto/rand"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "crypto/sha256"
+import "crypto/ed25519"
+import "encoding/asn1"
+import "encoding/binary"
+import "encoding/json"
+
+// Area signature record, the last record of a signature area. The signature
+// of the factory key covers every byte of the area before the record and the
+// record itself up to the signature, as written, so devices and backend
+// services can tell the area has not been changed since it was written and
+// check it on the bytes they read. The key is identified by the first 8 bytes
+// of the SHA-256 of the public key, the key tells the algorithm: an Ed25519
+// signature, or for an ECDSA P-256 key, as hardware keys in a TPM are, r and
+// s of the ECDSA signature of the SHA-256, 32 bytes each, big endian. The
+// public key of ECDSA is hashed as the uncompressed point.
+
+type KeyId [8]byte
+
+func (k KeyId) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%X", k[:]))
+}
+
+// KeyIdOf returns the identifier of a public key.
+func KeyIdOf(key ed25519.PublicKey) KeyId {
+	var id KeyId
+	h := sha256.Sum256(key)
+	copy(id[:], h[:])
+	return id
+}
+
+// PublicKeyId returns the identifier of an Ed25519 or ECDSA P-256 public key.
+func PublicKeyId(key crypto.PublicKey) (KeyId, error) {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return KeyIdOf(k), nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return KeyId{}, fmt.Errorf("ECDSA key on %s, areas are signed on P-256", k.Curve.Params().Name)
+		}
+		point, err := k.ECDH()
+		if err != nil {
+			return KeyId{}, err
+		}
+		var id KeyId
+		h := sha256.Sum256(point.Bytes())
+		copy(id[:], h[:])
+		return id, nil
+	}
+	return KeyId{}, fmt.Errorf("%T keys do not sign areas, Ed25519 and ECDSA P-256 keys do", key)
+}
+
+type AreaSignature struct {
+	BaseSignature
+
+	Key_id    KeyId            `json:"key_id"`    // Identifier of the factory key
+	Signature Ed25519Signature `json:"signature"` // Ed25519 or ECDSA P-256 signature over the area before it
+
+	// crc uint16
+}
+
+// ConstructAreaSignature signs area, the records the signature is appended to.
+func (self *Generator) ConstructAreaSignature(t time.Time, area []byte, key ed25519.PrivateKey) (*AreaSignature, error) {
+	return self.ConstructAreaSignatureWith(t, area, key)
+}
+
+// ConstructAreaSignatureWith signs area with a signer of an Ed25519 or ECDSA
+// P-256 key, a key in memory or one kept in hardware.
+func (self *Generator) ConstructAreaSignatureWith(t time.Time, area []byte, signer crypto.Signer) (*AreaSignature, error) {
+	sig := new(AreaSignature)
+	sig.BaseSignature = self.newBase(t, SIGNATURE_TYPE_AREA_SIGNATURE, binary.Size(sig))
+
+	if len(area) == 0 {
+		return nil, errors.New("There is no signature area to sign")
+	}
+	id, err := PublicKeyId(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	sig.Key_id = id
+
+	record, err := self.Serialize(sig)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := signedArea(area, record)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signBytes(signer, signed)
+	if err != nil {
+		return nil, err
+	}
+	copy(sig.Signature[:], raw)
+	return sig, nil
+}
+
+// signBytes signs data, Ed25519 as is, ECDSA its SHA-256 with r and s of 32
+// bytes.
+func signBytes(signer crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(nil, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	var rs struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(der, &rs); err != nil || len(rest) > 0 || rs.R.BitLen() > 256 || rs.S.BitLen() > 256 {
+		return nil, errors.New("ECDSA signer returned a malformed signature")
+	}
+	raw := make([]byte, 64)
+	rs.R.FillBytes(raw[:32])
+	rs.S.FillBytes(raw[32:])
+	return raw, nil
+}
+
+// verifyBytes checks a signature made by signBytes.
+func verifyBytes(key crypto.PublicKey, data []byte, sig []byte) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	}
+	return false
+}
+
+// signedArea returns the bytes the signature covers, the area before the
+// record and the record as written before the signature, in its byte order
+// and with its flags. The signature of a CBOR record is in the middle of the
+// map, the record before its CRC is covered with the signature all zero.
+func signedArea(area []byte, record []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, area...))
+	end := binary.Size(AreaSignature{}) - ed25519.SignatureSize
+	switch {
+	case IsCborRecord(record):
+		off, length, err := cborFieldOffset(record, "signature")
+		if err != nil {
+			return nil, err
+		}
+		rec := append([]byte{}, record[:len(record)-2]...)
+		copy(rec[off:off+length], make([]byte, length))
+		buf.Write(rec)
+		return buf.Bytes(), nil
+	case IsTlvRecord(record):
+		end = -1
+		err := walkTlv(record, len(record)-CheckSize(record), func(tag uint8, off int, length int) {
+			if tag == g_tlv_tags["signature"] {
+				end = off
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if end < 0 {
+			return nil, errors.New("Area signature record has no signature TLV")
+		}
+	}
+	if len(record) < end {
+		return nil, fmt.Errorf("Area signature record of %d bytes is truncated", len(record))
+	}
+	buf.Write(record[:end])
+	return buf.Bytes(), nil
+}
+
+func (self *Generator) DeserializeAreaSignature(sig_bytes []byte) (AreaSignature, error) {
+	var ret AreaSignature
+	err := deserializeFixed(sig_bytes, &ret)
+	return ret, err
+}
+
+// VerifyArea checks the area signature of a signature area with the public
+// key of the factory. The area signature must be the last record, only 0xFF
+// padding may follow it.
+func (self *Generator) VerifyArea(data []byte, key ed25519.PublicKey) error {
+	return self.VerifyAreaWith(data, key)
+}
+
+// VerifyAreaWith checks the area signature with an Ed25519 or ECDSA P-256
+// public key of the factory.
+func (self *Generator) VerifyAreaWith(data []byte, key crypto.PublicKey) error {
+	id, err := PublicKeyId(key)
+	if err != nil {
+		return err
+	}
+	for rd := 0; rd < len(data); {
+		var sig AreaSignature
+		var length int
+		if IsCborRecord(data[rd:]) {
+			rec, n, err := self.DeserializeCbor(data[rd:])
+			if err != nil {
+				return fmt.Errorf("Record at %d: %s", rd, err)
+			}
+			s, ok := rec.(AreaSignature)
+			if !ok {
+				rd += n
+				continue
+			}
+			sig, length = s, n
+		} else {
+			base, err := self.DeserializeBaseSignature(data[rd:])
+			if err != nil || base.Signature_size == 0xFFFF {
+				break
+			}
+			if base.Signature_size == 0 || base.Signature_size > MAX_SIGNATURE_LENGTH {
+				return fmt.Errorf("Record at %d has size %d", rd, base.Signature_size)
+			}
+			if base.Signature_type != SIGNATURE_TYPE_AREA_SIGNATURE {
+				rd += int(base.Signature_size)
+				continue
+			}
+			if base.Sig_version_major == TLV_VERSION_MAJOR {
+				rec, err := self.DeserializeTlv(data[rd:])
+				if err != nil {
+					return err
+				}
+				sig = rec.(AreaSignature)
+			} else if sig, err = self.DeserializeAreaSignature(data[rd:]); err != nil {
+				return err
+			}
+			length = int(base.Signature_size)
+		}
+		if sig.Key_id != id {
+			return fmt.Errorf("Area is signed with key %X, not with key %X", sig.Key_id[:], id[:])
+		}
+		signed, err := signedArea(data[:rd], data[rd:rd+length])
+		if err != nil {
+			return fmt.Errorf("Record at %d: %s", rd, err)
+		}
+		if !verifyBytes(key, signed, sig.Signature[:]) {
+			return errors.New("Area signature does not verify")
+		}
+		if rest := data[rd+length:]; !erased(rest) {
+			return fmt.Errorf("%d bytes after the area signature are not covered by it", len(rest))
+		}
+		return nil
+	}
+	return errors.New("Area is not signed")
+}
+
+// erased tells if the data is all erased flash, 0xFF bytes.
+func erased(data []byte) bool {
+	for _, b := range data {
+		if b != 0xFF {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "bytes"
+import "strings"
+import "testing"
+import "time"
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "crypto/rand"
+import "crypto/ed25519"
+
+// areaFormats are the generators of the formats an area signature is
+// written in.
+var areaFormats = []struct {
+	name       string
+	version    int
+	endianness string
+	check      string
+}{
+	{"v3", 3, ENDIANNESS_BIG, CHECK_XMODEM},
+	{"v3 little", 3, ENDIANNESS_LITTLE, CHECK_XMODEM},
+	{"v3 ccitt", 3, ENDIANNESS_BIG, CHECK_CCITT},
+	{"v3 sha256", 3, ENDIANNESS_LITTLE, CHECK_SHA256},
+	{"cbor", 3, ENDIANNESS_BIG, CHECK_XMODEM},
+	{"v4", TLV_VERSION_MAJOR, ENDIANNESS_BIG, CHECK_XMODEM},
+	{"v4 little", TLV_VERSION_MAJOR, ENDIANNESS_LITTLE, CHECK_XMODEM},
+	{"v4 crc32", TLV_VERSION_MAJOR, ENDIANNESS_LITTLE, CHECK_CRC32},
+}
+
+// The area signature covers the area and the record as written up to the
+// signature, with an Ed25519 and an ECDSA P-256 key.
+func TestAreaSignature(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]crypto.Signer{
+		"ed25519": ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x5A}, ed25519.SeedSize)),
+		"p256":    p256,
+	}
+	for name, key := range keys {
+		testAreaSignature(t, name, key)
+	}
+}
+
+func testAreaSignature(t *testing.T, name string, key crypto.Signer) {
+	ts := time.Unix(1700000000, 0)
+	pub := key.Public()
+
+	for _, f := range areaFormats {
+		f.name = name + " " + f.name
+		g := new(Generator)
+		if err := g.SetSigVersion(f.version); err != nil {
+			t.Fatal(err)
+		}
+		g.SetEndianness(f.endianness)
+		g.SetCheck(f.check)
+		if strings.HasSuffix(f.name, " cbor") {
+			g.SetWireFormat(WIRE_FORMAT_CBOR)
+		}
+
+		eui, _ := g.ConstructEUISignature(ts, 0x70B3D5D72F000001)
+		area, err := g.Serialize(eui)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asig, err := g.ConstructAreaSignatureWith(ts, area, key)
+		if err != nil {
+			t.Fatalf("%s: %s", f.name, err)
+		}
+		record, err := g.Serialize(asig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := append(append([]byte{}, area...), record...)
+		if err := g.VerifyAreaWith(data, pub); err != nil {
+			t.Errorf("%s: %s", f.name, err)
+		}
+
+		end := len(record) - CheckSize(record) - len(asig.Signature)
+		signed := append(append([]byte{}, area...), record[:end]...)
+		if IsCborRecord(record) {
+			// The signature is in the map, the record is signed with it zero
+			end = len(record) - CheckSize(record)
+			signed = append(append([]byte{}, area...), record[:end]...)
+			at := len(area) + bytes.Index(record, asig.Signature[:])
+			copy(signed[at:], make([]byte, len(asig.Signature)))
+		}
+		if !verifyBytes(pub, signed, asig.Signature[:]) {
+			t.Errorf("%s: the signature does not cover the record as written", f.name)
+		}
+
+		// A changed byte of the record before the signature, with a valid
+		// check, fails the signature
+		for _, at := range []int{0, 6, end - 1} {
+			changed := append([]byte{}, data...)
+			rec := changed[len(area):]
+			rec[at] ^= 0x01
+			Recheck(rec)
+			if err := g.VerifyAreaWith(changed, pub); err == nil {
+				t.Errorf("%s: changed byte %d of the record verifies", f.name, at)
+			}
+		}
+	}
+}
+
+// Keys the devices cannot check are refused.
+func TestAreaSignatureKeys(t *testing.T) {
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := new(Generator)
+	if _, err := g.ConstructAreaSignatureWith(time.Unix(1700000000, 0), []byte{0x83}, p384); err == nil {
+		t.Errorf("signed with a P-384 key")
+	}
+	if err := g.VerifyAreaWith([]byte{0x83}, &p384.PublicKey); err == nil {
+		t.Errorf("verified with a P-384 key")
+	}
+}
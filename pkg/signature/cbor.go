@@ -0,0 +1,460 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "sort"
+import "bytes"
+import "errors"
+import "reflect"
+import "strings"
+import "encoding/binary"
+
+// CBOR wire format, for platforms whose bootloader parses CBOR instead of
+// the fixed C structs. A CBOR record is the self-described CBOR tag 55799, a
+// map of the fields of the record by their JSON names and the CRC of both.
+// The encoding is the core deterministic one of RFC 8949: the shortest
+// integers, definite lengths and the map keys in bytewise order of their
+// encoding, so the same record always gives the same bytes. The size is not
+// a field, the CBOR item has a length of its own. The first byte of the tag,
+// 0xD9, is never the major version of a binary header, so readers tell the
+// two apart record by record. Readers ignore the fields they do not know.
+
+const WIRE_FORMAT_BINARY = "binary"
+const WIRE_FORMAT_CBOR = "cbor"
+
+var WireFormats = []string{WIRE_FORMAT_BINARY, WIRE_FORMAT_CBOR}
+
+var g_cbor_tag = []byte{0xD9, 0xD9, 0xF7} // Tag 55799, self-described CBOR
+
+const (
+	cborUint  = 0
+	cborNint  = 1
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+	cborTag   = 6
+	cborOther = 7
+)
+
+// SetWireFormat selects how the records are serialized, binary or cbor.
+func (self *Generator) SetWireFormat(format string) error {
+	switch format {
+	case WIRE_FORMAT_BINARY, "":
+		self.cbor = false
+	case WIRE_FORMAT_CBOR:
+		self.cbor = true
+	default:
+		return fmt.Errorf("Unknown wire format %s, known formats are: %s", format, strings.Join(WireFormats, ", "))
+	}
+	return nil
+}
+
+// IsCborRecord tells if the record at the start of data is a CBOR record.
+func IsCborRecord(data []byte) bool {
+	return bytes.HasPrefix(data, g_cbor_tag)
+}
+
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		buf.WriteByte(m | byte(n))
+	case n <= 0xFF:
+		buf.WriteByte(m | 24)
+		buf.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		buf.WriteByte(m | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= 0xFFFFFFFF:
+		buf.WriteByte(m | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(m | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func cborValue(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		cborHead(buf, cborUint, v.Uint())
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i >= 0 {
+			cborHead(buf, cborUint, uint64(i))
+		} else {
+			cborHead(buf, cborNint, uint64(-1-i))
+		}
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xF5)
+		} else {
+			buf.WriteByte(0xF4)
+		}
+	case reflect.Array, reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("%s can not be encoded", v.Type())
+		}
+		cborHead(buf, cborBytes, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			buf.WriteByte(byte(v.Index(i).Uint()))
+		}
+	default:
+		return fmt.Errorf("%s can not be encoded", v.Type())
+	}
+	return nil
+}
+
+// cborFields returns the fields of a record by their JSON names, the ones of
+// embedded structures included. The size is left out.
+func cborFields(v reflect.Value) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for name, fv := range cborFields(v.Field(i)) {
+				fields[name] = fv
+			}
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(f.PkgPath) > 0 || len(name) == 0 || name == "-" || name == "signature_size" {
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+type cborPair struct {
+	key   []byte
+	value []byte
+}
+
+// encodeCbor returns the deterministic CBOR map of a record.
+func encodeCbor(sig interface{}) ([]byte, error) {
+	v := reflect.Indirect(reflect.ValueOf(sig))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is not a record", sig)
+	}
+	pairs := make([]cborPair, 0)
+	for name, fv := range cborFields(v) {
+		var k, e bytes.Buffer
+		cborHead(&k, cborText, uint64(len(name)))
+		k.WriteString(name)
+		if err := cborValue(&e, fv); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		pairs = append(pairs, cborPair{k.Bytes(), e.Bytes()})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+
+	buf := new(bytes.Buffer)
+	cborHead(buf, cborMap, uint64(len(pairs)))
+	for _, p := range pairs {
+		buf.Write(p.key)
+		buf.Write(p.value)
+	}
+	return buf.Bytes(), nil
+}
+
+// serializeCbor returns a record in the CBOR wire format.
+func serializeCbor(sig interface{}) ([]byte, error) {
+	body, err := encodeCbor(sig)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(append([]byte{}, g_cbor_tag...))
+	buf.Write(body)
+	if buf.Len()+2 > MAX_SIGNATURE_LENGTH {
+		return nil, fmt.Errorf("CBOR record of %d bytes is too long, maximum is %d", buf.Len()+2, MAX_SIGNATURE_LENGTH)
+	}
+	binary.Write(buf, binary.BigEndian, Crc(buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// readCborHead returns the major type and the argument of the item at the
+// start of data and the length of its head.
+func readCborHead(data []byte) (byte, uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, 0, errors.New("CBOR item truncated")
+	}
+	info := data[0] & 0x1F
+	n := uint64(info)
+	pos := 1
+	switch {
+	case info < 24:
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < 1+size {
+			return 0, 0, 0, errors.New("CBOR item truncated")
+		}
+		n = 0
+		for _, b := range data[1 : 1+size] {
+			n = n<<8 | uint64(b)
+		}
+		pos += size
+	default:
+		return 0, 0, 0, fmt.Errorf("CBOR item %02X has no definite length", data[0])
+	}
+	return data[0] >> 5, n, pos, nil
+}
+
+// decodeCbor decodes the item at the start of data, it returns the value and
+// the length of the item. Maps have text keys, integers are uint64 or int64,
+// byte strings []byte. Indefinite lengths are not deterministic, they are
+// refused.
+func decodeCbor(data []byte, depth int) (interface{}, int, error) {
+	if depth > 8 {
+		return nil, 0, errors.New("CBOR items nested too deep")
+	}
+	major, n, pos, err := readCborHead(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case cborUint:
+		return n, pos, nil
+	case cborNint:
+		if n > 1<<63-1 {
+			return nil, 0, errors.New("CBOR negative integer out of range")
+		}
+		return -1 - int64(n), pos, nil
+	case cborBytes, cborText:
+		if uint64(len(data)-pos) < n {
+			return nil, 0, errors.New("CBOR string truncated")
+		}
+		s := data[pos : pos+int(n)]
+		if major == cborText {
+			return string(s), pos + int(n), nil
+		}
+		return append([]byte{}, s...), pos + int(n), nil
+	case cborArray:
+		list := make([]interface{}, 0)
+		for i := uint64(0); i < n; i++ {
+			v, l, err := decodeCbor(data[pos:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, v)
+			pos += l
+		}
+		return list, pos, nil
+	case cborMap:
+		m := make(map[string]interface{})
+		for i := uint64(0); i < n; i++ {
+			k, l, err := decodeCbor(data[pos:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("CBOR map key %v is not text", k)
+			}
+			pos += l
+			v, l, err := decodeCbor(data[pos:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			pos += l
+		}
+		return m, pos, nil
+	case cborTag:
+		v, l, err := decodeCbor(data[pos:], depth+1)
+		return v, pos + l, err
+	default:
+		switch data[0] {
+		case 0xF4:
+			return false, pos, nil
+		case 0xF5:
+			return true, pos, nil
+		}
+		return nil, 0, fmt.Errorf("CBOR simple value %02X is not supported", data[0])
+	}
+}
+
+// cborFieldOffset returns the offset and the length of the contents of a
+// byte string field of the CBOR record at the start of data.
+func cborFieldOffset(data []byte, name string) (int, int, error) {
+	pos := len(g_cbor_tag)
+	major, n, l, err := readCborHead(data[pos:])
+	if err != nil {
+		return 0, 0, err
+	}
+	if major != cborMap {
+		return 0, 0, errors.New("CBOR record is not a map")
+	}
+	pos += l
+	for i := uint64(0); i < n; i++ {
+		key, l, err := decodeCbor(data[pos:], 1)
+		if err != nil {
+			return 0, 0, err
+		}
+		pos += l
+		if key == name {
+			major, length, l, err := readCborHead(data[pos:])
+			if err != nil {
+				return 0, 0, err
+			}
+			if major != cborBytes || uint64(len(data)-pos-l) < length {
+				return 0, 0, fmt.Errorf("CBOR %s is not a byte string", name)
+			}
+			return pos + l, int(length), nil
+		}
+		_, l, err = decodeCbor(data[pos:], 1)
+		if err != nil {
+			return 0, 0, err
+		}
+		pos += l
+	}
+	return 0, 0, fmt.Errorf("CBOR record has no %s", name)
+}
+
+// setCborField sets a field of a record from its decoded value.
+func setCborField(name string, f reflect.Value, value interface{}) error {
+	switch f.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, ok := value.(uint64)
+		if !ok || f.OverflowUint(u) {
+			return fmt.Errorf("%s is not a %s", name, f.Type())
+		}
+		f.SetUint(u)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch t := value.(type) {
+		case uint64:
+			if t > 1<<63-1 {
+				return fmt.Errorf("%s is out of range", name)
+			}
+			i = int64(t)
+		case int64:
+			i = t
+		default:
+			return fmt.Errorf("%s is not an integer", name)
+		}
+		if f.OverflowInt(i) {
+			return fmt.Errorf("%s is out of range", name)
+		}
+		f.SetInt(i)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%s is not a boolean", name)
+		}
+		f.SetBool(b)
+	case reflect.Array:
+		b, ok := value.([]byte)
+		if !ok || len(b) > f.Len() {
+			return fmt.Errorf("%s is not %d bytes", name, f.Len())
+		}
+		reflect.Copy(f, reflect.ValueOf(b))
+	case reflect.Slice:
+		b, ok := value.([]byte)
+		if !ok {
+			return fmt.Errorf("%s is not a byte string", name)
+		}
+		f.SetBytes(b)
+	default:
+		return fmt.Errorf("%s can not be decoded", name)
+	}
+	return nil
+}
+
+// cborRecordOf returns an empty record of a type, nil for types that are not
+// known.
+func cborRecordOf(t uint8, fields map[string]interface{}) interface{} {
+	switch t {
+	case SIGNATURE_TYPE_EUI64:
+		return new(EUISignature)
+	case SIGNATURE_TYPE_BOARD, SIGNATURE_TYPE_PLATFORM, SIGNATURE_TYPE_COMPONENT:
+		if d, ok := fields["data"].([]byte); ok && len(d) > 0 {
+			return new(ComponentDataSignature)
+		}
+		if s, ok := fields["slot"].(uint64); ok && s != 0 {
+			return new(ComponentDataSignature)
+		}
+		return new(ComponentSignature)
+	case SIGNATURE_TYPE_LICENSE:
+		return new(LicenseSignature)
+	case SIGNATURE_TYPE_COMPLIANCE:
+		return new(ComplianceSignature)
+	case SIGNATURE_TYPE_EXTENDED_ID:
+		return new(ExtendedIdSignature)
+	case SIGNATURE_TYPE_DECOMMISSION:
+		return new(DecommissionSignature)
+	case SIGNATURE_TYPE_AREA_SIGNATURE:
+		return new(AreaSignature)
+	case SIGNATURE_TYPE_PIN:
+		return new(PinSignature)
+	case SIGNATURE_TYPE_LICENSE_V2:
+		return new(LicenseV2Signature)
+	}
+	return nil
+}
+
+// DeserializeCbor parses the CBOR record at the start of data, it returns the
+// record by value, nil for a type that is not known, and the length of the
+// record. The signature_size of the record is the one of its binary form.
+func (self *Generator) DeserializeCbor(data []byte) (interface{}, int, error) {
+	if !IsCborRecord(data) {
+		return nil, 0, errors.New("Not a CBOR record")
+	}
+	item, n, err := decodeCbor(data[len(g_cbor_tag):], 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	sz := len(g_cbor_tag) + n
+	if sz+2 > MAX_SIGNATURE_LENGTH {
+		return nil, 0, fmt.Errorf("CBOR record of %d bytes is too long", sz+2)
+	}
+	if err := checkRecord(data, sz); err != nil {
+		return nil, 0, err
+	}
+	fields, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, 0, errors.New("CBOR record is not a map")
+	}
+	t, ok := fields["signature_type"].(uint64)
+	if !ok || t > 0xFF {
+		return nil, 0, errors.New("CBOR record has no signature_type")
+	}
+
+	rec := cborRecordOf(uint8(t), fields)
+	if rec == nil {
+		return nil, sz + 2, nil
+	}
+	v := reflect.ValueOf(rec).Elem()
+	for name, f := range cborFields(v) {
+		if value, ok := fields[name]; ok {
+			if err := setCborField(name, f, value); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	v.FieldByName("Signature_size").SetUint(uint64(binarySize(rec, 2)))
+	if d, ok := rec.(*ComponentDataSignature); ok {
+		d.Data_length = uint16(len(d.Data))
+	}
+	return v.Interface(), sz + 2, nil
+}
+
+// binarySize returns the signature_size of the binary form of a record with
+// a check of w bytes, what the decommission signature covers.
+func binarySize(rec interface{}, w int) int {
+	switch r := rec.(type) {
+	case *ComponentDataSignature:
+		if r.Slot != 0 {
+			w += binary.Size(r.Slot)
+		}
+		return binary.Size(r.ComponentSignature) + len(r.Data) + w
+	case *LicenseSignature:
+		return binary.Size(r.BaseSignature) + len(r.Lic_file) + w
+	case *LicenseV2Signature:
+		return binary.Size(r.BaseSignature) + binary.Size(r.LicenseWindow) + len(r.Lic_file) + w
+	}
+	return binary.Size(rec) + w
+}
@@ -0,0 +1,229 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "bytes"
+import "reflect"
+import "strings"
+import "testing"
+import "time"
+import "crypto/ed25519"
+import "encoding/binary"
+
+// cborRecords returns a record of every type, constructed by g.
+func cborRecords(t *testing.T, g *Generator) []interface{} {
+	ts := time.Unix(1700000000, 0)
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	uuid := Uuid{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	version := BoardVersion{1, 2, 3}
+
+	recs := make([]interface{}, 0)
+	add := func(rec interface{}, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	component := func(name string, sigtype uint8) *ComponentSignature {
+		sig, err := g.ConstructComponentSignature(ts, name, version, uuid, uuid, uuid, 2, sigtype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	add(g.ConstructEUISignature(ts, 0x70B3D5D72F000001))
+	add(g.ConstructEUISignature(time.Unix(-1, 0), 0)) // Negative unix_time
+	add(component("board", SIGNATURE_TYPE_BOARD), nil)
+	add(component("platform", SIGNATURE_TYPE_PLATFORM), nil)
+	add(component("radio", SIGNATURE_TYPE_COMPONENT), nil)
+	add(g.AttachData(component("sensor", SIGNATURE_TYPE_COMPONENT), []byte{0xCA, 0x11, 0xB0}))
+	add(g.AttachSlot(component("antenna", SIGNATURE_TYPE_COMPONENT), SlotHash("antenna")))
+	data, err := g.AttachData(component("probe", SIGNATURE_TYPE_COMPONENT), make([]byte, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(g.AttachSlot(data, SlotHash("probe")))
+	add(g.ConstructComplianceSignature(ts, ComplianceInfo{CountryOfOrigin: "EE", RedId: "RED-1", FccId: "FCC-1", IcId: "IC-1"}))
+	add(g.ConstructExtendedIdSignature(ts, Id128(uuid), uuid))
+	add(g.ConstructDecommissionSignature(ts, 0x70B3D5D72F000001, 1, "factory", key))
+	add(g.ConstructAreaSignature(ts, []byte{0x83, 0x00}, key))
+	add(g.ConstructPinSignature(ts, "123456", Salt(uuid)))
+	lic := []byte("license")
+	recs = append(recs, &LicenseSignature{g.newBase(ts, SIGNATURE_TYPE_LICENSE, binary.Size(BaseSignature{})+len(lic)), lic})
+	window := LicenseWindow{-86400, 1 << 40}
+	recs = append(recs, &LicenseV2Signature{g.newBase(ts, SIGNATURE_TYPE_LICENSE_V2, binary.Size(BaseSignature{})+binary.Size(window)+len(lic)), window, lic})
+	return recs
+}
+
+func TestCborRoundTrip(t *testing.T) {
+	g := new(Generator)
+	if err := g.SetWireFormat(WIRE_FORMAT_CBOR); err != nil {
+		t.Fatal(err)
+	}
+	area := new(bytes.Buffer)
+	wants := make([]interface{}, 0)
+	for _, rec := range cborRecords(t, g) {
+		want := reflect.Indirect(reflect.ValueOf(rec)).Interface()
+		if d, ok := want.(ComponentDataSignature); ok && d.Data == nil {
+			d.Data = Data{} // Read back empty, not nil, as from the binary form
+			want = d
+		}
+		data, err := g.Serialize(rec)
+		if err != nil {
+			t.Fatalf("%T: %s", rec, err)
+		}
+		got, n, err := g.DeserializeCbor(data)
+		if err != nil {
+			t.Errorf("%T: %s", rec, err)
+			continue
+		}
+		if n != len(data) {
+			t.Errorf("%T: length %d, want %d", rec, n, len(data))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%T:\n got %+v\nwant %+v", rec, got, want)
+		}
+		area.Write(data)
+		wants = append(wants, want)
+	}
+
+	area.Write(bytes.Repeat([]byte{0xFF}, 16))
+	sigs, err := g.DeserializeArea(area.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sigs, wants) {
+		t.Errorf("the area does not read back as the records written")
+	}
+}
+
+// The encoding is deterministic: the shortest heads and the map keys in the
+// bytewise order of their encoding.
+func TestCborDeterministic(t *testing.T) {
+	heads := []struct {
+		n    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{23, []byte{0x17}},
+		{24, []byte{0x18, 0x18}},
+		{0xFF, []byte{0x18, 0xFF}},
+		{0x100, []byte{0x19, 0x01, 0x00}},
+		{0xFFFF, []byte{0x19, 0xFF, 0xFF}},
+		{0x10000, []byte{0x1A, 0x00, 0x01, 0x00, 0x00}},
+		{0xFFFFFFFF, []byte{0x1A, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{0x100000000, []byte{0x1B, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for _, h := range heads {
+		buf := new(bytes.Buffer)
+		cborHead(buf, cborUint, h.n)
+		if !bytes.Equal(buf.Bytes(), h.want) {
+			t.Errorf("head of %d is % X, want % X", h.n, buf.Bytes(), h.want)
+		}
+	}
+
+	g := new(Generator)
+	g.SetWireFormat(WIRE_FORMAT_CBOR)
+	for _, rec := range cborRecords(t, g) {
+		first, err := g.Serialize(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 8; i++ {
+			if again, _ := g.Serialize(rec); !bytes.Equal(again, first) {
+				t.Fatalf("%T serializes differently", rec)
+			}
+		}
+
+		body := first[len(g_cbor_tag) : len(first)-2]
+		count, pos := int(body[0]&0x1F), 1
+		var last []byte
+		for i := 0; i < count; i++ {
+			_, kl, err := decodeCbor(body[pos:], 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := body[pos : pos+kl]
+			if last != nil && bytes.Compare(last, key) >= 0 {
+				t.Errorf("%T: key %q after %q", rec, key[1:], last[1:])
+			}
+			last = key
+			pos += kl
+			_, vl, err := decodeCbor(body[pos:], 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pos += vl
+		}
+		if pos != len(body) {
+			t.Errorf("%T: %d bytes after the map", rec, len(body)-pos)
+		}
+	}
+}
+
+func TestCborMalformed(t *testing.T) {
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x81}, depth), 0x00)
+	}
+	items := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", []byte{}, "truncated"},
+		{"truncated head", []byte{0x19, 0x01}, "truncated"},
+		{"truncated string", []byte{0x44, 0x01, 0x02}, "truncated"},
+		{"truncated array", []byte{0x82, 0x00}, "truncated"},
+		{"truncated map", []byte{0xA1, 0x61, 'a'}, "truncated"},
+		{"nested too deep", nested(9), "nested too deep"},
+		{"half float", []byte{0xF9, 0x3C, 0x00}, "not supported"},
+		{"single float", []byte{0xFA, 0x3F, 0x80, 0x00, 0x00}, "not supported"},
+		{"double float", []byte{0xFB, 0x3F, 0xF0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "not supported"},
+		{"indefinite array", []byte{0x9F, 0x00, 0xFF}, "no definite length"},
+		{"indefinite string", []byte{0x5F, 0x41, 0x00, 0xFF}, "no definite length"},
+		{"key not text", []byte{0xA1, 0x01, 0x02}, "not text"},
+		{"negative out of range", []byte{0x3B, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "out of range"},
+	}
+	for _, item := range items {
+		_, _, err := decodeCbor(item.data, 0)
+		if err == nil || !strings.Contains(err.Error(), item.err) {
+			t.Errorf("%s: got %v, want an error with %q", item.name, err, item.err)
+		}
+	}
+	if _, _, err := decodeCbor(nested(8), 0); err != nil {
+		t.Errorf("8 levels of nesting: %s", err)
+	}
+}
+
+func TestCborMalformedRecord(t *testing.T) {
+	g := new(Generator)
+	g.SetWireFormat(WIRE_FORMAT_CBOR)
+	sig, _ := g.ConstructEUISignature(time.Unix(1700000000, 0), 0x70B3D5D72F000001)
+	data, err := g.Serialize(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i++ {
+		if _, _, err := g.DeserializeCbor(data[:i]); err == nil {
+			t.Errorf("record truncated to %d of %d bytes was accepted", i, len(data))
+		}
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-1] ^= 0x01
+	if _, _, err := g.DeserializeCbor(corrupt); err == nil {
+		t.Errorf("record with a wrong CRC was accepted")
+	}
+
+	// A float where the type is, with a valid CRC.
+	float := append([]byte{}, g_cbor_tag...)
+	float = append(float, 0xA1, 0x6E)
+	float = append(float, "signature_type"...)
+	float = append(float, 0xF9, 0x3C, 0x00)
+	float = append(float, byte(Crc(float)>>8), byte(Crc(float)))
+	if _, _, err := g.DeserializeCbor(float); err == nil {
+		t.Errorf("record with a float was accepted")
+	}
+}
@@ -0,0 +1,166 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "bytes"
+import "strings"
+import "hash/crc32"
+import "crypto/sha256"
+import "encoding/binary"
+
+// Integrity checks of the records. Every record ends with a check of all of
+// its bytes before it, by default the CRC-16/XMODEM as always. Bootloaders
+// with a hardware CRC engine of another kind get their own:
+//
+//	xmodem  CRC-16/XMODEM, poly 0x1021, init 0, 2 bytes
+//	ccitt   CRC-16/CCITT-FALSE, poly 0x1021, init 0xFFFF, 2 bytes
+//	crc32   CRC-32 of zlib and Ethernet, 4 bytes
+//	sha256  the first 4 bytes of the SHA-256
+//
+// Bits 5 and 6 of sig_version_major tell the check of the record, 0 for
+// xmodem, so a CRC-32 version 3 record starts with 0x43 and readers detect the
+// check record by record. The CRCs are numbers in the byte order of the
+// record, the SHA-256 is its bytes. signature_size includes the check, like
+// the byte order flag the check bits are part of the wire format only. CBOR
+// records always end with the CRC-16/XMODEM.
+
+const CHECK_XMODEM = "xmodem"
+const CHECK_CCITT = "ccitt"
+const CHECK_CRC32 = "crc32"
+const CHECK_SHA256 = "sha256"
+
+// Checks by their code in sig_version_major, the codes must never be
+// reassigned.
+var Checks = []string{CHECK_XMODEM, CHECK_CCITT, CHECK_CRC32, CHECK_SHA256}
+
+const FLAG_CHECK = 0x60 // In sig_version_major
+const FLAG_CHECK_SHIFT = 5
+
+// Flags of the wire format in sig_version_major
+const VERSION_FLAGS = FLAG_LITTLE_ENDIAN | FLAG_CHECK
+
+// SetCheck selects the integrity check the records are serialized with.
+func (self *Generator) SetCheck(check string) error {
+	if len(check) == 0 {
+		check = CHECK_XMODEM
+	}
+	for i, c := range Checks {
+		if c == check {
+			self.check = uint8(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown check %s, known checks are: %s", check, strings.Join(Checks, ", "))
+}
+
+// checkSize returns the size of the check of an algorithm.
+func checkSize(check uint8) int {
+	switch Checks[check] {
+	case CHECK_CRC32, CHECK_SHA256:
+		return 4
+	}
+	return 2
+}
+
+// recordCheck returns the check algorithm of the record at the start of
+// data.
+func recordCheck(data []byte) uint8 {
+	if len(data) == 0 || IsCborRecord(data) {
+		return 0
+	}
+	return (data[0] & FLAG_CHECK) >> FLAG_CHECK_SHIFT
+}
+
+// CheckName returns the name of the check of the record at the start of
+// data.
+func CheckName(data []byte) string {
+	return Checks[recordCheck(data)]
+}
+
+// CheckSize returns the size of the check at the end of the record at the
+// start of data.
+func CheckSize(data []byte) int {
+	return checkSize(recordCheck(data))
+}
+
+// computeCheck returns the check of body by an algorithm, in the byte order.
+func computeCheck(check uint8, order binary.ByteOrder, body []byte) []byte {
+	var sum []byte
+	switch Checks[check] {
+	case CHECK_CCITT:
+		sum = make([]byte, 2)
+		order.PutUint16(sum, crcCcittFalse(body))
+	case CHECK_CRC32:
+		sum = make([]byte, 4)
+		order.PutUint32(sum, crc32.ChecksumIEEE(body))
+	case CHECK_SHA256:
+		h := sha256.Sum256(body)
+		sum = h[:4]
+	default:
+		sum = make([]byte, 2)
+		order.PutUint16(sum, Crc(body))
+	}
+	return sum
+}
+
+// RecordCheck returns the stored and the computed check of the record at the
+// start of data, the check follows sz bytes.
+func RecordCheck(data []byte, sz int) ([]byte, []byte) {
+	check := recordCheck(data)
+	stored := data[sz:]
+	if n := checkSize(check); len(stored) > n {
+		stored = stored[:n]
+	}
+	return stored, computeCheck(check, RecordOrder(data), data[:sz])
+}
+
+// Recheck computes the check at the end of a record again, after it was
+// changed.
+func Recheck(record []byte) {
+	sz := len(record) - CheckSize(record)
+	copy(record[sz:], computeCheck(recordCheck(record), RecordOrder(record), record[:sz]))
+}
+
+// checkRecord compares the check stored after sz bytes of the record with the
+// computed one.
+func checkRecord(data []byte, sz int) error {
+	if n := CheckSize(data); len(data) < sz+n {
+		return fmt.Errorf("Failed to read signature %s from raw: %d bytes, need %d", CheckName(data), len(data), sz+n)
+	}
+	stored, computed := RecordCheck(data, sz)
+	if !bytes.Equal(stored, computed) {
+		return fmt.Errorf("Signature integrity check failed, stored %s: %X computed %s: %X", CheckName(data), stored, CheckName(data), computed)
+	}
+	return nil
+}
+
+// appendCheck marks the wire format in the header of the serialized record in
+// buf and appends its check.
+func (self *Generator) appendCheck(buf *bytes.Buffer) {
+	self.markOrder(buf.Bytes())
+	buf.Bytes()[0] |= self.check << FLAG_CHECK_SHIFT
+	buf.Write(computeCheck(self.check, self.byteOrder(), buf.Bytes()))
+}
+
+// crcCcittFalse returns the CRC-16/CCITT-FALSE of data.
+func crcCcittFalse(data []byte) uint16 {
+	return crc16(0xFFFF, data)
+}
+
+// crc16 computes the CRC-16 of polynomial 0x1021, not reflected, from init.
+func crc16(init uint16, data []byte) uint16 {
+	crc := init
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,125 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "bytes"
+import "reflect"
+import "testing"
+import "time"
+import "crypto/ed25519"
+import "encoding/binary"
+
+// The check values of the catalogue of CRCs, the checks of "123456789".
+func TestCheckValues(t *testing.T) {
+	checks := []struct {
+		check  string
+		big    []byte
+		little []byte
+	}{
+		{CHECK_XMODEM, []byte{0x31, 0xC3}, []byte{0xC3, 0x31}},
+		{CHECK_CCITT, []byte{0x29, 0xB1}, []byte{0xB1, 0x29}},
+		{CHECK_CRC32, []byte{0xCB, 0xF4, 0x39, 0x26}, []byte{0x26, 0x39, 0xF4, 0xCB}},
+		{CHECK_SHA256, []byte{0x15, 0xE2, 0xB0, 0xD3}, []byte{0x15, 0xE2, 0xB0, 0xD3}},
+	}
+	for _, c := range checks {
+		g := new(Generator)
+		if err := g.SetCheck(c.check); err != nil {
+			t.Fatal(err)
+		}
+		if n := checkSize(g.check); n != len(c.big) {
+			t.Errorf("%s: size %d, want %d", c.check, n, len(c.big))
+		}
+		if sum := computeCheck(g.check, binary.BigEndian, []byte("123456789")); !bytes.Equal(sum, c.big) {
+			t.Errorf("%s: big-endian % X, want % X", c.check, sum, c.big)
+		}
+		if sum := computeCheck(g.check, binary.LittleEndian, []byte("123456789")); !bytes.Equal(sum, c.little) {
+			t.Errorf("%s: little-endian % X, want % X", c.check, sum, c.little)
+		}
+	}
+	if crc := crcCcittFalse(nil); crc != 0xFFFF {
+		t.Errorf("CCITT-FALSE of nothing is %04X, want FFFF", crc)
+	}
+}
+
+// Records written with every check in both byte orders read back as written
+// and the reader detects the check and the order record by record.
+func TestCheckRoundTrip(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	uuid := Uuid{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	lic := []byte("license")
+
+	for _, check := range Checks {
+		for _, endianness := range Endiannesses {
+			name := check + "/" + endianness
+			g := new(Generator)
+			if err := g.SetCheck(check); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.SetEndianness(endianness); err != nil {
+				t.Fatal(err)
+			}
+
+			area := new(bytes.Buffer)
+			wants := make([]interface{}, 0)
+			write := func(rec interface{}) {
+				data, err := g.Serialize(rec)
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				if CheckName(data) != check {
+					t.Errorf("%s: record read as %s", name, CheckName(data))
+				}
+				if (RecordOrder(data) == binary.LittleEndian) != (endianness == ENDIANNESS_LITTLE) {
+					t.Errorf("%s: record read as %s", name, RecordOrder(data))
+				}
+				area.Write(data)
+				wants = append(wants, reflect.Indirect(reflect.ValueOf(rec)).Interface())
+			}
+
+			eui, _ := g.ConstructEUISignature(ts, 0x70B3D5D72F000001)
+			write(eui)
+			comp, err := g.ConstructComponentSignature(ts, "sensor", BoardVersion{1, 2, 3}, uuid, uuid, uuid, 1, SIGNATURE_TYPE_COMPONENT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := g.AttachData(comp, []byte{0xCA, 0x11, 0xB0})
+			slotted, err := g.AttachSlot(data, SlotHash("sensor"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(slotted)
+
+			licrec, err := g.SerializeLicense(ts, lic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			area.Write(licrec)
+			wants = append(wants, LicenseSignature{g.newBase(ts, SIGNATURE_TYPE_LICENSE, binary.Size(BaseSignature{})+len(lic)), lic})
+
+			areasig, err := g.ConstructAreaSignature(ts, area.Bytes(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(areasig)
+
+			sigs, err := g.DeserializeArea(area.Bytes())
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if !reflect.DeepEqual(sigs, wants) {
+				t.Errorf("%s: read back\n%+v\nwant\n%+v", name, sigs, wants)
+			}
+			if err := g.VerifyArea(area.Bytes(), key.Public().(ed25519.PublicKey)); err != nil {
+				t.Errorf("%s: %s", name, err)
+			}
+
+			corrupt := append([]byte{}, area.Bytes()...)
+			corrupt[20] ^= 0x01
+			if _, err := g.DeserializeArea(corrupt); err == nil {
+				t.Errorf("%s: a changed record passed its check", name)
+			}
+		}
+	}
+}
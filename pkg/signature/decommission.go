@@ -0,0 +1,95 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "bytes"
+import "errors"
+import "strings"
+import "time"
+import "encoding/binary"
+import "encoding/json"
+import "crypto/ed25519"
+
+// End-of-life record, written to a device before disposal so a decoder
+// reading the signature area of a returned device knows it was retired. The
+// Ed25519 signature of the authority covers the record before it.
+
+// Reasons for decommissioning, the codes are part of the signature format and
+// must never be reassigned.
+var DecommissionReasons = []string{"end-of-life", "failed", "returned", "lost", "compromised", "other"}
+
+type Reason uint8
+
+func (r Reason) String() string {
+	if int(r) < len(DecommissionReasons) {
+		return DecommissionReasons[r]
+	}
+	return fmt.Sprintf("unknown(%d)", r)
+}
+
+func (r Reason) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func ParseReason(name string) (Reason, error) {
+	for i, r := range DecommissionReasons {
+		if r == name {
+			return Reason(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown reason %s, known reasons are: %s", name, strings.Join(DecommissionReasons, ", "))
+}
+
+type Ed25519Signature [64]byte
+
+func (s Ed25519Signature) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%X", s[:]))
+}
+
+type DecommissionSignature struct {
+	BaseSignature
+
+	Eui64     Eui64            `json:"eui64"`
+	Reason    Reason           `json:"reason"`
+	Authority Name             `json:"authority"` // Who decided the device is retired, up to 16 chars
+	Signature Ed25519Signature `json:"signature"` // Ed25519 signature of the authority over the record before it
+
+	// crc uint16
+}
+
+func (self *Generator) ConstructDecommissionSignature(t time.Time, eui Eui64, reason Reason, authority string, key ed25519.PrivateKey) (*DecommissionSignature, error) {
+	sig := new(DecommissionSignature)
+	sig.BaseSignature = self.newBase(t, SIGNATURE_TYPE_DECOMMISSION, binary.Size(sig))
+
+	if len(authority) == 0 || len(authority) > len(sig.Authority) {
+		return nil, fmt.Errorf("The authority must be 1 to %d characters, %q is %d", len(sig.Authority), authority, len(authority))
+	}
+	sig.Eui64 = eui
+	sig.Reason = reason
+	copy(sig.Authority[:], authority)
+
+	copy(sig.Signature[:], ed25519.Sign(key, sig.signed()))
+	return sig, nil
+}
+
+// signed returns the bytes the signature of the record covers.
+func (self *DecommissionSignature) signed() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, self)
+	return buf.Bytes()[:buf.Len()-len(self.Signature)]
+}
+
+func (self *DecommissionSignature) Verify(key ed25519.PublicKey) error {
+	if !ed25519.Verify(key, self.signed(), self.Signature[:]) {
+		return errors.New("decommission record signature does not verify")
+	}
+	return nil
+}
+
+func (self *Generator) DeserializeDecommission(dec_bytes []byte) (DecommissionSignature, error) {
+	var ret DecommissionSignature
+	err := deserializeFixed(dec_bytes, &ret)
+	return ret, err
+}
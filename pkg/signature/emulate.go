@@ -0,0 +1,138 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "strings"
+import "reflect"
+
+// Emulation of earlier generator versions, for audits that need an artifact
+// reproduced byte for byte as the old generator made it. An encoder knows the
+// header version, record layouts and quirks of one generator version, every
+// encoder is verified against golden fixtures captured from a binary of that
+// version, usersiggen/compat/golden. A version nobody has a binary of has no
+// encoder, its quirks can not be known.
+
+// Encoder describes how a generator version serialized records.
+type Encoder struct {
+	Version string   `json:"version"`
+	Format  string   `json:"format"` // The record layouts, a version of the format history
+	Types   []uint8  `json:"types"`  // Record types the version could write
+	Quirks  []string `json:"quirks"`
+
+	serialIsComponentUuid bool
+}
+
+var g_encoders = []Encoder{
+	{"3.2.0", "3.2.0",
+		[]uint8{SIGNATURE_TYPE_EUI64, SIGNATURE_TYPE_BOARD, SIGNATURE_TYPE_PLATFORM, SIGNATURE_TYPE_COMPONENT, SIGNATURE_TYPE_LICENSE},
+		[]string{
+			"component records have no slot",
+			"a serial number given as a UUID is replaced by the component UUID",
+			"no component data",
+		},
+		true},
+}
+
+// Encoders returns the generator versions that can be emulated.
+func Encoders() []Encoder {
+	return append([]Encoder{}, g_encoders...)
+}
+
+// EncoderOf returns the encoder of a generator version X.Y.Z.
+func EncoderOf(version string) (*Encoder, error) {
+	for i := range g_encoders {
+		if g_encoders[i].Version == version {
+			e := g_encoders[i]
+			return &e, nil
+		}
+	}
+	known := make([]string, 0, len(g_encoders))
+	for _, e := range g_encoders {
+		known = append(known, e.Version)
+	}
+	return nil, fmt.Errorf("Generator %s can not be emulated, there are no fixtures of it to verify an encoder against, versions that can be: %s",
+		version, strings.Join(known, ", "))
+}
+
+// Emulate makes the generator serialize records as the generator version
+// did, the current version turns emulation off.
+func (self *Generator) Emulate(version string) error {
+	if version == fmt.Sprintf("%d.%d.%d", VersionMajor, VersionMinor, VersionPatch) {
+		self.emulate = nil
+		return nil
+	}
+	e, err := EncoderOf(version)
+	if err != nil {
+		return err
+	}
+	self.emulate = e
+	return nil
+}
+
+// Emulating returns the encoder of the emulated version, nil when the
+// generator writes the current format.
+func (self *Generator) Emulating() *Encoder {
+	return self.emulate
+}
+
+// SerialFromUuid returns the serial number the version wrote for a serial
+// given as a UUID.
+func (self *Encoder) SerialFromUuid(serial Uuid, component_uuid Uuid) Uuid {
+	if self.serialIsComponentUuid {
+		return component_uuid
+	}
+	return serial
+}
+
+func (self *Encoder) writes(t uint8) bool {
+	for _, w := range self.Types {
+		if w == t {
+			return true
+		}
+	}
+	return false
+}
+
+// header returns the header as the version wrote it.
+func (self *Encoder) header(base BaseSignature) (BaseSignature, error) {
+	if !self.writes(base.Signature_type) {
+		return base, fmt.Errorf("Generator %s did not write %s records", self.Version, TypeName(base.Signature_type))
+	}
+	fmt.Sscanf(self.Version, "%d.%d.%d", &base.Sig_version_major, &base.Sig_version_minor, &base.Sig_version_patch)
+	return base, nil
+}
+
+// slotless tells if component records of the version have no slot.
+func (self *Encoder) slotless() bool {
+	var f [3]uint8
+	fmt.Sscanf(self.Format, "%d.%d.%d", &f[0], &f[1], &f[2])
+	return compareVersions(f, [3]uint8{3, 4, 0}) < 0 // The slot came with 3.4.0
+}
+
+// encode returns the record as the version wrote it.
+func (self *Encoder) encode(sig interface{}) (interface{}, error) {
+	if s, ok := sig.(*ComponentDataSignature); ok {
+		if !self.writes(s.Signature_type) {
+			return nil, fmt.Errorf("Generator %s did not write %s records", self.Version, TypeName(s.Signature_type))
+		}
+		if s.Slot != 0 && self.slotless() {
+			return nil, fmt.Errorf("Generator %s did not write slots", self.Version)
+		}
+		return nil, fmt.Errorf("Generator %s did not write component data", self.Version)
+	}
+
+	v := reflect.New(reflect.Indirect(reflect.ValueOf(sig)).Type())
+	v.Elem().Set(reflect.Indirect(reflect.ValueOf(sig)))
+	field := v.Elem().FieldByName("BaseSignature")
+	if !field.IsValid() {
+		return nil, fmt.Errorf("%T is not a record", sig)
+	}
+	base, err := self.header(field.Interface().(BaseSignature))
+	if err != nil {
+		return nil, err
+	}
+	field.Set(reflect.ValueOf(base))
+	return v.Interface(), nil
+}
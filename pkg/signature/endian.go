@@ -0,0 +1,72 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "reflect"
+import "strings"
+import "encoding/binary"
+
+// Little-endian records, for firmware that reads the user page as packed
+// structs in the native order of a Cortex-M. The layout is the same, every
+// number of the record, the header and the CRC included, is little-endian.
+// The high bit of sig_version_major marks such a record, the first byte of a
+// version 3 record is 0x83, so readers tell the records apart one by one. The
+// flag is part of the wire format only, deserialized records have the
+// version without it. The area signature covers the record as written, the
+// flag included, the signature of a decommission record covers it big-endian
+// without the flags.
+
+const ENDIANNESS_BIG = "big"
+const ENDIANNESS_LITTLE = "little"
+
+var Endiannesses = []string{ENDIANNESS_BIG, ENDIANNESS_LITTLE}
+
+const FLAG_LITTLE_ENDIAN = 0x80 // In sig_version_major
+
+// SetEndianness selects the byte order the records are serialized in, big or
+// little.
+func (self *Generator) SetEndianness(endianness string) error {
+	switch endianness {
+	case ENDIANNESS_BIG, "":
+		self.little = false
+	case ENDIANNESS_LITTLE:
+		self.little = true
+	default:
+		return fmt.Errorf("Unknown endianness %s, known are: %s", endianness, strings.Join(Endiannesses, ", "))
+	}
+	return nil
+}
+
+// byteOrder returns the byte order the generator serializes in.
+func (self *Generator) byteOrder() binary.ByteOrder {
+	if self.little {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// markOrder sets the flag of the byte order in the header of a serialized
+// record.
+func (self *Generator) markOrder(record []byte) {
+	if self.little {
+		record[0] |= FLAG_LITTLE_ENDIAN
+	}
+}
+
+// RecordOrder returns the byte order of the binary record at the start of
+// data.
+func RecordOrder(data []byte) binary.ByteOrder {
+	if len(data) > 0 && data[0]&FLAG_LITTLE_ENDIAN != 0 && !IsCborRecord(data) {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// clearFlags clears the flags of the wire format, the byte order and the
+// check, in the header of a deserialized record.
+func clearFlags(sig interface{}) {
+	v := reflect.ValueOf(sig).Elem().FieldByName("Sig_version_major")
+	v.SetUint(v.Uint() &^ VERSION_FLAGS)
+}
@@ -0,0 +1,54 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "errors"
+import "time"
+import "encoding/binary"
+import "encoding/json"
+
+import "go.thinnect.net/euisig/eui"
+
+// Extended identity record, a 128-bit identifier for protocols that cannot
+// work with an EUI-64. The identifier is either allocated from an ID-128 pool
+// or derived from the EUI-64 within a namespace, in which case the namespace
+// is recorded so the derivation can be repeated.
+
+type Id128 [16]byte
+
+func (i Id128) String() string {
+	return eui.Id128(i).String()
+}
+
+func (i Id128) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.String())
+}
+
+type ExtendedIdSignature struct {
+	BaseSignature
+
+	Id128     Id128 `json:"id128"`     // 128-bit identifier
+	Namespace Uuid  `json:"namespace"` // Namespace the identifier was derived from the EUI-64 in, zero when allocated
+
+	// crc uint16
+}
+
+func (self *Generator) ConstructExtendedIdSignature(t time.Time, id Id128, namespace Uuid) (*ExtendedIdSignature, error) {
+	sig := new(ExtendedIdSignature)
+	sig.BaseSignature = self.newBase(t, SIGNATURE_TYPE_EXTENDED_ID, binary.Size(sig))
+
+	if id == (Id128{}) {
+		return nil, errors.New("The 128-bit identifier is all zeros")
+	}
+	sig.Id128 = id
+	sig.Namespace = namespace
+
+	return sig, nil
+}
+
+func (self *Generator) DeserializeExtendedId(id_bytes []byte) (ExtendedIdSignature, error) {
+	var ret ExtendedIdSignature
+	err := deserializeFixed(id_bytes, &ret)
+	return ret, err
+}
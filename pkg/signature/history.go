@@ -0,0 +1,330 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "sort"
+import "reflect"
+import "strings"
+import "encoding/binary"
+
+// The history of the signature format, every version with the layout of its
+// records. A version that changes the format gets an entry in g_formats, the
+// layouts and the differences between the versions are derived from the
+// structures. The encodings of the records, the fixed binary structures, the
+// TLVs of version 4 and CBOR, have entries of their own.
+
+const FORMAT_ENCODING_BINARY = "binary"
+const FORMAT_ENCODING_TLV = "tlv"
+const FORMAT_ENCODING_CBOR = "cbor"
+
+// FormatField is a field of a record body, after the common header.
+type FormatField struct {
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`        // From the start of the record, -1 when the encoding has no fixed offsets
+	Size   int    `json:"size"`          // -1 for the variable length tail, the size of the value for TLV and CBOR
+	Tag    uint8  `json:"tag,omitempty"` // Tag of the TLV of the field
+}
+
+// FormatRecord is the layout of a record type in a format version.
+type FormatRecord struct {
+	Type   uint8         `json:"type"`
+	Name   string        `json:"name"`
+	Size   int           `json:"size"` // Header, body and CRC, the minimum when variable, 0 for CBOR
+	Fields []FormatField `json:"fields"`
+}
+
+// FormatChange is a difference to the previous version.
+type FormatChange struct {
+	Record string `json:"record"`
+	Field  string `json:"field,omitempty"` // Empty when the whole record type changed
+	Change string `json:"change"`          // added, removed or resized
+	Size   int    `json:"size,omitempty"`
+}
+
+// FormatFlag is a flag of the wire format in sig_version_major, the codes
+// are the meanings of its values from 0 when it has more than one bit.
+type FormatFlag struct {
+	Name  string   `json:"name"`
+	Mask  uint8    `json:"mask"`
+	Codes []string `json:"codes,omitempty"`
+}
+
+// FormatVersion is a version of the signature format, the version the header
+// of every record carries, and the generator version that first wrote it.
+// The changes are to the previous version of the same encoding.
+type FormatVersion struct {
+	Version  string         `json:"version"`
+	Since    string         `json:"since_generator"`
+	Encoding string         `json:"encoding"` // binary, tlv or cbor
+	Notes    string         `json:"notes"`
+	Flags    []FormatFlag   `json:"flags"` // Flags in sig_version_major, not part of the version
+	Records  []FormatRecord `json:"records"`
+	Changes  []FormatChange `json:"changes"`
+}
+
+type formatDef struct {
+	version  string
+	since    string
+	encoding string
+	notes    string
+	flags    []FormatFlag
+	bodies   map[uint8]interface{}
+}
+
+var g_flag_little_endian = FormatFlag{Name: "little_endian", Mask: FLAG_LITTLE_ENDIAN}
+var g_flag_check = FormatFlag{Name: "check", Mask: FLAG_CHECK, Codes: Checks}
+
+// records34 are the records generator 3.4.0 writes, with the component
+// data and slot for the encodings that tag them.
+func records34(component interface{}) map[uint8]interface{} {
+	return map[uint8]interface{}{
+		SIGNATURE_TYPE_EUI64:          EUISignature{},
+		SIGNATURE_TYPE_BOARD:          component,
+		SIGNATURE_TYPE_PLATFORM:       component,
+		SIGNATURE_TYPE_COMPONENT:      component,
+		SIGNATURE_TYPE_LICENSE:        LicenseSignature{},
+		SIGNATURE_TYPE_COMPLIANCE:     ComplianceSignature{},
+		SIGNATURE_TYPE_EXTENDED_ID:    ExtendedIdSignature{},
+		SIGNATURE_TYPE_DECOMMISSION:   DecommissionSignature{},
+		SIGNATURE_TYPE_AREA_SIGNATURE: AreaSignature{},
+		SIGNATURE_TYPE_PIN:            PinSignature{},
+		SIGNATURE_TYPE_LICENSE_V2:     LicenseV2Signature{},
+	}
+}
+
+var g_formats = []formatDef{
+	{"3.2.0", "3.2.0", FORMAT_ENCODING_BINARY,
+		"Earliest format known to this library, the 3.2.0 binary in usersiggen/bin writes it. Component records may carry data_length bytes of component specific data before the CRC.",
+		nil,
+		map[uint8]interface{}{
+			SIGNATURE_TYPE_EUI64:     EUISignature{},
+			SIGNATURE_TYPE_BOARD:     ComponentSignature{},
+			SIGNATURE_TYPE_PLATFORM:  ComponentSignature{},
+			SIGNATURE_TYPE_COMPONENT: ComponentSignature{},
+			SIGNATURE_TYPE_LICENSE:   LicenseSignature{},
+		}},
+	{"3.4.0", "3.4.0", FORMAT_ENCODING_BINARY,
+		"Component records in a named slot end with the slot, 4 bytes after the data, readers take the CRC from the end of signature_size. Compliance, extended identity, decommission, area signature, setup PIN and version 2 license records. The little_endian flag, 0x80, marks records with every number little endian, the CRC included. The check code, bits 5 and 6, selects the check after the record: 0 CRC-16/XMODEM, 1 CRC-16/CCITT-FALSE, 2 CRC-32, 3 the first 4 bytes of the SHA-256, the sizes are the ones with the 2 byte CRC.",
+		[]FormatFlag{g_flag_little_endian, g_flag_check},
+		records34(ComponentSignature{})},
+	{"3.4.0", "3.4.0", FORMAT_ENCODING_CBOR,
+		"CBOR wire format, --wire-format cbor: the self-described CBOR tag 55799, a map of the fields by their JSON names, the header fields included, and always the CRC-16/XMODEM of both, 2 bytes big endian. signature_size is not written, the fields have no offsets and readers skip the keys they do not know.",
+		nil,
+		records34(ComponentDataSignature{})},
+	{"4.0.0", "3.4.0", FORMAT_ENCODING_TLV,
+		"Version 4, --sig-version 4: the 14 byte header with sig_version_major 4, then every field as a 1 byte tag, a 2 byte big endian length and the value, in the order of the tags. The data_length of components is the length of the data TLV, readers skip the tags they do not know. With the little_endian flag the values are little endian, the lengths stay big endian. The check code selects the check after the record as in the binary records.",
+		[]FormatFlag{g_flag_little_endian, g_flag_check},
+		records34(ComponentDataSignature{})},
+}
+
+func fieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; len(name) > 0 && name != "-" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+// formatFields lists the fields of a record body, the header is left out.
+func formatFields(t reflect.Type, offset *int) []FormatField {
+	fields := make([]FormatField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			if f.Type == reflect.TypeOf(BaseSignature{}) {
+				*offset += binary.Size(BaseSignature{})
+			} else {
+				fields = append(fields, formatFields(f.Type, offset)...)
+			}
+			continue
+		}
+		size := -1
+		if f.Type.Kind() != reflect.Slice {
+			size = int(f.Type.Size())
+		}
+		fields = append(fields, FormatField{Name: fieldName(f), Offset: *offset, Size: size})
+		if size > 0 {
+			*offset += size
+		}
+	}
+	return fields
+}
+
+func formatRecord(code uint8, encoding string, body interface{}) FormatRecord {
+	offset := 0
+	fields := formatFields(reflect.TypeOf(body), &offset)
+	r := FormatRecord{Type: code, Name: TypeName(code), Size: offset + 2, Fields: fields}
+	switch encoding {
+	case FORMAT_ENCODING_TLV:
+		// Every field a TLV in the order of the tags, the size of a record
+		// with all of them, the variable ones empty
+		r.Size = binary.Size(BaseSignature{}) + 2
+		r.Fields = make([]FormatField, 0, len(fields))
+		for _, f := range fields {
+			tag, ok := g_tlv_tags[f.Name]
+			if !ok {
+				continue
+			}
+			f.Offset, f.Tag = -1, tag
+			r.Size += 3 + maxInt(f.Size, 0)
+			r.Fields = append(r.Fields, f)
+		}
+		sort.Slice(r.Fields, func(i, j int) bool { return r.Fields[i].Tag < r.Fields[j].Tag })
+	case FORMAT_ENCODING_CBOR:
+		r.Size = 0
+		for i := range r.Fields {
+			r.Fields[i].Offset = -1
+		}
+	}
+	return r
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func formatChanges(prev []FormatRecord, cur []FormatRecord) []FormatChange {
+	changes := make([]FormatChange, 0)
+	before := make(map[uint8]FormatRecord)
+	for _, r := range prev {
+		before[r.Type] = r
+	}
+	after := make(map[uint8]bool)
+	for _, r := range cur {
+		after[r.Type] = true
+		old, ok := before[r.Type]
+		if !ok {
+			changes = append(changes, FormatChange{Record: r.Name, Change: "added", Size: r.Size})
+			continue
+		}
+		oldFields := make(map[string]FormatField)
+		for _, f := range old.Fields {
+			oldFields[f.Name] = f
+		}
+		newFields := make(map[string]bool)
+		for _, f := range r.Fields {
+			newFields[f.Name] = true
+			if of, ok := oldFields[f.Name]; !ok {
+				changes = append(changes, FormatChange{r.Name, f.Name, "added", f.Size})
+			} else if of.Size != f.Size {
+				changes = append(changes, FormatChange{r.Name, f.Name, "resized", f.Size})
+			}
+		}
+		for _, f := range old.Fields {
+			if !newFields[f.Name] {
+				changes = append(changes, FormatChange{r.Name, f.Name, "removed", f.Size})
+			}
+		}
+	}
+	for _, r := range prev {
+		if !after[r.Type] {
+			changes = append(changes, FormatChange{Record: r.Name, Change: "removed", Size: r.Size})
+		}
+	}
+	return changes
+}
+
+// FormatHistory returns the versions of the signature format, oldest first,
+// with the layout of every record and the changes to the version before.
+func FormatHistory() []FormatVersion {
+	history := make([]FormatVersion, 0, len(g_formats))
+	prev := make(map[string][]FormatRecord) // By encoding
+	for _, def := range g_formats {
+		v := FormatVersion{Version: def.version, Since: def.since, Encoding: def.encoding, Notes: def.notes, Flags: def.flags, Records: make([]FormatRecord, 0)}
+		if v.Flags == nil {
+			v.Flags = make([]FormatFlag, 0)
+		}
+		codes := make([]int, 0, len(def.bodies))
+		for code := range def.bodies {
+			codes = append(codes, int(code))
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			v.Records = append(v.Records, formatRecord(uint8(code), def.encoding, def.bodies[uint8(code)]))
+		}
+		v.Changes = make([]FormatChange, 0) // Nothing to compare the first one with
+		if p, ok := prev[def.encoding]; ok {
+			v.Changes = formatChanges(p, v.Records)
+		}
+		prev[def.encoding] = v.Records
+		history = append(history, v)
+	}
+	return history
+}
+
+// FormatOf returns the format version a binary or version 4 record header was
+// written with, the latest one before it for versions without their own
+// entry.
+func FormatOf(base BaseSignature) (FormatVersion, error) {
+	return formatOf(base, FORMAT_ENCODING_BINARY, FORMAT_ENCODING_TLV)
+}
+
+// FormatOfRecord returns the format version of the record at the start of
+// data, binary, version 4 or CBOR.
+func FormatOfRecord(data []byte) (FormatVersion, error) {
+	if IsCborRecord(data) {
+		item, _, err := decodeCbor(data[len(g_cbor_tag):], 0)
+		if err != nil {
+			return FormatVersion{}, err
+		}
+		fields, _ := item.(map[string]interface{})
+		var base BaseSignature
+		for name, f := range map[string]*uint8{
+			"sig_version_major": &base.Sig_version_major,
+			"sig_version_minor": &base.Sig_version_minor,
+			"sig_version_patch": &base.Sig_version_patch,
+		} {
+			v, ok := fields[name].(uint64)
+			if !ok || v > 0xFF {
+				return FormatVersion{}, fmt.Errorf("CBOR record has no %s", name)
+			}
+			*f = uint8(v)
+		}
+		return formatOf(base, FORMAT_ENCODING_CBOR)
+	}
+	var gen Generator
+	base, err := gen.DeserializeBaseSignature(data)
+	if err != nil {
+		return FormatVersion{}, err
+	}
+	return FormatOf(base)
+}
+
+// formatOf returns the latest format version of the encodings that is not
+// newer than the header.
+func formatOf(base BaseSignature, encodings ...string) (FormatVersion, error) {
+	written := [3]uint8{base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch}
+	var found *FormatVersion
+	history := FormatHistory()
+	for i := range history {
+		known := false
+		for _, e := range encodings {
+			known = known || history[i].Encoding == e
+		}
+		var v [3]uint8
+		fmt.Sscanf(history[i].Version, "%d.%d.%d", &v[0], &v[1], &v[2])
+		if known && compareVersions(v, written) <= 0 {
+			found = &history[i]
+		}
+	}
+	if found == nil {
+		return FormatVersion{}, fmt.Errorf("format %d.%d.%d is older than the history", written[0], written[1], written[2])
+	}
+	return *found, nil
+}
+
+func compareVersions(a [3]uint8, b [3]uint8) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
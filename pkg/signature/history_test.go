@@ -0,0 +1,131 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "reflect"
+import "testing"
+import "time"
+
+// Every format the generator writes resolves to its own entry in the history,
+// with a layout for every record type it writes.
+func TestFormatOfRecord(t *testing.T) {
+	formats := []struct {
+		name       string
+		emulate    string
+		version    int
+		endianness string
+		check      string
+		wire       string
+		want       string
+		encoding   string
+	}{
+		{"emulated 3.2.0", "3.2.0", 3, ENDIANNESS_BIG, CHECK_XMODEM, WIRE_FORMAT_BINARY, "3.2.0", FORMAT_ENCODING_BINARY},
+		{"v3", "", 3, ENDIANNESS_BIG, CHECK_XMODEM, WIRE_FORMAT_BINARY, "3.4.0", FORMAT_ENCODING_BINARY},
+		{"v3 little", "", 3, ENDIANNESS_LITTLE, CHECK_XMODEM, WIRE_FORMAT_BINARY, "3.4.0", FORMAT_ENCODING_BINARY},
+		{"v3 ccitt", "", 3, ENDIANNESS_BIG, CHECK_CCITT, WIRE_FORMAT_BINARY, "3.4.0", FORMAT_ENCODING_BINARY},
+		{"v3 crc32", "", 3, ENDIANNESS_LITTLE, CHECK_CRC32, WIRE_FORMAT_BINARY, "3.4.0", FORMAT_ENCODING_BINARY},
+		{"v3 sha256", "", 3, ENDIANNESS_BIG, CHECK_SHA256, WIRE_FORMAT_BINARY, "3.4.0", FORMAT_ENCODING_BINARY},
+		{"v4", "", TLV_VERSION_MAJOR, ENDIANNESS_BIG, CHECK_XMODEM, WIRE_FORMAT_BINARY, "4.0.0", FORMAT_ENCODING_TLV},
+		{"v4 little", "", TLV_VERSION_MAJOR, ENDIANNESS_LITTLE, CHECK_SHA256, WIRE_FORMAT_BINARY, "4.0.0", FORMAT_ENCODING_TLV},
+		{"cbor", "", 3, ENDIANNESS_BIG, CHECK_XMODEM, WIRE_FORMAT_CBOR, "3.4.0", FORMAT_ENCODING_CBOR},
+	}
+	for _, f := range formats {
+		g := new(Generator)
+		if f.emulate != "" {
+			if err := g.Emulate(f.emulate); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := g.SetSigVersion(f.version); err != nil {
+			t.Fatal(err)
+		}
+		g.SetEndianness(f.endianness)
+		g.SetCheck(f.check)
+		g.SetWireFormat(f.wire)
+
+		// The records of every type, the emulated generator writes its own
+		written := 0
+		for _, rec := range cborRecords(t, new(Generator)) {
+			sigtype := uint8(reflect.Indirect(reflect.ValueOf(rec)).FieldByName("Signature_type").Uint())
+			data, err := serializeAny(g, rec)
+			if err != nil {
+				if f.emulate == "" {
+					t.Errorf("%s: %T: %s", f.name, rec, err)
+				}
+				continue // Not written by the emulated version
+			}
+			written++
+			format, err := FormatOfRecord(data)
+			if err != nil {
+				t.Errorf("%s: %T: %s", f.name, rec, err)
+				continue
+			}
+			if format.Version != f.want || format.Encoding != f.encoding {
+				t.Errorf("%s: %T resolves to %s %s, want %s %s", f.name, rec, format.Encoding, format.Version, f.encoding, f.want)
+			}
+			if header := fmt.Sprintf("%d.%d.%d", data[0]&^VERSION_FLAGS, data[1], data[2]); !IsCborRecord(data) && header != format.Version {
+				t.Errorf("%s: %T has header %s, resolves to %s", f.name, rec, header, format.Version)
+			}
+			if !IsCborRecord(data) {
+				var masks uint8
+				for _, flag := range format.Flags {
+					masks |= flag.Mask
+				}
+				if set := data[0] & VERSION_FLAGS; set&^masks != 0 {
+					t.Errorf("%s: %T has flags %02X, format %s lists %02X", f.name, rec, set, format.Version, masks)
+				}
+			}
+			found := false
+			for _, r := range format.Records {
+				found = found || r.Type == sigtype
+			}
+			if !found {
+				t.Errorf("%s: format %s has no layout for %s", f.name, format.Version, TypeName(sigtype))
+			}
+		}
+		if written == 0 {
+			t.Errorf("%s: nothing written", f.name)
+		}
+	}
+}
+
+// The history lists a record type from the version that first writes it.
+func TestFormatHistoryEmulated(t *testing.T) {
+	for _, enc := range Encoders() {
+		g := new(Generator)
+		if err := g.Emulate(enc.Version); err != nil {
+			t.Fatal(err)
+		}
+		var format *FormatVersion
+		history := FormatHistory()
+		for i := range history {
+			if history[i].Version == enc.Version && history[i].Encoding == FORMAT_ENCODING_BINARY {
+				format = &history[i]
+			}
+		}
+		if format == nil {
+			t.Errorf("emulated %s has no entry in the history", enc.Version)
+			continue
+		}
+		for _, r := range format.Records {
+			if !g.Emulating().writes(r.Type) {
+				t.Errorf("format %s lists %s, the %s encoder does not write it", format.Version, r.Name, enc.Version)
+			}
+		}
+	}
+}
+
+// serializeAny serializes a record, licenses as the generator writes them.
+func serializeAny(g *Generator, rec interface{}) ([]byte, error) {
+	if !g.cbor {
+		switch r := rec.(type) {
+		case *LicenseSignature:
+			return g.SerializeLicense(time.Unix(r.Unix_time, 0), r.Lic_file)
+		case *LicenseV2Signature:
+			return g.SerializeLicenseV2(time.Unix(r.Unix_time, 0), r.Lic_file, r.LicenseWindow)
+		}
+	}
+	return g.Serialize(rec)
+}
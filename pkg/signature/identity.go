@@ -0,0 +1,111 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "errors"
+import "reflect"
+import "time"
+
+// Identity is the identity context of one device while the tools of the
+// production line work on it: the EUI-64, the time the records are stamped
+// with, the records in area order with their serialized form, and the
+// artifacts written for the device by name. Allocation, signature generation,
+// licensing and flashing pass it on instead of parsing each other's files,
+// so every step sees the same identity.
+type Identity struct {
+	Eui64     Eui64             `json:"eui64"`
+	Timestamp time.Time         `json:"timestamp"`
+	Records   []interface{}     `json:"records"`
+	Sigdata   []byte            `json:"-"`
+	Artifacts map[string]string `json:"artifacts"`
+}
+
+func NewIdentity(eui Eui64, t time.Time) *Identity {
+	return &Identity{Eui64: eui, Timestamp: t, Records: make([]interface{}, 0), Artifacts: make(map[string]string)}
+}
+
+// IdentityOf returns the identity context of an existing signature area, for
+// tools that start from a file.
+func (self *Generator) IdentityOf(sigdata []byte) (*Identity, error) {
+	sigs, err := self.DeserializeArea(sigdata)
+	if err != nil {
+		return nil, err
+	}
+	id := NewIdentity(0, time.Time{})
+	id.Records = sigs
+	id.Sigdata = sigdata
+	esig := id.EUI()
+	if esig == nil {
+		return nil, errors.New("Signature area has no EUI64 record")
+	}
+	id.Eui64 = esig.Eui64
+	id.Timestamp = time.Unix(esig.Unix_time, 0).UTC()
+	return id, nil
+}
+
+// Add serializes a record and appends it to the identity.
+func (self *Identity) Add(gen *Generator, rec interface{}) error {
+	data, err := gen.Serialize(rec)
+	if err != nil {
+		return err
+	}
+	self.Records = append(self.Records, deref(rec))
+	self.Sigdata = append(self.Sigdata, data...)
+	return nil
+}
+
+// deref stores records by value, the way DeserializeArea returns them.
+func deref(rec interface{}) interface{} {
+	if v := reflect.ValueOf(rec); v.Kind() == reflect.Ptr {
+		return v.Elem().Interface()
+	}
+	return rec
+}
+
+// EUI returns the EUI64 record, nil when there is none.
+func (self *Identity) EUI() *EUISignature {
+	for _, r := range self.Records {
+		if s, ok := r.(EUISignature); ok {
+			return &s
+		}
+	}
+	return nil
+}
+
+// Component returns the first board, platform or component record of the
+// type, without component data, nil when there is none.
+func (self *Identity) Component(signature_type uint8) *ComponentSignature {
+	for _, r := range self.Records {
+		if c, ok := ComponentOf(r); ok && c.Signature_type == signature_type {
+			return &c
+		}
+	}
+	return nil
+}
+
+// Board returns the board record, nil when there is none.
+func (self *Identity) Board() *ComponentSignature {
+	return self.Component(SIGNATURE_TYPE_BOARD)
+}
+
+// ExtendedId returns the extended identity record, nil when there is none.
+func (self *Identity) ExtendedId() *ExtendedIdSignature {
+	for _, r := range self.Records {
+		if s, ok := r.(ExtendedIdSignature); ok {
+			return &s
+		}
+	}
+	return nil
+}
+
+// SetArtifact records a file written for the device.
+func (self *Identity) SetArtifact(name string, path string) {
+	self.Artifacts[name] = path
+}
+
+// Artifact returns the file written for the device by name, empty when there
+// is none.
+func (self *Identity) Artifact(name string) string {
+	return self.Artifacts[name]
+}
@@ -0,0 +1,92 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "bytes"
+import "time"
+import "encoding/binary"
+
+// Version 2 of the license record, for time-limited licenses like the
+// evaluation licenses of pilot deployments. The license file follows a
+// validity window. It is a record type of its own, firmware that only knows
+// the first license record skips it instead of taking a time-limited license
+// for a permanent one.
+
+// LicenseWindow is the validity window of a license in Unix time, 0 leaves
+// the window open on that side.
+type LicenseWindow struct {
+	Not_before int64 `json:"not_before"`
+	Not_after  int64 `json:"not_after"`
+}
+
+type LicenseV2Signature struct {
+	BaseSignature
+	LicenseWindow
+
+	Lic_file []byte `json:"lic_file"`
+
+	// crc uint16
+}
+
+// Check tells if the window is open at t.
+func (self LicenseWindow) Check(t time.Time) error {
+	if self.Not_before != 0 && t.Unix() < self.Not_before {
+		return fmt.Errorf("License is not valid before %s", time.Unix(self.Not_before, 0).UTC().Format(time.RFC3339))
+	}
+	if self.Not_after != 0 && t.Unix() > self.Not_after {
+		return fmt.Errorf("License expired at %s", time.Unix(self.Not_after, 0).UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SerializeLicenseV2 returns the license record of the contents of a license
+// file valid in the window.
+func (self *Generator) SerializeLicenseV2(t time.Time, lic []byte, window LicenseWindow) ([]byte, error) {
+	if window.Not_before != 0 && window.Not_after != 0 && window.Not_after <= window.Not_before {
+		return nil, fmt.Errorf("License window ends before it starts")
+	}
+	fixed := binary.Size(BaseSignature{}) + binary.Size(window)
+	base := self.newBase(t, SIGNATURE_TYPE_LICENSE_V2, fixed+len(lic))
+	if self.cbor || self.tlv {
+		return self.Serialize(&LicenseV2Signature{base, window, lic})
+	}
+	if self.emulate != nil {
+		var err error
+		if base, err = self.emulate.header(base); err != nil {
+			return nil, err
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, self.byteOrder(), base); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, self.byteOrder(), window); err != nil {
+		return nil, err
+	}
+	buf.Write(lic)
+	self.appendCheck(buf)
+	return buf.Bytes(), nil
+}
+
+func (self *Generator) DeserializeLicenseV2(lic_bytes []byte) (LicenseV2Signature, error) {
+	var ret LicenseV2Signature
+
+	base, err := self.DeserializeBaseSignature(lic_bytes)
+	if err != nil {
+		return ret, err
+	}
+	ret.BaseSignature = base
+
+	fixed := binary.Size(BaseSignature{}) + binary.Size(ret.LicenseWindow)
+	w := CheckSize(lic_bytes)
+	sz := int(ret.Signature_size) - w
+	if sz < fixed || len(lic_bytes) < sz+w {
+		return ret, fmt.Errorf("License signature truncated, %d bytes available, %d needed", len(lic_bytes), sz+w)
+	}
+	binary.Read(bytes.NewReader(lic_bytes[binary.Size(BaseSignature{}):fixed]), RecordOrder(lic_bytes), &ret.LicenseWindow)
+	ret.Lic_file = lic_bytes[fixed:sz]
+
+	return ret, checkRecord(lic_bytes, sz)
+}
@@ -0,0 +1,79 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "bytes"
+import "time"
+import "crypto/sha256"
+import "crypto/subtle"
+import "encoding/binary"
+import "encoding/json"
+
+// Setup PIN record, for products that ship with a per-device PIN. The PIN
+// itself is never written to the signature area, only a random salt and the
+// SHA-256 of the salt followed by the PIN digits, so the device can check a
+// PIN entered during setup.
+
+const MAX_PIN_LENGTH = 12
+
+type Salt [16]byte
+
+func (s Salt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%X", s[:]))
+}
+
+type PinHash [32]byte
+
+func (h PinHash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%X", h[:]))
+}
+
+type PinSignature struct {
+	BaseSignature
+
+	Pin_length uint8   `json:"pin_length"` // Number of digits
+	Salt       Salt    `json:"salt"`
+	Pin_hash   PinHash `json:"pin_hash"` // SHA-256 of the salt and the PIN digits
+
+	// crc uint16
+}
+
+func pinHash(salt Salt, pin string) PinHash {
+	return PinHash(sha256.Sum256(append(salt[:], pin...)))
+}
+
+func (self *Generator) ConstructPinSignature(t time.Time, pin string, salt Salt) (*PinSignature, error) {
+	sig := new(PinSignature)
+	sig.BaseSignature = self.newBase(t, SIGNATURE_TYPE_PIN, binary.Size(sig))
+
+	if len(pin) == 0 || len(pin) > MAX_PIN_LENGTH {
+		return nil, fmt.Errorf("The PIN must be 1 to %d digits, it is %d", MAX_PIN_LENGTH, len(pin))
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("The PIN must only have digits")
+		}
+	}
+	if bytes.Equal(salt[:], make([]byte, len(salt))) {
+		return nil, fmt.Errorf("The PIN salt is all zeros")
+	}
+	sig.Pin_length = uint8(len(pin))
+	sig.Salt = salt
+	sig.Pin_hash = pinHash(salt, pin)
+
+	return sig, nil
+}
+
+// Check tells if pin is the PIN of the record.
+func (self *PinSignature) Check(pin string) bool {
+	h := pinHash(self.Salt, pin)
+	return len(pin) == int(self.Pin_length) && subtle.ConstantTimeCompare(h[:], self.Pin_hash[:]) == 1
+}
+
+func (self *Generator) DeserializePin(pin_bytes []byte) (PinSignature, error) {
+	var ret PinSignature
+	err := deserializeFixed(pin_bytes, &ret)
+	return ret, err
+}
@@ -0,0 +1,652 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package signature constructs, serializes and parses the records of the
+// device signature area, for programs that handle sigdata without running
+// usersiggen. Every record is a common header, a type specific body and a
+// check over both. The check is the CRC-16/XMODEM unless bits 5 and 6 of
+// sig_version_major, 0x60, select another one of Checks. The numbers are big
+// endian, unless the high bit of sig_version_major, 0x80, marks a
+// little-endian record. The flags are part of the wire format only,
+// deserialized records have the version without them.
+//
+// The exported API is stable within a major version, see the README.
+// Replaced identifiers stay as shims marked Deprecated until the next major
+// version.
+package signature
+
+import "fmt"
+import "bytes"
+import "errors"
+import "strings"
+import "strconv"
+import "time"
+import "encoding/binary"
+
+// Version of the signature format written, recorded in every header.
+var VersionMajor uint8 = 3
+var VersionMinor uint8 = 4
+var VersionPatch uint8 = 0
+
+const SIGNATURE_TYPE_EUI64 = 0          // EUI64 is the IEEE Extended Unique Identifier
+const SIGNATURE_TYPE_BOARD = 1          // Boards are the core of the system - MCU
+const SIGNATURE_TYPE_PLATFORM = 2       // Platforms define the set of components
+const SIGNATURE_TYPE_COMPONENT = 3      // Components list individual parts of a platform
+const SIGNATURE_TYPE_LICENSE = 4        // License file identifier.
+const SIGNATURE_TYPE_COMPLIANCE = 5     // Regulatory identifiers and country of origin
+const SIGNATURE_TYPE_EXTENDED_ID = 6    // 128-bit identity alongside the EUI-64
+const SIGNATURE_TYPE_DECOMMISSION = 7   // Signed end-of-life record, written before disposal
+const SIGNATURE_TYPE_AREA_SIGNATURE = 8 // Factory signature over the whole area, the last record
+const SIGNATURE_TYPE_PIN = 9            // Salted hash of the setup PIN
+const SIGNATURE_TYPE_LICENSE_V2 = 10    // License file with a validity window
+
+const MAX_SIGNATURE_LENGTH = 1024 // Sanity checking signature lengths
+
+// Crc returns the CRC-16/XMODEM of a record, the check of CBOR records and of
+// binary records with check code 0, see Checks for the others.
+func Crc(data []byte) uint16 {
+	return crc16(0, data)
+}
+
+type BaseSignature struct {
+	Sig_version_major uint8 `json:"sig_version_major"`
+	Sig_version_minor uint8 `json:"sig_version_minor"`
+	Sig_version_patch uint8 `json:"sig_version_patch"`
+
+	Signature_size uint16 `json:"signature_size"`
+	Signature_type uint8  `json:"signature_type"`
+
+	Unix_time int64 `json:"unix_time"` // Signature generation time
+}
+
+// newBase returns the header of a new record, size is the size of its binary
+// form without the check.
+func (self *Generator) newBase(t time.Time, signature_type uint8, size int) BaseSignature {
+	size += checkSize(self.check)
+	if self.tlv {
+		return BaseSignature{TLV_VERSION_MAJOR, TLV_VERSION_MINOR, TLV_VERSION_PATCH, uint16(size), signature_type, t.Unix()}
+	}
+	return BaseSignature{VersionMajor, VersionMinor, VersionPatch, uint16(size), signature_type, t.Unix()}
+}
+
+type EUISignature struct {
+	BaseSignature
+
+	Eui64 Eui64 `json:"eui64"` // EUI-64, byte array
+
+	// crc uint16
+}
+
+type ComponentSignature struct {
+	BaseSignature
+
+	Component_uuid Uuid `json:"component_uuid"`
+	Name           Name `json:"component_name"` //char boardname[16]; // up to 16 chars or 0 terminated
+
+	Version_major    uint8 `json:"pcb_version_major"`    // nx_uint8_t pcb_version_major;
+	Version_minor    uint8 `json:"pcb_version_minor"`    // nx_uint8_t pcb_version_minor;
+	Version_assembly uint8 `json:"pcb_version_assembly"` // nx_uint8_t pcb_version_assembly;
+
+	Serial_number Uuid `json:"serial_number"` // Possibly an UUID, but could be a \0 terminated string
+
+	Manufacturer_uuid Uuid `json:"manufacturer"`
+
+	Position uint8 `json:"position"` // Position / index of the component (when multiple)
+
+	Data_length uint16 `json:"data_length"` // Length of the component specific data
+	// data     []byte  // compoent specific data - calibration etc
+
+	// crc uint16
+}
+
+// ComponentDataSignature is a component record with component specific data,
+// calibration for sensors, or a slot. The data follows the fixed part, a
+// component record in a named slot ends with the slot after the data, the
+// CRC covers all of it. Readers that do not know the slot skip it, the CRC is
+// the last 2 bytes of signature_size as always.
+type ComponentDataSignature struct {
+	ComponentSignature
+
+	Data Data `json:"data"`
+
+	Slot Slot `json:"slot,omitempty"` // FNV-1a hash of the slot name, 0 when not in a named slot
+}
+
+// ComponentOf returns the fixed part of a board, platform or component
+// record, with or without data.
+func ComponentOf(sig interface{}) (ComponentSignature, bool) {
+	switch s := sig.(type) {
+	case ComponentSignature:
+		return s, true
+	case ComponentDataSignature:
+		return s.ComponentSignature, true
+	}
+	return ComponentSignature{}, false
+}
+
+// SlotOf returns the slot of a component record, 0 when it is not in a named
+// slot.
+func SlotOf(sig interface{}) Slot {
+	if s, ok := sig.(ComponentDataSignature); ok {
+		return s.Slot
+	}
+	return 0
+}
+
+func (self *ComponentSignature) BoardName() string {
+	n := bytes.Index(self.Name[:], []byte{0})
+	if n < 0 {
+		n = 16
+	}
+	return string(self.Name[:n])
+}
+
+func (self *ComponentSignature) BoardVersion() string {
+	return fmt.Sprintf("%d.%d.%d", self.Version_major, self.Version_minor, self.Version_assembly)
+}
+
+type ComplianceSignature struct {
+	BaseSignature
+
+	Country_of_origin Country `json:"country_of_origin"` // ISO 3166-1 alpha-2
+	Regions           Regions `json:"regions"`           // Bitmap of certified regions
+
+	Red_id Cert `json:"red_id"` // RED notified body certificate, up to 24 chars or 0 terminated
+	Fcc_id Cert `json:"fcc_id"` // FCC ID, up to 24 chars or 0 terminated
+	Ic_id  Cert `json:"ic_id"`  // ISED Canada certification number, up to 24 chars or 0 terminated
+
+	// crc uint16
+}
+
+// ComplianceInfo is the user facing description of a compliance record, used
+// for the JSON input file.
+type ComplianceInfo struct {
+	CountryOfOrigin string   `json:"country_of_origin"`
+	Regions         []string `json:"regions"`
+	RedId           string   `json:"red_id"`
+	FccId           string   `json:"fcc_id"`
+	IcId            string   `json:"ic_id"`
+}
+
+type LicenseSignature struct {
+	BaseSignature
+
+	Lic_file []byte `json:"lic_file"`
+
+	// crc uint16
+}
+
+type BoardVersion struct {
+	Major    uint8
+	Minor    uint8
+	Assembly uint8
+}
+
+func (v BoardVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Assembly)
+}
+
+func (v *BoardVersion) UnmarshalFlag(value string) error {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return errors.New("Expected 3 numbers as MAJOR.MINOR.ASSEMBLY")
+	}
+
+	major, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	minor, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return err
+	}
+	assembly, err := strconv.ParseInt(parts[2], 10, 32)
+	if err != nil {
+		return err
+	}
+
+	v.Major = uint8(major)
+	v.Minor = uint8(minor)
+	v.Assembly = uint8(assembly)
+
+	return nil
+}
+
+func (v BoardVersion) MarshalFlag() (string, error) {
+	return fmt.Sprintf("%s", v), nil
+}
+
+// Generator constructs, serializes and deserializes records, the zero value
+// is ready to use.
+type Generator struct {
+	emulate *Encoder // Serialize as an earlier generator version did
+	cbor    bool     // Serialize in the CBOR wire format
+	tlv     bool     // Serialize in the TLV layout of version 4
+	little  bool     // Serialize little-endian
+	check   uint8    // Integrity check of the records, the code in Checks
+}
+
+func (self *Generator) ConstructEUISignature(t time.Time, eui Eui64) (*EUISignature, error) {
+	sig := new(EUISignature)
+	sig.BaseSignature = self.newBase(t, SIGNATURE_TYPE_EUI64, binary.Size(sig))
+	sig.Eui64 = eui
+	return sig, nil
+}
+
+func (self *Generator) ConstructComponentSignature(t time.Time, boardname string,
+	boardversion BoardVersion, uuid [16]byte, manufuuid [16]byte,
+	serial [16]byte, component_position uint8,
+	signature_type uint8) (*ComponentSignature, error) {
+
+	sig := new(ComponentSignature)
+	sig.BaseSignature = self.newBase(t, signature_type, binary.Size(sig))
+
+	if len(boardname) == 0 {
+		return nil, errors.New(fmt.Sprintf("Boardname is too short(%d)", len(boardname)))
+	}
+
+	if len(boardname) > len(sig.Name) {
+		return nil, errors.New(fmt.Sprintf("Boardname is too long(%d), maximum allowed length is %d", len(boardname), len(sig.Name)))
+	}
+	copy(sig.Name[:], boardname)
+
+	sig.Version_major = boardversion.Major
+	sig.Version_minor = boardversion.Minor
+	sig.Version_assembly = boardversion.Assembly
+
+	sig.Component_uuid = uuid
+
+	sig.Serial_number = serial
+
+	sig.Manufacturer_uuid = manufuuid
+
+	sig.Position = component_position
+
+	return sig, nil
+}
+
+// AttachData adds component specific data to a component record.
+func (self *Generator) AttachData(sig *ComponentSignature, data []byte) (*ComponentDataSignature, error) {
+	size := binary.Size(sig) + len(data) + checkSize(self.check)
+	if size > MAX_SIGNATURE_LENGTH {
+		return nil, fmt.Errorf("Component data of %d bytes does not fit in a record, maximum is %d", len(data), MAX_SIGNATURE_LENGTH-binary.Size(sig)-checkSize(self.check))
+	}
+	dsig := &ComponentDataSignature{*sig, Data(data), 0}
+	dsig.Data_length = uint16(len(data))
+	dsig.Signature_size = uint16(size)
+	return dsig, nil
+}
+
+// AttachSlot places a component record, with or without data, in a named
+// slot, the slot follows the data.
+func (self *Generator) AttachSlot(rec interface{}, slot Slot) (*ComponentDataSignature, error) {
+	var dsig *ComponentDataSignature
+	switch r := rec.(type) {
+	case *ComponentSignature:
+		var err error
+		if dsig, err = self.AttachData(r, nil); err != nil {
+			return nil, err
+		}
+	case *ComponentDataSignature:
+		dsig = r
+	default:
+		return nil, fmt.Errorf("%T is not a component record", rec)
+	}
+	if dsig.Signature_type != SIGNATURE_TYPE_COMPONENT {
+		return nil, fmt.Errorf("Only component records have a slot, not %s records", TypeName(dsig.Signature_type))
+	}
+	if dsig.Slot == 0 {
+		if int(dsig.Signature_size)+binary.Size(slot) > MAX_SIGNATURE_LENGTH {
+			return nil, fmt.Errorf("Component data of %d bytes and a slot do not fit in a record", len(dsig.Data))
+		}
+		dsig.Signature_size += uint16(binary.Size(slot))
+	}
+	dsig.Slot = slot
+	return dsig, nil
+}
+
+func (self *Generator) ConstructComplianceSignature(t time.Time, info ComplianceInfo) (*ComplianceSignature, error) {
+	sig := new(ComplianceSignature)
+	sig.BaseSignature = self.newBase(t, SIGNATURE_TYPE_COMPLIANCE, binary.Size(sig))
+
+	coo := strings.ToUpper(info.CountryOfOrigin)
+	if len(coo) != len(sig.Country_of_origin) || !isUpperAlpha(coo) {
+		return nil, errors.New(fmt.Sprintf("Country of origin must be a 2 letter ISO 3166 code, got '%s'", info.CountryOfOrigin))
+	}
+	copy(sig.Country_of_origin[:], coo)
+
+	regions, err := ParseRegions(info.Regions)
+	if err != nil {
+		return nil, err
+	}
+	sig.Regions = regions
+
+	certs := []struct {
+		name  string
+		value string
+		field *Cert
+	}{
+		{"RED", info.RedId, &sig.Red_id},
+		{"FCC", info.FccId, &sig.Fcc_id},
+		{"IC", info.IcId, &sig.Ic_id},
+	}
+	for _, c := range certs {
+		if len(c.value) > len(c.field) {
+			return nil, errors.New(fmt.Sprintf("%s identifier is too long(%d), maximum allowed length is %d", c.name, len(c.value), len(c.field)))
+		}
+		copy(c.field[:], c.value)
+	}
+
+	return sig, nil
+}
+
+// isUpperAlpha tells if s consists of ASCII upper case letters only.
+func isUpperAlpha(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// SerializeLicense returns the license record of the contents of a license
+// file.
+func (self *Generator) SerializeLicense(t time.Time, lic []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	base := self.newBase(t, SIGNATURE_TYPE_LICENSE, len(lic)+binary.Size(BaseSignature{}))
+	if self.cbor || self.tlv {
+		return self.Serialize(&LicenseSignature{base, lic})
+	}
+	if self.emulate != nil {
+		var err error
+		if base, err = self.emulate.header(base); err != nil {
+			return nil, err
+		}
+	}
+	if err := binary.Write(buf, self.byteOrder(), base); err != nil {
+		return nil, err
+	}
+	buf.Write(lic)
+	self.appendCheck(buf)
+	return buf.Bytes(), nil
+}
+
+func (self *Generator) Serialize(sig interface{}) ([]byte, error) {
+	var err error
+	buf := new(bytes.Buffer)
+
+	if self.emulate != nil {
+		if self.cbor {
+			return nil, fmt.Errorf("Generator %s did not write CBOR records", self.emulate.Version)
+		}
+		if self.tlv {
+			return nil, fmt.Errorf("Generator %s did not write version %d records", self.emulate.Version, TLV_VERSION_MAJOR)
+		}
+		if self.little {
+			return nil, fmt.Errorf("Generator %s did not write little-endian records", self.emulate.Version)
+		}
+		if self.check != 0 {
+			return nil, fmt.Errorf("Generator %s did not write %s checks", self.emulate.Version, Checks[self.check])
+		}
+		if sig, err = self.emulate.encode(sig); err != nil {
+			return nil, err
+		}
+	}
+	if self.cbor && self.tlv {
+		return nil, fmt.Errorf("Version %d records have no CBOR wire format", TLV_VERSION_MAJOR)
+	}
+	if self.cbor && self.little {
+		return nil, errors.New("CBOR records have no byte order to choose")
+	}
+	if self.cbor && self.check != 0 {
+		return nil, fmt.Errorf("CBOR records end with the CRC-16/XMODEM, not %s", Checks[self.check])
+	}
+	if self.cbor {
+		return serializeCbor(sig)
+	}
+	if self.tlv {
+		return self.serializeTlv(sig)
+	}
+
+	order := self.byteOrder()
+	switch s := sig.(type) {
+	case *ComponentDataSignature:
+		err = binary.Write(buf, order, s.ComponentSignature)
+		if err == nil {
+			_, err = buf.Write(s.Data)
+		}
+		if err == nil && s.Slot != 0 {
+			err = binary.Write(buf, order, s.Slot)
+		}
+	default:
+		err = binary.Write(buf, order, sig)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	self.appendCheck(buf)
+	return buf.Bytes(), nil
+}
+
+// deserializeFixed reads a record with a fixed size body into sig.
+func deserializeFixed(data []byte, sig interface{}) error {
+	sz := binary.Size(sig)
+	if len(data) < sz+CheckSize(data) {
+		return fmt.Errorf("Failed to read signature from raw: %d bytes, need %d", len(data), sz+CheckSize(data))
+	}
+	if err := binary.Read(bytes.NewReader(data[:sz]), RecordOrder(data), sig); err != nil {
+		return fmt.Errorf("Failed to read signature from raw: %s", err)
+	}
+	clearFlags(sig)
+	return checkRecord(data, sz)
+}
+
+func (self *Generator) DeserializeEui(eui_bytes []byte) (EUISignature, error) {
+	var ret EUISignature
+	err := deserializeFixed(eui_bytes, &ret)
+	return ret, err
+}
+
+// DeserializeComponent returns a ComponentSignature, or a ComponentDataSignature
+// when the record has component data or a slot.
+func (self *Generator) DeserializeComponent(comp_bytes []byte) (interface{}, error) {
+	var ret ComponentSignature
+	sz := binary.Size(ComponentSignature{})
+	order := RecordOrder(comp_bytes)
+	w := CheckSize(comp_bytes)
+
+	if len(comp_bytes) < sz+w {
+		return ret, fmt.Errorf("Failed to read signature from raw: %d bytes, need %d", len(comp_bytes), sz+w)
+	}
+	err := binary.Read(bytes.NewReader(comp_bytes[:sz]), order, &ret)
+	if err != nil {
+		return ret, fmt.Errorf("Failed to read signature from raw: %s", err)
+	}
+	clearFlags(&ret)
+	data_end := sz + int(ret.Data_length)
+	end := data_end
+
+	// A component record in a named slot ends with the slot
+	var slot Slot
+	if ret.Signature_type == SIGNATURE_TYPE_COMPONENT && int(ret.Signature_size) == end+binary.Size(slot)+w {
+		end += binary.Size(slot)
+	}
+	if len(comp_bytes) < end+w {
+		return ret, fmt.Errorf("Component data truncated, %d bytes available, %d needed", len(comp_bytes), end+w)
+	}
+	if err := checkRecord(comp_bytes, end); err != nil {
+		return ret, err
+	}
+	if end > data_end {
+		slot = Slot(order.Uint32(comp_bytes[data_end:end]))
+	}
+	if ret.Data_length > 0 || slot != 0 {
+		return ComponentDataSignature{ret, Data(append([]byte{}, comp_bytes[sz:data_end]...)), slot}, nil
+	}
+	return ret, nil
+}
+
+func (self *Generator) DeserializeCompliance(comp_bytes []byte) (ComplianceSignature, error) {
+	var ret ComplianceSignature
+	err := deserializeFixed(comp_bytes, &ret)
+	return ret, err
+}
+
+func (self *Generator) DeserializeLicense(lic_bytes []byte) (LicenseSignature, error) {
+	var ret LicenseSignature
+
+	base, err := self.DeserializeBaseSignature(lic_bytes)
+	if err != nil {
+		return ret, err
+	}
+	ret.BaseSignature = base
+
+	w := CheckSize(lic_bytes)
+	sz := int(ret.Signature_size) - w
+	if sz < binary.Size(BaseSignature{}) || len(lic_bytes) < sz+w {
+		return ret, fmt.Errorf("License signature truncated, %d bytes available, %d needed", len(lic_bytes), sz+w)
+	}
+	ret.Lic_file = lic_bytes[binary.Size(BaseSignature{}):sz]
+
+	return ret, checkRecord(lic_bytes, sz)
+}
+
+func (self *Generator) DeserializeBaseSignature(sig_bytes []byte) (BaseSignature, error) {
+	var ret BaseSignature
+	if len(sig_bytes) < binary.Size(ret) {
+		return ret, fmt.Errorf("Failed to read BaseSignature from raw: %d bytes, need %d", len(sig_bytes), binary.Size(ret))
+	}
+	err := binary.Read(bytes.NewReader(sig_bytes[:binary.Size(ret)]), RecordOrder(sig_bytes), &ret)
+	if err != nil {
+		return ret, fmt.Errorf("Failed to read BaseSignature from raw: %s", err)
+	}
+	clearFlags(&ret)
+	return ret, nil
+}
+
+// DeserializeArea parses the records of a signature area, binary, version 4
+// TLV and CBOR records alike. Whatever follows the last record, 0xFF padding
+// of the flash, is ignored. Records of unknown types are skipped.
+func (self *Generator) DeserializeArea(data []byte) ([]interface{}, error) {
+	sigs := make([]interface{}, 0)
+	for rd := 0; rd < len(data); {
+		if IsCborRecord(data[rd:]) {
+			sig, n, err := self.DeserializeCbor(data[rd:])
+			if err != nil {
+				return sigs, fmt.Errorf("Failed to deserialize CBOR signature at %d (%s)", rd, err)
+			}
+			if sig != nil {
+				sigs = append(sigs, sig)
+			}
+			rd += n
+			continue
+		}
+
+		bsig, err := self.DeserializeBaseSignature(data[rd:])
+		if err != nil {
+			if len(sigs) > 0 {
+				// Garbage at the end of file?, consider deserialization finished successfully
+				return sigs, nil
+			}
+			return sigs, fmt.Errorf("Failed to deserialize base signature (%s)", err)
+		}
+		if bsig.Signature_size <= 0 || bsig.Signature_size > MAX_SIGNATURE_LENGTH {
+			break
+		}
+
+		if bsig.Sig_version_major == TLV_VERSION_MAJOR {
+			sig, err := self.DeserializeTlv(data[rd:])
+			if err != nil {
+				return sigs, fmt.Errorf("Failed to deserialize %s signature (%s)", TypeName(bsig.Signature_type), err)
+			}
+			if sig != nil {
+				sigs = append(sigs, sig)
+			}
+			rd += int(bsig.Signature_size)
+			continue
+		}
+
+		var sig interface{}
+		switch bsig.Signature_type {
+		case SIGNATURE_TYPE_EUI64:
+			sig, err = self.DeserializeEui(data[rd:])
+		case SIGNATURE_TYPE_BOARD, SIGNATURE_TYPE_PLATFORM, SIGNATURE_TYPE_COMPONENT:
+			// Board and platform records have the same structure as components
+			sig, err = self.DeserializeComponent(data[rd:])
+		case SIGNATURE_TYPE_LICENSE:
+			sig, err = self.DeserializeLicense(data[rd:])
+		case SIGNATURE_TYPE_COMPLIANCE:
+			sig, err = self.DeserializeCompliance(data[rd:])
+		case SIGNATURE_TYPE_EXTENDED_ID:
+			sig, err = self.DeserializeExtendedId(data[rd:])
+		case SIGNATURE_TYPE_DECOMMISSION:
+			sig, err = self.DeserializeDecommission(data[rd:])
+		case SIGNATURE_TYPE_AREA_SIGNATURE:
+			sig, err = self.DeserializeAreaSignature(data[rd:])
+		case SIGNATURE_TYPE_PIN:
+			sig, err = self.DeserializePin(data[rd:])
+		case SIGNATURE_TYPE_LICENSE_V2:
+			sig, err = self.DeserializeLicenseV2(data[rd:])
+		}
+		if err != nil {
+			return sigs, fmt.Errorf("Failed to deserialize %s signature (%s)", TypeName(bsig.Signature_type), err)
+		}
+		if sig != nil {
+			sigs = append(sigs, sig)
+		}
+		rd += int(bsig.Signature_size)
+	}
+
+	if len(sigs) == 0 {
+		return sigs, errors.New("No signatures found")
+	}
+	return sigs, nil
+}
+
+// RecordLength returns the length of the binary or CBOR record at the start
+// of data, the CRC included, for walking the records of an area.
+func RecordLength(data []byte) (int, error) {
+	if IsCborRecord(data) {
+		_, n, err := decodeCbor(data[len(g_cbor_tag):], 0)
+		if err != nil {
+			return 0, err
+		}
+		if n += len(g_cbor_tag) + 2; n > MAX_SIGNATURE_LENGTH {
+			return 0, fmt.Errorf("CBOR record of %d bytes is too long", n)
+		}
+		return n, nil
+	}
+	var gen Generator
+	base, err := gen.DeserializeBaseSignature(data)
+	if err != nil {
+		return 0, err
+	}
+	if int(base.Signature_size) < binary.Size(base)+2 || base.Signature_size > MAX_SIGNATURE_LENGTH {
+		return 0, fmt.Errorf("Record has size %d", base.Signature_size)
+	}
+	return int(base.Signature_size), nil
+}
+
+var g_type_names = map[uint8]string{
+	SIGNATURE_TYPE_EUI64:          "eui64",
+	SIGNATURE_TYPE_BOARD:          "board",
+	SIGNATURE_TYPE_PLATFORM:       "platform",
+	SIGNATURE_TYPE_COMPONENT:      "component",
+	SIGNATURE_TYPE_LICENSE:        "license",
+	SIGNATURE_TYPE_COMPLIANCE:     "compliance",
+	SIGNATURE_TYPE_EXTENDED_ID:    "extended_id",
+	SIGNATURE_TYPE_DECOMMISSION:   "decommission",
+	SIGNATURE_TYPE_AREA_SIGNATURE: "area_signature",
+	SIGNATURE_TYPE_PIN:            "pin",
+	SIGNATURE_TYPE_LICENSE_V2:     "license_v2",
+}
+
+// TypeName returns the name of a record type.
+func TypeName(t uint8) string {
+	if name, ok := g_type_names[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", t)
+}
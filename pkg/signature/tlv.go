@@ -0,0 +1,260 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "sort"
+import "bytes"
+import "errors"
+import "reflect"
+import "encoding/binary"
+
+// Signature format version 4, the fields of a record as tagged TLVs. The
+// record keeps the 14 byte header of version 3, with sig_version_major 4, so
+// bootloaders walk the area by signature_size as before. The header is
+// followed by the fields, every one a 1 byte tag, a 2 byte big endian length
+// and the value, in the order of the tags, and the CRC of the whole record.
+// The lengths are big endian in little-endian records too, the values are
+// the fields as version 3 writes them, in the byte order of the record. Parsers skip the tags
+// they do not know, so a field is added with a new tag, e.g. a longer name,
+// without breaking the parsers in the field. The data_length of components is
+// the length of the data TLV.
+//
+// The tags are part of the signature format and must never be reassigned.
+
+const TLV_VERSION_MAJOR = 4
+const TLV_VERSION_MINOR = 0
+const TLV_VERSION_PATCH = 0
+
+// Signature format versions usersiggen can write
+var SigVersions = []int{3, TLV_VERSION_MAJOR}
+
+var g_tlv_tags = map[string]uint8{
+	"eui64":                1,
+	"component_uuid":       2,
+	"component_name":       3,
+	"pcb_version_major":    4,
+	"pcb_version_minor":    5,
+	"pcb_version_assembly": 6,
+	"serial_number":        7,
+	"manufacturer":         8,
+	"position":             9,
+	"slot":                 10,
+	"data":                 11,
+	"country_of_origin":    12,
+	"regions":              13,
+	"red_id":               14,
+	"fcc_id":               15,
+	"ic_id":                16,
+	"lic_file":             17,
+	"not_before":           18,
+	"not_after":            19,
+	"id128":                20,
+	"namespace":            21,
+	"reason":               22,
+	"authority":            23,
+	"key_id":               24,
+	"signature":            25,
+	"pin_length":           26,
+	"salt":                 27,
+	"pin_hash":             28,
+}
+
+// SetSigVersion selects the signature format the records are serialized in,
+// 3 for the fixed structures or 4 for the TLV layout.
+func (self *Generator) SetSigVersion(version int) error {
+	switch version {
+	case 3:
+		self.tlv = false
+	case TLV_VERSION_MAJOR:
+		self.tlv = true
+	default:
+		return fmt.Errorf("Unknown signature format version %d, known versions are: %v", version, SigVersions)
+	}
+	return nil
+}
+
+// IsTlvRecord tells if the record at the start of data is a version 4 TLV
+// record.
+func IsTlvRecord(data []byte) bool {
+	return len(data) > 0 && data[0]&^VERSION_FLAGS == TLV_VERSION_MAJOR
+}
+
+// tlvFields returns the fields of a record that are written as TLVs, by
+// their tags, the header fields and the data_length are left out.
+func tlvFields(v reflect.Value) (map[uint8]reflect.Value, error) {
+	fields := make(map[uint8]reflect.Value)
+	for name, fv := range cborFields(v) {
+		switch name {
+		case "sig_version_major", "sig_version_minor", "sig_version_patch", "signature_type", "unix_time", "data_length":
+			continue
+		}
+		tag, ok := g_tlv_tags[name]
+		if !ok {
+			return nil, fmt.Errorf("%s has no TLV tag", name)
+		}
+		fields[tag] = fv
+	}
+	return fields, nil
+}
+
+// serializeTlv returns a record in the TLV layout of version 4.
+func (self *Generator) serializeTlv(sig interface{}) ([]byte, error) {
+	v := reflect.Indirect(reflect.ValueOf(sig))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is not a record", sig)
+	}
+	fields, err := tlvFields(v)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]int, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	order := self.byteOrder()
+	body := new(bytes.Buffer)
+	for _, tag := range tags {
+		fv := fields[uint8(tag)]
+		var value []byte
+		if fv.Kind() == reflect.Slice {
+			value = fv.Bytes()
+		} else {
+			b := new(bytes.Buffer)
+			if err := binary.Write(b, order, fv.Interface()); err != nil {
+				return nil, err
+			}
+			value = b.Bytes()
+		}
+		body.WriteByte(uint8(tag))
+		binary.Write(body, binary.BigEndian, uint16(len(value)))
+		body.Write(value)
+	}
+
+	base := v.FieldByName("BaseSignature").Interface().(BaseSignature)
+	base.Sig_version_major, base.Sig_version_minor, base.Sig_version_patch = TLV_VERSION_MAJOR, TLV_VERSION_MINOR, TLV_VERSION_PATCH
+	size := binary.Size(base) + body.Len() + checkSize(self.check)
+	if size > MAX_SIGNATURE_LENGTH {
+		return nil, fmt.Errorf("TLV record of %d bytes is too long, maximum is %d", size, MAX_SIGNATURE_LENGTH)
+	}
+	base.Signature_size = uint16(size)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, order, base)
+	buf.Write(body.Bytes())
+	self.appendCheck(buf)
+	return buf.Bytes(), nil
+}
+
+// setTlvField sets a field of a record from its TLV value. Byte arrays may be
+// shorter than the field, the rest is zero.
+func setTlvField(tag uint8, f reflect.Value, value []byte, order binary.ByteOrder) error {
+	switch f.Kind() {
+	case reflect.Slice:
+		f.SetBytes(append([]byte{}, value...))
+	case reflect.Array:
+		if len(value) > f.Len() {
+			return fmt.Errorf("TLV %d of %d bytes does not fit in %d", tag, len(value), f.Len())
+		}
+		reflect.Copy(f, reflect.ValueOf(value))
+	default:
+		if len(value) != binary.Size(f.Interface()) {
+			return fmt.Errorf("TLV %d of %d bytes, need %d", tag, len(value), binary.Size(f.Interface()))
+		}
+		if err := binary.Read(bytes.NewReader(value), order, f.Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTlv calls fn with the tag, the offset and the length of the value of
+// every TLV of the version 4 record at the start of data, the TLVs end at sz.
+func walkTlv(data []byte, sz int, fn func(tag uint8, off int, length int)) error {
+	for rd := binary.Size(BaseSignature{}); rd < sz; {
+		if rd+3 > sz {
+			return fmt.Errorf("TLV at %d truncated", rd)
+		}
+		tag := data[rd]
+		length := int(binary.BigEndian.Uint16(data[rd+1 : rd+3]))
+		if rd+3+length > sz {
+			return fmt.Errorf("TLV %d at %d of %d bytes is truncated", tag, rd, length)
+		}
+		fn(tag, rd+3, length)
+		rd += 3 + length
+	}
+	return nil
+}
+
+// DeserializeTlv parses the version 4 record at the start of data, it returns
+// the record by value, nil for a type that is not known. Like for CBOR, the
+// signature_size of the record is the one of its binary form.
+func (self *Generator) DeserializeTlv(data []byte) (interface{}, error) {
+	base, err := self.DeserializeBaseSignature(data)
+	if err != nil {
+		return nil, err
+	}
+	if base.Sig_version_major != TLV_VERSION_MAJOR {
+		return nil, errors.New("Not a TLV record")
+	}
+	hdr := binary.Size(base)
+	sz := int(base.Signature_size) - CheckSize(data)
+	if sz < hdr || base.Signature_size > MAX_SIGNATURE_LENGTH {
+		return nil, fmt.Errorf("TLV record has size %d", base.Signature_size)
+	}
+	if err := checkRecord(data, sz); err != nil {
+		return nil, err
+	}
+
+	order := RecordOrder(data)
+	values := make(map[uint8][]byte)
+	err = walkTlv(data, sz, func(tag uint8, off int, length int) {
+		values[tag] = data[off : off+length]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	kind := make(map[string]interface{})
+	if d, ok := values[g_tlv_tags["data"]]; ok {
+		kind["data"] = d
+	}
+	if s, ok := values[g_tlv_tags["slot"]]; ok && len(s) == binary.Size(Slot(0)) {
+		kind["slot"] = uint64(order.Uint32(s))
+	}
+	rec := cborRecordOf(base.Signature_type, kind)
+	if rec == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(rec).Elem()
+	v.FieldByName("BaseSignature").Set(reflect.ValueOf(base))
+	fields, err := tlvFields(v)
+	if err != nil {
+		return nil, err
+	}
+	for tag, f := range fields {
+		if value, ok := values[tag]; ok {
+			if err := setTlvField(tag, f, value, order); err != nil {
+				return nil, err
+			}
+		}
+	}
+	v.FieldByName("Signature_size").SetUint(uint64(binarySize(rec, CheckSize(data))))
+	if d, ok := rec.(*ComponentDataSignature); ok {
+		d.Data_length = uint16(len(d.Data))
+	}
+	return v.Interface(), nil
+}
+
+// TlvTagName returns the field name of a TLV tag.
+func TlvTagName(tag uint8) string {
+	for name, t := range g_tlv_tags {
+		if t == tag {
+			return name
+		}
+	}
+	return fmt.Sprintf("unknown(%d)", tag)
+}
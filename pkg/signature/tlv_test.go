@@ -0,0 +1,39 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "bytes"
+import "testing"
+import "time"
+
+// The TLV lengths are big endian in records of both byte orders, the values
+// are in the byte order of the record.
+func TestTlvLengths(t *testing.T) {
+	eui := Eui64(0x70B3D5D72F000001)
+	values := map[string][]byte{
+		ENDIANNESS_BIG:    {0x70, 0xB3, 0xD5, 0xD7, 0x2F, 0x00, 0x00, 0x01},
+		ENDIANNESS_LITTLE: {0x01, 0x00, 0x00, 0x2F, 0xD7, 0xD5, 0xB3, 0x70},
+	}
+	for _, endianness := range Endiannesses {
+		g := new(Generator)
+		g.SetSigVersion(TLV_VERSION_MAJOR)
+		g.SetEndianness(endianness)
+		sig, _ := g.ConstructEUISignature(time.Unix(1700000000, 0), eui)
+		data, err := g.Serialize(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]byte{g_tlv_tags["eui64"], 0x00, 0x08}, values[endianness]...)
+		if tlv := data[14 : 14+len(want)]; !bytes.Equal(tlv, want) {
+			t.Errorf("%s: eui64 TLV % X, want % X", endianness, tlv, want)
+		}
+		rec, err := g.DeserializeTlv(data)
+		if err != nil {
+			t.Fatalf("%s: %s", endianness, err)
+		}
+		if got := rec.(EUISignature).Eui64; got != eui {
+			t.Errorf("%s: read back %016X", endianness, uint64(got))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "fmt"
+import "bytes"
+import "strings"
+import "hash/fnv"
+import "encoding/json"
+
+import "go.thinnect.net/euisig/eui"
+
+// Field types of the records, fixed size for the binary layout, with the JSON
+// form used by read-sig.
+
+type Eui64 uint64
+
+func (m Eui64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%016X", m))
+}
+
+type Uuid [16]byte
+
+func (u Uuid) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eui.UuidString(u))
+}
+
+type Name [16]byte
+
+func (n Name) MarshalJSON() ([]byte, error) {
+	l := len(n)
+	for i := 0; i < l; i++ {
+		if n[i] == 0 {
+			l = i
+		}
+	}
+	return json.Marshal(fmt.Sprintf("%s", n[:l]))
+}
+
+type Country [2]byte
+
+func (c Country) MarshalJSON() ([]byte, error) {
+	if c[0] == 0 {
+		return json.Marshal("")
+	}
+	return json.Marshal(string(c[:]))
+}
+
+type Cert [24]byte
+
+func (c Cert) MarshalJSON() ([]byte, error) {
+	n := bytes.IndexByte(c[:], 0)
+	if n < 0 {
+		n = len(c)
+	}
+	return json.Marshal(string(c[:n]))
+}
+
+type Regions uint32
+
+// Regulatory regions the device has been certified for, bit positions are
+// part of the signature format and must never be reassigned.
+var RegionNames = []string{"eu", "us", "ca", "uk", "jp", "au", "nz", "kr", "cn", "in", "br", "za"}
+
+func (r Regions) MarshalJSON() ([]byte, error) {
+	names := make([]string, 0)
+	for i := uint(0); i < 32; i++ {
+		if r&(1<<i) != 0 {
+			if int(i) < len(RegionNames) {
+				names = append(names, RegionNames[i])
+			} else {
+				names = append(names, fmt.Sprintf("bit%d", i))
+			}
+		}
+	}
+	return json.Marshal(names)
+}
+
+func ParseRegions(names []string) (Regions, error) {
+	var r Regions
+	for _, name := range names {
+		found := false
+		for i, rn := range RegionNames {
+			if strings.EqualFold(name, rn) {
+				r |= 1 << uint(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Unknown region %s, supported regions are: %s", name, strings.Join(RegionNames, ", "))
+		}
+	}
+	return r, nil
+}
+
+type Data []byte
+
+func (d Data) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%X", []byte(d)))
+}
+
+// Slots name the places components are fitted to on a platform, "radio0" or
+// "sensor-ext". The component record carries the FNV-1a hash of the slot
+// name, the names themselves come from the platform definition.
+type Slot uint32
+
+// SlotNames are the slot names known from the platform definition, for
+// displaying records.
+var SlotNames = make(map[Slot]string)
+
+func (s Slot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Slot) String() string {
+	if name, ok := SlotNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08X", uint32(s))
+}
+
+func SlotHash(name string) Slot {
+	if len(name) == 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return Slot(h.Sum32())
+}
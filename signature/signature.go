@@ -0,0 +1,128 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Package signature forwards to go.thinnect.net/euisig/signature, where the
+// package moved with the separately versioned go.thinnect.net/euisig module.
+//
+// Deprecated: import go.thinnect.net/euisig/signature instead. The
+// shims stay until the next major version of the format.
+package signature
+
+import "go.thinnect.net/euisig/signature"
+
+// Deprecated: use the constants of go.thinnect.net/euisig/signature.
+const (
+	CHECK_CCITT                   = signature.CHECK_CCITT
+	CHECK_CRC32                   = signature.CHECK_CRC32
+	CHECK_SHA256                  = signature.CHECK_SHA256
+	CHECK_XMODEM                  = signature.CHECK_XMODEM
+	ENDIANNESS_BIG                = signature.ENDIANNESS_BIG
+	ENDIANNESS_LITTLE             = signature.ENDIANNESS_LITTLE
+	FLAG_CHECK                    = signature.FLAG_CHECK
+	FLAG_CHECK_SHIFT              = signature.FLAG_CHECK_SHIFT
+	FLAG_LITTLE_ENDIAN            = signature.FLAG_LITTLE_ENDIAN
+	FORMAT_ENCODING_BINARY        = signature.FORMAT_ENCODING_BINARY
+	FORMAT_ENCODING_CBOR          = signature.FORMAT_ENCODING_CBOR
+	FORMAT_ENCODING_TLV           = signature.FORMAT_ENCODING_TLV
+	MAX_PIN_LENGTH                = signature.MAX_PIN_LENGTH
+	MAX_SIGNATURE_LENGTH          = signature.MAX_SIGNATURE_LENGTH
+	SIGNATURE_TYPE_AREA_SIGNATURE = signature.SIGNATURE_TYPE_AREA_SIGNATURE
+	SIGNATURE_TYPE_BOARD          = signature.SIGNATURE_TYPE_BOARD
+	SIGNATURE_TYPE_COMPLIANCE     = signature.SIGNATURE_TYPE_COMPLIANCE
+	SIGNATURE_TYPE_COMPONENT      = signature.SIGNATURE_TYPE_COMPONENT
+	SIGNATURE_TYPE_DECOMMISSION   = signature.SIGNATURE_TYPE_DECOMMISSION
+	SIGNATURE_TYPE_EUI64          = signature.SIGNATURE_TYPE_EUI64
+	SIGNATURE_TYPE_EXTENDED_ID    = signature.SIGNATURE_TYPE_EXTENDED_ID
+	SIGNATURE_TYPE_LICENSE        = signature.SIGNATURE_TYPE_LICENSE
+	SIGNATURE_TYPE_LICENSE_V2     = signature.SIGNATURE_TYPE_LICENSE_V2
+	SIGNATURE_TYPE_PIN            = signature.SIGNATURE_TYPE_PIN
+	SIGNATURE_TYPE_PLATFORM       = signature.SIGNATURE_TYPE_PLATFORM
+	TLV_VERSION_MAJOR             = signature.TLV_VERSION_MAJOR
+	TLV_VERSION_MINOR             = signature.TLV_VERSION_MINOR
+	TLV_VERSION_PATCH             = signature.TLV_VERSION_PATCH
+	VERSION_FLAGS                 = signature.VERSION_FLAGS
+	WIRE_FORMAT_BINARY            = signature.WIRE_FORMAT_BINARY
+	WIRE_FORMAT_CBOR              = signature.WIRE_FORMAT_CBOR
+)
+
+// Deprecated: use the variables of go.thinnect.net/euisig/signature.
+// These are copies, changing them does not change the module.
+var (
+	Checks              = signature.Checks
+	DecommissionReasons = signature.DecommissionReasons
+	Endiannesses        = signature.Endiannesses
+	RegionNames         = signature.RegionNames
+	SigVersions         = signature.SigVersions
+	SlotNames           = signature.SlotNames
+	VersionMajor        = signature.VersionMajor
+	VersionMinor        = signature.VersionMinor
+	VersionPatch        = signature.VersionPatch
+	WireFormats         = signature.WireFormats
+)
+
+// Deprecated: use the types of go.thinnect.net/euisig/signature.
+type (
+	AreaSignature          = signature.AreaSignature
+	BaseSignature          = signature.BaseSignature
+	BoardVersion           = signature.BoardVersion
+	Cert                   = signature.Cert
+	ComplianceInfo         = signature.ComplianceInfo
+	ComplianceSignature    = signature.ComplianceSignature
+	ComponentDataSignature = signature.ComponentDataSignature
+	ComponentSignature     = signature.ComponentSignature
+	Country                = signature.Country
+	Data                   = signature.Data
+	DecommissionSignature  = signature.DecommissionSignature
+	EUISignature           = signature.EUISignature
+	Ed25519Signature       = signature.Ed25519Signature
+	Encoder                = signature.Encoder
+	Eui64                  = signature.Eui64
+	ExtendedIdSignature    = signature.ExtendedIdSignature
+	FormatChange           = signature.FormatChange
+	FormatField            = signature.FormatField
+	FormatFlag             = signature.FormatFlag
+	FormatRecord           = signature.FormatRecord
+	FormatVersion          = signature.FormatVersion
+	Generator              = signature.Generator
+	Id128                  = signature.Id128
+	Identity               = signature.Identity
+	KeyId                  = signature.KeyId
+	LicenseSignature       = signature.LicenseSignature
+	LicenseV2Signature     = signature.LicenseV2Signature
+	LicenseWindow          = signature.LicenseWindow
+	Name                   = signature.Name
+	PinHash                = signature.PinHash
+	PinSignature           = signature.PinSignature
+	Reason                 = signature.Reason
+	Regions                = signature.Regions
+	Salt                   = signature.Salt
+	Slot                   = signature.Slot
+	Uuid                   = signature.Uuid
+)
+
+// Deprecated: use the functions of go.thinnect.net/euisig/signature.
+var (
+	CheckName      = signature.CheckName
+	CheckSize      = signature.CheckSize
+	ComponentOf    = signature.ComponentOf
+	Crc            = signature.Crc
+	EncoderOf      = signature.EncoderOf
+	Encoders       = signature.Encoders
+	FormatHistory  = signature.FormatHistory
+	FormatOf       = signature.FormatOf
+	FormatOfRecord = signature.FormatOfRecord
+	IsCborRecord   = signature.IsCborRecord
+	IsTlvRecord    = signature.IsTlvRecord
+	KeyIdOf        = signature.KeyIdOf
+	NewIdentity    = signature.NewIdentity
+	ParseReason    = signature.ParseReason
+	ParseRegions   = signature.ParseRegions
+	Recheck        = signature.Recheck
+	RecordCheck    = signature.RecordCheck
+	RecordLength   = signature.RecordLength
+	RecordOrder    = signature.RecordOrder
+	SlotHash       = signature.SlotHash
+	SlotOf         = signature.SlotOf
+	TlvTagName     = signature.TlvTagName
+	TypeName       = signature.TypeName
+)
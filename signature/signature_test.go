@@ -0,0 +1,35 @@
+// Author  Raido Pahtma
+// License MIT
+
+package signature
+
+import "bytes"
+import "testing"
+import "time"
+
+import "go.thinnect.net/euisig/signature"
+
+// Records constructed through the shims are the records of the module and
+// serialize the same.
+func TestShims(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	var old Generator
+	var gen signature.Generator = old
+	a, err := old.ConstructEUISignature(ts, 0x70B3D5D72F000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := gen.ConstructEUISignature(ts, 0x70B3D5D72F000001)
+	var rec *signature.EUISignature = a
+	da, err := old.Serialize(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := gen.Serialize(b)
+	if !bytes.Equal(da, db) {
+		t.Errorf("the shim serializes % X, the module % X", da, db)
+	}
+	if Crc(da) != signature.Crc(da) || SIGNATURE_TYPE_LICENSE_V2 != signature.SIGNATURE_TYPE_LICENSE_V2 {
+		t.Errorf("the shims do not forward to the module")
+	}
+}
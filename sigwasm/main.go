@@ -0,0 +1,112 @@
+// Author  Raido Pahtma
+// License MIT
+
+//go:build js && wasm
+
+// The signature decoder for browsers, for support tools that show what a
+// dumped sigdata.bin carries without sending the identity of the device to a
+// server. Built with GOOS=js GOARCH=wasm, it registers thinnectSigdata with a
+// decode function taking the bytes of the area as an Uint8Array and returning
+// the decoded records as JSON. sigdata.js wraps it for the page.
+package main
+
+import "fmt"
+import "reflect"
+import "encoding/json"
+import "syscall/js"
+
+import "go.thinnect.net/euisig/signature"
+
+const SCHEMA_DECODE = "euisiggen/decode/v1"
+
+type decodedRecord struct {
+	Offset int         `json:"offset"`
+	Type   string      `json:"type"`
+	Record interface{} `json:"record,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type decodedArea struct {
+	Schema  string          `json:"schema"`
+	Size    int             `json:"size"`
+	Records []decodedRecord `json:"records"`
+	Error   string          `json:"error,omitempty"` // What stopped the walk, the records before it are given
+}
+
+// decodeArea walks the records of an area like DeserializeArea and decodes
+// them one by one, so a bad record is shown where it is and does not hide the
+// others.
+func decodeArea(data []byte) decodedArea {
+	var gen signature.Generator
+	area := decodedArea{Schema: SCHEMA_DECODE, Size: len(data), Records: make([]decodedRecord, 0)}
+
+	for rd := 0; rd < len(data); {
+		if signature.IsCborRecord(data[rd:]) {
+			size, err := signature.RecordLength(data[rd:])
+			if err != nil || rd+size > len(data) {
+				area.Error = fmt.Sprintf("CBOR record at %d is truncated or malformed", rd)
+				break
+			}
+			rec := decodedRecord{Offset: rd, Type: "cbor"}
+			if sig, _, err := gen.DeserializeCbor(data[rd : rd+size]); err != nil {
+				rec.Error = err.Error()
+			} else {
+				rec.Record = sig
+				if sig != nil {
+					rec.Type = signature.TypeName(uint8(reflect.ValueOf(sig).FieldByName("Signature_type").Uint()))
+				}
+			}
+			area.Records = append(area.Records, rec)
+			rd += size
+			continue
+		}
+		base, err := gen.DeserializeBaseSignature(data[rd:])
+		if err != nil || base.Signature_size == 0xFFFF {
+			break // Erased flash after the last record
+		}
+		end := rd + int(base.Signature_size)
+		if base.Signature_size == 0 || base.Signature_size > signature.MAX_SIGNATURE_LENGTH {
+			area.Error = fmt.Sprintf("record at %d has size %d", rd, base.Signature_size)
+			break
+		}
+		if end > len(data) {
+			area.Error = fmt.Sprintf("record at %d is truncated, %d of %d bytes", rd, len(data)-rd, base.Signature_size)
+			break
+		}
+
+		rec := decodedRecord{Offset: rd, Type: signature.TypeName(base.Signature_type)}
+		if sigs, err := gen.DeserializeArea(data[rd:end]); err != nil {
+			rec.Error = err.Error()
+		} else {
+			rec.Record = sigs[0]
+		}
+		area.Records = append(area.Records, rec)
+		rd = end
+	}
+	if len(area.Records) == 0 && len(area.Error) == 0 {
+		area.Error = "no signatures found"
+	}
+	return area
+}
+
+func decode(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return `{"error": "decode takes the signature area as an Uint8Array"}`
+	}
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+
+	j, err := json.Marshal(decodeArea(data))
+	if err != nil {
+		j, _ = json.Marshal(decodedArea{Schema: SCHEMA_DECODE, Size: len(data), Error: err.Error()})
+	}
+	return string(j)
+}
+
+func main() {
+	js.Global().Set("thinnectSigdata", js.ValueOf(map[string]interface{}{
+		"decode": js.FuncOf(decode),
+	}))
+	// The functions are called from the page for as long as it is open
+	select {}
+}
@@ -0,0 +1,19 @@
+// Author  Raido Pahtma
+// License MIT
+
+// Decodes dumped signature areas in the browser with sigdata.wasm, nothing
+// is sent to a server. Needs wasm_exec.js of the Go release the decoder was
+// built with loaded first.
+//
+//	const decoder = await loadSigdataDecoder("sigdata.wasm");
+//	const area = decoder.decode(new Uint8Array(await file.arrayBuffer()));
+//	area.records.forEach(r => console.log(r.offset, r.type, r.record, r.error));
+
+async function loadSigdataDecoder(url) {
+	const go = new Go();
+	const wasm = await WebAssembly.instantiateStreaming(fetch(url), go.importObject);
+	go.run(wasm.instance); // Returns only when the page is closed
+	return {
+		decode: (bytes) => JSON.parse(globalThis.thinnectSigdata.decode(bytes)),
+	};
+}
@@ -0,0 +1,12 @@
+// Author  Raido Pahtma
+// License MIT
+
+package main
+
+import "os"
+
+import "github.com/thinnect/euisiggen/cli/thinnectid"
+
+func main() {
+	thinnectid.Main("thinnect-id", os.Args[1:])
+}
@@ -0,0 +1,108 @@
+#!/bin/sh
+# Author  Raido Pahtma
+# License MIT
+#
+# CLI compatibility suite. Pins the behaviour of the legacy invocations that
+# production scripts rely on, run it against a build before releasing:
+#
+#   compat/cli-compat.sh path/to/usersiggen
+#
+# Every case prints PASS or FAIL, the exit code is the number of failures.
+
+USG=$(cd "$(dirname "${1:-usersiggen}")" && pwd)/$(basename "${1:-usersiggen}")
+GOLDEN=$(cd "$(dirname "$0")" && pwd)/golden
+WORK=$(mktemp -d)
+trap 'rm -rf "$WORK"' EXIT
+cd "$WORK" || exit 1
+
+FAILED=0
+
+pass() { echo "PASS $1"; }
+fail() { echo "FAIL $1"; FAILED=$((FAILED + 1)); }
+
+# expect <name> <exit code> <command ...>
+expect() {
+	name=$1; code=$2; shift 2
+	"$@" >out.txt 2>err.txt
+	rc=$?
+	if [ "$rc" -eq "$code" ]; then
+		pass "$name"
+	else
+		fail "$name (exit $rc, expected $code)"
+		sed 's/^/    /' out.txt err.txt
+	fi
+}
+
+# contains <name> <file> <text>
+contains() {
+	if grep -q -- "$3" "$2"; then pass "$1"; else fail "$1"; fi
+}
+
+BOARD="--type board --name compat --version 1.2.3
+	--uuid 00112233-4455-6677-8899-aabbccddeeff
+	--manufacturer 99999999-8888-7777-6666-555555555555
+	--timestamp 1600000000"
+SERIAL=01234567-89ab-cdef-0123-456789abcdef
+
+mkeuis() {
+	printf '%s\n' "# EUI-64 range" 0011223344550001, 0011223344550002, 0011223344550003, >"$1"
+}
+
+# Board signature with the original flag names
+mkeuis eui.txt
+expect "legacy board generation" 0 "$USG" $BOARD --serialuuid $SERIAL --euifile eui.txt --out legacy.bin
+contains "legacy flag warns on stderr" err.txt '"flag":"serialuuid"'
+contains "legacy flag warning is structured" err.txt "euisiggen/deprecation/v1"
+contains "EUI is marked in the pool" eui.txt "^0011223344550001,compat,"
+
+# The same board with the new flag names gives the same signature
+mkeuis eui2.txt
+expect "current board generation" 0 "$USG" $BOARD --serial-uuid $SERIAL --euifile eui2.txt --sigdir sigdata2 --out current.bin
+if [ ! -s err.txt ]; then pass "current flags do not warn"; else fail "current flags do not warn"; fi
+if cmp -s legacy.bin current.bin; then pass "legacy and current flags agree"; else fail "legacy and current flags agree"; fi
+
+expect "strict-cli refuses legacy flag" 2 "$USG" --strict-cli $BOARD --serialuuid $SERIAL --euifile eui2.txt --out strict.bin
+if [ ! -e strict.bin ]; then pass "strict-cli writes nothing"; else fail "strict-cli writes nothing"; fi
+expect "legacy and current flag together" 2 "$USG" $BOARD --serialuuid $SERIAL --serial-uuid $SERIAL --euifile eui2.txt --out both.bin
+
+# Reading signatures, the v1 schema is the original layout
+expect "read-sig" 0 "$USG" -r legacy.bin
+contains "read-sig schema v2 by default" out.txt "euisiggen/read-sig/v2"
+expect "read-sig v1" 0 "$USG" --schema v1 --read-sig legacy.bin
+if grep -q '"schema"' out.txt; then fail "read-sig v1 has no schema field"; else pass "read-sig v1 has no schema field"; fi
+contains "read-sig v1 board" out.txt '"board_signature"'
+expect "read-sig missing file" 3 "$USG" -r missing.bin
+expect "verify" 0 "$USG" --verify legacy.bin
+cp legacy.bin garbage.bin; printf 'junk' >>garbage.bin
+expect "verify trailing garbage" 4 "$USG" --verify garbage.bin
+
+# License append as documented in bin/README.md
+printf 'LICENSE' >license.bin
+expect "legacy license append" 0 "$USG" --type license --sigfile legacy.bin --licfile license.bin --out legacy+license.bin
+contains "legacy license flag warns" err.txt '"flag":"licfile"'
+expect "current license append" 0 "$USG" --type license --sigfile legacy.bin --license-file license.bin --out current+license.bin
+expect "read-sig with license" 0 "$USG" -r legacy+license.bin
+contains "license is decoded" out.txt '"license": {'
+
+# Emulated generator versions against fixtures captured from the old binaries,
+# golden/3.2.0 was made with the bin/usersiggen 3.2.0 binary
+# same <name> <file> <golden file>
+same() {
+	if cmp -s "$2" "$3"; then pass "$1"; else fail "$1"; fi
+}
+EMU="--emulate-version 3.2.0 --sigdir emulated"
+expect "emulated 3.2.0 board" 0 "$USG" $EMU $BOARD --serial-uuid $SERIAL --eui 0011223344550001 --out board.bin
+same "emulated 3.2.0 board is golden" board.bin "$GOLDEN/3.2.0/board.bin"
+expect "emulated 3.2.0 board with serial" 0 "$USG" $EMU $BOARD --serial compat-0001 --position 2 --eui 0011223344550002 --out board-serial.bin
+same "emulated 3.2.0 board with serial is golden" board-serial.bin "$GOLDEN/3.2.0/board-serial.bin"
+cp board.bin platform.bin
+expect "emulated 3.2.0 platform" 0 "$USG" $EMU --type platform --name compat-platform --version 2.0.1 \
+	--uuid 00112233-4455-6677-8899-aabbccddeeff --manufacturer 99999999-8888-7777-6666-555555555555 \
+	--serial-uuid $SERIAL --timestamp 1600000000 --out platform.bin
+same "emulated 3.2.0 platform is golden" platform.bin "$GOLDEN/3.2.0/platform.bin"
+expect "emulated 3.2.0 license" 0 "$USG" $EMU --type license --sigfile board.bin --license-file license.bin --timestamp 1600000000 --out emulated+license.bin
+same "emulated 3.2.0 license is golden" emulated+license.bin "$GOLDEN/3.2.0/license.bin"
+expect "emulated 3.2.0 has no compliance" 1 "$USG" $EMU --type compliance --coo EE --out board.bin
+expect "unknown version is not emulated" 2 "$USG" --emulate-version 3.0.1 $BOARD --eui 0011223344550003 --out unknown.bin
+
+exit $FAILED
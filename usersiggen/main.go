@@ -0,0 +1,12 @@
+// Author  Raido Pahtma
+// License MIT
+
+package main
+
+import "os"
+
+import "github.com/thinnect/euisiggen/cli/usersiggen"
+
+func main() {
+	usersiggen.Main("usersiggen", os.Args[1:])
+}